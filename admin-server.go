@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/dgrijalva/jwt-go"
 	router "github.com/gorilla/mux"
 	"github.com/journeymidnight/yig/api"
+	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/iam"
 	"github.com/journeymidnight/yig/log"
+	metacache "github.com/journeymidnight/yig/meta"
+	"github.com/journeymidnight/yig/meta/client/hbaseclient"
 	meta "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/redis"
+	"github.com/journeymidnight/yig/signature"
 	"github.com/journeymidnight/yig/storage"
 	"net"
 	"net/http"
@@ -36,15 +42,69 @@ type objectJson struct {
 }
 
 type cacheJson struct {
-	HitRate float64
+	HitRate           float64
+	NegativeCacheHits int64
+	DataCache         storage.DataCacheStats
+}
+
+type cacheStatsJson struct {
+	MetaCache metacache.MetaCacheStats
+	DataCache storage.DataCacheStats
+	Redis     redis.RedisStats
+}
+
+type metaStatsJson struct {
+	Operations map[string]hbaseclient.OperationStats
 }
 
 type usageJson struct {
 	Usage int64
 }
 
+type rebalanceJson struct {
+	Task storage.RebalanceTask
+}
+
+type statRequestJson struct {
+	Objects []storage.ObjectKey
+}
+
+type statJson struct {
+	Objects []storage.ObjectStat
+}
+
+type rateLimitJson struct {
+	Limits api.RateLimits
+}
+
+type bucketRateLimitJson struct {
+	Limits meta.BucketRateLimit
+}
+
+type maxObjectSizeJson struct {
+	MaxObjectSize int64
+}
+
+type gzipVariantsJson struct {
+	GzipVariants bool
+}
+
+type uploadJson struct {
+	State api.UploadState
+}
+
+type authStatsJson struct {
+	AuthFailures signature.AuthFailureStats
+}
+
 var adminServer *adminServerConfig
 
+// the listening admin server, shut down by stopAdminServer
+var adminHttpServer *http.Server
+
+// in-flight admin requests are waited for at most this long on shutdown
+const ADMIN_SHUTDOWN_TIMEOUT = 10 * time.Second
+
 type handlerFunc func(http.Handler) http.Handler
 
 func getUsage(w http.ResponseWriter, r *http.Request) {
@@ -61,6 +121,18 @@ func getUsage(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// Object, version and upload counts of a bucket besides its usage
+func getBucketStats(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter getBucketStats")
+	stats, err := adminServer.Yig.GetBucketStats(router.Vars(r)["bucket"])
+	if err != nil {
+		api.WriteErrorResponse(w, r, err)
+		return
+	}
+	b, _ := json.Marshal(stats)
+	w.Write(b)
+}
+
 func getBucketInfo(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value("claims").(jwt.MapClaims)
 	bucketName := claims["bucket"].(string)
@@ -120,12 +192,244 @@ func getObjectInfo(w http.ResponseWriter, r *http.Request) {
 func getCacheHitRatio(w http.ResponseWriter, r *http.Request) {
 	helper.Debugln("enter getCacheHitRatio")
 
-	rate := adminServer.Yig.MetaStorage.Cache.GetCacheHitRatio()
-	b, _ := json.Marshal(cacheJson{HitRate: rate})
+	cache := adminServer.Yig.MetaStorage.Cache
+	b, _ := json.Marshal(cacheJson{
+		HitRate:           cache.GetCacheHitRatio(),
+		NegativeCacheHits: cache.GetNegativeCacheHits(),
+		DataCache:         adminServer.Yig.DataCache.GetStats(),
+	})
 	w.Write(b)
 	return
 }
 
+func writeCacheStats(w http.ResponseWriter) {
+	b, _ := json.Marshal(cacheStatsJson{
+		MetaCache: adminServer.Yig.MetaStorage.Cache.GetStats(),
+		DataCache: adminServer.Yig.DataCache.GetStats(),
+		Redis:     redis.Stats(),
+	})
+	w.Write(b)
+}
+
+// Counters and in-memory entries of caches of this instance
+func getCacheStats(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter getCacheStats")
+	writeCacheStats(w)
+}
+
+// Drop a metadata table from Redis and in-memory cache of all instances, to
+// recover from stale cache without restarting them
+func flushCache(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter flushCache")
+	table, err := redis.TableFromName(router.Vars(r)["table"])
+	if err != nil || table == redis.FileTable {
+		api.WriteErrorResponse(w, r, ErrInvalidCacheTable)
+		return
+	}
+	if err = adminServer.Yig.MetaStorage.Cache.Flush(table); err != nil {
+		api.WriteErrorResponse(w, r, err)
+		return
+	}
+	writeCacheStats(w)
+}
+
+// Drop cached credential of an access key, so changes in IAM take effect at
+// once on this instance
+func flushIamCache(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter flushIamCache")
+	iam.FlushCredential(router.Vars(r)["accessKey"])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func rebalance(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter rebalance")
+	var task storage.RebalanceTask
+	err := json.NewDecoder(r.Body).Decode(&task)
+	if err != nil {
+		api.WriteErrorResponse(w, r, ErrInvalidRebalanceRequest)
+		return
+	}
+
+	err = adminServer.Yig.Rebalance(task)
+	if err != nil {
+		api.WriteErrorResponse(w, r, err)
+		return
+	}
+	b, _ := json.Marshal(rebalanceJson{Task: task})
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(b)
+	return
+}
+
+// Encrypt data keys of SSE-S3 objects again with current master key, after
+// the master key is changed in config
+func rotateSseKey(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter rotateSseKey")
+	err := adminServer.Yig.RotateSseKey()
+	if err != nil {
+		api.WriteErrorResponse(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Stat objects on behalf of user `uid` in claims, objects to stat are
+// listed in request body
+func statObjects(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter statObjects")
+	claims := r.Context().Value("claims").(jwt.MapClaims)
+	uid, _ := claims["uid"].(string)
+
+	var request statRequestJson
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || len(request.Objects) > storage.MAX_STAT_OBJECTS {
+		api.WriteErrorResponse(w, r, ErrInvalidStatRequest)
+		return
+	}
+
+	stats := adminServer.Yig.StatObjects(r.Context(), request.Objects, iam.Credential{UserId: uid})
+	b, _ := json.Marshal(statJson{Objects: stats})
+	w.Write(b)
+	return
+}
+
+func getRateLimit(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter getRateLimit")
+	b, _ := json.Marshal(rateLimitJson{Limits: api.GetRateLimits()})
+	w.Write(b)
+}
+
+// Adjust rate limits without restarting, limits are reset to those in
+// config file on restart
+func putRateLimit(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter putRateLimit")
+	var limits api.RateLimits
+	err := json.NewDecoder(r.Body).Decode(&limits)
+	if err != nil {
+		api.WriteErrorResponse(w, r, ErrInvalidRateLimitRequest)
+		return
+	}
+	err = api.SetRateLimits(limits)
+	if err != nil {
+		api.WriteErrorResponse(w, r, err)
+		return
+	}
+	b, _ := json.Marshal(rateLimitJson{Limits: limits})
+	w.Write(b)
+}
+
+// Adjust request rate and bandwidth limits of bucket in claims, limits
+// are saved with bucket
+func putBucketRateLimit(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter putBucketRateLimit")
+	claims := r.Context().Value("claims").(jwt.MapClaims)
+	bucketName, _ := claims["bucket"].(string)
+
+	var limits meta.BucketRateLimit
+	err := json.NewDecoder(r.Body).Decode(&limits)
+	if err != nil {
+		api.WriteErrorResponse(w, r, ErrInvalidRateLimitRequest)
+		return
+	}
+	err = adminServer.Yig.SetBucketRateLimit(bucketName, limits)
+	if err != nil {
+		api.WriteErrorResponse(w, r, err)
+		return
+	}
+	b, _ := json.Marshal(bucketRateLimitJson{Limits: limits})
+	w.Write(b)
+}
+
+// Set max object size in bytes of bucket in claims, 0 means no limit
+func putBucketMaxObjectSize(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter putBucketMaxObjectSize")
+	claims := r.Context().Value("claims").(jwt.MapClaims)
+	bucketName, _ := claims["bucket"].(string)
+
+	var request maxObjectSizeJson
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		api.WriteErrorResponse(w, r, ErrInvalidMaxObjectSize)
+		return
+	}
+	err = adminServer.Yig.SetBucketMaxObjectSize(bucketName, request.MaxObjectSize)
+	if err != nil {
+		api.WriteErrorResponse(w, r, err)
+		return
+	}
+	b, _ := json.Marshal(request)
+	w.Write(b)
+}
+
+// Enable or disable serving gzip variants of objects for bucket in claims
+func putBucketGzipVariants(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter putBucketGzipVariants")
+	claims := r.Context().Value("claims").(jwt.MapClaims)
+	bucketName, _ := claims["bucket"].(string)
+
+	var request gzipVariantsJson
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		api.WriteErrorResponse(w, r, ErrInvalidRequestBody)
+		return
+	}
+	err = adminServer.Yig.SetBucketGzipVariants(bucketName, request.GzipVariants)
+	if err != nil {
+		api.WriteErrorResponse(w, r, err)
+		return
+	}
+	b, _ := json.Marshal(request)
+	w.Write(b)
+}
+
+// Counters of metadata backend operations, only HBase keeps them for now
+func getMetaStats(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter getMetaStats")
+	var stats metaStatsJson
+	if client, ok := adminServer.Yig.MetaStorage.Client.(*hbaseclient.HbaseClient); ok {
+		stats.Operations = client.Stats()
+	}
+	b, _ := json.Marshal(stats)
+	w.Write(b)
+}
+
+// Signature failures tracked to slow down guessing of keys
+func getAuthStats(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter getAuthStats")
+	b, _ := json.Marshal(authStatsJson{AuthFailures: signature.GetAuthFailureStats()})
+	w.Write(b)
+}
+
+// Bandwidth shaping state of uploads
+func getUploadState(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter getUploadState")
+	b, _ := json.Marshal(uploadJson{State: api.GetUploadState()})
+	w.Write(b)
+}
+
+// Liveness probe, process is up as long as this responds
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+// Readiness probe, responds 503 with failing components if any dependency
+// is unreachable
+func getReadiness(w http.ResponseWriter, r *http.Request) {
+	status := adminServer.Yig.CheckHealth()
+	b, _ := json.Marshal(status)
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
+}
+
+func getStatus(w http.ResponseWriter, r *http.Request) {
+	helper.Debugln("enter getStatus")
+	status := adminServer.Yig.CheckHealth()
+	b, _ := json.Marshal(status)
+	w.Write(b)
+}
+
 var handlerFns = []handlerFunc{
 //	SetJwtMiddlewareHandler,
 }
@@ -144,10 +448,29 @@ func configureAdminHandler() http.Handler {
 	apiRouter := mux.NewRoute().PathPrefix("/").Subrouter()
 	admin := apiRouter.PathPrefix("/admin").Subrouter()
 	admin.Methods("GET").Path("/usage").HandlerFunc(SetJwtMiddlewareFunc(getUsage))
+	admin.Methods("GET").Path("/usage/{bucket}").HandlerFunc(SetJwtMiddlewareFunc(getBucketStats))
 	admin.Methods("GET").Path("/user").HandlerFunc(SetJwtMiddlewareFunc(getUserInfo))
 	admin.Methods("GET").Path("/bucket").HandlerFunc(SetJwtMiddlewareFunc(getBucketInfo))
 	admin.Methods("GET").Path("/object").HandlerFunc(SetJwtMiddlewareFunc(getObjectInfo))
 	admin.Methods("GET").Path("/cachehit").HandlerFunc(SetJwtMiddlewareFunc(getCacheHitRatio))
+	admin.Methods("GET").Path("/cache/stats").HandlerFunc(SetJwtMiddlewareFunc(getCacheStats))
+	admin.Methods("POST").Path("/cache/flush/{table}").HandlerFunc(SetJwtMiddlewareFunc(flushCache))
+	admin.Methods("POST").Path("/iam/flush/{accessKey}").HandlerFunc(SetJwtMiddlewareFunc(flushIamCache))
+	admin.Methods("GET").Path("/meta/stats").HandlerFunc(SetJwtMiddlewareFunc(getMetaStats))
+	admin.Methods("GET").Path("/auth/stats").HandlerFunc(SetJwtMiddlewareFunc(getAuthStats))
+	admin.Methods("POST").Path("/rebalance").HandlerFunc(SetJwtMiddlewareFunc(rebalance))
+	admin.Methods("POST").Path("/rotate-sse-key").HandlerFunc(SetJwtMiddlewareFunc(rotateSseKey))
+	admin.Methods("POST").Path("/stat").HandlerFunc(SetJwtMiddlewareFunc(statObjects))
+	admin.Methods("GET").Path("/status").HandlerFunc(SetJwtMiddlewareFunc(getStatus))
+	admin.Methods("GET").Path("/ratelimit").HandlerFunc(SetJwtMiddlewareFunc(getRateLimit))
+	admin.Methods("PUT").Path("/ratelimit").HandlerFunc(SetJwtMiddlewareFunc(putRateLimit))
+	admin.Methods("PUT").Path("/bucket/ratelimit").HandlerFunc(SetJwtMiddlewareFunc(putBucketRateLimit))
+	admin.Methods("PUT").Path("/bucket/maxobjectsize").HandlerFunc(SetJwtMiddlewareFunc(putBucketMaxObjectSize))
+	admin.Methods("PUT").Path("/bucket/gzip").HandlerFunc(SetJwtMiddlewareFunc(putBucketGzipVariants))
+	admin.Methods("GET").Path("/upload").HandlerFunc(SetJwtMiddlewareFunc(getUploadState))
+
+	apiRouter.Methods("GET").Path("/healthz").HandlerFunc(getHealth)
+	apiRouter.Methods("GET").Path("/readyz").HandlerFunc(getReadiness)
 
 	apiRouter.Path("/debug/cmdline").HandlerFunc(pprof.Cmdline)
 	apiRouter.Path("/debug/profile").HandlerFunc(pprof.Profile)
@@ -171,7 +494,7 @@ func startAdminServer(c *adminServerConfig) {
 	// Check if requested port is available.
 	checkPortAvailability(getPort(net.JoinHostPort(host, port)))
 
-	adminServer := &http.Server{
+	adminHttpServer = &http.Server{
 		Addr: c.Address,
 		// Adding timeout of 10 minutes for unresponsive client connections.
 		ReadTimeout:    10 * time.Minute,
@@ -180,7 +503,7 @@ func startAdminServer(c *adminServerConfig) {
 		MaxHeaderBytes: 1 << 20,
 	}
 
-	hosts, port := getListenIPs(adminServer) // get listen ips and port.
+	hosts, port := getListenIPs(adminHttpServer) // get listen ips and port.
 
 	logger.Println(5, "\nS3 Object Storage:")
 	// Print api listen ips.
@@ -189,11 +512,24 @@ func startAdminServer(c *adminServerConfig) {
 	go func() {
 		var err error
 		// Configure TLS if certs are available.
-		err = adminServer.ListenAndServe()
+		err = adminHttpServer.ListenAndServe()
+		if err == http.ErrServerClosed {
+			return
+		}
 		helper.FatalIf(err, "API server error.")
 	}()
 }
 
 func stopAdminServer() {
-	// TODO should shutdown admin API server gracefully
+	if adminHttpServer == nil {
+		return
+	}
+	helper.Logger.Print(5, "Stopping admin server...")
+	ctx, cancel := context.WithTimeout(context.Background(), ADMIN_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := adminHttpServer.Shutdown(ctx); err != nil {
+		helper.Logger.Println(5, "failed:", err)
+		return
+	}
+	helper.Logger.Println(5, "done")
 }
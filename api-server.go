@@ -17,6 +17,8 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	router "github.com/gorilla/mux"
 	"github.com/journeymidnight/yig/api"
@@ -28,6 +30,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -57,8 +60,6 @@ func configureServerHandler(c *ServerConfig) http.Handler {
 	// List of some generic handlers which are applied for all
 	// incoming requests.
 	var handlerFns = []api.HandlerFunc{
-		// Limits the number of concurrent http requests.
-		api.SetCommonHeaderHandler,
 		// CORS setting for all browser API requests.
 		api.SetCorsHandler,
 		// Validates all incoming URL resources, for invalid/unsupported
@@ -68,8 +69,22 @@ func configureServerHandler(c *ServerConfig) http.Handler {
 		// routes them accordingly. Client receives a HTTP error for
 		// invalid/unsupported signatures.
 		api.SetAuthHandler,
+		// Limits request rate of each access key and bucket, client
+		// receives 503 SlowDown if exceeded.
+		api.SetThrottleHandler,
+		// Gzips XML responses, including error responses of handlers
+		// above, unless DisableResponseCompression is set.
+		api.SetCompressionHandler,
 		// Add new handlers here.
 
+		// Sets headers common to all responses, including request id,
+		// placed outside of handlers above so their error responses
+		// have them too.
+		api.SetCommonHeaderHandler,
+
+		// Recovers panics of all handlers above, responses with
+		// InternalError and logs stack trace to panic log.
+		api.SetPanicHandler,
 		api.SetLogHandler,
 	}
 
@@ -90,6 +105,13 @@ func configureServer(c *ServerConfig) *api.Server {
 		},
 	}
 	apiServer.Server.SetKeepAlivesEnabled(helper.CONFIG.KeepAlive)
+	if isSSL(c) {
+		err := loadTLSCertificate()
+		helper.FatalIf(err, "Unable to load TLS certificate.")
+		apiServer.Server.TLSConfig = &tls.Config{
+			GetCertificate: getCertificate,
+		}
+	}
 
 	// Returns configured HTTP server.
 	return apiServer
@@ -214,6 +236,47 @@ func isSSL(c *ServerConfig) bool {
 	return false
 }
 
+// Current TLS certificate, of type *tls.Certificate. Certificate is loaded
+// at startup and on SIGHUP, so renewed certificates take effect without
+// restarting.
+var tlsCertificate atomic.Value
+
+func loadTLSCertificate() error {
+	cert, err := tls.LoadX509KeyPair(helper.CONFIG.SSLCertPath, helper.CONFIG.SSLKeyPath)
+	if err != nil {
+		return err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	tlsCertificate.Store(&cert)
+	return nil
+}
+
+// Keep using the old certificate if the new one fails to load
+func reloadTLSCertificate() {
+	if tlsCertificate.Load() == nil {
+		// TLS is not enabled at startup
+		return
+	}
+	err := loadTLSCertificate()
+	if err != nil {
+		logger.Println(5, "Failed to reload TLS certificate:", err)
+		return
+	}
+	cert := tlsCertificate.Load().(*tls.Certificate)
+	logger.Println(5, "TLS certificate reloaded, expires at", cert.Leaf.NotAfter)
+}
+
+func getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, ok := tlsCertificate.Load().(*tls.Certificate)
+	if !ok {
+		return nil, errors.New("No TLS certificate loaded")
+	}
+	return cert, nil
+}
+
 var ApiServer *api.Server
 
 // blocks after server started
@@ -247,7 +310,8 @@ func startApiServer(c *ServerConfig) {
 		var err error
 		// Configure TLS if certs are available.
 		if isSSL(c) {
-			err = apiServer.Server.ListenAndServeTLS(c.CertFilePath, c.KeyFilePath)
+			// certificate is provided by TLSConfig.GetCertificate
+			err = apiServer.Server.ListenAndServeTLS("", "")
 		} else {
 			// Fallback to http.
 			err = apiServer.Server.ListenAndServe()
@@ -1,15 +1,92 @@
 package api
 
 import (
-	. "github.com/journeymidnight/yig/api/datatype"
 	"net/http"
+	"strings"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
 )
 
-func getAclFromHeader(h http.Header) (acl Acl, err error) {
+// Maximum size of <AccessControlPolicy> in PUT Object ACL requests
+const MAX_ACL_BODY_SIZE = 64 << 10
+
+// x-amz-grant-* headers, in the order grants are listed
+var grantHeaders = []struct {
+	header     string
+	permission string
+}{
+	{"X-Amz-Grant-Full-Control", ACL_PERM_FULL_CONTROL},
+	{"X-Amz-Grant-Read", ACL_PERM_READ},
+	{"X-Amz-Grant-Write", ACL_PERM_WRITE},
+	{"X-Amz-Grant-Read-Acp", ACL_PERM_READ_ACP},
+	{"X-Amz-Grant-Write-Acp", ACL_PERM_WRITE_ACP},
+}
+
+func getCannedAclFromHeader(h http.Header) (acl Acl, err error) {
 	acl.CannedAcl = h.Get("x-amz-acl")
 	if acl.CannedAcl == "" {
 		acl.CannedAcl = "private"
 	}
 	err = IsValidCannedAcl(acl)
 	return
-}
\ No newline at end of file
+}
+
+func hasGrantHeaders(h http.Header) bool {
+	for _, g := range grantHeaders {
+		if _, ok := h[g.header]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL of buckets and objects could be either canned from x-amz-acl, or
+// explicit grants from x-amz-grant-* headers, but not both
+func getAclFromHeader(h http.Header) (acl Acl, err error) {
+	if !hasGrantHeaders(h) {
+		return getCannedAclFromHeader(h)
+	}
+	if _, ok := h["X-Amz-Acl"]; ok {
+		return acl, ErrInvalidAcl
+	}
+	for _, g := range grantHeaders {
+		for _, value := range h[g.header] {
+			grants, err := parseGrantees(value, g.permission)
+			if err != nil {
+				return acl, err
+			}
+			acl.Grants = append(acl.Grants, grants...)
+		}
+	}
+	err = IsValidGrants(acl.Grants)
+	return
+}
+
+// Grantees are listed as `id="canonical-user-id", uri="group-uri"`,
+// grantees by email address are not supported
+func parseGrantees(value string, permission string) (grants []Grant, err error) {
+	for _, grantee := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(grantee), "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrInvalidAcl
+		}
+		id := strings.Trim(strings.TrimSpace(kv[1]), "\"")
+		grant := Grant{Permission: permission}
+		grant.Grantee.XmlnsXsi = XMLNSXSI
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "id":
+			grant.Grantee.XsiType = ACL_TYPE_CANON_USER
+			grant.Grantee.ID = id
+		case "uri":
+			grant.Grantee.XsiType = ACL_TYPE_GROUP
+			grant.Grantee.URI = id
+		case "emailaddress":
+			return nil, ErrUnsupportedAcl
+		default:
+			return nil, ErrMalformedACLError
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
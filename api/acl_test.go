@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+)
+
+func TestGetAclFromHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-Amz-Grant-Read", `id="alice", uri="`+ACL_GROUP_TYPE_AUTHENTICATED_USERS+`"`)
+	h.Set("X-Amz-Grant-Write-Acp", `id="bob"`)
+	acl, err := getAclFromHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl.CannedAcl != "" || len(acl.Grants) != 3 {
+		t.Fatalf("expected 3 grants, got %+v", acl)
+	}
+	if !GrantsAllow(acl.Grants, "alice", ACL_PERM_READ) ||
+		!GrantsAllow(acl.Grants, "carol", ACL_PERM_READ) ||
+		GrantsAllow(acl.Grants, "", ACL_PERM_READ) ||
+		!GrantsAllow(acl.Grants, "bob", ACL_PERM_WRITE_ACP) ||
+		GrantsAllow(acl.Grants, "bob", ACL_PERM_WRITE) {
+
+		t.Errorf("grants parsed wrong: %+v", acl.Grants)
+	}
+
+	acl, err = getAclFromHeader(http.Header{"X-Amz-Acl": {"public-read"}})
+	if err != nil || acl.CannedAcl != "public-read" || len(acl.Grants) != 0 {
+		t.Errorf("canned ACL expected, got %+v, %v", acl, err)
+	}
+
+	invalid := []http.Header{
+		{"X-Amz-Acl": {"private"}, "X-Amz-Grant-Read": {`id="alice"`}},
+		{"X-Amz-Grant-Read": {`emailAddress="alice@example.com"`}},
+		{"X-Amz-Grant-Read": {`uri="http://example.com/hehe"`}},
+		{"X-Amz-Grant-Read": {`alice`}},
+	}
+	for _, h := range invalid {
+		if _, err := getAclFromHeader(h); err != ErrInvalidAcl && err != ErrUnsupportedAcl {
+			t.Errorf("%v should be rejected, got %v", h, err)
+		}
+	}
+
+	var grantees []string
+	for i := 0; i <= MAX_ACL_GRANTS; i++ {
+		grantees = append(grantees, `id="user`+strconv.Itoa(i)+`"`)
+	}
+	malformed := []http.Header{
+		{"X-Amz-Grant-Read": {`hehe="alice"`}},
+		{"X-Amz-Grant-Read": {strings.Join(grantees, ",")}},
+	}
+	for _, h := range malformed {
+		if _, err := getAclFromHeader(h); err != ErrMalformedACLError {
+			t.Errorf("%.64v should be malformed, got %v", h, err)
+		}
+	}
+}
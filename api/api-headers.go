@@ -21,6 +21,8 @@ import (
 	"encoding/xml"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	. "github.com/journeymidnight/yig/api/datatype"
 	meta "github.com/journeymidnight/yig/meta/types"
@@ -35,6 +37,16 @@ func EncodeResponse(response interface{}) []byte {
 	return bytesBuffer.Bytes()
 }
 
+// Checksum of the whole object, only returned when asked for with
+// "x-amz-checksum-mode: ENABLED", as S3 does
+func setChecksumHeader(w http.ResponseWriter, r *http.Request, object *meta.Object) {
+	if object.ChecksumAlgorithm == "" ||
+		!strings.EqualFold(r.Header.Get("X-Amz-Checksum-Mode"), "ENABLED") {
+		return
+	}
+	w.Header().Set("X-Amz-Checksum-"+object.ChecksumAlgorithm, object.Checksum)
+}
+
 // Write object header
 func SetObjectHeaders(w http.ResponseWriter, object *meta.Object, contentRange *HttpRange) {
 	// set object-related metadata headers
@@ -59,12 +71,18 @@ func SetObjectHeaders(w http.ResponseWriter, object *meta.Object, contentRange *
 	}
 
 	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	// omitted for STANDARD objects, as S3 does
+	if object.StorageClass != "" && object.StorageClass != STORAGE_CLASS_STANDARD {
+		w.Header().Set("X-Amz-Storage-Class", object.StorageClass)
+	}
+	if status := RestoreStatus(object.RestoreExpiryDate, time.Now()); status != "" {
+		w.Header().Set("X-Amz-Restore", status)
+	}
 
 	// for providing ranged content
 	if contentRange != nil && contentRange.OffsetBegin > -1 {
 		// Override content-length
 		w.Header().Set("Content-Length", strconv.FormatInt(contentRange.GetLength(), 10))
 		w.Header().Set("Content-Range", contentRange.String())
-		w.WriteHeader(http.StatusPartialContent)
 	}
 }
@@ -39,6 +39,8 @@ func parseListObjectsQuery(query url.Values) (request ListObjectsRequest, err er
 			true, false).(bool)
 	} else {
 		request.Version = 1
+		// V1 and versions listing always have owners
+		request.FetchOwner = true
 		request.Marker = query.Get("marker")
 		if !utf8.ValidString(request.Marker) {
 			err = ErrNonUTF8Encode
@@ -87,24 +89,58 @@ func parseListObjectsQuery(query url.Values) (request ListObjectsRequest, err er
 	return
 }
 
+// Parse service url queries, buckets are not paged without max-buckets
+func parseListBucketsQuery(query url.Values) (request ListBucketsRequest, err error) {
+	if query.Get("max-buckets") != "" {
+		request.MaxBuckets, err = strconv.Atoi(query.Get("max-buckets"))
+		if err != nil || request.MaxBuckets > MaxBucketsList || request.MaxBuckets < 1 {
+			err = ErrInvalidMaxBuckets
+			return
+		}
+	}
+	request.ContinuationToken = query.Get("continuation-token")
+	return
+}
+
 // Parse bucket url queries for ?uploads
 func parseListUploadsQuery(query url.Values) (request ListUploadsRequest, err error) {
 	request.Delimiter = query.Get("delimiter")
+	if !utf8.ValidString(request.Delimiter) {
+		err = ErrNonUTF8Encode
+		return
+	}
 	request.EncodingType = query.Get("encoding-type")
+	if request.EncodingType != "" && request.EncodingType != "url" {
+		err = ErrInvalidEncodingType
+		return
+	}
+	// like AWS, values above the limit are clamped rather than rejected
 	if query.Get("max-uploads") == "" {
 		request.MaxUploads = MaxUploadsList
 	} else {
 		request.MaxUploads, err = strconv.Atoi(query.Get("max-uploads"))
 		if err != nil {
-			return
+			helper.Debugln("Error parsing max-uploads:", err)
+			return request, ErrInvalidMaxUploads
 		}
-		if request.MaxUploads > MaxUploadsList || request.MaxUploads < 1 {
+		if request.MaxUploads < 1 {
 			err = ErrInvalidMaxUploads
 			return
 		}
+		if request.MaxUploads > MaxUploadsList {
+			request.MaxUploads = MaxUploadsList
+		}
 	}
 	request.KeyMarker = query.Get("key-marker")
+	if !utf8.ValidString(request.KeyMarker) {
+		err = ErrNonUTF8Encode
+		return
+	}
 	request.Prefix = query.Get("prefix")
+	if !utf8.ValidString(request.Prefix) {
+		err = ErrNonUTF8Encode
+		return
+	}
 	request.UploadIdMarker = query.Get("upload-id-marker")
 	return
 }
@@ -21,13 +21,9 @@ import (
 	"net/http"
 )
 
-const (
-	MIN_PART_SIZE = 128 << 10 // 128KB
-)
-
 // writeErrorResponsePartTooSmall - function is used specifically to
 // construct a proper error response during CompleteMultipartUpload
-// when one of the parts is < MIN_PART_SIZE
+// when one of the parts is < meta.MIN_PART_SIZE
 // The requirement comes due to the fact that generic ErrorResponse
 // XML doesn't carry the additional fields required to send this
 // error. So we construct a new type which lies well within the scope
@@ -50,7 +46,7 @@ func writePartSmallErrorResponse(w http.ResponseWriter, r *http.Request, err met
 	// Generate complete multipart error response.
 	cmpErrResp := completeMultipartAPIError{
 		ProposedSize:   err.PartSize,
-		MinSizeAllowed: MIN_PART_SIZE,
+		MinSizeAllowed: meta.MIN_PART_SIZE,
 		PartNumber:     err.PartNumber,
 		PartETag:       err.PartETag,
 		ApiErrorResponse: ApiErrorResponse{
@@ -32,6 +32,10 @@ import (
 
 const (
 	timeFormatAMZ = "2006-01-02T15:04:05.000Z" // Reply date format
+
+	// seconds for clients to wait before retrying, when metadata
+	// storage is unreachable
+	serviceUnavailableRetryAfter = "5"
 )
 
 // DeleteObjectsResponse container for multiple object deletes.
@@ -233,6 +237,9 @@ func WriteErrorResponseHeaders(w http.ResponseWriter, err error) {
 	} else {
 		status = http.StatusInternalServerError
 	}
+	if err == ErrServiceUnavailable && w.Header().Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", serviceUnavailableRetryAfter)
+	}
 	helper.Logger.Println(5, "Response status code:", status)
 	w.WriteHeader(status)
 }
@@ -253,6 +260,10 @@ func WriteErrorResponseNoHeader(w http.ResponseWriter, req *http.Request, err er
 		errorResponse.AwsErrorCode = "InternalError"
 		errorResponse.Message = "We encountered an internal error, please try again."
 	}
+	if mismatch, ok := err.(SignatureMismatch); ok {
+		errorResponse.StringToSign = mismatch.StringToSign
+		errorResponse.SignatureProvided = mismatch.SignatureProvided
+	}
 	errorResponse.Resource = resource
 	errorResponse.RequestId = requestIdFromContext(req.Context())
 	errorResponse.HostId = helper.CONFIG.InstanceId
@@ -272,4 +283,7 @@ type ApiErrorResponse struct {
 	Resource     string
 	RequestId    string
 	HostId       string
+	// only for SignatureDoesNotMatch
+	StringToSign      string `xml:",omitempty"`
+	SignatureProvided string `xml:",omitempty"`
 }
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+func TestServiceUnavailableResponse(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+
+	recorder := httptest.NewRecorder()
+	WriteErrorResponse(recorder, signedV2Request("alice", "bucket"), ErrServiceUnavailable)
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Retry-After") != serviceUnavailableRetryAfter {
+		t.Errorf("unexpected Retry-After: %s", recorder.Header().Get("Retry-After"))
+	}
+
+	recorder = httptest.NewRecorder()
+	WriteErrorResponse(recorder, signedV2Request("alice", "bucket"), ErrNoSuchKey)
+	if recorder.Code != http.StatusNotFound || recorder.Header().Get("Retry-After") != "" {
+		t.Errorf("logical errors should not be retryable, got %d", recorder.Code)
+	}
+}
+
+func TestSignatureMismatchResponse(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+
+	recorder := httptest.NewRecorder()
+	WriteErrorResponse(recorder, signedV2Request("alice", "bucket"),
+		SignatureMismatch{StringToSign: "GET\n\n\n", SignatureProvided: "hehe"})
+	var response ApiErrorResponse
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusForbidden || response.AwsErrorCode != "SignatureDoesNotMatch" ||
+		response.StringToSign != "GET\n\n\n" || response.SignatureProvided != "hehe" {
+		t.Errorf("unexpected response %d %+v", recorder.Code, response)
+	}
+}
+
+// Owners are always listed by V1, and by V2 only if fetch-owner is set
+func TestListObjectsOwner(t *testing.T) {
+	cases := []struct {
+		query string
+		owner bool
+	}{
+		{"", true},
+		{"list-type=2", false},
+		{"list-type=2&fetch-owner=false", false},
+		{"list-type=2&fetch-owner=true", true},
+	}
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		request, err := parseListObjectsQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request.FetchOwner != c.owner {
+			t.Errorf("%q: expected fetch owner %v, got %v", c.query, c.owner, request.FetchOwner)
+		}
+		object := Object{Key: "hehe"}
+		if request.FetchOwner {
+			object.Owner = &Owner{ID: "alice", DisplayName: "alice"}
+		}
+		response := GenerateListObjectsResponse("bucket", request,
+			meta.ListObjectsInfo{Objects: []Object{object}})
+		encoded := string(EncodeResponse(response))
+		if strings.Contains(encoded, "<Owner>") != c.owner {
+			t.Errorf("%q: unexpected response %s", c.query, encoded)
+		}
+	}
+}
+
+func TestListUploadsQuery(t *testing.T) {
+	cases := []struct {
+		query      string
+		maxUploads int
+		err        error
+	}{
+		{"", MaxUploadsList, nil},
+		{"max-uploads=1", 1, nil},
+		{"max-uploads=1000", 1000, nil},
+		{"max-uploads=1001", MaxUploadsList, nil},
+		{"max-uploads=0", 0, ErrInvalidMaxUploads},
+		{"max-uploads=-1", 0, ErrInvalidMaxUploads},
+		{"max-uploads=hehe", 0, ErrInvalidMaxUploads},
+		{"encoding-type=hehe", 0, ErrInvalidEncodingType},
+		{"prefix=%ff", 0, ErrNonUTF8Encode},
+	}
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		request, err := parseListUploadsQuery(query)
+		if err != c.err {
+			t.Errorf("%q: expected error %v, got %v", c.query, c.err, err)
+			continue
+		}
+		if err == nil && request.MaxUploads != c.maxUploads {
+			t.Errorf("%q: expected max uploads %d, got %d", c.query, c.maxUploads,
+				request.MaxUploads)
+		}
+	}
+}
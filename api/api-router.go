@@ -28,180 +28,147 @@ type ObjectAPIHandlers struct {
 
 // registerAPIRouter - registers S3 compatible APIs.
 func RegisterAPIRouter(mux *router.Router, api ObjectAPIHandlers) {
+	// Object names are matched against the decoded path as is, keys like
+	// "a//b" or "dir/../b" are valid and should not be cleaned or redirected
+	mux.SkipClean(true)
+
 	// API Router
 	apiRouter := mux.NewRoute().PathPrefix("/").Subrouter()
 
-	// Bucket router, matches domain.name/bucket_name/object_name
-	bucket := apiRouter.Host(helper.CONFIG.S3Domain).PathPrefix("/{bucket}").Subrouter()
-
-	// Host router, matches bucket_name.domain.name/object_name
-	bucket_host := apiRouter.Host("{bucket:.+}." + helper.CONFIG.S3Domain).Subrouter()
-
-	// HeadObject
-	bucket_host.Methods("HEAD").Path("/{object:.+}").HandlerFunc(api.HeadObjectHandler)
-	// PutObjectPart - Copy
-	bucket_host.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.CopyObjectPartHandler).
-		Queries("partNumber", "{partNumber:[0-9]+}", "uploadId", "{uploadId:.*}").
-		HeadersRegexp("X-Amz-Copy-Source", ".*?(/).*?")
-	// PutObjectPart
-	bucket_host.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectPartHandler).
-		Queries("partNumber", "{partNumber:[0-9]+}", "uploadId", "{uploadId:.*}")
-	// ListObjectParts
-	bucket_host.Methods("GET").Path("/{object:.+}").HandlerFunc(api.ListObjectPartsHandler).
-		Queries("uploadId", "{uploadId:.*}")
-	// CompleteMultipartUpload
-	bucket_host.Methods("POST").Path("/{object:.+}").HandlerFunc(api.CompleteMultipartUploadHandler).
-		Queries("uploadId", "{uploadId:.*}")
-	// NewMultipartUpload
-	bucket_host.Methods("POST").Path("/{object:.+}").HandlerFunc(api.NewMultipartUploadHandler).
-		Queries("uploads", "")
-	// AbortMultipartUpload
-	bucket_host.Methods("DELETE").Path("/{object:.+}").HandlerFunc(api.AbortMultipartUploadHandler).
-		Queries("uploadId", "{uploadId:.*}")
-	// CopyObject
-	bucket_host.Methods("PUT").Path("/{object:.+}").HeadersRegexp("X-Amz-Copy-Source", ".*?(/).*?").
-		HandlerFunc(api.CopyObjectHandler)
-	// PutObjectACL
-	bucket_host.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectAclHandler).
-		Queries("acl", "")
-	// GetObjectAcl
-	bucket_host.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectAclHandler).
-		Queries("acl", "")
-	// PutObject
-	bucket_host.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectHandler)
-	// GetObject
-	bucket_host.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectHandler)
-	// DeleteObject
-	bucket_host.Methods("DELETE").Path("/{object:.+}").HandlerFunc(api.DeleteObjectHandler)
-
-	/// Bucket operations
-
-	// GetBucketLocation
-	bucket_host.Methods("GET").HandlerFunc(api.GetBucketLocationHandler).Queries("location", "")
-	// ListMultipartUploads
-	bucket_host.Methods("GET").HandlerFunc(api.ListMultipartUploadsHandler).Queries("uploads", "")
-	// Get bucket versioning status
-	bucket_host.Methods("GET").HandlerFunc(api.GetBucketVersioningHandler).Queries("versioning", "")
-	// List versioned objects in a bucket
-	bucket_host.Methods("GET").HandlerFunc(api.ListVersionedObjectsHandler).Queries("versions", "")
-	// PutBucketACL
-	bucket_host.Methods("PUT").HandlerFunc(api.PutBucketAclHandler).Queries("acl", "")
-	// GetBucketACL
-	bucket_host.Methods("GET").HandlerFunc(api.GetBucketAclHandler).Queries("acl", "")
-	// PutBucketVersioning
-	bucket_host.Methods("PUT").HandlerFunc(api.PutBucketVersioningHandler).Queries("versioning", "")
-	// PutBucketCORS
-	bucket_host.Methods("PUT").HandlerFunc(api.PutBucketCorsHandler).Queries("cors", "")
-	// GetBucketCORS
-	bucket_host.Methods("GET").HandlerFunc(api.GetBucketCorsHandler).Queries("cors", "")
-	// GetBucketPolicy
-	bucket_host.Methods("GET").HandlerFunc(api.GetBucketPolicyHandler).Queries("policy", "")
-	// DeleteBucketCORS
-	bucket_host.Methods("DELETE").HandlerFunc(api.DeleteBucketCorsHandler).Queries("cors", "")
-	// PutLifeCycleConfig
-	bucket_host.Methods("PUT").HandlerFunc(api.PutBucketLifeCycleHandler).Queries("lifecycle", "")
-	// GetLifeCycleConfig
-	bucket_host.Methods("GET").HandlerFunc(api.GetBucketLifeCycleHandler).Queries("lifecycle", "")
-	// DelLifeCycleConfig
-	bucket_host.Methods("DELETE").HandlerFunc(api.DelBucketLifeCycleHandler).Queries("lifecycle", "")
-	// HeadBucket
-	bucket_host.Methods("HEAD").HandlerFunc(api.HeadBucketHandler)
-	// PostPolicy
-	bucket_host.Methods("POST").HeadersRegexp("Content-Type", "multipart/form-data*").
-		HandlerFunc(api.PostPolicyBucketHandler)
-	// DeleteMultipleObjects
-	bucket_host.Methods("POST").HandlerFunc(api.DeleteMultipleObjectsHandler)
-	// DeleteBucket
-	bucket_host.Methods("DELETE").HandlerFunc(api.DeleteBucketHandler)
-	// PutBucket
-	bucket_host.Methods("PUT").HandlerFunc(api.PutBucketHandler)
-	// ListObjects
-	bucket_host.Methods("GET").HandlerFunc(api.ListObjectsHandler)
-
-
-	/// Object operations
-
-	// HeadObject
-	bucket.Methods("HEAD").Path("/{object:.+}").HandlerFunc(api.HeadObjectHandler)
-	// PutObjectPart - Copy
-	bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.CopyObjectPartHandler).
-		Queries("partNumber", "{partNumber:[0-9]+}", "uploadId", "{uploadId:.*}").
-		HeadersRegexp("X-Amz-Copy-Source", ".*?(/).*?")
-	// PutObjectPart
-	bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectPartHandler).
-		Queries("partNumber", "{partNumber:[0-9]+}", "uploadId", "{uploadId:.*}")
-	// ListObjectParts
-	bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.ListObjectPartsHandler).
-		Queries("uploadId", "{uploadId:.*}")
-	// CompleteMultipartUpload
-	bucket.Methods("POST").Path("/{object:.+}").HandlerFunc(api.CompleteMultipartUploadHandler).
-		Queries("uploadId", "{uploadId:.*}")
-	// NewMultipartUpload
-	bucket.Methods("POST").Path("/{object:.+}").HandlerFunc(api.NewMultipartUploadHandler).
-		Queries("uploads", "")
-	// AbortMultipartUpload
-	bucket.Methods("DELETE").Path("/{object:.+}").HandlerFunc(api.AbortMultipartUploadHandler).
-		Queries("uploadId", "{uploadId:.*}")
-	// CopyObject
-	bucket.Methods("PUT").Path("/{object:.+}").HeadersRegexp("X-Amz-Copy-Source", ".*?(/).*?").
-		HandlerFunc(api.CopyObjectHandler)
-	// PutObjectACL
-	bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectAclHandler).
-		Queries("acl", "")
-	// GetObjectAcl
-	bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectAclHandler).
-		Queries("acl", "")
-	// PutObject
-	bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectHandler)
-	// GetObject
-	bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectHandler)
-	// DeleteObject
-	bucket.Methods("DELETE").Path("/{object:.+}").HandlerFunc(api.DeleteObjectHandler)
-
-	/// Bucket operations
-
-	// GetBucketLocation
-	bucket.Methods("GET").HandlerFunc(api.GetBucketLocationHandler).Queries("location", "")
-	// ListMultipartUploads
-	bucket.Methods("GET").HandlerFunc(api.ListMultipartUploadsHandler).Queries("uploads", "")
-	// Get bucket versioning status
-	bucket.Methods("GET").HandlerFunc(api.GetBucketVersioningHandler).Queries("versioning", "")
-	// List versioned objects in a bucket
-	bucket.Methods("GET").HandlerFunc(api.ListVersionedObjectsHandler).Queries("versions", "")
-	// PutBucketACL
-	bucket.Methods("PUT").HandlerFunc(api.PutBucketAclHandler).Queries("acl", "")
-	// GetBucketACL
-	bucket.Methods("GET").HandlerFunc(api.GetBucketAclHandler).Queries("acl", "")
-	// PutBucketVersioning
-	bucket.Methods("PUT").HandlerFunc(api.PutBucketVersioningHandler).Queries("versioning", "")
-	// PutBucketCORS
-	bucket.Methods("PUT").HandlerFunc(api.PutBucketCorsHandler).Queries("cors", "")
-	// GetBucketCORS
-	bucket.Methods("GET").HandlerFunc(api.GetBucketCorsHandler).Queries("cors", "")
-	// GetBucketPolicy
-	bucket.Methods("GET").HandlerFunc(api.GetBucketPolicyHandler).Queries("policy", "")
-	// DeleteBucketCORS
-	bucket.Methods("DELETE").HandlerFunc(api.DeleteBucketCorsHandler).Queries("cors", "")
-	// PutLifeCycleConfig
-	bucket.Methods("PUT").HandlerFunc(api.PutBucketLifeCycleHandler).Queries("lifecycle", "")
-	// GetLifeCycleConfig
-	bucket.Methods("GET").HandlerFunc(api.GetBucketLifeCycleHandler).Queries("lifecycle", "")
-	// DelLifeCycleConfig
-	bucket.Methods("DELETE").HandlerFunc(api.DelBucketLifeCycleHandler).Queries("lifecycle", "")
-	// HeadBucket
-	bucket.Methods("HEAD").HandlerFunc(api.HeadBucketHandler)
-	// PostPolicy
-	bucket.Methods("POST").HeadersRegexp("Content-Type", "multipart/form-data*").
-		HandlerFunc(api.PostPolicyBucketHandler)
-	// DeleteMultipleObjects
-	bucket.Methods("POST").HandlerFunc(api.DeleteMultipleObjectsHandler)
-	// DeleteBucket
-	bucket.Methods("DELETE").HandlerFunc(api.DeleteBucketHandler)
-	// PutBucket
-	bucket.Methods("PUT").HandlerFunc(api.PutBucketHandler)
-	// ListObjects
-	bucket.Methods("GET").HandlerFunc(api.ListObjectsHandler)
-
+	var routers []*router.Router
+	for _, domain := range helper.S3Domains() {
+		// Host router, matches bucket_name.domain.name/object_name
+		routers = append(routers, apiRouter.Host("{bucket:.+}."+domain).Subrouter())
+		// Bucket router, matches domain.name/bucket_name/object_name
+		routers = append(routers, apiRouter.Host(domain).PathPrefix("/{bucket}").Subrouter())
+	}
+
+	// All routers serve exactly the same set of APIs
+	for _, bucket := range routers {
+		/// Object operations
+
+		// HeadObject
+		bucket.Methods("HEAD").Path("/{object:.+}").HandlerFunc(api.HeadObjectHandler)
+		// PutObjectPart - Copy
+		bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.CopyObjectPartHandler).
+			Queries("partNumber", "{partNumber:[0-9]+}", "uploadId", "{uploadId:.*}").
+			HeadersRegexp("X-Amz-Copy-Source", ".*?(/).*?")
+		// PutObjectPart
+		bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectPartHandler).
+			Queries("partNumber", "{partNumber:[0-9]+}", "uploadId", "{uploadId:.*}")
+		// ListObjectParts
+		bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.ListObjectPartsHandler).
+			Queries("uploadId", "{uploadId:.*}")
+		// CompleteMultipartUpload
+		bucket.Methods("POST").Path("/{object:.+}").HandlerFunc(api.CompleteMultipartUploadHandler).
+			Queries("uploadId", "{uploadId:.*}")
+		// NewMultipartUpload
+		bucket.Methods("POST").Path("/{object:.+}").HandlerFunc(api.NewMultipartUploadHandler).
+			Queries("uploads", "")
+		// RestoreObject
+		bucket.Methods("POST").Path("/{object:.+}").HandlerFunc(api.RestoreObjectHandler).
+			Queries("restore", "")
+		// SelectObjectContent
+		bucket.Methods("POST").Path("/{object:.+}").HandlerFunc(api.SelectObjectContentHandler).
+			Queries("select", "", "select-type", "2")
+		// AbortMultipartUpload
+		bucket.Methods("DELETE").Path("/{object:.+}").HandlerFunc(api.AbortMultipartUploadHandler).
+			Queries("uploadId", "{uploadId:.*}")
+		// CopyObject
+		bucket.Methods("PUT").Path("/{object:.+}").HeadersRegexp("X-Amz-Copy-Source", ".*?(/).*?").
+			HandlerFunc(api.CopyObjectHandler)
+		// RenameObject
+		bucket.Methods("PUT").Path("/{object:.+}").HeadersRegexp("X-Amz-Rename-Source", ".+").
+			HandlerFunc(api.RenameObjectHandler)
+		// PutObjectACL
+		bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectAclHandler).
+			Queries("acl", "")
+		// GetObjectAcl
+		bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectAclHandler).
+			Queries("acl", "")
+		// PutObjectRetention
+		bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectRetentionHandler).
+			Queries("retention", "")
+		// GetObjectRetention
+		bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectRetentionHandler).
+			Queries("retention", "")
+		// PutObjectLegalHold
+		bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectLegalHoldHandler).
+			Queries("legal-hold", "")
+		// GetObjectAttributes
+		bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectAttributesHandler).
+			Queries("attributes", "")
+		// GetObjectLegalHold
+		bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectLegalHoldHandler).
+			Queries("legal-hold", "")
+		// PutObject
+		bucket.Methods("PUT").Path("/{object:.+}").HandlerFunc(api.PutObjectHandler)
+		// AppendObject
+		bucket.Methods("POST").Path("/{object:.+}").HandlerFunc(api.AppendObjectHandler).
+			Queries("append", "", "position", "{position:.*}")
+		// GetObject
+		bucket.Methods("GET").Path("/{object:.+}").HandlerFunc(api.GetObjectHandler)
+		// DeleteObject
+		bucket.Methods("DELETE").Path("/{object:.+}").HandlerFunc(api.DeleteObjectHandler)
+
+		/// Bucket operations
+
+		// GetBucketLocation
+		bucket.Methods("GET").HandlerFunc(api.GetBucketLocationHandler).Queries("location", "")
+		// ListMultipartUploads
+		bucket.Methods("GET").HandlerFunc(api.ListMultipartUploadsHandler).Queries("uploads", "")
+		// Get bucket versioning status
+		bucket.Methods("GET").HandlerFunc(api.GetBucketVersioningHandler).Queries("versioning", "")
+		// List versioned objects in a bucket
+		bucket.Methods("GET").HandlerFunc(api.ListVersionedObjectsHandler).Queries("versions", "")
+		// PutBucketACL
+		bucket.Methods("PUT").HandlerFunc(api.PutBucketAclHandler).Queries("acl", "")
+		// GetBucketACL
+		bucket.Methods("GET").HandlerFunc(api.GetBucketAclHandler).Queries("acl", "")
+		// PutBucketVersioning
+		bucket.Methods("PUT").HandlerFunc(api.PutBucketVersioningHandler).Queries("versioning", "")
+		// PutBucketCORS
+		bucket.Methods("PUT").HandlerFunc(api.PutBucketCorsHandler).Queries("cors", "")
+		// GetBucketCORS
+		bucket.Methods("GET").HandlerFunc(api.GetBucketCorsHandler).Queries("cors", "")
+		// GetBucketPolicy
+		bucket.Methods("GET").HandlerFunc(api.GetBucketPolicyHandler).Queries("policy", "")
+		// DeleteBucketCORS
+		bucket.Methods("DELETE").HandlerFunc(api.DeleteBucketCorsHandler).Queries("cors", "")
+		// PutBucketEncryption
+		bucket.Methods("PUT").HandlerFunc(api.PutBucketEncryptionHandler).Queries("encryption", "")
+		// GetBucketEncryption
+		bucket.Methods("GET").HandlerFunc(api.GetBucketEncryptionHandler).Queries("encryption", "")
+		// DeleteBucketEncryption
+		bucket.Methods("DELETE").HandlerFunc(api.DeleteBucketEncryptionHandler).Queries("encryption", "")
+		// PutBucketObjectLock
+		bucket.Methods("PUT").HandlerFunc(api.PutBucketObjectLockHandler).Queries("object-lock", "")
+		// GetBucketObjectLock
+		bucket.Methods("GET").HandlerFunc(api.GetBucketObjectLockHandler).Queries("object-lock", "")
+		// PutBucketRequestPayment
+		bucket.Methods("PUT").HandlerFunc(api.PutBucketRequestPaymentHandler).Queries("requestPayment", "")
+		// GetBucketRequestPayment
+		bucket.Methods("GET").HandlerFunc(api.GetBucketRequestPaymentHandler).Queries("requestPayment", "")
+		// PutLifeCycleConfig
+		bucket.Methods("PUT").HandlerFunc(api.PutBucketLifeCycleHandler).Queries("lifecycle", "")
+		// GetLifeCycleConfig
+		bucket.Methods("GET").HandlerFunc(api.GetBucketLifeCycleHandler).Queries("lifecycle", "")
+		// DelLifeCycleConfig
+		bucket.Methods("DELETE").HandlerFunc(api.DelBucketLifeCycleHandler).Queries("lifecycle", "")
+		// HeadBucket
+		bucket.Methods("HEAD").HandlerFunc(api.HeadBucketHandler)
+		// PostPolicy
+		bucket.Methods("POST").HeadersRegexp("Content-Type", "multipart/form-data*").
+			HandlerFunc(api.PostPolicyBucketHandler)
+		// DeleteMultipleObjects
+		bucket.Methods("POST").HandlerFunc(api.DeleteMultipleObjectsHandler)
+		// DeleteBucket
+		bucket.Methods("DELETE").HandlerFunc(api.DeleteBucketHandler)
+		// PutBucket
+		bucket.Methods("PUT").HandlerFunc(api.PutBucketHandler)
+		// ListObjects
+		bucket.Methods("GET").HandlerFunc(api.ListObjectsHandler)
+	}
 
 	/// Root operation
 
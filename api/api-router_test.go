@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	router "github.com/gorilla/mux"
+	"github.com/journeymidnight/yig/helper"
+)
+
+func handlerName(h http.Handler) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
+
+func TestAPIRouterAddressingStyles(t *testing.T) {
+	helper.CONFIG.S3Domain = "s3.test.com"
+	helper.CONFIG.Region = "cn-bj-1"
+	helper.CONFIG.RegionalS3Domain = true
+	// a custom domain, and one under S3Domain which shouldn't be taken as
+	// part of bucket names
+	helper.CONFIG.S3DomainAliases = []string{"s3.example.org", "cdn.s3.test.com"}
+	defer func() {
+		helper.CONFIG.RegionalS3Domain = false
+		helper.CONFIG.S3DomainAliases = nil
+	}()
+	mux := router.NewRouter()
+	RegisterAPIRouter(mux, ObjectAPIHandlers{})
+
+	hosts := []struct {
+		host       string
+		pathPrefix string // path-style requests have bucket in path
+	}{
+		{"bucket.s3.test.com", ""},
+		{"bucket.s3.test.com:8080", ""},
+		{"bucket.s3.cn-bj-1.test.com", ""},
+		{"bucket.s3.example.org", ""},
+		{"bucket.cdn.s3.test.com", ""},
+		{"s3.test.com", "/bucket"},
+		{"s3.test.com:8080", "/bucket"},
+		{"s3.cn-bj-1.test.com", "/bucket"},
+		{"s3.example.org", "/bucket"},
+		{"cdn.s3.test.com", "/bucket"},
+	}
+	requests := []struct {
+		method  string
+		path    string
+		object  string
+		handler string
+	}{
+		{"GET", "/", "", "ListObjectsHandler"},
+		{"PUT", "/", "", "PutBucketHandler"},
+		{"GET", "/?acl", "", "GetBucketAclHandler"},
+		{"GET", "/?versions", "", "ListVersionedObjectsHandler"},
+		{"POST", "/?delete", "", "DeleteMultipleObjectsHandler"},
+		{"HEAD", "/dir/object", "dir/object", "HeadObjectHandler"},
+		{"GET", "/object", "object", "GetObjectHandler"},
+		{"PUT", "/object?partNumber=1&uploadId=hehe", "object", "PutObjectPartHandler"},
+		{"POST", "/object?restore", "object", "RestoreObjectHandler"},
+		{"DELETE", "/object", "object", "DeleteObjectHandler"},
+	}
+	for _, h := range hosts {
+		for _, c := range requests {
+			url := "http://" + h.host + h.pathPrefix + c.path
+			r, err := http.NewRequest(c.method, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// as received by server, host is only in r.Host
+			r.URL.Scheme, r.URL.Host = "", ""
+			var match router.RouteMatch
+			if !mux.Match(r, &match) {
+				t.Errorf("%s %s: no route matched", c.method, url)
+				continue
+			}
+			if name := handlerName(match.Handler); name != c.handler {
+				t.Errorf("%s %s: expected %s, got %s", c.method, url, c.handler, name)
+			}
+			if match.Vars["bucket"] != "bucket" || match.Vars["object"] != c.object {
+				t.Errorf("%s %s: wrong vars %v", c.method, url, match.Vars)
+			}
+		}
+	}
+
+	// bucket names with dots, in both styles
+	for _, url := range []string{"http://my.bucket.s3.test.com/object",
+		"http://s3.test.com/my.bucket/object", "http://my.bucket.s3.example.org/object"} {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.URL.Scheme, r.URL.Host = "", ""
+		var match router.RouteMatch
+		if !mux.Match(r, &match) || handlerName(match.Handler) != "GetObjectHandler" ||
+			match.Vars["bucket"] != "my.bucket" || match.Vars["object"] != "object" {
+			t.Errorf("GET %s: wrong route %v", url, match.Vars)
+		}
+	}
+
+	// keys are decoded once after routing, and paths are never cleaned
+	for path, key := range map[string]string{
+		"/bucket/a/b%2Fc":   "a/b/c",
+		"/bucket/a/b%252Fc": "a/b%2Fc",
+		"/bucket/foo+bar":   "foo+bar",
+		"/bucket/foo%2Bbar": "foo+bar",
+		"/bucket/dir/":      "dir/",
+		"/bucket/a//b":      "a//b",
+		"/bucket/a/../b":    "a/../b",
+	} {
+		r := httptest.NewRequest("GET", "http://s3.test.com"+path, nil)
+		var match router.RouteMatch
+		if !mux.Match(r, &match) || match.Vars["bucket"] != "bucket" || match.Vars["object"] != key {
+			t.Errorf("GET %s: expected key %q, got %v", path, key, match.Vars)
+		}
+		r = httptest.NewRequest("PATCH", "http://s3.test.com"+path, nil)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, r)
+		if recorder.Code == http.StatusMovedPermanently {
+			t.Errorf("%s: should not be redirected to %s", path, recorder.Header().Get("Location"))
+		}
+	}
+}
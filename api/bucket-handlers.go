@@ -17,6 +17,7 @@
 package api
 
 import (
+	"bytes"
 	"encoding/xml"
 	"io"
 	"io/ioutil"
@@ -58,15 +59,16 @@ func (api ObjectAPIHandlers) GetBucketLocationHandler(w http.ResponseWriter, r *
 		}
 	}
 
-	if _, err = api.ObjectAPI.GetBucketInfo(bucketName, credential); err != nil {
+	if _, err = api.ObjectAPI.GetBucketInfo(r.Context(), bucketName, credential); err != nil {
 		helper.ErrorIf(err, "Unable to fetch bucket info.")
 		WriteErrorResponse(w, r, err)
 		return
 	}
 
 	// Generate response.
+	// Buckets are not stored with regions, they're all in the configured one
 	encodedSuccessResponse := EncodeResponse(LocationResponse{
-		Location: helper.CONFIG.Region,
+		Location: bucketLocation(helper.CONFIG.Region),
 	})
 	WriteSuccessResponse(w, encodedSuccessResponse)
 }
@@ -106,7 +108,7 @@ func (api ObjectAPIHandlers) ListMultipartUploadsHandler(w http.ResponseWriter,
 		return
 	}
 
-	listMultipartsResponse, err := api.ObjectAPI.ListMultipartUploads(credential, bucketName, request)
+	listMultipartsResponse, err := api.ObjectAPI.ListMultipartUploads(r.Context(), credential, bucketName, request)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to list multipart uploads.")
 		WriteErrorResponse(w, r, err)
@@ -150,7 +152,7 @@ func (api ObjectAPIHandlers) ListObjectsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	listObjectsInfo, err := api.ObjectAPI.ListObjects(credential, bucketName, request)
+	listObjectsInfo, err := api.ObjectAPI.ListObjects(r.Context(), credential, bucketName, request)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to list objects.")
 		WriteErrorResponse(w, r, err)
@@ -159,6 +161,7 @@ func (api ObjectAPIHandlers) ListObjectsHandler(w http.ResponseWriter, r *http.R
 
 	response := GenerateListObjectsResponse(bucketName, request, listObjectsInfo)
 	encodedSuccessResponse := EncodeResponse(response)
+	api.setRequestChargedHeader(w, r, bucketName, credential)
 
 	// Write success response.
 	WriteSuccessResponse(w, encodedSuccessResponse)
@@ -193,7 +196,7 @@ func (api ObjectAPIHandlers) ListVersionedObjectsHandler(w http.ResponseWriter,
 	}
 	request.Versioned = true
 
-	listObjectsInfo, err := api.ObjectAPI.ListVersionedObjects(credential, bucketName, request)
+	listObjectsInfo, err := api.ObjectAPI.ListVersionedObjects(r.Context(), credential, bucketName, request)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to list objects.")
 		WriteErrorResponse(w, r, err)
@@ -202,6 +205,7 @@ func (api ObjectAPIHandlers) ListVersionedObjectsHandler(w http.ResponseWriter,
 
 	response := GenerateVersionedListObjectResponse(bucketName, request, listObjectsInfo)
 	encodedSuccessResponse := EncodeResponse(response)
+	api.setRequestChargedHeader(w, r, bucketName, credential)
 
 	// Write success response.
 	WriteSuccessResponse(w, encodedSuccessResponse)
@@ -221,10 +225,17 @@ func (api ObjectAPIHandlers) ListBucketsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	bucketsInfo, err := api.ObjectAPI.ListBuckets(credential)
+	request, err := parseListBucketsQuery(r.URL.Query())
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	bucketsInfo, continuationToken, err := api.ObjectAPI.ListBuckets(r.Context(), credential, request)
 	if err == nil {
 		// generate response
 		response := GenerateListBucketsResponse(bucketsInfo, credential)
+		response.ContinuationToken = continuationToken
 		encodedSuccessResponse := EncodeResponse(response)
 		// write response
 		WriteSuccessResponse(w, encodedSuccessResponse)
@@ -264,14 +275,6 @@ func (api ObjectAPIHandlers) DeleteMultipleObjectsHandler(w http.ResponseWriter,
 		return
 	}
 
-	// Content-Md5 is required and should be set
-	// http://docs.aws.amazon.com/AmazonS3/latest/API/multiobjectdeleteapi.html
-	contentMd5 := r.Header.Get("Content-Md5")
-	if contentMd5 == "" {
-		WriteErrorResponse(w, r, ErrMissingContentMD5)
-		return
-	}
-
 	// Allocate incoming content length bytes.
 	deleteXmlBytes := make([]byte, contentLength)
 
@@ -282,6 +285,13 @@ func (api ObjectAPIHandlers) DeleteMultipleObjectsHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Content-Md5 or x-amz-checksum-* is required and should match the body
+	// http://docs.aws.amazon.com/AmazonS3/latest/API/multiobjectdeleteapi.html
+	if err := verifyRequiredChecksum(r, deleteXmlBytes); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
 	// Unmarshal list of keys to be deleted.
 	deleteObjects := &DeleteObjectsRequest{}
 	if err := xml.Unmarshal(deleteXmlBytes, deleteObjects); err != nil {
@@ -293,10 +303,12 @@ func (api ObjectAPIHandlers) DeleteMultipleObjectsHandler(w http.ResponseWriter,
 
 	var deleteErrors []DeleteError
 	var deletedObjects []ObjectIdentifier
-	// Loop through all the objects and delete them sequentially.
-	for _, object := range deleteObjects.Objects {
-		result, err := api.ObjectAPI.DeleteObject(bucket, object.ObjectName,
-			object.VersionId, credential)
+	bypassGovernance := r.Header.Get("X-Amz-Bypass-Governance-Retention") == "true"
+	// Objects are deleted sequentially, with their cache invalidated at once
+	results, errs := api.ObjectAPI.DeleteObjects(r.Context(), bucket, deleteObjects.Objects,
+		credential, bypassGovernance)
+	for i, object := range deleteObjects.Objects {
+		result, err := results[i], errs[i]
 		if err == nil {
 			deletedObjects = append(deletedObjects, ObjectIdentifier{
 				ObjectName:   object.ObjectName,
@@ -336,7 +348,7 @@ func (api ObjectAPIHandlers) DeleteMultipleObjectsHandler(w http.ResponseWriter,
 // ----------
 // This implementation of the PUT operation creates a new bucket for authenticated request
 func (api ObjectAPIHandlers) PutBucketHandler(w http.ResponseWriter, r *http.Request) {
-	helper.Debugln("PutBucketHandler", "enter")
+	helper.DebuglnContext(r.Context(), "PutBucketHandler", "enter")
 	vars := mux.Vars(r)
 	bucketName := strings.ToLower(vars["bucket"])
 	if !isValidBucketName(bucketName) {
@@ -351,7 +363,7 @@ func (api ObjectAPIHandlers) PutBucketHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	if len(r.Header.Get("Content-Length")) == 0 {
-		helper.Debugln("Content Length is null!")
+		helper.DebuglnContext(r.Context(), "Content Length is null!")
 		WriteErrorResponse(w, r, ErrInvalidHeader)
 		return
 	}
@@ -371,7 +383,7 @@ func (api ObjectAPIHandlers) PutBucketHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 	// Make bucket.
-	err = api.ObjectAPI.MakeBucket(bucketName, acl, credential)
+	err = api.ObjectAPI.MakeBucket(r.Context(), bucketName, acl, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to create bucket "+bucketName)
 		WriteErrorResponse(w, r, err)
@@ -406,8 +418,13 @@ func (api ObjectAPIHandlers) PutBucketLifeCycleHandler(w http.ResponseWriter, r
 		WriteErrorResponse(w, r, ErrInternalError)
 		return
 	}
+	err = lc.Validate()
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
 
-	err = api.ObjectAPI.SetBucketLc(bucket, lc, credential)
+	err = api.ObjectAPI.SetBucketLc(r.Context(), bucket, lc, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to set LC for bucket.")
 		WriteErrorResponse(w, r, err)
@@ -437,7 +454,7 @@ func (api ObjectAPIHandlers) GetBucketLifeCycleHandler(w http.ResponseWriter, r
 		}
 	}
 
-	lc, err := api.ObjectAPI.GetBucketLc(bucketName, credential)
+	lc, err := api.ObjectAPI.GetBucketLc(r.Context(), bucketName, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Failed to get bucket acl policy for bucket", bucketName)
 		WriteErrorResponse(w, r, err)
@@ -465,7 +482,7 @@ func (api ObjectAPIHandlers) DelBucketLifeCycleHandler(w http.ResponseWriter, r
 		return
 	}
 
-	err = api.ObjectAPI.DelBucketLc(bucketName, credential)
+	err = api.ObjectAPI.DelBucketLc(r.Context(), bucketName, credential)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
@@ -488,15 +505,15 @@ func (api ObjectAPIHandlers) PutBucketAclHandler(w http.ResponseWriter, r *http.
 
 	var acl Acl
 	var policy AccessControlPolicy
-	if _, ok := r.Header["X-Amz-Acl"]; ok {
+	if _, ok := r.Header["X-Amz-Acl"]; ok || hasGrantHeaders(r.Header) {
 		acl, err = getAclFromHeader(r.Header)
 		if err != nil {
-			helper.ErrorIf(err, "Unable to read canned acls")
-			WriteErrorResponse(w, r, ErrInvalidAcl)
+			helper.ErrorIf(err, "Unable to read acls from header")
+			WriteErrorResponse(w, r, err)
 			return
 		}
 	} else {
-		aclBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+		aclBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_ACL_BODY_SIZE))
 		if err != nil {
 			helper.ErrorIf(err, "Unable to read acls body")
 			WriteErrorResponse(w, r, ErrInvalidAcl)
@@ -505,12 +522,12 @@ func (api ObjectAPIHandlers) PutBucketAclHandler(w http.ResponseWriter, r *http.
 		err = xml.Unmarshal(aclBuffer, &policy)
 		if err != nil {
 			helper.ErrorIf(err, "Unable to parse acls xml body")
-			WriteErrorResponse(w, r, ErrInternalError)
+			WriteErrorResponse(w, r, ErrMalformedACLError)
 			return
 		}
 	}
 
-	err = api.ObjectAPI.SetBucketAcl(bucket, policy, acl, credential)
+	err = api.ObjectAPI.SetBucketAcl(r.Context(), bucket, policy, acl, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to set ACL for bucket.")
 		WriteErrorResponse(w, r, err)
@@ -540,7 +557,7 @@ func (api ObjectAPIHandlers) GetBucketAclHandler(w http.ResponseWriter, r *http.
 		}
 	}
 
-	policy, err := api.ObjectAPI.GetBucketAcl(bucketName, credential)
+	policy, err := api.ObjectAPI.GetBucketAcl(r.Context(), bucketName, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Failed to get bucket acl policy for bucket", bucketName)
 		WriteErrorResponse(w, r, err)
@@ -583,7 +600,7 @@ func (api ObjectAPIHandlers) PutBucketCorsHandler(w http.ResponseWriter, r *http
 	corsBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_CORS_SIZE))
 	if err != nil {
 		helper.ErrorIf(err, "Unable to read CORS body")
-		WriteErrorResponse(w, r, ErrInternalError)
+		WriteErrorResponse(w, r, err)
 		return
 	}
 
@@ -592,7 +609,7 @@ func (api ObjectAPIHandlers) PutBucketCorsHandler(w http.ResponseWriter, r *http
 		WriteErrorResponse(w, r, err)
 		return
 	}
-	err = api.ObjectAPI.SetBucketCors(bucketName, cors, credential)
+	err = api.ObjectAPI.SetBucketCors(r.Context(), bucketName, cors, credential)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
@@ -611,7 +628,7 @@ func (api ObjectAPIHandlers) DeleteBucketCorsHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	err = api.ObjectAPI.DeleteBucketCors(bucketName, credential)
+	err = api.ObjectAPI.DeleteBucketCors(r.Context(), bucketName, credential)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
@@ -630,7 +647,7 @@ func (api ObjectAPIHandlers) GetBucketCorsHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	cors, err := api.ObjectAPI.GetBucketCors(bucketName, credential)
+	cors, err := api.ObjectAPI.GetBucketCors(r.Context(), bucketName, credential)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
@@ -645,6 +662,148 @@ func (api ObjectAPIHandlers) GetBucketCorsHandler(w http.ResponseWriter, r *http
 	WriteSuccessResponse(w, corsBuffer)
 }
 
+func (api ObjectAPIHandlers) PutBucketEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	// If Content-Length is unknown or zero, deny the request.
+	if !contains(r.TransferEncoding, "chunked") {
+		if r.ContentLength == -1 || r.ContentLength == 0 {
+			WriteErrorResponse(w, r, ErrMissingContentLength)
+			return
+		}
+		if r.ContentLength > MAX_ENCRYPTION_SIZE {
+			WriteErrorResponse(w, r, ErrEntityTooLarge)
+			return
+		}
+	}
+
+	encryptionBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_ENCRYPTION_SIZE))
+	if err != nil {
+		helper.ErrorIf(err, "Unable to read encryption body")
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	encryption, err := EncryptionFromXml(encryptionBuffer)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	err = api.ObjectAPI.SetBucketEncryption(r.Context(), bucketName, encryption, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	WriteSuccessResponse(w, nil)
+}
+
+func (api ObjectAPIHandlers) DeleteBucketEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	err = api.ObjectAPI.DeleteBucketEncryption(r.Context(), bucketName, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	WriteSuccessNoContent(w)
+}
+
+func (api ObjectAPIHandlers) GetBucketEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	encryption, err := api.ObjectAPI.GetBucketEncryption(r.Context(), bucketName, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	encryptionBuffer, err := xml.Marshal(encryption)
+	if err != nil {
+		helper.ErrorIf(err, "Failed to marshal encryption XML for bucket %s", bucketName)
+		WriteErrorResponse(w, r, ErrInternalError)
+		return
+	}
+	WriteSuccessResponse(w, encryptionBuffer)
+}
+
+func (api ObjectAPIHandlers) PutBucketObjectLockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	lockBuffer, ok := readObjectLockBody(w, r)
+	if !ok {
+		return
+	}
+	config, err := ObjectLockConfigurationFromXml(lockBuffer)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	err = api.ObjectAPI.SetBucketObjectLock(r.Context(), bucketName, config, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	WriteSuccessResponse(w, nil)
+}
+
+func (api ObjectAPIHandlers) GetBucketObjectLockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	config, err := api.ObjectAPI.GetBucketObjectLock(r.Context(), bucketName, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	lockBuffer, err := xml.Marshal(config)
+	if err != nil {
+		helper.ErrorIf(err, "Failed to marshal object lock XML for bucket %s", bucketName)
+		WriteErrorResponse(w, r, ErrInternalError)
+		return
+	}
+	WriteSuccessResponse(w, lockBuffer)
+}
+
 func (api ObjectAPIHandlers) GetBucketPolicyHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 	if _, err = signature.IsReqAuthenticated(r); err != nil {
@@ -667,7 +826,7 @@ func (api ObjectAPIHandlers) GetBucketVersioningHandler(w http.ResponseWriter, r
 		return
 	}
 
-	versioning, err := api.ObjectAPI.GetBucketVersioning(bucketName, credential)
+	versioning, err := api.ObjectAPI.GetBucketVersioning(r.Context(), bucketName, credential)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
@@ -710,7 +869,7 @@ func (api ObjectAPIHandlers) PutBucketVersioningHandler(w http.ResponseWriter, r
 	versioningBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
 	if err != nil {
 		helper.ErrorIf(err, "Unable to read versioning body")
-		WriteErrorResponse(w, r, ErrInternalError)
+		WriteErrorResponse(w, r, err)
 		return
 	}
 
@@ -719,7 +878,7 @@ func (api ObjectAPIHandlers) PutBucketVersioningHandler(w http.ResponseWriter, r
 		WriteErrorResponse(w, r, err)
 		return
 	}
-	err = api.ObjectAPI.SetBucketVersioning(bucketName, versioning, credential)
+	err = api.ObjectAPI.SetBucketVersioning(r.Context(), bucketName, versioning, credential)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
@@ -727,6 +886,75 @@ func (api ObjectAPIHandlers) PutBucketVersioningHandler(w http.ResponseWriter, r
 	WriteSuccessResponse(w, nil)
 }
 
+func (api ObjectAPIHandlers) PutBucketRequestPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	// If Content-Length is unknown or zero, deny the request.
+	if !contains(r.TransferEncoding, "chunked") {
+		if r.ContentLength == -1 || r.ContentLength == 0 {
+			WriteErrorResponse(w, r, ErrMissingContentLength)
+			return
+		}
+		if r.ContentLength > MAX_REQUEST_PAYMENT_SIZE {
+			WriteErrorResponse(w, r, ErrEntityTooLarge)
+			return
+		}
+	}
+
+	paymentBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_PAYMENT_SIZE))
+	if err != nil {
+		helper.ErrorIf(err, "Unable to read request payment body")
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	payment, err := RequestPaymentFromXml(paymentBuffer)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	err = api.ObjectAPI.SetBucketRequestPayment(r.Context(), bucketName, payment, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	WriteSuccessResponse(w, nil)
+}
+
+func (api ObjectAPIHandlers) GetBucketRequestPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	payment, err := api.ObjectAPI.GetBucketRequestPayment(r.Context(), bucketName, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	paymentBuffer, err := xml.Marshal(payment)
+	if err != nil {
+		helper.ErrorIf(err, "Failed to marshal request payment XML for bucket %s", bucketName)
+		WriteErrorResponse(w, r, ErrInternalError)
+		return
+	}
+	WriteSuccessResponse(w, paymentBuffer)
+}
+
 func extractHTTPFormValues(reader *multipart.Reader) (filePartReader io.Reader,
 	formValues map[string]string, err error) {
 
@@ -774,6 +1002,62 @@ func extractHTTPFormValues(reader *multipart.Reader) (filePartReader io.Reader,
 	return
 }
 
+// Files of POST uploads smaller than this are read into memory first, so
+// their sizes are known when Ceph pools are picked, same as the threshold
+// of the small file pool
+const POST_FILE_READ_AHEAD = 128 << 10
+
+// Read ahead file of POST upload, size is -1 if it's not smaller than
+// POST_FILE_READ_AHEAD
+func readAheadPostFile(file io.Reader) (reader io.Reader, size int64, err error) {
+	buffer := make([]byte, POST_FILE_READ_AHEAD)
+	n, err := io.ReadFull(file, buffer)
+	switch err {
+	case nil:
+		return io.MultiReader(bytes.NewReader(buffer), file), -1, nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		return bytes.NewReader(buffer[:n]), int64(n), nil
+	}
+	return nil, 0, err
+}
+
+// Enforce content-length-range of POST policy while file is read, fails
+// with ErrEntityTooLarge once more than `max` bytes are read, or
+// ErrEntityTooSmall at the end if less than `min`
+type contentLengthRangeReader struct {
+	reader io.Reader
+	min    int64
+	max    int64
+	read   int64
+}
+
+func (r *contentLengthRangeReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.max {
+		return 0, ErrEntityTooLarge
+	}
+	if err == io.EOF && r.read < r.min {
+		return n, ErrEntityTooSmall
+	}
+	return
+}
+
+// success_action_redirect with bucket, key and etag of the object created
+// appended to its query string
+func postRedirectUrl(redirect, bucketName, objectName, etag string) (string, error) {
+	redirectUrl, err := url.Parse(redirect)
+	if err != nil {
+		return "", err
+	}
+	query := redirectUrl.Query()
+	query.Set("bucket", bucketName)
+	query.Set("key", objectName)
+	query.Set("etag", "\""+etag+"\"")
+	redirectUrl.RawQuery = query.Encode()
+	return redirectUrl.String(), nil
+}
+
 // PostPolicyBucketHandler - POST policy upload
 // ----------
 // This implementation of the POST operation handles object creation with a specified
@@ -806,7 +1090,7 @@ func (api ObjectAPIHandlers) PostPolicyBucketHandler(w http.ResponseWriter, r *h
 
 	bucketName := mux.Vars(r)["bucket"]
 	formValues["Bucket"] = bucketName
-	bucket, err := api.ObjectAPI.GetBucket(bucketName)
+	bucket, err := api.ObjectAPI.GetBucket(r.Context(), bucketName)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
@@ -819,10 +1103,8 @@ func (api ObjectAPIHandlers) PostPolicyBucketHandler(w http.ResponseWriter, r *h
 	postPolicyType := signature.GetPostPolicyType(formValues)
 	helper.Debugln("type", postPolicyType)
 	switch postPolicyType {
-	case signature.PostPolicyV2:
-		credential, err = signature.DoesPolicySignatureMatchV2(formValues)
-	case signature.PostPolicyV4:
-		credential, err = signature.DoesPolicySignatureMatchV4(formValues)
+	case signature.PostPolicyV2, signature.PostPolicyV4:
+		credential, err = signature.DoesPolicySignatureMatch(r, formValues, postPolicyType)
 	case signature.PostPolicyAnonymous:
 		if bucket.ACL.CannedAcl != "public-read-write" {
 			WriteErrorResponse(w, r, ErrAccessDenied)
@@ -837,10 +1119,18 @@ func (api ObjectAPIHandlers) PostPolicyBucketHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	if err = signature.CheckPostPolicy(formValues, postPolicyType); err != nil {
+	policy, err := signature.CheckPostPolicy(formValues, postPolicyType)
+	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
 	}
+	if lengthRange := policy.Conditions.ContentLengthRange; lengthRange.Valid {
+		fileBody = &contentLengthRangeReader{
+			reader: fileBody,
+			min:    lengthRange.Min,
+			max:    lengthRange.Max,
+		}
+	}
 
 	// Convert form values to header type so those values could be handled as in
 	// normal requests
@@ -850,6 +1140,19 @@ func (api ObjectAPIHandlers) PostPolicyBucketHandler(w http.ResponseWriter, r *h
 	}
 
 	metadata := extractMetadataFromHeader(headerfiedFormValues)
+	metadata["storageClass"], err = parseStorageClassHeader(headerfiedFormValues)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	ttl, err := parseTtlHeader(headerfiedFormValues)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if ttl > 0 {
+		metadata["ttl"] = strconv.FormatInt(ttl, 10)
+	}
 
 	var acl Acl
 	acl.CannedAcl = headerfiedFormValues.Get("acl")
@@ -868,7 +1171,17 @@ func (api ObjectAPIHandlers) PostPolicyBucketHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	result, err := api.ObjectAPI.PutObject(bucketName, objectName, credential, -1, fileBody,
+	fileBody, size, err := readAheadPostFile(fileBody)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to read file of POST upload.")
+		if _, ok := err.(ApiErrorCode); !ok {
+			err = ErrIncompleteBody
+		}
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	result, err := api.ObjectAPI.PutObject(r.Context(), bucketName, objectName, credential, size, fileBody,
 		metadata, acl, sseRequest)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to create object "+objectName)
@@ -885,12 +1198,9 @@ func (api ObjectAPIHandlers) PostPolicyBucketHandler(w http.ResponseWriter, r *h
 		redirect, _ = formValues["redirect"]
 	}
 	if redirect != "" {
-		redirectUrl, err := url.Parse(redirect)
+		redirectUrl, err := postRedirectUrl(redirect, bucketName, objectName, result.Md5)
 		if err == nil {
-			redirectUrl.Query().Set("bucket", bucketName)
-			redirectUrl.Query().Set("key", objectName)
-			redirectUrl.Query().Set("etag", result.Md5)
-			http.Redirect(w, r, redirectUrl.String(), http.StatusSeeOther)
+			http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
 			return
 		}
 		// If URL is Invalid, ignore the redirect field
@@ -945,7 +1255,7 @@ func (api ObjectAPIHandlers) HeadBucketHandler(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	if _, err = api.ObjectAPI.GetBucketInfo(bucket, credential); err != nil {
+	if _, err = api.ObjectAPI.GetBucketInfo(r.Context(), bucket, credential); err != nil {
 		helper.ErrorIf(err, "Unable to fetch bucket info.")
 		WriteErrorResponse(w, r, err)
 		return
@@ -965,7 +1275,7 @@ func (api ObjectAPIHandlers) DeleteBucketHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	if err = api.ObjectAPI.DeleteBucket(bucket, credential); err != nil {
+	if err = api.ObjectAPI.DeleteBucket(r.Context(), bucket, credential); err != nil {
 		helper.ErrorIf(err, "Unable to delete a bucket.")
 		WriteErrorResponse(w, r, err)
 		return
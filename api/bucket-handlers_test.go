@@ -0,0 +1,71 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/journeymidnight/yig/error"
+)
+
+func TestReadAheadPostFile(t *testing.T) {
+	cases := []struct {
+		size     int
+		min, max int64
+		expected error
+	}{
+		{0, 0, 10, nil},
+		{10, 1, 10, nil},
+		{10, 11, 100, ErrEntityTooSmall},
+		{11, 1, 10, ErrEntityTooLarge},
+		{POST_FILE_READ_AHEAD - 1, 0, POST_FILE_READ_AHEAD, nil},
+		{POST_FILE_READ_AHEAD * 3, 0, POST_FILE_READ_AHEAD * 3, nil},
+		// violations of big files are found while streaming
+		{POST_FILE_READ_AHEAD * 3, POST_FILE_READ_AHEAD*3 + 1, POST_FILE_READ_AHEAD * 4,
+			ErrEntityTooSmall},
+		{POST_FILE_READ_AHEAD * 3, 0, POST_FILE_READ_AHEAD * 2, ErrEntityTooLarge},
+	}
+	for i, c := range cases {
+		data := strings.Repeat("a", c.size)
+		reader, size, err := readAheadPostFile(&contentLengthRangeReader{
+			reader: strings.NewReader(data),
+			min:    c.min,
+			max:    c.max,
+		})
+		var read []byte
+		if err == nil {
+			expectedSize := int64(c.size)
+			if c.size >= POST_FILE_READ_AHEAD {
+				expectedSize = -1
+			}
+			if size != expectedSize {
+				t.Errorf("case %d: expected size %d, got %d", i, expectedSize, size)
+			}
+			read, err = ioutil.ReadAll(reader)
+		}
+		if err != c.expected {
+			t.Errorf("case %d: expected %v, got %v", i, c.expected, err)
+		}
+		if err == nil && string(read) != data {
+			t.Errorf("case %d: file read wrong", i)
+		}
+	}
+}
+
+func TestPostRedirectUrl(t *testing.T) {
+	redirect, err := postRedirectUrl("https://hehe.com/done?from=upload", "hehe", "a b",
+		"0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(redirect)
+	if err != nil || u.Host != "hehe.com" || u.Path != "/done" {
+		t.Fatalf("unexpected redirect %s, %v", redirect, err)
+	}
+	query := u.Query()
+	if query.Get("from") != "upload" || query.Get("bucket") != "hehe" ||
+		query.Get("key") != "a b" || query.Get("etag") != "\"0123456789abcdef\"" {
+		t.Errorf("unexpected query %v", query)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+)
+
+// Verify body against Content-Md5 or x-amz-checksum-* headers, at least
+// one of them is required. All provided ones should match.
+func verifyRequiredChecksum(r *http.Request, body []byte) error {
+	found := false
+	if contentMd5 := r.Header.Get("Content-Md5"); contentMd5 != "" {
+		expected, err := checkValidMD5(contentMd5)
+		if err != nil {
+			return ErrInvalidDigest
+		}
+		sum := md5.Sum(body)
+		if !bytes.Equal(expected, sum[:]) {
+			return ErrBadDigest
+		}
+		found = true
+	}
+	for _, algorithm := range datatype.ChecksumAlgorithms {
+		value := r.Header.Get("X-Amz-Checksum-" + algorithm)
+		if value == "" {
+			continue
+		}
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return ErrInvalidDigest
+		}
+		h := datatype.NewChecksumHash(algorithm)
+		h.Write(body)
+		if !bytes.Equal(expected, h.Sum(nil)) {
+			return ErrBadDigest
+		}
+		found = true
+	}
+	if !found {
+		return ErrMissingContentMD5
+	}
+	return nil
+}
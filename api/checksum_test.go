@@ -0,0 +1,45 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	. "github.com/journeymidnight/yig/error"
+)
+
+func TestVerifyRequiredChecksum(t *testing.T) {
+	body := []byte("<Delete><Object><Key>hehe</Key></Object></Delete>")
+	md5Sum := md5.Sum(body)
+	sha256Sum := sha256.Sum256(body)
+	validMd5 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	validSha256 := base64.StdEncoding.EncodeToString(sha256Sum[:])
+	wrongSum := base64.StdEncoding.EncodeToString([]byte("hehe"))
+
+	var testcase = []struct {
+		headers  map[string]string
+		expected error
+	}{
+		{map[string]string{"Content-Md5": validMd5}, nil},
+		{map[string]string{"X-Amz-Checksum-Sha256": validSha256}, nil},
+		{map[string]string{"Content-Md5": validMd5, "X-Amz-Checksum-Sha256": validSha256}, nil},
+		{map[string]string{}, ErrMissingContentMD5},
+		{map[string]string{"Content-Md5": wrongSum}, ErrBadDigest},
+		{map[string]string{"X-Amz-Checksum-Sha256": wrongSum}, ErrBadDigest},
+		{map[string]string{"Content-Md5": validMd5, "X-Amz-Checksum-Sha256": wrongSum}, ErrBadDigest},
+		{map[string]string{"X-Amz-Checksum-Sha256": "not base64!"}, ErrInvalidDigest},
+	}
+
+	for _, c := range testcase {
+		r, _ := http.NewRequest("POST", "http://s3.test.com/bucket?delete", nil)
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		err := verifyRequiredChecksum(r, body)
+		if err != c.expected {
+			t.Errorf("headers %v: expected %v, got %v", c.headers, c.expected, err)
+		}
+	}
+}
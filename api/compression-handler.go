@@ -0,0 +1,115 @@
+package api
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/journeymidnight/yig/helper"
+)
+
+// Gzips XML responses for clients accepting it. Object data, and anything
+// else with Content-Length, Content-Range or Content-Encoding set by
+// handlers, is sent as is, so are responses to HEAD requests
+type compressionHandler struct {
+	handler http.Handler
+}
+
+func SetCompressionHandler(h http.Handler, _ ObjectLayer) http.Handler {
+	return compressionHandler{h}
+}
+
+func (h compressionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if helper.CONFIG.DisableResponseCompression || r.Method == http.MethodHead ||
+		!acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	gw := &gzipResponseWriter{ResponseWriter: w}
+	defer gw.close()
+	h.handler.ServeHTTP(gw, r)
+}
+
+// Status code of a compressible response is held until its body is written,
+// so responses without body are sent as is
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	gz          *gzip.Writer
+}
+
+func (w *gzipResponseWriter) compressible(status int) bool {
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Length") != "" || header.Get("Content-Range") != "" ||
+		header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	return contentType == "" || strings.Contains(contentType, "xml")
+}
+
+func (w *gzipResponseWriter) writeHeader(status int, compressed bool) {
+	if compressed {
+		header := w.Header()
+		// would be sniffed from compressed data otherwise
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/xml")
+		}
+		header.Set("Content-Encoding", "gzip")
+		// for caches in between
+		header.Add("Vary", "Accept-Encoding")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+	w.wroteHeader = true
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.status != 0 {
+		return
+	}
+	if w.compressible(status) {
+		w.status = status
+		return
+	}
+	w.writeHeader(status, false)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		status := w.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.writeHeader(status, len(p) != 0 && w.compressible(status))
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if !w.wroteHeader && w.status != 0 {
+		w.writeHeader(w.status, false)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) close() {
+	if !w.wroteHeader && w.status != 0 {
+		w.writeHeader(w.status, false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
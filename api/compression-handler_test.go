@@ -0,0 +1,88 @@
+package api
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+)
+
+func TestCompressionHandler(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	listing := EncodeResponse(ListBucketsResponse{})
+	// for request id of error responses
+	handler := SetLogHandler(SetCompressionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xml":
+			WriteSuccessResponse(w, listing)
+		case "/error":
+			WriteErrorResponse(w, r, ErrNoSuchKey)
+		case "/empty":
+			WriteSuccessResponse(w, nil)
+		case "/object":
+			w.Header().Set("Content-Type", "application/xml")
+			w.Header().Set("Content-Length", "4")
+			w.Write([]byte("hehe"))
+		}
+	}), nil), nil)
+	cases := []struct {
+		method, path, acceptEncoding string
+		compressed                   bool
+	}{
+		{"GET", "/xml", "gzip", true},
+		{"GET", "/xml", "gzip;q=0", false},
+		{"GET", "/xml", "", false},
+		{"GET", "/error", "deflate, gzip", true},
+		{"HEAD", "/error", "gzip", false},
+		{"PUT", "/empty", "gzip", false},
+		{"GET", "/object", "gzip", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		compressed := recorder.Header().Get("Content-Encoding") == "gzip"
+		if compressed != c.compressed {
+			t.Errorf("%s %s with %q: expected compressed %v, got headers %v", c.method, c.path,
+				c.acceptEncoding, c.compressed, recorder.Header())
+			continue
+		}
+		if !compressed {
+			continue
+		}
+		if recorder.Header().Get("Content-Type") != "application/xml" ||
+			recorder.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: unexpected headers %v", c.path, recorder.Header())
+		}
+		reader, err := gzip.NewReader(recorder.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.path == "/xml" && string(body) != string(listing) {
+			t.Errorf("unexpected response %q", body)
+		}
+	}
+
+	helper.CONFIG.DisableResponseCompression = true
+	defer func() {
+		helper.CONFIG.DisableResponseCompression = false
+	}()
+	r := httptest.NewRequest("GET", "/xml", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	if recorder.Header().Get("Content-Encoding") != "" || recorder.Body.String() != string(listing) {
+		t.Errorf("response should not be compressed if disabled, got %v", recorder.Header())
+	}
+}
@@ -50,7 +50,8 @@ const (
 
 type Acl struct {
 	CannedAcl string
-	// TODO fancy ACLs
+	// Explicit grants, only used when CannedAcl is empty
+	Grants []Grant `json:",omitempty"`
 }
 
 type AccessControlPolicy struct {
@@ -62,13 +63,13 @@ type AccessControlPolicy struct {
 }
 
 type Grant struct {
-        XMLName                   xml.Name `xml:"Grant"`
+        XMLName                   xml.Name `xml:"Grant" json:"-"`
 	Grantee                   Grantee  `xml:"Grantee"`
 	Permission                string   `xml:"Permission"`
 }
 
 type Grantee struct {
-        XMLName                   xml.Name `xml:"Grantee"`
+        XMLName                   xml.Name `xml:"Grantee" json:"-"`
 	XmlnsXsi                  string   `xml:"xmlns xsi,attr"`
         XsiType                   string   `xml:"http://www.w3.org/2001/XMLSchema-instance type,attr"`
 	URI                       string   `xml:"URI,omitempty"`
@@ -76,6 +77,78 @@ type Grantee struct {
 	DisplayName               string   `xml:"DisplayName,omitempty"`
 }
 
+var validPermissions = []string{
+	ACL_PERM_READ,
+	ACL_PERM_WRITE,
+	ACL_PERM_READ_ACP,
+	ACL_PERM_WRITE_ACP,
+	ACL_PERM_FULL_CONTROL,
+}
+
+// Maximum number of explicit grants of a bucket or an object
+const MAX_ACL_GRANTS = 100
+
+// Check grantee types and permissions of explicit grants
+func IsValidGrants(grants []Grant) (err error) {
+	if len(grants) > MAX_ACL_GRANTS {
+		return ErrMalformedACLError
+	}
+	for _, grant := range grants {
+		if !helper.StringInSlice(grant.Permission, validPermissions) {
+			return ErrInvalidAcl
+		}
+		switch grant.Grantee.XsiType {
+		case ACL_TYPE_CANON_USER:
+			if grant.Grantee.ID == "" {
+				return ErrInvalidAcl
+			}
+		case ACL_TYPE_GROUP:
+			if grant.Grantee.URI != ACL_GROUP_TYPE_ALL_USERS &&
+				grant.Grantee.URI != ACL_GROUP_TYPE_AUTHENTICATED_USERS {
+				return ErrInvalidAcl
+			}
+		default:
+			return ErrMalformedACLError
+		}
+	}
+	return nil
+}
+
+// Returns true if any grant gives `permission` to the user, FULL_CONTROL
+// implies all permissions. `canonicalUserId` is empty for anonymous users.
+func GrantsAllow(grants []Grant, canonicalUserId string, permission string) bool {
+	for _, grant := range grants {
+		if grant.Permission != permission && grant.Permission != ACL_PERM_FULL_CONTROL {
+			continue
+		}
+		switch grant.Grantee.XsiType {
+		case ACL_TYPE_CANON_USER:
+			if canonicalUserId != "" && grant.Grantee.ID == canonicalUserId {
+				return true
+			}
+		case ACL_TYPE_GROUP:
+			if grant.Grantee.URI == ACL_GROUP_TYPE_ALL_USERS {
+				return true
+			}
+			if grant.Grantee.URI == ACL_GROUP_TYPE_AUTHENTICATED_USERS && canonicalUserId != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Build policy from owner and explicit grants, used when CannedAcl is empty
+func CreatePolicyFromGrants(owner Owner, acl Acl) (policy AccessControlPolicy) {
+	policy.ID = owner.ID
+	policy.DisplayName = owner.DisplayName
+	for _, grant := range acl.Grants {
+		grant.Grantee.XmlnsXsi = XMLNSXSI
+		policy.AccessControlList = append(policy.AccessControlList, grant)
+	}
+	return
+}
+
 func IsValidCannedAcl(acl Acl) (err error) {
 	if !helper.StringInSlice(acl.CannedAcl, ValidCannedAcl) {
 		err = ErrInvalidCannedAcl
@@ -83,50 +156,44 @@ func IsValidCannedAcl(acl Acl) (err error) {
 	}
 	return
 }
-// the function will be deleted, because we will use AccessControlPolicy instead canned acl stored in hbase
+// Canned ACL `policy` is equivalent to, i.e. what CreatePolicyFromCanned
+// builds for owner of the policy. ErrUnsupportedAcl is returned for policies
+// with other explicit grants, which should be kept as they are
 func GetCannedAclFromPolicy(policy AccessControlPolicy) (acl Acl, err error) {
-	aclOwner := Owner{ID: policy.ID, DisplayName: policy.DisplayName}
-	var canonUser bool
-	var group bool
+	var ownerGranted bool
+	groupGrants := make(map[string]bool) // URI + " " + permission
 	for _, grant := range policy.AccessControlList {
 		switch grant.Grantee.XsiType {
 		case ACL_TYPE_CANON_USER:
-			if grant.Grantee.ID != aclOwner.ID {
-				return acl, ErrUnsupportedAcl
-			}
-			if grant.Permission != ACL_PERM_FULL_CONTROL {
+			if grant.Grantee.ID != policy.ID || grant.Permission != ACL_PERM_FULL_CONTROL {
 				return acl, ErrUnsupportedAcl
 			}
-			canonUser = true
+			ownerGranted = true
 		case ACL_TYPE_GROUP:
-			if grant.Grantee.URI == ACL_GROUP_TYPE_ALL_USERS {
-				if grant.Permission != ACL_PERM_READ {
-					return acl, ErrUnsupportedAcl
-				}
-				acl = Acl{CannedAcl: ValidCannedAcl[CANNEDACL_PUBLIC_READ]}
-				group = true
-			} else if grant.Grantee.URI == ACL_GROUP_TYPE_AUTHENTICATED_USERS {
-				if grant.Permission != ACL_PERM_READ {
-					return acl, ErrUnsupportedAcl
-				}
-				acl = Acl{CannedAcl: ValidCannedAcl[CANNEDACL_AUTHENTICATED_READ]}
-				group = true
-			} else {
-				return acl, ErrUnsupportedAcl
-			}
+			groupGrants[grant.Grantee.URI+" "+grant.Permission] = true
 		default:
 			return acl, ErrUnsupportedAcl
 		}
 	}
-
-	if !canonUser {
+	if !ownerGranted {
 		return acl, ErrUnsupportedAcl
 	}
 
-	if !group {
-		acl = Acl{CannedAcl: ValidCannedAcl[CANNEDACL_PRIVATE]}
+	allUsersRead := groupGrants[ACL_GROUP_TYPE_ALL_USERS+" "+ACL_PERM_READ]
+	allUsersWrite := groupGrants[ACL_GROUP_TYPE_ALL_USERS+" "+ACL_PERM_WRITE]
+	authenticatedRead := groupGrants[ACL_GROUP_TYPE_AUTHENTICATED_USERS+" "+ACL_PERM_READ]
+	switch {
+	case len(groupGrants) == 0:
+		acl.CannedAcl = ValidCannedAcl[CANNEDACL_PRIVATE]
+	case len(groupGrants) == 1 && allUsersRead:
+		acl.CannedAcl = ValidCannedAcl[CANNEDACL_PUBLIC_READ]
+	case len(groupGrants) == 2 && allUsersRead && allUsersWrite:
+		acl.CannedAcl = ValidCannedAcl[CANNEDACL_PUBLIC_READ_WRITE]
+	case len(groupGrants) == 1 && authenticatedRead:
+		acl.CannedAcl = ValidCannedAcl[CANNEDACL_AUTHENTICATED_READ]
+	default:
+		return acl, ErrUnsupportedAcl
 	}
-
 	return acl, nil
 }
 
@@ -0,0 +1,123 @@
+package datatype
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/journeymidnight/yig/error"
+)
+
+func TestGrantsAllow(t *testing.T) {
+	grants := []Grant{
+		{
+			Grantee:    Grantee{XsiType: ACL_TYPE_CANON_USER, ID: "alice"},
+			Permission: ACL_PERM_READ,
+		},
+		{
+			Grantee:    Grantee{XsiType: ACL_TYPE_CANON_USER, ID: "bob"},
+			Permission: ACL_PERM_FULL_CONTROL,
+		},
+		{
+			Grantee:    Grantee{XsiType: ACL_TYPE_GROUP, URI: ACL_GROUP_TYPE_AUTHENTICATED_USERS},
+			Permission: ACL_PERM_READ_ACP,
+		},
+	}
+	cases := []struct {
+		user       string
+		permission string
+		allowed    bool
+	}{
+		{"alice", ACL_PERM_READ, true},
+		{"alice", ACL_PERM_WRITE, false},
+		{"bob", ACL_PERM_WRITE, true},
+		{"carol", ACL_PERM_READ, false},
+		{"carol", ACL_PERM_READ_ACP, true},
+		{"", ACL_PERM_READ_ACP, false},
+	}
+	for _, c := range cases {
+		if GrantsAllow(grants, c.user, c.permission) != c.allowed {
+			t.Errorf("GrantsAllow(%q, %s) should be %v", c.user, c.permission, c.allowed)
+		}
+	}
+
+	public := []Grant{{
+		Grantee:    Grantee{XsiType: ACL_TYPE_GROUP, URI: ACL_GROUP_TYPE_ALL_USERS},
+		Permission: ACL_PERM_READ,
+	}}
+	if !GrantsAllow(public, "", ACL_PERM_READ) {
+		t.Error("AllUsers grant should allow anonymous users")
+	}
+}
+
+func TestGrantsJsonRoundTrip(t *testing.T) {
+	acl := Acl{Grants: []Grant{{
+		Grantee:    Grantee{XsiType: ACL_TYPE_CANON_USER, ID: "alice"},
+		Permission: ACL_PERM_WRITE,
+	}}}
+	data, err := json.Marshal(acl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Acl
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Grants) != 1 || decoded.Grants[0].Grantee.ID != "alice" ||
+		decoded.Grants[0].Permission != ACL_PERM_WRITE {
+		t.Errorf("Unexpected grants after decoding: %+v", decoded.Grants)
+	}
+	if IsValidGrants(decoded.Grants) != nil {
+		t.Error("Grants should be valid")
+	}
+}
+
+func TestCannedAclPolicyRoundTrip(t *testing.T) {
+	owner := Owner{ID: "alice", DisplayName: "alice"}
+	for _, canned := range []string{"private", "public-read", "public-read-write",
+		"authenticated-read"} {
+
+		policy, err := CreatePolicyFromCanned(owner, owner, Acl{CannedAcl: canned})
+		if err != nil {
+			t.Fatal(err)
+		}
+		acl, err := GetCannedAclFromPolicy(policy)
+		if err != nil || acl.CannedAcl != canned {
+			t.Errorf("%s: got %s, %v", canned, acl.CannedAcl, err)
+		}
+	}
+
+	// explicit grants are not folded into a canned ACL
+	grants := [][]Grant{
+		{ // another user
+			{Grantee: Grantee{XsiType: ACL_TYPE_CANON_USER, ID: "bob"},
+				Permission: ACL_PERM_READ},
+		},
+		{ // both groups, which used to be taken as authenticated-read
+			{Grantee: Grantee{XsiType: ACL_TYPE_GROUP, URI: ACL_GROUP_TYPE_ALL_USERS},
+				Permission: ACL_PERM_READ},
+			{Grantee: Grantee{XsiType: ACL_TYPE_GROUP, URI: ACL_GROUP_TYPE_AUTHENTICATED_USERS},
+				Permission: ACL_PERM_READ},
+		},
+		{
+			{Grantee: Grantee{XsiType: ACL_TYPE_GROUP, URI: ACL_GROUP_TYPE_ALL_USERS},
+				Permission: ACL_PERM_READ_ACP},
+		},
+	}
+	for _, g := range grants {
+		policy, _ := CreatePolicyFromCanned(owner, owner, Acl{CannedAcl: "private"})
+		policy.AccessControlList = append(policy.AccessControlList, g...)
+		if _, err := GetCannedAclFromPolicy(policy); err != ErrUnsupportedAcl {
+			t.Errorf("%+v: expected ErrUnsupportedAcl, got %v", g, err)
+		}
+		acl := Acl{Grants: policy.AccessControlList}
+		if IsValidGrants(acl.Grants) != nil {
+			t.Errorf("%+v: grants should be valid", g)
+		}
+		decoded := CreatePolicyFromGrants(owner, acl)
+		if len(decoded.AccessControlList) != len(policy.AccessControlList) ||
+			decoded.ID != owner.ID {
+			t.Errorf("%+v: policy from grants differs: %+v", g, decoded)
+		}
+	}
+}
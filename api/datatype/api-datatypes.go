@@ -22,9 +22,10 @@ import (
 )
 
 const (
-	MaxObjectList  = 1000 // Limit number of objects in a listObjectsResponse.
-	MaxUploadsList = 1000 // Limit number of uploads in a listUploadsResponse.
-	MaxPartsList   = 1000 // Limit number of parts in a listPartsResponse.
+	MaxObjectList  = 1000  // Limit number of objects in a listObjectsResponse.
+	MaxUploadsList = 1000  // Limit number of uploads in a listUploadsResponse.
+	MaxPartsList   = 1000  // Limit number of parts in a listPartsResponse.
+	MaxBucketsList = 10000 // Limit number of buckets in a listBucketsResponse.
 )
 
 // LocationResponse - format for location response.
@@ -96,6 +97,11 @@ type ListObjectsRequest struct {
 	VersionIdMarker string
 }
 
+type ListBucketsRequest struct {
+	MaxBuckets        int // all buckets are listed if 0
+	ContinuationToken string
+}
+
 type ListUploadsRequest struct {
 	Delimiter      string
 	EncodingType   string
@@ -170,6 +176,30 @@ type ListBucketsResponse struct {
 	Buckets struct {
 		Buckets []Bucket `xml:"Bucket"`
 	} // Buckets are nested
+	// set if there're more buckets to list
+	ContinuationToken string `xml:",omitempty"`
+}
+
+// GetObjectAttributesResponse - format for GetObjectAttributes response, only
+// attributes asked for in x-amz-object-attributes are set
+type GetObjectAttributesResponse struct {
+	XMLName      xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ GetObjectAttributesResponse" json:"-"`
+	ETag         string           `xml:",omitempty"`
+	Checksum     *ObjectChecksum  `xml:",omitempty"`
+	ObjectParts  *ObjectPartsInfo `xml:",omitempty"`
+	StorageClass string           `xml:",omitempty"`
+	ObjectSize   *int64           `xml:",omitempty"`
+}
+
+type ObjectChecksum struct {
+	ChecksumCRC32  string `xml:",omitempty"`
+	ChecksumCRC32C string `xml:",omitempty"`
+	ChecksumSHA1   string `xml:",omitempty"`
+	ChecksumSHA256 string `xml:",omitempty"`
+}
+
+type ObjectPartsInfo struct {
+	TotalPartsCount int
 }
 
 // Upload container for in progress multipart upload
@@ -202,7 +232,8 @@ type Object struct {
 	ETag         string
 	Size         int64
 
-	Owner Owner
+	// omitted by ListObjectsV2 unless fetch-owner is set
+	Owner *Owner `xml:",omitempty"`
 
 	// The class of storage used to store the object.
 	StorageClass string
@@ -309,6 +340,13 @@ type PutObjectResult struct {
 	Md5          string
 	VersionId    string
 	LastModified time.Time
+	SseType      string // resolved SSE type, could come from bucket default encryption
+}
+
+type AppendObjectResult struct {
+	Md5          string // ETag of the whole object after appending
+	NextPosition int64
+	LastModified time.Time
 }
 
 type DeleteObjectResult struct {
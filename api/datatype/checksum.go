@@ -0,0 +1,44 @@
+package datatype
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"hash/crc32"
+)
+
+// Algorithms of x-amz-checksum-* headers
+const (
+	CHECKSUM_CRC32  = "CRC32"
+	CHECKSUM_CRC32C = "CRC32C"
+	CHECKSUM_SHA1   = "SHA1"
+	CHECKSUM_SHA256 = "SHA256"
+)
+
+var ChecksumAlgorithms = []string{CHECKSUM_CRC32, CHECKSUM_CRC32C, CHECKSUM_SHA1,
+	CHECKSUM_SHA256}
+
+// Hashes of payload declared by client, verified once payload is stored
+type Checksums struct {
+	// hex encoded, from x-amz-content-sha256 if it's not UNSIGNED-PAYLOAD
+	// or of streaming uploads
+	ContentSha256 string
+	// one of ChecksumAlgorithms, empty if no x-amz-checksum-* is set
+	Algorithm string
+	Checksum  string // base64 encoded
+}
+
+// nil if `algorithm` is unknown. Digests of CRCs are big-endian, as S3 does
+func NewChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case CHECKSUM_CRC32:
+		return crc32.NewIEEE()
+	case CHECKSUM_CRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case CHECKSUM_SHA1:
+		return sha1.New()
+	case CHECKSUM_SHA256:
+		return sha256.New()
+	}
+	return nil
+}
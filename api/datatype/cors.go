@@ -3,7 +3,6 @@ package datatype
 import (
 	"encoding/xml"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 
@@ -24,33 +23,23 @@ type CorsRule struct {
 	ExposedHeaders []string `xml:"ExposeHeader"`
 }
 
-func matchOrigin(urlStr string, allowedOrigin string) bool {
+// One "*" is allowed in `allowedOrigin`, which matches any characters as
+// S3 does, e.g. "http://*.example.com" or "https://*". Allowed origins
+// without scheme match "http" origins only
+func matchOrigin(origin string, allowedOrigin string) bool {
 	if allowedOrigin == "*" {
 		return true
 	}
-	allowedUrl, err := url.Parse(allowedOrigin)
-	if err != nil {
-		return false
+	if !strings.Contains(allowedOrigin, "://") {
+		allowedOrigin = "http://" + allowedOrigin
 	}
-	url, err := url.Parse(urlStr)
-	if err != nil {
-		return false
+	allowedOrigin = strings.TrimSuffix(allowedOrigin, "/")
+	split := strings.Split(allowedOrigin, "*")
+	if len(split) == 1 {
+		return origin == allowedOrigin
 	}
-	if allowedUrl.Scheme == url.Scheme ||
-		(allowedUrl.Scheme == "" && url.Scheme == "http") {
-		split := strings.Split(allowedUrl.Host, "*")
-		if len(split) == 1 { // no "*" in allowed origin
-			if url.Host == allowedUrl.Host {
-				return true
-			}
-		} else if len(split) == 2 { // one "*" in allowed origin
-			if strings.HasPrefix(url.Host, split[0]) &&
-				strings.HasSuffix(url.Host, split[1]) {
-				return true
-			}
-		}
-	}
-	return false
+	return len(split) == 2 && len(origin) >= len(split[0])+len(split[1]) &&
+		strings.HasPrefix(origin, split[0]) && strings.HasSuffix(origin, split[1])
 }
 
 func (rule CorsRule) MatchSimple(r *http.Request) (matched bool) {
@@ -65,10 +54,52 @@ func (rule CorsRule) MatchSimple(r *http.Request) (matched bool) {
 	return false
 }
 
+// Header names are case insensitive, one "*" is allowed in `allowedHeader`
+func matchHeader(header string, allowedHeader string) bool {
+	header = strings.ToLower(header)
+	split := strings.Split(strings.ToLower(allowedHeader), "*")
+	if len(split) == 1 {
+		return header == split[0]
+	}
+	return len(split) == 2 && len(header) >= len(split[0])+len(split[1]) &&
+		strings.HasPrefix(header, split[0]) && strings.HasSuffix(header, split[1])
+}
+
+// Headers listed in "Access-Control-Request-Headers" of a preflight
+func requestedHeaders(r *http.Request) (headers []string) {
+	for _, value := range r.Header["Access-Control-Request-Headers"] {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, header)
+			}
+		}
+	}
+	return
+}
+
+func (rule CorsRule) allowsHeaders(headers []string) bool {
+	for _, header := range headers {
+		allowed := false
+		for _, allowedHeader := range rule.AllowedHeaders {
+			if matchHeader(header, allowedHeader) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
 func (rule CorsRule) MatchPreflight(r *http.Request) (matched bool) {
 	if !helper.StringInSlice(r.Header.Get("Access-Control-Request-Method"), rule.AllowedMethods) {
 		return false
 	}
+	if !rule.allowsHeaders(requestedHeaders(r)) {
+		return false
+	}
 	for _, origin := range rule.AllowedOrigins {
 		if matchOrigin(r.Header.Get("Origin"), origin) {
 			return true
@@ -83,17 +114,19 @@ func (rule CorsRule) SetResponseHeaders(w http.ResponseWriter, r *http.Request,
 		// origin formats like "*.le.com" or "le*.com", so build a full
 		// URL for response
 		w.Header().Set("Access-Control-Allow-Origin", origin)
+		// as S3, only rules not allowing every origin allow credentials
+		if !helper.StringInSlice("*", rule.AllowedOrigins) {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
 	}
 	if len(rule.AllowedHeaders) > 0 {
-		if len(rule.AllowedHeaders) == 1 && rule.AllowedHeaders[0] == "*" {
-			requestHeaders, ok := r.Header["Access-Control-Request-Headers"]
-			if !ok {
-				w.Header().Set("Access-Control-Allow-Headers", "*")
-			} else {
-				for _, header := range requestHeaders {
-					w.Header().Add("Access-Control-Allow-Headers", header)
-				}
-			}
+		// headers requested by a matched preflight are all allowed, echo
+		// them since wildcards like "x-amz-*" are not understood by browsers
+		if requested := requestedHeaders(r); len(requested) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers",
+				strings.Join(requested, ", "))
+		} else if len(rule.AllowedHeaders) == 1 && rule.AllowedHeaders[0] == "*" {
+			w.Header().Set("Access-Control-Allow-Headers", "*")
 		} else {
 			for _, header := range rule.AllowedHeaders {
 				w.Header().Add("Access-Control-Allow-Headers", header)
@@ -0,0 +1,102 @@
+package datatype
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchHeader(t *testing.T) {
+	cases := []struct {
+		header, allowed string
+		expected        bool
+	}{
+		{"Content-Type", "content-type", true},
+		{"x-amz-date", "x-amz-*", true},
+		{"X-Amz-Date", "X-AMZ-*", true},
+		{"x-amz-", "x-amz-*", true},
+		{"authorization", "x-amz-*", false},
+		{"anything", "*", true},
+		{"x-amz", "x-amz-*", false},
+	}
+	for _, c := range cases {
+		if matchHeader(c.header, c.allowed) != c.expected {
+			t.Errorf("%s %s: expected %v", c.header, c.allowed, c.expected)
+		}
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	cases := []struct {
+		origin, allowed string
+		expected        bool
+	}{
+		{"http://www.example.com", "*", true},
+		{"http://www.example.com", "http://www.example.com", true},
+		{"http://www.example.com", "http://www.example.com/", true},
+		{"https://www.example.com", "http://www.example.com", false},
+		{"http://www.example.com:8080", "http://www.example.com", false},
+		{"http://www.example.com", "http://*.example.com", true},
+		{"http://example.com", "http://*.example.com", false},
+		{"http://www.example.com.evil.org", "http://*.example.com", false},
+		{"https://www.example.com", "https://*", true},
+		{"http://www.example.com", "*.example.com", true},
+		{"https://www.example.com", "*.example.com", false},
+		{"http://www.example.com", "www.example.com", true},
+		{"http://a.b.example.com", "http://a.*.com", true},
+		{"http://www.example.com", "http://*.*.com", false},
+	}
+	for _, c := range cases {
+		if matchOrigin(c.origin, c.allowed) != c.expected {
+			t.Errorf("%s %s: expected %v", c.origin, c.allowed, c.expected)
+		}
+	}
+}
+
+func preflight(method, headers string) *http.Request {
+	r, _ := http.NewRequest("OPTIONS", "http://s3.test.com/bucket/object", nil)
+	r.Header.Set("Origin", "http://www.example.com")
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return r
+}
+
+func TestMatchPreflight(t *testing.T) {
+	rule := CorsRule{
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedOrigins: []string{"http://*.example.com"},
+		AllowedHeaders: []string{"content-type", "x-amz-*"},
+		MaxAgeSeconds:  3000,
+	}
+	cases := []struct {
+		method, headers string
+		expected        bool
+	}{
+		{"GET", "", true},
+		{"PUT", "Content-Type, X-Amz-Date", true},
+		{"PUT", "content-type,x-amz-acl,authorization", false},
+		{"DELETE", "", false},
+	}
+	for _, c := range cases {
+		if rule.MatchPreflight(preflight(c.method, c.headers)) != c.expected {
+			t.Errorf("%s %s: expected %v", c.method, c.headers, c.expected)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := preflight("PUT", "Content-Type, X-Amz-Date")
+	rule.SetResponseHeaders(w, r, r.Header.Get("Origin"))
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":  "http://www.example.com",
+		"Access-Control-Allow-Methods": "GET, PUT",
+		"Access-Control-Allow-Headers": "Content-Type, X-Amz-Date",
+		"Access-Control-Max-Age":       "3000",
+	}
+	for header, value := range expected {
+		if w.Header().Get(header) != value {
+			t.Errorf("%s: expected %q, got %q", header, value, w.Header().Get(header))
+		}
+	}
+}
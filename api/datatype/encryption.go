@@ -0,0 +1,70 @@
+package datatype
+
+import (
+	"encoding/xml"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+const (
+	MAX_ENCRYPTION_SIZE = 16 << 10 // 16 KB
+)
+
+type ApplyServerSideEncryptionByDefault struct {
+	SseAlgorithm   string `xml:"SSEAlgorithm"`
+	KmsMasterKeyId string `xml:"KMSMasterKeyID,omitempty"`
+}
+
+type EncryptionRule struct {
+	ApplyServerSideEncryptionByDefault ApplyServerSideEncryptionByDefault
+}
+
+// Default server side encryption of a bucket, applied to objects uploaded
+// without SSE headers
+type Encryption struct {
+	XMLName xml.Name         `xml:"ServerSideEncryptionConfiguration" json:"-"`
+	Xmlns   string           `xml:"xmlns,attr,omitempty" json:"-"`
+	Rules   []EncryptionRule `xml:"Rule"`
+}
+
+func EncryptionFromXml(xmlBytes []byte) (encryption Encryption, err error) {
+	helper.Debugln("Incoming encryption XML:", string(xmlBytes))
+	err = xml.Unmarshal(xmlBytes, &encryption)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to unmarshal encryption XML")
+		return encryption, ErrInvalidEncryptionConfiguration
+	}
+	if len(encryption.Rules) != 1 {
+		return encryption, ErrInvalidEncryptionConfiguration
+	}
+	rule := encryption.Rules[0].ApplyServerSideEncryptionByDefault
+	switch rule.SseAlgorithm {
+	case "AES256":
+		if rule.KmsMasterKeyId != "" {
+			return encryption, ErrInvalidEncryptionConfiguration
+		}
+	case "aws:kms":
+		if rule.KmsMasterKeyId == "" {
+			return encryption, ErrInvalidEncryptionConfiguration
+		}
+		// same as "x-amz-server-side-encryption: aws:kms", KMS is not
+		// implemented yet
+		return encryption, ErrNotImplemented
+	default:
+		return encryption, ErrInvalidEncryptionConfiguration
+	}
+	return encryption, nil
+}
+
+// Synthesize SSE request from default encryption, returns an empty request
+// if no default encryption is set
+func (e Encryption) SseRequest() (request SseRequest) {
+	if len(e.Rules) == 0 {
+		return
+	}
+	if e.Rules[0].ApplyServerSideEncryptionByDefault.SseAlgorithm == "AES256" {
+		request.Type = "S3"
+	}
+	return
+}
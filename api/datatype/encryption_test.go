@@ -0,0 +1,56 @@
+package datatype
+
+import (
+	"io/ioutil"
+	"testing"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+)
+
+func TestEncryptionFromXml(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	cases := []struct {
+		xml string
+		err error
+	}{
+		{`<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault>
+<SSEAlgorithm>AES256</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule>
+</ServerSideEncryptionConfiguration>`, nil},
+		{`<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault>
+<SSEAlgorithm>aws:kms</SSEAlgorithm><KMSMasterKeyID>hehe</KMSMasterKeyID>
+</ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`, ErrNotImplemented},
+		{`<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault>
+<SSEAlgorithm>aws:kms</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule>
+</ServerSideEncryptionConfiguration>`, ErrInvalidEncryptionConfiguration},
+		{`<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault>
+<SSEAlgorithm>DES</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule>
+</ServerSideEncryptionConfiguration>`, ErrInvalidEncryptionConfiguration},
+		{`<ServerSideEncryptionConfiguration></ServerSideEncryptionConfiguration>`,
+			ErrInvalidEncryptionConfiguration},
+		{`hehe`, ErrInvalidEncryptionConfiguration},
+	}
+	for i, c := range cases {
+		_, err := EncryptionFromXml([]byte(c.xml))
+		if err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+func TestEncryptionSseRequest(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	if request := (Encryption{}).SseRequest(); request.Type != "" {
+		t.Errorf("no encryption expected without default, got %s", request.Type)
+	}
+	encryption, err := EncryptionFromXml([]byte(`<ServerSideEncryptionConfiguration>
+<Rule><ApplyServerSideEncryptionByDefault><SSEAlgorithm>AES256</SSEAlgorithm>
+</ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request := encryption.SseRequest(); request.Type != "S3" {
+		t.Errorf("expected SSE-S3, got %s", request.Type)
+	}
+}
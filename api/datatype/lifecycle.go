@@ -2,18 +2,182 @@ package datatype
 
 import (
 	"encoding/xml"
-//	. "github.com/journeymidnight/yig/error"
-//	"github.com/journeymidnight/yig/helper"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/journeymidnight/yig/error"
+	// "github.com/journeymidnight/yig/helper"
 )
 
 type LcRule struct {
-	ID string	`xml:"ID"`
-	Prefix string	`xml:"Prefix"`
-	Status string	`xml:"Status"`
-	Expiration string	`xml:"Expiration>Days"`
+	ID         string    `xml:"ID"`
+	Prefix     string    `xml:"Prefix"`
+	Status     string    `xml:"Status"`
+	Expiration string    `xml:"Expiration>Days"`
+	Filter     *LcFilter `xml:"Filter,omitempty" json:",omitempty"`
+	// objects are moved to StorageClass once they are Days old
+	Transitions []LcTransition `xml:"Transition,omitempty" json:",omitempty"`
+}
+
+type LcTransition struct {
+	Days         int    `xml:"Days"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// Objects are moved to STANDARD_IA no sooner than this, as AWS requires
+const MIN_STANDARD_IA_TRANSITION_DAYS = 30
+
+// Objects a rule applies to should have all tags in the filter, either one
+// in <Tag> or several in <And>
+type LcFilter struct {
+	Tag *TagFilter   `xml:"Tag,omitempty" json:",omitempty"`
+	And *LcFilterAnd `xml:"And,omitempty" json:",omitempty"`
+}
+
+type LcFilterAnd struct {
+	Tags []TagFilter `xml:"Tag"`
+}
+
+type TagFilter struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+func (rule LcRule) TagConditions() (tags []TagFilter) {
+	if rule.Filter == nil {
+		return nil
+	}
+	if rule.Filter.Tag != nil {
+		tags = append(tags, *rule.Filter.Tag)
+	}
+	if rule.Filter.And != nil {
+		tags = append(tags, rule.Filter.And.Tags...)
+	}
+	return tags
+}
+
+// Whether object tagged `tags` satisfies all tag conditions of the rule
+func (rule LcRule) MatchesTags(tags map[string]string) bool {
+	for _, condition := range rule.TagConditions() {
+		value, ok := tags[condition.Key]
+		if !ok || value != condition.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// Tag conditions should have keys, and keys in one rule should not repeat.
+// Transitions of a rule should go to different storage classes other than
+// STANDARD on different days
+func (lc Lc) Validate() error {
+	for _, rule := range lc.Rule {
+		keys := make(map[string]bool)
+		for _, condition := range rule.TagConditions() {
+			if condition.Key == "" || keys[condition.Key] {
+				return ErrInvalidLc
+			}
+			keys[condition.Key] = true
+		}
+		classes := make(map[string]bool)
+		days := make(map[int]bool)
+		for _, transition := range rule.Transitions {
+			if transition.StorageClass == STORAGE_CLASS_STANDARD ||
+				!IsValidStorageClass(transition.StorageClass) ||
+				classes[transition.StorageClass] || days[transition.Days] ||
+				transition.Days < 0 {
+				return ErrInvalidLc
+			}
+			if transition.StorageClass == STORAGE_CLASS_STANDARD_IA &&
+				transition.Days < MIN_STANDARD_IA_TRANSITION_DAYS {
+				return ErrInvalidLc
+			}
+			classes[transition.StorageClass] = true
+			days[transition.Days] = true
+		}
+	}
+	return nil
+}
+
+// Storage class an object of `storageClass` should be moved to when it's
+// `days` old, i.e. that of the latest transition due. Objects only move
+// along the transitions in order of their days, so ok is false if the
+// object is already there or further, or its storage class is not in the
+// rule at all
+func (rule LcRule) TransitionOf(storageClass string, days int) (target string, ok bool) {
+	transitions := append([]LcTransition{}, rule.Transitions...)
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].Days < transitions[j].Days
+	})
+	current, due := -1, -1
+	for i, transition := range transitions {
+		if transition.StorageClass == storageClass {
+			current = i
+		}
+		if transition.Days <= days {
+			due = i
+		}
+	}
+	if current == -1 && storageClass != "" && storageClass != STORAGE_CLASS_STANDARD {
+		return "", false
+	}
+	if due <= current {
+		return "", false
+	}
+	return transitions[due].StorageClass, true
 }
 
 type Lc struct {
 	XMLName xml.Name `xml:"LifecycleConfiguration"`
-	Rule []LcRule `xml:"Rule"`
-}
\ No newline at end of file
+	Rule    []LcRule `xml:"Rule"`
+}
+
+// Rule applying to object of `objectName` tagged `tags`, rules with a prefix
+// of the name take precedence over the one with empty prefix, the same way
+// tools/lc.go picks. Rules whose tag conditions are not satisfied are skipped.
+func (lc Lc) RuleOf(objectName string, tags map[string]string) (rule LcRule, ok bool) {
+	var defaultRule, matchedRule *LcRule
+	for i := range lc.Rule {
+		if !lc.Rule[i].MatchesTags(tags) {
+			continue
+		}
+		if lc.Rule[i].Prefix == "" {
+			defaultRule = &lc.Rule[i]
+		} else if strings.HasPrefix(objectName, lc.Rule[i].Prefix) {
+			matchedRule = &lc.Rule[i]
+		}
+	}
+	if matchedRule == nil {
+		matchedRule = defaultRule
+	}
+	if matchedRule == nil {
+		return
+	}
+	return *matchedRule, true
+}
+
+// Rule expiring object of `objectName` tagged `tags`, see RuleOf.
+// ok is false if no rule applies or expiration days is not positive.
+func (lc Lc) ExpirationRule(objectName string, tags map[string]string) (rule LcRule, days int,
+	ok bool) {
+
+	rule, ok = lc.RuleOf(objectName, tags)
+	if !ok {
+		return
+	}
+	days, ok = rule.ExpirationDays()
+	if !ok {
+		return LcRule{}, 0, false
+	}
+	return
+}
+
+// ok is false if the rule expires nothing, e.g. it only has transitions
+func (rule LcRule) ExpirationDays() (days int, ok bool) {
+	days, err := strconv.Atoi(rule.Expiration)
+	if err != nil || days <= 0 {
+		return 0, false
+	}
+	return days, true
+}
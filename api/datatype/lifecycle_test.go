@@ -0,0 +1,126 @@
+package datatype
+
+import (
+	"encoding/xml"
+	"testing"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+func TestLcTagConditions(t *testing.T) {
+	body := `<LifecycleConfiguration>
+<Rule><ID>temp</ID><Prefix></Prefix><Status>Enabled</Status>
+<Filter><Tag><Key>env</Key><Value>temp</Value></Tag></Filter>
+<Expiration><Days>1</Days></Expiration></Rule>
+<Rule><ID>logs</ID><Prefix>logs/</Prefix><Status>Enabled</Status>
+<Filter><And><Tag><Key>env</Key><Value>test</Value></Tag>
+<Tag><Key>team</Key><Value>hehe</Value></Tag></And></Filter>
+<Expiration><Days>7</Days></Expiration></Rule>
+</LifecycleConfiguration>`
+	var lc Lc
+	if err := xml.Unmarshal([]byte(body), &lc); err != nil {
+		t.Fatal(err)
+	}
+	if err := lc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lc.Rule[0].TagConditions()) != 1 || len(lc.Rule[1].TagConditions()) != 2 {
+		t.Fatalf("tag conditions parsed wrong: %+v", lc.Rule)
+	}
+
+	cases := []struct {
+		name string
+		tags map[string]string
+		rule string // empty if no rule applies
+	}{
+		{"hehe", nil, ""},
+		{"hehe", map[string]string{"env": "prod"}, ""},
+		{"hehe", map[string]string{"env": "temp", "team": "hehe"}, "temp"},
+		{"logs/hehe", map[string]string{"env": "test", "team": "hehe"}, "logs"},
+		// all tags of <And> are required
+		{"logs/hehe", map[string]string{"env": "test"}, ""},
+		{"logs/hehe", map[string]string{"env": "temp"}, "temp"},
+	}
+	for _, c := range cases {
+		rule, _, ok := lc.ExpirationRule(c.name, c.tags)
+		if ok != (c.rule != "") || rule.ID != c.rule {
+			t.Errorf("%s %v: expected rule %q, got %q", c.name, c.tags, c.rule, rule.ID)
+		}
+	}
+
+	lc.Rule[1].Filter.And.Tags[1].Key = "env"
+	if err := lc.Validate(); err != ErrInvalidLc {
+		t.Errorf("repeated tag keys should be rejected, got %v", err)
+	}
+}
+
+func TestLcTransitions(t *testing.T) {
+	pools := helper.CONFIG.StorageClassPools
+	defer func() {
+		helper.CONFIG.StorageClassPools = pools
+	}()
+	helper.CONFIG.StorageClassPools = map[string]string{
+		"STANDARD_IA": "rabbit-ia",
+		"GLACIER":     "rabbit-glacier",
+	}
+	body := `<LifecycleConfiguration>
+<Rule><ID>archive</ID><Prefix></Prefix><Status>Enabled</Status>
+<Transition><Days>90</Days><StorageClass>GLACIER</StorageClass></Transition>
+<Transition><Days>30</Days><StorageClass>STANDARD_IA</StorageClass></Transition>
+</Rule>
+</LifecycleConfiguration>`
+	var lc Lc
+	if err := xml.Unmarshal([]byte(body), &lc); err != nil {
+		t.Fatal(err)
+	}
+	if err := lc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	rule := lc.Rule[0]
+	if _, ok := rule.ExpirationDays(); ok {
+		t.Error("rule with transitions only should expire nothing")
+	}
+	if _, _, ok := lc.ExpirationRule("hehe", nil); ok {
+		t.Error("rule with transitions only should not be an expiration rule")
+	}
+
+	cases := []struct {
+		storageClass string
+		days         int
+		target       string // empty if not moved
+	}{
+		{"", 29, ""},
+		{"", 30, "STANDARD_IA"},
+		{"STANDARD", 89, "STANDARD_IA"},
+		{"STANDARD", 90, "GLACIER"},
+		{"STANDARD_IA", 89, ""},
+		{"STANDARD_IA", 90, "GLACIER"},
+		// never moved back
+		{"GLACIER", 30, ""},
+		{"GLACIER", 100, ""},
+		{"REDUCED_REDUNDANCY", 100, ""},
+	}
+	for _, c := range cases {
+		target, ok := rule.TransitionOf(c.storageClass, c.days)
+		if ok != (c.target != "") || target != c.target {
+			t.Errorf("%s of %d days: expected %q, got %q %v", c.storageClass, c.days,
+				c.target, target, ok)
+		}
+	}
+
+	invalid := [][]LcTransition{
+		{{Days: 30, StorageClass: "STANDARD"}},
+		{{Days: 30, StorageClass: "ONEZONE_IA"}},
+		{{Days: 29, StorageClass: "STANDARD_IA"}},
+		{{Days: -1, StorageClass: "GLACIER"}},
+		{{Days: 30, StorageClass: "STANDARD_IA"}, {Days: 60, StorageClass: "STANDARD_IA"}},
+		{{Days: 30, StorageClass: "STANDARD_IA"}, {Days: 30, StorageClass: "GLACIER"}},
+	}
+	for _, transitions := range invalid {
+		lc := Lc{Rule: []LcRule{{ID: "hehe", Status: "Enabled", Transitions: transitions}}}
+		if err := lc.Validate(); err != ErrInvalidLc {
+			t.Errorf("transitions %v should be rejected, got %v", transitions, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package datatype
+
+import (
+	"encoding/xml"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+const (
+	MAX_OBJECT_LOCK_SIZE = 16 << 10 // 16 KB
+
+	// Retention in GOVERNANCE mode could be bypassed by bucket owner with
+	// "x-amz-bypass-governance-retention" header, while COMPLIANCE could not
+	RETENTION_MODE_GOVERNANCE = "GOVERNANCE"
+	RETENTION_MODE_COMPLIANCE = "COMPLIANCE"
+
+	LEGAL_HOLD_ON  = "ON"
+	LEGAL_HOLD_OFF = "OFF"
+)
+
+func isValidRetentionMode(mode string) bool {
+	return mode == RETENTION_MODE_GOVERNANCE || mode == RETENTION_MODE_COMPLIANCE
+}
+
+type DefaultRetention struct {
+	Mode  string
+	Days  int `xml:",omitempty"`
+	Years int `xml:",omitempty"`
+}
+
+type ObjectLockRule struct {
+	DefaultRetention DefaultRetention
+}
+
+type ObjectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration" json:"-"`
+	Xmlns             string          `xml:"xmlns,attr,omitempty" json:"-"`
+	ObjectLockEnabled string          `xml:",omitempty"`
+	Rule              *ObjectLockRule `xml:",omitempty"`
+}
+
+func ObjectLockConfigurationFromXml(xmlBytes []byte) (config ObjectLockConfiguration, err error) {
+	helper.Debugln("Incoming object lock XML:", string(xmlBytes))
+	err = xml.Unmarshal(xmlBytes, &config)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to unmarshal object lock XML")
+		return config, ErrMalformedXML
+	}
+	if config.ObjectLockEnabled != "Enabled" {
+		return config, ErrMalformedXML
+	}
+	if config.Rule != nil {
+		retention := config.Rule.DefaultRetention
+		if !isValidRetentionMode(retention.Mode) {
+			return config, ErrMalformedXML
+		}
+		// exactly one of Days and Years should be set
+		if (retention.Days > 0) == (retention.Years > 0) ||
+			retention.Days < 0 || retention.Years < 0 {
+			return config, ErrMalformedXML
+		}
+	}
+	return config, nil
+}
+
+func (c ObjectLockConfiguration) IsEnabled() bool {
+	return c.ObjectLockEnabled == "Enabled"
+}
+
+// Retention applied to new objects without explicit retention,
+// returns zero time if bucket has no default retention
+func (c ObjectLockConfiguration) DefaultRetention(now time.Time) (mode string, retainUntil time.Time) {
+	if !c.IsEnabled() || c.Rule == nil {
+		return
+	}
+	retention := c.Rule.DefaultRetention
+	return retention.Mode, now.AddDate(retention.Years, 0, retention.Days)
+}
+
+type ObjectRetention struct {
+	XMLName         xml.Name `xml:"Retention"`
+	Xmlns           string   `xml:"xmlns,attr,omitempty"`
+	Mode            string
+	RetainUntilDate time.Time
+}
+
+func ObjectRetentionFromXml(xmlBytes []byte, now time.Time) (retention ObjectRetention, err error) {
+	helper.Debugln("Incoming retention XML:", string(xmlBytes))
+	err = xml.Unmarshal(xmlBytes, &retention)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to unmarshal retention XML")
+		return retention, ErrMalformedXML
+	}
+	if !isValidRetentionMode(retention.Mode) || !retention.RetainUntilDate.After(now) {
+		return retention, ErrMalformedXML
+	}
+	return retention, nil
+}
+
+type ObjectLegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Status  string
+}
+
+func ObjectLegalHoldFromXml(xmlBytes []byte) (legalHold ObjectLegalHold, err error) {
+	helper.Debugln("Incoming legal hold XML:", string(xmlBytes))
+	err = xml.Unmarshal(xmlBytes, &legalHold)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to unmarshal legal hold XML")
+		return legalHold, ErrMalformedXML
+	}
+	if legalHold.Status != LEGAL_HOLD_ON && legalHold.Status != LEGAL_HOLD_OFF {
+		return legalHold, ErrMalformedXML
+	}
+	return legalHold, nil
+}
@@ -0,0 +1,73 @@
+package datatype
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+)
+
+func TestObjectLockConfigurationFromXml(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	cases := []struct {
+		xml string
+		err error
+	}{
+		{`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled>
+</ObjectLockConfiguration>`, nil},
+		{`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled>
+<Rule><DefaultRetention><Mode>COMPLIANCE</Mode><Days>1</Days></DefaultRetention></Rule>
+</ObjectLockConfiguration>`, nil},
+		{`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled>
+<Rule><DefaultRetention><Mode>GOVERNANCE</Mode><Days>1</Days><Years>1</Years>
+</DefaultRetention></Rule></ObjectLockConfiguration>`, ErrMalformedXML},
+		{`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled>
+<Rule><DefaultRetention><Mode>hehe</Mode><Days>1</Days></DefaultRetention></Rule>
+</ObjectLockConfiguration>`, ErrMalformedXML},
+		{`<ObjectLockConfiguration><ObjectLockEnabled>Disabled</ObjectLockEnabled>
+</ObjectLockConfiguration>`, ErrMalformedXML},
+		{`hehe`, ErrMalformedXML},
+	}
+	for i, c := range cases {
+		_, err := ObjectLockConfigurationFromXml([]byte(c.xml))
+		if err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+func TestObjectLockDefaultRetention(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	now := time.Now()
+	if mode, _ := (ObjectLockConfiguration{}).DefaultRetention(now); mode != "" {
+		t.Errorf("no retention expected without Object Lock, got %s", mode)
+	}
+	config, err := ObjectLockConfigurationFromXml([]byte(`<ObjectLockConfiguration>
+<ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention>
+<Mode>GOVERNANCE</Mode><Years>1</Years></DefaultRetention></Rule></ObjectLockConfiguration>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, retainUntil := config.DefaultRetention(now)
+	if mode != RETENTION_MODE_GOVERNANCE || !retainUntil.Equal(now.AddDate(1, 0, 0)) {
+		t.Errorf("unexpected default retention %s %v", mode, retainUntil)
+	}
+}
+
+func TestObjectRetentionFromXml(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	now, _ := time.Parse(time.RFC3339, "2019-01-01T00:00:00Z")
+	_, err := ObjectRetentionFromXml([]byte(`<Retention><Mode>COMPLIANCE</Mode>
+<RetainUntilDate>2020-01-01T00:00:00Z</RetainUntilDate></Retention>`), now)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = ObjectRetentionFromXml([]byte(`<Retention><Mode>COMPLIANCE</Mode>
+<RetainUntilDate>2018-01-01T00:00:00Z</RetainUntilDate></Retention>`), now)
+	if err != ErrMalformedXML {
+		t.Errorf("retain until date in the past should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package datatype
+
+import (
+	"encoding/xml"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+const (
+	MAX_REQUEST_PAYMENT_SIZE = 1024
+
+	PAYER_BUCKET_OWNER = "BucketOwner"
+	PAYER_REQUESTER    = "Requester"
+)
+
+// See https://docs.aws.amazon.com/AmazonS3/latest/dev/RequesterPaysBuckets.html
+type RequestPaymentConfiguration struct {
+	XMLName xml.Name `xml:"RequestPaymentConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Payer   string
+}
+
+func RequestPaymentFromXml(xmlBytes []byte) (payment RequestPaymentConfiguration, err error) {
+	helper.Debugln("Incoming request payment XML:", string(xmlBytes))
+	err = xml.Unmarshal(xmlBytes, &payment)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to unmarshal request payment XML")
+		return payment, ErrMalformedXML
+	}
+	if payment.Payer != PAYER_BUCKET_OWNER && payment.Payer != PAYER_REQUESTER {
+		return payment, ErrMalformedXML
+	}
+	return payment, nil
+}
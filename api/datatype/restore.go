@@ -0,0 +1,75 @@
+package datatype
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+// Restore of archived objects, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_RestoreObject.html
+// Data of all storage classes is online in Ceph, so a restore completes as
+// soon as it's requested, only expiry of the restored copy is recorded for
+// clients waiting on "x-amz-restore".
+
+const (
+	MAX_RESTORE_REQUEST_SIZE = 16 << 10 // 16 KB
+
+	RESTORE_TIER_EXPEDITED = "Expedited"
+	RESTORE_TIER_STANDARD  = "Standard"
+	RESTORE_TIER_BULK      = "Bulk"
+)
+
+type GlacierJobParameters struct {
+	Tier string
+}
+
+type RestoreRequest struct {
+	XMLName              xml.Name `xml:"RestoreRequest"`
+	Days                 int
+	GlacierJobParameters *GlacierJobParameters `xml:",omitempty"`
+}
+
+func RestoreRequestFromXml(xmlBytes []byte) (request RestoreRequest, err error) {
+	helper.Debugln("Incoming restore request XML:", string(xmlBytes))
+	err = xml.Unmarshal(xmlBytes, &request)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to unmarshal restore request XML")
+		return request, ErrMalformedXML
+	}
+	if request.Days < 1 {
+		return request, ErrMalformedXML
+	}
+	if request.GlacierJobParameters != nil {
+		switch request.GlacierJobParameters.Tier {
+		case RESTORE_TIER_EXPEDITED, RESTORE_TIER_STANDARD, RESTORE_TIER_BULK:
+		default:
+			return request, ErrMalformedXML
+		}
+	}
+	return request, nil
+}
+
+// Objects of these storage classes have to be restored before read in S3
+func IsRestorableStorageClass(class string) bool {
+	return class == STORAGE_CLASS_GLACIER
+}
+
+// Restored copies expire at the midnight UTC after `days` days since `now`
+func RestoreExpiryDate(now time.Time, days int) time.Time {
+	return now.UTC().AddDate(0, 0, days).Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// Value of "x-amz-restore" of objects restored until `expiry`, "" if the
+// restored copy has expired or there was never one
+func RestoreStatus(expiry time.Time, now time.Time) string {
+	if !expiry.After(now) {
+		return ""
+	}
+	return fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`,
+		expiry.UTC().Format(http.TimeFormat))
+}
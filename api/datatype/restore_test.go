@@ -0,0 +1,51 @@
+package datatype
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+)
+
+func TestRestoreRequestFromXml(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	cases := []struct {
+		xml string
+		err error
+	}{
+		{`<RestoreRequest><Days>1</Days></RestoreRequest>`, nil},
+		{`<RestoreRequest><Days>7</Days>
+<GlacierJobParameters><Tier>Expedited</Tier></GlacierJobParameters></RestoreRequest>`, nil},
+		{`<RestoreRequest><Days>0</Days></RestoreRequest>`, ErrMalformedXML},
+		{`<RestoreRequest><Days>1</Days>
+<GlacierJobParameters><Tier>hehe</Tier></GlacierJobParameters></RestoreRequest>`, ErrMalformedXML},
+		{`hehe`, ErrMalformedXML},
+	}
+	for i, c := range cases {
+		_, err := RestoreRequestFromXml([]byte(c.xml))
+		if err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+func TestRestoreStatus(t *testing.T) {
+	now := time.Date(2019, 3, 1, 15, 4, 5, 0, time.UTC)
+	expiry := RestoreExpiryDate(now, 2)
+	if !expiry.Equal(time.Date(2019, 3, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("restored copy should expire at midnight, got %v", expiry)
+	}
+	status := RestoreStatus(expiry, now)
+	if status != `ongoing-request="false", expiry-date="Mon, 04 Mar 2019 00:00:00 GMT"` {
+		t.Errorf("unexpected restore status %q", status)
+	}
+	if status = RestoreStatus(expiry, expiry); status != "" {
+		t.Errorf("expired restore should have no status, got %q", status)
+	}
+	if status = RestoreStatus(time.Time{}, now); status != "" {
+		t.Errorf("objects never restored should have no status, got %q", status)
+	}
+}
@@ -0,0 +1,132 @@
+package datatype
+
+import (
+	"encoding/xml"
+	"unicode/utf8"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+const (
+	MAX_SELECT_REQUEST_SIZE = 256 << 10 // 256 KB
+
+	// FileHeaderInfo of CSV input
+	CSV_HEADER_USE    = "USE"    // first line is header, columns could be referenced by name
+	CSV_HEADER_IGNORE = "IGNORE" // first line is header, but ignored
+	CSV_HEADER_NONE   = "NONE"   // first line is data
+)
+
+type CsvInput struct {
+	FileHeaderInfo  string
+	Comments        string
+	FieldDelimiter  string
+	RecordDelimiter string
+	QuoteCharacter  string
+}
+
+type JsonInput struct {
+	Type string
+}
+
+type InputSerialization struct {
+	CompressionType string
+	CSV             *CsvInput
+	JSON            *JsonInput
+}
+
+type CsvOutput struct {
+	FieldDelimiter  string
+	RecordDelimiter string
+}
+
+type JsonOutput struct {
+	RecordDelimiter string
+}
+
+type OutputSerialization struct {
+	CSV  *CsvOutput
+	JSON *JsonOutput
+}
+
+type SelectObjectContentRequest struct {
+	XMLName             xml.Name `xml:"SelectObjectContentRequest"`
+	Expression          string
+	ExpressionType      string
+	InputSerialization  InputSerialization
+	OutputSerialization OutputSerialization
+}
+
+func isSingleCharacter(s string) bool {
+	return utf8.RuneCountInString(s) == 1
+}
+
+// Only uncompressed CSV input is supported for now, unset delimiters are
+// filled with defaults
+func SelectRequestFromXml(xmlBytes []byte) (request SelectObjectContentRequest, err error) {
+	helper.Debugln("Incoming select XML:", string(xmlBytes))
+	err = xml.Unmarshal(xmlBytes, &request)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to unmarshal select XML")
+		return request, ErrMalformedXML
+	}
+	if request.ExpressionType != "SQL" {
+		return request, ErrInvalidExpressionType
+	}
+	if request.Expression == "" {
+		return request, ErrMalformedXML
+	}
+
+	input := &request.InputSerialization
+	if input.CompressionType != "" && input.CompressionType != "NONE" {
+		return request, ErrNotImplemented
+	}
+	if input.JSON != nil {
+		return request, ErrNotImplemented
+	}
+	if input.CSV == nil {
+		return request, ErrInvalidSelectRequest
+	}
+	switch input.CSV.FileHeaderInfo {
+	case "":
+		input.CSV.FileHeaderInfo = CSV_HEADER_NONE
+	case CSV_HEADER_USE, CSV_HEADER_IGNORE, CSV_HEADER_NONE:
+		break
+	default:
+		return request, ErrInvalidSelectRequest
+	}
+	if input.CSV.FieldDelimiter == "" {
+		input.CSV.FieldDelimiter = ","
+	}
+	if input.CSV.RecordDelimiter == "" {
+		input.CSV.RecordDelimiter = "\n"
+	}
+	if input.CSV.QuoteCharacter == "" {
+		input.CSV.QuoteCharacter = "\""
+	}
+	// encoding/csv only handles these
+	if !isSingleCharacter(input.CSV.FieldDelimiter) ||
+		(input.CSV.RecordDelimiter != "\n" && input.CSV.RecordDelimiter != "\r\n") ||
+		input.CSV.QuoteCharacter != "\"" ||
+		(input.CSV.Comments != "" && !isSingleCharacter(input.CSV.Comments)) {
+		return request, ErrInvalidSelectRequest
+	}
+
+	output := &request.OutputSerialization
+	switch {
+	case output.CSV != nil && output.JSON == nil:
+		if output.CSV.FieldDelimiter == "" {
+			output.CSV.FieldDelimiter = ","
+		}
+		if output.CSV.RecordDelimiter == "" {
+			output.CSV.RecordDelimiter = "\n"
+		}
+	case output.JSON != nil && output.CSV == nil:
+		if output.JSON.RecordDelimiter == "" {
+			output.JSON.RecordDelimiter = "\n"
+		}
+	default:
+		return request, ErrInvalidSelectRequest
+	}
+	return request, nil
+}
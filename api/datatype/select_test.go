@@ -0,0 +1,53 @@
+package datatype
+
+import (
+	"io/ioutil"
+	"testing"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+)
+
+func TestSelectRequestFromXml(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	cases := []struct {
+		xml string
+		err error
+	}{
+		{`<SelectObjectContentRequest><Expression>SELECT * FROM S3Object</Expression>
+<ExpressionType>SQL</ExpressionType><InputSerialization><CSV/></InputSerialization>
+<OutputSerialization><CSV/></OutputSerialization></SelectObjectContentRequest>`, nil},
+		{`<SelectObjectContentRequest><Expression>SELECT * FROM S3Object</Expression>
+<ExpressionType>SQL</ExpressionType><InputSerialization><CSV><FileHeaderInfo>USE</FileHeaderInfo>
+<FieldDelimiter>|</FieldDelimiter></CSV></InputSerialization>
+<OutputSerialization><JSON/></OutputSerialization></SelectObjectContentRequest>`, nil},
+		{`<SelectObjectContentRequest><Expression>SELECT * FROM S3Object</Expression>
+<ExpressionType>hehe</ExpressionType><InputSerialization><CSV/></InputSerialization>
+<OutputSerialization><CSV/></OutputSerialization></SelectObjectContentRequest>`, ErrInvalidExpressionType},
+		{`<SelectObjectContentRequest><Expression>SELECT * FROM S3Object</Expression>
+<ExpressionType>SQL</ExpressionType><InputSerialization><JSON><Type>LINES</Type></JSON></InputSerialization>
+<OutputSerialization><CSV/></OutputSerialization></SelectObjectContentRequest>`, ErrNotImplemented},
+		{`<SelectObjectContentRequest><Expression>SELECT * FROM S3Object</Expression>
+<ExpressionType>SQL</ExpressionType><InputSerialization><CSV><FieldDelimiter>||</FieldDelimiter></CSV>
+</InputSerialization><OutputSerialization><CSV/></OutputSerialization></SelectObjectContentRequest>`,
+			ErrInvalidSelectRequest},
+		{`<SelectObjectContentRequest><Expression>SELECT * FROM S3Object</Expression>
+<ExpressionType>SQL</ExpressionType><InputSerialization><CSV/></InputSerialization>
+</SelectObjectContentRequest>`, ErrInvalidSelectRequest},
+		{`hehe`, ErrMalformedXML},
+	}
+	for i, c := range cases {
+		_, err := SelectRequestFromXml([]byte(c.xml))
+		if err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+		}
+	}
+
+	request, _ := SelectRequestFromXml([]byte(cases[0].xml))
+	if request.InputSerialization.CSV.FieldDelimiter != "," ||
+		request.InputSerialization.CSV.FileHeaderInfo != CSV_HEADER_NONE ||
+		request.OutputSerialization.CSV.RecordDelimiter != "\n" {
+		t.Errorf("defaults should be filled, got %+v", request)
+	}
+}
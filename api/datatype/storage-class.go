@@ -0,0 +1,19 @@
+package datatype
+
+import "github.com/journeymidnight/yig/helper"
+
+const (
+	STORAGE_CLASS_STANDARD    = "STANDARD"
+	STORAGE_CLASS_STANDARD_IA = "STANDARD_IA"
+	STORAGE_CLASS_GLACIER     = "GLACIER"
+)
+
+// STANDARD is always available, other storage classes are enabled by
+// mapping them to Ceph pools in StorageClassPools of config
+func IsValidStorageClass(class string) bool {
+	if class == STORAGE_CLASS_STANDARD {
+		return true
+	}
+	_, ok := helper.CONFIG.StorageClassPools[class]
+	return ok
+}
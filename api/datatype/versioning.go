@@ -9,6 +9,7 @@ import (
 
 type Versioning struct {
 	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
 	Status  string   `xml:",omitempty"`
 	//TODO: MfaDelete string
 }
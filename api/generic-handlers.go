@@ -20,6 +20,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/journeymidnight/yig/api/datatype"
 	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/signature"
@@ -38,8 +39,8 @@ func RegisterHandlers(router *mux.Router, objectLayer ObjectLayer, handlerFns ..
 	return f
 }
 
-// Common headers among ALL the requests, including "Server", "Accept-Ranges",
-// "Cache-Control" and more to be added
+// Common headers among ALL the requests, including "Accept-Ranges",
+// "x-amz-request-id", "x-amz-id-2" and more to be added
 type commonHeaderHandler struct {
 	handler http.Handler
 }
@@ -64,6 +65,10 @@ func guessIsBrowserReq(req *http.Request) bool {
 
 func (h commonHeaderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Accept-Ranges", "bytes")
+	// same as RequestId and HostId in error responses, so clients could
+	// correlate their requests with server logs
+	w.Header().Set("x-amz-request-id", requestIdFromContext(r.Context()))
+	w.Header().Set("x-amz-id-2", helper.CONFIG.InstanceId)
 	h.handler.ServeHTTP(w, r)
 }
 
@@ -126,25 +131,22 @@ func (h corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	urlSplit := strings.SplitN(r.URL.Path[1:], "/", 2) // "1:" to remove leading slash
-	bucketName := urlSplit[0]                          // assume bucketName is the first part of url path
-	helper.Debugln("bucket", bucketName)
-	bucket, err := h.objectLayer.GetBucket(bucketName)
-	if err != nil {
-		WriteErrorResponse(w, r, err)
-		return
-	}
-
+	bucketName, _ := bucketAndObjectFromRequest(r)
+	helper.DebuglnContext(r.Context(), "bucket", bucketName)
+	bucket, err := h.objectLayer.GetBucket(r.Context(), bucketName)
 	if r.Method != "OPTIONS" {
-		for _, rule := range bucket.CORS.CorsRules {
-			if matched := rule.MatchSimple(r); matched {
-				rule.SetResponseHeaders(w, r, r.Header.Get("Origin"))
-				break
-			}
+		// requests without bucket, or creating one, have no CORS rules
+		// to apply but are served all the same
+		if err == nil {
+			applyCORSHeaders(w, r, bucket.CORS)
 		}
 		h.handler.ServeHTTP(w, r)
 		return
 	}
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
 
 	// r.Method == "OPTIONS", i.e CORS preflight
 	w.Header().Add("Vary", "Access-Control-Request-Method")
@@ -160,6 +162,19 @@ func (h corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	WriteErrorResponse(w, r, ErrAccessDenied)
 }
 
+// Set CORS headers of an actual request, e.g. GET or HEAD of objects by
+// browsers, from the first rule of `cors` matching its method and origin.
+// Responses are served the same whether any rule matches or not, browsers
+// decide from these headers whether pages could read them
+func applyCORSHeaders(w http.ResponseWriter, r *http.Request, cors datatype.Cors) {
+	for _, rule := range cors.CorsRules {
+		if matched := rule.MatchSimple(r); matched {
+			rule.SetResponseHeaders(w, r, r.Header.Get("Origin"))
+			return
+		}
+	}
+}
+
 // setIgnoreResourcesHandler -
 // Ignore resources handler is wrapper handler used for API request resource validation
 // Since we do not support all the S3 queries, it is necessary for us to throw back a
@@ -170,28 +185,19 @@ func SetIgnoreResourcesHandler(h http.Handler, _ ObjectLayer) http.Handler {
 
 // Resource handler ServeHTTP() wrapper
 func (h resourceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Skip the first element which is usually '/' and split the rest.
-	var objectName string
-	var bucketName string
-	splits := strings.SplitN(r.URL.Path[1:], "/", 2)
-	v := strings.Split(r.Host, ":")
-	hostWithOutPort := v[0]
-	if strings.HasSuffix(hostWithOutPort, "."+helper.CONFIG.S3Domain) {
-		bucketName = strings.TrimSuffix(hostWithOutPort, "."+helper.CONFIG.S3Domain)
-		if len(splits) == 1 {
-			objectName = splits[0]
-		}
-	}else {
-		if len(splits) == 1 {
-			bucketName = splits[0]
-		}
-		if len(splits) == 2 {
-			bucketName = splits[0]
-			objectName = splits[1]
-		}
-	}
+	bucketName, objectName := bucketAndObjectFromRequest(r)
 
 	helper.Logger.Println(5, "ServeHTTP", bucketName, objectName)
+	// Names are validated strictly by the APIs creating buckets and objects,
+	// all the others only reject names unsafe in metadata row keys
+	if !isRowkeySafeName(bucketName) {
+		WriteErrorResponse(w, r, ErrInvalidBucketName)
+		return
+	}
+	if !isRowkeySafeName(objectName) {
+		WriteErrorResponse(w, r, ErrInvalidObjectName)
+		return
+	}
 	// If bucketName is present and not objectName check for bucket
 	// level resource queries.
 	if bucketName != "" && objectName == "" {
@@ -264,12 +270,11 @@ func ignoreNotImplementedObjectResources(req *http.Request) bool {
 
 // List of not implemented bucket queries
 var notimplementedBucketResourceNames = map[string]bool{
-	"logging":        true,
-	"notification":   true,
-	"replication":    true,
-	"tagging":        true,
-	"requestPayment": true,
-	"website":        true,
+	"logging":      true,
+	"notification": true,
+	"replication":  true,
+	"tagging":      true,
+	"website":      true,
 }
 
 // List of not implemented object queries
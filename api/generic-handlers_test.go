@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+func TestRequestIdHeaders(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	helper.CONFIG.InstanceId = "hehe"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		WriteErrorResponse(w, r, ErrNoSuchKey)
+	})
+	server := httptest.NewServer(SetLogHandler(SetCommonHeaderHandler(mux, nil), nil))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.Header.Get("x-amz-request-id") == "" {
+		t.Error("x-amz-request-id should be set")
+	}
+	if response.Header.Get("x-amz-id-2") != "hehe" {
+		t.Errorf("unexpected x-amz-id-2: %s", response.Header.Get("x-amz-id-2"))
+	}
+
+	response, err = http.Get(server.URL + "/error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errorResponse ApiErrorResponse
+	err = xml.NewDecoder(response.Body).Decode(&errorResponse)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestId := response.Header.Get("x-amz-request-id")
+	if requestId == "" || errorResponse.RequestId != requestId {
+		t.Errorf("request id in header(%s) and body(%s) should be the same",
+			requestId, errorResponse.RequestId)
+	}
+	if errorResponse.HostId != "hehe" {
+		t.Errorf("unexpected HostId: %s", errorResponse.HostId)
+	}
+}
+
+func TestCorsPreflight(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	layer := bucketsLayer{buckets: map[string]meta.Bucket{
+		"bucket": {Name: "bucket", CORS: Cors{CorsRules: []CorsRule{{
+			AllowedMethods: []string{"PUT"},
+			AllowedOrigins: []string{"*"},
+			AllowedHeaders: []string{"*"},
+			MaxAgeSeconds:  600,
+		}}}},
+	}}
+	handler := SetLogHandler(SetCommonHeaderHandler(SetCorsHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("preflight should not reach API handlers")
+		}), layer), nil), nil)
+
+	cases := []struct {
+		path, method string
+		status       int
+	}{
+		{"/bucket/object", "PUT", http.StatusOK},
+		{"/bucket", "PUT", http.StatusOK},
+		{"/bucket/object", "DELETE", http.StatusForbidden},
+		{"/nobucket/object", "PUT", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("OPTIONS", c.path, nil)
+		r.Header.Set("Origin", "http://www.example.com")
+		r.Header.Set("Access-Control-Request-Method", c.method)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s %s: expected %d, got %d", c.path, c.method, c.status, w.Code)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("preflight response should have no body, got %s", w.Body.String())
+		}
+		if w.Header().Get("Access-Control-Max-Age") != "600" ||
+			w.Header().Get("Access-Control-Allow-Methods") != "PUT" {
+			t.Errorf("unexpected preflight headers %v", w.Header())
+		}
+	}
+}
+
+func TestCorsActualRequest(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	layer := bucketsLayer{buckets: map[string]meta.Bucket{
+		"bucket": {Name: "bucket", CORS: Cors{CorsRules: []CorsRule{{
+			AllowedMethods: []string{"GET", "HEAD"},
+			AllowedOrigins: []string{"http://*.example.com"},
+			ExposedHeaders: []string{"ETag", "x-amz-meta-hehe"},
+		}, {
+			AllowedMethods: []string{"GET"},
+			AllowedOrigins: []string{"*"},
+		}}}},
+	}}
+	handler := SetLogHandler(SetCommonHeaderHandler(SetCorsHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}), layer), nil), nil)
+
+	cases := []struct {
+		method, origin string
+		allowed        bool
+		credentials    string
+		exposed        string
+	}{
+		{"GET", "http://www.example.com", true, "true", "ETag, x-amz-meta-hehe"},
+		{"HEAD", "http://www.example.com", true, "true", "ETag, x-amz-meta-hehe"},
+		{"GET", "http://www.other.com", true, "", ""},
+		{"HEAD", "http://www.other.com", false, "", ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, "/bucket/object", nil)
+		r.Header.Set("Origin", c.origin)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s from %s: requests should be served whether allowed or not, got %d",
+				c.method, c.origin, w.Code)
+		}
+		if allowed := w.Header().Get("Access-Control-Allow-Origin") == c.origin; allowed != c.allowed {
+			t.Errorf("%s from %s: expected allowed %v, got headers %v", c.method, c.origin,
+				c.allowed, w.Header())
+		}
+		if w.Header().Get("Access-Control-Allow-Credentials") != c.credentials ||
+			w.Header().Get("Access-Control-Expose-Headers") != c.exposed ||
+			w.Header().Get("Vary") != "Origin" {
+
+			t.Errorf("%s from %s: unexpected headers %v", c.method, c.origin, w.Header())
+		}
+	}
+
+	// listing buckets and creating one have no bucket to get CORS rules from
+	for _, c := range []struct{ method, path string }{{"GET", "/"}, {"PUT", "/nobucket"}} {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		r.Header.Set("Origin", "http://www.example.com")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s %s: expected served without CORS headers, got %d %v", c.method, c.path,
+				w.Code, w.Header())
+		}
+	}
+}
+
+func TestInvalidResourceNames(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	handler := SetLogHandler(SetIgnoreResourcesHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}), nil), nil)
+	long := strings.Repeat("a", 1025)
+	cases := []struct {
+		path string
+		err  error
+	}{
+		{"/bucket/object", nil},
+		{"/my.bucket-1/dir/object", nil},
+		{"/", nil},
+		// legacy names, only rejected when creating buckets and objects
+		{"/Bucket/object", nil},
+		{"/my_bucket", nil},
+		{"/" + strings.Repeat("b", 64), nil},
+		{"/192.168.1.1/object", nil},
+		{"/bucket/" + long, nil},
+		{"/bucket/a%20b,c:d", nil},
+		// separators of object row keys
+		{"/bucket%0Aa/object", ErrInvalidBucketName},
+		{"/bucket/a%0Ab", ErrInvalidObjectName},
+		{"/bucket/a%0A%00%00%00%00%00%00%00%00", ErrInvalidObjectName},
+	}
+	for _, c := range cases {
+		for _, method := range []string{"GET", "HEAD", "DELETE"} {
+			request := httptest.NewRequest(method, c.path, nil)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if c.err == nil {
+				if recorder.Code != http.StatusOK {
+					t.Errorf("%s %s: expected ok, got %d", method, c.path, recorder.Code)
+				}
+				continue
+			}
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("%s %s: expected %v, got %d", method, c.path, c.err, recorder.Code)
+			}
+			if method == "HEAD" {
+				continue
+			}
+			var errorResponse ApiErrorResponse
+			xml.NewDecoder(recorder.Body).Decode(&errorResponse)
+			if errorResponse.AwsErrorCode != c.err.(ApiError).AwsErrorCode() {
+				t.Errorf("%s %s: expected %v, got %+v", method, c.path, c.err, errorResponse)
+			}
+		}
+	}
+}
@@ -18,12 +18,15 @@ package api
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	. "github.com/journeymidnight/yig/api/datatype"
 	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -49,13 +52,27 @@ func isValidLocationConstraint(reqBody io.Reader) (err error) {
 		// It should be equal to Region in serverConfig.
 		// Else ErrInvalidRegion returned.
 		// For empty value location will be to set to  default value from the serverConfig.
-		if locationConstraint.Location != "" && region != locationConstraint.Location {
+		if locationConstraint.Location != "" &&
+			bucketLocation(region) != bucketLocation(locationConstraint.Location) {
 			err = ErrInvalidRegion
 		}
 	}
 	return err
 }
 
+// DEFAULT_REGION is the region of buckets created without LocationConstraint
+// on AWS, its LocationConstraint is empty
+const DEFAULT_REGION = "us-east-1"
+
+// bucketLocation returns LocationConstraint of buckets in region, empty for
+// the default region or no region configured, as AWS does
+func bucketLocation(region string) string {
+	if region == DEFAULT_REGION {
+		return ""
+	}
+	return region
+}
+
 // Supported headers that needs to be extracted.
 var supportedHeaders = []string{
 	"Content-Type",
@@ -84,6 +101,69 @@ func extractMetadataFromHeader(header http.Header) map[string]string {
 	return metadata
 }
 
+// Parse "x-yig-ttl" header, seconds before the object expires.
+// Returns 0 if not set
+func parseTtlHeader(header http.Header) (ttl int64, err error) {
+	value := header.Get("X-Yig-Ttl")
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err = strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidTtl
+	}
+	if ttl < int64(helper.CONFIG.ObjectTtlMin) || ttl > int64(helper.CONFIG.ObjectTtlMax) {
+		return 0, ErrInvalidTtl
+	}
+	return ttl, nil
+}
+
+// Parse "x-amz-storage-class" header, STANDARD if not set
+func parseStorageClassHeader(header http.Header) (class string, err error) {
+	class = header.Get("X-Amz-Storage-Class")
+	if class == "" {
+		return STORAGE_CLASS_STANDARD, nil
+	}
+	if !IsValidStorageClass(class) {
+		return "", ErrInvalidStorageClass
+	}
+	return class, nil
+}
+
+// Parse "x-amz-content-sha256" and "x-amz-checksum-*" headers. At most one
+// x-amz-checksum-* is allowed, and it should agree with
+// "x-amz-sdk-checksum-algorithm" if that's set. Trailing checksums of
+// aws-chunked uploads are not supported
+func parseChecksumHeaders(header http.Header) (checksums Checksums, err error) {
+	if header.Get("X-Amz-Trailer") != "" {
+		return checksums, ErrNotImplemented
+	}
+	contentSha256 := strings.ToLower(header.Get("X-Amz-Content-Sha256"))
+	if sha256Bytes, e := hex.DecodeString(contentSha256); e == nil && len(sha256Bytes) == sha256.Size {
+		checksums.ContentSha256 = contentSha256
+	}
+	for _, algorithm := range ChecksumAlgorithms {
+		value := header.Get("X-Amz-Checksum-" + algorithm)
+		if value == "" {
+			continue
+		}
+		if checksums.Algorithm != "" {
+			return checksums, ErrInvalidChecksum
+		}
+		digest, e := base64.StdEncoding.DecodeString(value)
+		if e != nil || len(digest) != NewChecksumHash(algorithm).Size() {
+			return checksums, ErrInvalidChecksum
+		}
+		checksums.Algorithm = algorithm
+		checksums.Checksum = value
+	}
+	sdkAlgorithm := strings.ToUpper(header.Get("X-Amz-Sdk-Checksum-Algorithm"))
+	if sdkAlgorithm != "" && sdkAlgorithm != checksums.Algorithm {
+		return checksums, ErrInvalidChecksum
+	}
+	return checksums, nil
+}
+
 func parseSseHeader(header http.Header) (request SseRequest, err error) {
 	if sse := header.Get("X-Amz-Server-Side-Encryption"); sse != "" {
 		switch sse {
@@ -185,3 +265,40 @@ func parseSseHeader(header http.Header) (request SseRequest, err error) {
 
 	return
 }
+
+// SSE request to read the source object of a copy with, which is encrypted
+// as `sourceSseType`. SSE-C source objects are decrypted with the
+// x-amz-copy-source-server-side-encryption-customer-key, which must not be
+// sent for other objects
+func copySourceSseRequest(sourceSseType string, sseRequest SseRequest) (SseRequest, error) {
+	hasKey := len(sseRequest.CopySourceSseCustomerKey) != 0
+	if (sourceSseType == "C") != hasKey {
+		return SseRequest{}, ErrInvalidSseHeader
+	}
+	return SseRequest{
+		Type:                           sourceSseType,
+		CopySourceSseCustomerAlgorithm: sseRequest.CopySourceSseCustomerAlgorithm,
+		CopySourceSseCustomerKey:       sseRequest.CopySourceSseCustomerKey,
+	}, nil
+}
+
+// Data of a copy source, which is read only once the copy starts reading it,
+// so nothing is read if storage copies data without going through it
+type copySourceReader struct {
+	open   func() *io.PipeReader
+	reader *io.PipeReader
+}
+
+func (r *copySourceReader) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		r.reader = r.open()
+	}
+	return r.reader.Read(p)
+}
+
+func (r *copySourceReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+func TestParseTtlHeader(t *testing.T) {
+	helper.CONFIG.ObjectTtlMin = 60
+	helper.CONFIG.ObjectTtlMax = 3600
+
+	var testcase = []struct {
+		header   string
+		ttl      int64
+		expected error
+	}{
+		{"", 0, nil},
+		{"60", 60, nil},
+		{"3600", 3600, nil},
+		{"59", 0, ErrInvalidTtl},
+		{"3601", 0, ErrInvalidTtl},
+		{"-1", 0, ErrInvalidTtl},
+		{"1h", 0, ErrInvalidTtl},
+	}
+	for _, c := range testcase {
+		header := http.Header{}
+		if c.header != "" {
+			header.Set("X-Yig-Ttl", c.header)
+		}
+		ttl, err := parseTtlHeader(header)
+		if err != c.expected || ttl != c.ttl {
+			t.Errorf("x-yig-ttl %q: expected %d %v, got %d %v",
+				c.header, c.ttl, c.expected, ttl, err)
+		}
+	}
+}
+
+func TestCopySourceSseRequest(t *testing.T) {
+	sourceKey := []byte("0123456789abcdef0123456789abcdef")
+	sseRequest := SseRequest{
+		Type:                     "C",
+		SseCustomerKey:           []byte("fedcba9876543210fedcba9876543210"),
+		CopySourceSseCustomerKey: sourceKey,
+	}
+	request, err := copySourceSseRequest("C", sseRequest)
+	if err != nil || string(request.CopySourceSseCustomerKey) != string(sourceKey) ||
+		len(request.SseCustomerKey) != 0 {
+
+		t.Errorf("SSE-C source should be read with copy source key only, got %+v %v",
+			request, err)
+	}
+	for _, sseType := range []string{"", "S3"} {
+		if _, err = copySourceSseRequest(sseType, sseRequest); err != ErrInvalidSseHeader {
+			t.Errorf("copy source key should be rejected for %q source, got %v",
+				sseType, err)
+		}
+	}
+	if _, err = copySourceSseRequest("C", SseRequest{Type: "C",
+		SseCustomerKey: sourceKey}); err != ErrInvalidSseHeader {
+
+		t.Errorf("SSE-C source should require copy source key, got %v", err)
+	}
+	if _, err = copySourceSseRequest("S3", SseRequest{Type: "C",
+		SseCustomerKey: sourceKey}); err != nil {
+
+		t.Errorf("SSE-S3 source could be copied to SSE-C, got %v", err)
+	}
+}
+
+func TestParseStorageClassHeader(t *testing.T) {
+	helper.CONFIG.StorageClassPools = map[string]string{"GLACIER": "rabbit-glacier"}
+	defer func() { helper.CONFIG.StorageClassPools = nil }()
+
+	var testcase = []struct {
+		header   string
+		class    string
+		expected error
+	}{
+		{"", STORAGE_CLASS_STANDARD, nil},
+		{"STANDARD", STORAGE_CLASS_STANDARD, nil},
+		{"GLACIER", "GLACIER", nil},
+		{"STANDARD_IA", "", ErrInvalidStorageClass},
+		{"glacier", "", ErrInvalidStorageClass},
+	}
+	for _, c := range testcase {
+		header := http.Header{}
+		if c.header != "" {
+			header.Set("X-Amz-Storage-Class", c.header)
+		}
+		class, err := parseStorageClassHeader(header)
+		if class != c.class || err != c.expected {
+			t.Errorf("%q: expected %q %v, got %q %v", c.header, c.class, c.expected, class, err)
+		}
+	}
+}
+
+func TestParseChecksumHeaders(t *testing.T) {
+	sha256Hex := "0ebe2eca800cf7bd9d9d9f9f4aafbc0c77ae155f43bbbeca69cb256a24c7f9bb"
+	var testcase = []struct {
+		header   map[string]string
+		expected Checksums
+		err      error
+	}{
+		{map[string]string{}, Checksums{}, nil},
+		{map[string]string{"X-Amz-Content-Sha256": "UNSIGNED-PAYLOAD"}, Checksums{}, nil},
+		{map[string]string{"X-Amz-Content-Sha256": "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"},
+			Checksums{}, nil},
+		{map[string]string{"X-Amz-Content-Sha256": sha256Hex},
+			Checksums{ContentSha256: sha256Hex}, nil},
+		{map[string]string{"X-Amz-Checksum-Crc32": "ATZ9Qw=="},
+			Checksums{Algorithm: CHECKSUM_CRC32, Checksum: "ATZ9Qw=="}, nil},
+		{map[string]string{"X-Amz-Checksum-Crc32c": "lqFPJA==",
+			"X-Amz-Sdk-Checksum-Algorithm": "crc32c"},
+			Checksums{Algorithm: CHECKSUM_CRC32C, Checksum: "lqFPJA=="}, nil},
+		// digest of wrong length
+		{map[string]string{"X-Amz-Checksum-Sha1": "ATZ9Qw=="}, Checksums{}, ErrInvalidChecksum},
+		{map[string]string{"X-Amz-Checksum-Crc32": "ATZ9Qw==", "X-Amz-Checksum-Crc32c": "lqFPJA=="},
+			Checksums{}, ErrInvalidChecksum},
+		{map[string]string{"X-Amz-Checksum-Crc32": "ATZ9Qw==",
+			"X-Amz-Sdk-Checksum-Algorithm": "SHA256"}, Checksums{}, ErrInvalidChecksum},
+		{map[string]string{"X-Amz-Trailer": "x-amz-checksum-crc32"}, Checksums{}, ErrNotImplemented},
+	}
+	for _, c := range testcase {
+		header := http.Header{}
+		for k, v := range c.header {
+			header.Set(k, v)
+		}
+		checksums, err := parseChecksumHeaders(header)
+		if err != c.err || (err == nil && checksums != c.expected) {
+			t.Errorf("%v: expected %+v %v, got %+v %v", c.header, c.expected, c.err, checksums, err)
+		}
+	}
+}
+
+func TestLocationConstraint(t *testing.T) {
+	region := helper.CONFIG.Region
+	defer func() {
+		helper.CONFIG.Region = region
+	}()
+	body := func(location string) string {
+		return "<CreateBucketConfiguration><LocationConstraint>" + location +
+			"</LocationConstraint></CreateBucketConfiguration>"
+	}
+	var testcase = []struct {
+		region   string
+		body     string
+		expected error
+	}{
+		{"cn-bj-1", "", nil},
+		{"cn-bj-1", body(""), nil},
+		{"cn-bj-1", body("cn-bj-1"), nil},
+		{"cn-bj-1", body("cn-bj-2"), ErrInvalidRegion},
+		{"cn-bj-1", body("us-east-1"), ErrInvalidRegion},
+		{"cn-bj-1", "<CreateBucketConfiguration>", ErrMalformedXML},
+		{"", body("us-east-1"), nil},
+		{"us-east-1", body("us-east-1"), nil},
+		{"us-east-1", body("cn-bj-1"), ErrInvalidRegion},
+	}
+	for _, c := range testcase {
+		helper.CONFIG.Region = c.region
+		err := isValidLocationConstraint(strings.NewReader(c.body))
+		if err != c.expected {
+			t.Errorf("region %q, body %q: expected %v, got %v", c.region, c.body,
+				c.expected, err)
+		}
+	}
+	for region, location := range map[string]string{
+		"cn-bj-1": "cn-bj-1", "us-east-1": "", "": ""} {
+
+		if got := bucketLocation(region); got != location {
+			t.Errorf("location of region %q: expected %q, got %q", region, location, got)
+		}
+	}
+}
@@ -14,6 +14,11 @@ func (l logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Serves the request.
 	requestId := string(helper.GenerateRandomId())
 	ctx := context.WithValue(r.Context(), RequestId, requestId)
+	// debug logs are written once authenticated by an admin, see
+	// helper.EnableDebugLogging
+	if r.Header.Get("x-yig-debug") == "true" {
+		ctx = helper.WithDebugLogging(ctx)
+	}
 	helper.Logger.Printf(5, "STARTING %s %s%s RequestID:%s", r.Method, r.Host, r.URL, requestId)
 	l.handler.ServeHTTP(w, r.WithContext(ctx))
 	helper.Logger.Printf(5, "COMPLETED %s %s%s RequestID:%s", r.Method, r.Host, r.URL, requestId)
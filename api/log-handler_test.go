@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/log"
+	"github.com/journeymidnight/yig/signature"
+)
+
+// Request signed with V2 by secretKey of accessKey
+func properlySignedRequest(t *testing.T, accessKey, secretKey string) *http.Request {
+	r, err := http.NewRequest("GET", "http://s3.test.com/bucket/hehe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	mac := hmac.New(sha1.New, []byte(secretKey))
+	mac.Write([]byte("GET\n\n\n" + r.Header.Get("Date") + "\n/bucket/hehe"))
+	r.Header.Set("Authorization", "AWS "+accessKey+":"+
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestDebugLoggingHeader(t *testing.T) {
+	var logs bytes.Buffer
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	helper.DebugLogger = log.New(&logs, "[yig]", log.LstdFlags, 5)
+	config := helper.CONFIG
+	defer func() {
+		helper.DebugLogger = nil
+		helper.CONFIG = config
+		helper.CONFIG.IamBackend = iam.BACKEND_REMOTE
+		iam.SetupBackend()
+	}()
+	helper.CONFIG.IamBackend = iam.BACKEND_LOCAL
+	helper.CONFIG.IamCredentials = []helper.IamCredential{
+		{AccessKey: "admin", SecretKey: "adminadmin", UserId: "admin"},
+		{AccessKey: "hehe", SecretKey: "hehehehe", UserId: "hehe"},
+	}
+	helper.CONFIG.AdminAccessKeys = []string{"admin"}
+	if err := iam.SetupBackend(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := SetLogHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		helper.DebuglnContext(r.Context(), "before authentication")
+		if _, err := signature.IsReqAuthenticated(r); err != nil {
+			t.Error(err)
+		}
+		helper.DebuglnContext(r.Context(), "request of", r.Header.Get("x-test-case"))
+	}), nil)
+	cases := []struct {
+		name, accessKey, secretKey string
+		debug                      bool
+	}{
+		{"admin-debug", "admin", "adminadmin", true},
+		{"admin", "admin", "adminadmin", false},
+		{"user-debug", "hehe", "hehehehe", true},
+	}
+	for _, c := range cases {
+		r := properlySignedRequest(t, c.accessKey, c.secretKey)
+		r.Header.Set("x-test-case", c.name)
+		if c.debug {
+			r.Header.Set("x-yig-debug", "true")
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if strings.Count(logs.String(), "\n") != 1 ||
+		!strings.Contains(logs.String(), "request of admin-debug") {
+		t.Errorf("only debug logs of admin asking for them should be written, got %q",
+			logs.String())
+	}
+}
@@ -25,66 +25,65 @@ import (
 	meta "github.com/journeymidnight/yig/meta/types"
 )
 
-// Validates the preconditions for CopyObject, returns nil if validates
+// Validates the preconditions for CopyObject and CopyObjectPart against
+// the source object, returns nil if validates
 // Preconditions supported are:
 //  x-amz-copy-source-if-modified-since
 //  x-amz-copy-source-if-unmodified-since
 //  x-amz-copy-source-if-match
 //  x-amz-copy-source-if-none-match
-func checkObjectPreconditions(w http.ResponseWriter, r *http.Request, object *meta.Object) error {
-	// x-amz-copy-source-if-modified-since: Return the object only if it has been modified
-	// since the specified time
-	ifModifiedSinceHeader := r.Header.Get("x-amz-copy-source-if-modified-since")
-	if ifModifiedSinceHeader != "" {
-		givenTime, err := time.Parse(http.TimeFormat, ifModifiedSinceHeader)
-		if err != nil {
-			return ErrInvalidPrecondition
-		}
-		if object.LastModifiedTime.Before(givenTime) {
-			// If the object is not modified since the specified time.
+// As AWS does, x-amz-copy-source-if-unmodified-since is ignored if
+// x-amz-copy-source-if-match is present and matches
+func checkObjectPreconditions(header http.Header, object *meta.Object) error {
+	// HTTP dates have a precision of seconds
+	lastModified := object.LastModifiedTime.Truncate(time.Second)
+
+	// x-amz-copy-source-if-match : Return the object only if its entity tag (ETag) is the
+	// same as the one specified
+	ifMatchETagHeader := header.Get("x-amz-copy-source-if-match")
+	if ifMatchETagHeader != "" {
+		if !isETagInList(object.Etag, ifMatchETagHeader) {
+			// If the object ETag does not match with the specified ETag.
 			return ErrPreconditionFailed
 		}
 	}
 
 	// x-amz-copy-source-if-unmodified-since : Return the object only if it has not been
 	// modified since the specified time
-	ifUnmodifiedSinceHeader := r.Header.Get("x-amz-copy-source-if-unmodified-since")
-	if ifUnmodifiedSinceHeader != "" {
-		givenTime, err := time.Parse(http.TimeFormat, ifUnmodifiedSinceHeader)
+	ifUnmodifiedSinceHeader := header.Get("x-amz-copy-source-if-unmodified-since")
+	if ifUnmodifiedSinceHeader != "" && ifMatchETagHeader == "" {
+		givenTime, err := http.ParseTime(ifUnmodifiedSinceHeader)
 		if err != nil {
 			return ErrInvalidPrecondition
 		}
-		if object.LastModifiedTime.After(givenTime) {
+		if lastModified.After(givenTime) {
 			// If the object is modified since the specified time.
 			return ErrPreconditionFailed
 		}
 	}
 
-	// x-amz-copy-source-if-match : Return the object only if its entity tag (ETag) is the
-	// same as the one specified
-	ifMatchETagHeader := r.Header.Get("x-amz-copy-source-if-match")
-	if ifMatchETagHeader != "" {
-		if !isETagEqual(object.Etag, ifMatchETagHeader) {
-			// If the object ETag does not match with the specified ETag.
-			return ErrPreconditionFailed
-		}
-	}
-
-	// If-None-Match : Return the object only if its entity tag (ETag) is different from the
-	// one specified
-	ifNoneMatchETagHeader := r.Header.Get("x-amz-copy-source-if-none-match")
+	// x-amz-copy-source-if-none-match : Return the object only if its entity tag (ETag) is
+	// different from the one specified
+	ifNoneMatchETagHeader := header.Get("x-amz-copy-source-if-none-match")
 	if ifNoneMatchETagHeader != "" {
-		if isETagEqual(object.Etag, ifNoneMatchETagHeader) {
+		if isETagInList(object.Etag, ifNoneMatchETagHeader) {
 			// If the object ETag matches with the specified ETag.
 			return ErrPreconditionFailed
 		}
 	}
 
-	if ifNoneMatchETagHeader != "" && ifUnmodifiedSinceHeader != "" {
-		return ErrInvalidPrecondition
-	}
-	if ifMatchETagHeader != "" && ifModifiedSinceHeader != "" {
-		return ErrInvalidPrecondition
+	// x-amz-copy-source-if-modified-since: Return the object only if it has been modified
+	// since the specified time
+	ifModifiedSinceHeader := header.Get("x-amz-copy-source-if-modified-since")
+	if ifModifiedSinceHeader != "" {
+		givenTime, err := http.ParseTime(ifModifiedSinceHeader)
+		if err != nil {
+			return ErrInvalidPrecondition
+		}
+		if !lastModified.After(givenTime) {
+			// If the object is not modified since the specified time.
+			return ErrPreconditionFailed
+		}
 	}
 
 	return nil
@@ -158,3 +157,15 @@ func canonicalizeETag(etag string) string {
 func isETagEqual(left, right string) bool {
 	return canonicalizeETag(left) == canonicalizeETag(right)
 }
+
+// isETagInList returns true if `etag` equals any of the comma separated
+// ETags in `list`, "*" matches any ETag
+func isETagInList(etag, list string) bool {
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if e == "*" || isETagEqual(etag, e) {
+			return true
+		}
+	}
+	return false
+}
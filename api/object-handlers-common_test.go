@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+func TestCheckObjectPreconditions(t *testing.T) {
+	modified := time.Date(2018, 5, 1, 10, 0, 0, 500000000, time.UTC)
+	object := &meta.Object{
+		Etag:             "0123456789abcdef",
+		LastModifiedTime: modified,
+	}
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	same := modified.Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	cases := []struct {
+		headers map[string]string
+		err     error
+	}{
+		{map[string]string{}, nil},
+		{map[string]string{"x-amz-copy-source-if-match": `"0123456789abcdef"`}, nil},
+		{map[string]string{"x-amz-copy-source-if-match": `"hehe", "0123456789abcdef"`}, nil},
+		{map[string]string{"x-amz-copy-source-if-match": "*"}, nil},
+		{map[string]string{"x-amz-copy-source-if-match": `"hehe"`}, ErrPreconditionFailed},
+		{map[string]string{"x-amz-copy-source-if-none-match": `"hehe"`}, nil},
+		{map[string]string{"x-amz-copy-source-if-none-match": "0123456789abcdef"},
+			ErrPreconditionFailed},
+		{map[string]string{"x-amz-copy-source-if-modified-since": before}, nil},
+		// not modified within the same second
+		{map[string]string{"x-amz-copy-source-if-modified-since": same}, ErrPreconditionFailed},
+		{map[string]string{"x-amz-copy-source-if-modified-since": after}, ErrPreconditionFailed},
+		{map[string]string{"x-amz-copy-source-if-unmodified-since": same}, nil},
+		{map[string]string{"x-amz-copy-source-if-unmodified-since": before}, ErrPreconditionFailed},
+		{map[string]string{"x-amz-copy-source-if-unmodified-since": "hehe"}, ErrInvalidPrecondition},
+		// if-unmodified-since is ignored if if-match holds
+		{map[string]string{
+			"x-amz-copy-source-if-match":            "0123456789abcdef",
+			"x-amz-copy-source-if-unmodified-since": before,
+		}, nil},
+		{map[string]string{
+			"x-amz-copy-source-if-none-match":     `"hehe"`,
+			"x-amz-copy-source-if-modified-since": after,
+		}, ErrPreconditionFailed},
+		{map[string]string{
+			"x-amz-copy-source-if-none-match":     `"hehe"`,
+			"x-amz-copy-source-if-modified-since": before,
+		}, nil},
+	}
+	for i, c := range cases {
+		header := http.Header{}
+		for k, v := range c.headers {
+			header.Set(k, v)
+		}
+		if err := checkObjectPreconditions(header, object); err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+		}
+	}
+}
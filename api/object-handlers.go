@@ -17,8 +17,10 @@
 package api
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -26,6 +28,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	mux "github.com/gorilla/mux"
 	. "github.com/journeymidnight/yig/api/datatype"
@@ -59,10 +62,10 @@ func setGetRespHeaders(w http.ResponseWriter, reqParams url.Values) {
 // this is in keeping with the permissions sections of the docs of both:
 //   HEAD Object: http://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectHEAD.html
 //   GET Object: http://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectGET.html
-func (api ObjectAPIHandlers) errAllowableObjectNotFound(bucketName string,
+func (api ObjectAPIHandlers) errAllowableObjectNotFound(ctx context.Context, bucketName string,
 	credential iam.Credential) error {
 
-	bucket, err := api.ObjectAPI.GetBucket(bucketName)
+	bucket, err := api.ObjectAPI.GetBucket(ctx, bucketName)
 	if err == ErrNoSuchBucket {
 		return ErrNoSuchKey
 	} else if err != nil {
@@ -85,6 +88,141 @@ func (api ObjectAPIHandlers) errAllowableObjectNotFound(bucketName string,
 	}
 }
 
+// Acknowledge requesters of Requester Pays bucket that they are charged,
+// owners are never charged for their own buckets. Should be called only if
+// the request succeeds.
+// See https://docs.aws.amazon.com/AmazonS3/latest/dev/ObjectsinRequesterPaysBuckets.html
+func (api ObjectAPIHandlers) setRequestChargedHeader(w http.ResponseWriter, r *http.Request,
+	bucketName string, credential iam.Credential) {
+
+	if r.Header.Get("X-Amz-Request-Payer") != "requester" || credential.UserId == "" {
+		return
+	}
+	bucket, err := api.ObjectAPI.GetBucket(r.Context(), bucketName)
+	if err != nil {
+		return
+	}
+	if bucket.RequestPayer == PAYER_REQUESTER && bucket.OwnerId != credential.UserId {
+		w.Header().Set("X-Amz-Request-Charged", "requester")
+	}
+}
+
+// Tell clients when the object expires by bucket lifecycle, the bucket
+// is served from cache so no extra database lookup is needed
+func (api ObjectAPIHandlers) setExpirationHeader(ctx context.Context, w http.ResponseWriter,
+	object *meta.Object) {
+
+	bucket, err := api.ObjectAPI.GetBucket(ctx, object.BucketName)
+	if err != nil {
+		return
+	}
+	// objects are not tagged yet
+	rule, days, ok := bucket.LC.ExpirationRule(object.Name, nil)
+	if !ok {
+		return
+	}
+	// same as tools/lc.go, days are seconds in debug mode
+	unit := 24 * time.Hour
+	if helper.CONFIG.LcDebug {
+		unit = time.Second
+	}
+	expiry := object.LastModifiedTime.Add(time.Duration(days) * unit)
+	w.Header().Set("x-amz-expiration", fmt.Sprintf(`expiry-date="%s", rule-id="%s"`,
+		expiry.UTC().Format(http.TimeFormat), rule.ID))
+}
+
+// Whether "gzip" is acceptable by Accept-Encoding header, see
+// https://tools.ietf.org/html/rfc7231#section-5.3.4
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range params[1:] {
+			param = strings.Replace(param, " ", "", -1)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		return accepted
+	}
+	return false
+}
+
+// Returns "<object>.gz" instead if bucket has GzipVariants enabled, the client
+// accepts gzip and the variant exists. Content-Type of the original object
+// is kept, so clients see the same content only encoded.
+func (api ObjectAPIHandlers) gzipVariant(w http.ResponseWriter, r *http.Request,
+	object *meta.Object, credential iam.Credential) (*meta.Object, bool) {
+
+	if r.URL.Query().Get("versionId") != "" || strings.HasSuffix(object.Name, ".gz") {
+		return object, false
+	}
+	bucket, err := api.ObjectAPI.GetBucket(r.Context(), object.BucketName)
+	if err != nil || !bucket.GzipVariants {
+		return object, false
+	}
+	// response differs by Accept-Encoding, for caches in between
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		return object, false
+	}
+	variant, err := api.ObjectAPI.GetObjectInfo(r.Context(), object.BucketName, object.Name+".gz", "",
+		credential)
+	if err != nil || variant.DeleteMarker {
+		return object, false
+	}
+	gzipped := *variant
+	gzipped.ContentType = object.ContentType
+	return &gzipped, true
+}
+
+// Headers of GET and HEAD object responses, the status code is written as
+// well so nothing should be set afterwards
+func (api ObjectAPIHandlers) setObjectResponseHeaders(w http.ResponseWriter, r *http.Request,
+	object *meta.Object, hrange *HttpRange, gzipped bool, credential iam.Credential) {
+
+	SetObjectHeaders(w, object, hrange)
+	if gzipped {
+		w.Header().Set("Content-Encoding", "gzip")
+	} else if hrange == nil {
+		setChecksumHeader(w, r, object)
+	}
+
+	switch object.SseType {
+	case "":
+		break
+	case "KMS":
+		w.Header().Set("X-Amz-Server-Side-Encryption", "aws:kms")
+		// TODO: not implemented yet
+	case "S3":
+		w.Header().Set("X-Amz-Server-Side-Encryption", "AES256")
+	case "C":
+		w.Header().Set("X-Amz-Server-Side-Encryption-Customer-Algorithm", "AES256")
+		w.Header().Set("X-Amz-Server-Side-Encryption-Customer-Key-Md5",
+			r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+	}
+
+	// Set any additional requested response headers.
+	setGetRespHeaders(w, r.URL.Query())
+
+	if version := r.URL.Query().Get("versionId"); version != "" {
+		w.Header().Set("x-amz-version-id", version)
+	}
+	api.setRequestChargedHeader(w, r, object.BucketName, credential)
+	api.setExpirationHeader(r.Context(), w, object)
+
+	if hrange != nil && hrange.OffsetBegin > -1 {
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 // Simple way to convert a func to io.Writer type.
 type funcToWriter func([]byte) (int, error)
 
@@ -120,11 +258,11 @@ func (api ObjectAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 	}
 	version := r.URL.Query().Get("versionId")
 	// Fetch object stat info.
-	object, err := api.ObjectAPI.GetObjectInfo(bucketName, objectName, version, credential)
+	object, err := api.ObjectAPI.GetObjectInfo(r.Context(), bucketName, objectName, version, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to fetch object info.")
 		if err == ErrNoSuchKey {
-			err = api.errAllowableObjectNotFound(bucketName, credential)
+			err = api.errAllowableObjectNotFound(r.Context(), bucketName, credential)
 		}
 		WriteErrorResponse(w, r, err)
 		return
@@ -135,6 +273,7 @@ func (api ObjectAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 		WriteErrorResponse(w, r, ErrNoSuchKey)
 		return
 	}
+	object, gzipped := api.gzipVariant(w, r, object, credential)
 
 	// Get request range.
 	var hrange *HttpRange
@@ -192,37 +331,14 @@ func (api ObjectAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 	writer := funcToWriter(func(p []byte) (int, error) {
 		if !dataWritten {
 			// Set headers on the first write.
-			// Set standard object headers.
-			SetObjectHeaders(w, object, hrange)
-
-			// Set any additional requested response headers.
-			setGetRespHeaders(w, r.URL.Query())
-
-			if version != "" {
-				w.Header().Set("x-amz-version-id", version)
-			}
-
+			api.setObjectResponseHeaders(w, r, object, hrange, gzipped, credential)
 			dataWritten = true
 		}
 		return w.Write(p)
 	})
 
-	switch object.SseType {
-	case "":
-		break
-	case "KMS":
-		w.Header().Set("X-Amz-Server-Side-Encryption", "aws:kms")
-		// TODO: not implemented yet
-	case "S3":
-		w.Header().Set("X-Amz-Server-Side-Encryption", "AES256")
-	case "C":
-		w.Header().Set("X-Amz-Server-Side-Encryption-Customer-Algorithm", "AES256")
-		w.Header().Set("X-Amz-Server-Side-Encryption-Customer-Key-Md5",
-			r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
-	}
-
 	// Reads the object at startOffset and writes to mw.
-	if err := api.ObjectAPI.GetObject(object, startOffset, length, writer, sseRequest); err != nil {
+	if err := api.ObjectAPI.GetObject(r.Context(), object, startOffset, length, writer, sseRequest); err != nil {
 		helper.ErrorIf(err, "Unable to write to client.")
 		if !dataWritten {
 			// Error response only if no data has been written to client yet. i.e if
@@ -244,7 +360,6 @@ func (api ObjectAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 // HeadObjectHandler - HEAD Object
 // -----------
 // The HEAD operation retrieves metadata from an object without returning the object itself.
-// TODO refactor HEAD and GET
 func (api ObjectAPIHandlers) HeadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	var objectName, bucketName string
 	vars := mux.Vars(r)
@@ -269,11 +384,11 @@ func (api ObjectAPIHandlers) HeadObjectHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	version := r.URL.Query().Get("versionId")
-	object, err := api.ObjectAPI.GetObjectInfo(bucketName, objectName, version, credential)
+	object, err := api.ObjectAPI.GetObjectInfo(r.Context(), bucketName, objectName, version, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to fetch object info.")
 		if err == ErrNoSuchKey {
-			err = api.errAllowableObjectNotFound(bucketName, credential)
+			err = api.errAllowableObjectNotFound(r.Context(), bucketName, credential)
 		}
 		WriteErrorResponse(w, r, err)
 		return
@@ -284,11 +399,13 @@ func (api ObjectAPIHandlers) HeadObjectHandler(w http.ResponseWriter, r *http.Re
 		WriteErrorResponse(w, r, ErrNoSuchKey)
 		return
 	}
+	object, gzipped := api.gzipVariant(w, r, object, credential)
 
 	// Get request range.
+	var hrange *HttpRange
 	rangeHeader := r.Header.Get("Range")
 	if rangeHeader != "" {
-		if _, err = ParseRequestRange(rangeHeader, object.Size); err != nil {
+		if hrange, err = ParseRequestRange(rangeHeader, object.Size); err != nil {
 			// Handle only ErrorInvalidRange
 			// Ignore other parse error and treat it as regular Get request like Amazon S3.
 			if err == ErrorInvalidRange {
@@ -323,25 +440,103 @@ func (api ObjectAPIHandlers) HeadObjectHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// Set standard object headers.
-	SetObjectHeaders(w, object, nil)
+	// Successful response, with the same headers as GET but no body
+	api.setObjectResponseHeaders(w, r, object, hrange, gzipped, credential)
+}
 
-	switch object.SseType {
-	case "":
+// GetObjectAttributesHandler - GET Object?attributes
+// ----------
+// Returns attributes of an object listed in x-amz-object-attributes,
+// without its data. Checksum is the one provided when the object is put
+func (api ObjectAPIHandlers) GetObjectAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	var credential iam.Credential
+	var err error
+	switch signature.GetRequestAuthType(r) {
+	default:
+		// For all unknown auth types return error.
+		WriteErrorResponse(w, r, ErrAccessDenied)
+		return
+	case signature.AuthTypeAnonymous:
 		break
-	case "KMS":
-		w.Header().Set("X-Amz-Server-Side-Encryption", "aws:kms")
-		// TODO not implemented yet
-	case "S3":
-		w.Header().Set("X-Amz-Server-Side-Encryption", "AES256")
-	case "C":
-		w.Header().Set("X-Amz-Server-Side-Encryption-Customer-Algorithm", "AES256")
-		w.Header().Set("X-Amz-Server-Side-Encryption-Customer-Key-Md5",
-			r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+	case signature.AuthTypePresignedV4, signature.AuthTypeSignedV4,
+		signature.AuthTypePresignedV2, signature.AuthTypeSignedV2:
+		if credential, err = signature.IsReqAuthenticated(r); err != nil {
+			WriteErrorResponse(w, r, err)
+			return
+		}
 	}
 
-	// Successful response.
-	w.WriteHeader(http.StatusOK)
+	attributes := make(map[string]bool)
+	for _, header := range r.Header["X-Amz-Object-Attributes"] {
+		for _, attribute := range strings.Split(header, ",") {
+			attribute = strings.TrimSpace(attribute)
+			switch attribute {
+			case "ETag", "Checksum", "ObjectParts", "StorageClass", "ObjectSize":
+				attributes[attribute] = true
+			default:
+				WriteErrorResponse(w, r, ErrInvalidObjectAttributes)
+				return
+			}
+		}
+	}
+	if len(attributes) == 0 {
+		WriteErrorResponse(w, r, ErrInvalidObjectAttributes)
+		return
+	}
+
+	version := r.URL.Query().Get("versionId")
+	object, err := api.ObjectAPI.GetObjectInfo(r.Context(), bucketName, objectName, version, credential)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to fetch object info.")
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if object.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		WriteErrorResponse(w, r, ErrNoSuchKey)
+		return
+	}
+
+	var response GetObjectAttributesResponse
+	if attributes["ETag"] {
+		response.ETag = object.Etag
+	}
+	if attributes["Checksum"] && object.ChecksumAlgorithm != "" {
+		response.Checksum = &ObjectChecksum{}
+		switch object.ChecksumAlgorithm {
+		case CHECKSUM_CRC32:
+			response.Checksum.ChecksumCRC32 = object.Checksum
+		case CHECKSUM_CRC32C:
+			response.Checksum.ChecksumCRC32C = object.Checksum
+		case CHECKSUM_SHA1:
+			response.Checksum.ChecksumSHA1 = object.Checksum
+		case CHECKSUM_SHA256:
+			response.Checksum.ChecksumSHA256 = object.Checksum
+		}
+	}
+	if attributes["ObjectParts"] && len(object.Parts) != 0 {
+		response.ObjectParts = &ObjectPartsInfo{TotalPartsCount: len(object.Parts)}
+	}
+	if attributes["StorageClass"] {
+		response.StorageClass = object.StorageClass
+		if response.StorageClass == "" {
+			response.StorageClass = STORAGE_CLASS_STANDARD
+		}
+	}
+	if attributes["ObjectSize"] {
+		response.ObjectSize = &object.Size
+	}
+
+	w.Header().Set("Last-Modified", object.LastModifiedTime.UTC().Format(http.TimeFormat))
+	if version != "" {
+		w.Header().Set("x-amz-version-id", version)
+	}
+	api.setRequestChargedHeader(w, r, bucketName, credential)
+	WriteSuccessResponse(w, EncodeResponse(response))
 }
 
 // CopyObjectHandler - Copy Object
@@ -353,6 +548,11 @@ func (api ObjectAPIHandlers) CopyObjectHandler(w http.ResponseWriter, r *http.Re
 	targetBucketName := vars["bucket"]
 	targetObjectName := vars["object"]
 
+	if !isValidObjectName(targetObjectName) {
+		WriteErrorResponse(w, r, ErrInvalidObjectName)
+		return
+	}
+
 	var credential iam.Credential
 	var err error
 	switch signature.GetRequestAuthType(r) {
@@ -415,15 +615,34 @@ func (api ObjectAPIHandlers) CopyObjectHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if sourceBucketName == targetBucketName && sourceObjectName == targetObjectName {
+	metadataDirective := r.Header.Get("X-Amz-Metadata-Directive")
+	if metadataDirective != "" && metadataDirective != "COPY" && metadataDirective != "REPLACE" {
+		WriteErrorResponse(w, r, ErrInvalidMetadataDirective)
+		return
+	}
+	storageClass, err := parseStorageClassHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	ttl, err := parseTtlHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	// An object could only be copied to itself to replace its metadata or
+	// change its storage class
+	sameObject := sourceBucketName == targetBucketName && sourceObjectName == targetObjectName
+	_, storageClassSet := r.Header["X-Amz-Storage-Class"]
+	if sameObject && metadataDirective != "REPLACE" && !storageClassSet {
 		WriteErrorResponse(w, r, ErrInvalidCopyDest)
 		return
 	}
 
-	helper.Debugln("sourceBucketName", sourceBucketName, "sourceObjectName", sourceObjectName,
+	helper.DebuglnContext(r.Context(), "sourceBucketName", sourceBucketName, "sourceObjectName", sourceObjectName,
 		"sourceVersion", sourceVersion)
 
-	sourceObject, err := api.ObjectAPI.GetObjectInfo(sourceBucketName, sourceObjectName,
+	sourceObject, err := api.ObjectAPI.GetObjectInfo(r.Context(), sourceBucketName, sourceObjectName,
 		sourceVersion, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to fetch object info.")
@@ -436,9 +655,15 @@ func (api ObjectAPIHandlers) CopyObjectHandler(w http.ResponseWriter, r *http.Re
 		WriteErrorResponse(w, r, err)
 		return
 	}
+	sourceSseRequest, err := copySourceSseRequest(sourceObject.SseType, sseRequest)
+	if err != nil {
+		WriteErrorResponseWithResource(w, r, err, copySource)
+		return
+	}
 
-	// Verify before x-amz-copy-source preconditions before continuing with CopyObject.
-	if err = checkObjectPreconditions(w, r, sourceObject); err != nil {
+	// Verify x-amz-copy-source preconditions against the resolved source
+	// version before anything is written.
+	if err = checkObjectPreconditions(r.Header, sourceObject); err != nil {
 		WriteErrorResponse(w, r, err)
 		return
 	}
@@ -449,20 +674,6 @@ func (api ObjectAPIHandlers) CopyObjectHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	pipeReader, pipeWriter := io.Pipe()
-	go func() {
-		startOffset := int64(0) // Read the whole file.
-		// Get the object.
-		err = api.ObjectAPI.GetObject(sourceObject, startOffset, sourceObject.Size,
-			pipeWriter, sseRequest)
-		if err != nil {
-			helper.ErrorIf(err, "Unable to read an object.")
-			pipeWriter.CloseWithError(err)
-			return
-		}
-		pipeWriter.Close()
-	}()
-
 	targetAcl, err := getAclFromHeader(r.Header)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
@@ -479,9 +690,61 @@ func (api ObjectAPIHandlers) CopyObjectHandler(w http.ResponseWriter, r *http.Re
 	targetObject.ContentType = sourceObject.ContentType
 	targetObject.CustomAttributes = sourceObject.CustomAttributes
 	targetObject.Parts = sourceObject.Parts
+	targetObject.StorageClass = storageClass
+	if metadataDirective == "REPLACE" {
+		metadata := extractMetadataFromHeader(r.Header)
+		targetObject.ContentType = metadata["Content-Type"]
+		targetObject.CustomAttributes = metadata
+	}
+
+	// The copy expires `ttl` seconds after it's made, or keeps expire time of
+	// the source if it's copied to itself
+	if ttl > 0 {
+		targetObject.ExpireTime = time.Now().UTC().Add(time.Duration(ttl) * time.Second)
+	} else if sameObject {
+		targetObject.ExpireTime = sourceObject.ExpireTime
+	}
+
+	// Copying the latest version to itself only replaces its metadata, data is
+	// rewritten only if encryption or storage class changes, or a new version
+	// has to be created, which is up to the object layer. SSE-C objects are
+	// always rewritten so the customer key is verified
+	var source io.Reader
+	if sameObject && sourceVersion == "" && sourceObject.SseType != "C" &&
+		(sseRequest.Type == "" || sseRequest.Type == sourceObject.SseType) &&
+		targetObject.StorageClass == sourceObject.StorageClass {
+
+		replaced := *sourceObject
+		replaced.ACL = targetObject.ACL
+		replaced.ContentType = targetObject.ContentType
+		replaced.CustomAttributes = targetObject.CustomAttributes
+		replaced.ExpireTime = targetObject.ExpireTime
+		targetObject = &replaced
+	} else {
+		sourceReader := &copySourceReader{open: func() *io.PipeReader {
+			pipeReader, pipeWriter := io.Pipe()
+			go func() {
+				startOffset := int64(0) // Read the whole file.
+				// Get the object.
+				err := api.ObjectAPI.GetObject(r.Context(), sourceObject, startOffset, sourceObject.Size,
+					pipeWriter, sourceSseRequest)
+				if err != nil {
+					helper.ErrorIf(err, "Unable to read an object.")
+					pipeWriter.CloseWithError(err)
+					return
+				}
+				pipeWriter.Close()
+			}()
+			return pipeReader
+		}}
+		// Explicitly close the reader, to avoid fd leaks.
+		defer sourceReader.Close()
+		source = sourceReader
+	}
 
 	// Create the object.
-	result, err := api.ObjectAPI.CopyObject(targetObject, pipeReader, credential, sseRequest)
+	result, err := api.ObjectAPI.CopyObject(r.Context(), targetObject, sourceObject, source,
+		credential, sseRequest)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to copy object from "+
 			sourceObjectName+" to "+targetObjectName)
@@ -512,17 +775,18 @@ func (api ObjectAPIHandlers) CopyObjectHandler(w http.ResponseWriter, r *http.Re
 			w.Header().Set(headerName, header)
 		}
 	}
+	if result.SseType == "S3" { // in case of bucket default encryption
+		w.Header().Set("X-Amz-Server-Side-Encryption", "AES256")
+	}
 	// write success response.
 	WriteSuccessResponse(w, encodedSuccessResponse)
-	// Explicitly close the reader, to avoid fd leaks.
-	pipeReader.Close()
 }
 
 // PutObjectHandler - PUT Object
 // ----------
 // This implementation of the PUT operation adds an object to a bucket.
 func (api ObjectAPIHandlers) PutObjectHandler(w http.ResponseWriter, r *http.Request) {
-	helper.Debugln("PutObjectHandler", "enter")
+	helper.DebuglnContext(r.Context(), "PutObjectHandler", "enter")
 	// If the matching failed, it means that the X-Amz-Copy-Source was
 	// wrong, fail right here.
 	if _, ok := r.Header["X-Amz-Copy-Source"]; ok {
@@ -543,6 +807,14 @@ func (api ObjectAPIHandlers) PutObjectHandler(w http.ResponseWriter, r *http.Req
 	if _, ok := r.Header["Content-Length"]; !ok {
 		size = -1
 	}
+	if signature.IsStreamingUpload(r) {
+		decodedSize, err := signature.DecodedContentLength(r)
+		if err != nil {
+			WriteErrorResponse(w, r, err)
+			return
+		}
+		size = decodedSize
+	}
 	if size == -1 && !contains(r.TransferEncoding, "chunked") {
 		WriteErrorResponse(w, r, ErrMissingContentLength)
 		return
@@ -560,13 +832,13 @@ func (api ObjectAPIHandlers) PutObjectHandler(w http.ResponseWriter, r *http.Req
 		metadata["md5Sum"] = ""
 	} else {
 		if len(r.Header.Get("Content-Md5")) == 0 {
-			helper.Debugln("Content Md5 is null!")
+			helper.DebuglnContext(r.Context(), "Content Md5 is null!")
 			WriteErrorResponse(w, r, ErrInvalidDigest)
 			return
 		}
 		md5Bytes, err := checkValidMD5(r.Header.Get("Content-Md5"))
 		if err != nil {
-			helper.Debugln("Content Md5 is invalid!")
+			helper.DebuglnContext(r.Context(), "Content Md5 is invalid!")
 			WriteErrorResponse(w, r, ErrInvalidDigest)
 			return
 		} else {
@@ -574,6 +846,28 @@ func (api ObjectAPIHandlers) PutObjectHandler(w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	ttl, err := parseTtlHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if ttl > 0 {
+		metadata["ttl"] = strconv.FormatInt(ttl, 10)
+	}
+	metadata["storageClass"], err = parseStorageClassHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	checksums, err := parseChecksumHeaders(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	metadata["contentSha256"] = checksums.ContentSha256
+	metadata["checksumAlgorithm"] = checksums.Algorithm
+	metadata["checksum"] = checksums.Checksum
+
 	// Parse SSE related headers
 	sseRequest, err := parseSseHeader(r.Header)
 	if err != nil {
@@ -593,8 +887,15 @@ func (api ObjectAPIHandlers) PutObjectHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	dataReader, release, err := limitUpload(r.Context(), dataReader, size)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	defer release()
+
 	var result PutObjectResult
-	result, err = api.ObjectAPI.PutObject(bucketName, objectName, credential, size, dataReader,
+	result, err = api.ObjectAPI.PutObject(r.Context(), bucketName, objectName, credential, size, dataReader,
 		metadata, acl, sseRequest)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to create object "+objectName)
@@ -619,6 +920,175 @@ func (api ObjectAPIHandlers) PutObjectHandler(w http.ResponseWriter, r *http.Req
 			w.Header().Set(headerName, header)
 		}
 	}
+	if result.SseType == "S3" { // in case of bucket default encryption
+		w.Header().Set("X-Amz-Server-Side-Encryption", "AES256")
+	}
+	api.setRequestChargedHeader(w, r, bucketName, credential)
+	WriteSuccessResponse(w, nil)
+}
+
+// AppendObjectHandler - POST Object?append&position=N
+// ----------
+// Appends data to an appendable object at position N, which should be the
+// current length of the object, the object is created if N is 0.
+// Position for the next append is returned in X-Yig-Next-Append-Position
+func (api ObjectAPIHandlers) AppendObjectHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	if !isValidObjectName(objectName) {
+		WriteErrorResponse(w, r, ErrInvalidObjectName)
+		return
+	}
+	position, err := strconv.ParseInt(vars["position"], 10, 64)
+	if err != nil || position < 0 {
+		WriteErrorResponse(w, r, ErrInvalidPosition)
+		return
+	}
+
+	// length of data appended should be known in advance
+	size := r.ContentLength
+	if _, ok := r.Header["Content-Length"]; !ok {
+		size = -1
+	}
+	if signature.IsStreamingUpload(r) {
+		size, err = signature.DecodedContentLength(r)
+		if err != nil {
+			WriteErrorResponse(w, r, err)
+			return
+		}
+	}
+	if size == -1 {
+		WriteErrorResponse(w, r, ErrMissingContentLength)
+		return
+	}
+	if isMaxObjectSize(size) {
+		WriteErrorResponse(w, r, ErrEntityTooLarge)
+		return
+	}
+
+	// appendable objects are always STANDARD
+	storageClass, err := parseStorageClassHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if storageClass != STORAGE_CLASS_STANDARD {
+		WriteErrorResponse(w, r, ErrNotImplemented)
+		return
+	}
+
+	metadata := extractMetadataFromHeader(r.Header)
+	if _, ok := r.Header["Content-Md5"]; !ok {
+		metadata["md5Sum"] = ""
+	} else {
+		md5Bytes, err := checkValidMD5(r.Header.Get("Content-Md5"))
+		if err != nil || len(md5Bytes) == 0 {
+			WriteErrorResponse(w, r, ErrInvalidDigest)
+			return
+		}
+		metadata["md5Sum"] = hex.EncodeToString(md5Bytes)
+	}
+
+	sseRequest, err := parseSseHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	acl, err := getAclFromHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	credential, dataReader, err := signature.VerifyUpload(r)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	dataReader, release, err := limitUpload(r.Context(), dataReader, size)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	defer release()
+
+	result, err := api.ObjectAPI.AppendObject(r.Context(), bucketName, objectName, credential,
+		position, size, dataReader, metadata, acl, sseRequest)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to append object %s", objectName)
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header()["ETag"] = []string{"\"" + result.Md5 + "\""}
+	w.Header().Set("X-Yig-Next-Append-Position", strconv.FormatInt(result.NextPosition, 10))
+	api.setRequestChargedHeader(w, r, bucketName, credential)
+	WriteSuccessResponse(w, nil)
+}
+
+// RenameObjectHandler - PUT Object with X-Amz-Rename-Source
+// ----------
+// Moves an object to a new name within the same bucket, without copying
+// its data. The source is of form /bucket-name/object-name
+func (api ObjectAPIHandlers) RenameObjectHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	if !isValidObjectName(objectName) {
+		WriteErrorResponse(w, r, ErrInvalidObjectName)
+		return
+	}
+
+	var credential iam.Credential
+	var err error
+	switch signature.GetRequestAuthType(r) {
+	default:
+		// For all unknown auth types return error.
+		WriteErrorResponse(w, r, ErrAccessDenied)
+		return
+	case signature.AuthTypeAnonymous:
+		break
+	case signature.AuthTypePresignedV4, signature.AuthTypeSignedV4,
+		signature.AuthTypePresignedV2, signature.AuthTypeSignedV2:
+		if credential, err = signature.IsReqAuthenticated(r); err != nil {
+			WriteErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// X-Amz-Rename-Source should be URL-encoded, like X-Amz-Copy-Source
+	renameSource := strings.TrimPrefix(r.Header.Get("X-Amz-Rename-Source"), "/")
+	splits := strings.SplitN(renameSource, "/", 2)
+	if len(splits) != 2 {
+		WriteErrorResponse(w, r, ErrInvalidRenameSource)
+		return
+	}
+	sourceBucketName, err := url.QueryUnescape(splits[0])
+	if err != nil || sourceBucketName != bucketName {
+		WriteErrorResponse(w, r, ErrInvalidRenameSource)
+		return
+	}
+	sourceObjectName, err := url.QueryUnescape(splits[1])
+	if err != nil || sourceObjectName == "" {
+		WriteErrorResponse(w, r, ErrInvalidRenameSource)
+		return
+	}
+
+	result, err := api.ObjectAPI.RenameObject(r.Context(), bucketName, sourceObjectName,
+		objectName, credential)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to rename object %s", sourceObjectName)
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header()["ETag"] = []string{"\"" + result.Md5 + "\""}
+	api.setRequestChargedHeader(w, r, bucketName, credential)
 	WriteSuccessResponse(w, nil)
 }
 
@@ -645,10 +1115,14 @@ func (api ObjectAPIHandlers) PutObjectAclHandler(w http.ResponseWriter, r *http.
 	}
 	var acl Acl
 	var policy AccessControlPolicy
-	if _, ok := r.Header["X-Amz-Acl"]; ok {
+	if _, ok := r.Header["X-Amz-Acl"]; ok || hasGrantHeaders(r.Header) {
 		acl, err = getAclFromHeader(r.Header)
+		if err != nil {
+			WriteErrorResponse(w, r, err)
+			return
+		}
 	} else {
-		aclBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+		aclBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_ACL_BODY_SIZE))
 		if err != nil {
 			helper.ErrorIf(err, "Unable to read acls body")
 			WriteErrorResponse(w, r, ErrInvalidAcl)
@@ -657,13 +1131,13 @@ func (api ObjectAPIHandlers) PutObjectAclHandler(w http.ResponseWriter, r *http.
 		err = xml.Unmarshal(aclBuffer, &policy)
 		if err != nil {
 			helper.ErrorIf(err, "Unable to Unmarshal xml for acl")
-			WriteErrorResponse(w, r, ErrInternalError)
+			WriteErrorResponse(w, r, ErrMalformedACLError)
 			return
 		}
 	}
 
 	version := r.URL.Query().Get("versionId")
-	err = api.ObjectAPI.SetObjectAcl(bucketName, objectName, version, policy, acl, credential)
+	err = api.ObjectAPI.SetObjectAcl(r.Context(), bucketName, objectName, version, policy, acl, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to set ACL for object")
 		WriteErrorResponse(w, r, err)
@@ -698,7 +1172,7 @@ func (api ObjectAPIHandlers) GetObjectAclHandler(w http.ResponseWriter, r *http.
 	}
 
 	version := r.URL.Query().Get("versionId")
-	policy, err := api.ObjectAPI.GetObjectAcl(bucketName, objectName, version, credential)
+	policy, err := api.ObjectAPI.GetObjectAcl(r.Context(), bucketName, objectName, version, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to fetch object policy.")
 		WriteErrorResponse(w, r, err)
@@ -718,6 +1192,208 @@ func (api ObjectAPIHandlers) GetObjectAclHandler(w http.ResponseWriter, r *http.
 	WriteSuccessResponse(w, aclBuffer)
 }
 
+// Read body of Object Lock requests, error response is written if not ok
+func readObjectLockBody(w http.ResponseWriter, r *http.Request) (buffer []byte, ok bool) {
+	return readSmallBody(w, r, MAX_OBJECT_LOCK_SIZE)
+}
+
+// Reads XML body of a request of at most `limit` bytes, error response is
+// written if not ok
+func readSmallBody(w http.ResponseWriter, r *http.Request, limit int64) (buffer []byte, ok bool) {
+	// If Content-Length is unknown or zero, deny the request.
+	if !contains(r.TransferEncoding, "chunked") {
+		if r.ContentLength == -1 || r.ContentLength == 0 {
+			WriteErrorResponse(w, r, ErrMissingContentLength)
+			return nil, false
+		}
+		if r.ContentLength > limit {
+			WriteErrorResponse(w, r, ErrEntityTooLarge)
+			return nil, false
+		}
+	}
+	buffer, err := ioutil.ReadAll(io.LimitReader(r.Body, limit))
+	if err != nil {
+		helper.ErrorIf(err, "Unable to read request body")
+		WriteErrorResponse(w, r, err)
+		return nil, false
+	}
+	return buffer, true
+}
+
+func (api ObjectAPIHandlers) PutObjectRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	retentionBuffer, ok := readObjectLockBody(w, r)
+	if !ok {
+		return
+	}
+	retention, err := ObjectRetentionFromXml(retentionBuffer, time.Now())
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	version := r.URL.Query().Get("versionId")
+	bypassGovernance := r.Header.Get("X-Amz-Bypass-Governance-Retention") == "true"
+	err = api.ObjectAPI.PutObjectRetention(r.Context(), bucketName, objectName, version, retention,
+		bypassGovernance, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if version != "" {
+		w.Header().Set("x-amz-version-id", version)
+	}
+	WriteSuccessResponse(w, nil)
+}
+
+func (api ObjectAPIHandlers) GetObjectRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	version := r.URL.Query().Get("versionId")
+	retention, err := api.ObjectAPI.GetObjectRetention(r.Context(), bucketName, objectName, version, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	retentionBuffer, err := xml.Marshal(retention)
+	if err != nil {
+		helper.ErrorIf(err, "Failed to marshal retention XML for object %s", objectName)
+		WriteErrorResponse(w, r, ErrInternalError)
+		return
+	}
+	if version != "" {
+		w.Header().Set("x-amz-version-id", version)
+	}
+	WriteSuccessResponse(w, retentionBuffer)
+}
+
+func (api ObjectAPIHandlers) PutObjectLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	legalHoldBuffer, ok := readObjectLockBody(w, r)
+	if !ok {
+		return
+	}
+	legalHold, err := ObjectLegalHoldFromXml(legalHoldBuffer)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	version := r.URL.Query().Get("versionId")
+	err = api.ObjectAPI.PutObjectLegalHold(r.Context(), bucketName, objectName, version, legalHold, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if version != "" {
+		w.Header().Set("x-amz-version-id", version)
+	}
+	WriteSuccessResponse(w, nil)
+}
+
+// RestoreObjectHandler - POST Object restore
+// Restores complete at once, so 202 Accepted is returned for a new restore
+// and 200 OK if the object is restored already, as S3 does
+func (api ObjectAPIHandlers) RestoreObjectHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	restoreBuffer, ok := readSmallBody(w, r, MAX_RESTORE_REQUEST_SIZE)
+	if !ok {
+		return
+	}
+	request, err := RestoreRequestFromXml(restoreBuffer)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	version := r.URL.Query().Get("versionId")
+	restored, err := api.ObjectAPI.RestoreObject(r.Context(), bucketName, objectName, version,
+		request, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if version != "" {
+		w.Header().Set("x-amz-version-id", version)
+	}
+	if restored {
+		WriteSuccessResponse(w, nil)
+	} else {
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (api ObjectAPIHandlers) GetObjectLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	var credential iam.Credential
+	var err error
+	if credential, err = signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	version := r.URL.Query().Get("versionId")
+	legalHold, err := api.ObjectAPI.GetObjectLegalHold(r.Context(), bucketName, objectName, version, credential)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	legalHoldBuffer, err := xml.Marshal(legalHold)
+	if err != nil {
+		helper.ErrorIf(err, "Failed to marshal legal hold XML for object %s", objectName)
+		WriteErrorResponse(w, r, ErrInternalError)
+		return
+	}
+	if version != "" {
+		w.Header().Set("x-amz-version-id", version)
+	}
+	WriteSuccessResponse(w, legalHoldBuffer)
+}
+
 /// Multipart objectAPIHandlers
 
 // NewMultipartUploadHandler - New multipart upload
@@ -756,6 +1432,20 @@ func (api ObjectAPIHandlers) NewMultipartUploadHandler(w http.ResponseWriter, r
 
 	// Save metadata.
 	metadata := extractMetadataFromHeader(r.Header)
+	metadata["storageClass"], err = parseStorageClassHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	// the object completed expires as if it's put when completed
+	ttl, err := parseTtlHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if ttl > 0 {
+		metadata["ttl"] = strconv.FormatInt(ttl, 10)
+	}
 
 	sseRequest, err := parseSseHeader(r.Header)
 	if err != nil {
@@ -763,7 +1453,7 @@ func (api ObjectAPIHandlers) NewMultipartUploadHandler(w http.ResponseWriter, r
 		return
 	}
 
-	uploadID, err := api.ObjectAPI.NewMultipartUpload(credential, bucketName, objectName,
+	uploadID, err := api.ObjectAPI.NewMultipartUpload(r.Context(), credential, bucketName, objectName,
 		metadata, acl, sseRequest)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to initiate new multipart upload id.")
@@ -809,6 +1499,13 @@ func (api ObjectAPIHandlers) PutObjectPartHandler(w http.ResponseWriter, r *http
 		WriteErrorResponse(w, r, ErrMissingContentLength)
 		return
 	}
+	if signature.IsStreamingUpload(r) {
+		size, err = signature.DecodedContentLength(r)
+		if err != nil {
+			WriteErrorResponse(w, r, err)
+			return
+		}
+	}
 
 	/// maximum Upload size for multipart objects in a single operation
 	if isMaxObjectSize(size) {
@@ -836,6 +1533,11 @@ func (api ObjectAPIHandlers) PutObjectPartHandler(w http.ResponseWriter, r *http
 		WriteErrorResponse(w, r, ErrInvalidSseHeader)
 		return
 	}
+	checksums, err := parseChecksumHeaders(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
 
 	credential, dataReader, err := signature.VerifyUpload(r)
 	if err != nil {
@@ -843,10 +1545,17 @@ func (api ObjectAPIHandlers) PutObjectPartHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	dataReader, release, err := limitUpload(r.Context(), dataReader, size)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	defer release()
+
 	var result PutObjectPartResult
 	// No need to verify signature, anonymous request access is already allowed.
-	result, err = api.ObjectAPI.PutObjectPart(bucketName, objectName, credential,
-		uploadID, partID, size, dataReader, incomingMd5, sseRequest)
+	result, err = api.ObjectAPI.PutObjectPart(r.Context(), bucketName, objectName, credential,
+		uploadID, partID, size, dataReader, incomingMd5, checksums, sseRequest)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to create object part for "+objectName)
 		// Verify if the underlying error is signature mismatch.
@@ -960,7 +1669,7 @@ func (api ObjectAPIHandlers) CopyObjectPartHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	sourceObject, err := api.ObjectAPI.GetObjectInfo(sourceBucketName, sourceObjectName,
+	sourceObject, err := api.ObjectAPI.GetObjectInfo(r.Context(), sourceBucketName, sourceObjectName,
 		sourceVersion, credential)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to fetch object info.")
@@ -973,9 +1682,14 @@ func (api ObjectAPIHandlers) CopyObjectPartHandler(w http.ResponseWriter, r *htt
 		WriteErrorResponseWithResource(w, r, err, copySource)
 		return
 	}
+	sourceSseRequest, err := copySourceSseRequest(sourceObject.SseType, sseRequest)
+	if err != nil {
+		WriteErrorResponseWithResource(w, r, err, copySource)
+		return
+	}
 
-	// Verify before x-amz-copy-source preconditions before continuing with CopyObject.
-	if err = checkObjectPreconditions(w, r, sourceObject); err != nil {
+	// Verify x-amz-copy-source preconditions before reading the part.
+	if err = checkObjectPreconditions(r.Header, sourceObject); err != nil {
 		WriteErrorResponse(w, r, err)
 		return
 	}
@@ -1007,8 +1721,8 @@ func (api ObjectAPIHandlers) CopyObjectPartHandler(w http.ResponseWriter, r *htt
 	pipeReader, pipeWriter := io.Pipe()
 	defer pipeReader.Close()
 	go func() {
-		err = api.ObjectAPI.GetObject(sourceObject, readOffset, readLength,
-			pipeWriter, sseRequest)
+		err = api.ObjectAPI.GetObject(r.Context(), sourceObject, readOffset, readLength,
+			pipeWriter, sourceSseRequest)
 		if err != nil {
 			helper.ErrorIf(err, "Unable to read an object.")
 			pipeWriter.CloseWithError(err)
@@ -1018,7 +1732,7 @@ func (api ObjectAPIHandlers) CopyObjectPartHandler(w http.ResponseWriter, r *htt
 	}()
 
 	// Create the object.
-	result, err := api.ObjectAPI.CopyObjectPart(targetBucketName, targetObjectName, targetUploadId,
+	result, err := api.ObjectAPI.CopyObjectPart(r.Context(), targetBucketName, targetObjectName, targetUploadId,
 		targetPartId, readLength, pipeReader, credential, sseRequest)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to copy object part from "+sourceObjectName+
@@ -1075,7 +1789,7 @@ func (api ObjectAPIHandlers) AbortMultipartUploadHandler(w http.ResponseWriter,
 	}
 
 	uploadId := r.URL.Query().Get("uploadId")
-	if err := api.ObjectAPI.AbortMultipartUpload(credential, bucketName,
+	if err := api.ObjectAPI.AbortMultipartUpload(r.Context(), credential, bucketName,
 		objectName, uploadId); err != nil {
 
 		helper.ErrorIf(err, "Unable to abort multipart upload.")
@@ -1113,7 +1827,7 @@ func (api ObjectAPIHandlers) ListObjectPartsHandler(w http.ResponseWriter, r *ht
 		WriteErrorResponse(w, r, err)
 		return
 	}
-	listPartsInfo, err := api.ObjectAPI.ListObjectParts(credential, bucketName,
+	listPartsInfo, err := api.ObjectAPI.ListObjectParts(r.Context(), credential, bucketName,
 		objectName, request)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to list uploaded parts.")
@@ -1153,7 +1867,7 @@ func (api ObjectAPIHandlers) CompleteMultipartUploadHandler(w http.ResponseWrite
 	completeMultipartBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		helper.ErrorIf(err, "Unable to complete multipart upload.")
-		WriteErrorResponse(w, r, ErrInternalError)
+		WriteErrorResponse(w, r, err)
 		return
 	}
 	complMultipartUpload := &meta.CompleteMultipartUpload{}
@@ -1179,7 +1893,7 @@ func (api ObjectAPIHandlers) CompleteMultipartUploadHandler(w http.ResponseWrite
 	}
 
 	var result CompleteMultipartResult
-	result, err = api.ObjectAPI.CompleteMultipartUpload(credential, bucketName,
+	result, err = api.ObjectAPI.CompleteMultipartUpload(r.Context(), credential, bucketName,
 		objectName, uploadId, completeParts)
 
 	if err != nil {
@@ -1255,7 +1969,9 @@ func (api ObjectAPIHandlers) DeleteObjectHandler(w http.ResponseWriter, r *http.
 	/// http://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectDELETE.html
 	/// Ignore delete object errors, since we are supposed to reply
 	/// only 204.
-	result, err := api.ObjectAPI.DeleteObject(bucketName, objectName, version, credential)
+	bypassGovernance := r.Header.Get("X-Amz-Bypass-Governance-Retention") == "true"
+	result, err := api.ObjectAPI.DeleteObject(r.Context(), bucketName, objectName, version, credential,
+		bypassGovernance)
 	if err != nil {
 		WriteErrorResponse(w, r, err)
 		return
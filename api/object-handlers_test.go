@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	router "github.com/gorilla/mux"
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+type bucketsLayer struct {
+	ObjectLayer
+	buckets map[string]meta.Bucket
+}
+
+func (l bucketsLayer) GetBucket(ctx context.Context, bucketName string) (meta.Bucket, error) {
+	bucket, ok := l.buckets[bucketName]
+	if !ok {
+		return bucket, ErrNoSuchBucket
+	}
+	return bucket, nil
+}
+
+func TestSetRequestChargedHeader(t *testing.T) {
+	api := ObjectAPIHandlers{ObjectAPI: bucketsLayer{buckets: map[string]meta.Bucket{
+		"paid": {Name: "paid", OwnerId: "alice", RequestPayer: PAYER_REQUESTER},
+		"free": {Name: "free", OwnerId: "alice"},
+	}}}
+	cases := []struct {
+		bucket   string
+		userId   string
+		payer    string
+		expected string
+	}{
+		{"paid", "bob", "requester", "requester"},
+		{"paid", "alice", "requester", ""}, // owner
+		{"paid", "bob", "", ""},            // not acknowledged by requester
+		{"paid", "", "requester", ""},      // anonymous
+		{"free", "bob", "requester", ""},
+		{"nonexist", "bob", "requester", ""},
+	}
+	for i, c := range cases {
+		r := signedV2Request("hehe", c.bucket)
+		if c.payer != "" {
+			r.Header.Set("X-Amz-Request-Payer", c.payer)
+		}
+		recorder := httptest.NewRecorder()
+		api.setRequestChargedHeader(recorder, r, c.bucket, iam.Credential{UserId: c.userId})
+		if charged := recorder.Header().Get("X-Amz-Request-Charged"); charged != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, charged)
+		}
+	}
+}
+
+func TestSetExpirationHeader(t *testing.T) {
+	lc := Lc{Rule: []LcRule{
+		{ID: "default", Prefix: "", Status: "Enabled", Expiration: "30"},
+		{ID: "logs", Prefix: "logs/", Status: "Enabled", Expiration: "7"},
+		{ID: "broken", Prefix: "tmp/", Status: "Enabled", Expiration: "hehe"},
+	}}
+	api := ObjectAPIHandlers{ObjectAPI: bucketsLayer{buckets: map[string]meta.Bucket{
+		"lc":   {Name: "lc", LC: lc},
+		"free": {Name: "free"},
+	}}}
+	modified := time.Date(2018, 1, 1, 8, 0, 0, 0, time.UTC)
+	cases := []struct {
+		bucket   string
+		object   string
+		expected string
+	}{
+		{"lc", "a", `expiry-date="Wed, 31 Jan 2018 08:00:00 GMT", rule-id="default"`},
+		{"lc", "logs/a", `expiry-date="Mon, 08 Jan 2018 08:00:00 GMT", rule-id="logs"`},
+		{"lc", "tmp/a", ""},
+		{"free", "a", ""},
+		{"nonexist", "a", ""},
+	}
+	for i, c := range cases {
+		recorder := httptest.NewRecorder()
+		api.setExpirationHeader(context.Background(), recorder, &meta.Object{BucketName: c.bucket, Name: c.object,
+			LastModifiedTime: modified})
+		if expiration := recorder.Header().Get("x-amz-expiration"); expiration != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, expiration)
+		}
+	}
+}
+
+type objectsLayer struct {
+	bucketsLayer
+	objects map[string]*meta.Object // bucket/object -> object
+}
+
+func (l objectsLayer) GetObjectInfo(ctx context.Context, bucketName, objectName, version string,
+	credential iam.Credential) (*meta.Object, error) {
+
+	object, ok := l.objects[bucketName+"/"+objectName]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	return object, nil
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"deflate, gzip;q=0.8":   true,
+		"GZIP":                  true,
+		"gzip;q=0":              false,
+		"gzip; q=0.0, identity": false,
+		"*":                     true,
+		"br, deflate":           false,
+	}
+	for acceptEncoding, expected := range cases {
+		if acceptsGzip(acceptEncoding) != expected {
+			t.Errorf("%q: expected %v", acceptEncoding, expected)
+		}
+	}
+}
+
+func TestGzipVariant(t *testing.T) {
+	api := ObjectAPIHandlers{ObjectAPI: objectsLayer{
+		bucketsLayer: bucketsLayer{buckets: map[string]meta.Bucket{
+			"site":  {Name: "site", GzipVariants: true},
+			"plain": {Name: "plain"},
+		}},
+		objects: map[string]*meta.Object{
+			"site/app.js.gz":  {BucketName: "site", Name: "app.js.gz", ContentType: "application/gzip"},
+			"plain/app.js.gz": {BucketName: "plain", Name: "app.js.gz", ContentType: "application/gzip"},
+		},
+	}}
+	cases := []struct {
+		bucket         string
+		object         string
+		acceptEncoding string
+		expected       string
+		vary           bool
+	}{
+		{"site", "app.js", "gzip, deflate", "app.js.gz", true},
+		{"site", "app.js", "", "app.js", true},       // client does not accept gzip
+		{"site", "app.css", "gzip", "app.css", true}, // no variant
+		{"site", "app.js.gz", "gzip", "app.js.gz", false},
+		{"plain", "app.js", "gzip", "app.js", false}, // not enabled
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/"+c.bucket+"/"+c.object, nil)
+		if c.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		}
+		recorder := httptest.NewRecorder()
+		original := &meta.Object{BucketName: c.bucket, Name: c.object, ContentType: "text/javascript"}
+		object, gzipped := api.gzipVariant(recorder, r, original, iam.Credential{})
+		if object.Name != c.expected || gzipped != (c.expected != c.object) {
+			t.Errorf("case %d: expected %s, got %s %v", i, c.expected, object.Name, gzipped)
+		}
+		if object.ContentType != "text/javascript" && c.object != c.expected {
+			t.Errorf("case %d: content type of original should be kept, got %s", i, object.ContentType)
+		}
+		if (recorder.Header().Get("Vary") == "Accept-Encoding") != c.vary {
+			t.Errorf("case %d: unexpected Vary %q", i, recorder.Header().Get("Vary"))
+		}
+	}
+}
+
+// Data of objects is their name repeated
+func (l objectsLayer) GetObject(ctx context.Context, object *meta.Object, startOffset int64, length int64,
+	writer io.Writer, sse SseRequest) error {
+
+	data := strings.Repeat(object.Name, int(object.Size))[:object.Size]
+	_, err := io.WriteString(writer, data[startOffset:startOffset+length])
+	return err
+}
+
+func TestHeadObjectHeaders(t *testing.T) {
+	api := ObjectAPIHandlers{ObjectAPI: objectsLayer{
+		bucketsLayer: bucketsLayer{buckets: map[string]meta.Bucket{
+			"bucket": {Name: "bucket"},
+		}},
+		objects: map[string]*meta.Object{
+			"bucket/hehe": {BucketName: "bucket", Name: "hehe", Size: 10, Etag: "etag",
+				ContentType: "text/plain", LastModifiedTime: time.Now(), SseType: "S3",
+				StorageClass: "STANDARD_IA", CustomAttributes: map[string]string{"X-Amz-Meta-Hehe": "haha"}},
+		},
+	}}
+	mux := router.NewRouter()
+	RegisterAPIRouter(mux, api)
+	handler := SetLogHandler(SetCommonHeaderHandler(mux, nil), nil)
+
+	for _, c := range []struct {
+		query, rangeHeader string
+		status             int
+		length             string
+	}{
+		{"", "", http.StatusOK, "10"},
+		{"?versionId=hehe", "", http.StatusOK, "10"},
+		{"?response-content-type=text/html", "", http.StatusOK, "10"},
+		{"", "bytes=2-5", http.StatusPartialContent, "4"},
+	} {
+		var headers []http.Header
+		for _, method := range []string{"GET", "HEAD"} {
+			r := httptest.NewRequest(method, "http://s3.test.com/bucket/hehe"+c.query, nil)
+			if c.rangeHeader != "" {
+				r.Header.Set("Range", c.rangeHeader)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, r)
+			response := recorder.Result()
+			if response.StatusCode != c.status || response.Header.Get("Content-Length") != c.length {
+				t.Errorf("%s %s %s: expected %d with length %s, got %d %s", method, c.query,
+					c.rangeHeader, c.status, c.length, response.StatusCode,
+					response.Header.Get("Content-Length"))
+			}
+			if method == "HEAD" && recorder.Body.Len() != 0 {
+				t.Errorf("%s %s: HEAD should have no body", c.query, c.rangeHeader)
+			}
+			// the only header differs for each request
+			response.Header.Del("X-Amz-Request-Id")
+			headers = append(headers, response.Header)
+		}
+		if !reflect.DeepEqual(headers[0], headers[1]) {
+			t.Errorf("%s %s: HEAD and GET headers differ\n%v\n%v", c.query, c.rangeHeader,
+				headers[0], headers[1])
+		}
+		for _, h := range []string{"Accept-Ranges", "Last-Modified", "ETag", "Content-Type",
+			"X-Amz-Meta-Hehe", "X-Amz-Server-Side-Encryption", "X-Amz-Storage-Class"} {
+			if headers[1].Get(h) == "" && headers[1][h] == nil {
+				t.Errorf("%s %s: %s should be set", c.query, c.rangeHeader, h)
+			}
+		}
+	}
+}
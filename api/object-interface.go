@@ -17,6 +17,7 @@
 package api
 
 import (
+	"context"
 	"github.com/journeymidnight/yig/api/datatype"
 	"github.com/journeymidnight/yig/iam"
 	meta "github.com/journeymidnight/yig/meta/types"
@@ -26,60 +27,97 @@ import (
 // ObjectLayer implements primitives for object API layer.
 type ObjectLayer interface {
 	// Bucket operations.
-	MakeBucket(bucket string, acl datatype.Acl, credential iam.Credential) error
-	SetBucketLc(bucket string, config datatype.Lc,
+	MakeBucket(ctx context.Context, bucket string, acl datatype.Acl, credential iam.Credential) error
+	SetBucketLc(ctx context.Context, bucket string, config datatype.Lc,
 	credential iam.Credential) error
-	GetBucketLc(bucket string, credential iam.Credential) (datatype.Lc, error)
-	DelBucketLc(bucket string, credential iam.Credential) error
-	SetBucketAcl(bucket string, policy datatype.AccessControlPolicy, acl datatype.Acl,
+	GetBucketLc(ctx context.Context, bucket string, credential iam.Credential) (datatype.Lc, error)
+	DelBucketLc(ctx context.Context, bucket string, credential iam.Credential) error
+	SetBucketAcl(ctx context.Context, bucket string, policy datatype.AccessControlPolicy, acl datatype.Acl,
 		credential iam.Credential) error
-	GetBucketAcl(bucket string, credential iam.Credential) (datatype.AccessControlPolicy, error)
-	SetBucketCors(bucket string, cors datatype.Cors, credential iam.Credential) error
-	SetBucketVersioning(bucket string, versioning datatype.Versioning, credential iam.Credential) error
-	DeleteBucketCors(bucket string, credential iam.Credential) error
-	GetBucketVersioning(bucket string, credential iam.Credential) (datatype.Versioning, error)
-	GetBucketCors(bucket string, credential iam.Credential) (datatype.Cors, error)
-	GetBucket(bucketName string) (bucket meta.Bucket, err error) // For INTERNAL USE ONLY
-	GetBucketInfo(bucket string, credential iam.Credential) (bucketInfo meta.Bucket, err error)
-	ListBuckets(credential iam.Credential) (buckets []meta.Bucket, err error)
-	DeleteBucket(bucket string, credential iam.Credential) error
-	ListObjects(credential iam.Credential, bucket string,
+	GetBucketAcl(ctx context.Context, bucket string, credential iam.Credential) (datatype.AccessControlPolicy, error)
+	SetBucketCors(ctx context.Context, bucket string, cors datatype.Cors, credential iam.Credential) error
+	SetBucketVersioning(ctx context.Context, bucket string, versioning datatype.Versioning, credential iam.Credential) error
+	DeleteBucketCors(ctx context.Context, bucket string, credential iam.Credential) error
+	GetBucketVersioning(ctx context.Context, bucket string, credential iam.Credential) (datatype.Versioning, error)
+	GetBucketCors(ctx context.Context, bucket string, credential iam.Credential) (datatype.Cors, error)
+	SetBucketEncryption(ctx context.Context, bucket string, encryption datatype.Encryption, credential iam.Credential) error
+	DeleteBucketEncryption(ctx context.Context, bucket string, credential iam.Credential) error
+	GetBucketEncryption(ctx context.Context, bucket string, credential iam.Credential) (datatype.Encryption, error)
+	SetBucketObjectLock(ctx context.Context, bucket string, config datatype.ObjectLockConfiguration,
+		credential iam.Credential) error
+	GetBucketObjectLock(ctx context.Context, bucket string, credential iam.Credential) (datatype.ObjectLockConfiguration,
+		error)
+	SetBucketRequestPayment(ctx context.Context, bucket string, payment datatype.RequestPaymentConfiguration,
+		credential iam.Credential) error
+	GetBucketRequestPayment(ctx context.Context, bucket string, credential iam.Credential) (
+		datatype.RequestPaymentConfiguration, error)
+	GetBucket(ctx context.Context, bucketName string) (bucket meta.Bucket, err error) // For INTERNAL USE ONLY
+	GetBucketInfo(ctx context.Context, bucket string, credential iam.Credential) (bucketInfo meta.Bucket, err error)
+	// `continuationToken` is set if there're more buckets to list
+	ListBuckets(ctx context.Context, credential iam.Credential, request datatype.ListBucketsRequest) (
+		buckets []meta.Bucket, continuationToken string, err error)
+	DeleteBucket(ctx context.Context, bucket string, credential iam.Credential) error
+	ListObjects(ctx context.Context, credential iam.Credential, bucket string,
 		request datatype.ListObjectsRequest) (result meta.ListObjectsInfo, err error)
-	ListVersionedObjects(credential iam.Credential, bucket string,
+	ListVersionedObjects(ctx context.Context, credential iam.Credential, bucket string,
 		request datatype.ListObjectsRequest) (result meta.VersionedListObjectsInfo, err error)
 
 	// Object operations.
-	GetObject(object *meta.Object, startOffset int64, length int64, writer io.Writer,
+	GetObject(ctx context.Context, object *meta.Object, startOffset int64, length int64, writer io.Writer,
 		sse datatype.SseRequest) (err error)
-	GetObjectInfo(bucket, object, version string, credential iam.Credential) (objInfo *meta.Object,
+	GetObjectInfo(ctx context.Context, bucket, object, version string, credential iam.Credential) (objInfo *meta.Object,
 		err error)
-	PutObject(bucket, object string, credential iam.Credential, size int64, data io.Reader,
+	PutObject(ctx context.Context, bucket, object string, credential iam.Credential, size int64, data io.Reader,
 		metadata map[string]string, acl datatype.Acl,
 		sse datatype.SseRequest) (result datatype.PutObjectResult, err error)
-	CopyObject(targetObject *meta.Object, source io.Reader, credential iam.Credential,
-		sse datatype.SseRequest) (result datatype.PutObjectResult, err error)
-	SetObjectAcl(bucket string, object string, version string, policy datatype.AccessControlPolicy,
+	AppendObject(ctx context.Context, bucket, object string, credential iam.Credential, position int64, size int64,
+		data io.Reader, metadata map[string]string, acl datatype.Acl,
+		sse datatype.SseRequest) (result datatype.AppendObjectResult, err error)
+	RenameObject(ctx context.Context, bucket, sourceObject, targetObject string,
+		credential iam.Credential) (result datatype.PutObjectResult, err error)
+	// metadata of targetObject is replaced in place if source is nil, data
+	// of sourceObject may be copied without being read from source if it
+	// needs no re-encryption
+	CopyObject(ctx context.Context, targetObject, sourceObject *meta.Object, source io.Reader,
+		credential iam.Credential, sse datatype.SseRequest) (result datatype.PutObjectResult, err error)
+	SetObjectAcl(ctx context.Context, bucket string, object string, version string, policy datatype.AccessControlPolicy,
 		acl datatype.Acl, credential iam.Credential) error
-	GetObjectAcl(bucket string, object string, version string, credential iam.Credential) (
+	GetObjectAcl(ctx context.Context, bucket string, object string, version string, credential iam.Credential) (
 	        policy datatype.AccessControlPolicy, err error)
-	DeleteObject(bucket, object, version string, credential iam.Credential) (datatype.DeleteObjectResult,
-		error)
+	DeleteObject(ctx context.Context, bucket, object, version string, credential iam.Credential,
+		bypassGovernance bool) (datatype.DeleteObjectResult, error)
+	// Delete `objects` one by one as DeleteObject, with metadata cache of
+	// all of them invalidated at once in the end. Results and errors are
+	// in the same order as `objects`
+	DeleteObjects(ctx context.Context, bucket string, objects []datatype.ObjectIdentifier,
+		credential iam.Credential, bypassGovernance bool) ([]datatype.DeleteObjectResult, []error)
+	PutObjectRetention(ctx context.Context, bucket, object, version string, retention datatype.ObjectRetention,
+		bypassGovernance bool, credential iam.Credential) error
+	GetObjectRetention(ctx context.Context, bucket, object, version string, credential iam.Credential) (
+		datatype.ObjectRetention, error)
+	PutObjectLegalHold(ctx context.Context, bucket, object, version string, legalHold datatype.ObjectLegalHold,
+		credential iam.Credential) error
+	GetObjectLegalHold(ctx context.Context, bucket, object, version string, credential iam.Credential) (
+		datatype.ObjectLegalHold, error)
+	// Returns whether the object was restored already
+	RestoreObject(ctx context.Context, bucket, object, version string, request datatype.RestoreRequest,
+		credential iam.Credential) (restored bool, err error)
 
 	// Multipart operations.
-	ListMultipartUploads(credential iam.Credential, bucket string,
+	ListMultipartUploads(ctx context.Context, credential iam.Credential, bucket string,
 		request datatype.ListUploadsRequest) (result datatype.ListMultipartUploadsResponse, err error)
-	NewMultipartUpload(credential iam.Credential, bucket, object string,
+	NewMultipartUpload(ctx context.Context, credential iam.Credential, bucket, object string,
 		metadata map[string]string, acl datatype.Acl,
 		sse datatype.SseRequest) (uploadID string, err error)
-	PutObjectPart(bucket, object string, credential iam.Credential, uploadID string, partID int,
-		size int64, data io.Reader, md5Hex string,
+	PutObjectPart(ctx context.Context, bucket, object string, credential iam.Credential, uploadID string, partID int,
+		size int64, data io.Reader, md5Hex string, checksums datatype.Checksums,
 		sse datatype.SseRequest) (result datatype.PutObjectPartResult, err error)
-	CopyObjectPart(bucketName, objectName, uploadId string, partId int, size int64, data io.Reader,
+	CopyObjectPart(ctx context.Context, bucketName, objectName, uploadId string, partId int, size int64, data io.Reader,
 		credential iam.Credential, sse datatype.SseRequest) (result datatype.PutObjectResult,
 		err error)
-	ListObjectParts(credential iam.Credential, bucket, object string,
+	ListObjectParts(ctx context.Context, credential iam.Credential, bucket, object string,
 		request datatype.ListPartsRequest) (result datatype.ListPartsResponse, err error)
-	AbortMultipartUpload(credential iam.Credential, bucket, object, uploadID string) error
-	CompleteMultipartUpload(credential iam.Credential, bucket, object, uploadID string,
+	AbortMultipartUpload(ctx context.Context, credential iam.Credential, bucket, object, uploadID string) error
+	CompleteMultipartUpload(ctx context.Context, credential iam.Credential, bucket, object, uploadID string,
 		uploadedParts []meta.CompletePart) (result datatype.CompleteMultipartResult, err error)
 }
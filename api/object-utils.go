@@ -17,10 +17,12 @@
 package api
 
 import (
+	"net"
 	"regexp"
-	"strconv"
 	"strings"
 	"unicode/utf8"
+
+	meta "github.com/journeymidnight/yig/meta/types"
 )
 
 // validBucket regexp.
@@ -39,14 +41,8 @@ func isValidBucketName(bucketName string) bool {
 		return false
 	}
 	// make sure it's not an IP address
-	split := strings.Split(bucketName, ".")
-	if len(split) == 4 {
-		for _, p := range split {
-			n, err := strconv.Atoi(p)
-			if err == nil && n >= 0 && n <= 255 {
-				return false
-			}
-		}
+	if net.ParseIP(bucketName) != nil {
+		return false
 	}
 	return true
 }
@@ -61,7 +57,9 @@ func isValidBucketName(bucketName string) bool {
 // \ { ^ } % ` [ ] ' " < > ~ # |
 // and non-printable ASCII characters(128-255 decimal)
 //
-// As in YIG, we PROHIBIT ALL the characters listed above
+// As in YIG, we PROHIBIT ALL the characters listed above, except "+" and
+// "%" which are common in keys, e.g. "c++" or "100%". Request paths are
+// percent-decoded exactly once, "+" in them is never taken as space
 // See http://docs.aws.amazon.com/AmazonS3/latest/dev/UsingMetadata.html
 func isValidObjectName(objectName string) bool {
 	if len(objectName) <= 0 || len(objectName) > 1024 {
@@ -75,9 +73,16 @@ func isValidObjectName(objectName string) bool {
 			return false
 		}
 		c := string(n)
-		if strings.ContainsAny(c, "&$=;:+ ,?\\^`><{}][#%\"'~|") {
+		if strings.ContainsAny(c, "&$=;: ,?\\^`><{}][#\"'~|") {
 			return false
 		}
 	}
 	return true
 }
+
+// isRowkeySafeName checks only that a bucket or object name can't be
+// confused with another one in metadata row keys, so buckets and objects
+// created before names were validated strictly can still be accessed
+func isRowkeySafeName(name string) bool {
+	return !strings.Contains(name, meta.ObjectNameSeparator)
+}
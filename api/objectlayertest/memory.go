@@ -0,0 +1,1873 @@
+// Package objectlayertest provides an in-memory api.ObjectLayer to be used
+// as a test double, and a suite checking behaviors every ObjectLayer should
+// share with YigStorage
+package objectlayertest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/journeymidnight/yig/api"
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+	meta "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/meta/util"
+)
+
+// Headers kept as custom attributes of objects, as storage does
+var customAttributes = []string{
+	"Cache-Control",
+}
+
+type memoryObject struct {
+	meta.Object
+	data []byte
+}
+
+type memoryUpload struct {
+	meta.Multipart
+	data map[int][]byte // by part number
+}
+
+type memoryBucket struct {
+	meta.Bucket
+	versions map[string][]*memoryObject // by object name, the latest first
+	uploads  map[string]*memoryUpload   // by upload id
+}
+
+// Memory is an ObjectLayer keeping everything in memory. Permissions,
+// versioning, Object Lock and multipart uploads behave as YigStorage does;
+// rate limits, caches and lifecycle are left out, and data of encrypted
+// objects is kept as is.
+// Owners are looked up with iam.GetCredentialByUserId, like YigStorage
+type Memory struct {
+	lock    sync.Mutex
+	buckets map[string]*memoryBucket
+	last    time.Time // see tick
+}
+
+var _ api.ObjectLayer = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*memoryBucket)}
+}
+
+// Time of a new version or upload. Version ids and upload ids are derived
+// from it, so it never repeats
+func (m *Memory) tick() time.Time {
+	now := time.Now().UTC()
+	if !now.After(m.last) {
+		now = m.last.Add(time.Nanosecond)
+	}
+	m.last = now
+	return now
+}
+
+func (m *Memory) getBucket(bucketName string) (*memoryBucket, error) {
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, ErrNoSuchBucket
+	}
+	return bucket, nil
+}
+
+// Names of objects with any version, in order
+func (b *memoryBucket) objectNames() []string {
+	names := make([]string, 0, len(b.versions))
+	for name := range b.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The latest version if `version` is empty
+func (b *memoryBucket) getObject(objectName, version string) (*memoryObject, error) {
+	versions, ok := b.versions[objectName]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	if version == "" {
+		return versions[0], nil
+	}
+	for _, o := range versions {
+		if o.GetVersionId() == version {
+			return o, nil
+		}
+	}
+	return nil, ErrNoSuchVersion
+}
+
+// Remove objects to be overwritten by a new version in a bucket of
+// `versioning`, then put `object` as the latest version
+func (b *memoryBucket) putObject(object *memoryObject) error {
+	var removed []*memoryObject
+	switch b.Versioning {
+	case "Disabled":
+		removed = b.versions[object.Name]
+	case "Suspended":
+		if o, err := b.getObject(object.Name, "null"); err == nil {
+			removed = []*memoryObject{o}
+		}
+	}
+	now := time.Now()
+	for _, o := range removed {
+		if o.IsLocked(now, false) {
+			return ErrObjectLocked
+		}
+	}
+	for _, o := range removed {
+		b.removeObject(o)
+	}
+	if !object.NullVersion {
+		object.GetVersionId() // fixed before metadata is changed in place
+	}
+	b.versions[object.Name] = append([]*memoryObject{object}, b.versions[object.Name]...)
+	b.Usage += object.Size
+	return nil
+}
+
+func (b *memoryBucket) removeObject(object *memoryObject) {
+	versions := b.versions[object.Name]
+	for i, o := range versions {
+		if o == object {
+			versions = append(versions[:i:i], versions[i+1:]...)
+			break
+		}
+	}
+	if len(versions) == 0 {
+		delete(b.versions, object.Name)
+	} else {
+		b.versions[object.Name] = versions
+	}
+	if !object.DeleteMarker {
+		b.Usage -= object.Size
+	}
+}
+
+func (b *memoryBucket) getUpload(objectName, uploadId string) (*memoryUpload, error) {
+	upload, ok := b.uploads[uploadId]
+	if !ok || upload.ObjectName != objectName {
+		return nil, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+// Read the payload of `size`, or all of it if size is unknown, verifying
+// its MD5 `md5Hex` and `checksums` if they're set
+func readPayload(data io.Reader, size int64, md5Hex string,
+	checksums datatype.Checksums) (payload []byte, etag string, err error) {
+
+	if size > 0 {
+		data = io.LimitReader(data, size)
+	}
+	payload, err = ioutil.ReadAll(data)
+	if err != nil {
+		return
+	}
+	if size > 0 && int64(len(payload)) < size {
+		return nil, "", ErrIncompleteBody
+	}
+	sum := md5.Sum(payload)
+	etag = hex.EncodeToString(sum[:])
+	if md5Hex != "" && md5Hex != etag {
+		return nil, "", ErrBadDigest
+	}
+	if checksums.ContentSha256 != "" {
+		sum := sha256.Sum256(payload)
+		if hex.EncodeToString(sum[:]) != checksums.ContentSha256 {
+			return nil, "", ErrContentSHA256Mismatch
+		}
+	}
+	if checksums.Algorithm != "" {
+		h := datatype.NewChecksumHash(checksums.Algorithm)
+		if h == nil {
+			return nil, "", ErrBadChecksum
+		}
+		h.Write(payload)
+		if base64.StdEncoding.EncodeToString(h.Sum(nil)) != checksums.Checksum {
+			return nil, "", ErrBadChecksum
+		}
+	}
+	return payload, etag, nil
+}
+
+func getCustomAttributes(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	attrs := make(map[string]string)
+	for _, k := range customAttributes {
+		if v, ok := metadata[k]; ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func ownerOf(userId string) (datatype.Owner, error) {
+	credential, err := iam.GetCredentialByUserId(userId)
+	if err != nil {
+		return datatype.Owner{}, err
+	}
+	return datatype.Owner{ID: credential.UserId, DisplayName: credential.DisplayName}, nil
+}
+
+// Permissions, see storage/acl.go
+
+func canWriteObjectsOf(bucket meta.Bucket, credential iam.Credential) bool {
+	if bucket.ACL.CannedAcl == "public-read-write" {
+		return true
+	}
+	return bucketAllows(bucket, credential, datatype.ACL_PERM_WRITE)
+}
+
+func canWriteToUpload(bucket meta.Bucket, upload *memoryUpload, credential iam.Credential) bool {
+	if credential.UserId != "" && upload.Metadata.InitiatorId == credential.UserId {
+		return true
+	}
+	return canWriteObjectsOf(bucket, credential)
+}
+
+func canListObjectsOf(bucket meta.Bucket, credential iam.Credential) bool {
+	switch bucket.ACL.CannedAcl {
+	case "public-read", "public-read-write":
+		return true
+	case "authenticated-read":
+		if credential.UserId != "" {
+			return true
+		}
+	}
+	return bucketAllows(bucket, credential, datatype.ACL_PERM_READ)
+}
+
+func canReadObject(bucket meta.Bucket, object *meta.Object, credential iam.Credential) bool {
+	switch bucket.ACL.CannedAcl {
+	case "public-read", "public-read-write":
+		return true
+	case "":
+		if grantsAllow(bucket.ACL.Grants, credential, datatype.ACL_PERM_READ) {
+			return true
+		}
+	}
+	if credential.UserId != "" && object.OwnerId == credential.UserId {
+		return true
+	}
+	switch object.ACL.CannedAcl {
+	case "public-read", "public-read-write":
+		return true
+	case "authenticated-read":
+		return credential.UserId != ""
+	case "bucket-owner-read", "bucket-owner-full-control":
+		return credential.UserId != "" && bucket.OwnerId == credential.UserId
+	case "":
+		return grantsAllow(object.ACL.Grants, credential, datatype.ACL_PERM_READ)
+	}
+	return false
+}
+
+func bucketAllows(bucket meta.Bucket, credential iam.Credential, permission string) bool {
+	if credential.UserId != "" && bucket.OwnerId == credential.UserId {
+		return true
+	}
+	return bucket.ACL.CannedAcl == "" && grantsAllow(bucket.ACL.Grants, credential, permission)
+}
+
+func grantsAllow(grants []datatype.Grant, credential iam.Credential, permission string) bool {
+	if len(grants) == 0 {
+		return false
+	}
+	var canonicalUserId string
+	if credential.UserId != "" {
+		var err error
+		canonicalUserId, err = iam.GetCanonicalUserId(credential.UserId)
+		if err != nil {
+			return false
+		}
+	}
+	return datatype.GrantsAllow(grants, canonicalUserId, permission)
+}
+
+// Canned ACL or explicit grants from headers, otherwise from `policy`
+func aclFromRequest(policy datatype.AccessControlPolicy, acl datatype.Acl) (datatype.Acl, error) {
+	if acl.CannedAcl != "" || len(acl.Grants) != 0 {
+		return acl, nil
+	}
+	acl, err := datatype.GetCannedAclFromPolicy(policy)
+	if err == ErrUnsupportedAcl {
+		err = datatype.IsValidGrants(policy.AccessControlList)
+		if err != nil {
+			return acl, err
+		}
+		return datatype.Acl{Grants: policy.AccessControlList}, nil
+	}
+	return acl, err
+}
+
+// Bucket operations
+
+func (m *Memory) MakeBucket(ctx context.Context, bucketName string, acl datatype.Acl,
+	credential iam.Credential) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if bucket, ok := m.buckets[bucketName]; ok {
+		if bucket.OwnerId == credential.UserId {
+			return ErrBucketAlreadyOwnedByYou
+		}
+		return ErrBucketAlreadyExists
+	}
+	m.buckets[bucketName] = &memoryBucket{
+		Bucket: meta.Bucket{
+			Name:       bucketName,
+			CreateTime: time.Now().UTC(),
+			OwnerId:    credential.UserId,
+			ACL:        acl,
+			Versioning: "Disabled",
+		},
+		versions: make(map[string][]*memoryObject),
+		uploads:  make(map[string]*memoryUpload),
+	}
+	return nil
+}
+
+// Change configuration of a bucket owned by `credential`
+func (m *Memory) updateBucket(bucketName string, credential iam.Credential,
+	update func(bucket *meta.Bucket) error) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return ErrBucketAccessForbidden
+	}
+	return update(&bucket.Bucket)
+}
+
+// Configuration of a bucket owned by `credential`
+func (m *Memory) bucketOf(bucketName string, credential iam.Credential) (meta.Bucket, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return meta.Bucket{}, err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return meta.Bucket{}, ErrBucketAccessForbidden
+	}
+	return bucket.Bucket, nil
+}
+
+func (m *Memory) SetBucketLc(ctx context.Context, bucketName string, lc datatype.Lc,
+	credential iam.Credential) error {
+
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		bucket.LC = lc
+		return nil
+	})
+}
+
+func (m *Memory) GetBucketLc(ctx context.Context, bucketName string,
+	credential iam.Credential) (datatype.Lc, error) {
+
+	bucket, err := m.bucketOf(bucketName, credential)
+	if err != nil {
+		return datatype.Lc{}, err
+	}
+	if len(bucket.LC.Rule) == 0 {
+		return datatype.Lc{}, ErrNoSuchBucketLc
+	}
+	return bucket.LC, nil
+}
+
+func (m *Memory) DelBucketLc(ctx context.Context, bucketName string, credential iam.Credential) error {
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		bucket.LC = datatype.Lc{}
+		return nil
+	})
+}
+
+func (m *Memory) SetBucketAcl(ctx context.Context, bucketName string, policy datatype.AccessControlPolicy,
+	acl datatype.Acl, credential iam.Credential) error {
+
+	acl, err := aclFromRequest(policy, acl)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	if !bucketAllows(bucket.Bucket, credential, datatype.ACL_PERM_WRITE_ACP) {
+		return ErrBucketAccessForbidden
+	}
+	bucket.ACL = acl
+	return nil
+}
+
+func (m *Memory) GetBucketAcl(ctx context.Context, bucketName string,
+	credential iam.Credential) (policy datatype.AccessControlPolicy, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !bucketAllows(bucket.Bucket, credential, datatype.ACL_PERM_READ_ACP) {
+		return policy, ErrBucketAccessForbidden
+	}
+	owner, err := ownerOf(bucket.OwnerId)
+	if err != nil {
+		return
+	}
+	if bucket.ACL.CannedAcl == "" {
+		return datatype.CreatePolicyFromGrants(owner, bucket.ACL), nil
+	}
+	return datatype.CreatePolicyFromCanned(owner, datatype.Owner{}, bucket.ACL)
+}
+
+func (m *Memory) SetBucketCors(ctx context.Context, bucketName string, cors datatype.Cors,
+	credential iam.Credential) error {
+
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		bucket.CORS = cors
+		return nil
+	})
+}
+
+func (m *Memory) SetBucketVersioning(ctx context.Context, bucketName string, versioning datatype.Versioning,
+	credential iam.Credential) error {
+
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		if bucket.ObjectLock.IsEnabled() && versioning.Status != "Enabled" {
+			return ErrInvalidBucketState
+		}
+		bucket.Versioning = versioning.Status
+		return nil
+	})
+}
+
+func (m *Memory) DeleteBucketCors(ctx context.Context, bucketName string, credential iam.Credential) error {
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		bucket.CORS = datatype.Cors{}
+		return nil
+	})
+}
+
+func (m *Memory) GetBucketVersioning(ctx context.Context, bucketName string,
+	credential iam.Credential) (versioning datatype.Versioning, err error) {
+
+	bucket, err := m.bucketOf(bucketName, credential)
+	if err != nil {
+		return
+	}
+	versioning.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	versioning.Status = helper.Ternary(bucket.Versioning == "Disabled",
+		"", bucket.Versioning).(string)
+	return
+}
+
+func (m *Memory) GetBucketCors(ctx context.Context, bucketName string,
+	credential iam.Credential) (datatype.Cors, error) {
+
+	bucket, err := m.bucketOf(bucketName, credential)
+	if err != nil {
+		return datatype.Cors{}, err
+	}
+	if len(bucket.CORS.CorsRules) == 0 {
+		return datatype.Cors{}, ErrNoSuchBucketCors
+	}
+	return bucket.CORS, nil
+}
+
+func (m *Memory) SetBucketEncryption(ctx context.Context, bucketName string, encryption datatype.Encryption,
+	credential iam.Credential) error {
+
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		bucket.Encryption = encryption
+		return nil
+	})
+}
+
+func (m *Memory) DeleteBucketEncryption(ctx context.Context, bucketName string,
+	credential iam.Credential) error {
+
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		bucket.Encryption = datatype.Encryption{}
+		return nil
+	})
+}
+
+func (m *Memory) GetBucketEncryption(ctx context.Context, bucketName string,
+	credential iam.Credential) (datatype.Encryption, error) {
+
+	bucket, err := m.bucketOf(bucketName, credential)
+	if err != nil {
+		return datatype.Encryption{}, err
+	}
+	if len(bucket.Encryption.Rules) == 0 {
+		return datatype.Encryption{}, ErrNoSuchBucketEncryption
+	}
+	return bucket.Encryption, nil
+}
+
+func (m *Memory) SetBucketObjectLock(ctx context.Context, bucketName string,
+	config datatype.ObjectLockConfiguration, credential iam.Credential) error {
+
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		if bucket.Versioning != "Enabled" {
+			return ErrInvalidBucketState
+		}
+		bucket.ObjectLock = config
+		return nil
+	})
+}
+
+func (m *Memory) GetBucketObjectLock(ctx context.Context, bucketName string,
+	credential iam.Credential) (config datatype.ObjectLockConfiguration, err error) {
+
+	bucket, err := m.bucketOf(bucketName, credential)
+	if err != nil {
+		return
+	}
+	if !bucket.ObjectLock.IsEnabled() {
+		return config, ErrNoSuchObjectLockConfiguration
+	}
+	config = bucket.ObjectLock
+	config.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	return config, nil
+}
+
+func (m *Memory) SetBucketRequestPayment(ctx context.Context, bucketName string,
+	payment datatype.RequestPaymentConfiguration, credential iam.Credential) error {
+
+	return m.updateBucket(bucketName, credential, func(bucket *meta.Bucket) error {
+		bucket.RequestPayer = payment.Payer
+		return nil
+	})
+}
+
+func (m *Memory) GetBucketRequestPayment(ctx context.Context, bucketName string,
+	credential iam.Credential) (payment datatype.RequestPaymentConfiguration, err error) {
+
+	bucket, err := m.bucketOf(bucketName, credential)
+	if err != nil {
+		return
+	}
+	payment.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	payment.Payer = helper.Ternary(bucket.RequestPayer == "",
+		datatype.PAYER_BUCKET_OWNER, bucket.RequestPayer).(string)
+	return payment, nil
+}
+
+func (m *Memory) GetBucket(ctx context.Context, bucketName string) (meta.Bucket, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return meta.Bucket{}, err
+	}
+	return bucket.Bucket, nil
+}
+
+func (m *Memory) GetBucketInfo(ctx context.Context, bucketName string,
+	credential iam.Credential) (meta.Bucket, error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return meta.Bucket{}, err
+	}
+	if !canListObjectsOf(bucket.Bucket, credential) {
+		return meta.Bucket{}, ErrBucketAccessForbidden
+	}
+	return bucket.Bucket, nil
+}
+
+func (m *Memory) ListBuckets(ctx context.Context, credential iam.Credential,
+	request datatype.ListBucketsRequest) (buckets []meta.Bucket, continuationToken string, err error) {
+
+	var marker string
+	if request.ContinuationToken != "" {
+		marker, err = util.Decrypt(request.ContinuationToken)
+		if err != nil {
+			return nil, "", ErrInvalidContinuationToken
+		}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var names []string
+	for name, bucket := range m.buckets {
+		if bucket.OwnerId == credential.UserId && name > marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if request.MaxBuckets != 0 && len(names) > request.MaxBuckets {
+		names = names[:request.MaxBuckets]
+		continuationToken = util.Encrypt(names[len(names)-1])
+	}
+	for _, name := range names {
+		buckets = append(buckets, m.buckets[name].Bucket)
+	}
+	return
+}
+
+func (m *Memory) DeleteBucket(ctx context.Context, bucketName string, credential iam.Credential) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return ErrBucketAccessForbidden
+	}
+	if len(bucket.versions) != 0 || len(bucket.uploads) != 0 {
+		return ErrBucketNotEmpty
+	}
+	delete(m.buckets, bucketName)
+	return nil
+}
+
+func (m *Memory) ListObjects(ctx context.Context, credential iam.Credential, bucketName string,
+	request datatype.ListObjectsRequest) (result meta.ListObjectsInfo, err error) {
+
+	var marker string
+	if request.Version == 2 {
+		if request.ContinuationToken != "" {
+			marker, err = util.Decrypt(request.ContinuationToken)
+			if err != nil {
+				return result, ErrInvalidContinuationToken
+			}
+		} else {
+			marker = request.StartAfter
+		}
+	} else {
+		marker = request.Marker
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !canListObjectsOf(bucket.Bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+
+	now := time.Now()
+	collector := util.NewListCollector(request.MaxKeys)
+	owners := make(map[string]datatype.Owner)
+	result.Objects = []datatype.Object{}
+	for _, name := range bucket.objectNames() {
+		if name <= marker || !strings.HasPrefix(name, request.Prefix) {
+			continue
+		}
+		o := bucket.versions[name][0]
+		if o.DeleteMarker || o.IsExpired(now) {
+			continue
+		}
+		if prefix := util.CommonPrefix(name, request.Prefix, request.Delimiter); prefix != "" {
+			if prefix <= marker || collector.Seen(prefix) {
+				continue
+			}
+			if !collector.TakePrefix(prefix) {
+				result.IsTruncated = true
+				break
+			}
+			result.NextMarker = prefix
+			continue
+		}
+		if !collector.TakeKey() {
+			result.IsTruncated = true
+			break
+		}
+		object := datatype.Object{
+			Key:          name,
+			LastModified: o.LastModifiedTime.UTC().Format(meta.CREATE_TIME_LAYOUT),
+			ETag:         "\"" + o.Etag + "\"",
+			Size:         o.Size,
+			StorageClass: o.StorageClass,
+		}
+		if request.FetchOwner {
+			owner, ok := owners[o.OwnerId]
+			if !ok {
+				owner, err = ownerOf(o.OwnerId)
+				if err != nil {
+					return
+				}
+				owners[o.OwnerId] = owner
+			}
+			object.Owner = &owner
+		}
+		result.Objects = append(result.Objects, object)
+		result.NextMarker = name
+	}
+	result.Prefixes = collector.Prefixes()
+	if !result.IsTruncated {
+		result.NextMarker = ""
+	}
+	if request.Version == 2 {
+		result.NextMarker = util.Encrypt(result.NextMarker)
+	}
+	if request.EncodingType != "" { // only support "url" encoding for now
+		for i := range result.Objects {
+			result.Objects[i].Key = url.QueryEscape(result.Objects[i].Key)
+		}
+		result.Prefixes = helper.Map(result.Prefixes, func(s string) string {
+			return url.QueryEscape(s)
+		})
+		result.NextMarker = url.QueryEscape(result.NextMarker)
+	}
+	return
+}
+
+func (m *Memory) ListVersionedObjects(ctx context.Context, credential iam.Credential, bucketName string,
+	request datatype.ListObjectsRequest) (result meta.VersionedListObjectsInfo, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !canListObjectsOf(bucket.Bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+
+	collector := util.NewListCollector(request.MaxKeys)
+	owners := make(map[string]datatype.Owner)
+	result.Objects = []datatype.VersionedObject{}
+	var nextKeyMarker, nextVersionIdMarker string
+scan:
+	for _, name := range bucket.objectNames() {
+		if name < request.KeyMarker || !strings.HasPrefix(name, request.Prefix) {
+			continue
+		}
+		if prefix := util.CommonPrefix(name, request.Prefix, request.Delimiter); prefix != "" {
+			if prefix <= request.KeyMarker || collector.Seen(prefix) {
+				continue
+			}
+			if !collector.TakePrefix(prefix) {
+				result.IsTruncated = true
+				break
+			}
+			nextKeyMarker, nextVersionIdMarker = prefix, ""
+			continue
+		}
+		versions := bucket.versions[name]
+		if name == request.KeyMarker {
+			// versions up to the marker are listed already, so are all of
+			// them if the marker is not given or not found
+			skipped := len(versions)
+			for i, o := range versions {
+				if request.VersionIdMarker != "" && o.GetVersionId() == request.VersionIdMarker {
+					skipped = i + 1
+					break
+				}
+			}
+			versions = versions[skipped:]
+		}
+		for _, o := range versions {
+			if !collector.TakeKey() {
+				result.IsTruncated = true
+				break scan
+			}
+			object := datatype.VersionedObject{
+				Key:          name,
+				VersionId:    o.GetVersionId(),
+				LastModified: o.LastModifiedTime.UTC().Format(meta.CREATE_TIME_LAYOUT),
+				ETag:         "\"" + o.Etag + "\"",
+				Size:         o.Size,
+				StorageClass: o.StorageClass,
+			}
+			if request.EncodingType != "" { // only support "url" encoding for now
+				object.Key = url.QueryEscape(object.Key)
+			}
+			if o.DeleteMarker {
+				object.XMLName.Local = "DeleteMarker"
+			} else {
+				object.XMLName.Local = "Version"
+			}
+			if request.FetchOwner {
+				owner, ok := owners[o.OwnerId]
+				if !ok {
+					owner, err = ownerOf(o.OwnerId)
+					if err != nil {
+						return
+					}
+					owners[o.OwnerId] = owner
+				}
+				object.Owner = owner
+			}
+			result.Objects = append(result.Objects, object)
+			nextKeyMarker, nextVersionIdMarker = name, object.VersionId
+		}
+	}
+	result.Prefixes = collector.Prefixes()
+	if result.IsTruncated {
+		result.NextKeyMarker = nextKeyMarker
+		result.NextVersionIdMarker = nextVersionIdMarker
+	}
+	if request.EncodingType != "" { // only support "url" encoding for now
+		result.Prefixes = helper.Map(result.Prefixes, func(s string) string {
+			return url.QueryEscape(s)
+		})
+		result.NextKeyMarker = url.QueryEscape(result.NextKeyMarker)
+	}
+	return
+}
+
+// Object operations
+
+func (m *Memory) GetObject(ctx context.Context, object *meta.Object, startOffset int64, length int64,
+	writer io.Writer, sse datatype.SseRequest) error {
+
+	m.lock.Lock()
+	bucket, err := m.getBucket(object.BucketName)
+	if err != nil {
+		m.lock.Unlock()
+		return err
+	}
+	o, err := bucket.getObject(object.Name, object.GetVersionId())
+	m.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if o.DeleteMarker {
+		return ErrNoSuchKey
+	}
+	if startOffset < 0 || startOffset > int64(len(o.data)) {
+		return ErrInvalidRange
+	}
+	end := int64(len(o.data))
+	if length >= 0 && startOffset+length < end {
+		end = startOffset + length
+	}
+	// data is never changed in place, but replaced
+	_, err = writer.Write(o.data[startOffset:end])
+	return err
+}
+
+func (m *Memory) GetObjectInfo(ctx context.Context, bucketName, objectName, version string,
+	credential iam.Credential) (*meta.Object, error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	o, err := bucket.getObject(objectName, version)
+	if err != nil {
+		return nil, err
+	}
+	if o.IsExpired(time.Now()) {
+		return nil, ErrNoSuchKey
+	}
+	if !canReadObject(bucket.Bucket, &o.Object, credential) {
+		return nil, ErrAccessDenied
+	}
+	object := o.Object
+	return &object, nil
+}
+
+func (m *Memory) PutObject(ctx context.Context, bucketName, objectName string, credential iam.Credential,
+	size int64, data io.Reader, metadata map[string]string, acl datatype.Acl,
+	sse datatype.SseRequest) (result datatype.PutObjectResult, err error) {
+
+	payload, etag, err := readPayload(data, size, metadata["md5Sum"], datatype.Checksums{
+		ContentSha256: metadata["contentSha256"],
+		Algorithm:     metadata["checksumAlgorithm"],
+		Checksum:      metadata["checksum"],
+	})
+	if err != nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !canWriteObjectsOf(bucket.Bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+	if bucket.MaxObjectSize > 0 && int64(len(payload)) > bucket.MaxObjectSize {
+		return result, ErrEntityTooLarge
+	}
+	if sse.Type == "" {
+		sse = bucket.Encryption.SseRequest()
+	}
+	object := &memoryObject{
+		Object: meta.Object{
+			Name:             objectName,
+			BucketName:       bucketName,
+			OwnerId:          credential.UserId,
+			Size:             int64(len(payload)),
+			LastModifiedTime: m.tick(),
+			Etag:             etag,
+			ContentType:      metadata["Content-Type"],
+			CustomAttributes: getCustomAttributes(metadata),
+			ACL:              acl,
+			NullVersion:      bucket.Versioning != "Enabled",
+			SseType:          sse.Type,
+			StorageClass: helper.Ternary(metadata["storageClass"] == "",
+				datatype.STORAGE_CLASS_STANDARD, metadata["storageClass"]).(string),
+			ChecksumAlgorithm: metadata["checksumAlgorithm"],
+			Checksum:          metadata["checksum"],
+		},
+		data: payload,
+	}
+	ttl, _ := strconv.ParseInt(metadata["ttl"], 10, 64)
+	object.SetTtl(ttl)
+	object.RetentionMode, object.RetainUntilDate =
+		bucket.ObjectLock.DefaultRetention(object.LastModifiedTime)
+	err = bucket.putObject(object)
+	if err != nil {
+		return
+	}
+	result.Md5 = etag
+	result.LastModified = object.LastModifiedTime
+	result.SseType = sse.Type
+	if bucket.Versioning == "Enabled" {
+		result.VersionId = object.GetVersionId()
+	}
+	return
+}
+
+func (m *Memory) AppendObject(ctx context.Context, bucketName, objectName string, credential iam.Credential,
+	position int64, size int64, data io.Reader, metadata map[string]string, acl datatype.Acl,
+	sse datatype.SseRequest) (result datatype.AppendObjectResult, err error) {
+
+	payload, etag, err := readPayload(data, size, metadata["md5Sum"], datatype.Checksums{})
+	if err != nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !canWriteObjectsOf(bucket.Bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+	if bucket.Versioning != "Disabled" {
+		return result, ErrNotImplemented
+	}
+	if sse.Type != "" || bucket.Encryption.SseRequest().Type != "" {
+		return result, ErrNotImplemented
+	}
+	if bucket.MaxObjectSize > 0 && position+int64(len(payload)) > bucket.MaxObjectSize {
+		return result, ErrEntityTooLarge
+	}
+	o, err := bucket.getObject(objectName, "")
+	if err == ErrNoSuchKey {
+		o, err = nil, nil
+	}
+	if err != nil {
+		return
+	}
+	var currentSize int64
+	if o != nil {
+		if !o.Appendable {
+			return result, ErrObjectNotAppendable
+		}
+		currentSize = o.Size
+	}
+	if position != currentSize {
+		return result, ErrPositionNotEqualToLength
+	}
+
+	now := m.tick()
+	if o == nil {
+		o = &memoryObject{
+			Object: meta.Object{
+				Name:             objectName,
+				BucketName:       bucketName,
+				OwnerId:          credential.UserId,
+				Size:             int64(len(payload)),
+				LastModifiedTime: now,
+				Etag:             etag,
+				ContentType:      metadata["Content-Type"],
+				CustomAttributes: getCustomAttributes(metadata),
+				ACL:              acl,
+				NullVersion:      true,
+				Appendable:       true,
+				StorageClass:     datatype.STORAGE_CLASS_STANDARD,
+			},
+			data: payload,
+		}
+		err = bucket.putObject(o)
+		if err != nil {
+			return
+		}
+	} else {
+		// ETags of appends are chained, as storage does
+		sum := md5.Sum([]byte(o.Etag + etag))
+		o.Etag = hex.EncodeToString(sum[:])
+		o.data = append(o.data[:len(o.data):len(o.data)], payload...)
+		o.Size += int64(len(payload))
+		o.LastModifiedTime = now
+		bucket.Usage += int64(len(payload))
+	}
+	result.Md5 = o.Etag
+	result.NextPosition = position + int64(len(payload))
+	result.LastModified = now
+	return result, nil
+}
+
+func (m *Memory) RenameObject(ctx context.Context, bucketName, sourceName, targetName string,
+	credential iam.Credential) (result datatype.PutObjectResult, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !canWriteObjectsOf(bucket.Bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+	if bucket.Versioning != "Disabled" {
+		return result, ErrNotImplemented
+	}
+	if sourceName == targetName {
+		return result, ErrInvalidCopyDest
+	}
+	source, err := bucket.getObject(sourceName, "")
+	if err != nil {
+		return
+	}
+	if source.IsLocked(time.Now(), false) {
+		return result, ErrObjectLocked
+	}
+	target := *source
+	target.Name = targetName
+	target.VersionId = ""
+	target.LastModifiedTime = m.tick()
+	err = bucket.putObject(&target)
+	if err != nil {
+		return
+	}
+	bucket.removeObject(source)
+	result.Md5 = target.Etag
+	result.LastModified = target.LastModifiedTime
+	result.SseType = target.SseType
+	return result, nil
+}
+
+func (m *Memory) CopyObject(ctx context.Context, targetObject, sourceObject *meta.Object, source io.Reader,
+	credential iam.Credential, sse datatype.SseRequest) (result datatype.PutObjectResult, err error) {
+
+	var payload []byte
+	if source != nil {
+		payload, _, err = readPayload(source, targetObject.Size, "", datatype.Checksums{})
+		if err != nil {
+			return
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(targetObject.BucketName)
+	if err != nil {
+		return
+	}
+	if !canWriteObjectsOf(bucket.Bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+	if source == nil { // replace metadata in place
+		var o *memoryObject
+		o, err = bucket.getObject(targetObject.Name, targetObject.GetVersionId())
+		if err != nil {
+			return
+		}
+		o.CustomAttributes = getCustomAttributes(targetObject.CustomAttributes)
+		o.ContentType = targetObject.ContentType
+		o.ExpireTime = targetObject.ExpireTime
+		o.LastModifiedTime = m.tick()
+		result.Md5 = o.Etag
+		result.LastModified = o.LastModifiedTime
+		result.SseType = o.SseType
+		if bucket.Versioning == "Enabled" {
+			result.VersionId = o.GetVersionId()
+		}
+		return result, nil
+	}
+	if bucket.MaxObjectSize > 0 && targetObject.Size > bucket.MaxObjectSize {
+		return result, ErrEntityTooLarge
+	}
+	if sse.Type == "" {
+		sse = bucket.Encryption.SseRequest()
+	}
+	object := &memoryObject{Object: *targetObject, data: payload}
+	object.Rowkey = nil
+	object.VersionId = ""
+	object.OwnerId = credential.UserId
+	object.LastModifiedTime = m.tick()
+	object.NullVersion = bucket.Versioning != "Enabled"
+	object.DeleteMarker = false
+	object.SseType = sse.Type
+	if object.StorageClass == "" {
+		object.StorageClass = datatype.STORAGE_CLASS_STANDARD
+	}
+	err = bucket.putObject(object)
+	if err != nil {
+		return
+	}
+	result.Md5 = object.Etag
+	result.LastModified = object.LastModifiedTime
+	result.SseType = object.SseType
+	if bucket.Versioning == "Enabled" {
+		result.VersionId = object.GetVersionId()
+	}
+	return result, nil
+}
+
+func (m *Memory) SetObjectAcl(ctx context.Context, bucketName string, objectName string, version string,
+	policy datatype.AccessControlPolicy, acl datatype.Acl, credential iam.Credential) error {
+
+	acl, err := aclFromRequest(policy, acl)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return ErrAccessDenied
+	}
+	o, err := bucket.getObject(objectName, version)
+	if err != nil {
+		return err
+	}
+	o.ACL = acl
+	return nil
+}
+
+func (m *Memory) GetObjectAcl(ctx context.Context, bucketName string, objectName string, version string,
+	credential iam.Credential) (policy datatype.AccessControlPolicy, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	o, err := bucket.getObject(objectName, version)
+	if err != nil {
+		return
+	}
+	switch o.ACL.CannedAcl {
+	case "bucket-owner-full-control":
+		if bucket.OwnerId != credential.UserId {
+			return policy, ErrAccessDenied
+		}
+	case "":
+		if o.OwnerId != credential.UserId &&
+			!grantsAllow(o.ACL.Grants, credential, datatype.ACL_PERM_READ_ACP) {
+			return policy, ErrAccessDenied
+		}
+	default:
+		if o.OwnerId != credential.UserId {
+			return policy, ErrAccessDenied
+		}
+	}
+	if o.ACL.CannedAcl == "" {
+		objectOwner, err := ownerOf(o.OwnerId)
+		if err != nil {
+			return policy, err
+		}
+		return datatype.CreatePolicyFromGrants(objectOwner, o.ACL), nil
+	}
+	bucketOwner, err := ownerOf(bucket.OwnerId)
+	if err != nil {
+		return
+	}
+	owner := datatype.Owner{ID: credential.UserId, DisplayName: credential.DisplayName}
+	return datatype.CreatePolicyFromCanned(owner, bucketOwner, o.ACL)
+}
+
+// See the table of YigStorage.DeleteObject
+func (m *Memory) DeleteObject(ctx context.Context, bucketName, objectName, version string,
+	credential iam.Credential, bypassGovernance bool) (datatype.DeleteObjectResult, error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.deleteObject(bucketName, objectName, version, credential, bypassGovernance)
+}
+
+func (m *Memory) deleteObject(bucketName, objectName, version string, credential iam.Credential,
+	bypassGovernance bool) (result datatype.DeleteObjectResult, err error) {
+
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	bypassGovernance = bypassGovernance && bucket.OwnerId == credential.UserId
+	if !canWriteObjectsOf(bucket.Bucket, credential) {
+		// objects without canned ACL could grant WRITE to others
+		o, err := bucket.getObject(objectName, version)
+		if err != nil || o.ACL.CannedAcl != "" ||
+			!grantsAllow(o.ACL.Grants, credential, datatype.ACL_PERM_WRITE) {
+			return result, ErrBucketAccessForbidden
+		}
+	}
+
+	switch bucket.Versioning {
+	case "Disabled":
+		if version != "" && version != "null" {
+			return result, ErrNoSuchVersion
+		}
+		now := time.Now()
+		versions := bucket.versions[objectName]
+		for _, o := range versions {
+			if o.IsLocked(now, bypassGovernance) {
+				return result, ErrObjectLocked
+			}
+		}
+		for _, o := range versions {
+			bucket.removeObject(o)
+		}
+	case "Enabled":
+		if version == "" {
+			result.VersionId = m.addDeleteMarker(bucket, objectName, false)
+			result.DeleteMarker = true
+		} else {
+			result.DeleteMarker, err = removeVersion(bucket, objectName, version, bypassGovernance)
+			if err != nil {
+				return
+			}
+			result.VersionId = version
+		}
+	case "Suspended":
+		if version == "" {
+			_, err = removeVersion(bucket, objectName, "null", bypassGovernance)
+			if err != nil {
+				return
+			}
+			result.VersionId = m.addDeleteMarker(bucket, objectName, true)
+			result.DeleteMarker = true
+		} else {
+			result.DeleteMarker, err = removeVersion(bucket, objectName, version, bypassGovernance)
+			if err != nil {
+				return
+			}
+			result.VersionId = version
+		}
+	default:
+		return result, ErrInternalError
+	}
+	return result, nil
+}
+
+// Returns whether the removed version is a delete marker, removing a version
+// that doesn't exist is not an error
+func removeVersion(bucket *memoryBucket, objectName, version string,
+	bypassGovernance bool) (deleteMarker bool, err error) {
+
+	o, err := bucket.getObject(objectName, version)
+	if err == ErrNoSuchKey || err == ErrNoSuchVersion {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if o.IsLocked(time.Now(), bypassGovernance) {
+		return false, ErrObjectLocked
+	}
+	bucket.removeObject(o)
+	return o.DeleteMarker, nil
+}
+
+func (m *Memory) addDeleteMarker(bucket *memoryBucket, objectName string, nullVersion bool) string {
+	deleteMarker := &memoryObject{Object: meta.Object{
+		Name:             objectName,
+		BucketName:       bucket.Name,
+		OwnerId:          bucket.OwnerId,
+		LastModifiedTime: m.tick(),
+		NullVersion:      nullVersion,
+		DeleteMarker:     true,
+	}}
+	bucket.versions[objectName] = append([]*memoryObject{deleteMarker}, bucket.versions[objectName]...)
+	return deleteMarker.GetVersionId()
+}
+
+func (m *Memory) DeleteObjects(ctx context.Context, bucketName string, objects []datatype.ObjectIdentifier,
+	credential iam.Credential, bypassGovernance bool) ([]datatype.DeleteObjectResult, []error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	results := make([]datatype.DeleteObjectResult, len(objects))
+	errs := make([]error, len(objects))
+	for i, object := range objects {
+		results[i], errs[i] = m.deleteObject(bucketName, object.ObjectName, object.VersionId,
+			credential, bypassGovernance)
+	}
+	return results, errs
+}
+
+// Object version to change its lock, bucket should have Object Lock enabled
+func (m *Memory) getObjectToLock(bucketName, objectName, version string,
+	credential iam.Credential) (*memoryObject, error) {
+
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return nil, ErrBucketAccessForbidden
+	}
+	if !bucket.ObjectLock.IsEnabled() {
+		return nil, ErrObjectLockNotEnabled
+	}
+	o, err := bucket.getObject(objectName, version)
+	if err != nil {
+		return nil, err
+	}
+	if o.DeleteMarker {
+		return nil, ErrNoSuchKey
+	}
+	return o, nil
+}
+
+func (m *Memory) PutObjectRetention(ctx context.Context, bucketName, objectName, version string,
+	retention datatype.ObjectRetention, bypassGovernance bool, credential iam.Credential) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	o, err := m.getObjectToLock(bucketName, objectName, version, credential)
+	if err != nil {
+		return err
+	}
+	if o.IsRetained(time.Now()) {
+		weakened := o.IsRetentionWeakenedBy(retention.Mode, retention.RetainUntilDate)
+		if weakened && (o.RetentionMode == datatype.RETENTION_MODE_COMPLIANCE || !bypassGovernance) {
+			return ErrObjectLocked
+		}
+	}
+	o.RetentionMode = retention.Mode
+	o.RetainUntilDate = retention.RetainUntilDate
+	return nil
+}
+
+func (m *Memory) GetObjectRetention(ctx context.Context, bucketName, objectName, version string,
+	credential iam.Credential) (retention datatype.ObjectRetention, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	o, err := m.getObjectToLock(bucketName, objectName, version, credential)
+	if err != nil {
+		return
+	}
+	if o.RetentionMode == "" {
+		return retention, ErrNoSuchObjectLockConfiguration
+	}
+	retention.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	retention.Mode = o.RetentionMode
+	retention.RetainUntilDate = o.RetainUntilDate.UTC()
+	return retention, nil
+}
+
+func (m *Memory) PutObjectLegalHold(ctx context.Context, bucketName, objectName, version string,
+	legalHold datatype.ObjectLegalHold, credential iam.Credential) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	o, err := m.getObjectToLock(bucketName, objectName, version, credential)
+	if err != nil {
+		return err
+	}
+	o.LegalHold = legalHold.Status == datatype.LEGAL_HOLD_ON
+	return nil
+}
+
+func (m *Memory) GetObjectLegalHold(ctx context.Context, bucketName, objectName, version string,
+	credential iam.Credential) (legalHold datatype.ObjectLegalHold, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	o, err := m.getObjectToLock(bucketName, objectName, version, credential)
+	if err != nil {
+		return
+	}
+	legalHold.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	legalHold.Status = helper.Ternary(o.LegalHold,
+		datatype.LEGAL_HOLD_ON, datatype.LEGAL_HOLD_OFF).(string)
+	return legalHold, nil
+}
+
+func (m *Memory) RestoreObject(ctx context.Context, bucketName, objectName, version string,
+	request datatype.RestoreRequest, credential iam.Credential) (restored bool, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !canWriteObjectsOf(bucket.Bucket, credential) {
+		return false, ErrBucketAccessForbidden
+	}
+	o, err := bucket.getObject(objectName, version)
+	if err != nil {
+		return
+	}
+	if o.DeleteMarker {
+		return false, ErrNoSuchKey
+	}
+	if !datatype.IsRestorableStorageClass(o.StorageClass) {
+		return false, ErrInvalidObjectState
+	}
+	now := time.Now()
+	restored = o.RestoreExpiryDate.After(now)
+	o.RestoreExpiryDate = datatype.RestoreExpiryDate(now, request.Days)
+	return restored, nil
+}
+
+// Multipart operations
+
+func (m *Memory) ListMultipartUploads(ctx context.Context, credential iam.Credential, bucketName string,
+	request datatype.ListUploadsRequest) (result datatype.ListMultipartUploadsResponse, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !canListObjectsOf(bucket.Bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+
+	uploads := make([]*memoryUpload, 0, len(bucket.uploads))
+	for _, upload := range bucket.uploads {
+		uploads = append(uploads, upload)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].ObjectName != uploads[j].ObjectName {
+			return uploads[i].ObjectName < uploads[j].ObjectName
+		}
+		return uploads[i].InitialTime.Before(uploads[j].InitialTime)
+	})
+	// uploads of the key marker initiated up to the upload id marker are
+	// listed already, so are all of them if the upload id marker is not given
+	// or not found
+	var markerTime time.Time
+	if marker, ok := bucket.uploads[request.UploadIdMarker]; ok &&
+		marker.ObjectName == request.KeyMarker {
+		markerTime = marker.InitialTime
+	}
+
+	collector := util.NewListCollector(request.MaxUploads)
+	for _, upload := range uploads {
+		name := upload.ObjectName
+		if name < request.KeyMarker || !strings.HasPrefix(name, request.Prefix) {
+			continue
+		}
+		if name == request.KeyMarker && (markerTime.IsZero() || !upload.InitialTime.After(markerTime)) {
+			continue
+		}
+		if prefix := util.CommonPrefix(name, request.Prefix, request.Delimiter); prefix != "" {
+			if prefix <= request.KeyMarker || collector.Seen(prefix) {
+				continue
+			}
+			if !collector.TakePrefix(prefix) {
+				result.IsTruncated = true
+				break
+			}
+			result.NextKeyMarker, result.NextUploadIdMarker = prefix, ""
+			continue
+		}
+		if !collector.TakeKey() {
+			result.IsTruncated = true
+			break
+		}
+		var initiator, owner datatype.Owner
+		initiator, err = ownerOf(upload.Metadata.InitiatorId)
+		if err != nil {
+			return
+		}
+		owner, err = ownerOf(upload.Metadata.OwnerId)
+		if err != nil {
+			return
+		}
+		result.Uploads = append(result.Uploads, datatype.Upload{
+			Key:          name,
+			UploadId:     upload.UploadId,
+			Initiator:    datatype.Initiator(initiator),
+			Owner:        owner,
+			StorageClass: upload.Metadata.StorageClass,
+			Initiated:    upload.InitialTime.Format(meta.CREATE_TIME_LAYOUT),
+		})
+		result.NextKeyMarker, result.NextUploadIdMarker = name, upload.UploadId
+	}
+	if !result.IsTruncated {
+		result.NextKeyMarker, result.NextUploadIdMarker = "", ""
+	}
+	for _, prefix := range collector.Prefixes() {
+		result.CommonPrefixes = append(result.CommonPrefixes, datatype.CommonPrefix{
+			Prefix: prefix,
+		})
+	}
+
+	result.Bucket = bucketName
+	result.KeyMarker = request.KeyMarker
+	result.UploadIdMarker = request.UploadIdMarker
+	result.MaxUploads = request.MaxUploads
+	result.Prefix = request.Prefix
+	result.Delimiter = request.Delimiter
+	result.EncodingType = request.EncodingType
+	if result.EncodingType != "" { // only support "url" encoding for now
+		for i := range result.Uploads {
+			result.Uploads[i].Key = url.QueryEscape(result.Uploads[i].Key)
+		}
+		result.Delimiter = url.QueryEscape(result.Delimiter)
+		result.KeyMarker = url.QueryEscape(result.KeyMarker)
+		result.Prefix = url.QueryEscape(result.Prefix)
+		result.NextKeyMarker = url.QueryEscape(result.NextKeyMarker)
+	}
+	return
+}
+
+func (m *Memory) NewMultipartUpload(ctx context.Context, credential iam.Credential, bucketName, objectName string,
+	metadata map[string]string, acl datatype.Acl, sse datatype.SseRequest) (uploadId string, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	if !canWriteObjectsOf(bucket.Bucket, credential) {
+		return "", ErrBucketAccessForbidden
+	}
+	if sse.Type == "" {
+		sse = bucket.Encryption.SseRequest()
+	}
+	contentType, ok := metadata["Content-Type"]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	ttl, _ := strconv.ParseInt(metadata["ttl"], 10, 64)
+	upload := &memoryUpload{
+		Multipart: meta.Multipart{
+			BucketName:  bucketName,
+			ObjectName:  objectName,
+			InitialTime: m.tick(),
+			Metadata: meta.MultipartMetadata{
+				InitiatorId: credential.UserId,
+				OwnerId:     bucket.OwnerId,
+				ContentType: contentType,
+				Acl:         acl,
+				SseRequest:  sse,
+				Attrs:       getCustomAttributes(metadata),
+				StorageClass: helper.Ternary(metadata["storageClass"] == "",
+					datatype.STORAGE_CLASS_STANDARD, metadata["storageClass"]).(string),
+				Ttl: ttl,
+			},
+			Parts: make(map[int]*meta.Part),
+		},
+		data: make(map[int][]byte),
+	}
+	uploadId, err = upload.GetUploadId()
+	if err != nil {
+		return
+	}
+	bucket.uploads[uploadId] = upload
+	return uploadId, nil
+}
+
+// Save data of a part, whose payload is verified already
+func (m *Memory) putPart(bucketName, objectName, uploadId string, partId int, payload []byte,
+	etag string, credential iam.Credential) (lastModified time.Time, err error) {
+
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	upload, err := bucket.getUpload(objectName, uploadId)
+	if err != nil {
+		return
+	}
+	if !canWriteToUpload(bucket.Bucket, upload, credential) {
+		return lastModified, ErrBucketAccessForbidden
+	}
+	if old, ok := upload.Parts[partId]; ok {
+		bucket.Usage -= old.Size
+	}
+	lastModified = time.Now().UTC()
+	upload.Parts[partId] = &meta.Part{
+		PartNumber:   partId,
+		Size:         int64(len(payload)),
+		Etag:         etag,
+		LastModified: lastModified.Format(meta.CREATE_TIME_LAYOUT),
+	}
+	upload.data[partId] = payload
+	bucket.Usage += int64(len(payload))
+	return lastModified, nil
+}
+
+func (m *Memory) PutObjectPart(ctx context.Context, bucketName, objectName string, credential iam.Credential,
+	uploadId string, partId int, size int64, data io.Reader, md5Hex string, checksums datatype.Checksums,
+	sse datatype.SseRequest) (result datatype.PutObjectPartResult, err error) {
+
+	if size > meta.MAX_PART_SIZE {
+		return result, ErrEntityTooLarge
+	}
+	payload, etag, err := readPayload(data, size, md5Hex, checksums)
+	if err != nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, err = m.putPart(bucketName, objectName, uploadId, partId, payload, etag, credential)
+	if err != nil {
+		return
+	}
+	result.ETag = etag
+	result.SseType = sse.Type
+	return result, nil
+}
+
+func (m *Memory) CopyObjectPart(ctx context.Context, bucketName, objectName, uploadId string, partId int,
+	size int64, data io.Reader, credential iam.Credential,
+	sse datatype.SseRequest) (result datatype.PutObjectResult, err error) {
+
+	if size > meta.MAX_PART_SIZE {
+		return result, ErrEntityTooLarge
+	}
+	payload, etag, err := readPayload(data, size, "", datatype.Checksums{})
+	if err != nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result.LastModified, err = m.putPart(bucketName, objectName, uploadId, partId, payload, etag,
+		credential)
+	if err != nil {
+		return
+	}
+	result.Md5 = etag
+	result.SseType = sse.Type
+	return result, nil
+}
+
+func (m *Memory) ListObjectParts(ctx context.Context, credential iam.Credential, bucketName, objectName string,
+	request datatype.ListPartsRequest) (result datatype.ListPartsResponse, err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	upload, err := bucket.getUpload(objectName, request.UploadId)
+	if err != nil {
+		return
+	}
+	initiatorId := upload.Metadata.InitiatorId
+	ownerId := upload.Metadata.OwnerId
+	switch {
+	case credential.UserId != "" && credential.UserId == initiatorId:
+		break
+	case upload.Metadata.Acl.CannedAcl == "public-read",
+		upload.Metadata.Acl.CannedAcl == "public-read-write":
+		break
+	case upload.Metadata.Acl.CannedAcl == "authenticated-read":
+		if credential.UserId == "" {
+			return result, ErrAccessDenied
+		}
+	case upload.Metadata.Acl.CannedAcl == "bucket-owner-read",
+		upload.Metadata.Acl.CannedAcl == "bucket-owner-full-control":
+		if bucket.OwnerId != credential.UserId {
+			return result, ErrAccessDenied
+		}
+	default:
+		if ownerId != credential.UserId {
+			return result, ErrAccessDenied
+		}
+	}
+	for i := request.PartNumberMarker + 1; i <= meta.MAX_PART_NUMBER; i++ {
+		p, ok := upload.Parts[i]
+		if !ok {
+			continue
+		}
+		if len(result.Parts) == request.MaxParts {
+			result.IsTruncated = true
+			result.NextPartNumberMarker = result.Parts[len(result.Parts)-1].PartNumber
+			break
+		}
+		result.Parts = append(result.Parts, datatype.Part{
+			PartNumber:   i,
+			ETag:         "\"" + p.Etag + "\"",
+			LastModified: p.LastModified,
+			Size:         p.Size,
+		})
+	}
+
+	result.Owner, err = ownerOf(ownerId)
+	if err != nil {
+		return
+	}
+	initiator, err := ownerOf(initiatorId)
+	if err != nil {
+		return
+	}
+	result.Initiator = datatype.Initiator(initiator)
+	result.Bucket = bucketName
+	result.Key = objectName
+	result.UploadId = request.UploadId
+	result.StorageClass = upload.Metadata.StorageClass
+	result.PartNumberMarker = request.PartNumberMarker
+	result.MaxParts = request.MaxParts
+	result.EncodingType = request.EncodingType
+	if result.EncodingType != "" { // only support "url" encoding for now
+		result.Key = url.QueryEscape(result.Key)
+	}
+	return
+}
+
+func (m *Memory) AbortMultipartUpload(ctx context.Context, credential iam.Credential,
+	bucketName, objectName, uploadId string) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	upload, err := bucket.getUpload(objectName, uploadId)
+	if err != nil {
+		return err
+	}
+	if !canWriteToUpload(bucket.Bucket, upload, credential) {
+		return ErrBucketAccessForbidden
+	}
+	delete(bucket.uploads, uploadId)
+	for _, p := range upload.Parts {
+		bucket.Usage -= p.Size
+	}
+	return nil
+}
+
+func (m *Memory) CompleteMultipartUpload(ctx context.Context, credential iam.Credential, bucketName,
+	objectName, uploadId string, uploadedParts []meta.CompletePart) (result datatype.CompleteMultipartResult,
+	err error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bucket, err := m.getBucket(bucketName)
+	if err != nil {
+		return
+	}
+	upload, err := bucket.getUpload(objectName, uploadId)
+	if err != nil {
+		return
+	}
+	if !canWriteToUpload(bucket.Bucket, upload, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+
+	var data bytes.Buffer
+	parts := make(map[int]*meta.Part, len(uploadedParts))
+	compositeMd5 := md5.New()
+	for i := 0; i < len(uploadedParts); i++ {
+		if uploadedParts[i].PartNumber != i+1 {
+			return result, ErrInvalidPart
+		}
+		part, ok := upload.Parts[i+1]
+		if !ok {
+			return result, ErrInvalidPart
+		}
+		if part.Size < meta.MIN_PART_SIZE && part.PartNumber != len(uploadedParts) {
+			return result, meta.PartTooSmall{
+				PartSize:   part.Size,
+				PartNumber: part.PartNumber,
+				PartETag:   part.Etag,
+			}
+		}
+		if part.Etag != uploadedParts[i].ETag {
+			return result, ErrInvalidPart
+		}
+		etag, err := hex.DecodeString(part.Etag)
+		if err != nil {
+			return result, ErrInvalidPart
+		}
+		compositeMd5.Write(etag)
+		p := *part
+		p.Offset = int64(data.Len())
+		parts[p.PartNumber] = &p
+		data.Write(upload.data[p.PartNumber])
+	}
+	if bucket.MaxObjectSize > 0 && int64(data.Len()) > bucket.MaxObjectSize {
+		return result, ErrEntityTooLarge
+	}
+	result.ETag = hex.EncodeToString(compositeMd5.Sum(nil)) + "-" + strconv.Itoa(len(uploadedParts))
+
+	object := &memoryObject{
+		Object: meta.Object{
+			Name:             objectName,
+			BucketName:       bucketName,
+			OwnerId:          bucket.OwnerId,
+			Size:             int64(data.Len()),
+			LastModifiedTime: m.tick(),
+			Etag:             result.ETag,
+			ContentType:      upload.Metadata.ContentType,
+			CustomAttributes: upload.Metadata.Attrs,
+			Parts:            parts,
+			ACL:              upload.Metadata.Acl,
+			NullVersion:      bucket.Versioning != "Enabled",
+			SseType:          upload.Metadata.SseRequest.Type,
+			StorageClass:     upload.Metadata.StorageClass,
+		},
+		data: data.Bytes(),
+	}
+	object.SetTtl(upload.Metadata.Ttl)
+	object.RetentionMode, object.RetainUntilDate =
+		bucket.ObjectLock.DefaultRetention(object.LastModifiedTime)
+	err = bucket.putObject(object)
+	if err != nil {
+		return
+	}
+	// usage of parts is taken over by the object, parts left out are dropped
+	for _, p := range upload.Parts {
+		bucket.Usage -= p.Size
+	}
+	delete(bucket.uploads, uploadId)
+	if bucket.Versioning == "Enabled" {
+		result.VersionId = object.GetVersionId()
+	}
+	result.SseType = upload.Metadata.SseRequest.Type
+	return result, nil
+}
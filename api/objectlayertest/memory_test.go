@@ -0,0 +1,29 @@
+package objectlayertest
+
+import (
+	"testing"
+
+	"github.com/journeymidnight/yig/api"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+)
+
+func TestMemory(t *testing.T) {
+	config := helper.CONFIG
+	defer func() {
+		helper.CONFIG = config
+		iam.SetupBackend()
+	}()
+	helper.CONFIG.IamBackend = iam.BACKEND_LOCAL
+	helper.CONFIG.IamCredentials = []helper.IamCredential{
+		{AccessKey: "alice", SecretKey: "alicealice", UserId: Alice.UserId},
+		{AccessKey: "bob", SecretKey: "bobbobbob", UserId: Bob.UserId},
+	}
+	if err := iam.SetupBackend(); err != nil {
+		t.Fatal(err)
+	}
+
+	Run(t, func(t *testing.T) api.ObjectLayer {
+		return NewMemory()
+	})
+}
@@ -0,0 +1,918 @@
+package objectlayertest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/api"
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+// Users of the suite, they should be known by iam.GetCredentialByUserId of
+// the layer under test
+var (
+	Alice = iam.Credential{UserId: "alice", DisplayName: "alice"}
+	Bob   = iam.Credential{UserId: "bob", DisplayName: "bob"}
+	// anonymous requests have no user id
+	Anonymous = iam.Credential{}
+)
+
+// Run the conformance suite against layers created by `newLayer`, each test
+// starts with an empty layer
+func Run(t *testing.T, newLayer func(t *testing.T) api.ObjectLayer) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, layer api.ObjectLayer)
+	}{
+		{"Buckets", testBuckets},
+		{"BucketConfigurations", testBucketConfigurations},
+		{"Objects", testObjects},
+		{"VersioningEnabled", testVersioningEnabled},
+		{"VersioningSuspended", testVersioningSuspended},
+		{"Acl", testAcl},
+		{"ObjectLock", testObjectLock},
+		{"RestoreObject", testRestoreObject},
+		{"Multipart", testMultipart},
+		{"ObjectTtl", testObjectTtl},
+		{"ListObjects", testListObjects},
+		{"ListVersionedObjects", testListVersionedObjects},
+		{"ListMultipartUploads", testListMultipartUploads},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newLayer(t))
+		})
+	}
+}
+
+var ctx = context.Background()
+
+func expectError(t *testing.T, what string, err, expected error) {
+	t.Helper()
+	if err != expected {
+		t.Errorf("%s: expected error %v, got %v", what, expected, err)
+	}
+}
+
+func mustSucceed(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
+
+func makeBucket(t *testing.T, layer api.ObjectLayer, bucket string, versioning string) {
+	t.Helper()
+	mustSucceed(t, "make bucket "+bucket,
+		layer.MakeBucket(ctx, bucket, datatype.Acl{CannedAcl: "private"}, Alice))
+	if versioning != "" {
+		mustSucceed(t, "set versioning of "+bucket, layer.SetBucketVersioning(ctx, bucket,
+			datatype.Versioning{Status: versioning}, Alice))
+	}
+}
+
+func putObject(t *testing.T, layer api.ObjectLayer, bucket, object, data string) datatype.PutObjectResult {
+	t.Helper()
+	result, err := layer.PutObject(ctx, bucket, object, Alice, int64(len(data)),
+		strings.NewReader(data), map[string]string{}, datatype.Acl{CannedAcl: "private"},
+		datatype.SseRequest{})
+	mustSucceed(t, "put "+object, err)
+	return result
+}
+
+// Data of `version` of `object`, its latest version if `version` is empty.
+// Deleted objects and delete markers are reported as ErrNoSuchKey
+func getObject(layer api.ObjectLayer, bucket, object, version string) (string, error) {
+	info, err := layer.GetObjectInfo(ctx, bucket, object, version, Alice)
+	if err != nil {
+		return "", err
+	}
+	if info.DeleteMarker {
+		return "", ErrNoSuchKey
+	}
+	var buf bytes.Buffer
+	err = layer.GetObject(ctx, info, 0, info.Size, &buf, datatype.SseRequest{})
+	return buf.String(), err
+}
+
+func expectData(t *testing.T, layer api.ObjectLayer, bucket, object, version, expected string) {
+	t.Helper()
+	data, err := getObject(layer, bucket, object, version)
+	if err != nil || data != expected {
+		t.Errorf("%s of version %q: expected %q, got %q, %v", object, version, expected, data, err)
+	}
+}
+
+func expectNoObject(t *testing.T, layer api.ObjectLayer, bucket, object, version string) {
+	t.Helper()
+	data, err := getObject(layer, bucket, object, version)
+	if err != ErrNoSuchKey && err != ErrNoSuchVersion {
+		t.Errorf("%s of version %q should be gone, got %q, %v", object, version, data, err)
+	}
+}
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func testBuckets(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "alice-b", "")
+	makeBucket(t, layer, "alice-a", "")
+	expectError(t, "make bucket again", layer.MakeBucket(ctx, "alice-a", datatype.Acl{}, Alice),
+		ErrBucketAlreadyOwnedByYou)
+	expectError(t, "make bucket of others", layer.MakeBucket(ctx, "alice-a", datatype.Acl{}, Bob),
+		ErrBucketAlreadyExists)
+
+	bucket, err := layer.GetBucketInfo(ctx, "alice-a", Alice)
+	if err != nil || bucket.Name != "alice-a" || bucket.OwnerId != Alice.UserId {
+		t.Errorf("unexpected bucket %v, %v", bucket, err)
+	}
+	_, err = layer.GetBucketInfo(ctx, "alice-a", Bob)
+	expectError(t, "get private bucket of others", err, ErrBucketAccessForbidden)
+	_, err = layer.GetBucketInfo(ctx, "nonexistent", Alice)
+	expectError(t, "get nonexistent bucket", err, ErrNoSuchBucket)
+
+	buckets, token, err := layer.ListBuckets(ctx, Alice, datatype.ListBucketsRequest{})
+	if err != nil || token != "" || len(buckets) != 2 {
+		t.Errorf("all buckets should be listed, got %v, %q, %v", buckets, token, err)
+	}
+	var names []string
+	request := datatype.ListBucketsRequest{MaxBuckets: 1}
+	for i := 0; i < 3; i++ {
+		buckets, token, err = layer.ListBuckets(ctx, Alice, request)
+		mustSucceed(t, "list buckets", err)
+		for _, b := range buckets {
+			names = append(names, b.Name)
+		}
+		if token == "" {
+			break
+		}
+		request.ContinuationToken = token
+	}
+	if !reflect.DeepEqual(names, []string{"alice-a", "alice-b"}) {
+		t.Errorf("buckets should be listed one by one in order, got %v", names)
+	}
+	buckets, _, err = layer.ListBuckets(ctx, Bob, datatype.ListBucketsRequest{})
+	if err != nil || len(buckets) != 0 {
+		t.Errorf("buckets of others should not be listed, got %v, %v", buckets, err)
+	}
+
+	putObject(t, layer, "alice-a", "hehe", "hehe")
+	expectError(t, "delete bucket of others", layer.DeleteBucket(ctx, "alice-a", Bob),
+		ErrBucketAccessForbidden)
+	expectError(t, "delete bucket with objects", layer.DeleteBucket(ctx, "alice-a", Alice),
+		ErrBucketNotEmpty)
+	_, err = layer.DeleteObject(ctx, "alice-a", "hehe", "", Alice, false)
+	mustSucceed(t, "delete object", err)
+	_, err = layer.NewMultipartUpload(ctx, Alice, "alice-a", "hehe", map[string]string{},
+		datatype.Acl{}, datatype.SseRequest{})
+	mustSucceed(t, "new upload", err)
+	expectError(t, "delete bucket with uploads", layer.DeleteBucket(ctx, "alice-a", Alice),
+		ErrBucketNotEmpty)
+
+	mustSucceed(t, "delete bucket", layer.DeleteBucket(ctx, "alice-b", Alice))
+	_, err = layer.GetBucketInfo(ctx, "alice-b", Alice)
+	expectError(t, "get deleted bucket", err, ErrNoSuchBucket)
+	expectError(t, "delete deleted bucket", layer.DeleteBucket(ctx, "alice-b", Alice),
+		ErrNoSuchBucket)
+}
+
+func testBucketConfigurations(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "")
+
+	versioning, err := layer.GetBucketVersioning(ctx, "b", Alice)
+	if err != nil || versioning.Status != "" {
+		t.Errorf("versioning should be unset by default, got %v, %v", versioning, err)
+	}
+	expectError(t, "set versioning of others", layer.SetBucketVersioning(ctx, "b",
+		datatype.Versioning{Status: "Enabled"}, Bob), ErrBucketAccessForbidden)
+
+	_, err = layer.GetBucketCors(ctx, "b", Alice)
+	expectError(t, "get unset CORS", err, ErrNoSuchBucketCors)
+	cors := datatype.Cors{CorsRules: []datatype.CorsRule{{
+		AllowedMethods: []string{"GET"},
+		AllowedOrigins: []string{"*"},
+	}}}
+	mustSucceed(t, "set CORS", layer.SetBucketCors(ctx, "b", cors, Alice))
+	got, err := layer.GetBucketCors(ctx, "b", Alice)
+	if err != nil || len(got.CorsRules) != 1 || got.CorsRules[0].AllowedOrigins[0] != "*" {
+		t.Errorf("unexpected CORS %v, %v", got, err)
+	}
+	_, err = layer.GetBucketCors(ctx, "b", Bob)
+	expectError(t, "get CORS of others", err, ErrBucketAccessForbidden)
+	mustSucceed(t, "delete CORS", layer.DeleteBucketCors(ctx, "b", Alice))
+	_, err = layer.GetBucketCors(ctx, "b", Alice)
+	expectError(t, "get deleted CORS", err, ErrNoSuchBucketCors)
+
+	_, err = layer.GetBucketLc(ctx, "b", Alice)
+	expectError(t, "get unset lifecycle", err, ErrNoSuchBucketLc)
+	lc := datatype.Lc{Rule: []datatype.LcRule{{ID: "logs", Prefix: "logs/", Status: "Enabled",
+		Expiration: "7"}}}
+	mustSucceed(t, "set lifecycle", layer.SetBucketLc(ctx, "b", lc, Alice))
+	gotLc, err := layer.GetBucketLc(ctx, "b", Alice)
+	if err != nil || len(gotLc.Rule) != 1 || gotLc.Rule[0].ID != "logs" {
+		t.Errorf("unexpected lifecycle %v, %v", gotLc, err)
+	}
+	mustSucceed(t, "delete lifecycle", layer.DelBucketLc(ctx, "b", Alice))
+	_, err = layer.GetBucketLc(ctx, "b", Alice)
+	expectError(t, "get deleted lifecycle", err, ErrNoSuchBucketLc)
+
+	_, err = layer.GetBucketEncryption(ctx, "b", Alice)
+	expectError(t, "get unset encryption", err, ErrNoSuchBucketEncryption)
+	_, err = layer.GetBucketObjectLock(ctx, "b", Alice)
+	expectError(t, "get unset object lock", err, ErrNoSuchObjectLockConfiguration)
+	expectError(t, "enable object lock without versioning", layer.SetBucketObjectLock(ctx, "b",
+		datatype.ObjectLockConfiguration{ObjectLockEnabled: "Enabled"}, Alice), ErrInvalidBucketState)
+
+	payment, err := layer.GetBucketRequestPayment(ctx, "b", Alice)
+	if err != nil || payment.Payer != datatype.PAYER_BUCKET_OWNER {
+		t.Errorf("bucket owner should pay by default, got %v, %v", payment, err)
+	}
+	mustSucceed(t, "set request payment", layer.SetBucketRequestPayment(ctx, "b",
+		datatype.RequestPaymentConfiguration{Payer: datatype.PAYER_REQUESTER}, Alice))
+	payment, err = layer.GetBucketRequestPayment(ctx, "b", Alice)
+	if err != nil || payment.Payer != datatype.PAYER_REQUESTER {
+		t.Errorf("requester should pay, got %v, %v", payment, err)
+	}
+}
+
+// Objects in buckets without versioning
+func testObjects(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "")
+
+	_, err := layer.PutObject(ctx, "nonexistent", "hehe", Alice, 4, strings.NewReader("hehe"),
+		map[string]string{}, datatype.Acl{}, datatype.SseRequest{})
+	expectError(t, "put to nonexistent bucket", err, ErrNoSuchBucket)
+	_, err = layer.PutObject(ctx, "b", "hehe", Alice, 4, strings.NewReader("hehe"),
+		map[string]string{"md5Sum": md5Hex("haha")}, datatype.Acl{}, datatype.SseRequest{})
+	expectError(t, "put with wrong MD5", err, ErrBadDigest)
+	expectNoObject(t, layer, "b", "hehe", "")
+
+	result := putObject(t, layer, "b", "hehe", "hehe")
+	if result.Md5 != md5Hex("hehe") || result.VersionId != "" {
+		t.Errorf("unexpected put result %v", result)
+	}
+	expectData(t, layer, "b", "hehe", "", "hehe")
+	info, err := layer.GetObjectInfo(ctx, "b", "hehe", "", Alice)
+	if err != nil || info.Size != 4 || info.Etag != md5Hex("hehe") || info.OwnerId != Alice.UserId {
+		t.Errorf("unexpected object %v, %v", info, err)
+	}
+	var buf bytes.Buffer
+	err = layer.GetObject(ctx, info, 1, 2, &buf, datatype.SseRequest{})
+	if err != nil || buf.String() != "eh" {
+		t.Errorf("range should be read, got %q, %v", buf.String(), err)
+	}
+
+	putObject(t, layer, "b", "hehe", "haha")
+	expectData(t, layer, "b", "hehe", "", "haha")
+	expectData(t, layer, "b", "hehe", "null", "haha")
+
+	_, err = layer.DeleteObject(ctx, "b", "hehe", "someversion", Alice, false)
+	expectError(t, "delete version without versioning", err, ErrNoSuchVersion)
+	result2, err := layer.DeleteObject(ctx, "b", "hehe", "", Alice, false)
+	if err != nil || result2.DeleteMarker {
+		t.Errorf("object should be removed without delete marker, got %v, %v", result2, err)
+	}
+	expectNoObject(t, layer, "b", "hehe", "")
+	_, err = layer.DeleteObject(ctx, "b", "hehe", "", Alice, false)
+	mustSucceed(t, "delete nonexistent object", err)
+
+	putObject(t, layer, "b", "a", "a")
+	putObject(t, layer, "b", "b", "b")
+	results, errs := layer.DeleteObjects(ctx, "b", []datatype.ObjectIdentifier{
+		{ObjectName: "a"}, {ObjectName: "b", VersionId: "someversion"}}, Alice, false)
+	if len(results) != 2 || errs[0] != nil || errs[1] != ErrNoSuchVersion {
+		t.Errorf("objects should be deleted one by one, got %v, %v", results, errs)
+	}
+	expectNoObject(t, layer, "b", "a", "")
+	expectData(t, layer, "b", "b", "", "b")
+
+	// rename and copy
+	_, err = layer.RenameObject(ctx, "b", "b", "c", Alice)
+	mustSucceed(t, "rename", err)
+	expectNoObject(t, layer, "b", "b", "")
+	expectData(t, layer, "b", "c", "", "b")
+	source, err := layer.GetObjectInfo(ctx, "b", "c", "", Alice)
+	mustSucceed(t, "get source", err)
+	target := *source
+	target.Name = "d"
+	_, err = layer.CopyObject(ctx, &target, source, strings.NewReader("b"), Alice, datatype.SseRequest{})
+	mustSucceed(t, "copy", err)
+	expectData(t, layer, "b", "d", "", "b")
+	expectData(t, layer, "b", "c", "", "b")
+
+	// append
+	appended, err := layer.AppendObject(ctx, "b", "log", Alice, 0, 2, strings.NewReader("he"),
+		map[string]string{}, datatype.Acl{}, datatype.SseRequest{})
+	mustSucceed(t, "append", err)
+	_, err = layer.AppendObject(ctx, "b", "log", Alice, 0, 2, strings.NewReader("he"),
+		map[string]string{}, datatype.Acl{}, datatype.SseRequest{})
+	expectError(t, "append at wrong position", err, ErrPositionNotEqualToLength)
+	_, err = layer.AppendObject(ctx, "b", "log", Alice, appended.NextPosition, 2,
+		strings.NewReader("he"), map[string]string{}, datatype.Acl{}, datatype.SseRequest{})
+	mustSucceed(t, "append again", err)
+	expectData(t, layer, "b", "log", "", "hehe")
+	_, err = layer.AppendObject(ctx, "b", "c", Alice, 1, 2, strings.NewReader("he"),
+		map[string]string{}, datatype.Acl{}, datatype.SseRequest{})
+	expectError(t, "append to normal object", err, ErrObjectNotAppendable)
+}
+
+func testVersioningEnabled(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "")
+	putObject(t, layer, "b", "hehe", "null")
+	mustSucceed(t, "enable versioning", layer.SetBucketVersioning(ctx, "b",
+		datatype.Versioning{Status: "Enabled"}, Alice))
+	v1 := putObject(t, layer, "b", "hehe", "v1").VersionId
+	v2 := putObject(t, layer, "b", "hehe", "v2").VersionId
+	if v1 == "" || v2 == "" || v1 == v2 || v1 == "null" {
+		t.Fatalf("versions should have distinct ids, got %q, %q", v1, v2)
+	}
+	expectData(t, layer, "b", "hehe", "", "v2")
+	expectData(t, layer, "b", "hehe", v1, "v1")
+	expectData(t, layer, "b", "hehe", "null", "null")
+
+	// deleting without version adds a delete marker, versions are kept
+	result, err := layer.DeleteObject(ctx, "b", "hehe", "", Alice, false)
+	if err != nil || !result.DeleteMarker || result.VersionId == "" || result.VersionId == "null" {
+		t.Fatalf("delete marker should be added, got %v, %v", result, err)
+	}
+	marker := result.VersionId
+	expectNoObject(t, layer, "b", "hehe", "")
+	expectData(t, layer, "b", "hehe", v2, "v2")
+
+	// removing the delete marker brings the object back
+	result, err = layer.DeleteObject(ctx, "b", "hehe", marker, Alice, false)
+	if err != nil || !result.DeleteMarker || result.VersionId != marker {
+		t.Errorf("delete marker should be removed, got %v, %v", result, err)
+	}
+	expectData(t, layer, "b", "hehe", "", "v2")
+
+	// removing the latest version makes the former one latest
+	result, err = layer.DeleteObject(ctx, "b", "hehe", v2, Alice, false)
+	if err != nil || result.DeleteMarker || result.VersionId != v2 {
+		t.Errorf("version should be removed, got %v, %v", result, err)
+	}
+	expectNoObject(t, layer, "b", "hehe", v2)
+	expectData(t, layer, "b", "hehe", "", "v1")
+	result, err = layer.DeleteObject(ctx, "b", "hehe", "null", Alice, false)
+	mustSucceed(t, "delete null version", err)
+	expectNoObject(t, layer, "b", "hehe", "null")
+	expectData(t, layer, "b", "hehe", "", "v1")
+
+	// suspending versioning keeps existing versions
+	mustSucceed(t, "suspend versioning", layer.SetBucketVersioning(ctx, "b",
+		datatype.Versioning{Status: "Suspended"}, Alice))
+	versioning, err := layer.GetBucketVersioning(ctx, "b", Alice)
+	if err != nil || versioning.Status != "Suspended" {
+		t.Errorf("versioning should be suspended, got %v, %v", versioning, err)
+	}
+	expectData(t, layer, "b", "hehe", v1, "v1")
+}
+
+func testVersioningSuspended(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "Enabled")
+	v1 := putObject(t, layer, "b", "hehe", "v1").VersionId
+	mustSucceed(t, "suspend versioning", layer.SetBucketVersioning(ctx, "b",
+		datatype.Versioning{Status: "Suspended"}, Alice))
+
+	// new objects are `null` versions overwriting each other
+	result := putObject(t, layer, "b", "hehe", "null1")
+	if result.VersionId != "" {
+		t.Errorf("no version id should be returned, got %q", result.VersionId)
+	}
+	putObject(t, layer, "b", "hehe", "null2")
+	expectData(t, layer, "b", "hehe", "", "null2")
+	expectData(t, layer, "b", "hehe", "null", "null2")
+	expectData(t, layer, "b", "hehe", v1, "v1")
+
+	// deleting without version replaces the `null` version with a `null`
+	// delete marker
+	deleted, err := layer.DeleteObject(ctx, "b", "hehe", "", Alice, false)
+	if err != nil || !deleted.DeleteMarker || deleted.VersionId != "null" {
+		t.Errorf("null delete marker should be added, got %v, %v", deleted, err)
+	}
+	expectNoObject(t, layer, "b", "hehe", "")
+	expectNoObject(t, layer, "b", "hehe", "null")
+	expectData(t, layer, "b", "hehe", v1, "v1")
+	versions, err := layer.ListVersionedObjects(ctx, Alice, "b", datatype.ListObjectsRequest{
+		Versioned: true, MaxKeys: 1000})
+	if err != nil || len(versions.Objects) != 2 || versions.Objects[0].VersionId != "null" ||
+		versions.Objects[0].XMLName.Local != "DeleteMarker" || versions.Objects[1].VersionId != v1 {
+		t.Errorf("null delete marker and v1 should be left, got %v, %v", versions.Objects, err)
+	}
+
+	// removing the `null` delete marker brings v1 back
+	deleted, err = layer.DeleteObject(ctx, "b", "hehe", "null", Alice, false)
+	if err != nil || !deleted.DeleteMarker {
+		t.Errorf("null delete marker should be removed, got %v, %v", deleted, err)
+	}
+	expectData(t, layer, "b", "hehe", "", "v1")
+}
+
+func testAcl(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "private", "")
+	putObject(t, layer, "private", "hehe", "hehe")
+
+	_, err := layer.PutObject(ctx, "private", "bob", Bob, 3, strings.NewReader("bob"),
+		map[string]string{}, datatype.Acl{}, datatype.SseRequest{})
+	expectError(t, "put to private bucket of others", err, ErrBucketAccessForbidden)
+	_, err = layer.GetObjectInfo(ctx, "private", "hehe", "", Bob)
+	expectError(t, "get private object of others", err, ErrAccessDenied)
+	_, err = layer.GetObjectInfo(ctx, "private", "hehe", "", Anonymous)
+	expectError(t, "get private object anonymously", err, ErrAccessDenied)
+	_, err = layer.ListObjects(ctx, Bob, "private", datatype.ListObjectsRequest{MaxKeys: 1000})
+	expectError(t, "list private bucket of others", err, ErrBucketAccessForbidden)
+	_, err = layer.DeleteObject(ctx, "private", "hehe", "", Bob, false)
+	expectError(t, "delete from private bucket of others", err, ErrBucketAccessForbidden)
+	_, err = layer.GetBucketAcl(ctx, "private", Bob)
+	expectError(t, "get ACL of private bucket of others", err, ErrBucketAccessForbidden)
+
+	// objects could be made public in private buckets
+	mustSucceed(t, "set object ACL", layer.SetObjectAcl(ctx, "private", "hehe", "",
+		datatype.AccessControlPolicy{}, datatype.Acl{CannedAcl: "public-read"}, Alice))
+	_, err = layer.GetObjectInfo(ctx, "private", "hehe", "", Anonymous)
+	mustSucceed(t, "get public object anonymously", err)
+	expectError(t, "set object ACL by others", layer.SetObjectAcl(ctx, "private", "hehe", "",
+		datatype.AccessControlPolicy{}, datatype.Acl{CannedAcl: "private"}, Bob), ErrAccessDenied)
+	policy, err := layer.GetObjectAcl(ctx, "private", "hehe", "", Alice)
+	if acl, _ := datatype.GetCannedAclFromPolicy(policy); err != nil || acl.CannedAcl != "public-read" {
+		t.Errorf("object ACL should be public-read, got %v, %v", policy, err)
+	}
+
+	// public-read buckets are listed and read by anyone, but written by owner
+	mustSucceed(t, "set bucket ACL", layer.SetBucketAcl(ctx, "private",
+		datatype.AccessControlPolicy{}, datatype.Acl{CannedAcl: "public-read"}, Alice))
+	_, err = layer.ListObjects(ctx, Anonymous, "private", datatype.ListObjectsRequest{MaxKeys: 1000})
+	mustSucceed(t, "list public-read bucket anonymously", err)
+	_, err = layer.PutObject(ctx, "private", "bob", Bob, 3, strings.NewReader("bob"),
+		map[string]string{}, datatype.Acl{}, datatype.SseRequest{})
+	expectError(t, "put to public-read bucket of others", err, ErrBucketAccessForbidden)
+	expectError(t, "set bucket ACL by others", layer.SetBucketAcl(ctx, "private",
+		datatype.AccessControlPolicy{}, datatype.Acl{CannedAcl: "public-read-write"}, Bob),
+		ErrBucketAccessForbidden)
+
+	// public-read-write buckets are written by anyone, objects are owned by
+	// their writers
+	mustSucceed(t, "make public bucket", layer.MakeBucket(ctx, "public",
+		datatype.Acl{CannedAcl: "public-read-write"}, Alice))
+	_, err = layer.PutObject(ctx, "public", "bob", Bob, 3, strings.NewReader("bob"),
+		map[string]string{}, datatype.Acl{CannedAcl: "private"}, datatype.SseRequest{})
+	mustSucceed(t, "put to public-read-write bucket", err)
+	info, err := layer.GetObjectInfo(ctx, "public", "bob", "", Bob)
+	if err != nil || info.OwnerId != Bob.UserId {
+		t.Errorf("object should be owned by its writer, got %v, %v", info, err)
+	}
+	_, err = layer.DeleteObject(ctx, "public", "bob", "", Anonymous, false)
+	mustSucceed(t, "delete from public-read-write bucket anonymously", err)
+}
+
+func testObjectLock(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "Enabled")
+	mustSucceed(t, "enable object lock", layer.SetBucketObjectLock(ctx, "b",
+		datatype.ObjectLockConfiguration{ObjectLockEnabled: "Enabled"}, Alice))
+	expectError(t, "suspend versioning with object lock", layer.SetBucketVersioning(ctx, "b",
+		datatype.Versioning{Status: "Suspended"}, Alice), ErrInvalidBucketState)
+
+	v1 := putObject(t, layer, "b", "hehe", "v1").VersionId
+	mustSucceed(t, "put legal hold", layer.PutObjectLegalHold(ctx, "b", "hehe", v1,
+		datatype.ObjectLegalHold{Status: datatype.LEGAL_HOLD_ON}, Alice))
+	legalHold, err := layer.GetObjectLegalHold(ctx, "b", "hehe", v1, Alice)
+	if err != nil || legalHold.Status != datatype.LEGAL_HOLD_ON {
+		t.Errorf("legal hold should be on, got %v, %v", legalHold, err)
+	}
+	_, err = layer.DeleteObject(ctx, "b", "hehe", v1, Alice, true)
+	expectError(t, "delete version on legal hold", err, ErrObjectLocked)
+	// delete markers could always be added
+	_, err = layer.DeleteObject(ctx, "b", "hehe", "", Alice, false)
+	mustSucceed(t, "add delete marker", err)
+	expectData(t, layer, "b", "hehe", v1, "v1")
+
+	mustSucceed(t, "remove legal hold", layer.PutObjectLegalHold(ctx, "b", "hehe", v1,
+		datatype.ObjectLegalHold{Status: datatype.LEGAL_HOLD_OFF}, Alice))
+	_, err = layer.DeleteObject(ctx, "b", "hehe", v1, Alice, false)
+	mustSucceed(t, "delete version without legal hold", err)
+	expectNoObject(t, layer, "b", "hehe", v1)
+}
+
+// Data of `n` bytes, different for different `seed`s
+func partData(seed byte, n int) string {
+	return strings.Repeat(string('a'+seed), n)
+}
+
+func testMultipart(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "")
+	_, err := layer.NewMultipartUpload(ctx, Bob, "b", "hehe", map[string]string{},
+		datatype.Acl{}, datatype.SseRequest{})
+	expectError(t, "new upload to private bucket of others", err, ErrBucketAccessForbidden)
+
+	uploadId, err := layer.NewMultipartUpload(ctx, Alice, "b", "hehe",
+		map[string]string{"Content-Type": "text/plain"}, datatype.Acl{}, datatype.SseRequest{})
+	mustSucceed(t, "new upload", err)
+	parts := []string{partData(0, meta.MIN_PART_SIZE), partData(1, meta.MIN_PART_SIZE), "tail"}
+	var completed []meta.CompletePart
+	for i, data := range parts {
+		result, err := layer.PutObjectPart(ctx, "b", "hehe", Alice, uploadId, i+1,
+			int64(len(data)), strings.NewReader(data), md5Hex(data), datatype.Checksums{},
+			datatype.SseRequest{})
+		if err != nil || result.ETag != md5Hex(data) {
+			t.Fatalf("put part %d: %v, %v", i+1, result, err)
+		}
+		completed = append(completed, meta.CompletePart{PartNumber: i + 1, ETag: result.ETag})
+	}
+	_, err = layer.PutObjectPart(ctx, "b", "hehe", Alice, "nonexistent", 1, 4,
+		strings.NewReader("hehe"), "", datatype.Checksums{}, datatype.SseRequest{})
+	if err == nil {
+		t.Error("part of nonexistent upload should not be put")
+	}
+	_, err = layer.PutObjectPart(ctx, "b", "hehe", Alice, uploadId, 4, 4,
+		strings.NewReader("hehe"), md5Hex("haha"), datatype.Checksums{}, datatype.SseRequest{})
+	expectError(t, "put part with wrong MD5", err, ErrBadDigest)
+
+	// parts are listed page by page
+	var listed []int
+	request := datatype.ListPartsRequest{UploadId: uploadId, MaxParts: 2}
+	for i := 0; i < 3; i++ {
+		result, err := layer.ListObjectParts(ctx, Alice, "b", "hehe", request)
+		mustSucceed(t, "list parts", err)
+		for _, p := range result.Parts {
+			listed = append(listed, p.PartNumber)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		request.PartNumberMarker = result.NextPartNumberMarker
+	}
+	if !reflect.DeepEqual(listed, []int{1, 2, 3}) {
+		t.Errorf("parts should be listed in order, got %v", listed)
+	}
+	_, err = layer.ListObjectParts(ctx, Bob, "b", "hehe", datatype.ListPartsRequest{
+		UploadId: uploadId, MaxParts: 1000})
+	expectError(t, "list parts of others", err, ErrAccessDenied)
+
+	// invalid parts fail completion and keep the upload
+	wrong := append([]meta.CompletePart{}, completed...)
+	wrong[1].ETag = md5Hex("hehe")
+	_, err = layer.CompleteMultipartUpload(ctx, Alice, "b", "hehe", uploadId, wrong)
+	expectError(t, "complete with wrong ETag", err, ErrInvalidPart)
+	_, err = layer.CompleteMultipartUpload(ctx, Alice, "b", "hehe", uploadId,
+		[]meta.CompletePart{completed[0], completed[2]})
+	expectError(t, "complete with missing part", err, ErrInvalidPart)
+	_, err = layer.CompleteMultipartUpload(ctx, Alice, "b", "hehe", uploadId,
+		[]meta.CompletePart{completed[2]})
+	expectError(t, "complete with wrong part number", err, ErrInvalidPart)
+
+	result, err := layer.CompleteMultipartUpload(ctx, Alice, "b", "hehe", uploadId, completed)
+	if err != nil || !strings.HasSuffix(result.ETag, "-3") {
+		t.Fatalf("upload should be completed, got %v, %v", result, err)
+	}
+	expectData(t, layer, "b", "hehe", "", strings.Join(parts, ""))
+	info, err := layer.GetObjectInfo(ctx, "b", "hehe", "", Alice)
+	if err != nil || info.Etag != result.ETag || info.ContentType != "text/plain" {
+		t.Errorf("unexpected completed object %v, %v", info, err)
+	}
+	_, err = layer.ListObjectParts(ctx, Alice, "b", "hehe", datatype.ListPartsRequest{
+		UploadId: uploadId, MaxParts: 1000})
+	expectError(t, "list parts of completed upload", err, ErrNoSuchUpload)
+
+	// small parts could only be the last one
+	uploadId, err = layer.NewMultipartUpload(ctx, Alice, "b", "small", map[string]string{},
+		datatype.Acl{}, datatype.SseRequest{})
+	mustSucceed(t, "new upload", err)
+	completed = nil
+	for i, data := range []string{"small", "tail"} {
+		result, err := layer.PutObjectPart(ctx, "b", "small", Alice, uploadId, i+1,
+			int64(len(data)), strings.NewReader(data), "", datatype.Checksums{},
+			datatype.SseRequest{})
+		mustSucceed(t, "put small part", err)
+		completed = append(completed, meta.CompletePart{PartNumber: i + 1, ETag: result.ETag})
+	}
+	_, err = layer.CompleteMultipartUpload(ctx, Alice, "b", "small", uploadId, completed)
+	if _, ok := err.(meta.PartTooSmall); !ok {
+		t.Errorf("small part should fail completion, got %v", err)
+	}
+
+	expectError(t, "abort upload of others", layer.AbortMultipartUpload(ctx, Bob, "b", "small",
+		uploadId), ErrBucketAccessForbidden)
+	mustSucceed(t, "abort upload", layer.AbortMultipartUpload(ctx, Alice, "b", "small", uploadId))
+	expectError(t, "abort aborted upload", layer.AbortMultipartUpload(ctx, Alice, "b", "small",
+		uploadId), ErrNoSuchUpload)
+	expectNoObject(t, layer, "b", "small", "")
+}
+
+// Expire time of `object`, which is expected to be `ttl` after it's last
+// modified, or never if `ttl` is zero
+func expectTtl(t *testing.T, layer api.ObjectLayer, bucket, object string, ttl time.Duration) {
+	t.Helper()
+	info, err := layer.GetObjectInfo(ctx, bucket, object, "", Alice)
+	mustSucceed(t, "get "+object, err)
+	if ttl == 0 {
+		if !info.ExpireTime.IsZero() {
+			t.Errorf("%s should never expire, got %v", object, info.ExpireTime)
+		}
+		return
+	}
+	if info.ExpireTime.Sub(info.LastModifiedTime).Round(time.Second) != ttl {
+		t.Errorf("%s should expire %v after it's modified, got %v, last modified %v", object,
+			ttl, info.ExpireTime, info.LastModifiedTime)
+	}
+}
+
+func testObjectTtl(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "")
+	ttl := map[string]string{"ttl": "3600"}
+	_, err := layer.PutObject(ctx, "b", "put", Alice, 4, strings.NewReader("hehe"), ttl,
+		datatype.Acl{CannedAcl: "private"}, datatype.SseRequest{})
+	mustSucceed(t, "put with TTL", err)
+	expectTtl(t, layer, "b", "put", time.Hour)
+	putObject(t, layer, "b", "plain", "hehe")
+	expectTtl(t, layer, "b", "plain", 0)
+
+	uploadId, err := layer.NewMultipartUpload(ctx, Alice, "b", "multipart", ttl,
+		datatype.Acl{}, datatype.SseRequest{})
+	mustSucceed(t, "new upload with TTL", err)
+	part, err := layer.PutObjectPart(ctx, "b", "multipart", Alice, uploadId, 1, 4,
+		strings.NewReader("hehe"), "", datatype.Checksums{}, datatype.SseRequest{})
+	mustSucceed(t, "put part", err)
+	_, err = layer.CompleteMultipartUpload(ctx, Alice, "b", "multipart", uploadId,
+		[]meta.CompletePart{{PartNumber: 1, ETag: part.ETag}})
+	mustSucceed(t, "complete upload with TTL", err)
+	expectTtl(t, layer, "b", "multipart", time.Hour)
+
+	// expire time of copies is decided by handlers
+	source, err := layer.GetObjectInfo(ctx, "b", "plain", "", Alice)
+	mustSucceed(t, "get source", err)
+	target := *source
+	target.Name = "expired"
+	target.ExpireTime = time.Now().Add(-time.Second)
+	_, err = layer.CopyObject(ctx, &target, source, strings.NewReader("hehe"), Alice,
+		datatype.SseRequest{})
+	mustSucceed(t, "copy with TTL", err)
+	expectNoObject(t, layer, "b", "expired", "")
+	listed := listAllObjects(t, layer, "b", datatype.ListObjectsRequest{MaxKeys: 1000})
+	if !reflect.DeepEqual(listed, []string{"multipart", "plain", "put"}) {
+		t.Errorf("expired objects should not be listed, got %v", listed)
+	}
+}
+
+// Follow markers of ListObjects until all are listed, keys and common
+// prefixes are returned in the order they're listed
+func listAllObjects(t *testing.T, layer api.ObjectLayer, bucket string,
+	request datatype.ListObjectsRequest) (listed []string) {
+
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		result, err := layer.ListObjects(ctx, Alice, bucket, request)
+		mustSucceed(t, "list objects", err)
+		if len(result.Objects)+len(result.Prefixes) > request.MaxKeys {
+			t.Errorf("at most %d keys should be listed, got %v", request.MaxKeys, result)
+		}
+		var page []string
+		for _, o := range result.Objects {
+			page = append(page, o.Key)
+		}
+		page = append(page, result.Prefixes...)
+		sort.Strings(page)
+		listed = append(listed, page...)
+		if !result.IsTruncated {
+			return
+		}
+		if request.Version == 2 {
+			request.ContinuationToken = result.NextMarker
+		} else {
+			request.Marker = result.NextMarker
+		}
+	}
+	t.Fatalf("listing should end, got %v", listed)
+	return
+}
+
+func testListObjects(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "Enabled")
+	for _, name := range []string{"a", "b/1", "b/2", "c/1", "c/d/1", "d", "e"} {
+		putObject(t, layer, "b", name, name)
+	}
+	putObject(t, layer, "b", "a", "a again")
+	_, err := layer.DeleteObject(ctx, "b", "e", "", Alice, false)
+	mustSucceed(t, "add delete marker", err)
+
+	cases := []struct {
+		request  datatype.ListObjectsRequest
+		expected []string
+	}{
+		{datatype.ListObjectsRequest{MaxKeys: 1000},
+			[]string{"a", "b/1", "b/2", "c/1", "c/d/1", "d"}},
+		{datatype.ListObjectsRequest{MaxKeys: 1},
+			[]string{"a", "b/1", "b/2", "c/1", "c/d/1", "d"}},
+		{datatype.ListObjectsRequest{MaxKeys: 2, Delimiter: "/"},
+			[]string{"a", "b/", "c/", "d"}},
+		{datatype.ListObjectsRequest{MaxKeys: 1, Delimiter: "/", Prefix: "c/"},
+			[]string{"c/1", "c/d/"}},
+		{datatype.ListObjectsRequest{MaxKeys: 1, Prefix: "b"},
+			[]string{"b/1", "b/2"}},
+		{datatype.ListObjectsRequest{MaxKeys: 1000, Marker: "b/2"},
+			[]string{"c/1", "c/d/1", "d"}},
+		{datatype.ListObjectsRequest{Version: 2, MaxKeys: 2, Delimiter: "/"},
+			[]string{"a", "b/", "c/", "d"}},
+		{datatype.ListObjectsRequest{Version: 2, MaxKeys: 1, StartAfter: "c/1"},
+			[]string{"c/d/1", "d"}},
+	}
+	for i, c := range cases {
+		listed := listAllObjects(t, layer, "b", c.request)
+		if !reflect.DeepEqual(listed, c.expected) {
+			t.Errorf("case %d: expected %v, got %v", i, c.expected, listed)
+		}
+	}
+
+	result, err := layer.ListObjects(ctx, Alice, "b", datatype.ListObjectsRequest{
+		MaxKeys: 1, Version: 2, FetchOwner: true})
+	if err != nil || len(result.Objects) != 1 || result.Objects[0].Owner == nil ||
+		result.Objects[0].Owner.ID != Alice.UserId || result.Objects[0].Size != int64(len("a again")) {
+		t.Errorf("latest version should be listed with owner, got %v, %v", result.Objects, err)
+	}
+	result, err = layer.ListObjects(ctx, Alice, "b", datatype.ListObjectsRequest{
+		MaxKeys: 1, Version: 2})
+	if err != nil || len(result.Objects) != 1 || result.Objects[0].Owner != nil {
+		t.Errorf("owner should be omitted unless fetched, got %v, %v", result.Objects, err)
+	}
+}
+
+func testListVersionedObjects(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "Enabled")
+	var expected []string
+	for _, name := range []string{"a", "b/1", "c"} {
+		var versions []string
+		for i := 0; i < 3; i++ {
+			versions = append(versions, name+"@"+putObject(t, layer, "b", name, name).VersionId)
+		}
+		result, err := layer.DeleteObject(ctx, "b", name, "", Alice, false)
+		mustSucceed(t, "add delete marker", err)
+		versions = append(versions, name+"@"+result.VersionId)
+		// the latest first
+		for i := len(versions) - 1; i >= 0; i-- {
+			expected = append(expected, versions[i])
+		}
+	}
+
+	for _, maxKeys := range []int{1, 3, 1000} {
+		var listed []string
+		request := datatype.ListObjectsRequest{Versioned: true, MaxKeys: maxKeys}
+		for i := 0; i < 100; i++ {
+			result, err := layer.ListVersionedObjects(ctx, Alice, "b", request)
+			mustSucceed(t, "list versions", err)
+			if len(result.Objects) > maxKeys {
+				t.Errorf("at most %d versions should be listed, got %v", maxKeys, result.Objects)
+			}
+			for _, o := range result.Objects {
+				listed = append(listed, o.Key+"@"+o.VersionId)
+			}
+			if !result.IsTruncated {
+				break
+			}
+			request.KeyMarker = result.NextKeyMarker
+			request.VersionIdMarker = result.NextVersionIdMarker
+		}
+		if !reflect.DeepEqual(listed, expected) {
+			t.Errorf("max keys %d: expected %v, got %v", maxKeys, expected, listed)
+		}
+	}
+
+	result, err := layer.ListVersionedObjects(ctx, Alice, "b", datatype.ListObjectsRequest{
+		Versioned: true, MaxKeys: 1000, Delimiter: "/"})
+	if err != nil || !reflect.DeepEqual(result.Prefixes, []string{"b/"}) || len(result.Objects) != 8 {
+		t.Errorf("versions under b/ should be rolled up, got %v, %v", result, err)
+	}
+}
+
+func testRestoreObject(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "")
+	putObject(t, layer, "b", "standard", "hehe")
+	_, err := layer.PutObject(ctx, "b", "glacier", Alice, 4, strings.NewReader("hehe"),
+		map[string]string{"storageClass": datatype.STORAGE_CLASS_GLACIER},
+		datatype.Acl{CannedAcl: "private"}, datatype.SseRequest{})
+	mustSucceed(t, "put glacier object", err)
+	request := datatype.RestoreRequest{Days: 1}
+
+	_, err = layer.RestoreObject(ctx, "b", "standard", "", request, Alice)
+	expectError(t, "restore standard object", err, ErrInvalidObjectState)
+	_, err = layer.RestoreObject(ctx, "b", "glacier", "", request, Bob)
+	expectError(t, "restore object of others", err, ErrBucketAccessForbidden)
+	_, err = layer.RestoreObject(ctx, "b", "nothing", "", request, Alice)
+	expectError(t, "restore nothing", err, ErrNoSuchKey)
+
+	restored, err := layer.RestoreObject(ctx, "b", "glacier", "", request, Alice)
+	mustSucceed(t, "restore glacier object", err)
+	if restored {
+		t.Error("object should not be restored before its first restore")
+	}
+	info, err := layer.GetObjectInfo(ctx, "b", "glacier", "", Alice)
+	mustSucceed(t, "get restored object", err)
+	if !info.RestoreExpiryDate.After(time.Now().AddDate(0, 0, 1)) {
+		t.Errorf("restored copy should last for a day, expires at %v", info.RestoreExpiryDate)
+	}
+	restored, err = layer.RestoreObject(ctx, "b", "glacier", "",
+		datatype.RestoreRequest{Days: 3}, Alice)
+	mustSucceed(t, "restore glacier object again", err)
+	if !restored {
+		t.Error("object should be restored already")
+	}
+	info, err = layer.GetObjectInfo(ctx, "b", "glacier", "", Alice)
+	mustSucceed(t, "get restored object", err)
+	if !info.RestoreExpiryDate.After(time.Now().AddDate(0, 0, 3)) {
+		t.Errorf("restore again should extend the expiry, expires at %v", info.RestoreExpiryDate)
+	}
+	expectData(t, layer, "b", "glacier", "", "hehe")
+}
+
+func testListMultipartUploads(t *testing.T, layer api.ObjectLayer) {
+	makeBucket(t, layer, "b", "")
+	var expected []string
+	for _, name := range []string{"a", "a", "b/1", "b/2", "c"} {
+		uploadId, err := layer.NewMultipartUpload(ctx, Alice, "b", name, map[string]string{},
+			datatype.Acl{}, datatype.SseRequest{})
+		mustSucceed(t, "new upload", err)
+		if name != "b/2" {
+			expected = append(expected, name+"@"+uploadId)
+		}
+	}
+	expected[2] = "b/"
+	sort.Strings(expected)
+
+	for _, maxUploads := range []int{1, 2, 1000} {
+		var listed []string
+		request := datatype.ListUploadsRequest{MaxUploads: maxUploads, Delimiter: "/"}
+		for i := 0; i < 100; i++ {
+			result, err := layer.ListMultipartUploads(ctx, Alice, "b", request)
+			mustSucceed(t, "list uploads", err)
+			if len(result.Uploads)+len(result.CommonPrefixes) > maxUploads {
+				t.Errorf("at most %d uploads should be listed, got %v", maxUploads, result)
+			}
+			for _, u := range result.Uploads {
+				listed = append(listed, u.Key+"@"+u.UploadId)
+			}
+			for _, p := range result.CommonPrefixes {
+				listed = append(listed, p.Prefix)
+			}
+			if !result.IsTruncated {
+				break
+			}
+			request.KeyMarker = result.NextKeyMarker
+			request.UploadIdMarker = result.NextUploadIdMarker
+		}
+		sort.Strings(listed)
+		if !reflect.DeepEqual(listed, expected) {
+			t.Errorf("max uploads %d: expected %v, got %v", maxUploads, expected, listed)
+		}
+	}
+
+	// prefixes are matched literally, and an upload named as the prefix
+	// is listed as is rather than rolled up
+	for _, name := range []string{"a.b+/", "a.b+/c", "a.b+/d/e", "axb+/c", "a.bb/c",
+		"文件/", "文件/夹/1", "文件夹"} {
+		_, err := layer.NewMultipartUpload(ctx, Alice, "b", name, map[string]string{},
+			datatype.Acl{}, datatype.SseRequest{})
+		mustSucceed(t, "new upload", err)
+	}
+	for _, c := range []struct {
+		prefix   string
+		expected []string
+	}{
+		{"a.b+/", []string{"a.b+/", "a.b+/c", "a.b+/d/"}},
+		{"a.b+", []string{"a.b+/"}},
+		{"文件/", []string{"文件/", "文件/夹/"}},
+		{"文件", []string{"文件/", "文件夹"}},
+	} {
+		result, err := layer.ListMultipartUploads(ctx, Alice, "b", datatype.ListUploadsRequest{
+			MaxUploads: 1000, Prefix: c.prefix, Delimiter: "/"})
+		mustSucceed(t, "list uploads with prefix", err)
+		var listed []string
+		for _, u := range result.Uploads {
+			listed = append(listed, u.Key)
+		}
+		for _, p := range result.CommonPrefixes {
+			listed = append(listed, p.Prefix)
+		}
+		sort.Strings(listed)
+		if !reflect.DeepEqual(listed, c.expected) {
+			t.Errorf("prefix %q: expected %v, got %v", c.prefix, c.expected, listed)
+		}
+		if result.IsTruncated || result.NextKeyMarker != "" || result.NextUploadIdMarker != "" {
+			t.Errorf("prefix %q: next markers should only be set when truncated, got %+v",
+				c.prefix, result)
+		}
+	}
+
+	_, err := layer.ListMultipartUploads(ctx, Bob, "b", datatype.ListUploadsRequest{MaxUploads: 1000})
+	expectError(t, "list uploads of private bucket of others", err, ErrBucketAccessForbidden)
+}
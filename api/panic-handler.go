@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+// panicHandler recovers panics from handlers it wraps, so one bad request
+// won't bring down the whole server
+type panicHandler struct {
+	handler http.Handler
+}
+
+func (p panicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			requestId := requestIdFromContext(r.Context())
+			helper.Logger.Printf(5, "PANIC %s %s%s RequestID:%s %v",
+				r.Method, r.Host, r.URL, requestId, err)
+			if helper.PanicLogger != nil {
+				helper.PanicLogger.Printf(0, "%s %s%s RequestID:%s panic: %v\n%s",
+					r.Method, r.Host, r.URL, requestId, err, debug.Stack())
+			}
+			WriteErrorResponse(w, r, ErrInternalError)
+		}
+	}()
+	p.handler.ServeHTTP(w, r)
+}
+
+func SetPanicHandler(handler http.Handler, _ ObjectLayer) http.Handler {
+	return panicHandler{handler: handler}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+)
+
+func TestPanicHandler(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	helper.PanicLogger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler panic")
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	server := httptest.NewServer(SetLogHandler(SetPanicHandler(mux, nil), nil))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", response.StatusCode)
+	}
+
+	// server should still be serving after a panic
+	response, err = http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("expected 200 ok after panic, got %d %s", response.StatusCode, body)
+	}
+}
@@ -0,0 +1,87 @@
+package s3select
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+// Messages of the event stream returned by SelectObjectContent, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/RESTSelectObjectAppendix.html
+//
+// Each message is framed as
+//	total length(4) | headers length(4) | prelude CRC(4) | headers | payload | message CRC(4)
+// and each header as
+//	name length(1) | name | value type(1), always 7 for string | value length(2) | value
+
+const headerValueTypeString = 7
+
+type header struct {
+	name, value string
+}
+
+func encodeMessage(headers []header, payload []byte) []byte {
+	var headerBuffer bytes.Buffer
+	for _, h := range headers {
+		headerBuffer.WriteByte(byte(len(h.name)))
+		headerBuffer.WriteString(h.name)
+		headerBuffer.WriteByte(headerValueTypeString)
+		binary.Write(&headerBuffer, binary.BigEndian, uint16(len(h.value)))
+		headerBuffer.WriteString(h.value)
+	}
+
+	totalLength := 4 + 4 + 4 + headerBuffer.Len() + len(payload) + 4
+	var message bytes.Buffer
+	message.Grow(totalLength)
+	binary.Write(&message, binary.BigEndian, uint32(totalLength))
+	binary.Write(&message, binary.BigEndian, uint32(headerBuffer.Len()))
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	message.Write(headerBuffer.Bytes())
+	message.Write(payload)
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	return message.Bytes()
+}
+
+func eventHeaders(eventType, contentType string) []header {
+	headers := []header{
+		{":event-type", eventType},
+	}
+	if contentType != "" {
+		headers = append(headers, header{":content-type", contentType})
+	}
+	return append(headers, header{":message-type", "event"})
+}
+
+func writeRecords(w io.Writer, records []byte) error {
+	_, err := w.Write(encodeMessage(eventHeaders("Records", "application/octet-stream"),
+		records))
+	return err
+}
+
+func writeStats(w io.Writer, scanned, returned int64) error {
+	// objects are not compressed, so bytes processed equals bytes scanned
+	stats := "<?xml version=\"1.0\" encoding=\"UTF-8\"?><Stats>" +
+		"<BytesScanned>" + strconv.FormatInt(scanned, 10) + "</BytesScanned>" +
+		"<BytesProcessed>" + strconv.FormatInt(scanned, 10) + "</BytesProcessed>" +
+		"<BytesReturned>" + strconv.FormatInt(returned, 10) + "</BytesReturned>" +
+		"</Stats>"
+	_, err := w.Write(encodeMessage(eventHeaders("Stats", "text/xml"), []byte(stats)))
+	return err
+}
+
+func writeEnd(w io.Writer) error {
+	_, err := w.Write(encodeMessage(eventHeaders("End", ""), nil))
+	return err
+}
+
+// Errors after response status is sent are reported in stream
+func writeError(w io.Writer, code, message string) error {
+	_, err := w.Write(encodeMessage([]header{
+		{":error-code", code},
+		{":error-message", message},
+		{":message-type", "error"},
+	}, nil))
+	return err
+}
@@ -0,0 +1,179 @@
+package s3select
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+)
+
+// Records are sent in messages of about this size, so neither
+// input nor output is buffered as a whole
+const RECORDS_MESSAGE_SIZE = 128 << 10 // 128 KB
+
+type Selector struct {
+	request datatype.SelectObjectContentRequest
+	query   *Query
+}
+
+// Request should be validated by datatype.SelectRequestFromXml
+func NewSelector(request datatype.SelectObjectContentRequest) (*Selector, error) {
+	query, err := ParseQuery(request.Expression)
+	if err != nil {
+		return nil, ErrUnsupportedSqlStructure
+	}
+	return &Selector{request: request, query: query}, nil
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.count += int64(n)
+	return
+}
+
+type flusher interface {
+	Flush()
+}
+
+// Run query over CSV data from `reader`, write results as event stream
+// into `w`. Errors of reading input are reported in stream, returned error
+// is only of writing `w`.
+func (s *Selector) Run(reader io.Reader, w io.Writer) error {
+	input := s.request.InputSerialization.CSV
+	counter := &countingReader{reader: reader}
+	csvReader := csv.NewReader(counter)
+	csvReader.Comma, _ = utf8.DecodeRuneInString(input.FieldDelimiter)
+	if input.Comments != "" {
+		csvReader.Comment, _ = utf8.DecodeRuneInString(input.Comments)
+	}
+	csvReader.FieldsPerRecord = -1
+	csvReader.ReuseRecord = true
+
+	var names map[string]int
+	var headerNames []string
+	if input.FileHeaderInfo != datatype.CSV_HEADER_NONE {
+		header, err := csvReader.Read()
+		if err != nil && err != io.EOF {
+			return s.writeReadError(w, err)
+		}
+		if input.FileHeaderInfo == datatype.CSV_HEADER_USE {
+			names = make(map[string]int, len(header))
+			for i, name := range header {
+				names[name] = i
+				headerNames = append(headerNames, name)
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	var returned, matched int64
+	flush := func() error {
+		if buffer.Len() == 0 {
+			return nil
+		}
+		returned += int64(buffer.Len())
+		err := writeRecords(w, buffer.Bytes())
+		buffer.Reset()
+		if f, ok := w.(flusher); ok {
+			f.Flush()
+		}
+		return err
+	}
+	for s.query.limit < 0 || matched < s.query.limit {
+		fields, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err := flush(); err != nil {
+				return err
+			}
+			return s.writeReadError(w, err)
+		}
+		projected := s.query.apply(record{fields: fields, names: names})
+		if projected == nil {
+			continue
+		}
+		matched += 1
+		s.encode(&buffer, projected, headerNames)
+		if buffer.Len() >= RECORDS_MESSAGE_SIZE {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := writeStats(w, counter.count, returned); err != nil {
+		return err
+	}
+	return writeEnd(w)
+}
+
+func (s *Selector) writeReadError(w io.Writer, err error) error {
+	if apiErr, ok := err.(ApiError); ok {
+		return writeError(w, apiErr.AwsErrorCode(), apiErr.Description())
+	}
+	if _, ok := err.(*csv.ParseError); ok {
+		return writeError(w, "CSVParsingError", err.Error())
+	}
+	return writeError(w, "InternalError", err.Error())
+}
+
+func (s *Selector) encode(buffer *bytes.Buffer, fields []string, headerNames []string) {
+	output := s.request.OutputSerialization
+	if output.CSV != nil {
+		for i, field := range fields {
+			if i > 0 {
+				buffer.WriteString(output.CSV.FieldDelimiter)
+			}
+			if strings.ContainsAny(field, output.CSV.FieldDelimiter+"\"\r\n") {
+				field = "\"" + strings.Replace(field, "\"", "\"\"", -1) + "\""
+			}
+			buffer.WriteString(field)
+		}
+		buffer.WriteString(output.CSV.RecordDelimiter)
+		return
+	}
+
+	buffer.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(s.columnName(i, headerNames))
+		value, _ := json.Marshal(field)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	buffer.WriteString(output.JSON.RecordDelimiter)
+}
+
+// Name of i-th output column, used as key of JSON output
+func (s *Selector) columnName(i int, headerNames []string) string {
+	if s.query.columns == nil {
+		if i < len(headerNames) {
+			return headerNames[i]
+		}
+		return "_" + strconv.Itoa(i+1)
+	}
+	c := s.query.columns[i]
+	if c.index > 0 {
+		return "_" + strconv.Itoa(c.index)
+	}
+	return c.name
+}
@@ -0,0 +1,194 @@
+package s3select
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/journeymidnight/yig/api/datatype"
+)
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		sql string
+		ok  bool
+	}{
+		{"SELECT * FROM S3Object", true},
+		{"select s._1, s._3 from s3object s where s._2 > 10 limit 5", true},
+		{"SELECT name FROM S3Object AS s WHERE (age >= 18 AND NOT city = 'Beijing') OR \"id\" <> '1'", true},
+		{"SELECT * FROM S3Object WHERE _1 = 'it''s'", true},
+		{"SELECT * FROM table", false},
+		{"SELECT FROM S3Object", false},
+		{"SELECT * FROM S3Object WHERE _1", false},
+		{"SELECT * FROM S3Object WHERE _1 = 'unterminated", false},
+		{"SELECT * FROM S3Object LIMIT hehe", false},
+		{"SELECT count(*) FROM S3Object", false},
+	}
+	for _, c := range cases {
+		_, err := ParseQuery(c.sql)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.sql, c.ok, err)
+		}
+	}
+}
+
+type message struct {
+	headers map[string]string
+	payload []byte
+}
+
+// decode event stream and verify CRCs
+func decodeMessages(t *testing.T, stream []byte) (messages []message) {
+	for len(stream) > 0 {
+		totalLength := binary.BigEndian.Uint32(stream[0:4])
+		headersLength := binary.BigEndian.Uint32(stream[4:8])
+		if crc32.ChecksumIEEE(stream[0:8]) != binary.BigEndian.Uint32(stream[8:12]) {
+			t.Fatal("bad prelude CRC")
+		}
+		if crc32.ChecksumIEEE(stream[:totalLength-4]) !=
+			binary.BigEndian.Uint32(stream[totalLength-4:totalLength]) {
+			t.Fatal("bad message CRC")
+		}
+		m := message{headers: make(map[string]string)}
+		headers := stream[12 : 12+headersLength]
+		for len(headers) > 0 {
+			nameLength := int(headers[0])
+			name := string(headers[1 : 1+nameLength])
+			valueLength := int(binary.BigEndian.Uint16(headers[2+nameLength:]))
+			m.headers[name] = string(headers[4+nameLength : 4+nameLength+valueLength])
+			headers = headers[4+nameLength+valueLength:]
+		}
+		m.payload = stream[12+headersLength : totalLength-4]
+		messages = append(messages, m)
+		stream = stream[totalLength:]
+	}
+	return
+}
+
+func runSelect(t *testing.T, request datatype.SelectObjectContentRequest,
+	input io.Reader) (records string, messages []message) {
+
+	selector, err := NewSelector(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = selector.Run(input, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages = decodeMessages(t, out.Bytes())
+	for _, m := range messages {
+		if m.headers[":event-type"] == "Records" {
+			records += string(m.payload)
+		}
+	}
+	return
+}
+
+const people = `name,age,city
+alice,30,Beijing
+bob,17,"Shanghai, China"
+carol,25,Beijing
+`
+
+func csvRequest(sql, headerInfo string) datatype.SelectObjectContentRequest {
+	return datatype.SelectObjectContentRequest{
+		Expression:     sql,
+		ExpressionType: "SQL",
+		InputSerialization: datatype.InputSerialization{
+			CSV: &datatype.CsvInput{
+				FileHeaderInfo:  headerInfo,
+				FieldDelimiter:  ",",
+				RecordDelimiter: "\n",
+				QuoteCharacter:  "\"",
+			},
+		},
+		OutputSerialization: datatype.OutputSerialization{
+			CSV: &datatype.CsvOutput{FieldDelimiter: ",", RecordDelimiter: "\n"},
+		},
+	}
+}
+
+func TestSelectCsv(t *testing.T) {
+	cases := []struct {
+		sql      string
+		header   string
+		expected string
+	}{
+		{"SELECT name FROM S3Object WHERE age >= 18", "USE", "alice\ncarol\n"},
+		{"SELECT s._3, s._1 FROM S3Object s WHERE s._1 <> 'alice'", "IGNORE",
+			"\"Shanghai, China\",bob\nBeijing,carol\n"},
+		{"SELECT * FROM S3Object WHERE city = 'Beijing' AND NOT name = 'alice'", "USE",
+			"carol,25,Beijing\n"},
+		{"SELECT _1 FROM S3Object LIMIT 2", "NONE", "name\nalice\n"},
+		{"SELECT name FROM S3Object WHERE age < 20 OR name = 'carol'", "USE", "bob\ncarol\n"},
+	}
+	scanned := fmt.Sprintf("<BytesScanned>%d</BytesScanned>", len(people))
+	for _, c := range cases {
+		records, messages := runSelect(t, csvRequest(c.sql, c.header), strings.NewReader(people))
+		if records != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.sql, c.expected, records)
+		}
+		last := messages[len(messages)-1]
+		if last.headers[":event-type"] != "End" {
+			t.Errorf("%s: stream should end with End event, got %v", c.sql, last.headers)
+		}
+		stats := messages[len(messages)-2]
+		if stats.headers[":event-type"] != "Stats" ||
+			!bytes.Contains(stats.payload, []byte(scanned)) {
+			t.Errorf("%s: bad stats %v %s", c.sql, stats.headers, stats.payload)
+		}
+	}
+}
+
+func TestSelectJsonOutput(t *testing.T) {
+	request := csvRequest("SELECT name, _2 FROM S3Object WHERE name = 'bob'", "USE")
+	request.OutputSerialization = datatype.OutputSerialization{
+		JSON: &datatype.JsonOutput{RecordDelimiter: "\n"},
+	}
+	records, _ := runSelect(t, request, strings.NewReader(people))
+	if records != "{\"name\":\"bob\",\"_2\":\"17\"}\n" {
+		t.Errorf("unexpected records %q", records)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("ceph down")
+}
+
+func TestSelectReadError(t *testing.T) {
+	_, messages := runSelect(t, csvRequest("SELECT * FROM S3Object", "NONE"),
+		io.MultiReader(strings.NewReader("a,b\n"), failingReader{}))
+	last := messages[len(messages)-1]
+	if last.headers[":message-type"] != "error" ||
+		last.headers[":error-code"] != "InternalError" {
+		t.Errorf("read error should be reported in stream, got %v", last.headers)
+	}
+}
+
+// records are sent in multiple messages instead of buffered
+func TestSelectLargeInput(t *testing.T) {
+	line := strings.Repeat("x", 1000) + "\n"
+	reader, writer := io.Pipe()
+	go func() {
+		for i := 0; i < 1000; i++ {
+			writer.Write([]byte(line))
+		}
+		writer.Close()
+	}()
+	records, messages := runSelect(t, csvRequest("SELECT * FROM S3Object", "NONE"), reader)
+	if len(records) != 1000*len(line) {
+		t.Errorf("expected %d bytes, got %d", 1000*len(line), len(records))
+	}
+	if len(messages) < 2+1000*len(line)/RECORDS_MESSAGE_SIZE {
+		t.Errorf("records should be split into messages, got %d messages", len(messages))
+	}
+}
@@ -0,0 +1,468 @@
+package s3select
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A minimal subset of S3 Select SQL:
+//
+//	SELECT * | column [, column ...] FROM S3Object [[AS] alias]
+//	    [WHERE condition] [LIMIT n]
+//
+// Columns are `_1`, `_2`... by position, or names from CSV header.
+// Conditions are comparisons(=, !=, <>, <, <=, >, >=) of columns and
+// literals, combined with AND, OR, NOT and parentheses. A comparison is
+// numeric if the literal is a number, otherwise values are compared as strings.
+
+var ErrUnsupportedSql = errors.New("unsupported SQL expression")
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+func tokenize(sql string) (tokens []token, err error) {
+	runes := []rune(sql)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i += 1
+		case r == '\'' || r == '"':
+			// single quotes for strings, double quotes for identifiers,
+			// quote is escaped by doubling it
+			var value []rune
+			i += 1
+			for {
+				if i >= len(runes) {
+					return nil, ErrUnsupportedSql
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						value = append(value, r)
+						i += 2
+						continue
+					}
+					i += 1
+					break
+				}
+				value = append(value, runes[i])
+				i += 1
+			}
+			kind := tokenString
+			if r == '"' {
+				kind = tokenIdentifier
+			}
+			tokens = append(tokens, token{kind, string(value)})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i += 1
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i += 1
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				runes[i] == '_') {
+				i += 1
+			}
+			tokens = append(tokens, token{tokenIdentifier, string(runes[start:i])})
+		case strings.ContainsRune("<>!", r):
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				tokens = append(tokens, token{tokenSymbol, string(runes[i : i+2])})
+				i += 2
+			} else if r == '!' {
+				return nil, ErrUnsupportedSql
+			} else {
+				tokens = append(tokens, token{tokenSymbol, string(r)})
+				i += 1
+			}
+		case strings.ContainsRune("=*,.()", r):
+			tokens = append(tokens, token{tokenSymbol, string(r)})
+			i += 1
+		default:
+			return nil, ErrUnsupportedSql
+		}
+	}
+	return append(tokens, token{kind: tokenEnd}), nil
+}
+
+// Reference to a column, by position if index > 0, otherwise by name
+type column struct {
+	index int
+	name  string
+}
+
+type operand struct {
+	isColumn bool
+	column   column
+	literal  string
+	isNumber bool
+	number   float64
+}
+
+type condition struct {
+	op          string // AND, OR, NOT, or comparison operator
+	left, right *condition
+	lhs, rhs    operand
+}
+
+type Query struct {
+	columns []column // nil for *
+	where   *condition
+	limit   int64 // -1 if no limit
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	alias  string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos += 1
+	}
+	return t
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdentifier && strings.EqualFold(t.value, keyword)
+}
+
+func (p *parser) isSymbol(symbol string) bool {
+	t := p.peek()
+	return t.kind == tokenSymbol && t.value == symbol
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.isKeyword(keyword) {
+		return ErrUnsupportedSql
+	}
+	p.next()
+	return nil
+}
+
+func ParseQuery(sql string) (query *Query, err error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return
+	}
+	p := &parser{tokens: tokens}
+	query = &Query{limit: -1}
+
+	if err = p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	// alias is known only after FROM, so parse FROM first
+	projectionStart := p.pos
+	for !p.isKeyword("FROM") {
+		if p.peek().kind == tokenEnd {
+			return nil, ErrUnsupportedSql
+		}
+		p.next()
+	}
+	projectionEnd := p.pos
+	p.next()
+	if err = p.expectKeyword("S3Object"); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("AS") {
+		p.next()
+	}
+	if t := p.peek(); t.kind == tokenIdentifier && !p.isKeyword("WHERE") &&
+		!p.isKeyword("LIMIT") {
+		p.alias = t.value
+		p.next()
+	}
+	if p.isKeyword("WHERE") {
+		p.next()
+		query.where, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.isKeyword("LIMIT") {
+		p.next()
+		t := p.next()
+		if t.kind != tokenNumber {
+			return nil, ErrUnsupportedSql
+		}
+		query.limit, err = strconv.ParseInt(t.value, 10, 64)
+		if err != nil || query.limit < 0 {
+			return nil, ErrUnsupportedSql
+		}
+	}
+	if p.peek().kind != tokenEnd {
+		return nil, ErrUnsupportedSql
+	}
+
+	whereEnd := p.pos
+	p.pos = projectionStart
+	if p.isSymbol("*") {
+		p.next()
+	} else {
+		for {
+			c, err := p.parseColumn()
+			if err != nil {
+				return nil, err
+			}
+			query.columns = append(query.columns, c)
+			if !p.isSymbol(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.pos != projectionEnd {
+		return nil, ErrUnsupportedSql
+	}
+	p.pos = whereEnd
+	return query, nil
+}
+
+func (p *parser) parseColumn() (c column, err error) {
+	t := p.next()
+	if t.kind != tokenIdentifier {
+		return c, ErrUnsupportedSql
+	}
+	// strip alias in "s._1" or "S3Object.name"
+	if p.isSymbol(".") && (strings.EqualFold(t.value, "S3Object") ||
+		(p.alias != "" && strings.EqualFold(t.value, p.alias))) {
+		p.next()
+		t = p.next()
+		if t.kind != tokenIdentifier {
+			return c, ErrUnsupportedSql
+		}
+	}
+	if strings.HasPrefix(t.value, "_") {
+		if index, err := strconv.Atoi(t.value[1:]); err == nil && index > 0 {
+			return column{index: index}, nil
+		}
+	}
+	return column{name: t.value}, nil
+}
+
+func (p *parser) parseOr() (*condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &condition{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (*condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &condition{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (*condition, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &condition{op: "NOT", left: c}, nil
+	}
+	if p.isSymbol("(") {
+		p.next()
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isSymbol(")") {
+			return nil, ErrUnsupportedSql
+		}
+		p.next()
+		return c, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (c *condition, err error) {
+	c = new(condition)
+	c.lhs, err = p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.next()
+	switch t.value {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		if t.kind != tokenSymbol {
+			return nil, ErrUnsupportedSql
+		}
+		c.op = t.value
+	default:
+		return nil, ErrUnsupportedSql
+	}
+	c.rhs, err = p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *parser) parseOperand() (o operand, err error) {
+	switch t := p.peek(); t.kind {
+	case tokenString:
+		p.next()
+		o.literal = t.value
+	case tokenNumber:
+		p.next()
+		o.literal = t.value
+		o.number, err = strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return o, ErrUnsupportedSql
+		}
+		o.isNumber = true
+	case tokenIdentifier:
+		o.isColumn = true
+		o.column, err = p.parseColumn()
+	default:
+		err = ErrUnsupportedSql
+	}
+	return
+}
+
+// Fields of a record, `names` maps header names to positions(from 0)
+type record struct {
+	fields []string
+	names  map[string]int
+}
+
+func (r record) get(c column) (string, bool) {
+	index := c.index - 1
+	if c.index == 0 {
+		var ok bool
+		index, ok = r.names[c.name]
+		if !ok {
+			return "", false
+		}
+	}
+	if index < 0 || index >= len(r.fields) {
+		return "", false
+	}
+	return r.fields[index], true
+}
+
+func (o operand) value(r record) (value string, ok bool) {
+	if o.isColumn {
+		return r.get(o.column)
+	}
+	return o.literal, true
+}
+
+func compare(op string, lhs, rhs operand, r record) bool {
+	left, ok := lhs.value(r)
+	if !ok {
+		return false
+	}
+	right, ok := rhs.value(r)
+	if !ok {
+		return false
+	}
+	var result int
+	if lhs.isNumber || rhs.isNumber {
+		l, err := strconv.ParseFloat(strings.TrimSpace(left), 64)
+		if err != nil {
+			return false
+		}
+		r, err := strconv.ParseFloat(strings.TrimSpace(right), 64)
+		if err != nil {
+			return false
+		}
+		switch {
+		case l < r:
+			result = -1
+		case l > r:
+			result = 1
+		}
+	} else {
+		result = strings.Compare(left, right)
+	}
+	switch op {
+	case "=":
+		return result == 0
+	case "!=", "<>":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	}
+	return false
+}
+
+func (c *condition) match(r record) bool {
+	if c == nil {
+		return true
+	}
+	switch c.op {
+	case "AND":
+		return c.left.match(r) && c.right.match(r)
+	case "OR":
+		return c.left.match(r) || c.right.match(r)
+	case "NOT":
+		return !c.left.match(r)
+	}
+	return compare(c.op, c.lhs, c.rhs, r)
+}
+
+// Returns projected fields, or nil if record is filtered out
+func (q *Query) apply(r record) []string {
+	if !q.where.match(r) {
+		return nil
+	}
+	if q.columns == nil {
+		return r.fields
+	}
+	projected := make([]string, len(q.columns))
+	for i, c := range q.columns {
+		projected[i], _ = r.get(c)
+	}
+	return projected
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	mux "github.com/gorilla/mux"
+	. "github.com/journeymidnight/yig/api/datatype"
+	"github.com/journeymidnight/yig/api/s3select"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/signature"
+)
+
+// SelectObjectContentHandler - POST Object?select&select-type=2
+// ----------
+// Filter contents of a CSV object with a simple SQL expression, matched
+// records are streamed back in event stream messages. Same permission as
+// GET Object is required.
+func (api ObjectAPIHandlers) SelectObjectContentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+	objectName := vars["object"]
+
+	var credential iam.Credential
+	var err error
+	switch signature.GetRequestAuthType(r) {
+	default:
+		// For all unknown auth types return error.
+		WriteErrorResponse(w, r, ErrAccessDenied)
+		return
+	case signature.AuthTypeAnonymous:
+		break
+	case signature.AuthTypePresignedV4, signature.AuthTypeSignedV4,
+		signature.AuthTypePresignedV2, signature.AuthTypeSignedV2:
+		if credential, err = signature.IsReqAuthenticated(r); err != nil {
+			WriteErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !contains(r.TransferEncoding, "chunked") {
+		if r.ContentLength == -1 || r.ContentLength == 0 {
+			WriteErrorResponse(w, r, ErrMissingContentLength)
+			return
+		}
+		if r.ContentLength > MAX_SELECT_REQUEST_SIZE {
+			WriteErrorResponse(w, r, ErrEntityTooLarge)
+			return
+		}
+	}
+	selectBuffer, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_SELECT_REQUEST_SIZE))
+	if err != nil {
+		helper.ErrorIf(err, "Unable to read select body")
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	request, err := SelectRequestFromXml(selectBuffer)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	selector, err := s3select.NewSelector(request)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	version := r.URL.Query().Get("versionId")
+	object, err := api.ObjectAPI.GetObjectInfo(r.Context(), bucketName, objectName, version, credential)
+	if err != nil {
+		helper.ErrorIf(err, "Unable to fetch object info.")
+		if err == ErrNoSuchKey {
+			err = api.errAllowableObjectNotFound(r.Context(), bucketName, credential)
+		}
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	if object.DeleteMarker {
+		WriteErrorResponse(w, r, ErrNoSuchKey)
+		return
+	}
+	sseRequest, err := parseSseHeader(r.Header)
+	if err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	defer pipeReader.Close()
+	go func() {
+		err := api.ObjectAPI.GetObject(r.Context(), object, 0, object.Size, pipeWriter, sseRequest)
+		helper.ErrorIf(err, "Unable to read object for select %s", objectName)
+		pipeWriter.CloseWithError(err)
+	}()
+
+	// errors after this are sent in event stream
+	w.Header().Set("Content-Type", "application/octet-stream")
+	api.setRequestChargedHeader(w, r, bucketName, credential)
+	w.WriteHeader(http.StatusOK)
+	err = selector.Run(pipeReader, w)
+	helper.ErrorIf(err, "Unable to write select results of %s", objectName)
+}
@@ -5,11 +5,9 @@ import (
 	"net/http"
 )
 
-type ContextKey int
-
-const (
-	RequestId ContextKey = iota
-)
+// Context key of request ID, shared with storage so error logs there
+// could be traced back to requests
+const RequestId = helper.RequestIdKey
 
 type Server struct {
 	Server *http.Server
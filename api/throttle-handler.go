@@ -0,0 +1,277 @@
+package api
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/signature"
+)
+
+// Token bucket rate limiting, keyed by access key(or client IP for anonymous
+// requests) and optionally by bucket name. Unlike rateLimit which limits
+// concurrent requests of the whole server, this keeps a single tenant from
+// starving the others.
+
+type RateLimits struct {
+	RequestsPerSecond       float64 `json:"requestsPerSecond"` // 0 means no limit
+	Burst                   int     `json:"burst"`
+	BucketRequestsPerSecond float64 `json:"bucketRequestsPerSecond"` // 0 means no limit
+	BucketBurst             int     `json:"bucketBurst"`
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(rate float64, burst int, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// time to wait until one token is available
+func (b *tokenBucket) wait(rate float64) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+type throttle struct {
+	handler  http.Handler
+	lock     sync.Mutex
+	limits   RateLimits
+	capacity int // max number of token buckets kept, least recently used ones are dropped
+	buckets  map[string]*list.Element
+	lru      *list.List
+	now      func() time.Time
+}
+
+var requestThrottle *throttle
+
+func newThrottle(handler http.Handler, limits RateLimits, capacity int) *throttle {
+	return &throttle{
+		handler:  handler,
+		limits:   limits,
+		capacity: capacity,
+		buckets:  make(map[string]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// Should be called with lock held
+func (t *throttle) getBucket(key string, burst int, now time.Time) *tokenBucket {
+	if element, ok := t.buckets[key]; ok {
+		t.lru.MoveToFront(element)
+		return element.Value.(*tokenBucket)
+	}
+	b := &tokenBucket{key: key, tokens: float64(burst), last: now}
+	t.buckets[key] = t.lru.PushFront(b)
+	for t.lru.Len() > t.capacity {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		delete(t.buckets, oldest.Value.(*tokenBucket).key)
+	}
+	return b
+}
+
+func effectiveBurst(burst int) int {
+	if burst < 1 {
+		return 1
+	}
+	return burst
+}
+
+// Take one token from both buckets of the requester and the bucket
+// requested, tokens are taken only if both have one available. Requester
+// is not limited if empty.
+// Returns how long the client should wait if not allowed.
+func (t *throttle) allow(requester, bucketName string) (bool, time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	type limited struct {
+		bucket *tokenBucket
+		rate   float64
+	}
+	var checks []limited
+	if t.limits.RequestsPerSecond > 0 && requester != "" {
+		burst := effectiveBurst(t.limits.Burst)
+		b := t.getBucket("requester:"+requester, burst, now)
+		b.refill(t.limits.RequestsPerSecond, burst, now)
+		checks = append(checks, limited{b, t.limits.RequestsPerSecond})
+	}
+	if t.limits.BucketRequestsPerSecond > 0 && bucketName != "" {
+		burst := effectiveBurst(t.limits.BucketBurst)
+		b := t.getBucket("bucket:"+bucketName, burst, now)
+		b.refill(t.limits.BucketRequestsPerSecond, burst, now)
+		checks = append(checks, limited{b, t.limits.BucketRequestsPerSecond})
+	}
+
+	var retryAfter time.Duration
+	for _, c := range checks {
+		if wait := c.bucket.wait(c.rate); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	for _, c := range checks {
+		c.bucket.tokens -= 1
+	}
+	return true, 0
+}
+
+// How long requester should wait until it has one token available, no
+// token is taken
+func (t *throttle) pending(requester string) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.limits.RequestsPerSecond <= 0 {
+		return 0
+	}
+	now := t.now()
+	burst := effectiveBurst(t.limits.Burst)
+	b := t.getBucket("requester:"+requester, burst, now)
+	b.refill(t.limits.RequestsPerSecond, burst, now)
+	return b.wait(t.limits.RequestsPerSecond)
+}
+
+// Take one token from requester even if it has none left, for requests
+// already served
+func (t *throttle) charge(requester string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.limits.RequestsPerSecond <= 0 {
+		return
+	}
+	now := t.now()
+	burst := effectiveBurst(t.limits.Burst)
+	b := t.getBucket("requester:"+requester, burst, now)
+	b.refill(t.limits.RequestsPerSecond, burst, now)
+	b.tokens -= 1
+}
+
+// Give back the token taken from `bucketName` by allow, for requests denied
+// by the limit of their access key afterwards
+func (t *throttle) refund(bucketName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.limits.BucketRequestsPerSecond <= 0 || bucketName == "" {
+		return
+	}
+	now := t.now()
+	burst := effectiveBurst(t.limits.BucketBurst)
+	b := t.getBucket("bucket:"+bucketName, burst, now)
+	b.refill(t.limits.BucketRequestsPerSecond, burst, now)
+	b.tokens += 1
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+}
+
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// Access keys claimed by requests are only charged once their signatures
+// are verified, by helper.CheckVerifiedKey from signature package, so
+// forged requests can't drain tokens of others' keys. Anonymous requests,
+// and those failed to authenticate, are charged to the client IP instead.
+// Requests claiming access keys are not charged to the IP before they're
+// served, but denied if the IP has no tokens left. The token taken from the
+// bucket is given back if the access key turns out to be limited.
+func (t *throttle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, _ := bucketAndObjectFromRequest(r)
+	ip := "ip:" + helper.ClientIP(r)
+	if signature.GetAccessKeyUnverified(r) == "" {
+		if allowed, retryAfter := t.allow(ip, bucketName); !allowed {
+			setRetryAfter(w, retryAfter)
+			WriteErrorResponse(w, r, ErrSlowDown)
+			return
+		}
+		t.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if retryAfter := t.pending(ip); retryAfter > 0 {
+		setRetryAfter(w, retryAfter)
+		WriteErrorResponse(w, r, ErrSlowDown)
+		return
+	}
+	if allowed, retryAfter := t.allow("", bucketName); !allowed {
+		setRetryAfter(w, retryAfter)
+		WriteErrorResponse(w, r, ErrSlowDown)
+		return
+	}
+	var verified int32
+	ctx := helper.WithVerifiedKeyCheck(r.Context(), func(accessKey string) error {
+		// charged once even if the request is verified more than once
+		if accessKey == "" || !atomic.CompareAndSwapInt32(&verified, 0, 1) {
+			return nil
+		}
+		if allowed, retryAfter := t.allow("key:"+accessKey, ""); !allowed {
+			t.refund(bucketName)
+			setRetryAfter(w, retryAfter)
+			return ErrSlowDown
+		}
+		return nil
+	})
+	t.handler.ServeHTTP(w, r.WithContext(ctx))
+	if atomic.LoadInt32(&verified) == 0 {
+		t.charge(ip)
+	}
+}
+
+// SetThrottleHandler limits request rate of each access key and bucket based on
+// CONFIG.RateLimitRequests and CONFIG.BucketRateLimitRequests
+func SetThrottleHandler(handler http.Handler, _ ObjectLayer) http.Handler {
+	limits := RateLimits{
+		RequestsPerSecond:       helper.CONFIG.RateLimitRequests,
+		Burst:                   helper.CONFIG.RateLimitBurst,
+		BucketRequestsPerSecond: helper.CONFIG.BucketRateLimitRequests,
+		BucketBurst:             helper.CONFIG.BucketRateLimitBurst,
+	}
+	requestThrottle = newThrottle(handler, limits, helper.CONFIG.RateLimitCacheSize)
+	return requestThrottle
+}
+
+func GetRateLimits() RateLimits {
+	if requestThrottle == nil {
+		return RateLimits{}
+	}
+	requestThrottle.lock.Lock()
+	defer requestThrottle.lock.Unlock()
+	return requestThrottle.limits
+}
+
+// Adjust limits at runtime, token buckets already created are kept and
+// refilled with new rates
+func SetRateLimits(limits RateLimits) error {
+	if limits.RequestsPerSecond < 0 || limits.Burst < 0 ||
+		limits.BucketRequestsPerSecond < 0 || limits.BucketBurst < 0 {
+		return ErrInvalidRateLimitRequest
+	}
+	if requestThrottle == nil {
+		return ErrInternalError
+	}
+	requestThrottle.lock.Lock()
+	defer requestThrottle.lock.Unlock()
+	requestThrottle.limits = limits
+	return nil
+}
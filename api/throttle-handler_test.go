@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/log"
+	"github.com/journeymidnight/yig/signature"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+})
+
+func signedV2Request(accessKey, bucket string) *http.Request {
+	r, _ := http.NewRequest("GET", "http://s3.test.com/"+bucket+"/hehe", nil)
+	r.Header.Set("Authorization", "AWS "+accessKey+":c2lnbmF0dXJl")
+	r.RemoteAddr = "10.0.0.1:12345"
+	// request id is set by log handler in production
+	return r.WithContext(context.WithValue(r.Context(), RequestId, "hehe"))
+}
+
+// Hammer the throttle from many goroutines, number of allowed requests
+// should be burst + rate * duration
+func TestThrottleConvergesToRate(t *testing.T) {
+	const rate = 200
+	const burst = 10
+	const duration = 500 * time.Millisecond
+	th := newThrottle(okHandler, RateLimits{RequestsPerSecond: rate, Burst: burst}, 100)
+
+	var allowed int64
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(duration)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if ok, _ := th.allow("key:hehe", ""); ok {
+					atomic.AddInt64(&allowed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	expected := burst + rate*duration.Seconds()
+	if float64(allowed) < expected*0.85 || float64(allowed) > expected*1.15 {
+		t.Errorf("expected about %.0f requests allowed, got %d", expected, allowed)
+	}
+}
+
+func TestThrottleBucketLimit(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	th := newThrottle(okHandler, RateLimits{BucketRequestsPerSecond: 1, BucketBurst: 2}, 100)
+	th.now = func() time.Time { return now }
+
+	// different requesters share limit of the same bucket
+	for i := 0; i < 2; i++ {
+		if ok, _ := th.allow("key:"+strconv.Itoa(i), "bucket"); !ok {
+			t.Fatalf("request %d should be allowed within burst", i)
+		}
+	}
+	ok, retryAfter := th.allow("key:2", "bucket")
+	if ok || retryAfter != time.Second {
+		t.Errorf("expected to be limited for 1s, got %v %v", ok, retryAfter)
+	}
+	if ok, _ := th.allow("key:2", "another"); !ok {
+		t.Error("other buckets should not be limited")
+	}
+	now = now.Add(time.Second)
+	if ok, _ := th.allow("key:2", "bucket"); !ok {
+		t.Error("request should be allowed after refilling")
+	}
+}
+
+func TestThrottleMemoryBounded(t *testing.T) {
+	th := newThrottle(okHandler, RateLimits{RequestsPerSecond: 1, Burst: 1}, 10)
+	for i := 0; i < 1000; i++ {
+		th.allow("key:"+strconv.Itoa(i), "")
+	}
+	if th.lru.Len() != 10 || len(th.buckets) != 10 {
+		t.Errorf("expected 10 token buckets kept, got %d %d", th.lru.Len(), len(th.buckets))
+	}
+	// the most recent ones are kept
+	if _, ok := th.buckets["requester:key:999"]; !ok {
+		t.Error("most recently used token bucket should be kept")
+	}
+}
+
+// Authenticates requests like object handlers do, anonymous ones are allowed
+var authHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if signature.GetRequestAuthType(r) == signature.AuthTypeAnonymous {
+		w.Write([]byte("ok"))
+		return
+	}
+	if _, err := signature.IsReqAuthenticated(r); err != nil {
+		WriteErrorResponse(w, r, err)
+		return
+	}
+	w.Write([]byte("ok"))
+})
+
+func setupThrottleCredentials(t *testing.T) func() {
+	config := helper.CONFIG
+	helper.CONFIG.IamBackend = iam.BACKEND_LOCAL
+	helper.CONFIG.IamCredentials = []helper.IamCredential{
+		{AccessKey: "alice", SecretKey: "alicealice", UserId: "alice"},
+		{AccessKey: "bob", SecretKey: "bobbob", UserId: "bob"},
+	}
+	if err := iam.SetupBackend(); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		helper.CONFIG = config
+		helper.CONFIG.IamBackend = iam.BACKEND_REMOTE
+		iam.SetupBackend()
+	}
+}
+
+func throttledRequest(t *testing.T, accessKey, secretKey, ip string) *http.Request {
+	r := properlySignedRequest(t, accessKey, secretKey)
+	r.RemoteAddr = ip + ":12345"
+	return r.WithContext(context.WithValue(r.Context(), RequestId, "hehe"))
+}
+
+func TestThrottleHandler(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	defer setupThrottleCredentials(t)()
+	th := newThrottle(authHandler, RateLimits{RequestsPerSecond: 0.5, Burst: 1}, 100)
+
+	recorder := httptest.NewRecorder()
+	th.ServeHTTP(recorder, throttledRequest(t, "alice", "alicealice", "10.0.0.1"))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("first request should pass, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	th.ServeHTTP(recorder, throttledRequest(t, "alice", "alicealice", "10.0.0.1"))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("second request should be limited, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Retry-After") != "2" {
+		t.Errorf("unexpected Retry-After: %s", recorder.Header().Get("Retry-After"))
+	}
+
+	// other access keys have their own limits, anonymous requests are
+	// limited by source IP
+	recorder = httptest.NewRecorder()
+	th.ServeHTTP(recorder, throttledRequest(t, "bob", "bobbob", "10.0.0.1"))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("request of another access key should pass, got %d", recorder.Code)
+	}
+	anonymous := signedV2Request("", "bucket")
+	anonymous.Header.Del("Authorization")
+	recorder = httptest.NewRecorder()
+	th.ServeHTTP(recorder, anonymous)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("anonymous request should pass, got %d", recorder.Code)
+	}
+	if _, ok := th.buckets["requester:ip:10.0.0.1"]; !ok {
+		t.Error("anonymous request should be limited by IP")
+	}
+
+	// limits adjusted at runtime
+	requestThrottle = th
+	defer func() { requestThrottle = nil }()
+	err := SetRateLimits(RateLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = httptest.NewRecorder()
+	th.ServeHTTP(recorder, throttledRequest(t, "alice", "alicealice", "10.0.0.1"))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("request should pass after removing limits, got %d", recorder.Code)
+	}
+	if SetRateLimits(RateLimits{RequestsPerSecond: -1}) == nil {
+		t.Error("negative limits should be rejected")
+	}
+}
+
+// Requests forging the access key of others are charged to their own IP
+func TestThrottleForgedKey(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	defer setupThrottleCredentials(t)()
+	th := newThrottle(authHandler, RateLimits{RequestsPerSecond: 0.5, Burst: 1}, 100)
+
+	recorder := httptest.NewRecorder()
+	th.ServeHTTP(recorder, throttledRequest(t, "alice", "forged", "10.0.0.2"))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("forged request should be denied, got %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	th.ServeHTTP(recorder, throttledRequest(t, "alice", "forged", "10.0.0.2"))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("forged requests should be limited by IP, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	th.ServeHTTP(recorder, throttledRequest(t, "alice", "alicealice", "10.0.0.1"))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("request of the real key should pass, got %d", recorder.Code)
+	}
+}
+
+// Requests denied by the limit of their access key don't use up the limit
+// of the bucket
+func TestThrottleKeyLimitedRefundsBucket(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	defer setupThrottleCredentials(t)()
+	th := newThrottle(authHandler, RateLimits{RequestsPerSecond: 0.5, Burst: 1,
+		BucketRequestsPerSecond: 0.5, BucketBurst: 2}, 100)
+	now := time.Unix(1500000000, 0)
+	th.now = func() time.Time { return now }
+
+	for i, expected := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		recorder := httptest.NewRecorder()
+		th.ServeHTTP(recorder, throttledRequest(t, "alice", "alicealice", "10.0.0.1"))
+		if recorder.Code != expected {
+			t.Fatalf("request %d: expected %d, got %d", i, expected, recorder.Code)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	th.ServeHTTP(recorder, throttledRequest(t, "bob", "bobbob", "10.0.0.2"))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("token of the bucket should be given back, got %d", recorder.Code)
+	}
+}
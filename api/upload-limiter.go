@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+// Shapes bandwidth of request bodies of PutObject and PutObjectPart, so a
+// few large uploads won't starve small requests:
+// - BytesPerSecond is shared equally by all active uploads
+// - ConnectionBytesPerSecond limits every single upload
+// - new uploads are rejected with 503 SlowDown if bytes of uploads in
+// flight exceed MaxInflightBytes
+
+// Max bytes read before recalculating rate, so rate adapts quickly
+// when uploads start or finish
+const SHAPING_CHUNK_SIZE = 64 << 10
+
+type UploadLimits struct {
+	BytesPerSecond           int64 // 0 means no limit
+	ConnectionBytesPerSecond int64 // 0 means no limit
+	MaxInflightBytes         int64 // 0 means no limit
+}
+
+type UploadState struct {
+	Limits        UploadLimits
+	ActiveUploads int
+	InflightBytes int64
+	Rejected      uint64 // number of uploads rejected since started
+}
+
+type uploadLimiter struct {
+	lock     sync.Mutex
+	limits   UploadLimits
+	active   int
+	inflight int64
+	rejected uint64
+}
+
+var uploadShaper = &uploadLimiter{}
+
+// Current rate for a single upload, 0 means no limit
+func (l *uploadLimiter) rate() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var rate int64
+	if l.limits.BytesPerSecond > 0 && l.active > 0 {
+		rate = l.limits.BytesPerSecond / int64(l.active)
+		if rate == 0 {
+			rate = 1
+		}
+	}
+	if l.limits.ConnectionBytesPerSecond > 0 &&
+		(rate == 0 || l.limits.ConnectionBytesPerSecond < rate) {
+		rate = l.limits.ConnectionBytesPerSecond
+	}
+	return rate
+}
+
+func (l *uploadLimiter) addInflight(n int64) {
+	l.lock.Lock()
+	l.inflight += n
+	l.lock.Unlock()
+}
+
+// Admit a new upload of `size` bytes. An upload is always admitted if
+// nothing is in flight, so objects larger than MaxInflightBytes could
+// still be uploaded
+func (l *uploadLimiter) admit(size int64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.limits.MaxInflightBytes > 0 && l.inflight > 0 &&
+		l.inflight+size > l.limits.MaxInflightBytes {
+		l.rejected += 1
+		return ErrSlowDown
+	}
+	l.active += 1
+	l.inflight += size
+	return nil
+}
+
+func (l *uploadLimiter) release(inflight int64) {
+	l.lock.Lock()
+	l.active -= 1
+	l.inflight -= inflight
+	l.lock.Unlock()
+}
+
+func (l *uploadLimiter) setLimits(limits UploadLimits) {
+	l.lock.Lock()
+	l.limits = limits
+	l.lock.Unlock()
+}
+
+func (l *uploadLimiter) state() UploadState {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return UploadState{
+		Limits:        l.limits,
+		ActiveUploads: l.active,
+		InflightBytes: l.inflight,
+		Rejected:      l.rejected,
+	}
+}
+
+type shapedReader struct {
+	reader    io.Reader
+	ctx       context.Context
+	limiter   *uploadLimiter
+	countRead bool  // size is unknown, count bytes in flight as they are read
+	read      int64 // bytes counted in flight by reading
+	next      time.Time
+}
+
+func (r *shapedReader) Read(p []byte) (n int, err error) {
+	if len(p) > SHAPING_CHUNK_SIZE {
+		p = p[:SHAPING_CHUNK_SIZE]
+	}
+	n, err = r.reader.Read(p)
+	if n == 0 {
+		return
+	}
+	if r.countRead {
+		r.limiter.addInflight(int64(n))
+		r.read += int64(n)
+	}
+	rate := r.limiter.rate()
+	if rate == 0 {
+		return
+	}
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	r.next = r.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	timer := time.NewTimer(r.next.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.ctx.Done():
+		// client is gone, stop waiting so its share is released
+		return n, r.ctx.Err()
+	}
+	return
+}
+
+// Wrap data of an upload with bandwidth shaping, should be called after
+// signature.VerifyUpload so unauthenticated requests can't take shares of
+// others, and `reader` is the data reader it returns.
+// `release` must be called after the upload finishes.
+func limitUpload(ctx context.Context, reader io.Reader, size int64) (shaped io.Reader,
+	release func(), err error) {
+
+	// size is -1 if unknown, bytes are counted as they are read then
+	reserved := size
+	if reserved < 0 {
+		reserved = 0
+	}
+	err = uploadShaper.admit(reserved)
+	if err != nil {
+		return nil, nil, err
+	}
+	shapedReader := &shapedReader{
+		reader:    reader,
+		ctx:       ctx,
+		limiter:   uploadShaper,
+		countRead: size < 0,
+	}
+	release = func() {
+		uploadShaper.release(reserved + shapedReader.read)
+	}
+	return shapedReader, release, nil
+}
+
+// Reload limits from config, called at startup and on SIGHUP
+func ReloadUploadLimits() {
+	uploadShaper.setLimits(UploadLimits{
+		BytesPerSecond:           int64(helper.CONFIG.UploadBandwidth) << 20,
+		ConnectionBytesPerSecond: int64(helper.CONFIG.UploadConnectionBandwidth) << 20,
+		MaxInflightBytes:         int64(helper.CONFIG.MaxInflightUploadSize) << 20,
+	})
+}
+
+func GetUploadState() UploadState {
+	return uploadShaper.state()
+}
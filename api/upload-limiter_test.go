@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+)
+
+func newUploadData(size int) io.Reader {
+	return bytes.NewReader(make([]byte, size))
+}
+
+func TestUploadInflightLimit(t *testing.T) {
+	defer uploadShaper.setLimits(UploadLimits{})
+	uploadShaper.setLimits(UploadLimits{MaxInflightBytes: 100})
+	rejected := GetUploadState().Rejected
+
+	_, release, err := limitUpload(context.Background(), newUploadData(80), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = limitUpload(context.Background(), newUploadData(80), 80)
+	if err != ErrSlowDown {
+		t.Errorf("expected SlowDown when in-flight bytes exceed limit, got %v", err)
+	}
+	state := GetUploadState()
+	if state.ActiveUploads != 1 || state.InflightBytes != 80 || state.Rejected != rejected+1 {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	release()
+	_, release, err = limitUpload(context.Background(), newUploadData(80), 80)
+	if err != nil {
+		t.Errorf("upload should be admitted after release, got %v", err)
+	} else {
+		release()
+	}
+	if state = GetUploadState(); state.ActiveUploads != 0 || state.InflightBytes != 0 {
+		t.Errorf("all shares should be released, got %+v", state)
+	}
+}
+
+func TestUploadBandwidthShared(t *testing.T) {
+	defer uploadShaper.setLimits(UploadLimits{})
+	uploadShaper.setLimits(UploadLimits{BytesPerSecond: 1 << 20})
+
+	// two uploads share 1MB/s, so reading 256KB takes about 0.5s
+	const size = 256 << 10
+	r1, release1, err := limitUpload(context.Background(), newUploadData(size), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release1()
+	r2, release2, err := limitUpload(context.Background(), newUploadData(size), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release2()
+
+	start := time.Now()
+	done := make(chan error)
+	for _, r := range []io.Reader{r1, r2} {
+		go func(r io.Reader) {
+			_, err := ioutil.ReadAll(r)
+			done <- err
+		}(r)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed < 400*time.Millisecond || elapsed > 800*time.Millisecond {
+		t.Errorf("expected about 500ms to read, took %v", elapsed)
+	}
+}
+
+func TestUploadAborted(t *testing.T) {
+	defer uploadShaper.setLimits(UploadLimits{})
+	uploadShaper.setLimits(UploadLimits{ConnectionBytesPerSecond: 1024})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, release, err := limitUpload(ctx, newUploadData(1<<20), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err = ioutil.ReadAll(r)
+	if err != context.Canceled {
+		t.Errorf("expected read to be canceled, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("read should return soon after canceled, took %v", time.Since(start))
+	}
+	if GetUploadState().InflightBytes == 0 {
+		t.Error("bytes read should be counted in flight if size is unknown")
+	}
+	release()
+	if state := GetUploadState(); state.ActiveUploads != 0 || state.InflightBytes != 0 {
+		t.Errorf("all shares should be released, got %+v", state)
+	}
+}
@@ -21,7 +21,10 @@ import (
 	"encoding/base64"
 	"encoding/xml"
 	"io"
+	"net/http"
 	"strings"
+
+	"github.com/journeymidnight/yig/helper"
 )
 
 // xmlDecoder provide decoded value in xml.
@@ -72,3 +75,23 @@ func contains(stringList []string, element string) bool {
 func requestIdFromContext(ctx context.Context) string {
 	return ctx.Value(RequestId).(string)
 }
+
+// Extract bucket and object name from request, supports both
+// virtual-hosted-style (bucket.S3Domain/object) and path-style
+// (S3Domain/bucket/object) requests, see helper.S3Domains
+// Note request path is never rewritten, since it's used to calculate
+// the canonical URI when verifying V4 signatures.
+func bucketAndObjectFromRequest(r *http.Request) (bucketName, objectName string) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if bucket, ok := helper.BucketFromHost(r.Host); ok {
+		bucketName = bucket
+		objectName = path
+		return
+	}
+	splits := strings.SplitN(path, "/", 2)
+	bucketName = splits[0]
+	if len(splits) == 2 {
+		objectName = splits[1]
+	}
+	return
+}
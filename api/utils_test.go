@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/journeymidnight/yig/helper"
+)
+
+func TestBucketAndObjectFromRequest(t *testing.T) {
+	helper.CONFIG.S3Domain = "s3.test.com"
+	helper.CONFIG.S3DomainAliases = []string{"cdn.s3.test.com"}
+	defer func() { helper.CONFIG.S3DomainAliases = nil }()
+
+	var testcase = []struct {
+		host   string
+		path   string
+		bucket string
+		object string
+	}{
+		{"s3.test.com", "/", "", ""},
+		{"s3.test.com", "/bucket", "bucket", ""},
+		{"s3.test.com", "/bucket/", "bucket", ""},
+		{"s3.test.com:8080", "/bucket/dir/object", "bucket", "dir/object"},
+		{"bucket.s3.test.com", "/", "bucket", ""},
+		{"bucket.s3.test.com", "/object", "bucket", "object"},
+		{"bucket.s3.test.com:8080", "/dir/object", "bucket", "dir/object"},
+		{"my.bucket.S3.test.com", "/dir/", "my.bucket", "dir/"},
+		{"bucket.cdn.s3.test.com", "/object", "bucket", "object"},
+		{"cdn.s3.test.com", "/bucket/object", "bucket", "object"},
+	}
+
+	for _, c := range testcase {
+		r, _ := http.NewRequest("GET", "http://"+c.host+c.path, nil)
+		bucket, object := bucketAndObjectFromRequest(r)
+		if bucket != c.bucket || object != c.object {
+			t.Errorf("%s%s: expected (%q, %q), got (%q, %q)",
+				c.host, c.path, c.bucket, c.object, bucket, object)
+		}
+	}
+}
@@ -33,6 +33,11 @@ const (
 type CircuitClient struct {
 	HttpClient http.Client
 	UrlMap     map[string]*UrlItem
+	lock       sync.Mutex
+	// for circuits of new destinations, defaults are used if not set
+	Threshold    int           // consecutive failures before circuit is closed
+	MaxRetryTime int           // requests let through in halfopen status
+	Interval     time.Duration // how long circuit stays closed
 }
 
 type UrlItem struct {
@@ -52,17 +57,52 @@ func NewCircuitClient() *CircuitClient {
 }
 
 func NewUrlItem() *UrlItem {
+	return newUrlItem(DefaultThreshold, DefaultMaxRetry, time.Duration(DefaultInterval)*time.Second)
+}
+
+func newUrlItem(threshold, maxRetryTime int, interval time.Duration) *UrlItem {
 	urlItem := &UrlItem{
 		Status:       DefaultStatus,
-		Threshold:    DefaultThreshold,
-		MaxRetryTime: DefaultMaxRetry,
-		Interval:     time.Duration(DefaultInterval) * time.Second,
+		Threshold:    threshold,
+		MaxRetryTime: maxRetryTime,
+		Interval:     interval,
 		L:            new(sync.Mutex),
 	}
 	urlItem.RetryChan = make(chan int, urlItem.MaxRetryTime)
 	return urlItem
 }
 
+func (cli *CircuitClient) getUrlItem(dest string) *UrlItem {
+	cli.lock.Lock()
+	defer cli.lock.Unlock()
+	if item, ok := cli.UrlMap[dest]; ok {
+		return item
+	}
+	threshold, maxRetryTime, interval := cli.Threshold, cli.MaxRetryTime, cli.Interval
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	if maxRetryTime <= 0 {
+		maxRetryTime = DefaultMaxRetry
+	}
+	if interval <= 0 {
+		interval = time.Duration(DefaultInterval) * time.Second
+	}
+	item := newUrlItem(threshold, maxRetryTime, interval)
+	cli.UrlMap[dest] = item
+	go checkStatus(item)
+	go exceedRetryHandle(item)
+	return item
+}
+
+// Status of circuit to dest, "open" means requests are let through
+func (cli *CircuitClient) Status(dest string) string {
+	item := cli.getUrlItem(dest)
+	item.L.Lock()
+	defer item.L.Unlock()
+	return item.Status
+}
+
 func (i *UrlItem) Add() {
 	i.L.Lock()
 	i.FailNum += 1
@@ -78,6 +118,20 @@ func (i *UrlItem) Sub() {
 	i.L.Unlock()
 }
 
+// Only consecutive failures close the circuit
+func (i *UrlItem) Reset() {
+	i.L.Lock()
+	if i.Status == "open" {
+		i.FailNum = 0
+	}
+	i.L.Unlock()
+}
+
+// Server errors count as failures as well as those of connections
+func failed(res *http.Response, err error) bool {
+	return err != nil || res.StatusCode >= http.StatusInternalServerError
+}
+
 func (i *UrlItem) SetOpen() {
 	i.RetryTime = 0
 	i.FailNum = 0
@@ -95,36 +149,35 @@ func (i *UrlItem) SetHalfOpen() {
 }
 
 func (cli *CircuitClient) Do(req *http.Request) (res *http.Response, err error) {
-	dest := parseUrl(req)
-	if _, ok := cli.UrlMap[dest]; !ok {
-		cli.UrlMap[dest] = NewUrlItem()
-		go checkStatus(cli.UrlMap[dest])
-		go exceedRetryHandle(cli.UrlMap[dest])
+	item := cli.getUrlItem(parseUrl(req))
+	item.L.Lock()
+	status := item.Status
+	if status == "halfopen" {
+		item.RetryTime += 1
 	}
-	item := cli.UrlMap[dest]
-	if item.Status == "close" {
+	retryTime := item.RetryTime
+	item.L.Unlock()
+	if status == "close" {
 		err = CircuitCloseErr
 		return
 	}
-	if item.Status == "halfopen" {
-		var L *sync.Mutex = new(sync.Mutex)
-		L.Lock()
-		item.RetryTime += 1
-		L.Unlock()
-		if item.RetryTime > item.MaxRetryTime {
+	if status == "halfopen" {
+		if retryTime > item.MaxRetryTime {
 			err = ExceedMaxRetryErr
 			return
 		}
 		res, err = cli.HttpClient.Do(req)
-		if err == nil {
+		if !failed(res, err) {
 			item.Sub()
 		}
 		item.RetryChan <- 1
 		return
 	}
 	res, err = cli.HttpClient.Do(req)
-	if err != nil {
+	if failed(res, err) {
 		item.Add()
+	} else {
+		item.Reset()
 	}
 	return
 }
@@ -136,12 +189,14 @@ func exceedRetryHandle(i *UrlItem) {
 		case tmp = <-i.RetryChan:
 			total += tmp
 			if total >= i.MaxRetryTime {
+				i.L.Lock()
 				if i.FailNum == 0 {
 					i.SetOpen()
 				} else {
-					total = 0
 					i.SetClose()
 				}
+				i.L.Unlock()
+				total = 0
 			}
 		}
 	}
@@ -152,9 +207,11 @@ func checkStatus(i *UrlItem) {
 	for {
 		select {
 		case <-ticker.C:
+			i.L.Lock()
 			if i.Status == "close" {
 				i.SetHalfOpen()
 			}
+			i.L.Unlock()
 		}
 	}
 }
@@ -0,0 +1,71 @@
+package circuitbreak
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitClient(t *testing.T) {
+	var requests, broken int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&broken) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	client := NewCircuitClient()
+	client.Threshold = 3
+	client.MaxRetryTime = 1
+	client.Interval = 100 * time.Millisecond
+	do := func() error {
+		request, _ := http.NewRequest("GET", server.URL, nil)
+		res, err := client.Do(request)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+	dest := parseUrl(httptest.NewRequest("GET", server.URL, nil))
+
+	// failures in between successes don't add up
+	for i := 0; i < 5; i++ {
+		atomic.StoreInt32(&broken, int32(i%2))
+		do()
+	}
+	if status := client.Status(dest); status != "open" {
+		t.Fatalf("circuit should be open, got %s", status)
+	}
+
+	atomic.StoreInt32(&broken, 1)
+	for i := 0; i < 3; i++ {
+		do()
+	}
+	if status := client.Status(dest); status != "close" {
+		t.Fatalf("circuit should be closed after consecutive failures, got %s", status)
+	}
+	sent := atomic.LoadInt32(&requests)
+	if err := do(); err != CircuitCloseErr {
+		t.Errorf("closed circuit should fail fast, got %v", err)
+	}
+	if atomic.LoadInt32(&requests) != sent {
+		t.Error("no request should be sent while circuit is closed")
+	}
+
+	atomic.StoreInt32(&broken, 0)
+	for i := 0; i < 100 && client.Status(dest) != "halfopen"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := do(); err != nil {
+		t.Fatalf("request should be let through in halfopen status, got %v", err)
+	}
+	for i := 0; i < 100 && client.Status(dest) != "open"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := client.Status(dest); status != "open" {
+		t.Errorf("circuit should be open after recovery, got %s", status)
+	}
+}
@@ -124,6 +124,40 @@ const (
 	ErrNonUTF8Encode
         ErrInvalidLc
         ErrNoSuchBucketLc
+	ErrInvalidRebalanceRequest
+	ErrRebalanceQueueFull
+	ErrInvalidStatRequest
+	ErrSlowDown
+	ErrInvalidRateLimitRequest
+	ErrInvalidTtl
+	ErrInvalidEncryptionConfiguration
+	ErrNoSuchBucketEncryption
+	ErrObjectLocked
+	ErrObjectLockNotEnabled
+	ErrNoSuchObjectLockConfiguration
+	ErrInvalidBucketState
+	ErrServiceUnavailable
+	ErrInvalidExpressionType
+	ErrUnsupportedSqlStructure
+	ErrInvalidSelectRequest
+	ErrInvalidMaxObjectSize
+	ErrInvalidCacheTable
+	ErrPresignedExpiresTooLong
+	ErrRequestNotReadyYet
+	ErrInvalidMetadataDirective
+	ErrInvalidPosition
+	ErrPositionNotEqualToLength
+	ErrObjectNotAppendable
+	ErrInvalidStorageClass
+	ErrInvalidRenameSource
+	ErrInvalidMaxBuckets
+	ErrInvalidChecksum
+	ErrBadChecksum
+	ErrInvalidObjectAttributes
+	ErrMalformedACLError
+	ErrSseKeyRotationInProgress
+	ErrEntityTooSmall
+	ErrInvalidObjectState
 )
 
 // error code to APIError structure, these fields carry respective
@@ -532,6 +566,176 @@ var ErrorCodeResponse = map[ApiErrorCode]ApiErrorStruct{
                 Description:    "The LC configuration specified in the request is invalid.",
                 HttpStatusCode: http.StatusBadRequest,
         },
+	ErrInvalidRebalanceRequest: {
+		AwsErrorCode:   "InvalidRequest",
+		Description:    "The rebalance request is malformed or targets an unknown cluster or pool.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrRebalanceQueueFull: {
+		AwsErrorCode:   "SlowDown",
+		Description:    "Too many pending rebalance tasks, please try again later.",
+		HttpStatusCode: http.StatusServiceUnavailable,
+	},
+	ErrInvalidStatRequest: {
+		AwsErrorCode:   "InvalidRequest",
+		Description:    "The stat request is malformed or contains too many objects.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrSlowDown: {
+		AwsErrorCode:   "SlowDown",
+		Description:    "Please reduce your request rate.",
+		HttpStatusCode: http.StatusServiceUnavailable,
+	},
+	ErrInvalidRateLimitRequest: {
+		AwsErrorCode:   "InvalidRequest",
+		Description:    "The rate limit request is malformed or contains negative values.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidTtl: {
+		AwsErrorCode:   "InvalidArgument",
+		Description:    "The x-yig-ttl header is not a number of seconds in allowed range.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidEncryptionConfiguration: {
+		AwsErrorCode:   "MalformedXML",
+		Description:    "The server side encryption configuration is malformed or contains unsupported algorithm.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrNoSuchBucketEncryption: {
+		AwsErrorCode:   "ServerSideEncryptionConfigurationNotFoundError",
+		Description:    "The server side encryption configuration was not found.",
+		HttpStatusCode: http.StatusNotFound,
+	},
+	ErrObjectLocked: {
+		AwsErrorCode:   "AccessDenied",
+		Description:    "Object version is protected by Object Lock retention or legal hold and cannot be deleted, overwritten or have its retention shortened.",
+		HttpStatusCode: http.StatusForbidden,
+	},
+	ErrObjectLockNotEnabled: {
+		AwsErrorCode:   "InvalidRequest",
+		Description:    "Bucket is missing Object Lock Configuration.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrNoSuchObjectLockConfiguration: {
+		AwsErrorCode:   "ObjectLockConfigurationNotFoundError",
+		Description:    "The Object Lock configuration, retention or legal hold does not exist.",
+		HttpStatusCode: http.StatusNotFound,
+	},
+	ErrInvalidBucketState: {
+		AwsErrorCode:   "InvalidBucketState",
+		Description:    "Object Lock requires versioning enabled, and versioning cannot be suspended once Object Lock is enabled.",
+		HttpStatusCode: http.StatusConflict,
+	},
+	ErrServiceUnavailable: {
+		AwsErrorCode:   "ServiceUnavailable",
+		Description:    "The metadata service is temporarily unavailable, please try again later.",
+		HttpStatusCode: http.StatusServiceUnavailable,
+	},
+	ErrInvalidExpressionType: {
+		AwsErrorCode:   "InvalidExpressionType",
+		Description:    "The ExpressionType is invalid. Only SQL expressions are supported.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrUnsupportedSqlStructure: {
+		AwsErrorCode:   "UnsupportedSqlStructure",
+		Description:    "Encountered an unsupported SQL structure. Check the SQL Reference.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidSelectRequest: {
+		AwsErrorCode:   "InvalidRequest",
+		Description:    "The input or output serialization of select request is invalid or not supported.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidMaxObjectSize: {
+		AwsErrorCode:   "InvalidArgument",
+		Description:    "The max object size is malformed or negative.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidCacheTable: {
+		AwsErrorCode:   "InvalidArgument",
+		Description:    "The specified cache table does not exist.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrPresignedExpiresTooLong: {
+		AwsErrorCode:   "AuthorizationQueryParametersError",
+		Description:    "X-Amz-Expires exceeds the maximum allowed by this server.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrRequestNotReadyYet: {
+		AwsErrorCode:   "AccessDenied",
+		Description:    "Request is not valid yet",
+		HttpStatusCode: http.StatusForbidden,
+	},
+	ErrInvalidMetadataDirective: {
+		AwsErrorCode:   "InvalidArgument",
+		Description:    "Unknown metadata directive.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidPosition: {
+		AwsErrorCode:   "InvalidArgument",
+		Description:    "Position should be a non-negative integer.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrPositionNotEqualToLength: {
+		AwsErrorCode:   "PositionNotEqualToLength",
+		Description:    "Position is not equal to the length of the object.",
+		HttpStatusCode: http.StatusConflict,
+	},
+	ErrObjectNotAppendable: {
+		AwsErrorCode:   "ObjectNotAppendable",
+		Description:    "The object is not appendable.",
+		HttpStatusCode: http.StatusConflict,
+	},
+	ErrInvalidStorageClass: {
+		AwsErrorCode:   "InvalidStorageClass",
+		Description:    "The storage class you specified is not valid.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidRenameSource: {
+		AwsErrorCode:   "InvalidArgument",
+		Description:    "Rename source should be an object in the same bucket: /bucket/key.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidMaxBuckets: {
+		AwsErrorCode:   "InvalidArgument",
+		Description:    "Argument max-buckets must be an integer between 1 and 10000",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidChecksum: {
+		AwsErrorCode:   "InvalidRequest",
+		Description:    "Value for x-amz-checksum-* header is invalid, or more than one checksum is set.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrBadChecksum: {
+		AwsErrorCode:   "BadDigest",
+		Description:    "The checksum you specified did not match what we received.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidObjectAttributes: {
+		AwsErrorCode:   "InvalidArgument",
+		Description:    "x-amz-object-attributes should list some of ETag, Checksum, ObjectParts, StorageClass and ObjectSize.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrMalformedACLError: {
+		AwsErrorCode:   "MalformedACLError",
+		Description:    "The XML you provided was not well-formed or did not validate against our published schema.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrSseKeyRotationInProgress: {
+		AwsErrorCode:   "OperationAborted",
+		Description:    "SSE-S3 master key rotation is already in progress.",
+		HttpStatusCode: http.StatusConflict,
+	},
+	ErrEntityTooSmall: {
+		AwsErrorCode:   "EntityTooSmall",
+		Description:    "Your proposed upload is smaller than the minimum allowed object size.",
+		HttpStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidObjectState: {
+		AwsErrorCode:   "InvalidObjectState",
+		Description:    "The operation is not valid for the object's storage class.",
+		HttpStatusCode: http.StatusForbidden,
+	},
 }
 
 func (e ApiErrorCode) AwsErrorCode() string {
@@ -561,3 +765,34 @@ func (e ApiErrorCode) HttpStatusCode() int {
 	}
 	return awsError.HttpStatusCode
 }
+
+// SignatureDoesNotMatch with what was signed and what was provided, returned
+// for unknown access keys as well so the two can't be told apart
+type SignatureMismatch struct {
+	StringToSign      string
+	SignatureProvided string
+}
+
+func (e SignatureMismatch) AwsErrorCode() string {
+	return ErrSignatureDoesNotMatch.AwsErrorCode()
+}
+
+func (e SignatureMismatch) Description() string {
+	return ErrSignatureDoesNotMatch.Description()
+}
+
+func (e SignatureMismatch) Error() string {
+	return e.Description()
+}
+
+func (e SignatureMismatch) HttpStatusCode() int {
+	return ErrSignatureDoesNotMatch.HttpStatusCode()
+}
+
+// Whether err is SignatureDoesNotMatch, with or without details
+func IsSignatureMismatch(err error) bool {
+	if _, ok := err.(SignatureMismatch); ok {
+		return true
+	}
+	return err == ErrSignatureDoesNotMatch
+}
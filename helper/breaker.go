@@ -0,0 +1,77 @@
+package helper
+
+import (
+	"sync"
+	"time"
+)
+
+// Max time to bypass a backend before trying it again
+const MAX_RETRY_INTERVAL = time.Minute
+
+// Bypass a backend after `threshold` consecutive failures, so requests fail
+// or fall back immediately instead of waiting for timeouts. After `interval`
+// one request is let through to probe the backend, interval is doubled each
+// time the probe fails, up to MAX_RETRY_INTERVAL.
+type CircuitBreaker struct {
+	lock      sync.Mutex
+	threshold int
+	interval  time.Duration
+	failures  int
+	backoff   time.Duration // current interval to bypass
+	openUntil time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, interval time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		interval:  interval,
+		backoff:   interval,
+		now:       time.Now,
+	}
+}
+
+// Whether a request should be sent to the backend
+func (b *CircuitBreaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *CircuitBreaker) Record(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	wasProbing := b.probing
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		b.backoff = b.interval
+		return
+	}
+	b.failures += 1
+	if b.failures < b.threshold {
+		return
+	}
+	if wasProbing {
+		b.backoff *= 2
+		if b.backoff > MAX_RETRY_INTERVAL {
+			b.backoff = MAX_RETRY_INTERVAL
+		}
+	}
+	b.openUntil = b.now().Add(b.backoff)
+}
+
+// Whether the backend is considered reachable
+func (b *CircuitBreaker) Closed() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.failures < b.threshold
+}
@@ -0,0 +1,43 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(3, time.Second)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatal("should allow before threshold reached")
+		}
+		b.Record(true)
+	}
+	if b.Allow() || b.Closed() {
+		t.Fatal("should bypass after consecutive failures")
+	}
+
+	now = now.Add(time.Second)
+	if !b.Allow() {
+		t.Fatal("should probe after retry interval")
+	}
+	if b.Allow() {
+		t.Fatal("only one probe at a time")
+	}
+	b.Record(true)
+	now = now.Add(time.Second)
+	if b.Allow() {
+		t.Fatal("retry interval should be doubled after failed probe")
+	}
+	now = now.Add(time.Second)
+	if !b.Allow() {
+		t.Fatal("should probe after doubled interval")
+	}
+	b.Record(false)
+	if !b.Closed() || !b.Allow() {
+		t.Fatal("should be closed after successful probe")
+	}
+}
@@ -7,13 +7,19 @@ import (
 )
 
 type Config struct {
-	S3Domain                   string // Domain name of YIG
-	Region                     string // Region name this instance belongs to, e.g cn-bj-1
-	IamEndpoint                string // le IAM endpoint address
+	S3Domain                   string   // Domain name of YIG
+	Region                     string   // Region name this instance belongs to, e.g cn-bj-1
+	RegionalS3Domain           bool     // also serve S3Domain with Region after its first label, e.g s3.cn-bj-1.test.com
+	S3DomainAliases            []string // other domain names CNAMEd to S3Domain, serving the same buckets
+	IamEndpoint                string   // le IAM endpoint address
 	IamKey                     string
 	IamSecret                  string
 	LogPath                    string
 	PanicLogPath               string
+	LogMaxSize                 int // in MB, rotate log file when its size exceeds
+	LogMaxFiles                int // number of rotated log files to keep
+	LogRotateInterval          time.Duration
+	LogCompress                bool // gzip rotated log files
 	PidFile                    string
 	BindApiAddress             string
 	BindAdminAddress           string
@@ -23,11 +29,21 @@ type Config struct {
 	RedisAddress               string // redis connection string, e.g localhost:1234
 	RedisConnectionNumber      int    // number of connections to redis(i.e max concurrent request number)
 	RedisPassword              string // redis auth passowrd
+	RedisSentinelMasterName    string // use Redis Sentinel to find master if set
+	RedisSentinelAddresses     string // comma separated, e.g. 10.0.0.1:26379,10.0.0.2:26379
+	RedisClusterAddresses      string // comma separated seed nodes, use Redis Cluster if set
+	RedisTimeout               time.Duration
+	RedisFailureThreshold      int // bypass Redis after this many consecutive failures
+	RedisRetryInterval         time.Duration
 	InMemoryCacheMaxEntryCount int
 	InstanceId                 string // if empty, generated one at server startup
 	ConcurrentRequestLimit     int
 	HbaseZnodeParent           string // won't change default("/hbase") if leave this option empty
 	HbaseTimeout               time.Duration
+	HbaseRetryCount            int // retries of idempotent HBase requests
+	HbaseRetryBackoff          time.Duration
+	HbaseFailureThreshold      int // fail fast after this many consecutive failures of a table
+	HbaseRetryInterval         time.Duration
 	DebugMode                  bool
 	AdminKey                   string //used for tools/admin to communicate with yig
 	GcThread                   int
@@ -41,16 +57,74 @@ type Config struct {
 	MetaStore                  string
 	TidbInfo                   string
 	KeepAlive                  bool
+	RebalanceBandwidth         int     // in MB/s, limit data copied by admin rebalance tasks
+	RateLimitRequests          float64 // requests per second for each access key(or IP if anonymous), 0 means no limit
+	RateLimitBurst             int
+	BucketRateLimitRequests    float64 // requests per second for each bucket, 0 means no limit
+	BucketRateLimitBurst       int
+	RateLimitCacheSize         int // max number of access keys, IPs and buckets tracked
+	ObjectTtlMin               int // in seconds, allowed range of "x-yig-ttl" header
+	ObjectTtlMax               int
+	UploadBandwidth            int  // in MB/s, shared by all uploads, 0 means no limit
+	UploadConnectionBandwidth  int  // in MB/s, for each upload, 0 means no limit
+	MaxInflightUploadSize      int  // in MB, reject new uploads with 503 if exceeded, 0 means no limit
+	NegativeCacheTTL           int  // in seconds, how long missing objects are cached, negative to disable
+	MaxObjectSizeForCache      int  // in KB, larger objects bypass data cache
+	StrictObjectRowkey         bool // ignore object rows whose keys only share a prefix with the requested one
+	MaxPresignedExpiry         time.Duration
+	CephWriteChunkSize         int // in KB, big objects are written to Ceph in chunks of this size
+	CephWriteQueueDepth        int // max async writes in flight for each upload
+	EnableDualWrite            bool
+	DualWriteFailurePolicy     string
+	DownloadPrefetchParts      int // parts of multipart objects read ahead for each download
+	HealthCheckTimeout         time.Duration
+	GcCheckpointPath           string
+	StorageClassPools          map[string]string // storage classes other than STANDARD, and Ceph pools to store them
+	SseS3MasterKey             string            // 32 bytes, data keys of SSE-S3 objects are encrypted with it
+	SseS3PreviousKeys          []string          // master keys rotated out, still tried when decrypting data keys
+	AuthFailureThreshold       int               // signature failures of an access key from one IP before denied, 0 disables tracking
+	AuthFailureWindow          time.Duration
+	AuthFailureDelay           time.Duration
+	AuthFailureMaxDelay        time.Duration
+	AuthFailureCacheSize       int // max number of access key and IP pairs tracked
+	IamCacheTTL                time.Duration
+	IamNegativeCacheTTL        time.Duration
+	DisableIamCache            bool
+	IamCacheStaleTTL           time.Duration
+	IamBreakerThreshold        int
+	IamBreakerMaxRetry         int
+	IamBreakerInterval         time.Duration
+	IamBackend                 string
+	IamCredentialFile          string
+	IamCredentials             []IamCredential
+	DebugLogPath               string
+	AdminAccessKeys            []string
+	DownloadPrefetchBufferSize int // in KB, data of each part read ahead buffered before its reading blocks
+	DisableResponseCompression bool
+}
+
+// Credential of a user of the local IAM backend
+type IamCredential struct {
+	AccessKey   string
+	SecretKey   string
+	UserId      string
+	DisplayName string // same as UserId if not set
 }
 
 type config struct {
-	S3Domain                   string // Domain name of YIG
-	Region                     string // Region name this instance belongs to, e.g cn-bj-1
-	IamEndpoint                string // le IAM endpoint address
+	S3Domain                   string   // Domain name of YIG
+	Region                     string   // Region name this instance belongs to, e.g cn-bj-1
+	RegionalS3Domain           bool     // also serve S3Domain with Region after its first label, e.g s3.cn-bj-1.test.com
+	S3DomainAliases            []string // other domain names CNAMEd to S3Domain, serving the same buckets
+	IamEndpoint                string   // le IAM endpoint address
 	IamKey                     string
 	IamSecret                  string
 	LogPath                    string
 	PanicLogPath               string
+	LogMaxSize                 int  // in MB, rotate log file when its size exceeds
+	LogMaxFiles                int  // number of rotated log files to keep
+	LogRotateInterval          int  // in hours, rotate log file periodically if set
+	LogCompress                bool // gzip rotated log files
 	PidFile                    string
 	BindApiAddress             string
 	BindAdminAddress           string
@@ -60,11 +134,21 @@ type config struct {
 	RedisAddress               string // redis connection string, e.g localhost:1234
 	RedisConnectionNumber      int    // number of connections to redis(i.e max concurrent request number)
 	RedisPassword              string // redis auth passowrd
+	RedisSentinelMasterName    string // use Redis Sentinel to find master if set
+	RedisSentinelAddresses     string // comma separated, e.g. 10.0.0.1:26379,10.0.0.2:26379
+	RedisClusterAddresses      string // comma separated seed nodes, use Redis Cluster if set
+	RedisTimeout               int    // in milliseconds
+	RedisFailureThreshold      int    // bypass Redis after this many consecutive failures
+	RedisRetryInterval         int    // in seconds, doubled on each failed retry, up to 1 minute
 	InMemoryCacheMaxEntryCount int
 	InstanceId                 string // if empty, generated one at server startup
 	ConcurrentRequestLimit     int
 	HbaseZnodeParent           string // won't change default("/hbase") if leave this option empty
 	HbaseTimeout               int    // in seconds
+	HbaseRetryCount            int    // retries of idempotent HBase requests
+	HbaseRetryBackoff          int    // in milliseconds, doubled on each retry
+	HbaseFailureThreshold      int    // fail fast after this many consecutive failures of a table
+	HbaseRetryInterval         int    // in seconds, doubled on each failed retry, up to 1 minute
 	DebugMode                  bool
 	AdminKey                   string //used for tools/admin to communicate with yig
 	GcThread                   int
@@ -78,6 +162,50 @@ type config struct {
 	MetaStore                  string
 	TidbInfo                   string
 	KeepAlive                  bool
+	RebalanceBandwidth         int     // in MB/s, limit data copied by admin rebalance tasks
+	RateLimitRequests          float64 // requests per second for each access key(or IP if anonymous), 0 means no limit
+	RateLimitBurst             int
+	BucketRateLimitRequests    float64 // requests per second for each bucket, 0 means no limit
+	BucketRateLimitBurst       int
+	RateLimitCacheSize         int // max number of access keys, IPs and buckets tracked
+	ObjectTtlMin               int // in seconds, allowed range of "x-yig-ttl" header
+	ObjectTtlMax               int
+	UploadBandwidth            int               // in MB/s, shared by all uploads, 0 means no limit
+	UploadConnectionBandwidth  int               // in MB/s, for each upload, 0 means no limit
+	MaxInflightUploadSize      int               // in MB, reject new uploads with 503 if exceeded, 0 means no limit
+	NegativeCacheTTL           int               // in seconds, how long missing objects are cached, negative to disable
+	MaxObjectSizeForCache      int               // in KB, larger objects bypass data cache
+	StrictObjectRowkey         bool              // ignore object rows whose keys only share a prefix with the requested one
+	MaxPresignedExpiry         int               // in seconds, max X-Amz-Expires of presigned URLs, up to 7 days
+	CephWriteChunkSize         int               // in KB, big objects are written to Ceph in chunks of this size
+	CephWriteQueueDepth        int               // max async writes in flight for each upload
+	EnableDualWrite            bool              // write big objects to two Ceph clusters at the same time
+	DualWriteFailurePolicy     string            // "single" to keep the copy written if the other fails, "fail" to fail the upload
+	DownloadPrefetchParts      int               // parts of multipart objects read ahead for each download, negative to disable
+	HealthCheckTimeout         int               // in milliseconds, for each dependency checked by readiness probe
+	GcCheckpointPath           string            // used for tools/delete only, where to resume scanning garbage collection table from after restarts
+	StorageClassPools          map[string]string // storage classes other than STANDARD, and Ceph pools to store them, e.g. {"STANDARD_IA": "rabbit-ia"}
+	SseS3MasterKey             string            // 32 bytes, built-in key is used if empty
+	SseS3PreviousKeys          []string          // 32 bytes each, tried in order if data key of an object fails to decrypt with SseS3MasterKey
+	AuthFailureThreshold       int               // signature failures of an access key from one IP within AuthFailureWindow before requests are denied, negative to disable
+	AuthFailureWindow          int               // in seconds
+	AuthFailureDelay           int               // in milliseconds, for the first failure, doubled for each one afterwards
+	AuthFailureMaxDelay        int               // in milliseconds
+	AuthFailureCacheSize       int               // max number of access key and IP pairs tracked
+	IamCacheTTL                int               // in seconds, how long credentials from IAM are cached
+	IamNegativeCacheTTL        int               // in seconds, how long unknown access keys are cached
+	DisableIamCache            bool              // query IAM for every request
+	IamCacheStaleTTL           int               // in seconds, how long expired credentials are used if IAM fails
+	IamBreakerThreshold        int               // consecutive failures of IAM before it's not queried for a while
+	IamBreakerMaxRetry         int               // queries let through to check if IAM recovers
+	IamBreakerInterval         int               // in seconds, how long IAM is not queried after failures
+	IamBackend                 string            // "remote" to query IamEndpoint, or "local" to use IamCredentialFile and IamCredentials
+	IamCredentialFile          string            // JSON array of credentials for local IAM backend, reloaded on SIGHUP
+	IamCredentials             []IamCredential   // credentials for local IAM backend besides those in IamCredentialFile
+	DebugLogPath               string            // debug logs of requests with "x-yig-debug: true", LogPath is used if empty
+	AdminAccessKeys            []string          // access keys allowed to turn on debug logs of their requests
+	DownloadPrefetchBufferSize int               // in KB, data buffered for each part read ahead before its reading blocks, rounded up to 512KB
+	DisableResponseCompression bool              // never gzip XML responses, for clients mishandling Content-Encoding
 }
 
 var CONFIG Config
@@ -98,11 +226,17 @@ func SetupConfig() {
 	// setup CONFIG with defaults
 	CONFIG.S3Domain = c.S3Domain
 	CONFIG.Region = c.Region
+	CONFIG.RegionalS3Domain = c.RegionalS3Domain
+	CONFIG.S3DomainAliases = c.S3DomainAliases
 	CONFIG.IamEndpoint = c.IamEndpoint
 	CONFIG.IamKey = c.IamKey
 	CONFIG.IamSecret = c.IamSecret
 	CONFIG.LogPath = c.LogPath
 	CONFIG.PanicLogPath = c.PanicLogPath
+	CONFIG.LogMaxSize = Ternary(c.LogMaxSize == 0, 512, c.LogMaxSize).(int)
+	CONFIG.LogMaxFiles = Ternary(c.LogMaxFiles == 0, 10, c.LogMaxFiles).(int)
+	CONFIG.LogRotateInterval = time.Duration(c.LogRotateInterval) * time.Hour
+	CONFIG.LogCompress = c.LogCompress
 	CONFIG.PidFile = c.PidFile
 	CONFIG.BindApiAddress = c.BindApiAddress
 	CONFIG.BindAdminAddress = c.BindAdminAddress
@@ -115,6 +249,15 @@ func SetupConfig() {
 	CONFIG.RedisConnectionNumber = Ternary(c.RedisConnectionNumber == 0,
 		10, c.RedisConnectionNumber).(int)
 	CONFIG.RedisPassword = c.RedisPassword
+	CONFIG.RedisSentinelMasterName = c.RedisSentinelMasterName
+	CONFIG.RedisSentinelAddresses = c.RedisSentinelAddresses
+	CONFIG.RedisClusterAddresses = c.RedisClusterAddresses
+	CONFIG.RedisTimeout = Ternary(c.RedisTimeout == 0, time.Second,
+		time.Duration(c.RedisTimeout)*time.Millisecond).(time.Duration)
+	CONFIG.RedisFailureThreshold = Ternary(c.RedisFailureThreshold == 0,
+		5, c.RedisFailureThreshold).(int)
+	CONFIG.RedisRetryInterval = Ternary(c.RedisRetryInterval == 0, time.Second,
+		time.Duration(c.RedisRetryInterval)*time.Second).(time.Duration)
 	CONFIG.InMemoryCacheMaxEntryCount = Ternary(c.InMemoryCacheMaxEntryCount == 0,
 		100000, c.InMemoryCacheMaxEntryCount).(int)
 	CONFIG.InstanceId = Ternary(c.InstanceId == "",
@@ -125,6 +268,14 @@ func SetupConfig() {
 		"/hbase", c.HbaseZnodeParent).(string)
 	CONFIG.HbaseTimeout = Ternary(c.HbaseTimeout == 0, 30*time.Second,
 		time.Duration(c.HbaseTimeout)*time.Second).(time.Duration)
+	CONFIG.HbaseRetryCount = Ternary(c.HbaseRetryCount == 0,
+		2, c.HbaseRetryCount).(int)
+	CONFIG.HbaseRetryBackoff = Ternary(c.HbaseRetryBackoff == 0, 100*time.Millisecond,
+		time.Duration(c.HbaseRetryBackoff)*time.Millisecond).(time.Duration)
+	CONFIG.HbaseFailureThreshold = Ternary(c.HbaseFailureThreshold == 0,
+		5, c.HbaseFailureThreshold).(int)
+	CONFIG.HbaseRetryInterval = Ternary(c.HbaseRetryInterval == 0, time.Second,
+		time.Duration(c.HbaseRetryInterval)*time.Second).(time.Duration)
 	CONFIG.DebugMode = c.DebugMode
 	CONFIG.AdminKey = c.AdminKey
 	CONFIG.GcThread = Ternary(c.GcThread == 0,
@@ -138,4 +289,81 @@ func SetupConfig() {
 	CONFIG.MetaStore = Ternary(c.MetaStore == "", "hbase", c.MetaStore).(string)
 	CONFIG.TidbInfo = c.TidbInfo
 	CONFIG.KeepAlive = c.KeepAlive
+	CONFIG.RebalanceBandwidth = Ternary(c.RebalanceBandwidth == 0,
+		50, c.RebalanceBandwidth).(int)
+	CONFIG.RateLimitRequests = c.RateLimitRequests
+	CONFIG.RateLimitBurst = Ternary(c.RateLimitBurst == 0,
+		int(c.RateLimitRequests), c.RateLimitBurst).(int)
+	CONFIG.BucketRateLimitRequests = c.BucketRateLimitRequests
+	CONFIG.BucketRateLimitBurst = Ternary(c.BucketRateLimitBurst == 0,
+		int(c.BucketRateLimitRequests), c.BucketRateLimitBurst).(int)
+	CONFIG.RateLimitCacheSize = Ternary(c.RateLimitCacheSize == 0,
+		100000, c.RateLimitCacheSize).(int)
+	CONFIG.ObjectTtlMin = Ternary(c.ObjectTtlMin == 0, 1, c.ObjectTtlMin).(int)
+	CONFIG.ObjectTtlMax = Ternary(c.ObjectTtlMax == 0,
+		365*24*3600, c.ObjectTtlMax).(int)
+	CONFIG.UploadBandwidth = c.UploadBandwidth
+	CONFIG.UploadConnectionBandwidth = c.UploadConnectionBandwidth
+	CONFIG.MaxInflightUploadSize = c.MaxInflightUploadSize
+	CONFIG.NegativeCacheTTL = Ternary(c.NegativeCacheTTL == 0, 10, c.NegativeCacheTTL).(int)
+	CONFIG.MaxObjectSizeForCache = Ternary(c.MaxObjectSizeForCache == 0,
+		4096, c.MaxObjectSizeForCache).(int)
+	CONFIG.StrictObjectRowkey = c.StrictObjectRowkey
+	CONFIG.MaxPresignedExpiry = Ternary(c.MaxPresignedExpiry <= 0 || c.MaxPresignedExpiry > 7*24*3600,
+		7*24*time.Hour, time.Duration(c.MaxPresignedExpiry)*time.Second).(time.Duration)
+	CONFIG.CephWriteChunkSize = Ternary(c.CephWriteChunkSize <= 0,
+		4096, c.CephWriteChunkSize).(int)
+	CONFIG.CephWriteQueueDepth = Ternary(c.CephWriteQueueDepth <= 0,
+		4, c.CephWriteQueueDepth).(int)
+	CONFIG.EnableDualWrite = c.EnableDualWrite
+	CONFIG.DualWriteFailurePolicy = Ternary(c.DualWriteFailurePolicy == "",
+		"single", c.DualWriteFailurePolicy).(string)
+	CONFIG.DownloadPrefetchParts = Ternary(c.DownloadPrefetchParts == 0,
+		2, c.DownloadPrefetchParts).(int)
+	CONFIG.DownloadPrefetchBufferSize = Ternary(c.DownloadPrefetchBufferSize <= 0,
+		4096, c.DownloadPrefetchBufferSize).(int)
+	CONFIG.DisableResponseCompression = c.DisableResponseCompression
+	CONFIG.HealthCheckTimeout = Ternary(c.HealthCheckTimeout <= 0, 2*time.Second,
+		time.Duration(c.HealthCheckTimeout)*time.Millisecond).(time.Duration)
+	CONFIG.GcCheckpointPath = Ternary(c.GcCheckpointPath == "",
+		"delete.checkpoint", c.GcCheckpointPath).(string)
+	CONFIG.StorageClassPools = c.StorageClassPools
+	if c.SseS3MasterKey != "" && len(c.SseS3MasterKey) != 32 {
+		panic("SseS3MasterKey should be 32 bytes")
+	}
+	for _, key := range c.SseS3PreviousKeys {
+		if len(key) != 32 {
+			panic("SseS3PreviousKeys should be 32 bytes each")
+		}
+	}
+	CONFIG.SseS3MasterKey = c.SseS3MasterKey
+	CONFIG.SseS3PreviousKeys = c.SseS3PreviousKeys
+	CONFIG.AuthFailureThreshold = Ternary(c.AuthFailureThreshold == 0,
+		10, c.AuthFailureThreshold).(int)
+	CONFIG.AuthFailureWindow = Ternary(c.AuthFailureWindow <= 0, 5*time.Minute,
+		time.Duration(c.AuthFailureWindow)*time.Second).(time.Duration)
+	CONFIG.AuthFailureDelay = Ternary(c.AuthFailureDelay <= 0, 100*time.Millisecond,
+		time.Duration(c.AuthFailureDelay)*time.Millisecond).(time.Duration)
+	CONFIG.AuthFailureMaxDelay = Ternary(c.AuthFailureMaxDelay <= 0, 5*time.Second,
+		time.Duration(c.AuthFailureMaxDelay)*time.Millisecond).(time.Duration)
+	CONFIG.AuthFailureCacheSize = Ternary(c.AuthFailureCacheSize <= 0,
+		100000, c.AuthFailureCacheSize).(int)
+	CONFIG.IamCacheTTL = Ternary(c.IamCacheTTL <= 0, 5*time.Minute,
+		time.Duration(c.IamCacheTTL)*time.Second).(time.Duration)
+	CONFIG.IamNegativeCacheTTL = Ternary(c.IamNegativeCacheTTL <= 0, 30*time.Second,
+		time.Duration(c.IamNegativeCacheTTL)*time.Second).(time.Duration)
+	CONFIG.DisableIamCache = c.DisableIamCache
+	CONFIG.IamCacheStaleTTL = Ternary(c.IamCacheStaleTTL <= 0, 10*time.Minute,
+		time.Duration(c.IamCacheStaleTTL)*time.Second).(time.Duration)
+	CONFIG.IamBreakerThreshold = Ternary(c.IamBreakerThreshold <= 0, 10,
+		c.IamBreakerThreshold).(int)
+	CONFIG.IamBreakerMaxRetry = Ternary(c.IamBreakerMaxRetry <= 0, 3,
+		c.IamBreakerMaxRetry).(int)
+	CONFIG.IamBreakerInterval = Ternary(c.IamBreakerInterval <= 0, 30*time.Second,
+		time.Duration(c.IamBreakerInterval)*time.Second).(time.Duration)
+	CONFIG.IamBackend = Ternary(c.IamBackend == "", "remote", c.IamBackend).(string)
+	CONFIG.IamCredentialFile = c.IamCredentialFile
+	CONFIG.IamCredentials = c.IamCredentials
+	CONFIG.DebugLogPath = c.DebugLogPath
+	CONFIG.AdminAccessKeys = c.AdminAccessKeys
 }
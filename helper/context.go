@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type contextKey int
+
+// Keys of values carried by request contexts
+const (
+	RequestIdKey contextKey = iota
+	DebugLoggingKey
+	VerifiedKeyCheckKey
+)
+
+// ID of the request `ctx` belongs to, empty if `ctx` is not from a request
+func RequestIdFromContext(ctx context.Context) string {
+	requestId, _ := ctx.Value(RequestIdKey).(string)
+	return requestId
+}
+
+// Log errors with ID of the request that causes them, `ctx` is the one
+// passed down from API handlers
+func LogWithContext(ctx context.Context, format string, args ...interface{}) {
+	Logger.Printf(5, "[%s] "+format+"\n",
+		append([]interface{}{RequestIdFromContext(ctx)}, args...)...)
+}
+
+// Set on requests asking for debug logs, which are only written after the
+// request is authenticated by one of CONFIG.AdminAccessKeys
+type debugLogging struct {
+	enabled int32
+}
+
+func WithDebugLogging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, DebugLoggingKey, new(debugLogging))
+}
+
+// Turn on debug logs of the request `ctx` belongs to if they are asked for
+// and accessKey is an admin's
+func EnableDebugLogging(ctx context.Context, accessKey string) {
+	flag, ok := ctx.Value(DebugLoggingKey).(*debugLogging)
+	if !ok {
+		return
+	}
+	for _, key := range CONFIG.AdminAccessKeys {
+		if key == accessKey {
+			atomic.StoreInt32(&flag.enabled, 1)
+			return
+		}
+	}
+}
+
+func DebugLoggingFromContext(ctx context.Context) bool {
+	flag, ok := ctx.Value(DebugLoggingKey).(*debugLogging)
+	return ok && atomic.LoadInt32(&flag.enabled) != 0
+}
+
+// Set on requests whose access keys should be checked once verified, e.g.
+// to rate limit them. The request is denied if the check returns an error
+func WithVerifiedKeyCheck(ctx context.Context, check func(accessKey string) error) context.Context {
+	return context.WithValue(ctx, VerifiedKeyCheckKey, check)
+}
+
+// Run the check of the request `ctx` belongs to, after its signature is
+// verified to be of accessKey
+func CheckVerifiedKey(ctx context.Context, accessKey string) error {
+	check, ok := ctx.Value(VerifiedKeyCheckKey).(func(accessKey string) error)
+	if !ok {
+		return nil
+	}
+	return check(accessKey)
+}
@@ -0,0 +1,29 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/journeymidnight/yig/log"
+)
+
+func TestLogWithContext(t *testing.T) {
+	var buf bytes.Buffer
+	Logger = log.New(&buf, "[yig]", log.LstdFlags, 5)
+	ctx := context.WithValue(context.Background(), RequestIdKey, "hehe-request")
+	LogWithContext(ctx, "Error get bucket: %s, with error %v", "bucket", errors.New("NoSuchBucket"))
+	if line := buf.String(); !strings.Contains(line,
+		"[hehe-request] Error get bucket: bucket, with error") {
+
+		t.Errorf("request ID is not logged: %s", line)
+	}
+
+	buf.Reset()
+	LogWithContext(context.Background(), "Error deleting: %v", errors.New("NoSuchBucket"))
+	if !strings.Contains(buf.String(), "[] Error deleting") {
+		t.Errorf("unexpected log without request: %s", buf.String())
+	}
+}
@@ -1,5 +1,7 @@
 package helper
 
+import "context"
+
 func Debug(format string, args ...interface{}) {
 	if CONFIG.DebugMode == true {
 		Logger.Printf(0, format, args...)
@@ -11,3 +13,20 @@ func Debugln(args ...interface{}) {
 		Logger.Println(0, args...)
 	}
 }
+
+// Same as Debugln, and also logs to DebugLogger for requests with debug
+// logging enabled, see EnableDebugLogging
+func DebuglnContext(ctx context.Context, args ...interface{}) {
+	if CONFIG.DebugMode == true {
+		Logger.Println(0, args...)
+		return
+	}
+	if !DebugLoggingFromContext(ctx) {
+		return
+	}
+	logger := DebugLogger
+	if logger == nil {
+		logger = Logger
+	}
+	logger.Println(0, append([]interface{}{"[" + RequestIdFromContext(ctx) + "]"}, args...)...)
+}
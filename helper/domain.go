@@ -0,0 +1,57 @@
+package helper
+
+import (
+	"sort"
+	"strings"
+)
+
+// Domain names requests could be addressed to, S3Domain and if
+// RegionalS3Domain is set, its regional form with Region inserted after
+// the first label, i.e. s3.test.com and s3.cn-bj-1.test.com, followed by
+// S3DomainAliases.
+// Longer domains come first, so if an alias is a subdomain of another
+// domain, e.g. cdn.test.com of test.com, bucket.cdn.test.com is matched
+// against the alias rather than taken as bucket "bucket.cdn"
+func S3Domains() []string {
+	if CONFIG.S3Domain == "" {
+		return nil
+	}
+	domains := []string{CONFIG.S3Domain}
+	if CONFIG.RegionalS3Domain && CONFIG.Region != "" {
+		labels := strings.SplitN(CONFIG.S3Domain, ".", 2)
+		regional := labels[0] + "." + CONFIG.Region
+		if len(labels) == 2 {
+			regional += "." + labels[1]
+		}
+		domains = append(domains, regional)
+	}
+	for _, alias := range CONFIG.S3DomainAliases {
+		if alias != "" {
+			domains = append(domains, alias)
+		}
+	}
+	sort.SliceStable(domains, func(i, j int) bool {
+		return len(domains[i]) > len(domains[j])
+	})
+	return domains
+}
+
+// Bucket name of a virtual-hosted-style request addressed to
+// bucket.<one of S3Domains>, `host` could have port. Bucket names could
+// contain dots, i.e. my.bucket.s3.test.com is bucket "my.bucket".
+// ok is false if `host` is not a subdomain of S3Domains, i.e. the request
+// is path-style
+func BucketFromHost(host string) (bucket string, ok bool) {
+	host = strings.ToLower(strings.Split(host, ":")[0])
+	for _, domain := range S3Domains() {
+		domain = strings.ToLower(domain)
+		if host == domain {
+			return "", false
+		}
+		suffix := "." + domain
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return strings.TrimSuffix(host, suffix), true
+		}
+	}
+	return "", false
+}
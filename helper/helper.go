@@ -2,6 +2,8 @@ package helper
 
 import (
 	"math/rand"
+	"net"
+	"net/http"
 	"reflect"
 )
 
@@ -39,3 +41,12 @@ func GenerateRandomId() []byte {
 	}
 	return alpha
 }
+
+// Address of the peer sending `r`, without port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
@@ -30,6 +30,13 @@ import (
 
 var Logger *log.Logger
 
+// PanicLogger writes stack traces of recovered panics, to PanicLogPath
+var PanicLogger *log.Logger
+
+// DebugLogger writes debug logs of requests with debug logging enabled, to
+// DebugLogPath, see DebuglnContext
+var DebugLogger *log.Logger
+
 // sysInfo returns useful system statistics.
 func sysInfo() map[string]string {
 	host, err := os.Hostname()
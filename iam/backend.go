@@ -0,0 +1,83 @@
+package iam
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/journeymidnight/yig/helper"
+)
+
+const (
+	BACKEND_REMOTE = "remote"
+	BACKEND_LOCAL  = "local"
+)
+
+// Backend is where credentials of users come from, see CONFIG.IamBackend
+type Backend interface {
+	// returns ErrAccessKeyNotExist if the access key is unknown
+	GetCredential(accessKey string) (Credential, error)
+	GetCredentialByUserId(userId string) (Credential, error)
+	GetKeysByUid(uid string) ([]AccessKeyItem, error)
+}
+
+var backend Backend
+var backendLock sync.RWMutex
+
+// SetupBackend creates the backend of CONFIG.IamBackend, called on startup
+// and after config is reloaded. The backend in use is kept on errors
+func SetupBackend() error {
+	var b Backend
+	switch helper.CONFIG.IamBackend {
+	case BACKEND_REMOTE:
+		b = remoteBackend{}
+	case BACKEND_LOCAL:
+		local, err := newLocalBackend(helper.CONFIG.IamCredentialFile,
+			helper.CONFIG.IamCredentials)
+		if err != nil {
+			return err
+		}
+		b = local
+	default:
+		return errors.New("Unknown IAM backend " + helper.CONFIG.IamBackend)
+	}
+	backendLock.Lock()
+	backend = b
+	backendLock.Unlock()
+	return nil
+}
+
+// IAM service is used if SetupBackend is never called
+func getBackend() Backend {
+	backendLock.RLock()
+	defer backendLock.RUnlock()
+	if backend == nil {
+		return remoteBackend{}
+	}
+	return backend
+}
+
+// remoteBackend queries IAM service at CONFIG.IamEndpoint
+type remoteBackend struct{}
+
+func (remoteBackend) GetCredential(accessKey string) (Credential, error) {
+	if helper.CONFIG.DisableIamCache {
+		return queryCredential(accessKey)
+	}
+	initializeIamCache()
+	return iamCache.get(accessKey)
+}
+
+func (remoteBackend) GetCredentialByUserId(userId string) (credential Credential, err error) {
+	// should use a cache with timeout
+	// TODO
+	return Credential{
+		UserId:          userId,
+		DisplayName:     userId,
+		AccessKeyID:     "hehehehe",
+		SecretAccessKey: "hehehehe",
+	}, nil // For test now
+}
+
+func (remoteBackend) GetKeysByUid(uid string) ([]AccessKeyItem, error) {
+	return queryKeysByUid(uid)
+}
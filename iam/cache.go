@@ -3,74 +3,206 @@ package iam
 import (
 	"sync"
 	"time"
+
+	"github.com/journeymidnight/yig/helper"
 )
 
 const (
-	CACHE_EXPIRE_TIME = 600 * time.Second
 	CACHE_CHECK_TIME = 60 * time.Second
+	// entries older than this part of their TTL are refreshed in background
+	// when requested, so hot keys never expire
+	CACHE_REFRESH_RATIO = 0.8
+	// entries are invalidated on signature failures at most this often,
+	// so wrong signatures don't turn into queries to IAM
+	CACHE_MIN_INVALIDATE_AGE = 10 * time.Second
 )
 
 type cacheEntry struct {
 	createTime time.Time
 	credential Credential
+	exists     bool // false for access keys unknown to IAM
+	refreshing bool
+	invalid    bool // queried again when requested, but still usable as stale
+	// when it's last marked invalid, entries are invalidated at most once
+	// within CACHE_MIN_INVALIDATE_AGE
+	invalidateTime time.Time
+}
+
+// one query to IAM, shared by all requests of the same access key
+type call struct {
+	done       chan struct{}
+	credential Credential
+	err        error
 }
 
 // maps access key to Credential object
 type cache struct {
-	cache map[string]cacheEntry
-	lock  *sync.RWMutex
+	cache       map[string]*cacheEntry
+	calls       map[string]*call
+	lock        *sync.RWMutex
+	ttl         time.Duration
+	negativeTtl time.Duration
+	staleTtl    time.Duration // how long expired credentials are kept in case IAM fails
+	query       func(accessKey string) (Credential, error)
+	now         func() time.Time
 }
 
 var iamCache *cache
+var iamCacheLock sync.Mutex
+
+func newCache(ttl, negativeTtl, staleTtl time.Duration,
+	query func(accessKey string) (Credential, error)) *cache {
 
-func cacheInvalidator() {
-	if iamCache == nil {
-		panic("IAM cache not initialized yet")
+	return &cache{
+		cache:       make(map[string]*cacheEntry),
+		calls:       make(map[string]*call),
+		lock:        new(sync.RWMutex),
+		ttl:         ttl,
+		negativeTtl: negativeTtl,
+		staleTtl:    staleTtl,
+		query:       query,
+		now:         time.Now,
 	}
+}
+
+func (c *cache) invalidate() {
 	for {
-		keysToExpire := make([]string, 0)
-		now := time.Now()
-		iamCache.lock.Lock()
-		for k, entry := range iamCache.cache {
-			if entry.createTime.Add(CACHE_EXPIRE_TIME).Before(now) {
-				keysToExpire = append(keysToExpire, k)
+		now := c.now()
+		c.lock.Lock()
+		for key, entry := range c.cache {
+			if c.expired(entry, now) && !c.stale(entry, now) {
+				delete(c.cache, key)
 			}
 		}
-		for _, key := range keysToExpire {
-			delete(iamCache.cache, key)
-		}
-		iamCache.lock.Unlock()
+		c.lock.Unlock()
 		time.Sleep(CACHE_CHECK_TIME)
 	}
 }
 
 func initializeIamCache() {
+	iamCacheLock.Lock()
+	defer iamCacheLock.Unlock()
 	if iamCache != nil {
 		return
 	}
-	iamCache = &cache{
-		cache: make(map[string]cacheEntry),
-		lock:  new(sync.RWMutex),
+	iamCache = newCache(helper.CONFIG.IamCacheTTL, helper.CONFIG.IamNegativeCacheTTL,
+		helper.CONFIG.IamCacheStaleTTL, queryCredential)
+	go iamCache.invalidate()
+}
+
+func (c *cache) entryTtl(entry *cacheEntry) time.Duration {
+	if entry.exists {
+		return c.ttl
 	}
-	go cacheInvalidator()
+	return c.negativeTtl
 }
 
-func (c *cache) get(key string) (credential Credential, hit bool) {
-	c.lock.RLock()
-	entry, hit := c.cache[key]
-	c.lock.RUnlock()
-	if hit {
-		credential = entry.credential
+func (c *cache) expired(entry *cacheEntry, now time.Time) bool {
+	return entry.invalid || now.Sub(entry.createTime) >= c.entryTtl(entry)
+}
+
+// Whether credential of entry could still be used when IAM fails
+func (c *cache) stale(entry *cacheEntry, now time.Time) bool {
+	return entry.exists && now.Sub(entry.createTime) < c.ttl+c.staleTtl
+}
+
+// Credential of accessKey, from cache if possible. Only one query to IAM is
+// made for concurrent requests of the same key
+func (c *cache) get(accessKey string) (credential Credential, err error) {
+	now := c.now()
+	c.lock.Lock()
+	entry, ok := c.cache[accessKey]
+	if ok && !c.expired(entry, now) {
+		if !entry.refreshing &&
+			now.Sub(entry.createTime) >= time.Duration(float64(c.entryTtl(entry))*CACHE_REFRESH_RATIO) {
+			entry.refreshing = true
+			go c.load(accessKey)
+		}
+		c.lock.Unlock()
+		if !entry.exists {
+			return credential, ErrAccessKeyNotExist
+		}
+		return entry.credential, nil
 	}
-	return credential, hit
+	c.lock.Unlock()
+	return c.load(accessKey)
 }
 
-func (c *cache) set(key string, credential Credential) {
-	entry := cacheEntry{
-		createTime: time.Now(),
-		credential: credential,
+func (c *cache) load(accessKey string) (credential Credential, err error) {
+	c.lock.Lock()
+	if inflight, ok := c.calls[accessKey]; ok {
+		c.lock.Unlock()
+		<-inflight.done
+		return inflight.credential, inflight.err
+	}
+	inflight := &call{done: make(chan struct{})}
+	c.calls[accessKey] = inflight
+	c.lock.Unlock()
+
+	inflight.credential, inflight.err = c.query(accessKey)
+
+	c.lock.Lock()
+	delete(c.calls, accessKey)
+	switch inflight.err {
+	case nil:
+		c.cache[accessKey] = &cacheEntry{createTime: c.now(), credential: inflight.credential,
+			exists: true}
+	case ErrAccessKeyNotExist:
+		c.cache[accessKey] = &cacheEntry{createTime: c.now()}
+	default:
+		// failures of IAM are not cached, entries being refreshed are
+		// kept until they expire, and expired ones are served as stale
+		// for a while, e.g. when the circuit to IAM is closed
+		if entry, ok := c.cache[accessKey]; ok {
+			entry.refreshing = false
+			// queried once for each invalidation, even if IAM fails
+			entry.invalid = false
+			if c.stale(entry, c.now()) {
+				helper.Logger.Println(5, "Failed to query IAM for", accessKey,
+					"with error", inflight.err, ", using stale credential")
+				inflight.credential, inflight.err = entry.credential, nil
+			}
+		}
 	}
+	c.lock.Unlock()
+	close(inflight.done)
+	return inflight.credential, inflight.err
+}
+
+func (c *cache) remove(accessKey string) {
+	c.lock.Lock()
+	delete(c.cache, accessKey)
+	c.lock.Unlock()
+}
+
+// Mark entry of accessKey to be queried again, unless it's just created or
+// invalidated
+func (c *cache) invalidateEntry(accessKey string) {
+	now := c.now()
 	c.lock.Lock()
-	c.cache[key] = entry
+	if entry, ok := c.cache[accessKey]; ok &&
+		now.Sub(entry.createTime) >= CACHE_MIN_INVALIDATE_AGE &&
+		now.Sub(entry.invalidateTime) >= CACHE_MIN_INVALIDATE_AGE {
+		entry.invalid = true
+		entry.invalidateTime = now
+	}
 	c.lock.Unlock()
 }
+
+// Drop cached credential of accessKey, e.g. after the key is revoked, so it's
+// queried from IAM again by the next request
+func FlushCredential(accessKey string) {
+	initializeIamCache()
+	iamCache.remove(accessKey)
+}
+
+// Called when a signature of accessKey doesn't match, in case its secret is
+// changed in IAM. Unlike FlushCredential, the cached credential is still used
+// if IAM fails. Repeated calls within CACHE_MIN_INVALIDATE_AGE are ignored
+func InvalidateCredential(accessKey string) {
+	if helper.CONFIG.DebugMode || helper.CONFIG.DisableIamCache {
+		return
+	}
+	initializeIamCache()
+	iamCache.invalidateEntry(accessKey)
+}
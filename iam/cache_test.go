@@ -0,0 +1,244 @@
+package iam
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+)
+
+// IAM that knows access key "hehe" only, and counts queries
+type fakeIam struct {
+	*httptest.Server
+	queries int64
+	delay   time.Duration
+	broken  int32 // responds 500 if set
+}
+
+func newFakeIam(t *testing.T) *fakeIam {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	iam := &fakeIam{}
+	iam.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&iam.queries, 1)
+		time.Sleep(iam.delay)
+		if atomic.LoadInt32(&iam.broken) != 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var query Query
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			t.Error(err)
+		}
+		var resp QueryRespAll
+		if len(query.AccessKeys) == 1 && query.AccessKeys[0] == "hehe" {
+			resp.Data.Total = 1
+			resp.Data.AccessKeySet = []AccessKeyItem{{ProjectId: "u-hehe",
+				AccessKey: "hehe", AccessSecret: "hehehehe"}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	helper.CONFIG.IamEndpoint = iam.URL
+	return iam
+}
+
+func (iam *fakeIam) queried() int64 {
+	return atomic.LoadInt64(&iam.queries)
+}
+
+// A stampede of requests of the same key, before and after it's cached
+func TestCacheConcurrentRequests(t *testing.T) {
+	iam := newFakeIam(t)
+	defer iam.Close()
+	iam.delay = 50 * time.Millisecond
+	c := newCache(time.Minute, time.Second, 0, queryCredential)
+
+	for round := 0; round < 2; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				credential, err := c.get("hehe")
+				if err != nil || credential.SecretAccessKey != "hehehehe" {
+					t.Errorf("unexpected credential %v, %v", credential, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if iam.queried() != 1 {
+			t.Fatalf("round %d: expected 1 query to IAM, got %d", round, iam.queried())
+		}
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	iam := newFakeIam(t)
+	defer iam.Close()
+	now := time.Now()
+	c := newCache(time.Minute, 10*time.Second, 0, queryCredential)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.get("haha"); err != ErrAccessKeyNotExist {
+			t.Fatalf("expected unknown key, got %v", err)
+		}
+	}
+	if iam.queried() != 1 {
+		t.Errorf("unknown key should be cached, got %d queries", iam.queried())
+	}
+	now = now.Add(10 * time.Second)
+	c.get("haha")
+	if iam.queried() != 2 {
+		t.Errorf("unknown key should be queried again after its TTL, got %d queries", iam.queried())
+	}
+
+	// entries near expiry are served from cache and refreshed in background
+	c.get("hehe")
+	now = now.Add(50 * time.Second)
+	if credential, err := c.get("hehe"); err != nil || credential.UserId != "u-hehe" {
+		t.Fatalf("unexpected credential %v, %v", credential, err)
+	}
+	for i := 0; iam.queried() != 4; i++ {
+		if i > 100 {
+			t.Fatalf("entry should be refreshed, got %d queries", iam.queried())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		c.lock.RLock()
+		refreshing := c.cache["hehe"].refreshing
+		c.lock.RUnlock()
+		if !refreshing {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	now = now.Add(30 * time.Second)
+	c.get("hehe")
+	if iam.queried() != 4 {
+		t.Errorf("refreshed entry should be used, got %d queries", iam.queried())
+	}
+}
+
+func TestCacheIamFailure(t *testing.T) {
+	iam := newFakeIam(t)
+	defer iam.Close()
+	c := newCache(time.Minute, time.Minute, 0, queryCredential)
+
+	atomic.StoreInt32(&iam.broken, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := c.get("hehe"); err == nil || err == ErrAccessKeyNotExist {
+			t.Fatalf("expected failure of IAM, got %v", err)
+		}
+	}
+	if iam.queried() != 2 {
+		t.Errorf("failures of IAM should not be cached, got %d queries", iam.queried())
+	}
+	atomic.StoreInt32(&iam.broken, 0)
+	if _, err := c.get("hehe"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Expired credentials are used for a while if IAM fails, unknown keys are not
+func TestCacheStaleCredential(t *testing.T) {
+	iam := newFakeIam(t)
+	defer iam.Close()
+	now := time.Now()
+	c := newCache(time.Minute, time.Minute, 10*time.Minute, queryCredential)
+	c.now = func() time.Time { return now }
+
+	c.get("hehe")
+	c.get("haha")
+	atomic.StoreInt32(&iam.broken, 1)
+	now = now.Add(5 * time.Minute)
+	if credential, err := c.get("hehe"); err != nil || credential.SecretAccessKey != "hehehehe" {
+		t.Fatalf("stale credential should be used, got %v, %v", credential, err)
+	}
+	if _, err := c.get("haha"); err == nil || err == ErrAccessKeyNotExist {
+		t.Errorf("unknown key should not be used as stale, got %v", err)
+	}
+	now = now.Add(6 * time.Minute)
+	if _, err := c.get("hehe"); err == nil {
+		t.Error("credential should not be used beyond its stale TTL")
+	}
+}
+
+func TestInvalidateCredential(t *testing.T) {
+	iam := newFakeIam(t)
+	defer iam.Close()
+	now := time.Now()
+	c := newCache(time.Minute, time.Minute, time.Minute, queryCredential)
+	c.now = func() time.Time { return now }
+
+	c.get("hehe")
+	c.invalidateEntry("hehe")
+	c.get("hehe")
+	if iam.queried() != 1 {
+		t.Errorf("fresh entry should not be invalidated, got %d queries", iam.queried())
+	}
+	now = now.Add(CACHE_MIN_INVALIDATE_AGE)
+	c.invalidateEntry("hehe")
+	c.get("hehe")
+	c.get("hehe")
+	if iam.queried() != 2 {
+		t.Errorf("invalidated entry should be queried once, got %d queries", iam.queried())
+	}
+	now = now.Add(CACHE_MIN_INVALIDATE_AGE)
+	c.invalidateEntry("hehe")
+	atomic.StoreInt32(&iam.broken, 1)
+	if _, err := c.get("hehe"); err != nil {
+		t.Errorf("invalidated entry should be used if IAM fails, got %v", err)
+	}
+
+	// repeated failures of signatures don't turn into queries, even if
+	// IAM fails and the entry is not refreshed
+	queried := iam.queried()
+	for i := 0; i < 9; i++ {
+		now = now.Add(time.Second)
+		c.invalidateEntry("hehe")
+		c.get("hehe")
+	}
+	if iam.queried() != queried {
+		t.Errorf("entry should be invalidated at most once within %v, got %d more queries",
+			CACHE_MIN_INVALIDATE_AGE, iam.queried()-queried)
+	}
+	now = now.Add(time.Second)
+	c.invalidateEntry("hehe")
+	c.get("hehe")
+	if iam.queried() == queried {
+		t.Error("entry should be invalidated again after a while")
+	}
+}
+
+func TestFlushCredential(t *testing.T) {
+	iam := newFakeIam(t)
+	defer iam.Close()
+	initializeIamCache()
+	ttl := iamCache.ttl
+	iamCache.ttl = time.Minute
+	defer func() { iamCache.ttl = ttl }()
+	FlushCredential("hehe")
+
+	GetCredential("hehe")
+	GetCredential("hehe")
+	FlushCredential("hehe")
+	GetCredential("hehe")
+	if iam.queried() != 2 {
+		t.Errorf("flushed credential should be queried again, got %d queries", iam.queried())
+	}
+
+	helper.CONFIG.DisableIamCache = true
+	defer func() { helper.CONFIG.DisableIamCache = false }()
+	GetCredential("hehe")
+	if iam.queried() != 3 {
+		t.Errorf("cache should be bypassed if disabled, got %d queries", iam.queried())
+	}
+}
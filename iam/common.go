@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 // credential container for access and secret keys.
@@ -56,14 +57,27 @@ type QueryRespAll struct {
 }
 
 var iamClient *circuitbreak.CircuitClient
+var iamClientOnce sync.Once
+
+// Queries to IAM fail fast while it's considered unhealthy
+func getIamClient() *circuitbreak.CircuitClient {
+	iamClientOnce.Do(func() {
+		iamClient = circuitbreak.NewCircuitClient()
+		iamClient.Threshold = helper.CONFIG.IamBreakerThreshold
+		iamClient.MaxRetryTime = helper.CONFIG.IamBreakerMaxRetry
+		iamClient.Interval = helper.CONFIG.IamBreakerInterval
+	})
+	return iamClient
+}
 
 func GetKeysByUid(uid string) (keyslist []AccessKeyItem, err error) {
+	return getBackend().GetKeysByUid(uid)
+}
+
+func queryKeysByUid(uid string) (keyslist []AccessKeyItem, err error) {
 
 	var slog = helper.Logger
 	var query Query
-	if iamClient == nil {
-		iamClient = circuitbreak.NewCircuitClient()
-	}
 	var offset int = 0
 	var total int = 0
 	query.Action = "DescribeAccessKeys"
@@ -79,7 +93,7 @@ func GetKeysByUid(uid string) (keyslist []AccessKeyItem, err error) {
 		request.Header.Set("X-Le-Key", "key")
 		request.Header.Set("X-Le-Secret", "secret")
 		slog.Println(10, "replay request:", request, string(b))
-		response, err := iamClient.Do(request)
+		response, err := getIamClient().Do(request)
 		if err != nil {
 			slog.Println(5, "replay histroy send request failed", err)
 			return keyslist, err
@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"github.com/journeymidnight/yig/circuitbreak"
 	"github.com/journeymidnight/yig/helper"
 	"io/ioutil"
 	"net/http"
@@ -19,6 +18,10 @@ var IsValidSecretKey = regexp.MustCompile(`^.{8,40}$`)
 // IsValidAccessKey - validate access key.
 var IsValidAccessKey = regexp.MustCompile(`^[a-zA-Z0-9\\-\\.\\_\\~]{5,20}$`)
 
+// Returned by GetCredential when IAM knows nothing about the access key,
+// other errors mean IAM itself failed
+var ErrAccessKeyNotExist = errors.New("Access key does not exist")
+
 func GetCredential(accessKey string) (credential Credential, err error) {
 	if helper.CONFIG.DebugMode == true {
 		return Credential{
@@ -29,19 +32,12 @@ func GetCredential(accessKey string) (credential Credential, err error) {
 		}, nil // For test now
 	}
 
-	if iamCache == nil {
-		initializeIamCache()
-	}
-	credential, hit := iamCache.get(accessKey)
-	if hit {
-		return credential, nil
-	}
+	return getBackend().GetCredential(accessKey)
+}
 
+func queryCredential(accessKey string) (credential Credential, err error) {
 	var slog = helper.Logger
 	var query Query
-	if iamClient == nil {
-		iamClient = circuitbreak.NewCircuitClient()
-	}
 	query.Action = "DescribeAccessKeys"
 	query.AccessKeys = append(query.AccessKeys, accessKey)
 
@@ -71,7 +67,7 @@ func GetCredential(accessKey string) (credential Credential, err error) {
 	request.Header.Set("X-Le-Secret", helper.CONFIG.IamSecret)
 	request.Header.Set("content-type", "application/json")
 	request = request.WithContext(ctx)
-	response, err := iamClient.Do(request)
+	response, err := getIamClient().Do(request)
 	if err != nil {
 		return credential, err
 	}
@@ -102,21 +98,23 @@ func GetCredential(accessKey string) (credential Credential, err error) {
 		credential.DisplayName = queryRetAll.Data.AccessKeySet[0].Name
 		credential.AccessKeyID = queryRetAll.Data.AccessKeySet[0].AccessKey
 		credential.SecretAccessKey = queryRetAll.Data.AccessKeySet[0].AccessSecret
-		iamCache.set(accessKey, credential)
 		return credential, nil
 	} else {
-		return credential, errors.New("Access key does not exist")
+		return credential, ErrAccessKeyNotExist
 	}
 
 }
 
 func GetCredentialByUserId(userId string) (credential Credential, err error) {
-	// should use a cache with timeout
-	// TODO
-	return Credential{
-		UserId:          userId,
-		DisplayName:     userId,
-		AccessKeyID:     "hehehehe",
-		SecretAccessKey: "hehehehe",
-	}, nil // For test now
+	return getBackend().GetCredentialByUserId(userId)
+}
+
+// Canonical user id is what used as grantee id in ACLs, which is the same
+// as user id in YIG
+func GetCanonicalUserId(userId string) (string, error) {
+	credential, err := GetCredentialByUserId(userId)
+	if err != nil {
+		return "", err
+	}
+	return credential.UserId, nil
 }
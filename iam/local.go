@@ -0,0 +1,86 @@
+package iam
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	"github.com/journeymidnight/yig/helper"
+)
+
+// localBackend keeps credentials in memory, for development and small
+// deployments without IAM service
+type localBackend struct {
+	byAccessKey map[string]Credential
+	byUserId    map[string][]Credential // in the order configured
+}
+
+// Credentials in file at path, if any, are loaded before the others
+func newLocalBackend(path string, credentials []helper.IamCredential) (*localBackend, error) {
+	var all []helper.IamCredential
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &all)
+		if err != nil {
+			return nil, errors.New("Failed to decode credential file " + path + ": " +
+				err.Error())
+		}
+	}
+	all = append(all, credentials...)
+
+	b := &localBackend{
+		byAccessKey: make(map[string]Credential),
+		byUserId:    make(map[string][]Credential),
+	}
+	for _, c := range all {
+		if c.AccessKey == "" || c.SecretKey == "" || c.UserId == "" {
+			return nil, errors.New("Access key, secret key and user id are required " +
+				"for local IAM credentials")
+		}
+		if _, ok := b.byAccessKey[c.AccessKey]; ok {
+			return nil, errors.New("Duplicate access key " + c.AccessKey)
+		}
+		credential := Credential{
+			UserId:          c.UserId,
+			DisplayName:     helper.Ternary(c.DisplayName == "", c.UserId, c.DisplayName).(string),
+			AccessKeyID:     c.AccessKey,
+			SecretAccessKey: c.SecretKey,
+		}
+		b.byAccessKey[c.AccessKey] = credential
+		b.byUserId[c.UserId] = append(b.byUserId[c.UserId], credential)
+	}
+	return b, nil
+}
+
+func (b *localBackend) GetCredential(accessKey string) (Credential, error) {
+	credential, ok := b.byAccessKey[accessKey]
+	if !ok {
+		return Credential{}, ErrAccessKeyNotExist
+	}
+	return credential, nil
+}
+
+// Users unknown, e.g. owners of data created before they are removed from
+// config, are named after their ids
+func (b *localBackend) GetCredentialByUserId(userId string) (Credential, error) {
+	if credentials, ok := b.byUserId[userId]; ok {
+		return credentials[0], nil
+	}
+	return Credential{UserId: userId, DisplayName: userId}, nil
+}
+
+func (b *localBackend) GetKeysByUid(uid string) ([]AccessKeyItem, error) {
+	var keys []AccessKeyItem
+	for _, credential := range b.byUserId[uid] {
+		keys = append(keys, AccessKeyItem{
+			ProjectId:    credential.UserId,
+			Name:         credential.DisplayName,
+			AccessKey:    credential.AccessKeyID,
+			AccessSecret: credential.SecretAccessKey,
+		})
+	}
+	return keys, nil
+}
@@ -0,0 +1,72 @@
+package iam
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/journeymidnight/yig/helper"
+)
+
+func TestLocalBackend(t *testing.T) {
+	f, err := ioutil.TempFile("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[{"AccessKey": "hehe", "SecretKey": "hehehehe", "UserId": "u-hehe",
+		"DisplayName": "Hehe"}]`)
+	f.Close()
+
+	config := helper.CONFIG
+	defer func() {
+		helper.CONFIG = config
+		backend = nil
+	}()
+	helper.CONFIG.IamBackend = BACKEND_LOCAL
+	helper.CONFIG.IamCredentialFile = f.Name()
+	helper.CONFIG.IamCredentials = []helper.IamCredential{
+		{AccessKey: "haha", SecretKey: "hahahaha", UserId: "u-hehe"},
+		{AccessKey: "hoho", SecretKey: "hohohoho", UserId: "u-hoho"},
+	}
+	if err := SetupBackend(); err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := GetCredential("haha")
+	if err != nil || credential.UserId != "u-hehe" || credential.SecretAccessKey != "hahahaha" {
+		t.Errorf("unexpected credential %v, %v", credential, err)
+	}
+	if _, err := GetCredential("hihi"); err != ErrAccessKeyNotExist {
+		t.Errorf("unknown access key should not exist, got %v", err)
+	}
+	credential, err = GetCredentialByUserId("u-hehe")
+	if err != nil || credential.DisplayName != "Hehe" || credential.AccessKeyID != "hehe" {
+		t.Errorf("unexpected credential of user %v, %v", credential, err)
+	}
+	credential, err = GetCredentialByUserId("u-hoho")
+	if err != nil || credential.DisplayName != "u-hoho" {
+		t.Errorf("display name should default to user id, got %v, %v", credential, err)
+	}
+	if credential, err = GetCredentialByUserId("u-gone"); err != nil || credential.UserId != "u-gone" {
+		t.Errorf("unknown user should be named after its id, got %v, %v", credential, err)
+	}
+	keys, err := GetKeysByUid("u-hehe")
+	if err != nil || len(keys) != 2 || keys[0].AccessKey != "hehe" || keys[1].AccessKey != "haha" {
+		t.Errorf("unexpected keys %v, %v", keys, err)
+	}
+
+	// broken config keeps the backend in use
+	helper.CONFIG.IamCredentials = append(helper.CONFIG.IamCredentials,
+		helper.IamCredential{AccessKey: "hehe", SecretKey: "hehehehe", UserId: "u-other"})
+	if err := SetupBackend(); err == nil {
+		t.Error("duplicate access keys should be rejected")
+	}
+	helper.CONFIG.IamCredentialFile = "/nonexistent"
+	if err := SetupBackend(); err == nil {
+		t.Error("missing credential file should be reported")
+	}
+	if _, err := GetCredential("hoho"); err != nil {
+		t.Errorf("backend should be kept after failed reload, got %v", err)
+	}
+}
@@ -31,6 +31,13 @@ CREATE TABLE `buckets` (
   `createtime` datetime DEFAULT NULL,
   `usages` bigint(20) DEFAULT NULL,
   `versioning` varchar(255) DEFAULT NULL,
+  `encryption` varchar(255) NOT NULL DEFAULT '',
+  `objectlock` varchar(255) NOT NULL DEFAULT '',
+  `payer` varchar(255) NOT NULL DEFAULT '',
+  `ratelimit` varchar(255) NOT NULL DEFAULT '',
+  `maxobjectsize` bigint(20) NOT NULL DEFAULT 0,
+  `gzipvariants` tinyint(1) NOT NULL DEFAULT 0,
+  `grants` text NOT NULL,
   PRIMARY KEY (`bucketname`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 /*!40101 SET character_set_client = @saved_cs_client */;
@@ -94,6 +101,20 @@ CREATE TABLE `gcpart` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
+--
+-- Table structure for table `lifecycle`
+--
+
+DROP TABLE IF EXISTS `lifecycle`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `lifecycle` (
+  `bucketname` varchar(255) NOT NULL DEFAULT '',
+  `status` varchar(255) DEFAULT NULL,
+  PRIMARY KEY (`bucketname`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `multipartpart`
 --
@@ -132,10 +153,12 @@ CREATE TABLE `multiparts` (
   `contenttype` varchar(255) DEFAULT NULL,
   `location` varchar(255) DEFAULT NULL,
   `pool` varchar(255) DEFAULT NULL,
-  `acl` varchar(255) DEFAULT NULL,
+  `acl` text DEFAULT NULL,
   `sserequest` varchar(255) DEFAULT NULL,
   `encryption` blob DEFAULT NULL,
   `attrs` varchar(255) DEFAULT NULL,
+  `storageclass` varchar(255) NOT NULL DEFAULT 'STANDARD',
+  `ttl` bigint(20) NOT NULL DEFAULT 0,
   UNIQUE KEY `rowkey` (`bucketname`,`objectname`,`uploadtime`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 /*!40101 SET character_set_client = @saved_cs_client */;
@@ -188,6 +211,18 @@ CREATE TABLE `objects` (
   `ssetype` varchar(255) DEFAULT NULL,
   `encryptionkey` blob DEFAULT NULL,
   `initializationvector` blob DEFAULT NULL,
+  `expiretime` bigint(20) NOT NULL DEFAULT 0,
+  `retentionmode` varchar(255) NOT NULL DEFAULT '',
+  `retainuntil` bigint(20) NOT NULL DEFAULT 0,
+  `legalhold` tinyint(1) NOT NULL DEFAULT 0,
+  `appendable` tinyint(1) NOT NULL DEFAULT 0,
+  `grants` text NOT NULL,
+  `storageclass` varchar(255) NOT NULL DEFAULT 'STANDARD',
+  `sharedwith` varchar(1024) NOT NULL DEFAULT '',
+  `checksumalgorithm` varchar(16) NOT NULL DEFAULT '',
+  `checksum` varchar(64) NOT NULL DEFAULT '',
+  `replicas` text NOT NULL,
+  `restoreexpiry` bigint(20) NOT NULL DEFAULT 0,
    UNIQUE KEY `rowkey` (`bucketname`,`name`,`version`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 /*!40101 SET character_set_client = @saved_cs_client */;
@@ -216,7 +251,8 @@ DROP TABLE IF EXISTS `users`;
 /*!40101 SET character_set_client = utf8 */;
 CREATE TABLE `users` (
   `userid` varchar(255) DEFAULT NULL,
-  `bucketname` varchar(255) DEFAULT NULL
+  `bucketname` varchar(255) DEFAULT NULL,
+  UNIQUE KEY `rowkey` (`userid`,`bucketname`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 /*!40101 SET character_set_client = @saved_cs_client */;
 /*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
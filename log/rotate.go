@@ -0,0 +1,170 @@
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an io.Writer which writes to a log file and rotates it
+// when its size exceeds maxSize, or interval elapsed since it's opened.
+// Rotated files are named as path.1, path.2, ... path.N(.gz), path.1 is
+// the newest one, at most maxFiles rotated files are kept.
+type RotatingFile struct {
+	path     string
+	maxSize  int64         // in bytes, 0 means no limit
+	maxFiles int           // number of rotated files to keep
+	interval time.Duration // 0 means never rotate by time
+	compress bool
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	openTime time.Time
+	// serialize compressing and shifting of rotated files
+	compressing sync.Mutex
+}
+
+func NewRotatingFile(path string, maxSize int64, maxFiles int,
+	interval time.Duration, compress bool) (*RotatingFile, error) {
+
+	r := &RotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		interval: interval,
+		compress: compress,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	r.openTime = time.Now()
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.shouldRotate(int64(len(p))) {
+		err = r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	return
+}
+
+func (r *RotatingFile) shouldRotate(incoming int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.maxSize > 0 && r.size+incoming > r.maxSize {
+		return true
+	}
+	if r.interval > 0 && time.Since(r.openTime) >= r.interval {
+		return true
+	}
+	return false
+}
+
+func (r *RotatingFile) rotatedName(i int) string {
+	return r.path + "." + strconv.Itoa(i)
+}
+
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+
+	r.compressing.Lock()
+	if r.maxFiles > 0 {
+		os.Remove(r.rotatedName(r.maxFiles))
+		os.Remove(r.rotatedName(r.maxFiles) + ".gz")
+		for i := r.maxFiles - 1; i > 0; i-- {
+			os.Rename(r.rotatedName(i), r.rotatedName(i+1))
+			os.Rename(r.rotatedName(i)+".gz", r.rotatedName(i+1)+".gz")
+		}
+		os.Rename(r.path, r.rotatedName(1))
+	} else {
+		os.Remove(r.path)
+	}
+	r.compressing.Unlock()
+
+	if r.compress && r.maxFiles > 0 {
+		go r.compressRotated()
+	}
+	return r.open()
+}
+
+// Compress all rotated files not compressed yet, in case some rotation
+// happened before the previous compression started
+func (r *RotatingFile) compressRotated() {
+	r.compressing.Lock()
+	defer r.compressing.Unlock()
+	for i := 1; i <= r.maxFiles; i++ {
+		name := r.rotatedName(i)
+		if _, err := os.Stat(name); err == nil {
+			compressFile(name)
+		}
+	}
+}
+
+func compressFile(name string) {
+	src, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return
+	}
+	writer := gzip.NewWriter(dst)
+	_, err = io.Copy(writer, src)
+	if err == nil {
+		err = writer.Close()
+	}
+	dst.Close()
+	if err != nil {
+		os.Remove(name + ".gz")
+		return
+	}
+	os.Remove(name)
+}
+
+// Reopen closes and opens the log file again, used after the file is
+// moved by external tools like logrotate
+func (r *RotatingFile) Reopen() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.file.Close()
+	return r.open()
+}
+
+func (r *RotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.compressing.Lock()
+	defer r.compressing.Unlock()
+	return r.file.Close()
+}
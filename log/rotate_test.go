@@ -0,0 +1,103 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yig-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "yig.log")
+
+	f, err := NewRotatingFile(path, 10, 2, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var expected = map[string]string{
+		path:        "dddddddd\n",
+		path + ".1": "cccccccc\n",
+		path + ".2": "bbbbbbbb\n",
+	}
+	for name, content := range expected {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, got %q", name, content, string(data))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only 2 rotated files should be kept")
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yig-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "yig.log")
+
+	f, err := NewRotatingFile(path, 10, 2, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("aaaaaaaa\n"))
+	f.Write([]byte("bbbbbbbb\n"))
+	// let compression start, Close waits for it to finish
+	time.Sleep(100 * time.Millisecond)
+	f.Close()
+
+	if _, err := os.Stat(path + ".1.gz"); err != nil {
+		t.Errorf("rotated file should be compressed: %v", err)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("uncompressed rotated file should be removed")
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yig-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "yig.log")
+
+	f, err := NewRotatingFile(path, 0, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("before\n"))
+	// what logrotate does
+	os.Rename(path, path+".old")
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("after\n"))
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "after\n" {
+		t.Errorf("expected new log file after reopen, got %q", string(data))
+	}
+}
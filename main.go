@@ -7,9 +7,12 @@ import (
 	"syscall"
 	"time"
 	"runtime"
+	"github.com/journeymidnight/yig/api"
 	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
 	"github.com/journeymidnight/yig/log"
 	"github.com/journeymidnight/yig/redis"
+	"github.com/journeymidnight/yig/signature"
 	"github.com/journeymidnight/yig/storage"
 )
 
@@ -18,7 +21,29 @@ var logger *log.Logger
 func DumpStacks() {
 	buf := make([]byte, 1<<16)
 	stacklen := runtime.Stack(buf, true)
-	helper.Logger.Printf(5,"=== received SIGQUIT ===\n*** goroutine dump...\n%s\n*** end\n", buf[:stacklen])
+	helper.Logger.Printf(5,"=== received SIGUSR2 ===\n*** goroutine dump...\n%s\n*** end\n", buf[:stacklen])
+}
+
+func openLogFile(path string) *log.RotatingFile {
+	f, err := log.NewRotatingFile(path, int64(helper.CONFIG.LogMaxSize)<<20,
+		helper.CONFIG.LogMaxFiles, helper.CONFIG.LogRotateInterval,
+		helper.CONFIG.LogCompress)
+	if err != nil {
+		panic("Failed to open log file " + path)
+	}
+	return f
+}
+
+func reopenLogFiles(files ...*log.RotatingFile) {
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		err := f.Reopen()
+		if err != nil {
+			helper.Logger.Println(5, "Failed to reopen log file:", err)
+		}
+	}
 }
 
 func main() {
@@ -28,18 +53,29 @@ func main() {
 
 	helper.SetupConfig()
 
-	f, err := os.OpenFile(helper.CONFIG.LogPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		panic("Failed to open log file " + helper.CONFIG.LogPath)
-	}
+	f := openLogFile(helper.CONFIG.LogPath)
 	defer f.Close()
 
 	logger = log.New(f, "[yig]", log.LstdFlags, helper.CONFIG.LogLevel)
 	helper.Logger = logger
 
+	var panicFile *log.RotatingFile
+	if helper.CONFIG.PanicLogPath != "" {
+		panicFile = openLogFile(helper.CONFIG.PanicLogPath)
+		defer panicFile.Close()
+		helper.PanicLogger = log.New(panicFile, "[yig]", log.LstdFlags, helper.CONFIG.LogLevel)
+	}
+
+	var debugFile *log.RotatingFile
+	if helper.CONFIG.DebugLogPath != "" {
+		debugFile = openLogFile(helper.CONFIG.DebugLogPath)
+		defer debugFile.Close()
+		helper.DebugLogger = log.New(debugFile, "[yig]", log.LstdFlags, helper.CONFIG.LogLevel)
+	}
+
 	logger.Println(5, "YIG instance ID:", helper.CONFIG.InstanceId)
 
-	if helper.CONFIG.MetaCacheType > 0 || helper.CONFIG.EnableDataCache {
+	if storage.RedisEnabled() {
 		defer redis.Close()
 		redis.Initialize()
 	}
@@ -52,6 +88,11 @@ func main() {
 	}
 	startAdminServer(adminServerConfig)
 
+	api.ReloadUploadLimits()
+	signature.SetupAuthFailures()
+	if err := iam.SetupBackend(); err != nil {
+		panic("Failed to setup IAM backend: " + err.Error())
+	}
 	apiServerConfig := &ServerConfig{
 		Address:      helper.CONFIG.BindApiAddress,
 		KeyFilePath:  helper.CONFIG.SSLKeyPath,
@@ -65,14 +106,23 @@ func main() {
 	signal.Ignore()
 	signalQueue := make(chan os.Signal)
 	signal.Notify(signalQueue, syscall.SIGINT, syscall.SIGTERM,
-		syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGUSR1)
+		syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
 	for {
 		s := <-signalQueue
 		switch s {
 		case syscall.SIGHUP:
-			// reload config file
+			// reload config file and TLS certificate
 			helper.SetupConfig()
+			reloadTLSCertificate()
+			api.ReloadUploadLimits()
+			if err := iam.SetupBackend(); err != nil {
+				helper.Logger.Println(5, "Failed to reload IAM backend:", err)
+			}
+			reopenLogFiles(f, panicFile, debugFile)
 		case syscall.SIGUSR1:
+			// log files are moved by logrotate
+			reopenLogFiles(f, panicFile, debugFile)
+		case syscall.SIGUSR2:
 			go DumpStacks()
 		default:
 			// stop YIG server, order matters
@@ -32,6 +32,12 @@ func (m *Meta) GetBucket(bucketName string, willNeed bool) (bucket Bucket, err e
 	return bucket, nil
 }
 
+// Read bucket from database directly for read-modify-write updates, cached
+// bucket may carry stale usage which would be written back by PutBucket
+func (m *Meta) GetBucketForUpdate(bucketName string) (Bucket, error) {
+	return m.Client.GetBucket(bucketName)
+}
+
 func (m *Meta) UpdateUsage(bucketName string, size int64) {
 	m.Client.UpdateUsage(bucketName, size)
 }
@@ -1,11 +1,14 @@
 package meta
 
 import (
+	"bytes"
 	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mediocregopher/radix.v2/pubsub"
+	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/redis"
 )
@@ -13,19 +16,74 @@ import (
 type CacheType int
 
 const (
-	NoCache CacheType = iota
-	EnableCache
-	SimpleCache
+	NoCache       CacheType = iota
+	EnableCache             // in memory and Redis
+	SimpleCache             // Redis only
+	InMemoryCache           // in memory only, for single instance deployments without Redis
 )
 
-var cacheNames = [...]string{"NOCACHE", "EnableCache", "SimpleCache"}
+var cacheNames = [...]string{"NOCACHE", "EnableCache", "SimpleCache", "InMemoryCache"}
+
+// Whether Redis is needed by cache of type `t`
+func (t CacheType) UsesRedis() bool {
+	return t == EnableCache || t == SimpleCache
+}
 
 type MetaCache interface {
 	Get(table redis.RedisDatabase, key string,
 		onCacheMiss func() (interface{}, error),
 		unmarshaller func([]byte) (interface{}, error), willNeed bool) (value interface{}, err error)
 	Remove(table redis.RedisDatabase, key string)
+	// Same as Remove for each of `entries`, with Redis commands pipelined
+	RemoveBatch(entries []redis.InvalidEntry)
 	GetCacheHitRatio() float64
+	GetNegativeCacheHits() int64
+	GetStats() MetaCacheStats
+	// Drop all entries of `table` cached in Redis and in memory of all YIG
+	// instances
+	Flush(table redis.RedisDatabase) error
+}
+
+type MetaCacheStats struct {
+	Hit         int64
+	Miss        int64
+	NegativeHit int64
+	LruLength   int            // entries in memory
+	Entries     map[string]int // table name -> entries in memory
+}
+
+// Published in place of key to flush a whole table
+const FLUSH_TABLE_MESSAGE = "\x00yig:flush"
+
+// Cached in place of keys `onCacheMiss` reports ErrNoSuchKey for, so requests
+// of a popular missing key won't all fall through to HBase
+type notFoundEntry struct {
+	expire time.Time // for in-memory cache, Redis expires them by itself
+}
+
+// Stored in Redis for notFoundEntry
+const NOT_FOUND_MARKER = "\x00yig:not-found"
+
+var encodedNotFoundMarker, _ = helper.MsgPackMarshal(NOT_FOUND_MARKER)
+
+func negativeCacheEnabled() bool {
+	return helper.CONFIG.NegativeCacheTTL > 0
+}
+
+func newNotFoundEntry() notFoundEntry {
+	return notFoundEntry{
+		expire: time.Now().Add(time.Duration(helper.CONFIG.NegativeCacheTTL) * time.Second),
+	}
+}
+
+// Recognize notFoundEntry stored in Redis before calling `unmarshaller`
+func unmarshalWithNotFound(unmarshaller func([]byte) (interface{}, error)) func([]byte) (interface{}, error) {
+	return func(in []byte) (interface{}, error) {
+		if bytes.Equal(in, encodedNotFoundMarker) {
+			return newNotFoundEntry(), nil
+		}
+		return unmarshaller(in)
+	}
 }
 
 // metadata is organized in 3 layers: YIG instance memory, Redis, HBase
@@ -34,8 +92,11 @@ type enabledMetaCache struct {
 	lock       *sync.Mutex // protects both `lruList` and `cache`
 	MaxEntries int
 	lruList    *list.List
-	Hit        int64
-	Miss       int64
+	// counters are accessed atomically
+	Hit  int64
+	Miss int64
+	// number of requests answered by notFoundEntry
+	NegativeHit int64
 	// maps table -> key -> value
 	cache                       map[redis.RedisDatabase]map[string]*list.Element
 	failedCacheInvalidOperation chan entry
@@ -54,41 +115,54 @@ func newMetaCache(myType CacheType) (m MetaCache) {
 	helper.Logger.Printf(10, "Setting Up Metadata Cache: %s\n", cacheNames[int(myType)])
 
 	if myType == EnableCache {
-		m := &enabledMetaCache{
-			lock:       new(sync.Mutex),
-			MaxEntries: helper.CONFIG.InMemoryCacheMaxEntryCount,
-			lruList:    list.New(),
-			cache:      make(map[redis.RedisDatabase]map[string]*list.Element),
-			Hit:        0,
-			Miss:       0,
-			failedCacheInvalidOperation: make(chan entry, helper.CONFIG.RedisConnectionNumber),
-		}
-		for _, table := range redis.MetadataTables {
-			m.cache[table] = make(map[string]*list.Element)
-		}
+		m := newEnabledMetaCache(helper.CONFIG.InMemoryCacheMaxEntryCount)
 		go invalidLocalCache(m)
 		go invalidRedisCache(m)
 		return m
 	} else if myType == SimpleCache {
-		m := new(enabledSimpleMetaCache)
-		m.Hit = 0
-		m.Miss = 0
-		return m
+		return new(enabledSimpleMetaCache)
+	} else if myType == InMemoryCache {
+		// Redis calls are no-op without redis.Initialize, and there's no
+		// other instance to receive invalid messages from
+		return newEnabledMetaCache(helper.CONFIG.InMemoryCacheMaxEntryCount)
 	}
 	return &disabledMetaCache{}
 }
 
+// allocate in-memory cache without starting background goroutines
+func newEnabledMetaCache(maxEntries int) *enabledMetaCache {
+	m := &enabledMetaCache{
+		lock:                        new(sync.Mutex),
+		MaxEntries:                  maxEntries,
+		lruList:                     list.New(),
+		cache:                       make(map[redis.RedisDatabase]map[string]*list.Element),
+		failedCacheInvalidOperation: make(chan entry, helper.CONFIG.RedisConnectionNumber),
+	}
+	for _, table := range redis.MetadataTables {
+		m.cache[table] = make(map[string]*list.Element)
+	}
+	return m
+}
+
 // subscribe to Redis channels and handle cache invalid info
 func invalidLocalCache(m *enabledMetaCache) {
-	c, err := redis.GetClient()
-	if err != nil {
-		helper.Logger.Panicln(0, "Connot get Redis client: "+err.Error())
-	}
+	// invalid messages are lost while reconnecting
+	subscription := redis.Subscribe(redis.InvalidQueueName+"*", m.removeAll)
+	handleInvalidMessages(m, subscription)
+}
 
-	subClient := pubsub.NewSubClient(c)
-	subClient.PSubscribe(redis.InvalidQueueName + "*")
+// *redis.Subscription
+type subscription interface {
+	Receive() *pubsub.SubResp
+}
+
+// Returns once subscription is closed, i.e. Receive returns nil
+func handleInvalidMessages(m *enabledMetaCache, subscription subscription) {
 	for {
-		response := subClient.Receive() // should block
+		response := subscription.Receive() // should block
+		if response == nil {
+			return
+		}
 		if response.Err != nil {
 			if !response.Timeout() {
 				helper.Logger.Println(5, "Error receiving from redis channel:",
@@ -102,6 +176,10 @@ func invalidLocalCache(m *enabledMetaCache) {
 			helper.Logger.Println(5, "Bad redis channel name: ", response.Channel)
 			continue
 		}
+		if response.Message == FLUSH_TABLE_MESSAGE {
+			m.removeTable(table)
+			continue
+		}
 		m.remove(table, response.Message)
 	}
 }
@@ -112,25 +190,33 @@ func invalidRedisCache(m *enabledMetaCache) {
 		failedEntry := <-m.failedCacheInvalidOperation
 		err := redis.Remove(failedEntry.table, failedEntry.key)
 		if err != nil {
-			m.failedCacheInvalidOperation <- failedEntry
+			m.retryInvalid(failedEntry.table, failedEntry.key)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 		err = redis.Invalid(failedEntry.table, failedEntry.key)
 		if err != nil {
-			m.failedCacheInvalidOperation <- failedEntry
+			m.retryInvalid(failedEntry.table, failedEntry.key)
 			time.Sleep(1 * time.Second)
 		}
 	}
 }
 
+// Queue invalid operation to redo, without blocking the request when
+// Redis is down for long and the queue is full
+func (m *enabledMetaCache) retryInvalid(table redis.RedisDatabase, key string) {
+	select {
+	case m.failedCacheInvalidOperation <- entry{table: table, key: key}:
+	default:
+		helper.Logger.Println(5, "Too many failed cache invalid operations, dropped",
+			table, key)
+	}
+}
+
 func (m *enabledMetaCache) invalidRedisCache(table redis.RedisDatabase, key string) {
 	err := redis.Invalid(table, key)
 	if err != nil {
-		m.failedCacheInvalidOperation <- entry{
-			table: table,
-			key:   key,
-		}
+		m.retryInvalid(table, key)
 	}
 }
 
@@ -144,9 +230,10 @@ func (m *enabledMetaCache) set(table redis.RedisDatabase, key string, value inte
 	}
 	element := m.lruList.PushFront(&entry{table, key, value})
 	m.cache[table][key] = element
+	full := m.lruList.Len() > m.MaxEntries
 	m.lock.Unlock()
 
-	if m.lruList.Len() > m.MaxEntries {
+	if full {
 		m.removeOldest()
 	}
 }
@@ -161,26 +248,43 @@ func (m *enabledMetaCache) Get(table redis.RedisDatabase, key string,
 
 	m.lock.Lock()
 	if element, hit := m.cache[table][key]; hit {
-		m.lruList.MoveToFront(element)
-		defer m.lock.Unlock()
-		m.Hit = m.Hit + 1
-
-		return element.Value.(*entry).value, nil
+		value := element.Value.(*entry).value
+		if notFound, ok := value.(notFoundEntry); !ok || time.Now().Before(notFound.expire) {
+			m.lruList.MoveToFront(element)
+			defer m.lock.Unlock()
+			atomic.AddInt64(&m.Hit, 1)
+			if ok {
+				atomic.AddInt64(&m.NegativeHit, 1)
+				return nil, ErrNoSuchKey
+			}
+			return value, nil
+		}
+		// expired notFoundEntry
+		m.lruList.Remove(element)
+		delete(m.cache[table], key)
 	}
 	m.lock.Unlock()
 
-	value, err = redis.Get(table, key, unmarshaller)
+	value, err = redis.Get(table, key, unmarshalWithNotFound(unmarshaller))
 	if err == nil && value != nil {
 		if willNeed == true {
 			m.set(table, key, value)
 		}
-		m.Hit = m.Hit + 1
+		atomic.AddInt64(&m.Hit, 1)
+		if _, ok := value.(notFoundEntry); ok {
+			atomic.AddInt64(&m.NegativeHit, 1)
+			return nil, ErrNoSuchKey
+		}
 		return value, nil
 	}
 
 	//if redis doesn't have the entry
 	if onCacheMiss != nil {
 		value, err = onCacheMiss()
+		if err == ErrNoSuchKey && willNeed == true && negativeCacheEnabled() {
+			redis.SetWithExpire(table, key, NOT_FOUND_MARKER, helper.CONFIG.NegativeCacheTTL)
+			m.set(table, key, newNotFoundEntry())
+		}
 		if err != nil {
 			return
 		}
@@ -189,16 +293,13 @@ func (m *enabledMetaCache) Get(table redis.RedisDatabase, key string,
 			err = redis.Set(table, key, value)
 			if err != nil {
 				// invalid the entry asynchronously
-				m.failedCacheInvalidOperation <- entry{
-					table: table,
-					key:   key,
-				}
+				m.retryInvalid(table, key)
 			}
 			m.invalidRedisCache(table, key)
 			m.set(table, key, value)
 		}
 
-		m.Miss = m.Miss + 1
+		atomic.AddInt64(&m.Miss, 1)
 		return value, nil
 	}
 	return nil, nil
@@ -228,10 +329,7 @@ func (m *enabledMetaCache) Remove(table redis.RedisDatabase, key string) {
 
 	if err != nil {
 		// invalid the entry asynchronously
-		m.failedCacheInvalidOperation <- entry{
-			table: table,
-			key:   key,
-		}
+		m.retryInvalid(table, key)
 	}
 	m.invalidRedisCache(table, key)
 	// this would cause YIG instance handling the API request to call `remove` twice
@@ -239,10 +337,48 @@ func (m *enabledMetaCache) Remove(table redis.RedisDatabase, key string) {
 	m.remove(table, key)
 }
 
+func (m *enabledMetaCache) RemoveBatch(entries []redis.InvalidEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	removeErr := redis.RemoveBatch(entries)
+	invalidErr := redis.InvalidBatch(entries)
+	for _, e := range entries {
+		if removeErr != nil || invalidErr != nil {
+			m.retryInvalid(e.Table, e.Key)
+		}
+		m.remove(e.Table, e.Key)
+	}
+}
+
+// Drop entries of `table` in in-memory cache
+func (m *enabledMetaCache) removeTable(table redis.RedisDatabase) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, element := range m.cache[table] {
+		m.lruList.Remove(element)
+	}
+	m.cache[table] = make(map[string]*list.Element)
+}
+
+// Drop all entries of in-memory cache
+func (m *enabledMetaCache) removeAll() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lruList.Init()
+	for table := range m.cache {
+		m.cache[table] = make(map[string]*list.Element)
+	}
+}
+
 func (m *disabledMetaCache) Remove(table redis.RedisDatabase, key string) {
 	return
 }
 
+func (m *disabledMetaCache) RemoveBatch(entries []redis.InvalidEntry) {
+	return
+}
+
 func (m *enabledMetaCache) removeOldest() {
 	m.lock.Lock()
 	element := m.lruList.Back()
@@ -256,17 +392,72 @@ func (m *enabledMetaCache) removeOldest() {
 	// Do not invalid Redis cache because data there is still _valid_
 }
 
+func hitRatio(hit, miss *int64) float64 {
+	h := atomic.LoadInt64(hit)
+	total := h + atomic.LoadInt64(miss)
+	if total == 0 {
+		return 0
+	}
+	return float64(h) / float64(total)
+}
+
 func (m *enabledMetaCache) GetCacheHitRatio() float64 {
-	return float64(m.Hit) / float64(m.Hit+m.Miss)
+	return hitRatio(&m.Hit, &m.Miss)
+}
+
+func (m *enabledMetaCache) GetNegativeCacheHits() int64 {
+	return atomic.LoadInt64(&m.NegativeHit)
+}
+
+func (m *enabledMetaCache) GetStats() MetaCacheStats {
+	stats := MetaCacheStats{
+		Hit:         atomic.LoadInt64(&m.Hit),
+		Miss:        atomic.LoadInt64(&m.Miss),
+		NegativeHit: atomic.LoadInt64(&m.NegativeHit),
+		Entries:     make(map[string]int),
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stats.LruLength = m.lruList.Len()
+	for table, entries := range m.cache {
+		stats.Entries[table.Name()] = len(entries)
+	}
+	return stats
+}
+
+func (m *enabledMetaCache) Flush(table redis.RedisDatabase) error {
+	m.removeTable(table)
+	// entries are removed from Redis first, or other instances could cache
+	// them from Redis again
+	if err := redis.RemoveTable(table); err != nil {
+		return err
+	}
+	// other instances drop the table when receiving this
+	m.invalidRedisCache(table, FLUSH_TABLE_MESSAGE)
+	return nil
 }
 
 func (m *disabledMetaCache) GetCacheHitRatio() float64 {
 	return -1
 }
 
+func (m *disabledMetaCache) GetNegativeCacheHits() int64 {
+	return 0
+}
+
+func (m *disabledMetaCache) GetStats() MetaCacheStats {
+	return MetaCacheStats{}
+}
+
+func (m *disabledMetaCache) Flush(table redis.RedisDatabase) error {
+	return nil
+}
+
+// counters are accessed atomically
 type enabledSimpleMetaCache struct {
-	Hit  int64
-	Miss int64
+	Hit         int64
+	Miss        int64
+	NegativeHit int64
 }
 
 func (m *enabledSimpleMetaCache) Get(table redis.RedisDatabase, key string,
@@ -275,15 +466,22 @@ func (m *enabledSimpleMetaCache) Get(table redis.RedisDatabase, key string,
 
 	helper.Logger.Println(10, "enabledMetaCache Get()", table, key)
 
-	value, err = redis.Get(table, key, unmarshaller)
+	value, err = redis.Get(table, key, unmarshalWithNotFound(unmarshaller))
 	if err == nil && value != nil {
-		m.Hit = m.Hit + 1
+		atomic.AddInt64(&m.Hit, 1)
+		if _, ok := value.(notFoundEntry); ok {
+			atomic.AddInt64(&m.NegativeHit, 1)
+			return nil, ErrNoSuchKey
+		}
 		return value, nil
 	}
 
 	//if redis doesn't have the entry
 	if onCacheMiss != nil {
 		value, err = onCacheMiss()
+		if err == ErrNoSuchKey && willNeed == true && negativeCacheEnabled() {
+			redis.SetWithExpire(table, key, NOT_FOUND_MARKER, helper.CONFIG.NegativeCacheTTL)
+		}
 		if err != nil {
 			return
 		}
@@ -294,7 +492,7 @@ func (m *enabledSimpleMetaCache) Get(table redis.RedisDatabase, key string,
 				//do nothing, even if redis is down.
 			}
 		}
-		m.Miss = m.Miss + 1
+		atomic.AddInt64(&m.Miss, 1)
 		return value, nil
 	}
 	return nil, nil
@@ -304,6 +502,85 @@ func (m *enabledSimpleMetaCache) Remove(table redis.RedisDatabase, key string) {
 	redis.Remove(table, key)
 }
 
+func (m *enabledSimpleMetaCache) RemoveBatch(entries []redis.InvalidEntry) {
+	if len(entries) > 0 {
+		redis.RemoveBatch(entries)
+	}
+}
+
 func (m *enabledSimpleMetaCache) GetCacheHitRatio() float64 {
-	return float64(m.Hit) / float64(m.Hit+m.Miss)
+	return hitRatio(&m.Hit, &m.Miss)
+}
+
+func (m *enabledSimpleMetaCache) GetNegativeCacheHits() int64 {
+	return atomic.LoadInt64(&m.NegativeHit)
+}
+
+func (m *enabledSimpleMetaCache) GetStats() MetaCacheStats {
+	return MetaCacheStats{
+		Hit:         atomic.LoadInt64(&m.Hit),
+		Miss:        atomic.LoadInt64(&m.Miss),
+		NegativeHit: atomic.LoadInt64(&m.NegativeHit),
+	}
+}
+
+// Nothing is cached in memory
+func (m *enabledSimpleMetaCache) Flush(table redis.RedisDatabase) error {
+	return redis.RemoveTable(table)
+}
+
+// Holds removals of entries, so those made while operating on many objects
+// in a request are sent to Redis at once by Commit. Entries removed are
+// read from metadata store directly until then, since Redis and other
+// instances still have them
+type BatchCache struct {
+	MetaCache
+	lock    sync.Mutex
+	entries []redis.InvalidEntry
+	removed map[redis.InvalidEntry]bool
+}
+
+func NewBatchCache(cache MetaCache) *BatchCache {
+	return &BatchCache{
+		MetaCache: cache,
+		removed:   make(map[redis.InvalidEntry]bool),
+	}
+}
+
+func (b *BatchCache) Get(table redis.RedisDatabase, key string,
+	onCacheMiss func() (interface{}, error),
+	unmarshaller func([]byte) (interface{}, error), willNeed bool) (value interface{}, err error) {
+
+	b.lock.Lock()
+	removed := b.removed[redis.InvalidEntry{Table: table, Key: key}]
+	b.lock.Unlock()
+	if removed {
+		return onCacheMiss()
+	}
+	return b.MetaCache.Get(table, key, onCacheMiss, unmarshaller, willNeed)
+}
+
+func (b *BatchCache) Remove(table redis.RedisDatabase, key string) {
+	b.RemoveBatch([]redis.InvalidEntry{{Table: table, Key: key}})
+}
+
+func (b *BatchCache) RemoveBatch(entries []redis.InvalidEntry) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, e := range entries {
+		if !b.removed[e] {
+			b.removed[e] = true
+			b.entries = append(b.entries, e)
+		}
+	}
+}
+
+// Remove entries held so far from the underlying cache
+func (b *BatchCache) Commit() {
+	b.lock.Lock()
+	entries := b.entries
+	b.entries = nil
+	b.removed = make(map[redis.InvalidEntry]bool)
+	b.lock.Unlock()
+	b.MetaCache.RemoveBatch(entries)
 }
@@ -0,0 +1,275 @@
+package meta
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+	"github.com/journeymidnight/yig/redis"
+	"github.com/mediocregopher/radix.v2/pubsub"
+)
+
+func TestNegativeCacheInMemory(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	helper.CONFIG.NegativeCacheTTL = 10
+	m := newEnabledMetaCache(10)
+	m.set(redis.ObjectTable, "bucket:missing:", newNotFoundEntry())
+
+	for i := 0; i < 3; i++ {
+		_, err := m.Get(redis.ObjectTable, "bucket:missing:", func() (interface{}, error) {
+			t.Fatal("should not fall through to database")
+			return nil, nil
+		}, nil, true)
+		if err != ErrNoSuchKey {
+			t.Errorf("expected NoSuchKey, got %v", err)
+		}
+	}
+	if m.GetNegativeCacheHits() != 3 {
+		t.Errorf("expected 3 negative cache hits, got %d", m.GetNegativeCacheHits())
+	}
+}
+
+func TestUnmarshalWithNotFound(t *testing.T) {
+	helper.CONFIG.NegativeCacheTTL = 10
+	unmarshaller := func(in []byte) (interface{}, error) {
+		var s string
+		err := helper.MsgPackUnMarshal(in, &s)
+		return s, err
+	}
+
+	in, _ := helper.MsgPackMarshal(NOT_FOUND_MARKER)
+	value, err := unmarshalWithNotFound(unmarshaller)(in)
+	if _, ok := value.(notFoundEntry); !ok || err != nil {
+		t.Errorf("marker should be recognized, got %v %v", value, err)
+	}
+	in, _ = helper.MsgPackMarshal("hehe")
+	value, err = unmarshalWithNotFound(unmarshaller)(in)
+	if value != "hehe" || err != nil {
+		t.Errorf("other values should be passed to unmarshaller, got %v %v", value, err)
+	}
+}
+
+func TestNewMetaCache(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	if _, ok := newMetaCache(NoCache).(*disabledMetaCache); !ok {
+		t.Error("NoCache should get disabledMetaCache")
+	}
+	if _, ok := newMetaCache(SimpleCache).(*enabledSimpleMetaCache); !ok {
+		t.Error("SimpleCache should get enabledSimpleMetaCache")
+	}
+
+	calls := 0
+	value, err := newMetaCache(NoCache).Get(redis.BucketTable, "bucket",
+		func() (interface{}, error) {
+			calls += 1
+			return "hehe", nil
+		}, nil, true)
+	if value != "hehe" || err != nil || calls != 1 {
+		t.Errorf("disabled cache should always call onCacheMiss, got %v %v", value, err)
+	}
+}
+
+// Redis is not initialized in tests, so calls to it are no-op
+func TestInMemoryCacheWithoutRedis(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	helper.CONFIG.InMemoryCacheMaxEntryCount = 10
+	if InMemoryCache.UsesRedis() || !EnableCache.UsesRedis() {
+		t.Error("only InMemoryCache and NoCache should run without Redis")
+	}
+	m := newMetaCache(InMemoryCache)
+	calls := 0
+	onCacheMiss := func() (interface{}, error) {
+		calls += 1
+		return "hehe", nil
+	}
+	for i := 0; i < 2; i++ {
+		value, err := m.Get(redis.BucketTable, "bucket", onCacheMiss, nil, true)
+		if value != "hehe" || err != nil {
+			t.Fatalf("unexpected value %v %v", value, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("second Get should hit memory, onCacheMiss called %d times", calls)
+	}
+	m.Remove(redis.BucketTable, "bucket")
+	m.Get(redis.BucketTable, "bucket", onCacheMiss, nil, true)
+	if calls != 2 {
+		t.Error("removed entry should be fetched again")
+	}
+}
+
+// Get returns values in memory without touching Redis
+func getInMemory(t *testing.T, m *enabledMetaCache, table redis.RedisDatabase,
+	key string) (interface{}, bool) {
+
+	m.lock.Lock()
+	_, hit := m.cache[table][key]
+	m.lock.Unlock()
+	if !hit {
+		return nil, false
+	}
+	value, err := m.Get(table, key, nil, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value, true
+}
+
+func TestMetaCacheLRU(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	m := newEnabledMetaCache(2)
+
+	m.set(redis.BucketTable, "a", "1")
+	m.set(redis.ObjectTable, "b", "2")
+	if value, ok := getInMemory(t, m, redis.BucketTable, "a"); !ok || value != "1" {
+		t.Errorf("expected 1, got %v", value)
+	}
+	// "b" is the least recently used
+	m.set(redis.UserTable, "c", "3")
+	if _, ok := getInMemory(t, m, redis.ObjectTable, "b"); ok {
+		t.Error("least recently used entry should be evicted")
+	}
+	if m.lruList.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", m.lruList.Len())
+	}
+
+	m.set(redis.BucketTable, "a", "4")
+	if value, ok := getInMemory(t, m, redis.BucketTable, "a"); !ok || value != "4" {
+		t.Errorf("entry should be updated in place, got %v", value)
+	}
+	m.remove(redis.BucketTable, "a")
+	if _, ok := getInMemory(t, m, redis.BucketTable, "a"); ok {
+		t.Error("entry should be removed")
+	}
+}
+
+// Messages sent to fakeSubscription are handled by handleInvalidMessages
+// until it's closed
+type fakeSubscription struct {
+	messages chan *pubsub.SubResp
+	done     chan struct{}
+}
+
+func handleFakeSubscription(m *enabledMetaCache) *fakeSubscription {
+	f := &fakeSubscription{
+		messages: make(chan *pubsub.SubResp),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		handleInvalidMessages(m, f)
+		close(f.done)
+	}()
+	return f
+}
+
+func (f *fakeSubscription) Receive() *pubsub.SubResp {
+	return <-f.messages // nil once closed
+}
+
+func (f *fakeSubscription) send(channel, message string) {
+	f.messages <- &pubsub.SubResp{Type: pubsub.Message, Channel: channel, Message: message}
+}
+
+// Close and wait for handleInvalidMessages to return
+func (f *fakeSubscription) Close() {
+	close(f.messages)
+	<-f.done
+}
+
+func TestHandleInvalidMessages(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	m := newEnabledMetaCache(10)
+	m.set(redis.BucketTable, "bucket", "hehe")
+	m.set(redis.ObjectTable, "bucket", "hehe")
+
+	subscription := handleFakeSubscription(m)
+	defer subscription.Close()
+	subscription.send(redis.InvalidQueueName+"hehe", "bucket")
+	subscription.send(redis.BucketTable.InvalidQueue(), "bucket")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := getInMemory(t, m, redis.BucketTable, "bucket"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry should be invalidated by message")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := getInMemory(t, m, redis.ObjectTable, "bucket"); !ok {
+		t.Error("entries of other tables should be kept")
+	}
+}
+
+func TestMetaCacheFlush(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	m := newEnabledMetaCache(10)
+	m.set(redis.BucketTable, "a", "1")
+	m.set(redis.BucketTable, "b", "2")
+	m.set(redis.ObjectTable, "a", "3")
+	stats := m.GetStats()
+	if stats.LruLength != 3 || stats.Entries["bucket"] != 2 || stats.Entries["object"] != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	m.Flush(redis.BucketTable)
+	stats = m.GetStats()
+	if stats.LruLength != 1 || stats.Entries["bucket"] != 0 || stats.Entries["object"] != 1 {
+		t.Errorf("only bucket table should be flushed, got %+v", stats)
+	}
+
+	// flush from other instances
+	subscription := handleFakeSubscription(m)
+	defer subscription.Close()
+	subscription.send(redis.ObjectTable.InvalidQueue(), FLUSH_TABLE_MESSAGE)
+	subscription.send(redis.UserTable.InvalidQueue(), "sync")
+	if stats := m.GetStats(); stats.LruLength != 0 {
+		t.Errorf("object table should be flushed by message, got %+v", stats)
+	}
+}
+
+func TestBatchCache(t *testing.T) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	m := newEnabledMetaCache(10)
+	m.set(redis.ObjectTable, "bucket:a:", "old a")
+	m.set(redis.ObjectTable, "bucket:b:", "old b")
+	calls := 0
+	onCacheMiss := func() (interface{}, error) {
+		calls += 1
+		return "new", nil
+	}
+
+	b := NewBatchCache(m)
+	b.Remove(redis.ObjectTable, "bucket:a:")
+	b.Remove(redis.ObjectTable, "bucket:a:")
+	if _, hit := getInMemory(t, m, redis.ObjectTable, "bucket:a:"); !hit {
+		t.Fatal("removal should be held until committed")
+	}
+	if value, _ := b.Get(redis.ObjectTable, "bucket:a:", onCacheMiss, nil, true); value != "new" {
+		t.Errorf("removed entry should be read from metadata store, got %v", value)
+	}
+	if value, _ := b.Get(redis.ObjectTable, "bucket:b:", onCacheMiss, nil, true); value != "old b" {
+		t.Errorf("other entries should be read from cache, got %v", value)
+	}
+	if len(b.entries) != 1 {
+		t.Errorf("entries should be removed once, got %v", b.entries)
+	}
+
+	b.Commit()
+	if _, hit := getInMemory(t, m, redis.ObjectTable, "bucket:a:"); hit {
+		t.Error("entry should be removed once committed")
+	}
+	if _, hit := getInMemory(t, m, redis.ObjectTable, "bucket:b:"); !hit {
+		t.Error("other entries should be kept")
+	}
+	if value, _ := b.Get(redis.ObjectTable, "bucket:a:", onCacheMiss, nil, true); value != "new" || calls != 2 {
+		t.Errorf("unexpected value %v, onCacheMiss called %d times", value, calls)
+	}
+	if _, hit := getInMemory(t, m, redis.ObjectTable, "bucket:a:"); !hit {
+		t.Error("entry should be cached again after commit")
+	}
+}
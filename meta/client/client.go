@@ -1,6 +1,8 @@
 package client
 
 import (
+	"time"
+
 	"github.com/journeymidnight/yig/api/datatype"
 	. "github.com/journeymidnight/yig/meta/types"
 )
@@ -11,19 +13,39 @@ type Client interface {
 	GetAllObject(bucketName, objectName, version string) (object []*Object, err error)
 	PutObject(object *Object) error
 	DeleteObject(object *Object) error
+	UpdateObjectLocation(object *Object, oldLocation, oldPool string) (bool, error)
+	// replace content type, custom attributes and ACL of an existing object,
+	// leaving its data, location and last modified time untouched
+	UpdateObjectAttrs(object *Object) error
+	// save `part` appended to an appendable object, along with its updated
+	// size and etag, fails with ErrPositionNotEqualToLength if the object
+	// has changed since `part` is read at its offset
+	AppendObject(object *Object, part *Part, lastModified time.Time) error
+	// update SharedWith of an existing object
+	UpdateObjectSharedWith(object *Object) error
+	// update RestoreExpiryDate of an existing object, fails with
+	// ErrNoSuchKey if the object is removed or overwritten meanwhile
+	UpdateObjectRestoreExpiry(object *Object) error
+	// save EncryptionKey of an existing object, encrypted with current
+	// SSE-S3 master key
+	UpdateObjectSseKey(object *Object) error
 	//bucket
 	GetBucket(bucketName string) (bucket Bucket, err error)
 	PutBucket(bucket Bucket) error
 	CheckAndPutBucket(bucket Bucket) (bool, error)
 	DeleteBucket(bucket Bucket) error
+	// names of all buckets, at most `maxBuckets` after `marker` in lexical order
+	ListBuckets(marker string, maxBuckets int) (buckets []string, truncated bool, err error)
 	ListObjects(bucketName, marker, verIdMarker, prefix, delimiter string, versioned bool, maxKeys int) (retObjects []*Object, prefixes []string, truncated bool, nextMarker, nextVerIdMarker string, err error)
 	UpdateUsage(bucketName string, size int64)
+	CountObjects(bucketName string) (stats BucketStats, err error)
 	//multipart
 	GetMultipart(bucketName, objectName, uploadId string) (multipart Multipart, err error)
 	CreateMultipart(multipart Multipart) (err error)
 	PutObjectPart(multipart Multipart, part Part) (err error)
 	DeleteMultipart(multipart Multipart) (err error)
 	ListMultipartUploads(bucketName, keyMarker, uploadIdMarker, prefix, delimiter, encodingType string, maxUploads int) (uploads []datatype.Upload, prefixs []string, isTruncated bool, nextKeyMarker, nextUploadIdMarker string, err error)
+	CountMultipartUploads(bucketName string) (count int64, err error)
 	//objmap
 	GetObjectMap(bucketName, objectName string) (objMap *ObjMap, err error)
 	PutObjectMap(objMap *ObjMap) error
@@ -36,10 +58,15 @@ type Client interface {
 	ScanLifeCycle(limit int, marker string) (result ScanLifeCycleResult, err error)
 	//user
 	GetUserBuckets(userId string) (buckets []string, err error)
+	// at most `maxBuckets` buckets after `marker`, in lexical order
+	ListUserBuckets(userId, marker string, maxBuckets int) (buckets []string, truncated bool,
+		err error)
 	AddBucketForUser(bucketName, userId string) (err error)
 	RemoveBucketForUser(bucketName string, userId string) (err error)
 	//gc
 	PutObjectToGarbageCollection(object *Object) error
 	ScanGarbageCollection(limit int, startRowKey string) ([]GarbageCollection, error)
 	RemoveGarbageCollection(garbage GarbageCollection) error
+	//health
+	Ping(timeout time.Duration) error
 }
@@ -61,6 +61,13 @@ func (h *HbaseClient) GetBucket(bucketName string) (bucket Bucket, err error) {
 			bucket.LC = lc
 		case "ACL":
 			bucket.ACL.CannedAcl = string(cell.Value)
+		case "grants":
+			if len(cell.Value) != 0 {
+				err = json.Unmarshal(cell.Value, &bucket.ACL.Grants)
+				if err != nil {
+					return
+				}
+			}
 		case "versioning":
 			bucket.Versioning = string(cell.Value)
 		case "usage":
@@ -69,6 +76,31 @@ func (h *HbaseClient) GetBucket(bucketName string) (bucket Bucket, err error) {
 			if err != nil {
 				return
 			}
+		case "encryption":
+			err = json.Unmarshal(cell.Value, &bucket.Encryption)
+			if err != nil {
+				return
+			}
+		case "objectLock":
+			err = json.Unmarshal(cell.Value, &bucket.ObjectLock)
+			if err != nil {
+				return
+			}
+		case "payer":
+			bucket.RequestPayer = string(cell.Value)
+		case "rateLimit":
+			err = json.Unmarshal(cell.Value, &bucket.BucketRateLimit)
+			if err != nil {
+				return
+			}
+		case "gzipVariants":
+			bucket.GzipVariants = string(cell.Value) == "true"
+		case "maxObjectSize":
+			err = binary.Read(bytes.NewReader(cell.Value), binary.BigEndian,
+				&bucket.MaxObjectSize)
+			if err != nil {
+				return
+			}
 		default:
 		}
 	}
@@ -123,6 +155,38 @@ func (h *HbaseClient) DeleteBucket(bucket Bucket) error {
 	return err
 }
 
+func (h *HbaseClient) ListBuckets(marker string, maxBuckets int) (buckets []string,
+	truncated bool, err error) {
+
+	var startRow string
+	if marker != "" {
+		// smallest row after marker
+		startRow = marker + "\x00"
+	}
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	scanRequest, err := hrpc.NewScanRangeStr(ctx, BUCKET_TABLE, startRow, "",
+		hrpc.Filters(filter.NewKeyOnlyFilter(false)), hrpc.NumberOfRows(uint32(maxBuckets+1)))
+	if err != nil {
+		return
+	}
+	rows, err := h.Client.Scan(scanRequest)
+	if err != nil {
+		return
+	}
+	buckets = make([]string, 0, len(rows))
+	for _, row := range rows {
+		if len(row.Cells) == 0 {
+			continue
+		}
+		buckets = append(buckets, string(row.Cells[0].Row))
+	}
+	if len(buckets) > maxBuckets {
+		return buckets[:maxBuckets], true, nil
+	}
+	return buckets, false, nil
+}
+
 func (h *HbaseClient) UpdateUsage(bucketName string, size int64) {
 	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
 	defer done()
@@ -149,7 +213,7 @@ func (h *HbaseClient) ListObjects(bucketName, marker, verIdMarker, prefix, delim
 			err = e
 			return
 		}
-		verIdMarker = objMap.NullVerId
+		verIdMarker = objMap.GetVersionId()
 	}
 	if verIdMarker != "" {
 		var versionBytes []byte
@@ -3,19 +3,48 @@ package hbaseclient
 import (
 	"context"
 	"github.com/cannium/gohbase"
+	"github.com/cannium/gohbase/hrpc"
 	"github.com/journeymidnight/yig/helper"
+	. "github.com/journeymidnight/yig/meta/types"
+	"time"
 )
 
 var RootContext = context.Background()
 
+// A row never exists in bucket table, since "." is not a valid bucket name
+const HEALTH_CHECK_ROWKEY = "."
+
 type HbaseClient struct {
-	Client gohbase.Client
+	Client    gohbase.Client
+	resilient *resilientClient
 }
 
 func NewHbaseClient() *HbaseClient {
 	cli := &HbaseClient{}
 	znodeOption := gohbase.SetZnodeParentOption(helper.CONFIG.HbaseZnodeParent)
-	cli.Client = gohbase.NewClient(helper.CONFIG.ZookeeperAddress, znodeOption)
+	cli.resilient = newResilientClient(
+		gohbase.NewClient(helper.CONFIG.ZookeeperAddress, znodeOption))
+	cli.Client = availabilityClient{cli.resilient}
 
 	return cli
 }
+
+// Check connectivity to HBase by getting a sentinel row
+func (h *HbaseClient) Ping(timeout time.Duration) error {
+	ctx, done := context.WithTimeout(RootContext, timeout)
+	defer done()
+	getRequest, err := hrpc.NewGetStr(ctx, BUCKET_TABLE, HEALTH_CHECK_ROWKEY)
+	if err != nil {
+		return err
+	}
+	_, err = h.Client.Get(getRequest)
+	return err
+}
+
+// Counters of HBase operations, keyed by operation name
+func (h *HbaseClient) Stats() map[string]OperationStats {
+	if h.resilient == nil {
+		return nil
+	}
+	return h.resilient.stats()
+}
@@ -0,0 +1,76 @@
+package hbaseclient
+
+import (
+	"context"
+	"net"
+
+	"github.com/cannium/gohbase"
+	"github.com/cannium/gohbase/hrpc"
+	"github.com/cannium/gohbase/region"
+	. "github.com/journeymidnight/yig/error"
+)
+
+// Whether err means HBase(or zookeeper) could not be reached, in contrast
+// to errors of the request itself
+func isConnectivityError(err error) bool {
+	switch err {
+	case gohbase.ErrDeadline, region.ErrRegionUnavailable, region.ErrClientClosed,
+		context.DeadlineExceeded, context.Canceled, errTableUnavailable:
+		return true
+	}
+	switch err.(type) {
+	case region.RetryableError, region.UnrecoverableError, net.Error:
+		return true
+	}
+	return false
+}
+
+func mapConnectivityError(err error) error {
+	if err != nil && isConnectivityError(err) {
+		return ErrServiceUnavailable
+	}
+	return err
+}
+
+// availabilityClient wraps gohbase.Client and returns ErrServiceUnavailable
+// if HBase is unreachable, so clients could tell it's retryable
+type availabilityClient struct {
+	gohbase.Client
+}
+
+func (c availabilityClient) Scan(s *hrpc.Scan) ([]*hrpc.Result, error) {
+	results, err := c.Client.Scan(s)
+	return results, mapConnectivityError(err)
+}
+
+func (c availabilityClient) Get(g *hrpc.Get) (*hrpc.Result, error) {
+	result, err := c.Client.Get(g)
+	return result, mapConnectivityError(err)
+}
+
+func (c availabilityClient) Put(p *hrpc.Mutate) (*hrpc.Result, error) {
+	result, err := c.Client.Put(p)
+	return result, mapConnectivityError(err)
+}
+
+func (c availabilityClient) Delete(d *hrpc.Mutate) (*hrpc.Result, error) {
+	result, err := c.Client.Delete(d)
+	return result, mapConnectivityError(err)
+}
+
+func (c availabilityClient) Append(a *hrpc.Mutate) (*hrpc.Result, error) {
+	result, err := c.Client.Append(a)
+	return result, mapConnectivityError(err)
+}
+
+func (c availabilityClient) Increment(i *hrpc.Mutate) (int64, error) {
+	value, err := c.Client.Increment(i)
+	return value, mapConnectivityError(err)
+}
+
+func (c availabilityClient) CheckAndPut(p *hrpc.Mutate, family string, qualifier string,
+	expectedValue []byte) (bool, error) {
+
+	ok, err := c.Client.CheckAndPut(p, family, qualifier, expectedValue)
+	return ok, mapConnectivityError(err)
+}
@@ -0,0 +1,45 @@
+package hbaseclient
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/cannium/gohbase"
+	"github.com/cannium/gohbase/hrpc"
+	. "github.com/journeymidnight/yig/error"
+)
+
+// fakeHbase fails every Get with `err`, or returns an empty row
+type fakeHbase struct {
+	gohbase.Client
+	err error
+}
+
+func (f fakeHbase) Get(g *hrpc.Get) (*hrpc.Result, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &hrpc.Result{}, nil
+}
+
+func TestConnectivityErrorMapped(t *testing.T) {
+	connectionRefused := &net.OpError{Op: "dial", Net: "tcp",
+		Err: errors.New("connection refused")}
+	for _, injected := range []error{connectionRefused, gohbase.ErrDeadline} {
+		h := &HbaseClient{Client: availabilityClient{fakeHbase{err: injected}}}
+		if _, err := h.GetBucket("bucket"); err != ErrServiceUnavailable {
+			t.Errorf("%v: expected ServiceUnavailable, got %v", injected, err)
+		}
+	}
+
+	h := &HbaseClient{Client: availabilityClient{fakeHbase{}}}
+	if _, err := h.GetBucket("bucket"); err != ErrNoSuchBucket {
+		t.Errorf("logical errors should be kept, got %v", err)
+	}
+	other := errors.New("hehe")
+	h = &HbaseClient{Client: availabilityClient{fakeHbase{err: other}}}
+	if _, err := h.GetBucket("bucket"); err != other {
+		t.Errorf("unknown errors should be kept, got %v", err)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	. "github.com/journeymidnight/yig/meta/types"
 	"github.com/journeymidnight/yig/meta/util"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -112,7 +113,6 @@ func (h *HbaseClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker
 			return
 		}
 		startRowkey.WriteString(keyMarker)
-		stopKey = helper.CopiedBytes(startRowkey.Bytes())
 		if uploadIdMarker != "" {
 			var timestampString string
 			timestampString, err = util.Decrypt(uploadIdMarker)
@@ -133,7 +133,7 @@ func (h *HbaseClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker
 	stopKey[len(stopKey)-1]++
 
 	comparator := filter.NewRegexStringComparator(
-		"^"+bucketName+".."+prefix+".*"+".{8}"+"$",
+		multipartRowkeyRegex(bucketName, prefix),
 		0x20, // Dot-all mode
 		"ISO-8859-1",
 		"JAVA", // regexp engine name, in `JAVA` or `JONI`
@@ -141,96 +141,125 @@ func (h *HbaseClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker
 	compareFilter := filter.NewCompareFilter(filter.Equal, comparator)
 	rowFilter := filter.NewRowFilter(compareFilter)
 
-	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
-	defer done()
-	scanRequest, err := hrpc.NewScanRangeStr(ctx, MULTIPART_TABLE,
-		startRowkey.String(), string(stopKey), hrpc.Filters(rowFilter),
-		// scan for max+1 rows to determine if results are truncated
-		hrpc.NumberOfRows(uint32(maxUploads+1)))
-	if err != nil {
-		return
-	}
-	scanResponse, err := h.Client.Scan(scanRequest)
-	if err != nil {
-		return
-	}
-
-	if len(scanResponse) > maxUploads {
-		isTruncated = true
-		var nextUpload Multipart
-		nextUpload, err = MultipartFromResponse(scanResponse[maxUploads], bucketName)
+	uploads = make([]datatype.Upload, 0)
+	collector := util.NewListCollector(maxUploads)
+	startRow := startRowkey.String()
+	for {
+		// scan for max+1 rows, uploads under collected prefixes are
+		// skipped so more batches may be needed to fill a page
+		var scanResponse []*hrpc.Result
+		scanResponse, err = h.scanMultipart(startRow, string(stopKey), rowFilter, maxUploads+1)
 		if err != nil {
 			return
 		}
-		nextKeyMarker = nextUpload.ObjectName
-		nextUploadIdMarker, err = nextUpload.GetUploadId()
-		if err != nil {
-			return
+		for _, row := range scanResponse {
+			var m Multipart
+			m, err = MultipartFromResponse(row, bucketName)
+			if err != nil {
+				return
+			}
+			if prefixKey := util.CommonPrefix(m.ObjectName, prefix, delimiter); prefixKey != "" {
+				if collector.Seen(prefixKey) {
+					continue
+				}
+				if !collector.TakePrefix(prefixKey) {
+					isTruncated = true
+					nextKeyMarker = m.ObjectName
+					nextUploadIdMarker, err = m.GetUploadId()
+					prefixs = collector.Prefixes()
+					return
+				}
+				continue
+			}
+			if !collector.TakeKey() {
+				isTruncated = true
+				nextKeyMarker = m.ObjectName
+				nextUploadIdMarker, err = m.GetUploadId()
+				prefixs = collector.Prefixes()
+				return
+			}
+			var upload datatype.Upload
+			upload, err = uploadFromMultipart(m, encodingType)
+			if err != nil {
+				return
+			}
+			uploads = append(uploads, upload)
+		}
+		if len(scanResponse) <= maxUploads {
+			break
 		}
-		scanResponse = scanResponse[:maxUploads]
+		// start row is included in scan result, continue right after
+		// the last scanned row
+		startRow = string(scanResponse[len(scanResponse)-1].Cells[0].Row) + "\x00"
 	}
+	prefixs = collector.Prefixes()
+	return
+}
 
-	var currentLevel int
-	if delimiter == "" {
-		currentLevel = 0
-	} else {
-		currentLevel = strings.Count(prefix, delimiter)
+// Regex of multipart row keys, i.e. bucket name, 2 bytes of object name
+// depth, object name and 8 bytes of upload time, of objects in `bucketName`
+// whose names start with `prefix`. Both are user input so regex
+// metacharacters in them are escaped
+func multipartRowkeyRegex(bucketName, prefix string) string {
+	return "^" + regexp.QuoteMeta(latin1(bucketName)) + ".." +
+		regexp.QuoteMeta(latin1(prefix)) + ".*" + ".{8}" + "$"
+}
+
+// Row keys are decoded as ISO-8859-1 by the regex comparator while the
+// pattern is sent as UTF-8, so bytes of names are sent as the characters
+// they decode to, one for each byte
+func latin1(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
 	}
+	return string(runes)
+}
 
-	uploads = make([]datatype.Upload, 0, len(scanResponse))
-	prefixMap := make(map[string]int) // value is dummy, only need a set here
-	for _, row := range scanResponse {
-		var m Multipart
-		m, err = MultipartFromResponse(row, bucketName)
-		if err != nil {
-			return
-		}
-		upload := datatype.Upload{
-			StorageClass: "STANDARD",
-			Initiated:    m.InitialTime.UTC().Format(CREATE_TIME_LAYOUT),
-		}
-		if delimiter == "" {
-			upload.Key = m.ObjectName
-		} else {
-			level := strings.Count(m.ObjectName, delimiter)
-			if level > currentLevel {
-				split := strings.Split(m.ObjectName, delimiter)
-				split = split[:currentLevel+1]
-				prefix := strings.Join(split, delimiter) + delimiter
-				prefixMap[prefix] = 1
-				continue
-			} else {
-				upload.Key = m.ObjectName
-			}
-		}
-		//upload.Key = strings.TrimPrefix(upload.Key, prefix)
-		if encodingType != "" { // only support "url" encoding for now
-			upload.Key = url.QueryEscape(upload.Key)
-		}
-		upload.UploadId, err = m.GetUploadId()
-		if err != nil {
-			return
-		}
+func (h *HbaseClient) scanMultipart(startRow, stopRow string, rowFilter filter.Filter,
+	limit int) ([]*hrpc.Result, error) {
 
-		var user iam.Credential
-		user, err = iam.GetCredentialByUserId(m.Metadata.OwnerId)
-		if err != nil {
-			return
-		}
-		upload.Owner.ID = user.UserId
-		upload.Owner.DisplayName = user.DisplayName
-		user, err = iam.GetCredentialByUserId(m.Metadata.InitiatorId)
-		if err != nil {
-			return
-		}
-		upload.Initiator.ID = user.UserId
-		upload.Initiator.DisplayName = user.DisplayName
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	scanRequest, err := hrpc.NewScanRangeStr(ctx, MULTIPART_TABLE, startRow, stopRow,
+		hrpc.Filters(rowFilter), hrpc.NumberOfRows(uint32(limit)))
+	if err != nil {
+		return nil, err
+	}
+	return h.Client.Scan(scanRequest)
+}
 
-		uploads = append(uploads, upload)
+func uploadFromMultipart(m Multipart, encodingType string) (upload datatype.Upload, err error) {
+	upload = datatype.Upload{
+		Key:          m.ObjectName,
+		StorageClass: m.Metadata.StorageClass,
+		Initiated:    m.InitialTime.UTC().Format(CREATE_TIME_LAYOUT),
+	}
+	// uploads initiated before storage classes are supported
+	if upload.StorageClass == "" {
+		upload.StorageClass = datatype.STORAGE_CLASS_STANDARD
+	}
+	if encodingType != "" { // only support "url" encoding for now
+		upload.Key = url.QueryEscape(upload.Key)
+	}
+	upload.UploadId, err = m.GetUploadId()
+	if err != nil {
+		return
 	}
-	uploads = uploads
 
-	prefixs = helper.Keys(prefixMap)
+	var user iam.Credential
+	user, err = iam.GetCredentialByUserId(m.Metadata.OwnerId)
+	if err != nil {
+		return
+	}
+	upload.Owner.ID = user.UserId
+	upload.Owner.DisplayName = user.DisplayName
+	user, err = iam.GetCredentialByUserId(m.Metadata.InitiatorId)
+	if err != nil {
+		return
+	}
+	upload.Initiator.ID = user.UserId
+	upload.Initiator.DisplayName = user.DisplayName
 	return
 }
 
@@ -3,13 +3,12 @@ package hbaseclient
 import (
 	"bytes"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"github.com/cannium/gohbase/filter"
 	"github.com/cannium/gohbase/hrpc"
+	"github.com/journeymidnight/yig/api/datatype"
 	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	. "github.com/journeymidnight/yig/meta/types"
@@ -17,6 +16,7 @@ import (
 	"github.com/xxtea/xxtea-go/xxtea"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -26,28 +26,47 @@ func (h *HbaseClient) GetObject(bucketName, objectName, version string) (object
 		return
 	}
 	prefixFilter := filter.NewPrefixFilter(objectRowkeyPrefix)
+	startKey := objectRowkeyPrefix
 	stopKey := helper.CopiedBytes(objectRowkeyPrefix)
 	stopKey[len(stopKey)-1]++
 	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
 	defer done()
 
-	scanRequest, err := hrpc.NewScanRangeStr(ctx, OBJECT_TABLE,
-		string(objectRowkeyPrefix), string(stopKey),
-		hrpc.Filters(prefixFilter), hrpc.NumberOfRows(1))
-	if err != nil {
-		return
-	}
-	scanResponse, err := h.Client.Scan(scanRequest)
-	if err != nil {
-		return
-	}
-	helper.Debugln("GetObject scanResponse length:", len(scanResponse))
-	if len(scanResponse) == 0 {
-		err = ErrNoSuchKey
-		return
+	for {
+		var scanRequest *hrpc.Scan
+		scanRequest, err = hrpc.NewScanRangeStr(ctx, OBJECT_TABLE,
+			string(startKey), string(stopKey),
+			hrpc.Filters(prefixFilter), hrpc.NumberOfRows(1))
+		if err != nil {
+			return
+		}
+		var scanResponse []*hrpc.Result
+		scanResponse, err = h.Client.Scan(scanRequest)
+		if err != nil {
+			return
+		}
+		helper.Debugln("GetObject scanResponse length:", len(scanResponse))
+		if len(scanResponse) == 0 || len(scanResponse[0].Cells) == 0 {
+			err = ErrNoSuchKey
+			return
+		}
+		// rows of objects named before ObjectNameSeparator was rejected,
+		// e.g. "a\n...", also match the prefix of "a", skip them
+		rowkey := scanResponse[0].Cells[0].Row
+		if helper.CONFIG.StrictObjectRowkey && version == "" &&
+			len(rowkey) != len(objectRowkeyPrefix)+8 {
+
+			startKey = append(helper.CopiedBytes(rowkey), 0)
+			continue
+		}
+		// a version is only matched by its own row, which sorts before the
+		// colliding ones above
+		if version != "" && len(rowkey) != len(objectRowkeyPrefix) {
+			err = ErrNoSuchKey
+			return
+		}
+		return ObjectFromResponse(scanResponse[0])
 	}
-	object, err = ObjectFromResponse(scanResponse[0])
-	return
 }
 
 func (h *HbaseClient) GetAllObject(bucketName, objectName, version string) (object []*Object, err error) {
@@ -56,12 +75,11 @@ func (h *HbaseClient) GetAllObject(bucketName, objectName, version string) (obje
 	if err != nil {
 		return nil, err
 	}
-	var exit bool
 	startRowkey := objectRowkeyPrefix
 	stopKey := helper.CopiedBytes(objectRowkeyPrefix)
 	stopKey[len(stopKey)-1]++
 	prefixFilter := filter.NewPrefixFilter(objectRowkeyPrefix)
-	for !exit {
+	for {
 		ctx, _ := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
 		//defer done() // TODO:
 
@@ -88,18 +106,14 @@ func (h *HbaseClient) GetAllObject(bucketName, objectName, version string) (obje
 				helper.Logger.Printf(5, "Error converting response to object, err:", err)
 				return nil, ErrInternalError
 			}
+			startRowkey = helper.CopiedBytes(obj.Cells[0].Row)
+			helper.Logger.Println(20, "GetAllObject(): Row key:", startRowkey)
+			// rows of objects named "a\n..." share the prefix with
+			// versions of "a", and may sort among them
 			if object.Name != objectName {
-				exit = true
-				break
+				continue
 			}
 			objs = append(objs, object)
-			strRowkey, err := object.GetRowkey()
-			if err != nil {
-				helper.Logger.Printf(5, "Error getting row key for object, err:", err)
-				return nil, ErrInternalError
-			}
-			startRowkey = []byte(strRowkey)
-			helper.Logger.Println(20, "GetAllObject(): Row key:", startRowkey)
 		}
 		startRowkey[len(startRowkey)-1]++
 		if len(scanResponse) != ResponseNumberOfRows {
@@ -134,6 +148,190 @@ func (h *HbaseClient) PutObject(object *Object) error {
 	return err
 }
 
+// Update `location`, `pool` and object ids of an existing object, only if
+// its current location/pool still equals to oldLocation/oldPool. Other
+// columns are left alone, they may be changed since `object` is read
+func (h *HbaseClient) UpdateObjectLocation(object *Object, oldLocation, oldPool string) (bool, error) {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return false, err
+	}
+	values, err := object.GetLocationValues()
+	if err != nil {
+		return false, err
+	}
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	put, err := hrpc.NewPutStr(ctx, OBJECT_TABLE, rowkey, values)
+	if err != nil {
+		return false, err
+	}
+	// CheckAndPut could only compare one column, so check the one that
+	// is changed by this update
+	qualifier, expected := "location", oldLocation
+	if object.Location == oldLocation {
+		qualifier, expected = "pool", oldPool
+	}
+	processed, err := h.Client.CheckAndPut(put, OBJECT_COLUMN_FAMILY,
+		qualifier, []byte(expected))
+	return processed, err
+}
+
+// Update metadata columns of an existing object, fails with ErrNoSuchKey if
+// the object is removed or overwritten meanwhile. Last modified time is
+// kept, as it's part of the rowkey
+func (h *HbaseClient) UpdateObjectAttrs(object *Object) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	values, err := object.GetAttrValues()
+	if err != nil {
+		return err
+	}
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	put, err := hrpc.NewPutStr(ctx, OBJECT_TABLE, rowkey, values)
+	if err != nil {
+		return err
+	}
+	// so a removed object won't be brought back as a row of metadata only
+	processed, err := h.Client.CheckAndPut(put, OBJECT_COLUMN_FAMILY,
+		"etag", []byte(object.Etag))
+	if err != nil {
+		return err
+	}
+	if !processed {
+		return ErrNoSuchKey
+	}
+	return nil
+}
+
+func (h *HbaseClient) UpdateObjectSharedWith(object *Object) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	values := map[string]map[string][]byte{
+		OBJECT_COLUMN_FAMILY: map[string][]byte{
+			"sharedWith": []byte(object.SharedWith),
+		},
+	}
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	put, err := hrpc.NewPutStr(ctx, OBJECT_TABLE, rowkey, values)
+	if err != nil {
+		return err
+	}
+	processed, err := h.Client.CheckAndPut(put, OBJECT_COLUMN_FAMILY,
+		"etag", []byte(object.Etag))
+	if err != nil {
+		return err
+	}
+	if !processed {
+		return ErrNoSuchKey
+	}
+	return nil
+}
+
+// Conditioned on etag as UpdateObjectSharedWith
+func (h *HbaseClient) UpdateObjectRestoreExpiry(object *Object) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	var restoreExpiry []byte
+	if !object.RestoreExpiryDate.IsZero() {
+		restoreExpiry = []byte(strconv.FormatInt(object.RestoreExpiryDate.Unix(), 10))
+	}
+	values := map[string]map[string][]byte{
+		OBJECT_COLUMN_FAMILY: map[string][]byte{
+			"restoreExpiry": restoreExpiry,
+		},
+	}
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	put, err := hrpc.NewPutStr(ctx, OBJECT_TABLE, rowkey, values)
+	if err != nil {
+		return err
+	}
+	processed, err := h.Client.CheckAndPut(put, OBJECT_COLUMN_FAMILY,
+		"etag", []byte(object.Etag))
+	if err != nil {
+		return err
+	}
+	if !processed {
+		return ErrNoSuchKey
+	}
+	return nil
+}
+
+// Conditioned on etag as UpdateObjectSharedWith, so a removed object isn't
+// brought back
+func (h *HbaseClient) UpdateObjectSseKey(object *Object) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	encryptionKey, err := EncryptSseKey(object.InitializationVector, object.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	values := map[string]map[string][]byte{
+		OBJECT_COLUMN_FAMILY: map[string][]byte{
+			"encryptionKey": encryptionKey,
+		},
+	}
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	put, err := hrpc.NewPutStr(ctx, OBJECT_TABLE, rowkey, values)
+	if err != nil {
+		return err
+	}
+	processed, err := h.Client.CheckAndPut(put, OBJECT_COLUMN_FAMILY,
+		"etag", []byte(object.Etag))
+	if err != nil {
+		return err
+	}
+	if !processed {
+		return ErrNoSuchKey
+	}
+	return nil
+}
+
+// Conditioned on the size column being offset of `part`, so only one of
+// appends at the same position succeeds
+func (h *HbaseClient) AppendObject(object *Object, part *Part, lastModified time.Time) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	values, err := object.GetAppendValues(part, lastModified)
+	if err != nil {
+		return err
+	}
+	var size bytes.Buffer
+	err = binary.Write(&size, binary.BigEndian, part.Offset)
+	if err != nil {
+		return err
+	}
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	put, err := hrpc.NewPutStr(ctx, OBJECT_TABLE, rowkey, values)
+	if err != nil {
+		return err
+	}
+	processed, err := h.Client.CheckAndPut(put, OBJECT_COLUMN_FAMILY,
+		"size", size.Bytes())
+	if err != nil {
+		return err
+	}
+	if !processed {
+		return ErrPositionNotEqualToLength
+	}
+	return nil
+}
+
 func (h *HbaseClient) DeleteObject(object *Object) error {
 	rowkeyToDelete, err := object.GetRowkey()
 	if err != nil {
@@ -156,6 +354,9 @@ func (h *HbaseClient) DeleteObject(object *Object) error {
 // bigEndian(uint64.max - unixNanoTimestamp)
 // The prefix excludes timestamp part if version is empty
 func getObjectRowkeyPrefix(bucketName string, objectName string, version string) ([]byte, error) {
+	if strings.Contains(objectName, ObjectNameSeparator) {
+		return []byte{}, ErrNoSuchKey
+	}
 	var rowkey bytes.Buffer
 	rowkey.WriteString(bucketName + ObjectNameSeparator)
 	rowkey.WriteString(objectName + ObjectNameSeparator)
@@ -215,6 +416,13 @@ func ObjectFromResponse(response *hrpc.Result) (object *Object, err error) {
 				object.ContentType = string(cell.Value)
 			case "ACL":
 				object.ACL.CannedAcl = string(cell.Value)
+			case "grants":
+				if len(cell.Value) != 0 {
+					err = json.Unmarshal(cell.Value, &object.ACL.Grants)
+					if err != nil {
+						return
+					}
+				}
 			case "nullVersion":
 				object.NullVersion = helper.Ternary(string(cell.Value) == "true",
 					true, false).(bool)
@@ -227,6 +435,54 @@ func ObjectFromResponse(response *hrpc.Result) (object *Object, err error) {
 				object.EncryptionKey = cell.Value
 			case "IV":
 				object.InitializationVector = cell.Value
+			case "expireTime":
+				if len(cell.Value) != 0 {
+					var expireTime int64
+					expireTime, err = strconv.ParseInt(string(cell.Value), 10, 64)
+					if err != nil {
+						return
+					}
+					object.ExpireTime = time.Unix(expireTime, 0)
+				}
+			case "retentionMode":
+				object.RetentionMode = string(cell.Value)
+			case "retainUntil":
+				if len(cell.Value) != 0 {
+					var retainUntil int64
+					retainUntil, err = strconv.ParseInt(string(cell.Value), 10, 64)
+					if err != nil {
+						return
+					}
+					object.RetainUntilDate = time.Unix(retainUntil, 0)
+				}
+			case "legalHold":
+				object.LegalHold = string(cell.Value) == "true"
+			case "appendable":
+				object.Appendable = string(cell.Value) == "true"
+			case "storageClass":
+				object.StorageClass = string(cell.Value)
+			case "sharedWith":
+				object.SharedWith = string(cell.Value)
+			case "checksumAlgorithm":
+				object.ChecksumAlgorithm = string(cell.Value)
+			case "checksum":
+				object.Checksum = string(cell.Value)
+			case "replicas":
+				if len(cell.Value) != 0 {
+					err = json.Unmarshal(cell.Value, &object.Replicas)
+					if err != nil {
+						return
+					}
+				}
+			case "restoreExpiry":
+				if len(cell.Value) != 0 {
+					var restoreExpiry int64
+					restoreExpiry, err = strconv.ParseInt(string(cell.Value), 10, 64)
+					if err != nil {
+						return
+					}
+					object.RestoreExpiryDate = time.Unix(restoreExpiry, 0)
+				}
 			case "attributes":
 				if len(cell.Value) != 0 {
 					var attrs map[string]string
@@ -262,9 +518,13 @@ func ObjectFromResponse(response *hrpc.Result) (object *Object, err error) {
 		}
 		object.PartsIndex = &SimpleIndex{Index: sortedPartNum}
 	}
+	// rows written before storage classes are supported
+	if object.StorageClass == "" {
+		object.StorageClass = datatype.STORAGE_CLASS_STANDARD
+	}
 
 	// To decrypt encryption key, we need to know IV first
-	object.EncryptionKey, err = decryptSseKey(object.InitializationVector, object.EncryptionKey)
+	object.EncryptionKey, err = DecryptSseKey(object.InitializationVector, object.EncryptionKey)
 	if err != nil {
 		return
 	}
@@ -288,23 +548,3 @@ func ObjectFromResponse(response *hrpc.Result) (object *Object, err error) {
 	helper.Debugln("ObjectFromResponse:", object)
 	return
 }
-
-func decryptSseKey(initializationVector []byte, cipherText []byte) (plainText []byte, err error) {
-	if len(cipherText) == 0 {
-		return
-	}
-
-	block, err := aes.NewCipher(SSE_S3_MASTER_KEY)
-	if err != nil {
-		return
-	}
-
-	aesGcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return
-	}
-
-	// InitializationVector is 16 bytes(because of CTR), but use only first 12 bytes in GCM
-	// for performance
-	return aesGcm.Open(nil, initializationVector[:12], cipherText, nil)
-}
@@ -0,0 +1,108 @@
+package hbaseclient
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cannium/gohbase"
+	"github.com/cannium/gohbase/hrpc"
+	. "github.com/journeymidnight/yig/error"
+	. "github.com/journeymidnight/yig/meta/types"
+)
+
+// casHbase records CheckAndPut requests and processes them if `exists`
+type casHbase struct {
+	gohbase.Client
+	exists    bool
+	qualifier string
+	expected  string
+	mutation  []byte
+}
+
+func (f *casHbase) CheckAndPut(p *hrpc.Mutate, family string, qualifier string,
+	expectedValue []byte) (bool, error) {
+
+	f.qualifier = family + ":" + qualifier
+	f.expected = string(expectedValue)
+	f.mutation, _ = p.Serialize()
+	return f.exists, nil
+}
+
+func TestUpdateObjectAttrs(t *testing.T) {
+	object := &Object{
+		BucketName:       "bucket",
+		Name:             "object",
+		Location:         "hehe-location",
+		Pool:             "hehe-pool",
+		ObjectId:         "hehe-oid",
+		LastModifiedTime: time.Now().Add(-time.Hour),
+		Etag:             "0123456789abcdef",
+		ContentType:      "text/hehe",
+		CustomAttributes: map[string]string{"Cache-Control": "no-cache"},
+	}
+	fake := &casHbase{exists: true}
+	h := &HbaseClient{Client: fake}
+	if err := h.UpdateObjectAttrs(object); err != nil {
+		t.Fatal(err)
+	}
+	if fake.qualifier != OBJECT_COLUMN_FAMILY+":etag" || fake.expected != object.Etag {
+		t.Errorf("put should be checked against etag, got %s=%s", fake.qualifier, fake.expected)
+	}
+	for _, column := range []string{"content-type", "text/hehe", "attributes", "no-cache"} {
+		if !bytes.Contains(fake.mutation, []byte(column)) {
+			t.Errorf("%s is not updated", column)
+		}
+	}
+	// data and location are left untouched
+	for _, column := range []string{"hehe-location", "hehe-pool", "hehe-oid", "size",
+		"lastModified"} {
+		if bytes.Contains(fake.mutation, []byte(column)) {
+			t.Errorf("%s should not be updated", column)
+		}
+	}
+
+	fake.exists = false
+	if err := h.UpdateObjectAttrs(object); err != ErrNoSuchKey {
+		t.Errorf("expected ErrNoSuchKey for removed object, got %v", err)
+	}
+}
+
+func TestAppendObject(t *testing.T) {
+	object := &Object{
+		BucketName:       "bucket",
+		Name:             "object",
+		LastModifiedTime: time.Now().Add(-time.Hour),
+		Size:             30,
+		Etag:             "0123456789abcdef",
+		Appendable:       true,
+	}
+	part := &Part{
+		PartNumber: 2,
+		Size:       20,
+		ObjectId:   "hehe-oid",
+		Offset:     10,
+		Etag:       "fedcba9876543210",
+	}
+	fake := &casHbase{exists: true}
+	h := &HbaseClient{Client: fake}
+	if err := h.AppendObject(object, part, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// appends at the same position race on size of the object
+	if fake.qualifier != OBJECT_COLUMN_FAMILY+":size" ||
+		fake.expected != "\x00\x00\x00\x00\x00\x00\x00\x0a" {
+		t.Errorf("put should be checked against size 10, got %s=%q", fake.qualifier,
+			fake.expected)
+	}
+	for _, column := range []string{"0123456789abcdef", "hehe-oid", "fedcba9876543210"} {
+		if !bytes.Contains(fake.mutation, []byte(column)) {
+			t.Errorf("%s is not updated", column)
+		}
+	}
+
+	fake.exists = false
+	if err := h.AppendObject(object, part, time.Now()); err != ErrPositionNotEqualToLength {
+		t.Errorf("expected ErrPositionNotEqualToLength for stale position, got %v", err)
+	}
+}
@@ -4,13 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
-	"encoding/hex"
 	"github.com/cannium/gohbase/hrpc"
 	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	. "github.com/journeymidnight/yig/meta/types"
-	"github.com/xxtea/xxtea-go/xxtea"
-	"strconv"
 )
 
 func (h *HbaseClient) GetObjectMap(bucketName, objectName string) (objMap *ObjMap, err error) {
@@ -89,8 +86,7 @@ func ObjMapFromResponse(response *hrpc.Result) (objMap *ObjMap, err error) {
 			}
 		}
 	}
-	timeData := []byte(strconv.FormatUint(objMap.NullVerNum, 10))
-	objMap.NullVerId = hex.EncodeToString(xxtea.Encrypt(timeData, XXTEA_KEY))
+	objMap.NullVerId = objMap.GetVersionId()
 	//helper.Debugln("ObjectFromResponse:", objMap)
 	return
 }
@@ -0,0 +1,198 @@
+package hbaseclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cannium/gohbase"
+	"github.com/cannium/gohbase/hrpc"
+	"github.com/journeymidnight/yig/helper"
+)
+
+// Returned without sending requests when a table is considered down
+var errTableUnavailable = errors.New("HBase table is unavailable")
+
+// Counters of an operation(Get, Scan, Put, etc.) since startup
+type OperationStats struct {
+	Calls    int64
+	Errors   int64
+	Retries  int64
+	Rejected int64 // failed fast by circuit breaker
+	// in microseconds, including retries
+	AverageLatency int64
+}
+
+type operationCounters struct {
+	calls, errors, retries, rejected, latency int64
+}
+
+// resilientClient wraps gohbase.Client, retries idempotent requests failed
+// by connectivity errors with exponential backoff, and fails fast once a
+// table keeps failing. gohbase already retries on region moves without
+// delay, retries here wait for regions to be reassigned.
+type resilientClient struct {
+	client   gohbase.Client
+	lock     sync.Mutex
+	breakers map[string]*helper.CircuitBreaker // table -> breaker
+	counters map[string]*operationCounters     // operation -> counters
+}
+
+func newResilientClient(client gohbase.Client) *resilientClient {
+	c := &resilientClient{
+		client:   client,
+		breakers: make(map[string]*helper.CircuitBreaker),
+		counters: make(map[string]*operationCounters),
+	}
+	for _, operation := range []string{"Scan", "Get", "Put", "Delete",
+		"Append", "Increment", "CheckAndPut"} {
+		c.counters[operation] = new(operationCounters)
+	}
+	return c
+}
+
+func (c *resilientClient) breakerOf(table []byte) *helper.CircuitBreaker {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	b, ok := c.breakers[string(table)]
+	if !ok {
+		b = helper.NewCircuitBreaker(helper.CONFIG.HbaseFailureThreshold,
+			helper.CONFIG.HbaseRetryInterval)
+		c.breakers[string(table)] = b
+	}
+	return b
+}
+
+// Whether a failed request could succeed if sent again later, requests
+// running out of time are not
+func isRetryable(err error) bool {
+	switch err {
+	case gohbase.ErrDeadline, context.DeadlineExceeded, context.Canceled:
+		return false
+	}
+	return isConnectivityError(err)
+}
+
+// Send `rpc` by `send`, retry at most HbaseRetryCount times if `idempotent`
+func (c *resilientClient) call(operation string, rpc hrpc.RpcCall, idempotent bool,
+	send func() error) (err error) {
+
+	counters := c.counters[operation]
+	atomic.AddInt64(&counters.calls, 1)
+	breaker := c.breakerOf(rpc.Table())
+	if !breaker.Allow() {
+		atomic.AddInt64(&counters.rejected, 1)
+		return errTableUnavailable
+	}
+	start := time.Now()
+	backoff := helper.CONFIG.HbaseRetryBackoff
+	for i := 0; ; i++ {
+		err = send()
+		if err == nil || !idempotent || i >= helper.CONFIG.HbaseRetryCount ||
+			!isRetryable(err) {
+			break
+		}
+		helper.Logger.Println(5, "Retry HBase", operation, "on table",
+			string(rpc.Table()), "after error:", err)
+		atomic.AddInt64(&counters.retries, 1)
+		select {
+		case <-time.After(backoff):
+		case <-rpc.Context().Done():
+			err = gohbase.ErrDeadline
+		}
+		if err == gohbase.ErrDeadline {
+			break
+		}
+		backoff *= 2
+	}
+	atomic.AddInt64(&counters.latency, int64(time.Since(start)/time.Microsecond))
+	breaker.Record(err != nil && isConnectivityError(err))
+	if err != nil {
+		atomic.AddInt64(&counters.errors, 1)
+	}
+	return err
+}
+
+func (c *resilientClient) stats() map[string]OperationStats {
+	stats := make(map[string]OperationStats)
+	for operation, counters := range c.counters {
+		s := OperationStats{
+			Calls:    atomic.LoadInt64(&counters.calls),
+			Errors:   atomic.LoadInt64(&counters.errors),
+			Retries:  atomic.LoadInt64(&counters.retries),
+			Rejected: atomic.LoadInt64(&counters.rejected),
+		}
+		if sent := s.Calls - s.Rejected; sent > 0 {
+			s.AverageLatency = atomic.LoadInt64(&counters.latency) / sent
+		}
+		stats[operation] = s
+	}
+	return stats
+}
+
+func (c *resilientClient) Scan(s *hrpc.Scan) (results []*hrpc.Result, err error) {
+	err = c.call("Scan", s, true, func() (err error) {
+		results, err = c.client.Scan(s)
+		return err
+	})
+	return
+}
+
+func (c *resilientClient) Get(g *hrpc.Get) (result *hrpc.Result, err error) {
+	err = c.call("Get", g, true, func() (err error) {
+		result, err = c.client.Get(g)
+		return err
+	})
+	return
+}
+
+// Writing the same cells again is harmless
+func (c *resilientClient) Put(p *hrpc.Mutate) (result *hrpc.Result, err error) {
+	err = c.call("Put", p, true, func() (err error) {
+		result, err = c.client.Put(p)
+		return err
+	})
+	return
+}
+
+func (c *resilientClient) Delete(d *hrpc.Mutate) (result *hrpc.Result, err error) {
+	err = c.call("Delete", d, true, func() (err error) {
+		result, err = c.client.Delete(d)
+		return err
+	})
+	return
+}
+
+// Appending or incrementing twice is not, the first try may have succeeded
+func (c *resilientClient) Append(a *hrpc.Mutate) (result *hrpc.Result, err error) {
+	err = c.call("Append", a, false, func() (err error) {
+		result, err = c.client.Append(a)
+		return err
+	})
+	return
+}
+
+func (c *resilientClient) Increment(i *hrpc.Mutate) (value int64, err error) {
+	err = c.call("Increment", i, false, func() (err error) {
+		value, err = c.client.Increment(i)
+		return err
+	})
+	return
+}
+
+// A retry would see the value put by the first try and fail
+func (c *resilientClient) CheckAndPut(p *hrpc.Mutate, family string, qualifier string,
+	expectedValue []byte) (ok bool, err error) {
+
+	err = c.call("CheckAndPut", p, false, func() (err error) {
+		ok, err = c.client.CheckAndPut(p, family, qualifier, expectedValue)
+		return err
+	})
+	return
+}
+
+func (c *resilientClient) Close() {
+	c.client.Close()
+}
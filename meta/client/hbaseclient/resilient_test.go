@@ -0,0 +1,184 @@
+package hbaseclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cannium/gohbase"
+	"github.com/cannium/gohbase/hrpc"
+	"github.com/cannium/gohbase/region"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+	. "github.com/journeymidnight/yig/meta/types"
+)
+
+func init() {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	helper.CONFIG.HbaseTimeout = time.Second
+	helper.CONFIG.HbaseRetryCount = 2
+	helper.CONFIG.HbaseRetryBackoff = time.Millisecond
+	helper.CONFIG.HbaseFailureThreshold = 3
+	helper.CONFIG.HbaseRetryInterval = time.Hour
+}
+
+// what gohbase returns when a region moves until it's reassigned
+var errRegionMoved = region.ErrRegionUnavailable
+
+var errConnectionReset = &net.OpError{Op: "read", Net: "tcp",
+	Err: errors.New("connection reset by peer")}
+
+// flakyHbase fails requests with errors from `errs` in turn, then succeeds
+type flakyHbase struct {
+	gohbase.Client
+	lock  sync.Mutex
+	errs  []error
+	sent  int
+	delay time.Duration
+}
+
+func (f *flakyHbase) next() error {
+	time.Sleep(f.delay)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sent += 1
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *flakyHbase) Get(g *hrpc.Get) (*hrpc.Result, error) {
+	if err := f.next(); err != nil {
+		return nil, err
+	}
+	return &hrpc.Result{}, nil
+}
+
+func (f *flakyHbase) Put(p *hrpc.Mutate) (*hrpc.Result, error) {
+	if err := f.next(); err != nil {
+		return nil, err
+	}
+	return &hrpc.Result{}, nil
+}
+
+func (f *flakyHbase) CheckAndPut(p *hrpc.Mutate, family string, qualifier string,
+	expectedValue []byte) (bool, error) {
+
+	if err := f.next(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func newGet(t *testing.T, ctx context.Context, table string) *hrpc.Get {
+	g, err := hrpc.NewGetStr(ctx, table, "row")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestRetryIdempotentRequests(t *testing.T) {
+	fake := &flakyHbase{errs: []error{errRegionMoved, errConnectionReset}}
+	c := newResilientClient(fake)
+	if _, err := c.Get(newGet(t, context.Background(), "t")); err != nil {
+		t.Fatalf("Get should succeed after retries, got %v", err)
+	}
+	if fake.sent != 3 || c.stats()["Get"].Retries != 2 {
+		t.Errorf("expected 2 retries, sent %d times", fake.sent)
+	}
+
+	fake = &flakyHbase{errs: []error{errRegionMoved, errRegionMoved, errRegionMoved}}
+	c = newResilientClient(fake)
+	put, _ := hrpc.NewPutStr(context.Background(), "t", "row",
+		map[string]map[string][]byte{"f": {"q": []byte("v")}})
+	if _, err := c.Put(put); err != errRegionMoved {
+		t.Errorf("Put should fail after retries used up, got %v", err)
+	}
+	if fake.sent != 3 || c.stats()["Put"].Errors != 1 {
+		t.Errorf("expected 2 retries, sent %d times", fake.sent)
+	}
+}
+
+func TestNoRetryForUnsafeRequests(t *testing.T) {
+	fake := &flakyHbase{errs: []error{errRegionMoved}}
+	c := newResilientClient(fake)
+	put, _ := hrpc.NewPutStr(context.Background(), "t", "row",
+		map[string]map[string][]byte{"f": {"q": []byte("v")}})
+	if _, err := c.CheckAndPut(put, "f", "q", nil); err != errRegionMoved {
+		t.Errorf("CheckAndPut should not be retried, got %v", err)
+	}
+
+	// timed out requests have no time left to retry
+	fake = &flakyHbase{errs: []error{gohbase.ErrDeadline}}
+	c = newResilientClient(fake)
+	if _, err := c.Get(newGet(t, context.Background(), "t")); err != gohbase.ErrDeadline {
+		t.Errorf("timed out Get should not be retried, got %v", err)
+	}
+	if fake.sent != 1 {
+		t.Errorf("sent %d times", fake.sent)
+	}
+
+	other := errors.New("hehe")
+	fake = &flakyHbase{errs: []error{other}}
+	c = newResilientClient(fake)
+	if _, err := c.Get(newGet(t, context.Background(), "t")); err != other || fake.sent != 1 {
+		t.Errorf("request errors should not be retried, got %v", err)
+	}
+}
+
+func TestRetryWithinTimeout(t *testing.T) {
+	fake := &flakyHbase{errs: []error{errRegionMoved, errRegionMoved, errRegionMoved}}
+	c := newResilientClient(fake)
+	backoff := helper.CONFIG.HbaseRetryBackoff
+	helper.CONFIG.HbaseRetryBackoff = time.Hour
+	defer func() { helper.CONFIG.HbaseRetryBackoff = backoff }()
+
+	ctx, done := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer done()
+	start := time.Now()
+	if _, err := c.Get(newGet(t, ctx, "t")); err != gohbase.ErrDeadline {
+		t.Errorf("should give up at deadline, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("backoff should not exceed request timeout")
+	}
+}
+
+func TestFailFastWhenTableDown(t *testing.T) {
+	fake := &flakyHbase{errs: []error{gohbase.ErrDeadline, gohbase.ErrDeadline,
+		gohbase.ErrDeadline}}
+	h := &HbaseClient{resilient: newResilientClient(fake)}
+	h.Client = availabilityClient{h.resilient}
+	for i := 0; i < 3; i++ {
+		if _, err := h.GetBucket("bucket"); err != ErrServiceUnavailable {
+			t.Fatalf("expected ServiceUnavailable, got %v", err)
+		}
+	}
+
+	fake.delay = time.Second
+	start := time.Now()
+	if _, err := h.GetBucket("bucket"); err != ErrServiceUnavailable {
+		t.Errorf("expected ServiceUnavailable, got %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond || fake.sent != 3 {
+		t.Error("requests to a down table should fail fast")
+	}
+	if h.Stats()["Get"].Rejected != 1 {
+		t.Errorf("rejected request not counted: %+v", h.Stats()["Get"])
+	}
+
+	// other tables are not affected
+	fake.delay = 0
+	if _, err := h.Client.Get(newGet(t, context.Background(), OBJECT_TABLE)); err != nil {
+		t.Errorf("other tables should be available, got %v", err)
+	}
+}
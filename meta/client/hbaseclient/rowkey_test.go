@@ -0,0 +1,199 @@
+package hbaseclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cannium/gohbase"
+	"github.com/cannium/gohbase/hrpc"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	. "github.com/journeymidnight/yig/meta/types"
+)
+
+// tableHbase serves scans from sorted row keys, and gets from nothing
+type tableHbase struct {
+	gohbase.Client
+	rows []string
+	gets []string
+}
+
+func (f *tableHbase) Scan(s *hrpc.Scan) (results []*hrpc.Result, err error) {
+	sort.Strings(f.rows)
+	for _, row := range f.rows {
+		if row < string(s.StartRow()) || row >= string(s.StopRow()) {
+			continue
+		}
+		results = append(results, &hrpc.Result{Cells: []*hrpc.Cell{{
+			Row:       []byte(row),
+			Family:    []byte(OBJECT_COLUMN_FAMILY),
+			Qualifier: []byte("bucket"),
+			Value:     []byte("bucket"),
+		}}})
+		if len(results) == int(s.NumberOfRows()) {
+			break
+		}
+	}
+	return
+}
+
+func (f *tableHbase) Get(g *hrpc.Get) (*hrpc.Result, error) {
+	f.gets = append(f.gets, string(g.Table())+":"+string(g.Key()))
+	return &hrpc.Result{}, nil
+}
+
+func objectRowkey(name string, t time.Time) string {
+	var rowkey bytes.Buffer
+	rowkey.WriteString("bucket" + ObjectNameSeparator + name + ObjectNameSeparator)
+	binary.Write(&rowkey, binary.BigEndian, math.MaxUint64-uint64(t.UnixNano()))
+	return rowkey.String()
+}
+
+func TestObjectNameWithSeparator(t *testing.T) {
+	object := &Object{BucketName: "bucket", Name: "a\nb", LastModifiedTime: time.Now()}
+	if _, err := object.GetRowkey(); err != ErrInvalidObjectName {
+		t.Errorf("expected ErrInvalidObjectName, got %v", err)
+	}
+	h := &HbaseClient{Client: &tableHbase{}}
+	if _, err := h.GetObject("bucket", "a\nb", ""); err != ErrNoSuchKey {
+		t.Errorf("expected ErrNoSuchKey, got %v", err)
+	}
+}
+
+// A legacy object named "a\n\x00..." shares its rowkey prefix with "a"
+func TestStrictObjectRowkey(t *testing.T) {
+	now := time.Now()
+	mimic := "a\n\x00\x00\x00\x00\x00\x00\x00\x00"
+	fake := &tableHbase{rows: []string{objectRowkey("a", now), objectRowkey(mimic, now)}}
+	h := &HbaseClient{Client: fake}
+
+	object, err := h.GetObject("bucket", "a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Name != mimic {
+		t.Fatalf("expected the colliding row first, got %q", object.Name)
+	}
+
+	helper.CONFIG.StrictObjectRowkey = true
+	defer func() { helper.CONFIG.StrictObjectRowkey = false }()
+	object, err = h.GetObject("bucket", "a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Name != "a" {
+		t.Errorf("expected object a, got %q", object.Name)
+	}
+	// only the colliding row left
+	fake.rows = []string{objectRowkey(mimic, now)}
+	if _, err = h.GetObject("bucket", "a", ""); err != ErrNoSuchKey {
+		t.Errorf("expected ErrNoSuchKey, got %v", err)
+	}
+}
+
+// Version IDs and upload IDs are encoded the same way, but they are only
+// looked up in their own tables
+func TestVersionIdAsUploadId(t *testing.T) {
+	now := time.Now()
+	fake := &tableHbase{rows: []string{objectRowkey("a", now)}}
+	h := &HbaseClient{Client: fake}
+	object, err := h.GetObject("bucket", "a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.GetMultipart("bucket", "a", object.VersionId); err != ErrNoSuchUpload {
+		t.Errorf("expected ErrNoSuchUpload, got %v", err)
+	}
+	if len(fake.gets) != 1 || fake.gets[0][:len(MULTIPART_TABLE)+1] != MULTIPART_TABLE+":" {
+		t.Errorf("version ID should be looked up in multipart table: %q", fake.gets)
+	}
+
+	multipart := Multipart{BucketName: "bucket", ObjectName: "a",
+		InitialTime: now.Add(time.Second)}
+	uploadId, _ := multipart.GetUploadId()
+	if _, err = h.GetObject("bucket", "a", uploadId); err != ErrNoSuchKey {
+		t.Errorf("expected ErrNoSuchKey, got %v", err)
+	}
+	for _, uploadId := range []string{"null", object.VersionId[2:]} {
+		if _, err = h.GetMultipart("bucket", "a", uploadId); err != ErrNoSuchUpload {
+			t.Errorf("upload ID %q: expected ErrNoSuchUpload, got %v", uploadId, err)
+		}
+	}
+}
+
+// Legacy objects named "a\n" followed by a version of "a" have rows that
+// look like a version of "a" with something appended
+func TestVersionRowkeyCollision(t *testing.T) {
+	now := time.Now()
+	older, newer := now.Add(-time.Hour), now.Add(time.Hour)
+	var version bytes.Buffer
+	binary.Write(&version, binary.BigEndian, math.MaxUint64-uint64(now.UnixNano()))
+	mimic := "a\n" + version.String()
+	fake := &tableHbase{rows: []string{objectRowkey("a", older), objectRowkey("a", newer),
+		objectRowkey(mimic, now)}}
+	h := &HbaseClient{Client: fake}
+
+	missing := &Object{BucketName: "bucket", Name: "a", LastModifiedTime: now}
+	if _, err := h.GetObject("bucket", "a", missing.GetVersionId()); err != ErrNoSuchKey {
+		t.Errorf("version matched by the colliding row only should not be found, got %v", err)
+	}
+	existing := &Object{BucketName: "bucket", Name: "a", LastModifiedTime: older}
+	object, err := h.GetObject("bucket", "a", existing.GetVersionId())
+	if err != nil || object.Name != "a" {
+		t.Errorf("existing version should be found, got %v %v", object, err)
+	}
+
+	// the colliding row sorts between the two versions of "a"
+	versions, err := h.GetAllObject("bucket", "a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Name != "a" || versions[1].Name != "a" {
+		t.Errorf("expected both versions of a, got %v", versions)
+	}
+}
+
+// Multipart rows are filtered by regex in HBase, which matches row keys
+// decoded as ISO-8859-1 in dot-all mode
+func TestMultipartRowkeyRegex(t *testing.T) {
+	cases := []struct {
+		prefix  string
+		matched []string
+	}{
+		{"", []string{"a.b+/c", "axb+/c", "a.bb/c", "文件/夹", "x"}},
+		{"a.b+/", []string{"a.b+/c"}},
+		{"a.b", []string{"a.b+/c", "a.bb/c"}},
+		{"文件", []string{"文件/夹"}},
+		{"(", nil},
+	}
+	for _, c := range cases {
+		pattern := regexp.MustCompile("(?s)" + multipartRowkeyRegex("b.c", c.prefix))
+		var matched []string
+		for _, bucket := range []string{"b.c", "bxc"} {
+			for _, name := range []string{"a.b+/c", "axb+/c", "a.bb/c", "文件/夹", "x"} {
+				multipart := Multipart{BucketName: bucket, ObjectName: name,
+					InitialTime: time.Now()}
+				rowkey, err := multipart.GetRowkey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if pattern.MatchString(latin1(rowkey)) {
+					matched = append(matched, bucket+":"+name)
+				}
+			}
+		}
+		var expected []string
+		for _, name := range c.matched {
+			expected = append(expected, "b.c:"+name)
+		}
+		if !reflect.DeepEqual(matched, expected) {
+			t.Errorf("prefix %q: expected %v, got %v", c.prefix, expected, matched)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package hbaseclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/cannium/gohbase/filter"
+	"github.com/cannium/gohbase/hrpc"
+	"github.com/journeymidnight/yig/helper"
+	. "github.com/journeymidnight/yig/meta/types"
+)
+
+// Rows fetched by each request of a full scan
+const STATS_SCAN_BATCH = 1000
+
+// Scan rows in [startRow, stopRow) batch by batch, each batch with its own
+// timeout, so scanning a big bucket doesn't hold all rows in memory
+func (h *HbaseClient) scanAll(table, startRow, stopRow string,
+	options []func(hrpc.RpcCall) error, onRow func(row *hrpc.Result) error) error {
+
+	options = append(options, hrpc.NumberOfRows(STATS_SCAN_BATCH))
+	for {
+		ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+		scanRequest, err := hrpc.NewScanRangeStr(ctx, table, startRow, stopRow, options...)
+		if err != nil {
+			done()
+			return err
+		}
+		rows, err := h.Client.Scan(scanRequest)
+		done()
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err = onRow(row); err != nil {
+				return err
+			}
+		}
+		if len(rows) < STATS_SCAN_BATCH {
+			return nil
+		}
+		// smallest row key after the last one
+		startRow = string(rows[len(rows)-1].Cells[0].Row) + "\x00"
+	}
+}
+
+func (h *HbaseClient) CountObjects(bucketName string) (stats BucketStats, err error) {
+	// rows are bucketName + "\n" + objectName + "\n" + 8 bytes of version,
+	// newer versions of an object first
+	prefix := bucketName + ObjectNameSeparator
+	stopRow := []byte(prefix)
+	stopRow[len(stopRow)-1]++
+	options := []func(hrpc.RpcCall) error{
+		hrpc.Families(map[string][]string{
+			OBJECT_COLUMN_FAMILY: {"size", "deleteMarker", "storageClass"},
+		}),
+	}
+	var lastName []byte
+	err = h.scanAll(OBJECT_TABLE, prefix, string(stopRow), options,
+		func(row *hrpc.Result) error {
+			if len(row.Cells) == 0 || len(row.Cells[0].Row) < len(prefix)+9 {
+				return nil
+			}
+			rowkey := row.Cells[0].Row
+			name := rowkey[len(prefix) : len(rowkey)-9]
+			latest := !bytes.Equal(name, lastName)
+			lastName = append(lastName[:0], name...)
+			var size int64
+			var storageClass string
+			var deleteMarker bool
+			for _, cell := range row.Cells {
+				switch string(cell.Qualifier) {
+				case "size":
+					err := binary.Read(bytes.NewReader(cell.Value), binary.BigEndian, &size)
+					if err != nil {
+						return err
+					}
+				case "deleteMarker":
+					deleteMarker = string(cell.Value) == "true"
+				case "storageClass":
+					storageClass = string(cell.Value)
+				}
+			}
+			stats.AddVersion(size, storageClass, deleteMarker, latest)
+			return nil
+		})
+	return
+}
+
+func (h *HbaseClient) CountMultipartUploads(bucketName string) (count int64, err error) {
+	// rows are bucketName + bigEndian(uint16(depth)) + objectName + ..., the
+	// first byte of depth is always less than "-", the smallest character
+	// allowed in bucket names, so rows of bucket "b" and "b.x" don't mix
+	startRow := bucketName + "\x00"
+	stopRow := bucketName + "-"
+	options := []func(hrpc.RpcCall) error{
+		hrpc.Filters(filter.NewKeyOnlyFilter(false)),
+	}
+	err = h.scanAll(MULTIPART_TABLE, startRow, stopRow, options,
+		func(row *hrpc.Result) error {
+			count += 1
+			return nil
+		})
+	return
+}
@@ -2,6 +2,7 @@ package hbaseclient
 
 import (
 	"context"
+	"github.com/cannium/gohbase/filter"
 	"github.com/cannium/gohbase/hrpc"
 	"github.com/journeymidnight/yig/helper"
 	. "github.com/journeymidnight/yig/meta/types"
@@ -25,6 +26,37 @@ func (h *HbaseClient) GetUserBuckets(userId string) (buckets []string, err error
 	return buckets, nil
 }
 
+// Buckets of a user are columns of the user row, pages of them are read
+// with ColumnPaginationFilter
+func (h *HbaseClient) ListUserBuckets(userId, marker string, maxBuckets int) (buckets []string,
+	truncated bool, err error) {
+
+	var columnOffset []byte
+	if marker != "" {
+		// smallest column after marker
+		columnOffset = []byte(marker + "\x00")
+	}
+	pagination := filter.NewColumnPaginationFilter(int32(maxBuckets+1), 0, columnOffset)
+	ctx, done := context.WithTimeout(RootContext, helper.CONFIG.HbaseTimeout)
+	defer done()
+	getRequest, err := hrpc.NewGetStr(ctx, USER_TABLE, userId, hrpc.Filters(pagination))
+	if err != nil {
+		return
+	}
+	response, err := h.Client.Get(getRequest)
+	if err != nil {
+		return
+	}
+	buckets = make([]string, 0, len(response.Cells))
+	for _, cell := range response.Cells {
+		buckets = append(buckets, string(cell.Qualifier))
+	}
+	if len(buckets) > maxBuckets {
+		return buckets[:maxBuckets], true, nil
+	}
+	return buckets, false, nil
+}
+
 func (h *HbaseClient) AddBucketForUser(bucketName, userId string) (err error) {
 	newUserBucket := map[string]map[string][]byte{
 		USER_COLUMN_FAMILY: map[string][]byte{
@@ -4,17 +4,19 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	. "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/meta/util"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 )
 
 func (t *TidbClient) GetBucket(bucketName string) (bucket Bucket, err error) {
-	var acl, cors, lc, createTime string
+	var acl, cors, lc, createTime, encryption, objectLock, rateLimit, grants string
 	sqltext := fmt.Sprintf("select * from buckets where bucketname='%s';", bucketName)
 	err = t.Client.QueryRow(sqltext).Scan(
 		&bucket.Name,
@@ -25,6 +27,13 @@ func (t *TidbClient) GetBucket(bucketName string) (bucket Bucket, err error) {
 		&createTime,
 		&bucket.Usage,
 		&bucket.Versioning,
+		&encryption,
+		&objectLock,
+		&bucket.RequestPayer,
+		&rateLimit,
+		&bucket.MaxObjectSize,
+		&bucket.GzipVariants,
+		&grants,
 	)
 	if err != nil && err == sql.ErrNoRows {
 		err = ErrNoSuchBucket
@@ -40,6 +49,12 @@ func (t *TidbClient) GetBucket(bucketName string) (bucket Bucket, err error) {
 	if err != nil {
 		return
 	}
+	if grants != "" {
+		err = json.Unmarshal([]byte(grants), &bucket.ACL.Grants)
+		if err != nil {
+			return
+		}
+	}
 	err = json.Unmarshal([]byte(cors), &bucket.CORS)
 	if err != nil {
 		return
@@ -48,6 +63,21 @@ func (t *TidbClient) GetBucket(bucketName string) (bucket Bucket, err error) {
 	if err != nil {
 		return
 	}
+	if encryption != "" {
+		err = json.Unmarshal([]byte(encryption), &bucket.Encryption)
+		if err != nil {
+			return
+		}
+	}
+	if objectLock != "" {
+		err = json.Unmarshal([]byte(objectLock), &bucket.ObjectLock)
+		if err != nil {
+			return
+		}
+	}
+	if rateLimit != "" {
+		err = json.Unmarshal([]byte(rateLimit), &bucket.BucketRateLimit)
+	}
 	return
 }
 
@@ -61,21 +91,18 @@ func (t *TidbClient) PutBucket(bucket Bucket) error {
 	return nil
 }
 
+// Rely on primary key of buckets table instead of checking before inserting,
+// so only one of concurrent creators wins
 func (t *TidbClient) CheckAndPutBucket(bucket Bucket) (bool, error) {
-	var processed bool
-	_, err := t.GetBucket(bucket.Name)
-	if err == nil {
-		processed = false
-		return processed, err
-	} else if err != nil && err != ErrNoSuchBucket {
-		processed = false
-		return processed, err
-	} else {
-		processed = true
-	}
 	sql := bucket.GetCreateSql()
-	_, err = t.Client.Exec(sql)
-	return processed, err
+	_, err := t.Client.Exec(sql)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == MYSQL_ER_DUP_ENTRY {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
 }
 
 func (t *TidbClient) ListObjects(bucketName, marker, verIdMarker, prefix, delimiter string, versioned bool, maxKeys int) (retObjects []*Object, prefixes []string, truncated bool, nextMarker, nextVerIdMarker string, err error) {
@@ -167,7 +194,8 @@ func (t *TidbClient) ListObjects(bucketName, marker, verIdMarker, prefix, delimi
 				}
 			}
 			var o *Object
-			Strver := strconv.FormatUint(version, 10)
+			// GetObject expects the public version id
+			Strver := util.Encrypt(strconv.FormatUint(math.MaxUint64-version, 10))
 			o, err = t.GetObject(bucketname, name, Strver)
 			if err != nil {
 				return
@@ -206,8 +234,61 @@ func (t *TidbClient) DeleteBucket(bucket Bucket) error {
 	return nil
 }
 
+func (t *TidbClient) ListBuckets(marker string, maxBuckets int) (buckets []string,
+	truncated bool, err error) {
+
+	sqltext := fmt.Sprintf("select bucketname from buckets where bucketname>'%s' "+
+		"order by bucketname limit %d", marker, maxBuckets+1)
+	rows, err := t.Client.Query(sqltext)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucketName string
+		err = rows.Scan(&bucketName)
+		if err != nil {
+			return
+		}
+		buckets = append(buckets, bucketName)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	if len(buckets) > maxBuckets {
+		return buckets[:maxBuckets], true, nil
+	}
+	return buckets, false, nil
+}
+
 func (t *TidbClient) UpdateUsage(bucketName string, size int64) {
 	sql := fmt.Sprintf("update buckets set usages='%s' where bucketname='%s'", size, bucketName)
 	t.Client.Exec(sql)
 	return
 }
+
+func (t *TidbClient) CountObjects(bucketName string) (stats BucketStats, err error) {
+	// newer versions of an object first
+	sqltext := fmt.Sprintf("select name,size,storageclass,deletemarker from objects where bucketname='%s' order by bucketname,name,version", bucketName)
+	rows, err := t.Client.Query(sqltext)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var lastName string
+	for i := 0; rows.Next(); i++ {
+		var name string
+		var size int64
+		var storageClass string
+		var deleteMarker bool
+		err = rows.Scan(&name, &size, &storageClass, &deleteMarker)
+		if err != nil {
+			return
+		}
+		stats.AddVersion(size, storageClass, deleteMarker, i == 0 || name != lastName)
+		lastName = name
+	}
+	err = rows.Err()
+	return
+}
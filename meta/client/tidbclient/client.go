@@ -1,12 +1,17 @@
 package tidbclient
 
 import (
+	"context"
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/journeymidnight/yig/helper"
 	"os"
+	"time"
 )
 
+// MySQL error number of duplicate entry for a unique key
+const MYSQL_ER_DUP_ENTRY = 1062
+
 type TidbClient struct {
 	Client *sql.DB
 }
@@ -20,3 +25,9 @@ func NewTidbClient() *TidbClient {
 	cli.Client = conn
 	return cli
 }
+
+func (t *TidbClient) Ping(timeout time.Duration) error {
+	ctx, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+	return t.Client.PingContext(ctx)
+}
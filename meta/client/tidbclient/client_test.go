@@ -0,0 +1,112 @@
+package tidbclient
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeDatabase records statements sent to it. Queries are answered by
+// `query`, other statements affect rows as many as `affected` returns
+type fakeDatabase struct {
+	lock       sync.Mutex
+	statements []string
+	query      func(sqltext string) (columns []string, rows [][]driver.Value)
+	affected   func(sqltext string) int64
+}
+
+func (d *fakeDatabase) record(sqltext string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.statements = append(d.statements, sqltext)
+}
+
+// Databases by data source name, which is passed to fakeDriver.Open
+var fakeDatabases = struct {
+	sync.Mutex
+	m map[string]*fakeDatabase
+}{m: make(map[string]*fakeDatabase)}
+
+type fakeDriver struct{}
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDatabases.Lock()
+	defer fakeDatabases.Unlock()
+	return fakeConn{fakeDatabases.m[name]}, nil
+}
+
+type fakeConn struct {
+	database *fakeDatabase
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.database, query}, nil
+}
+
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c fakeConn) Commit() error             { return nil }
+func (c fakeConn) Rollback() error           { return nil }
+
+type fakeStmt struct {
+	database *fakeDatabase
+	sqltext  string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.database.record(s.sqltext)
+	var affected int64 = 1
+	if s.database.affected != nil {
+		affected = s.database.affected(s.sqltext)
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.database.record(s.sqltext)
+	rows := &fakeRows{}
+	if s.database.query != nil {
+		rows.columns, rows.rows = s.database.query(s.sqltext)
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func newFakeTidbClient(t *testing.T) (*TidbClient, *fakeDatabase) {
+	database := &fakeDatabase{}
+	fakeDatabases.Lock()
+	name := strconv.Itoa(len(fakeDatabases.m))
+	fakeDatabases.m[name] = database
+	fakeDatabases.Unlock()
+	db, err := sql.Open("fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TidbClient{Client: db}, database
+}
@@ -0,0 +1,51 @@
+package tidbclient
+
+import (
+	"fmt"
+
+	. "github.com/journeymidnight/yig/meta/types"
+)
+
+//lc
+func (t *TidbClient) PutBucketToLifeCycle(lifeCycle LifeCycle) error {
+	sqltext := fmt.Sprintf("insert into lifecycle(bucketname,status) values('%s','%s') "+
+		"on duplicate key update status='%s'", lifeCycle.BucketName, lifeCycle.Status,
+		lifeCycle.Status)
+	_, err := t.Client.Exec(sqltext)
+	return err
+}
+
+func (t *TidbClient) RemoveBucketFromLifeCycle(bucket Bucket) error {
+	sqltext := fmt.Sprintf("delete from lifecycle where bucketname='%s'", bucket.Name)
+	_, err := t.Client.Exec(sqltext)
+	return err
+}
+
+// Buckets after `marker` in name order, same as scanning lifecycle table
+// of HBase
+func (t *TidbClient) ScanLifeCycle(limit int, marker string) (result ScanLifeCycleResult, err error) {
+	// query for one more row to determine if results are truncated
+	sqltext := fmt.Sprintf("select bucketname,status from lifecycle where bucketname>'%s' "+
+		"order by bucketname limit %d", marker, limit+1)
+	rows, err := t.Client.Query(sqltext)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	result.Lcs = make([]LifeCycle, 0, limit)
+	for rows.Next() {
+		var lc LifeCycle
+		err = rows.Scan(&lc.BucketName, &lc.Status)
+		if err != nil {
+			return
+		}
+		if len(result.Lcs) == limit {
+			result.Truncated = true
+			result.NextMarker = result.Lcs[limit-1].BucketName
+			break
+		}
+		result.Lcs = append(result.Lcs, lc)
+	}
+	err = rows.Err()
+	return
+}
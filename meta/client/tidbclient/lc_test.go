@@ -0,0 +1,94 @@
+package tidbclient
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	. "github.com/journeymidnight/yig/meta/types"
+)
+
+func TestLifeCycleStatements(t *testing.T) {
+	cases := []struct {
+		name     string
+		run      func(client *TidbClient) error
+		expected string
+	}{
+		{"put", func(client *TidbClient) error {
+			return client.PutBucketToLifeCycle(LifeCycle{BucketName: "bucket", Status: "Pending"})
+		}, "insert into lifecycle(bucketname,status) values('bucket','Pending') " +
+			"on duplicate key update status='Pending'"},
+		{"remove", func(client *TidbClient) error {
+			return client.RemoveBucketFromLifeCycle(Bucket{Name: "bucket"})
+		}, "delete from lifecycle where bucketname='bucket'"},
+	}
+	for _, c := range cases {
+		client, database := newFakeTidbClient(t)
+		if err := c.run(client); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(database.statements) != 1 || database.statements[0] != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, database.statements)
+		}
+	}
+}
+
+func TestScanLifeCycle(t *testing.T) {
+	// rows are those after marker in name order, as returned by the database
+	cases := []struct {
+		limit      int
+		marker     string
+		rows       []string
+		query      string
+		buckets    []string
+		truncated  bool
+		nextMarker string
+	}{
+		{2, "", nil,
+			"select bucketname,status from lifecycle where bucketname>'' order by bucketname limit 3",
+			[]string{}, false, ""},
+		{2, "", []string{"a", "b"},
+			"select bucketname,status from lifecycle where bucketname>'' order by bucketname limit 3",
+			[]string{"a", "b"}, false, ""},
+		{2, "", []string{"a", "b", "c"},
+			"select bucketname,status from lifecycle where bucketname>'' order by bucketname limit 3",
+			[]string{"a", "b"}, true, "b"},
+		{2, "b", []string{"c", "d", "e"},
+			"select bucketname,status from lifecycle where bucketname>'b' order by bucketname limit 3",
+			[]string{"c", "d"}, true, "d"},
+		{2, "d", []string{"e"},
+			"select bucketname,status from lifecycle where bucketname>'d' order by bucketname limit 3",
+			[]string{"e"}, false, ""},
+	}
+	for _, c := range cases {
+		client, database := newFakeTidbClient(t)
+		database.query = func(sqltext string) ([]string, [][]driver.Value) {
+			var rows [][]driver.Value
+			for _, name := range c.rows {
+				rows = append(rows, []driver.Value{name, "Pending"})
+			}
+			return []string{"bucketname", "status"}, rows
+		}
+		result, err := client.ScanLifeCycle(c.limit, c.marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(database.statements) != 1 || database.statements[0] != c.query {
+			t.Errorf("marker %q: expected %q, got %q", c.marker, c.query, database.statements)
+		}
+		buckets := []string{}
+		for _, lc := range result.Lcs {
+			buckets = append(buckets, lc.BucketName)
+			if lc.Status != "Pending" {
+				t.Errorf("marker %q: status of %s is lost, got %q", c.marker, lc.BucketName,
+					lc.Status)
+			}
+		}
+		if !reflect.DeepEqual(buckets, c.buckets) || result.Truncated != c.truncated ||
+			result.NextMarker != c.nextMarker {
+
+			t.Errorf("marker %q: expected %v %v %q, got %v %v %q", c.marker, c.buckets,
+				c.truncated, c.nextMarker, buckets, result.Truncated, result.NextMarker)
+		}
+	}
+}
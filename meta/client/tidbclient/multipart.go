@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/journeymidnight/yig/api/datatype"
 	. "github.com/journeymidnight/yig/error"
-	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/iam"
 	. "github.com/journeymidnight/yig/meta/types"
 	"github.com/journeymidnight/yig/meta/util"
@@ -19,12 +18,15 @@ import (
 
 func (t *TidbClient) GetMultipart(bucketName, objectName, uploadId string) (multipart Multipart, err error) {
 	multipart.Parts = make(map[int]*Part)
+	// upload IDs not issued by us can't name any upload
 	timestampString, err := util.Decrypt(uploadId)
 	if err != nil {
+		err = ErrNoSuchUpload
 		return
 	}
 	uploadTime, err := strconv.ParseUint(timestampString, 10, 64)
 	if err != nil {
+		err = ErrNoSuchUpload
 		return
 	}
 	uploadTime = math.MaxUint64 - uploadTime
@@ -44,6 +46,8 @@ func (t *TidbClient) GetMultipart(bucketName, objectName, uploadId string) (mult
 		&sseRequest,
 		&multipart.Metadata.EncryptionKey,
 		&attrs,
+		&multipart.Metadata.StorageClass,
+		&multipart.Metadata.Ttl,
 	)
 	if err != nil && err == sql.ErrNoRows {
 		err = ErrNoSuchUpload
@@ -99,7 +103,7 @@ func (t *TidbClient) CreateMultipart(multipart Multipart) (err error) {
 	acl, _ := json.Marshal(m.Acl)
 	sseRequest, _ := json.Marshal(m.SseRequest)
 	attrs, _ := json.Marshal(m.Attrs)
-	sqltext := fmt.Sprintf("insert into multiparts values('%s','%s',%d,'%s','%s','%s','%s','%s','%s','%s','%s','%s')", multipart.BucketName, multipart.ObjectName, uploadtime, m.InitiatorId, m.OwnerId, m.ContentType, m.Location, m.Pool, acl, sseRequest, m.EncryptionKey, attrs)
+	sqltext := fmt.Sprintf("insert into multiparts values('%s','%s',%d,'%s','%s','%s','%s','%s','%s','%s',x'%x','%s','%s',%d)", multipart.BucketName, multipart.ObjectName, uploadtime, m.InitiatorId, m.OwnerId, m.ContentType, m.Location, m.Pool, acl, sseRequest, m.EncryptionKey, attrs, m.StorageClass, m.Ttl)
 	_, err = t.Client.Exec(sqltext)
 	if err != nil {
 	}
@@ -113,7 +117,7 @@ func (t *TidbClient) PutObjectPart(multipart Multipart, part Part) (err error) {
 		return
 	}
 	lastModified := lastt.Format(TIME_LAYOUT_TIDB)
-	sqltext := fmt.Sprintf("insert into multipartpart values(%d,%d,'%s',%d,'%s','%s','%s','%s','%s',%d)", part.PartNumber, part.Size, part.ObjectId, part.Offset, part.Etag, lastModified, part.InitializationVector, multipart.BucketName, multipart.ObjectName, uploadtime)
+	sqltext := fmt.Sprintf("insert into multipartpart values(%d,%d,'%s',%d,'%s','%s',x'%x','%s','%s',%d)", part.PartNumber, part.Size, part.ObjectId, part.Offset, part.Etag, lastModified, part.InitializationVector, multipart.BucketName, multipart.ObjectName, uploadtime)
 	_, err = t.Client.Exec(sqltext)
 	if err != nil {
 	}
@@ -136,9 +140,8 @@ func (t *TidbClient) DeleteMultipart(multipart Multipart) (err error) {
 }
 
 func (t *TidbClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker, prefix, delimiter, encodingType string, maxUploads int) (uploads []datatype.Upload, prefixs []string, isTruncated bool, nextKeyMarker, nextUploadIdMarker string, err error) {
-	var count int
 	var exit bool
-	commonPrefixes := make(map[string]struct{})
+	collector := util.NewListCollector(maxUploads)
 	var uploadNum uint64
 	if uploadIdMarker != "" {
 		uploadNum, err = strconv.ParseUint(uploadIdMarker, 10, 64)
@@ -157,9 +160,9 @@ func (t *TidbClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker,
 		}
 		var sqltext string
 		if currentMarker == "" {
-			sqltext = fmt.Sprintf("select objectname,uploadtime,initiatorid,ownerid from multiparts where bucketName='%s' order by bucketname,objectname,uploadtime limit %d,%d", bucketName, objnum[currentMarker], objnum[currentMarker]+maxUploads)
+			sqltext = fmt.Sprintf("select objectname,uploadtime,initiatorid,ownerid,storageclass from multiparts where bucketName='%s' order by bucketname,objectname,uploadtime limit %d,%d", bucketName, objnum[currentMarker], objnum[currentMarker]+maxUploads)
 		} else {
-			sqltext = fmt.Sprintf("select objectname,uploadtime,initiatorid,ownerid from multiparts where bucketName='%s' and objectname>='%s' order by bucketname,objectname,uploadtime limit %d,%d", bucketName, keyMarker, objnum[currentMarker], objnum[currentMarker]+maxUploads)
+			sqltext = fmt.Sprintf("select objectname,uploadtime,initiatorid,ownerid,storageclass from multiparts where bucketName='%s' and objectname>='%s' order by bucketname,objectname,uploadtime limit %d,%d", bucketName, keyMarker, objnum[currentMarker], objnum[currentMarker]+maxUploads)
 		}
 		var rows *sql.Rows
 		rows, err = t.Client.Query(sqltext)
@@ -169,13 +172,14 @@ func (t *TidbClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker,
 		defer rows.Close()
 		for rows.Next() {
 			loopnum += 1
-			var name, initiatorid, ownerid string
+			var name, initiatorid, ownerid, storageClass string
 			var uploadtime uint64
 			err = rows.Scan(
 				&name,
 				&uploadtime,
 				&initiatorid,
 				&ownerid,
+				&storageClass,
 			)
 			if err != nil {
 				return
@@ -185,7 +189,7 @@ func (t *TidbClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker,
 			}
 			objnum[name] += 1
 			currentMarker = name
-			upload := datatype.Upload{StorageClass: "STANDARD"}
+			upload := datatype.Upload{StorageClass: storageClass}
 			//filte by uploadtime and key
 			if first {
 				if uploadNum != 0 {
@@ -200,20 +204,21 @@ func (t *TidbClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker,
 				continue
 			}
 			//filte by delimiter
-			if len(delimiter) != 0 {
-				subStr := strings.TrimPrefix(name, prefix)
-				n := strings.Index(subStr, delimiter)
-				if n != -1 {
-					prefixKey := string([]byte(subStr)[0:(n + 1)])
-					if _, ok := commonPrefixes[prefixKey]; !ok {
-						commonPrefixes[prefixKey] = struct{}{}
-					}
+			if prefixKey := util.CommonPrefix(name, prefix, delimiter); prefixKey != "" {
+				if collector.Seen(prefixKey) {
 					continue
 				}
+				if !collector.TakePrefix(prefixKey) {
+					// next page starts from uploads under this prefix
+					isTruncated = true
+					nextKeyMarker = prefixKey
+					exit = true
+					break
+				}
+				continue
 			}
-			if count >= maxUploads {
+			if !collector.TakeKey() {
 				isTruncated = true
-				exit = true
 				nextKeyMarker = name
 				nextUploadIdMarker = GetMultipartUploadIdForTidb(uploadtime)
 				exit = true
@@ -242,7 +247,6 @@ func (t *TidbClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker,
 			ns := timestamp % 1e9
 			upload.Initiated = time.Unix(s, ns).Format(CREATE_TIME_LAYOUT)
 			uploads = append(uploads, upload)
-			count += 1
 		}
 		if loopnum == 0 {
 			exit = true
@@ -252,6 +256,12 @@ func (t *TidbClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker,
 			break
 		}
 	}
-	prefixs = helper.Keys(commonPrefixes)
+	prefixs = collector.Prefixes()
+	return
+}
+
+func (t *TidbClient) CountMultipartUploads(bucketName string) (count int64, err error) {
+	sqltext := fmt.Sprintf("select count(*) from multiparts where bucketname='%s'", bucketName)
+	err = t.Client.QueryRow(sqltext).Scan(&count)
 	return
 }
@@ -0,0 +1,19 @@
+package tidbclient
+
+import (
+	"testing"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/meta/util"
+)
+
+func TestGetMultipartBogusUploadId(t *testing.T) {
+	// rejected before querying the database
+	client := &TidbClient{}
+	for _, uploadId := range []string{"", "bogus", "deadbeef", util.Encrypt("hehe")} {
+		_, err := client.GetMultipart("bucket", "object", uploadId)
+		if err != ErrNoSuchUpload {
+			t.Errorf("upload ID %q: expected ErrNoSuchUpload, got %v", uploadId, err)
+		}
+	}
+}
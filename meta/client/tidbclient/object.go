@@ -7,6 +7,7 @@ import (
 	"fmt"
 	. "github.com/journeymidnight/yig/error"
 	. "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/meta/util"
 	"github.com/xxtea/xxtea-go/xxtea"
 	"math"
 	"strconv"
@@ -14,13 +15,27 @@ import (
 )
 
 func (t *TidbClient) GetObject(bucketName, objectName, version string) (object *Object, err error) {
-	var ibucketname, iname, customattributes, acl, lastModifiedTime string
+	var ibucketname, iname, customattributes, acl, grants, lastModifiedTime, replicas string
 	var iversion uint64
+	var expireTime, retainUntil, restoreExpiry int64
 	var sqltext string
 	if version == "" {
 		sqltext = fmt.Sprintf("select * from objects where bucketname='%s' and name='%s' order by bucketname,name,version limit 1", bucketName, objectName)
 	} else {
-		sqltext = fmt.Sprintf("select * from objects where bucketname='%s' and name='%s' and version=%s", bucketName, objectName, version)
+		// version is the public version id, i.e. encrypted unix nano timestamp,
+		// while objects table stores (uint64.max - unixNanoTimestamp)
+		var decrypted string
+		decrypted, err = util.Decrypt(version)
+		if err != nil {
+			return
+		}
+		var unixNanoTimestamp uint64
+		unixNanoTimestamp, err = strconv.ParseUint(decrypted, 10, 64)
+		if err != nil {
+			err = ErrInvalidVersioning
+			return
+		}
+		sqltext = fmt.Sprintf("select * from objects where bucketname='%s' and name='%s' and version=%d", bucketName, objectName, math.MaxUint64-unixNanoTimestamp)
 	}
 	object = &Object{}
 	err = t.Client.QueryRow(sqltext).Scan(
@@ -42,6 +57,18 @@ func (t *TidbClient) GetObject(bucketName, objectName, version string) (object *
 		&object.SseType,
 		&object.EncryptionKey,
 		&object.InitializationVector,
+		&expireTime,
+		&object.RetentionMode,
+		&retainUntil,
+		&object.LegalHold,
+		&object.Appendable,
+		&grants,
+		&object.StorageClass,
+		&object.SharedWith,
+		&object.ChecksumAlgorithm,
+		&object.Checksum,
+		&replicas,
+		&restoreExpiry,
 	)
 	if err != nil && err == sql.ErrNoRows {
 		err = ErrNoSuchKey
@@ -53,6 +80,15 @@ func (t *TidbClient) GetObject(bucketName, objectName, version string) (object *
 	s := int64(rversion) / 1e9
 	ns := int64(rversion) % 1e9
 	object.LastModifiedTime = time.Unix(s, ns)
+	if expireTime != 0 {
+		object.ExpireTime = time.Unix(expireTime, 0)
+	}
+	if retainUntil != 0 {
+		object.RetainUntilDate = time.Unix(retainUntil, 0)
+	}
+	if restoreExpiry != 0 {
+		object.RestoreExpiryDate = time.Unix(restoreExpiry, 0)
+	}
 	object.GetRowkey()
 	object.Name = objectName
 	object.BucketName = bucketName
@@ -60,10 +96,22 @@ func (t *TidbClient) GetObject(bucketName, objectName, version string) (object *
 	if err != nil {
 		return
 	}
+	if grants != "" {
+		err = json.Unmarshal([]byte(grants), &object.ACL.Grants)
+		if err != nil {
+			return
+		}
+	}
 	err = json.Unmarshal([]byte(customattributes), &object.CustomAttributes)
 	if err != nil {
 		return
 	}
+	if replicas != "" {
+		err = json.Unmarshal([]byte(replicas), &object.Replicas)
+		if err != nil {
+			return
+		}
+	}
 	object.Parts, err = getParts(object.BucketName, object.Name, iversion, t.Client)
 	//build simple index for multipart
 	if len(object.Parts) != 0 {
@@ -73,9 +121,7 @@ func (t *TidbClient) GetObject(bucketName, objectName, version string) (object *
 		}
 		object.PartsIndex = &SimpleIndex{Index: sortedPartNum}
 	}
-	var reversedTime uint64
-	timestamp := math.MaxUint64 - reversedTime
-	timeData := []byte(strconv.FormatUint(timestamp, 10))
+	timeData := []byte(strconv.FormatUint(rversion, 10))
 	object.VersionId = hex.EncodeToString(xxtea.Encrypt(timeData, XXTEA_KEY))
 	return
 }
@@ -89,12 +135,13 @@ func (t *TidbClient) GetAllObject(bucketName, objectName, version string) (objec
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var sversion string
-		err = rows.Scan(&sversion)
+		var iversion uint64
+		err = rows.Scan(&iversion)
 		if err != nil {
 			return
 		}
-		versions = append(versions, sversion)
+		timestamp := strconv.FormatUint(math.MaxUint64-iversion, 10)
+		versions = append(versions, util.Encrypt(timestamp))
 	}
 	for _, v := range versions {
 		var obj *Object
@@ -125,6 +172,130 @@ func (t *TidbClient) PutObject(object *Object) error {
 	return err
 }
 
+func (t *TidbClient) UpdateObjectLocation(object *Object, oldLocation, oldPool string) (bool, error) {
+	v := math.MaxUint64 - uint64(object.LastModifiedTime.UnixNano())
+	tx, err := t.Client.Begin()
+	if err != nil {
+		return false, err
+	}
+	sqltext := fmt.Sprintf("update objects set location='%s',pool='%s',objectid='%s',storageclass='%s' where bucketname='%s' and name='%s' and version=%d and location='%s' and pool='%s'", object.Location, object.Pool, object.ObjectId, object.StorageClass, object.BucketName, object.Name, v, oldLocation, oldPool)
+	result, err := tx.Exec(sqltext)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		tx.Rollback()
+		return false, err
+	}
+	for _, p := range object.Parts {
+		sqltext = fmt.Sprintf("update objectpart set objectid='%s' where bucketname='%s' and objectname='%s' and version=%d and partnumber=%d", p.ObjectId, object.BucketName, object.Name, v, p.PartNumber)
+		_, err = tx.Exec(sqltext)
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Last modified time of an object is derived from its version, so it's
+// left as is, same as HBase
+func (t *TidbClient) UpdateObjectAttrs(object *Object) error {
+	v := math.MaxUint64 - uint64(object.LastModifiedTime.UnixNano())
+	customAttributes, _ := json.Marshal(object.CustomAttributes)
+	acl, grants := object.GetAclSql()
+	sqltext := fmt.Sprintf("update objects set contenttype='%s',customattributes='%s',acl='%s',grants='%s' where bucketname='%s' and name='%s' and version=%d", object.ContentType, customAttributes, acl, grants, object.BucketName, object.Name, v)
+	_, err := t.Client.Exec(sqltext)
+	return err
+}
+
+// Fails with ErrNoSuchKey if the object is removed meanwhile, same as HBase
+func (t *TidbClient) UpdateObjectSharedWith(object *Object) error {
+	v := math.MaxUint64 - uint64(object.LastModifiedTime.UnixNano())
+	sqltext := fmt.Sprintf("update objects set sharedwith='%s' where bucketname='%s' and name='%s' and version=%d", object.SharedWith, object.BucketName, object.Name, v)
+	return t.updateObject(object, sqltext)
+}
+
+func (t *TidbClient) UpdateObjectRestoreExpiry(object *Object) error {
+	v := math.MaxUint64 - uint64(object.LastModifiedTime.UnixNano())
+	var restoreExpiry int64
+	if !object.RestoreExpiryDate.IsZero() {
+		restoreExpiry = object.RestoreExpiryDate.Unix()
+	}
+	sqltext := fmt.Sprintf("update objects set restoreexpiry=%d where bucketname='%s' and name='%s' and version=%d", restoreExpiry, object.BucketName, object.Name, v)
+	return t.updateObject(object, sqltext)
+}
+
+// Run `sqltext` updating columns of an existing object, fails with
+// ErrNoSuchKey if the object is removed or overwritten meanwhile. Rows
+// left unchanged by an update are not counted as affected, so the object
+// is looked up again if none is
+func (t *TidbClient) updateObject(object *Object, sqltext string) error {
+	result, err := t.Client.Exec(sqltext)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != 0 {
+		return nil
+	}
+	v := math.MaxUint64 - uint64(object.LastModifiedTime.UnixNano())
+	var count int
+	sqltext = fmt.Sprintf("select count(*) from objects where bucketname='%s' and name='%s' and version=%d", object.BucketName, object.Name, v)
+	err = t.Client.QueryRow(sqltext).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNoSuchKey
+	}
+	return nil
+}
+
+// Data keys are saved as is in TiDB, not encrypted with SSE-S3 master key,
+// so there is nothing to update on rotation
+func (t *TidbClient) UpdateObjectSseKey(object *Object) error {
+	return nil
+}
+
+func (t *TidbClient) AppendObject(object *Object, part *Part, lastModified time.Time) error {
+	v := math.MaxUint64 - uint64(object.LastModifiedTime.UnixNano())
+	tx, err := t.Client.Begin()
+	if err != nil {
+		return err
+	}
+	sqltext := fmt.Sprintf("update objects set size=%d,etag='%s',lastmodifiedtime='%s' where bucketname='%s' and name='%s' and version=%d and size=%d", object.Size, object.Etag, lastModified.Format(TIME_LAYOUT_TIDB), object.BucketName, object.Name, v, part.Offset)
+	result, err := tx.Exec(sqltext)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback()
+		return ErrPositionNotEqualToLength
+	}
+	_, err = tx.Exec(part.GetCreateSql(object.BucketName, object.Name, strconv.FormatUint(v, 10)))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
 func (t *TidbClient) DeleteObject(object *Object) error {
 	v := math.MaxUint64 - uint64(object.LastModifiedTime.UnixNano())
 	version := strconv.FormatUint(v, 10)
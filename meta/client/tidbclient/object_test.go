@@ -0,0 +1,54 @@
+package tidbclient
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	. "github.com/journeymidnight/yig/meta/types"
+)
+
+func TestUpdateObjectColumns(t *testing.T) {
+	object := &Object{BucketName: "bucket", Name: "object", SharedWith: "bob",
+		LastModifiedTime: time.Now(), RestoreExpiryDate: time.Now().Add(time.Hour)}
+	updates := map[string]func(client *TidbClient) error{
+		"sharedwith": func(client *TidbClient) error {
+			return client.UpdateObjectSharedWith(object)
+		},
+		"restoreexpiry": func(client *TidbClient) error {
+			return client.UpdateObjectRestoreExpiry(object)
+		},
+	}
+	cases := []struct {
+		affected int64
+		exists   bool
+		err      error
+	}{
+		{1, true, nil},
+		// updated to the value it already has
+		{0, true, nil},
+		{0, false, ErrNoSuchKey},
+	}
+	for column, update := range updates {
+		for _, c := range cases {
+			client, database := newFakeTidbClient(t)
+			database.affected = func(string) int64 { return c.affected }
+			database.query = func(string) ([]string, [][]driver.Value) {
+				count := int64(0)
+				if c.exists {
+					count = 1
+				}
+				return []string{"count(*)"}, [][]driver.Value{{count}}
+			}
+			if err := update(client); err != c.err {
+				t.Errorf("%s, %d rows affected, exists %v: expected %v, got %v", column,
+					c.affected, c.exists, c.err, err)
+			}
+			if !strings.HasPrefix(database.statements[0], "update objects set "+column+"=") {
+				t.Errorf("only %s should be updated, got %q", column, database.statements[0])
+			}
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package tidbclient
 
 import (
+	"database/sql"
 	"fmt"
+	. "github.com/journeymidnight/yig/error"
 	. "github.com/journeymidnight/yig/meta/types"
-	"strconv"
 )
 
 //objmap
@@ -15,10 +16,13 @@ func (t *TidbClient) GetObjectMap(bucketName, objectName string) (objMap *ObjMap
 		&objMap.Name,
 		&objMap.NullVerNum,
 	)
-	if err != nil {
+	if err != nil && err == sql.ErrNoRows {
+		err = ErrNoSuchKey
+		return
+	} else if err != nil {
 		return
 	}
-	objMap.NullVerId = strconv.FormatUint(objMap.NullVerNum, 10)
+	objMap.NullVerId = objMap.GetVersionId()
 	return
 }
 
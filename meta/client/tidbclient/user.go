@@ -23,8 +23,36 @@ func (t *TidbClient) GetUserBuckets(userId string) (buckets []string, err error)
 	return
 }
 
+func (t *TidbClient) ListUserBuckets(userId, marker string, maxBuckets int) (buckets []string,
+	truncated bool, err error) {
+
+	sqltext := fmt.Sprintf("select bucketname from users where userid='%s' and bucketname>'%s' "+
+		"order by bucketname limit %d", userId, marker, maxBuckets+1)
+	rows, err := t.Client.Query(sqltext)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucketName string
+		err = rows.Scan(&bucketName)
+		if err != nil {
+			return
+		}
+		buckets = append(buckets, bucketName)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	if len(buckets) > maxBuckets {
+		return buckets[:maxBuckets], true, nil
+	}
+	return buckets, false, nil
+}
+
 func (t *TidbClient) AddBucketForUser(bucketName, userId string) (err error) {
-	sql := fmt.Sprintf("insert into users values('%s','%s')", userId, bucketName)
+	sql := fmt.Sprintf("insert ignore into users values('%s','%s')", userId, bucketName)
 	_, err = t.Client.Exec(sql)
 	return
 }
@@ -1,6 +1,9 @@
 package meta
 
 import (
+	"context"
+	"time"
+
 	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	. "github.com/journeymidnight/yig/meta/types"
@@ -44,8 +47,7 @@ func (m *Meta) GetAllObject(bucketName string, objectName string) (object []*Obj
 }
 
 func (m *Meta) GetObjectMap(bucketName, objectName string) (objMap *ObjMap, err error) {
-	m.Client.GetObjectMap(bucketName, objectName)
-	return
+	return m.Client.GetObjectMap(bucketName, objectName)
 }
 
 func (m *Meta) GetObjectVersion(bucketName, objectName, version string, willNeed bool) (object *Object, err error) {
@@ -78,9 +80,17 @@ func (m *Meta) GetObjectVersion(bucketName, objectName, version string, willNeed
 	return object, nil
 }
 
+// Also clears cached entries of the object, including negative ones
+// cached while it did not exist
 func (m *Meta) PutObjectEntry(object *Object) error {
 	err := m.Client.PutObject(object)
-	return err
+	if err != nil {
+		return err
+	}
+	m.Cache.Remove(redis.ObjectTable, object.BucketName+":"+object.Name+":")
+	m.Cache.Remove(redis.ObjectTable,
+		object.BucketName+":"+object.Name+":"+object.GetVersionId())
+	return nil
 }
 
 func (m *Meta) PutObjMapEntry(objMap *ObjMap) error {
@@ -88,6 +98,39 @@ func (m *Meta) PutObjMapEntry(objMap *ObjMap) error {
 	return err
 }
 
+// Put object entry and objMap entry(if not nil) of a new object. They're
+// in different tables so can't go in one request, put them concurrently
+// instead. Either both are put or neither is.
+func (m *Meta) PutObjectEntries(ctx context.Context, object *Object, objMap *ObjMap) error {
+	if objMap == nil {
+		return m.PutObjectEntry(object)
+	}
+	objMapResult := make(chan error, 1)
+	go func() {
+		objMapResult <- m.PutObjMapEntry(objMap)
+	}()
+	err := m.PutObjectEntry(object)
+	objMapErr := <-objMapResult
+	if err == nil && objMapErr == nil {
+		return nil
+	}
+	if err == nil {
+		// same as the object is never put, no need to clear cache
+		if rollbackErr := m.Client.DeleteObject(object); rollbackErr != nil {
+			helper.LogWithContext(ctx, "Inconsistent data: object should be removed: %s %s %s %v",
+				object.BucketName, object.Name, object.GetVersionId(), rollbackErr)
+		}
+		return objMapErr
+	}
+	if objMapErr == nil {
+		if rollbackErr := m.Client.DeleteObjectMap(objMap); rollbackErr != nil {
+			helper.LogWithContext(ctx, "Inconsistent data: objmap should be removed: %s %s %v",
+				objMap.BucketName, objMap.Name, rollbackErr)
+		}
+	}
+	return err
+}
+
 func (m *Meta) DeleteObjectEntry(object *Object) error {
 	err := m.Client.DeleteObject(object)
 	return err
@@ -97,3 +140,55 @@ func (m *Meta) DeleteObjMapEntry(objMap *ObjMap) error {
 	err := m.Client.DeleteObjectMap(objMap)
 	return err
 }
+
+// Also clears cached entries of the object
+func (m *Meta) UpdateObjectAttrs(object *Object) error {
+	err := m.Client.UpdateObjectAttrs(object)
+	if err != nil {
+		return err
+	}
+	m.Cache.Remove(redis.ObjectTable, object.BucketName+":"+object.Name+":")
+	m.Cache.Remove(redis.ObjectTable,
+		object.BucketName+":"+object.Name+":"+object.GetVersionId())
+	return nil
+}
+
+// Also clears cached entries of the object
+func (m *Meta) UpdateObjectSharedWith(object *Object) error {
+	err := m.Client.UpdateObjectSharedWith(object)
+	if err != nil {
+		return err
+	}
+	m.Cache.Remove(redis.ObjectTable, object.BucketName+":"+object.Name+":")
+	m.Cache.Remove(redis.ObjectTable,
+		object.BucketName+":"+object.Name+":"+object.GetVersionId())
+	return nil
+}
+
+// Also clears cached entries of the object
+func (m *Meta) UpdateObjectRestoreExpiry(object *Object) error {
+	err := m.Client.UpdateObjectRestoreExpiry(object)
+	if err != nil {
+		return err
+	}
+	m.Cache.Remove(redis.ObjectTable, object.BucketName+":"+object.Name+":")
+	m.Cache.Remove(redis.ObjectTable,
+		object.BucketName+":"+object.Name+":"+object.GetVersionId())
+	return nil
+}
+
+// Also clears cached entries of the object
+func (m *Meta) AppendObject(object *Object, part *Part, lastModified time.Time) error {
+	err := m.Client.AppendObject(object, part, lastModified)
+	if err != nil {
+		return err
+	}
+	m.Cache.Remove(redis.ObjectTable, object.BucketName+":"+object.Name+":")
+	m.Cache.Remove(redis.ObjectTable,
+		object.BucketName+":"+object.Name+":"+object.GetVersionId())
+	return nil
+}
+
+func (m *Meta) UpdateObjectLocation(object *Object, oldLocation, oldPool string) (bool, error) {
+	return m.Client.UpdateObjectLocation(object, oldLocation, oldPool)
+}
@@ -0,0 +1,149 @@
+package meta
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+	"github.com/journeymidnight/yig/meta/client"
+	. "github.com/journeymidnight/yig/meta/types"
+)
+
+// slowClient takes `latency` for every request, like a remote HBase
+type slowClient struct {
+	client.Client
+	latency   time.Duration
+	lock      sync.Mutex
+	objects   map[string]bool
+	objMaps   map[string]bool
+	objectErr error
+	objMapErr error
+}
+
+func newSlowClient(latency time.Duration) *slowClient {
+	return &slowClient{
+		latency: latency,
+		objects: make(map[string]bool),
+		objMaps: make(map[string]bool),
+	}
+}
+
+func (c *slowClient) PutObject(object *Object) error {
+	time.Sleep(c.latency)
+	if c.objectErr != nil {
+		return c.objectErr
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.objects[object.Name] = true
+	return nil
+}
+
+func (c *slowClient) DeleteObject(object *Object) error {
+	time.Sleep(c.latency)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.objects, object.Name)
+	return nil
+}
+
+func (c *slowClient) PutObjectMap(objMap *ObjMap) error {
+	time.Sleep(c.latency)
+	if c.objMapErr != nil {
+		return c.objMapErr
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.objMaps[objMap.Name] = true
+	return nil
+}
+
+func (c *slowClient) DeleteObjectMap(objMap *ObjMap) error {
+	time.Sleep(c.latency)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.objMaps, objMap.Name)
+	return nil
+}
+
+func newSlowMeta(latency time.Duration) (*Meta, *slowClient) {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	c := newSlowClient(latency)
+	return &Meta{Client: c, Cache: newMetaCache(NoCache)}, c
+}
+
+func newTestObject() (*Object, *ObjMap) {
+	now := time.Now()
+	object := &Object{BucketName: "bucket", Name: "hehe", LastModifiedTime: now,
+		NullVersion: true}
+	objMap := &ObjMap{BucketName: "bucket", Name: "hehe", NullVerNum: uint64(now.UnixNano())}
+	return object, objMap
+}
+
+func TestPutObjectEntriesRollback(t *testing.T) {
+	m, c := newSlowMeta(0)
+	object, objMap := newTestObject()
+	if err := m.PutObjectEntries(context.Background(), object, objMap); err != nil {
+		t.Fatal(err)
+	}
+	if !c.objects["hehe"] || !c.objMaps["hehe"] {
+		t.Fatal("both entries should be put")
+	}
+
+	failure := errors.New("hehe")
+	for i := 0; i < 2; i++ {
+		m, c = newSlowMeta(0)
+		if i == 0 {
+			c.objectErr = failure
+		} else {
+			c.objMapErr = failure
+		}
+		if err := m.PutObjectEntries(context.Background(), object, objMap); err != failure {
+			t.Errorf("expected error %v, got %v", failure, err)
+		}
+		if len(c.objects) != 0 || len(c.objMaps) != 0 {
+			t.Errorf("entries left after failure: %v %v", c.objects, c.objMaps)
+		}
+	}
+}
+
+func TestPutObjectEntriesConcurrently(t *testing.T) {
+	m, _ := newSlowMeta(50 * time.Millisecond)
+	object, objMap := newTestObject()
+	start := time.Now()
+	if err := m.PutObjectEntries(context.Background(), object, objMap); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 90*time.Millisecond {
+		t.Errorf("entries should be put concurrently, took %v", time.Since(start))
+	}
+}
+
+// Putting entries one after another, as done before PutObjectEntries
+func BenchmarkPutObjectEntriesSequential(b *testing.B) {
+	m, _ := newSlowMeta(time.Millisecond)
+	object, objMap := newTestObject()
+	for i := 0; i < b.N; i++ {
+		if err := m.PutObjectEntry(object); err != nil {
+			b.Fatal(err)
+		}
+		if err := m.PutObjMapEntry(objMap); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPutObjectEntries(b *testing.B) {
+	m, _ := newSlowMeta(time.Millisecond)
+	object, objMap := newTestObject()
+	for i := 0; i < b.N; i++ {
+		if err := m.PutObjectEntries(context.Background(), object, objMap); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
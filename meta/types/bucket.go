@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/dustin/go-humanize"
 	"github.com/journeymidnight/yig/api/datatype"
+	"strconv"
 	"time"
 )
 
@@ -21,6 +22,68 @@ type Bucket struct {
 	LC         datatype.Lc
 	Versioning string // actually enum: Disabled/Enabled/Suspended
 	Usage      int64
+	Encryption datatype.Encryption // default server side encryption
+	ObjectLock datatype.ObjectLockConfiguration
+	// who pays for requests, empty if not configured, which means BucketOwner
+	RequestPayer string
+	BucketRateLimit
+	MaxObjectSize int64 // in bytes, 0 means no limit
+	// serve "<key>.gz" for GET "<key>" to clients accepting gzip
+	GzipVariants bool
+}
+
+// Limits of requests and bandwidth per second of a bucket, 0 means no limit.
+// Reads are GET of objects, writes are PUT.
+type BucketRateLimit struct {
+	ReadRPSLimit      int64
+	WriteRPSLimit     int64
+	ReadBandwidthBps  int64 // bytes per second
+	WriteBandwidthBps int64
+}
+
+func (l BucketRateLimit) IsLimited() bool {
+	return l.ReadRPSLimit > 0 || l.WriteRPSLimit > 0 ||
+		l.ReadBandwidthBps > 0 || l.WriteBandwidthBps > 0
+}
+
+// Statistics of a bucket collected by scanning its metadata
+type BucketStats struct {
+	TotalBytes int64 `json:"totalBytes"`
+	// keys whose latest version is not a delete marker
+	ObjectCount          int64                        `json:"objectCount"`
+	MultipartUploadCount int64                        `json:"multipartUploadCount"`
+	VersionsCount        int64                        `json:"versionsCount"`
+	DeleteMarkerCount    int64                        `json:"deleteMarkerCount"`
+	StorageClasses       map[string]StorageClassStats `json:"storageClasses"`
+}
+
+type StorageClassStats struct {
+	TotalBytes    int64 `json:"totalBytes"`
+	VersionsCount int64 `json:"versionsCount"`
+}
+
+// Count a version of an object, `latest` if it's the newest version of
+// its key. Empty `storageClass` is taken as STANDARD
+func (s *BucketStats) AddVersion(size int64, storageClass string, deleteMarker bool, latest bool) {
+	if deleteMarker {
+		s.DeleteMarkerCount += 1
+		return
+	}
+	if latest {
+		s.ObjectCount += 1
+	}
+	s.VersionsCount += 1
+	s.TotalBytes += size
+	if s.StorageClasses == nil {
+		s.StorageClasses = make(map[string]StorageClassStats)
+	}
+	if storageClass == "" {
+		storageClass = datatype.STORAGE_CLASS_STANDARD
+	}
+	class := s.StorageClasses[storageClass]
+	class.TotalBytes += size
+	class.VersionsCount += 1
+	s.StorageClasses[storageClass] = class
 }
 
 func (b *Bucket) String() (s string) {
@@ -32,6 +95,12 @@ func (b *Bucket) String() (s string) {
 	s += "LifeCycle: " + fmt.Sprintf("%+v", b.LC) + "\n"
 	s += "Version: " + b.Versioning + "\n"
 	s += "Usage: " + humanize.Bytes(uint64(b.Usage)) + "\n"
+	s += "Encryption: " + fmt.Sprintf("%+v", b.Encryption) + "\n"
+	s += "ObjectLock: " + fmt.Sprintf("%+v", b.ObjectLock) + "\n"
+	s += "RequestPayer: " + b.RequestPayer + "\n"
+	s += "RateLimit: " + fmt.Sprintf("%+v", b.BucketRateLimit) + "\n"
+	s += "MaxObjectSize: " + humanize.Bytes(uint64(b.MaxObjectSize)) + "\n"
+	s += "GzipVariants: " + strconv.FormatBool(b.GzipVariants) + "\n"
 	return
 }
 
@@ -46,41 +115,77 @@ func (b *Bucket) GetValues() (values map[string]map[string][]byte, err error) {
 	if err != nil {
 		return
 	}
+	encryption, err := json.Marshal(b.Encryption)
+	if err != nil {
+		return
+	}
+	objectLock, err := json.Marshal(b.ObjectLock)
+	if err != nil {
+		return
+	}
+	rateLimit, err := json.Marshal(b.BucketRateLimit)
+	if err != nil {
+		return
+	}
+	var grants []byte
+	if len(b.ACL.Grants) != 0 {
+		grants, err = json.Marshal(b.ACL.Grants)
+		if err != nil {
+			return
+		}
+	}
 	var usage bytes.Buffer
 	err = binary.Write(&usage, binary.BigEndian, b.Usage)
 	if err != nil {
 		return
 	}
+	var maxObjectSize bytes.Buffer
+	err = binary.Write(&maxObjectSize, binary.BigEndian, b.MaxObjectSize)
+	if err != nil {
+		return
+	}
 	values = map[string]map[string][]byte{
 		BUCKET_COLUMN_FAMILY: map[string][]byte{
-			"UID":        []byte(b.OwnerId),
-			"ACL":        []byte(b.ACL.CannedAcl),
-			"CORS":       cors,
-			"LC":         lc,
-			"createTime": []byte(b.CreateTime.Format(CREATE_TIME_LAYOUT)),
-			"versioning": []byte(b.Versioning),
-			"usage":      usage.Bytes(),
+			"UID":           []byte(b.OwnerId),
+			"ACL":           []byte(b.ACL.CannedAcl),
+			"grants":        grants,
+			"CORS":          cors,
+			"LC":            lc,
+			"createTime":    []byte(b.CreateTime.Format(CREATE_TIME_LAYOUT)),
+			"versioning":    []byte(b.Versioning),
+			"usage":         usage.Bytes(),
+			"encryption":    encryption,
+			"objectLock":    objectLock,
+			"payer":         []byte(b.RequestPayer),
+			"rateLimit":     rateLimit,
+			"maxObjectSize": maxObjectSize.Bytes(),
+			"gzipVariants":  []byte(strconv.FormatBool(b.GzipVariants)),
 		},
-		// TODO fancy ACL
 	}
 	return
 }
 
-//Tidb related function
+// Tidb related function
 func (b Bucket) GetUpdateSql() string {
-	acl, _ := json.Marshal(b.ACL)
+	acl, grants := aclSql(b.ACL)
 	cors, _ := json.Marshal(b.CORS)
 	lc, _ := json.Marshal(b.LC)
-	sql := fmt.Sprintf("update buckets set bucketname='%s',acl='%s',cors='%s',lc='%s',uid='%s',usages=%d,versioning='%s' where bucketname='%s'", b.Name, acl, cors, lc, b.OwnerId, b.Usage, b.Versioning, b.Name)
+	encryption, _ := json.Marshal(b.Encryption)
+	objectLock, _ := json.Marshal(b.ObjectLock)
+	rateLimit, _ := json.Marshal(b.BucketRateLimit)
+	sql := fmt.Sprintf("update buckets set bucketname='%s',acl='%s',cors='%s',lc='%s',uid='%s',usages=%d,versioning='%s',encryption='%s',objectlock='%s',payer='%s',ratelimit='%s',maxobjectsize=%d,gzipvariants=%t,grants='%s' where bucketname='%s'", b.Name, acl, cors, lc, b.OwnerId, b.Usage, b.Versioning, encryption, objectLock, b.RequestPayer, rateLimit, b.MaxObjectSize, b.GzipVariants, grants, b.Name)
 
 	return sql
 }
 
 func (b Bucket) GetCreateSql() string {
-	acl, _ := json.Marshal(b.ACL)
+	acl, grants := aclSql(b.ACL)
 	cors, _ := json.Marshal(b.CORS)
 	lc, _ := json.Marshal(b.LC)
+	encryption, _ := json.Marshal(b.Encryption)
+	objectLock, _ := json.Marshal(b.ObjectLock)
+	rateLimit, _ := json.Marshal(b.BucketRateLimit)
 	createTime := b.CreateTime.Format(TIME_LAYOUT_TIDB)
-	sql := fmt.Sprintf("insert into buckets values('%s','%s','%s','%s','%s','%s',%d,'%s');", b.Name, acl, cors, lc, b.OwnerId, createTime, b.Usage, b.Versioning)
+	sql := fmt.Sprintf("insert into buckets values('%s','%s','%s','%s','%s','%s',%d,'%s','%s','%s','%s','%s',%d,%t,'%s');", b.Name, acl, cors, lc, b.OwnerId, createTime, b.Usage, b.Versioning, encryption, objectLock, b.RequestPayer, rateLimit, b.MaxObjectSize, b.GzipVariants, grants)
 	return sql
 }
@@ -10,6 +10,13 @@ const (
 	ResponseNumberOfRows         = 1024
 )
 
+// Limits of multipart uploads
+const (
+	MIN_PART_SIZE   = 128 << 10 // 128KB
+	MAX_PART_SIZE   = 5 << 30   // 5GB
+	MAX_PART_NUMBER = 10000
+)
+
 const (
 	BUCKET_TABLE                          = "buckets"
 	BUCKET_COLUMN_FAMILY                  = "b"
@@ -52,6 +52,10 @@ type MultipartMetadata struct {
 	SseRequest    datatype.SseRequest
 	EncryptionKey []byte
 	Attrs         map[string]string
+	StorageClass  string
+	// seconds the completed object lives, from "x-yig-ttl" header on
+	// initiation, zero means never expire
+	Ttl int64
 }
 
 type Multipart struct {
@@ -146,11 +150,11 @@ func valuesForParts(parts map[int]*Part) (values map[string][]byte, err error) {
 }
 
 func (p *Part) GetCreateSql(bucketname, objectname, version string) string {
-	sql := fmt.Sprintf("insert into objectpart values(%d,%d,'%s',%d,'%s','%s','%s','%s','%s','%s')", p.PartNumber, p.Size, p.ObjectId, p.Offset, p.Etag, p.LastModified, p.InitializationVector, bucketname, objectname, version)
+	sql := fmt.Sprintf("insert into objectpart values(%d,%d,'%s',%d,'%s','%s',x'%x','%s','%s','%s')", p.PartNumber, p.Size, p.ObjectId, p.Offset, p.Etag, p.LastModified, p.InitializationVector, bucketname, objectname, version)
 	return sql
 }
 
 func (p *Part) GetCreateGcSql(bucketname, objectname string, version uint64) string {
-	sql := fmt.Sprintf("insert into gcpart values(%d,%d,'%s',%d,'%s','%s','%s','%s','%s',%d)", p.PartNumber, p.Size, p.ObjectId, p.Offset, p.Etag, p.LastModified, p.InitializationVector, bucketname, objectname, version)
+	sql := fmt.Sprintf("insert into gcpart values(%d,%d,'%s',%d,'%s','%s',x'%x','%s','%s',%d)", p.PartNumber, p.Size, p.ObjectId, p.Offset, p.Etag, p.LastModified, p.InitializationVector, bucketname, objectname, version)
 	return sql
 }
@@ -10,12 +10,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
 	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/meta/util"
 	"github.com/xxtea/xxtea-go/xxtea"
 	"io"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -45,6 +47,36 @@ type Object struct {
 	// in AES256-GCM
 	EncryptionKey        []byte
 	InitializationVector []byte
+	// set from "x-yig-ttl" header of uploads, copies and POST, zero means
+	// never expire, see SetTtl
+	ExpireTime time.Time
+	// Object Lock, version couldn't be deleted before RetainUntilDate,
+	// or while LegalHold is on
+	RetentionMode   string // GOVERNANCE or COMPLIANCE, empty if not retained
+	RetainUntilDate time.Time
+	LegalHold       bool
+	// created by AppendObject, each append is saved as a part
+	Appendable   bool
+	StorageClass string
+	// name of the other object referencing the same data, set on both
+	// objects while a rename is in progress, see YigStorage.RenameObject
+	SharedWith string
+	// x-amz-checksum-* of the object put, see datatype.Checksums
+	ChecksumAlgorithm string
+	Checksum          string
+	// copies of object data in Ceph clusters other than Location, written
+	// when CONFIG.EnableDualWrite is set
+	Replicas []Replica
+	// set by RestoreObject on archived objects, zero if never restored,
+	// see datatype.RestoreRequest
+	RestoreExpiryDate time.Time
+}
+
+// Where a copy of object data locates
+type Replica struct {
+	Location string
+	Pool     string
+	ObjectId string
 }
 
 func (o *Object) String() (s string) {
@@ -52,6 +84,9 @@ func (o *Object) String() (s string) {
 	s += "Location: " + o.Location + "\n"
 	s += "Pool: " + o.Pool + "\n"
 	s += "Object ID: " + o.ObjectId + "\n"
+	for _, replica := range o.Replicas {
+		s += fmt.Sprintln("Replica:", replica.Location, replica.Pool, replica.ObjectId)
+	}
 	s += "Last Modified Time: " + o.LastModifiedTime.Format(CREATE_TIME_LAYOUT) + "\n"
 	s += "Version: " + o.VersionId + "\n"
 	for n, part := range o.Parts {
@@ -60,6 +95,44 @@ func (o *Object) String() (s string) {
 	return s
 }
 
+// Let the object expire `ttl` seconds after it's last modified, the object
+// never expires if `ttl` is not positive
+func (o *Object) SetTtl(ttl int64) {
+	if ttl > 0 {
+		o.ExpireTime = o.LastModifiedTime.Add(time.Duration(ttl) * time.Second)
+	}
+}
+
+// Expired objects are invisible to clients, and deleted by lifecycle
+// tool eventually
+func (o *Object) IsExpired(now time.Time) bool {
+	return !o.ExpireTime.IsZero() && !now.Before(o.ExpireTime)
+}
+
+func (o *Object) IsRetained(now time.Time) bool {
+	return o.RetentionMode != "" && now.Before(o.RetainUntilDate)
+}
+
+// If the version is protected from deletion by Object Lock, retention
+// in GOVERNANCE mode is ignored if `bypassGovernance`
+func (o *Object) IsLocked(now time.Time, bypassGovernance bool) bool {
+	if o.LegalHold {
+		return true
+	}
+	if !o.IsRetained(now) {
+		return false
+	}
+	return !(bypassGovernance && o.RetentionMode == datatype.RETENTION_MODE_GOVERNANCE)
+}
+
+// If retention in `mode` until `retainUntil` would weaken current retention
+// of the version, i.e. shorten it or change COMPLIANCE mode to another
+func (o *Object) IsRetentionWeakenedBy(mode string, retainUntil time.Time) bool {
+	return retainUntil.Before(o.RetainUntilDate) ||
+		(o.RetentionMode == datatype.RETENTION_MODE_COMPLIANCE &&
+			mode != datatype.RETENTION_MODE_COMPLIANCE)
+}
+
 func (o *Object) GetVersionNumber() (uint64, error) {
 	decrypted, err := util.Decrypt(o.VersionId)
 	if err != nil {
@@ -78,10 +151,15 @@ func (o *Object) GetVersionNumber() (uint64, error) {
 // ObjectName +
 // ObjectNameSeparator +
 // bigEndian(uint64.max - unixNanoTimestamp)
+// Names containing ObjectNameSeparator are rejected, or rows of "a" could
+// be taken as versions of "a\n..." in prefix scans
 func (o *Object) GetRowkey() (string, error) {
 	if len(o.Rowkey) != 0 {
 		return string(o.Rowkey), nil
 	}
+	if strings.Contains(o.Name, ObjectNameSeparator) {
+		return "", ErrInvalidObjectName
+	}
 	var rowkey bytes.Buffer
 	rowkey.WriteString(o.BucketName + ObjectNameSeparator)
 	rowkey.WriteString(o.Name + ObjectNameSeparator)
@@ -117,24 +195,62 @@ func (o *Object) GetValues() (values map[string]map[string][]byte, err error) {
 			return
 		}
 	}
+	var grantsData []byte
+	if len(o.ACL.Grants) != 0 {
+		grantsData, err = json.Marshal(o.ACL.Grants)
+		if err != nil {
+			return
+		}
+	}
+	var expireData []byte
+	if !o.ExpireTime.IsZero() {
+		expireData = []byte(strconv.FormatInt(o.ExpireTime.Unix(), 10))
+	}
+	var retainUntilData []byte
+	if !o.RetainUntilDate.IsZero() {
+		retainUntilData = []byte(strconv.FormatInt(o.RetainUntilDate.Unix(), 10))
+	}
+	var replicasData []byte
+	if len(o.Replicas) != 0 {
+		replicasData, err = json.Marshal(o.Replicas)
+		if err != nil {
+			return
+		}
+	}
+	var restoreExpiryData []byte
+	if !o.RestoreExpiryDate.IsZero() {
+		restoreExpiryData = []byte(strconv.FormatInt(o.RestoreExpiryDate.Unix(), 10))
+	}
 	values = map[string]map[string][]byte{
 		OBJECT_COLUMN_FAMILY: map[string][]byte{
-			"bucket":        []byte(o.BucketName),
-			"location":      []byte(o.Location),
-			"pool":          []byte(o.Pool),
-			"owner":         []byte(o.OwnerId),
-			"oid":           []byte(o.ObjectId),
-			"size":          size.Bytes(),
-			"lastModified":  []byte(o.LastModifiedTime.Format(CREATE_TIME_LAYOUT)),
-			"etag":          []byte(o.Etag),
-			"content-type":  []byte(o.ContentType),
-			"attributes":    attrsData, // TODO
-			"ACL":           []byte(o.ACL.CannedAcl),
-			"nullVersion":   []byte(helper.Ternary(o.NullVersion, "true", "false").(string)),
-			"deleteMarker":  []byte(helper.Ternary(o.DeleteMarker, "true", "false").(string)),
-			"sseType":       []byte(o.SseType),
-			"encryptionKey": o.EncryptionKey,
-			"IV":            o.InitializationVector,
+			"bucket":            []byte(o.BucketName),
+			"location":          []byte(o.Location),
+			"pool":              []byte(o.Pool),
+			"owner":             []byte(o.OwnerId),
+			"oid":               []byte(o.ObjectId),
+			"size":              size.Bytes(),
+			"lastModified":      []byte(o.LastModifiedTime.Format(CREATE_TIME_LAYOUT)),
+			"etag":              []byte(o.Etag),
+			"content-type":      []byte(o.ContentType),
+			"attributes":        attrsData, // TODO
+			"ACL":               []byte(o.ACL.CannedAcl),
+			"grants":            grantsData,
+			"nullVersion":       []byte(helper.Ternary(o.NullVersion, "true", "false").(string)),
+			"deleteMarker":      []byte(helper.Ternary(o.DeleteMarker, "true", "false").(string)),
+			"sseType":           []byte(o.SseType),
+			"encryptionKey":     o.EncryptionKey,
+			"IV":                o.InitializationVector,
+			"expireTime":        expireData,
+			"retentionMode":     []byte(o.RetentionMode),
+			"retainUntil":       retainUntilData,
+			"legalHold":         []byte(helper.Ternary(o.LegalHold, "true", "false").(string)),
+			"appendable":        []byte(helper.Ternary(o.Appendable, "true", "false").(string)),
+			"storageClass":      []byte(o.StorageClass),
+			"sharedWith":        []byte(o.SharedWith),
+			"checksumAlgorithm": []byte(o.ChecksumAlgorithm),
+			"checksum":          []byte(o.Checksum),
+			"replicas":          replicasData,
+			"restoreExpiry":     restoreExpiryData,
 		},
 	}
 	if len(o.Parts) != 0 {
@@ -146,6 +262,79 @@ func (o *Object) GetValues() (values map[string]map[string][]byte, err error) {
 	return
 }
 
+// Columns updated when data of an object is moved to another cluster or
+// pool, see Client.UpdateObjectLocation. Storage class goes with the pool
+func (o *Object) GetLocationValues() (values map[string]map[string][]byte, err error) {
+	values = map[string]map[string][]byte{
+		OBJECT_COLUMN_FAMILY: map[string][]byte{
+			"location":     []byte(o.Location),
+			"pool":         []byte(o.Pool),
+			"oid":          []byte(o.ObjectId),
+			"storageClass": []byte(o.StorageClass),
+		},
+	}
+	if len(o.Parts) != 0 {
+		values[OBJECT_PART_COLUMN_FAMILY], err = valuesForParts(o.Parts)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Columns replaced when metadata of an object is updated in place,
+// see Client.UpdateObjectAttrs
+func (o *Object) GetAttrValues() (values map[string]map[string][]byte, err error) {
+	var attrsData []byte
+	if o.CustomAttributes != nil {
+		attrsData, err = json.Marshal(o.CustomAttributes)
+		if err != nil {
+			return
+		}
+	}
+	var grantsData []byte
+	if len(o.ACL.Grants) != 0 {
+		grantsData, err = json.Marshal(o.ACL.Grants)
+		if err != nil {
+			return
+		}
+	}
+	values = map[string]map[string][]byte{
+		OBJECT_COLUMN_FAMILY: map[string][]byte{
+			"content-type": []byte(o.ContentType),
+			"attributes":   attrsData,
+			"ACL":          []byte(o.ACL.CannedAcl),
+			"grants":       grantsData,
+		},
+	}
+	return
+}
+
+// Columns updated when `part` is appended to an appendable object, whose
+// Size and Etag are already updated, see Client.AppendObject
+func (o *Object) GetAppendValues(part *Part, lastModified time.Time) (values map[string]map[string][]byte,
+	err error) {
+
+	var size bytes.Buffer
+	err = binary.Write(&size, binary.BigEndian, o.Size)
+	if err != nil {
+		return
+	}
+	partValues, err := valuesForParts(map[int]*Part{part.PartNumber: part})
+	if err != nil {
+		return
+	}
+	values = map[string]map[string][]byte{
+		OBJECT_COLUMN_FAMILY: map[string][]byte{
+			"size":         size.Bytes(),
+			"etag":         []byte(o.Etag),
+			"lastModified": []byte(lastModified.Format(CREATE_TIME_LAYOUT)),
+		},
+		OBJECT_PART_COLUMN_FAMILY: partValues,
+	}
+	return
+}
+
 func (o *Object) GetValuesForDelete() (values map[string]map[string][]byte) {
 	return map[string]map[string][]byte{
 		OBJECT_COLUMN_FAMILY:      map[string][]byte{},
@@ -167,20 +356,59 @@ func (o *Object) encryptSseKey() (err error) {
 		}
 	}
 
-	block, err := aes.NewCipher(SSE_S3_MASTER_KEY)
-	if err != nil {
-		return err
+	o.EncryptionKey, err = EncryptSseKey(o.InitializationVector, o.EncryptionKey)
+	return err
+}
+
+// Master key used to encrypt data keys of SSE-S3 objects
+func sseMasterKey() []byte {
+	if helper.CONFIG.SseS3MasterKey != "" {
+		return []byte(helper.CONFIG.SseS3MasterKey)
 	}
+	return SSE_S3_MASTER_KEY
+}
 
-	aesGcm, err := cipher.NewGCM(block)
+func sseKeyCipher(masterKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(masterKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
+// Encrypt data key of an object with current master key
+func EncryptSseKey(initializationVector []byte, plainText []byte) ([]byte, error) {
+	aesGcm, err := sseKeyCipher(sseMasterKey())
+	if err != nil {
+		return nil, err
+	}
 	// InitializationVector is 16 bytes(because of CTR), but use only first 12 bytes in GCM
 	// for performance
-	o.EncryptionKey = aesGcm.Seal(nil, o.InitializationVector[:12], o.EncryptionKey, nil)
-	return nil
+	return aesGcm.Seal(nil, initializationVector[:12], plainText, nil), nil
+}
+
+// Decrypt data key of an object with current master key, or previous ones if
+// the key is encrypted before master key is rotated
+func DecryptSseKey(initializationVector []byte, cipherText []byte) (plainText []byte, err error) {
+	if len(cipherText) == 0 {
+		return
+	}
+	masterKeys := [][]byte{sseMasterKey()}
+	for _, key := range helper.CONFIG.SseS3PreviousKeys {
+		masterKeys = append(masterKeys, []byte(key))
+	}
+	for _, masterKey := range masterKeys {
+		var aesGcm cipher.AEAD
+		aesGcm, err = sseKeyCipher(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		plainText, err = aesGcm.Open(nil, initializationVector[:12], cipherText, nil)
+		if err == nil {
+			return plainText, nil
+		}
+	}
+	return nil, err
 }
 
 func (o *Object) GetVersionId() string {
@@ -200,8 +428,40 @@ func (o *Object) GetVersionId() string {
 func (o *Object) GetCreateSql() string {
 	version := math.MaxUint64 - uint64(o.LastModifiedTime.UnixNano())
 	customAttributes, _ := json.Marshal(o.CustomAttributes)
-	acl, _ := json.Marshal(o.ACL)
+	acl, grants := o.GetAclSql()
 	lastModifiedTime := o.LastModifiedTime.Format(TIME_LAYOUT_TIDB)
-	sql := fmt.Sprintf("insert into objects values('%s','%s',%d,'%s','%s','%s','%d','%s','%s','%s','%s','%s','%s',%t,%t,'%s','%s','%s')", o.BucketName, o.Name, version, o.Location, o.Pool, o.OwnerId, o.Size, o.ObjectId, lastModifiedTime, o.Etag, o.ContentType, customAttributes, acl, o.NullVersion, o.DeleteMarker, o.SseType, o.EncryptionKey, o.InitializationVector)
+	var expireTime, retainUntil, restoreExpiry int64
+	if !o.ExpireTime.IsZero() {
+		expireTime = o.ExpireTime.Unix()
+	}
+	if !o.RetainUntilDate.IsZero() {
+		retainUntil = o.RetainUntilDate.Unix()
+	}
+	if !o.RestoreExpiryDate.IsZero() {
+		restoreExpiry = o.RestoreExpiryDate.Unix()
+	}
+	var replicas []byte
+	if len(o.Replicas) != 0 {
+		replicas, _ = json.Marshal(o.Replicas)
+	}
+	sql := fmt.Sprintf("insert into objects values('%s','%s',%d,'%s','%s','%s','%d','%s','%s','%s','%s','%s','%s',%t,%t,'%s',x'%x',x'%x',%d,'%s',%d,%t,%t,'%s','%s','%s','%s','%s','%s',%d)", o.BucketName, o.Name, version, o.Location, o.Pool, o.OwnerId, o.Size, o.ObjectId, lastModifiedTime, o.Etag, o.ContentType, customAttributes, acl, o.NullVersion, o.DeleteMarker, o.SseType, o.EncryptionKey, o.InitializationVector, expireTime, o.RetentionMode, retainUntil, o.LegalHold, o.Appendable, grants, o.StorageClass, o.SharedWith,
+		o.ChecksumAlgorithm, o.Checksum, replicas, restoreExpiry)
 	return sql
 }
+
+// Values of `acl` and `grants` columns
+func (o *Object) GetAclSql() (acl, grants string) {
+	return aclSql(o.ACL)
+}
+
+// Explicit grants are kept in their own column since a list of them easily
+// outgrows `acl`, `grants` is empty if there's none. Same for buckets and
+// objects
+func aclSql(acl datatype.Acl) (cannedAcl, grants string) {
+	cannedAclData, _ := json.Marshal(datatype.Acl{CannedAcl: acl.CannedAcl})
+	if len(acl.Grants) != 0 {
+		grantsData, _ := json.Marshal(acl.Grants)
+		grants = string(grantsData)
+	}
+	return string(cannedAclData), grants
+}
@@ -0,0 +1,198 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/helper"
+)
+
+func TestObjectExpireTime(t *testing.T) {
+	now := time.Now()
+	object := &Object{
+		Name:             "hehe",
+		BucketName:       "bucket",
+		LastModifiedTime: now,
+	}
+	if object.IsExpired(now.Add(100 * 365 * 24 * time.Hour)) {
+		t.Error("Object without TTL should never expire")
+	}
+	values, err := object.GetValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values[OBJECT_COLUMN_FAMILY]["expireTime"]) != 0 {
+		t.Error("expireTime should be empty for object without TTL")
+	}
+	if !strings.Contains(object.GetCreateSql(), ",0,'',0,false,false,'','','','','','',0)") {
+		t.Errorf("expiretime should be 0 for object without TTL: %s", object.GetCreateSql())
+	}
+
+	object.ExpireTime = now.Add(time.Minute)
+	if object.IsExpired(now) {
+		t.Error("Object should not expire before its expire time")
+	}
+	if !object.IsExpired(object.ExpireTime) || !object.IsExpired(now.Add(time.Hour)) {
+		t.Error("Object should expire after its expire time")
+	}
+	values, err = object.GetValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strconv.FormatInt(object.ExpireTime.Unix(), 10)
+	if string(values[OBJECT_COLUMN_FAMILY]["expireTime"]) != expected {
+		t.Errorf("expireTime expected %s, got %s", expected,
+			values[OBJECT_COLUMN_FAMILY]["expireTime"])
+	}
+	if !strings.Contains(object.GetCreateSql(), ","+expected+",'',0,false,false,'','','','','','',0)") {
+		t.Errorf("expiretime should be %s: %s", expected, object.GetCreateSql())
+	}
+}
+
+func TestObjectLocked(t *testing.T) {
+	now := time.Now()
+	object := &Object{
+		Name:       "hehe",
+		BucketName: "bucket",
+	}
+	if object.IsLocked(now, false) {
+		t.Error("Object without retention should not be locked")
+	}
+
+	object.RetentionMode = "GOVERNANCE"
+	object.RetainUntilDate = now.Add(time.Hour)
+	if !object.IsLocked(now, false) {
+		t.Error("Object in GOVERNANCE mode should be locked")
+	}
+	if object.IsLocked(now, true) {
+		t.Error("GOVERNANCE mode should be bypassed")
+	}
+	if object.IsLocked(now.Add(2*time.Hour), false) {
+		t.Error("Object should not be locked after retain until date")
+	}
+
+	object.RetentionMode = "COMPLIANCE"
+	if !object.IsLocked(now, true) {
+		t.Error("COMPLIANCE mode should not be bypassed")
+	}
+
+	object.RetentionMode = ""
+	object.LegalHold = true
+	if !object.IsLocked(now, true) {
+		t.Error("Object under legal hold should be locked")
+	}
+	values, err := object.GetValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(values[OBJECT_COLUMN_FAMILY]["legalHold"]) != "true" {
+		t.Errorf("legalHold expected true, got %s", values[OBJECT_COLUMN_FAMILY]["legalHold"])
+	}
+}
+
+func TestRetentionWeakened(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	cases := []struct {
+		mode        string
+		newMode     string
+		retainUntil time.Time
+		weakened    bool
+	}{
+		{"GOVERNANCE", "GOVERNANCE", later, false},
+		{"GOVERNANCE", "GOVERNANCE", now.Add(2 * time.Hour), false},
+		{"GOVERNANCE", "GOVERNANCE", now, true},
+		{"GOVERNANCE", "COMPLIANCE", later, false},
+		{"GOVERNANCE", "COMPLIANCE", now, true},
+		{"COMPLIANCE", "COMPLIANCE", now.Add(2 * time.Hour), false},
+		{"COMPLIANCE", "GOVERNANCE", now.Add(2 * time.Hour), true},
+		{"COMPLIANCE", "COMPLIANCE", now, true},
+		{"COMPLIANCE", "", time.Time{}, true},
+	}
+	for _, c := range cases {
+		object := &Object{RetentionMode: c.mode, RetainUntilDate: later}
+		if weakened := object.IsRetentionWeakenedBy(c.newMode, c.retainUntil); weakened != c.weakened {
+			t.Errorf("%s to %s until %v: expected weakened %v, got %v", c.mode, c.newMode,
+				c.retainUntil.Sub(now), c.weakened, weakened)
+		}
+	}
+}
+
+func TestBinaryColumnsInSql(t *testing.T) {
+	// random bytes may contain quotes and backslashes
+	initializationVector := []byte("\x00'\\\x7f\xff")
+	object := &Object{
+		Name:                 "hehe",
+		BucketName:           "bucket",
+		EncryptionKey:        []byte("'"),
+		InitializationVector: initializationVector,
+	}
+	if !strings.Contains(object.GetCreateSql(), ",x'27',x'00275c7fff',") {
+		t.Errorf("binary columns should be hex literals: %s", object.GetCreateSql())
+	}
+	part := &Part{InitializationVector: initializationVector}
+	if !strings.Contains(part.GetCreateSql("bucket", "hehe", "0"), "x'00275c7fff'") {
+		t.Errorf("IV of part should be hex literal: %s", part.GetCreateSql("bucket", "hehe", "0"))
+	}
+}
+
+// Other columns may be changed while data is moved, they're not written
+// back by the conditional update of location
+func TestLocationValues(t *testing.T) {
+	object := &Object{Name: "hehe", BucketName: "bucket", Location: "ceph", Pool: "tiger",
+		ObjectId: "oid", StorageClass: "GLACIER", ContentType: "text/plain", Etag: "etag",
+		Parts: map[int]*Part{1: {PartNumber: 1, ObjectId: "oid-1"}}}
+	values, err := object.GetLocationValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var columns []string
+	for family, qualifiers := range values {
+		for qualifier := range qualifiers {
+			columns = append(columns, family+":"+qualifier)
+		}
+	}
+	sort.Strings(columns)
+	expected := "[o:location o:oid o:pool o:storageClass p:1]"
+	if fmt.Sprint(columns) != expected {
+		t.Errorf("expected columns %s, got %v", expected, columns)
+	}
+}
+
+func TestSseKeyRotation(t *testing.T) {
+	defer func() {
+		helper.CONFIG.SseS3MasterKey, helper.CONFIG.SseS3PreviousKeys = "", nil
+	}()
+	iv := []byte("0123456789abcdef")
+	dataKey := []byte("data key of some object, 32 byte")
+	encrypted, err := EncryptSseKey(iv, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.CONFIG.SseS3MasterKey = "new master key of 32 bytes......"
+	if _, err := DecryptSseKey(iv, encrypted); err == nil {
+		t.Error("data key encrypted with previous master key should not be decrypted without it")
+	}
+	helper.CONFIG.SseS3PreviousKeys = []string{"some other key of 32 bytes......",
+		string(SSE_S3_MASTER_KEY)}
+	decrypted, err := DecryptSseKey(iv, encrypted)
+	if err != nil || !bytes.Equal(decrypted, dataKey) {
+		t.Fatalf("data key should be decrypted with previous master key, got %q %v", decrypted, err)
+	}
+
+	rotated, err := EncryptSseKey(iv, decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper.CONFIG.SseS3PreviousKeys = nil
+	decrypted, err = DecryptSseKey(iv, rotated)
+	if err != nil || !bytes.Equal(decrypted, dataKey) {
+		t.Errorf("rotated data key should be decrypted with current master key, got %q %v", decrypted, err)
+	}
+}
@@ -3,14 +3,18 @@ package types
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/xxtea/xxtea-go/xxtea"
 )
 
 type ObjMap struct {
 	Rowkey     []byte // Rowkey cache
 	Name       string
 	BucketName string
-	NullVerNum uint64
-	NullVerId  string
+	NullVerNum uint64 // unix nano timestamp of the `null` version object
+	NullVerId  string // version id cache, derived from NullVerNum
 }
 
 func (om *ObjMap) GetRowKey() (string, error) {
@@ -45,3 +49,15 @@ func (om *ObjMap) GetValuesForDelete() (values map[string]map[string][]byte) {
 		OBJMAP_COLUMN_FAMILY: map[string][]byte{},
 	}
 }
+
+// GetVersionId returns the version id of the object `null` version points to,
+// encoded in the same way as Object.GetVersionId so it could be used to
+// fetch the object directly
+func (om *ObjMap) GetVersionId() string {
+	if om.NullVerId != "" {
+		return om.NullVerId
+	}
+	timeData := []byte(strconv.FormatUint(om.NullVerNum, 10))
+	om.NullVerId = hex.EncodeToString(xxtea.Encrypt(timeData, XXTEA_KEY))
+	return om.NullVerId
+}
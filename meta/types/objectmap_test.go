@@ -0,0 +1,32 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObjMapVersionIdMatchesObject(t *testing.T) {
+	lastModified := time.Now().UTC()
+	object := &Object{
+		Name:             "hehe",
+		BucketName:       "bucket",
+		LastModifiedTime: lastModified,
+	}
+	objMap := &ObjMap{
+		Name:       "hehe",
+		BucketName: "bucket",
+		NullVerNum: uint64(lastModified.UnixNano()),
+	}
+
+	if objMap.GetVersionId() != object.GetVersionId() {
+		t.Errorf("ObjMap version id %s doesn't match object version id %s",
+			objMap.GetVersionId(), object.GetVersionId())
+	}
+	number, err := object.GetVersionNumber()
+	if err != nil {
+		t.Fatalf("GetVersionNumber failed: %v", err)
+	}
+	if number != objMap.NullVerNum {
+		t.Errorf("Version number expected %d, got %d", objMap.NullVerNum, number)
+	}
+}
@@ -32,6 +32,13 @@ func (m *Meta) GetUserBuckets(userId string, willNeed bool) (buckets []string, e
 	return buckets, nil
 }
 
+// Not cached, unlike GetUserBuckets, since pages differ by marker
+func (m *Meta) ListUserBuckets(userId, marker string, maxBuckets int) (buckets []string,
+	truncated bool, err error) {
+
+	return m.Client.ListUserBuckets(userId, marker, maxBuckets)
+}
+
 func (m *Meta) AddBucketForUser(bucketName string, userId string) (err error) {
 	buckets, err := m.GetUserBuckets(userId, false)
 	if err != nil {
@@ -0,0 +1,65 @@
+package util
+
+import "strings"
+
+// Common prefix `name` is rolled up into when listing with `prefix` and
+// `delimiter`, "" if `name` should be listed as is
+func CommonPrefix(name, prefix, delimiter string) string {
+	if delimiter == "" || !strings.HasPrefix(name, prefix) {
+		return ""
+	}
+	n := strings.Index(name[len(prefix):], delimiter)
+	if n == -1 {
+		return ""
+	}
+	return name[:len(prefix)+n+len(delimiter)]
+}
+
+// Collects common prefixes of a listing while scanning, each distinct
+// prefix counts toward the limit like a key, so a listing over millions
+// of prefixes stops at the limit instead of holding them all in memory
+type ListCollector struct {
+	limit    int
+	count    int
+	prefixes map[string]struct{}
+	ordered  []string
+}
+
+func NewListCollector(limit int) *ListCollector {
+	return &ListCollector{
+		limit:    limit,
+		prefixes: make(map[string]struct{}),
+	}
+}
+
+// Whether `commonPrefix` is already collected, scanned entries under it
+// should be skipped
+func (c *ListCollector) Seen(commonPrefix string) bool {
+	_, ok := c.prefixes[commonPrefix]
+	return ok
+}
+
+// Take room for a key, false if the limit is reached and listing should
+// be truncated before it
+func (c *ListCollector) TakeKey() bool {
+	if c.count >= c.limit {
+		return false
+	}
+	c.count += 1
+	return true
+}
+
+// Take room for a new common prefix, false if the limit is reached
+func (c *ListCollector) TakePrefix(commonPrefix string) bool {
+	if !c.TakeKey() {
+		return false
+	}
+	c.prefixes[commonPrefix] = struct{}{}
+	c.ordered = append(c.ordered, commonPrefix)
+	return true
+}
+
+// Collected common prefixes, in order of collection
+func (c *ListCollector) Prefixes() []string {
+	return c.ordered
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestCommonPrefix(t *testing.T) {
+	cases := []struct {
+		name, prefix, delimiter, expected string
+	}{
+		{"a/b/c", "", "/", "a/"},
+		{"a/b/c", "a/", "/", "a/b/"},
+		{"a/b", "a/", "/", ""},
+		{"a/b/c", "", "", ""},
+		{"a--b--c", "a--", "--", "a--b--"},
+		{"b/c", "a/", "/", ""},
+	}
+	for _, c := range cases {
+		if p := CommonPrefix(c.name, c.prefix, c.delimiter); p != c.expected {
+			t.Errorf("%s %s %s: expected %q, got %q", c.name, c.prefix, c.delimiter,
+				c.expected, p)
+		}
+	}
+}
+
+// List sorted `names` from `marker`(inclusive) the way meta clients do
+func listPage(names []string, marker, delimiter string,
+	maxKeys int) (keys, prefixes []string, nextMarker string) {
+
+	collector := NewListCollector(maxKeys)
+	for _, name := range names[sort.SearchStrings(names, marker):] {
+		if commonPrefix := CommonPrefix(name, "", delimiter); commonPrefix != "" {
+			if collector.Seen(commonPrefix) {
+				continue
+			}
+			if !collector.TakePrefix(commonPrefix) {
+				return keys, collector.Prefixes(), commonPrefix
+			}
+			continue
+		}
+		if !collector.TakeKey() {
+			return keys, collector.Prefixes(), name
+		}
+		keys = append(keys, name)
+	}
+	return keys, collector.Prefixes(), ""
+}
+
+func TestListManyPrefixes(t *testing.T) {
+	var names []string
+	for i := 0; i < 1000; i++ {
+		for j := 0; j < 3; j++ {
+			names = append(names, fmt.Sprintf("dir%04d/file%d", i, j))
+		}
+		names = append(names, fmt.Sprintf("file%04d", i))
+	}
+	sort.Strings(names)
+
+	const maxKeys = 7
+	seen := make(map[string]bool)
+	marker := ""
+	pages := 0
+	for {
+		keys, prefixes, nextMarker := listPage(names, marker, "/", maxKeys)
+		pages += 1
+		if len(keys)+len(prefixes) > maxKeys {
+			t.Fatalf("page of %d keys and %d prefixes exceeds %d",
+				len(keys), len(prefixes), maxKeys)
+		}
+		if nextMarker != "" && len(keys)+len(prefixes) != maxKeys {
+			t.Fatalf("truncated page should be full, got %d", len(keys)+len(prefixes))
+		}
+		for _, entry := range append(keys, prefixes...) {
+			if seen[entry] {
+				t.Fatalf("%s listed twice", entry)
+			}
+			seen[entry] = true
+		}
+		if nextMarker == "" {
+			break
+		}
+		marker = nextMarker
+	}
+	if len(seen) != 2000 {
+		t.Errorf("expected 1000 prefixes and 1000 keys, got %d entries", len(seen))
+	}
+	if pages != (2000+maxKeys-1)/maxKeys {
+		t.Errorf("unexpected number of pages %d", pages)
+	}
+}
@@ -0,0 +1,299 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Where commands are sent to, i.e. a standalone server, master found by
+// Sentinel, or nodes of a Redis Cluster
+type backend interface {
+	// `key` is used to route the command in Redis Cluster, "" for any node
+	do(key string, cmd string, args ...interface{}) *redis.Resp
+	// send `cmds` in as few round trips as possible, replies are in the
+	// same order as `cmds`
+	pipeline(cmds []command) []*redis.Resp
+	// a dedicated connection for pub/sub
+	dialSubscriber() (*redis.Client, error)
+	// keys matching `match` on all masters, passed to `found` a page at a
+	// time
+	scan(match string, found func(keys []string) error) error
+	close()
+}
+
+// A command routed by `key` as in backend.do
+type command struct {
+	key  string
+	cmd  string
+	args []interface{}
+}
+
+// Used when Redis is not configured, commands succeed with nil replies,
+// i.e. every key is missing
+type disabledBackend struct{}
+
+func (disabledBackend) do(key string, cmd string, args ...interface{}) *redis.Resp {
+	return redis.NewResp(nil)
+}
+
+func (disabledBackend) pipeline(cmds []command) []*redis.Resp {
+	replies := make([]*redis.Resp, len(cmds))
+	for i := range cmds {
+		replies[i] = redis.NewResp(nil)
+	}
+	return replies
+}
+
+func (disabledBackend) dialSubscriber() (*redis.Client, error) {
+	return nil, errors.New("redis is disabled")
+}
+
+func (disabledBackend) scan(match string, found func(keys []string) error) error {
+	return nil
+}
+
+func (disabledBackend) close() {}
+
+func dial(network, addr string) (*redis.Client, error) {
+	client, err := redis.DialTimeout(network, addr, helper.CONFIG.RedisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if helper.CONFIG.RedisPassword != "" {
+		if err = client.Cmd("AUTH", helper.CONFIG.RedisPassword).Err; err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Connections are dialed at once, so pool creation fails if Redis is down,
+// pool.NewCustom would return a pool which never keeps idle connections
+// in that case
+func newPool(addr string) (*pool.Pool, error) {
+	p, err := pool.NewCustom("tcp", addr, helper.CONFIG.RedisConnectionNumber, dial)
+	if err != nil {
+		p.Empty()
+		return nil, err
+	}
+	return p, nil
+}
+
+func poolCmd(p *pool.Pool, cmd string, args ...interface{}) *redis.Resp {
+	client, err := p.Get()
+	if err != nil {
+		return redis.NewRespIOErr(err)
+	}
+	defer p.Put(client)
+	return client.Cmd(cmd, args...)
+}
+
+// Send `cmds` through one connection of `p` in a single round trip
+func poolPipeline(p *pool.Pool, cmds []command) []*redis.Resp {
+	replies := make([]*redis.Resp, len(cmds))
+	client, err := p.Get()
+	if err != nil {
+		for i := range replies {
+			replies[i] = redis.NewRespIOErr(err)
+		}
+		return replies
+	}
+	for _, c := range cmds {
+		client.PipeAppend(c.cmd, c.args...)
+	}
+	broken := false
+	for i := range replies {
+		replies[i] = client.PipeResp()
+		broken = broken || replies[i].IsType(redis.IOErr)
+	}
+	if broken {
+		// replies left unread would be taken as those of next commands
+		client.Close()
+	} else {
+		p.Put(client)
+	}
+	return replies
+}
+
+// Keys served by a node are iterated by SCAN, which doesn't block the node
+// for long as KEYS does. `cmd` sends a command to the node
+func scanNode(cmd func(args ...interface{}) *redis.Resp, match string,
+	found func(keys []string) error) error {
+
+	cursor := "0"
+	for {
+		reply, err := cmd(cursor, "MATCH", match, "COUNT", SCAN_COUNT).Array()
+		if err != nil {
+			return err
+		}
+		if len(reply) != 2 {
+			return errors.New("bad SCAN reply")
+		}
+		if cursor, err = reply[0].Str(); err != nil {
+			return err
+		}
+		keys, err := reply[1].List()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err = found(keys); err != nil {
+				return err
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+func splitAddresses(addresses string) (result []string) {
+	for _, addr := range strings.Split(addresses, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			result = append(result, addr)
+		}
+	}
+	return
+}
+
+// Standalone server, or master found by Sentinel. Master is asked from
+// sentinels again when it's unreachable or turns out to be a replica
+// after failover.
+type masterBackend struct {
+	resolve func() (string, error) // address of master
+	lock    sync.Mutex
+	pool    *pool.Pool // of current master, nil if not connected
+}
+
+func newStandaloneBackend(addr string) *masterBackend {
+	m := &masterBackend{
+		resolve: func() (string, error) { return addr, nil },
+	}
+	m.connect()
+	return m
+}
+
+func newSentinelBackend(masterName string, sentinels []string) *masterBackend {
+	m := &masterBackend{
+		resolve: func() (string, error) { return askMaster(masterName, sentinels) },
+	}
+	m.connect()
+	return m
+}
+
+func askMaster(masterName string, sentinels []string) (string, error) {
+	var lastErr error = errors.New("no sentinel configured")
+	for _, sentinel := range sentinels {
+		client, err := redis.DialTimeout("tcp", sentinel, helper.CONFIG.RedisTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addr, err := client.Cmd("SENTINEL", "get-master-addr-by-name", masterName).List()
+		client.Close()
+		if err != nil || len(addr) != 2 {
+			lastErr = errors.New("sentinel " + sentinel + " does not know master " + masterName)
+			continue
+		}
+		return net.JoinHostPort(addr[0], addr[1]), nil
+	}
+	return "", lastErr
+}
+
+func (m *masterBackend) connect() (*pool.Pool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.pool != nil {
+		return m.pool, nil
+	}
+	addr, err := m.resolve()
+	if err != nil {
+		helper.Logger.Println(5, "Failed to find Redis master:", err)
+		return nil, err
+	}
+	m.pool, err = newPool(addr)
+	if err != nil {
+		helper.Logger.Println(5, "Failed to connect to Redis server", addr, err)
+		return nil, err
+	}
+	helper.Logger.Println(5, "Connected to Redis server", addr)
+	return m.pool, nil
+}
+
+// Forget `p` so master is resolved and connected again for next command
+func (m *masterBackend) reset(p *pool.Pool) {
+	m.lock.Lock()
+	if m.pool == p {
+		m.pool = nil
+	}
+	m.lock.Unlock()
+	p.Empty()
+}
+
+func (m *masterBackend) do(key string, cmd string, args ...interface{}) *redis.Resp {
+	p, err := m.connect()
+	if err != nil {
+		return redis.NewRespIOErr(err)
+	}
+	resp := poolCmd(p, cmd, args...)
+	if resp.IsType(redis.IOErr) && !redis.IsTimeout(resp) ||
+		resp.IsType(redis.AppErr) && strings.HasPrefix(resp.Err.Error(), "READONLY") {
+		m.reset(p)
+	}
+	return resp
+}
+
+func (m *masterBackend) pipeline(cmds []command) []*redis.Resp {
+	p, err := m.connect()
+	if err != nil {
+		replies := make([]*redis.Resp, len(cmds))
+		for i := range replies {
+			replies[i] = redis.NewRespIOErr(err)
+		}
+		return replies
+	}
+	replies := poolPipeline(p, cmds)
+	for _, resp := range replies {
+		if resp.IsType(redis.IOErr) && !redis.IsTimeout(resp) ||
+			resp.IsType(redis.AppErr) && strings.HasPrefix(resp.Err.Error(), "READONLY") {
+			m.reset(p)
+			break
+		}
+	}
+	return replies
+}
+
+func (m *masterBackend) dialSubscriber() (*redis.Client, error) {
+	p, err := m.connect()
+	if err != nil {
+		return nil, err
+	}
+	client, err := dial("tcp", p.Addr)
+	if err != nil {
+		m.reset(p)
+	}
+	return client, err
+}
+
+func (m *masterBackend) scan(match string, found func(keys []string) error) error {
+	return scanNode(func(args ...interface{}) *redis.Resp {
+		return m.do("", "SCAN", args...)
+	}, match, found)
+}
+
+func (m *masterBackend) close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.pool != nil {
+		m.pool.Empty()
+		m.pool = nil
+	}
+}
@@ -0,0 +1,354 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+const CLUSTER_SLOT_COUNT = 16384
+
+// Commands are sent to master of the slot their key belongs to. The slot
+// map is loaded by "CLUSTER SLOTS", and reloaded when a node replies
+// MOVED or becomes unreachable.
+type clusterBackend struct {
+	seeds []string
+	lock  sync.RWMutex
+	slots [CLUSTER_SLOT_COUNT]string // slot -> address of master
+	pools map[string]*pool.Pool      // address -> connections
+}
+
+func newClusterBackend(seeds []string) *clusterBackend {
+	c := &clusterBackend{
+		seeds: seeds,
+		pools: make(map[string]*pool.Pool),
+	}
+	if err := c.refresh(); err != nil {
+		helper.Logger.Println(5, "Failed to load Redis Cluster slots:", err)
+	}
+	return c
+}
+
+// CRC16-CCITT (XModem) used by Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc = crc << 1
+			}
+		}
+	}
+	return crc
+}
+
+// Only part of key in "{}" is hashed if present, see
+// https://redis.io/topics/cluster-spec#keys-hash-tags
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % CLUSTER_SLOT_COUNT)
+}
+
+// Parse reply of "CLUSTER SLOTS", i.e. a list of
+// [start slot, end slot, [master ip, master port, ...], replicas...]
+func parseClusterSlots(resp *redis.Resp) (ranges map[string][][2]int, err error) {
+	entries, err := resp.Array()
+	if err != nil {
+		return nil, err
+	}
+	ranges = make(map[string][][2]int)
+	for _, entry := range entries {
+		fields, err := entry.Array()
+		if err != nil || len(fields) < 3 {
+			return nil, errors.New("malformed CLUSTER SLOTS reply")
+		}
+		start, err1 := fields[0].Int()
+		end, err2 := fields[1].Int()
+		master, err3 := fields[2].Array()
+		if err1 != nil || err2 != nil || err3 != nil || len(master) < 2 ||
+			start < 0 || end >= CLUSTER_SLOT_COUNT || start > end {
+			return nil, errors.New("malformed CLUSTER SLOTS reply")
+		}
+		ip, err1 := master[0].Str()
+		port, err2 := master[1].Int()
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("malformed CLUSTER SLOTS reply")
+		}
+		addr := net.JoinHostPort(ip, strconv.Itoa(port))
+		ranges[addr] = append(ranges[addr], [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+// Reload slot map from any reachable node
+func (c *clusterBackend) refresh() error {
+	c.lock.RLock()
+	candidates := make([]string, 0, len(c.pools)+len(c.seeds))
+	for addr := range c.pools {
+		candidates = append(candidates, addr)
+	}
+	c.lock.RUnlock()
+	candidates = append(candidates, c.seeds...)
+
+	var lastErr error = errors.New("no cluster node configured")
+	for _, addr := range candidates {
+		client, err := dial("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ranges, err := parseClusterSlots(client.Cmd("CLUSTER", "SLOTS"))
+		client.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.lock.Lock()
+		for i := range c.slots {
+			c.slots[i] = ""
+		}
+		for master, masterRanges := range ranges {
+			for _, r := range masterRanges {
+				for slot := r[0]; slot <= r[1]; slot++ {
+					c.slots[slot] = master
+				}
+			}
+		}
+		// drop connections to nodes no longer masters
+		for addr, p := range c.pools {
+			if _, ok := ranges[addr]; !ok {
+				p.Empty()
+				delete(c.pools, addr)
+			}
+		}
+		c.lock.Unlock()
+		return nil
+	}
+	return lastErr
+}
+
+func (c *clusterBackend) nodeOf(key string) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if key != "" {
+		return c.slots[keySlot(key)]
+	}
+	for addr := range c.pools {
+		return addr
+	}
+	for _, addr := range c.slots {
+		if addr != "" {
+			return addr
+		}
+	}
+	return ""
+}
+
+func (c *clusterBackend) poolOf(addr string) (*pool.Pool, error) {
+	c.lock.RLock()
+	p, ok := c.pools[addr]
+	c.lock.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if p, ok := c.pools[addr]; ok {
+		return p, nil
+	}
+	p, err := newPool(addr)
+	if err != nil {
+		return nil, err
+	}
+	c.pools[addr] = p
+	return p, nil
+}
+
+func (c *clusterBackend) removePool(addr string) {
+	c.lock.Lock()
+	p, ok := c.pools[addr]
+	delete(c.pools, addr)
+	c.lock.Unlock()
+	if ok {
+		p.Empty()
+	}
+}
+
+// Redirections of a command to follow before giving up
+const MAX_CLUSTER_REDIRECTIONS = 3
+
+func (c *clusterBackend) do(key string, cmd string, args ...interface{}) (resp *redis.Resp) {
+	addr := c.nodeOf(key)
+	asking := false
+	for i := 0; i <= MAX_CLUSTER_REDIRECTIONS; i++ {
+		if addr == "" {
+			if err := c.refresh(); err != nil {
+				return redis.NewRespIOErr(err)
+			}
+			if addr = c.nodeOf(key); addr == "" {
+				return redis.NewRespIOErr(errors.New("no Redis node serves key " + key))
+			}
+		}
+		p, err := c.poolOf(addr)
+		if err != nil {
+			// node is probably down and replaced by its replica
+			resp = redis.NewRespIOErr(err)
+			addr = ""
+			continue
+		}
+		client, err := p.Get()
+		if err != nil {
+			resp = redis.NewRespIOErr(err)
+			c.removePool(addr)
+			addr = ""
+			continue
+		}
+		if asking {
+			client.Cmd("ASKING")
+			asking = false
+		}
+		resp = client.Cmd(cmd, args...)
+		p.Put(client)
+
+		if resp.IsType(redis.IOErr) {
+			if !redis.IsTimeout(resp) {
+				c.removePool(addr)
+			}
+			return resp
+		}
+		if !resp.IsType(redis.AppErr) {
+			return resp
+		}
+		// "MOVED 3999 127.0.0.1:6381" or "ASK 3999 127.0.0.1:6381"
+		parts := strings.Fields(resp.Err.Error())
+		if len(parts) != 3 || (parts[0] != "MOVED" && parts[0] != "ASK") {
+			return resp
+		}
+		if parts[0] == "MOVED" {
+			if err := c.refresh(); err != nil {
+				helper.Logger.Println(5, "Failed to reload Redis Cluster slots:", err)
+			}
+		} else {
+			asking = true
+		}
+		addr = parts[2]
+	}
+	return resp
+}
+
+func isRedirection(resp *redis.Resp) bool {
+	if !resp.IsType(redis.AppErr) {
+		return false
+	}
+	message := resp.Err.Error()
+	return strings.HasPrefix(message, "MOVED ") || strings.HasPrefix(message, "ASK ")
+}
+
+// Commands are grouped by node and pipelined to each of them. Those that
+// fail, including ones redirected by MOVED or ASK, are sent again one by
+// one with redirections followed
+func (c *clusterBackend) pipeline(cmds []command) []*redis.Resp {
+	replies := make([]*redis.Resp, len(cmds))
+	groups := make(map[string][]int) // address -> indexes of cmds
+	for i, cmd := range cmds {
+		addr := c.nodeOf(cmd.key)
+		if addr == "" {
+			replies[i] = c.do(cmd.key, cmd.cmd, cmd.args...)
+			continue
+		}
+		groups[addr] = append(groups[addr], i)
+	}
+	for addr, indexes := range groups {
+		p, err := c.poolOf(addr)
+		if err != nil {
+			for _, i := range indexes {
+				replies[i] = c.do(cmds[i].key, cmds[i].cmd, cmds[i].args...)
+			}
+			continue
+		}
+		group := make([]command, len(indexes))
+		for j, i := range indexes {
+			group[j] = cmds[i]
+		}
+		for j, resp := range poolPipeline(p, group) {
+			i := indexes[j]
+			if resp.IsType(redis.IOErr) || isRedirection(resp) {
+				resp = c.do(cmds[i].key, cmds[i].cmd, cmds[i].args...)
+			}
+			replies[i] = resp
+		}
+	}
+	return replies
+}
+
+func (c *clusterBackend) dialSubscriber() (*redis.Client, error) {
+	// messages published to any node are propagated to the whole cluster
+	addr := c.nodeOf("")
+	if addr == "" {
+		if err := c.refresh(); err != nil {
+			return nil, err
+		}
+		addr = c.nodeOf("")
+	}
+	return dial("tcp", addr)
+}
+
+// Addresses of masters in the slot map
+func (c *clusterBackend) masters() map[string]bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	masters := make(map[string]bool)
+	for _, addr := range c.slots {
+		if addr != "" {
+			masters[addr] = true
+		}
+	}
+	return masters
+}
+
+// Replicas are skipped, they have the same keys as their masters
+func (c *clusterBackend) scan(match string, found func(keys []string) error) error {
+	masters := c.masters()
+	if len(masters) == 0 {
+		if err := c.refresh(); err != nil {
+			return err
+		}
+		masters = c.masters()
+	}
+	for addr := range masters {
+		p, err := c.poolOf(addr)
+		if err != nil {
+			return err
+		}
+		err = scanNode(func(args ...interface{}) *redis.Resp {
+			return poolCmd(p, "SCAN", args...)
+		}, match, found)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *clusterBackend) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for addr, p := range c.pools {
+		p.Empty()
+		delete(c.pools, addr)
+	}
+}
@@ -1,11 +1,15 @@
 package redis
 
 import (
+	"errors"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/mediocregopher/radix.v2/pool"
-	"github.com/mediocregopher/radix.v2/redis"
 	"github.com/journeymidnight/yig/helper"
+	"github.com/mediocregopher/radix.v2/pubsub"
+	"github.com/mediocregopher/radix.v2/redis"
 )
 
 const InvalidQueueName = "InvalidQueue"
@@ -38,81 +42,234 @@ func TableFromChannelName(name string) (r RedisDatabase, err error) {
 	return
 }
 
+var tableNames = [...]string{"user", "bucket", "object", "file", "cluster"}
+
+// Human readable name, e.g. "bucket" for BucketTable
+func (r RedisDatabase) Name() string {
+	if int(r) < 0 || int(r) >= len(tableNames) {
+		return r.String()
+	}
+	return tableNames[r]
+}
+
+func TableFromName(name string) (r RedisDatabase, err error) {
+	for i, tableName := range tableNames {
+		if tableName == name {
+			return RedisDatabase(i), nil
+		}
+	}
+	return r, errors.New("unknown table " + name)
+}
+
 var MetadataTables = []RedisDatabase{UserTable, BucketTable, ObjectTable, ClusterTable}
 var DataTables = []RedisDatabase{FileTable}
 
-var redisConnectionPool *pool.Pool
+// Returned instead of sending commands when Redis is considered down
+var ErrUnavailable = errors.New("redis is unavailable")
+
+// No-op until Initialize is called, so YIG could run without Redis
+var conn backend = disabledBackend{}
+var breaker = helper.NewCircuitBreaker(1, time.Second)
+
+// Counters of commands since Initialize, accessed atomically
+var failedCommands, bypassedCommands int64
 
 func Initialize() {
-	var err error
-	df := func(network, addr string) (*redis.Client, error) {
-		client, err := redis.Dial(network, addr)
-		if err != nil {
-			return nil, err
-		}
-		if helper.CONFIG.RedisPassword != "" {
-			if err = client.Cmd("AUTH", helper.CONFIG.RedisPassword).Err; err != nil {
-				client.Close()
-				return nil, err
+	switch {
+	case helper.CONFIG.RedisClusterAddresses != "":
+		conn = newClusterBackend(splitAddresses(helper.CONFIG.RedisClusterAddresses))
+	case helper.CONFIG.RedisSentinelMasterName != "":
+		conn = newSentinelBackend(helper.CONFIG.RedisSentinelMasterName,
+			splitAddresses(helper.CONFIG.RedisSentinelAddresses))
+	default:
+		conn = newStandaloneBackend(helper.CONFIG.RedisAddress)
+	}
+	breaker = helper.NewCircuitBreaker(helper.CONFIG.RedisFailureThreshold,
+		helper.CONFIG.RedisRetryInterval)
+	atomic.StoreInt64(&failedCommands, 0)
+	atomic.StoreInt64(&bypassedCommands, 0)
+}
+
+func Close() {
+	conn.close()
+}
+
+// Whether Redis is enabled and reachable recently, callers could skip
+// optional operations if not
+func Available() bool {
+	if _, disabled := conn.(disabledBackend); disabled {
+		return false
+	}
+	return breaker.Closed()
+}
+
+type RedisStats struct {
+	Enabled   bool
+	Available bool
+	Failed    int64 // commands failed as Redis is down or failing over
+	Bypassed  int64 // commands not sent as Redis is considered down
+}
+
+// Availability of Redis seen by this instance, cache falls back to
+// metadata store while it's unavailable
+func Stats() RedisStats {
+	_, disabled := conn.(disabledBackend)
+	return RedisStats{
+		Enabled:   !disabled,
+		Available: Available(),
+		Failed:    atomic.LoadInt64(&failedCommands),
+		Bypassed:  atomic.LoadInt64(&bypassedCommands),
+	}
+}
+
+// Errors meaning Redis is down or failing over, other errors like
+// WRONGTYPE don't count
+func isFailure(resp *redis.Resp) bool {
+	if resp.IsType(redis.IOErr) {
+		return true
+	}
+	if resp.IsType(redis.AppErr) {
+		message := resp.Err.Error()
+		for _, prefix := range []string{"LOADING", "MASTERDOWN", "CLUSTERDOWN", "READONLY", "TRYAGAIN"} {
+			if strings.HasPrefix(message, prefix) {
+				return true
 			}
 		}
-		return client, nil
 	}
-	redisConnectionPool, err = pool.NewCustom("tcp", helper.CONFIG.RedisAddress, helper.CONFIG.RedisConnectionNumber, df)
-	if err != nil {
-		panic("Failed to connect to Redis server: " + err.Error())
+	return false
+}
+
+func do(key string, cmd string, args ...interface{}) *redis.Resp {
+	if !breaker.Allow() {
+		atomic.AddInt64(&bypassedCommands, 1)
+		return redis.NewRespIOErr(ErrUnavailable)
+	}
+	resp := conn.do(key, cmd, args...)
+	failed := isFailure(resp)
+	if failed {
+		atomic.AddInt64(&failedCommands, 1)
 	}
+	breaker.Record(failed)
+	return resp
 }
 
-func Close() {
-	redisConnectionPool.Empty()
+// Same as do, but for many commands at once. The circuit breaker records
+// the pipeline as a single command
+func pipeline(cmds []command) []*redis.Resp {
+	if !breaker.Allow() {
+		atomic.AddInt64(&bypassedCommands, int64(len(cmds)))
+		replies := make([]*redis.Resp, len(cmds))
+		for i := range replies {
+			replies[i] = redis.NewRespIOErr(ErrUnavailable)
+		}
+		return replies
+	}
+	replies := conn.pipeline(cmds)
+	failed := false
+	for _, resp := range replies {
+		if isFailure(resp) {
+			atomic.AddInt64(&failedCommands, 1)
+			failed = true
+		}
+	}
+	breaker.Record(failed)
+	return replies
 }
 
-func GetClient() (*redis.Client, error) {
-	return redisConnectionPool.Get()
+// First error of `replies`
+func firstError(replies []*redis.Resp) error {
+	for _, resp := range replies {
+		if resp.Err != nil {
+			return resp.Err
+		}
+	}
+	return nil
 }
 
-func PutClient(c *redis.Client) {
-	redisConnectionPool.Put(c)
+func Ping() (err error) {
+	return do("", "ping").Err
 }
 
 func Remove(table RedisDatabase, key string) (err error) {
-	c, err := GetClient()
-	if err != nil {
-		return err
+	// Use table.String() + key as Redis key
+	return do(table.String()+key, "del", table.String()+key).Err
+}
+
+// Keys are deleted one by one since they may belong to different slots
+// of Redis Cluster
+func RemoveKeys(table RedisDatabase, keys []string) (err error) {
+	for _, key := range keys {
+		if err = Remove(table, key); err != nil {
+			return err
+		}
 	}
-	defer PutClient(c)
+	return nil
+}
 
-	// Use table.String() + key as Redis key
-	return c.Cmd("del", table.String()+key).Err
+// A cache entry to remove or invalidate
+type InvalidEntry struct {
+	Table RedisDatabase
+	Key   string
+}
+
+// Same as Remove for each of `entries`, pipelined
+func RemoveBatch(entries []InvalidEntry) error {
+	cmds := make([]command, len(entries))
+	for i, e := range entries {
+		key := e.Table.String() + e.Key
+		cmds[i] = command{key: key, cmd: "del", args: []interface{}{key}}
+	}
+	return firstError(pipeline(cmds))
+}
+
+// Keys asked for by each SCAN
+const SCAN_COUNT = 1000
+
+// Remove all keys of `table`, e.g. those cached of a metadata table. The
+// circuit breaker records the removal as a single command
+func RemoveTable(table RedisDatabase) error {
+	if !breaker.Allow() {
+		atomic.AddInt64(&bypassedCommands, 1)
+		return ErrUnavailable
+	}
+	// keys of all tables are prefixed by a single digit
+	err := conn.scan(table.String()+"*", func(keys []string) error {
+		cmds := make([]command, len(keys))
+		for i, key := range keys {
+			cmds[i] = command{key: key, cmd: "del", args: []interface{}{key}}
+		}
+		return firstError(conn.pipeline(cmds))
+	})
+	if err != nil {
+		atomic.AddInt64(&failedCommands, 1)
+	}
+	breaker.Record(err != nil)
+	return err
 }
 
 func Set(table RedisDatabase, key string, value interface{}) (err error) {
-	c, err := GetClient()
+	encodedValue, err := helper.MsgPackMarshal(value)
 	if err != nil {
 		return err
 	}
-	defer PutClient(c)
+	// Use table.String() + key as Redis key
+	return do(table.String()+key, "set", table.String()+key, string(encodedValue)).Err
+}
 
+// Set with expiration, `ttl` is in seconds
+func SetWithExpire(table RedisDatabase, key string, value interface{}, ttl int) (err error) {
 	encodedValue, err := helper.MsgPackMarshal(value)
 	if err != nil {
 		return err
 	}
-	// Use table.String() + key as Redis key
-	return c.Cmd("set", table.String()+key, string(encodedValue)).Err
+	return do(table.String()+key, "set", table.String()+key, string(encodedValue), "EX", ttl).Err
 }
 
 func Get(table RedisDatabase, key string,
 	unmarshal func([]byte) (interface{}, error)) (value interface{}, err error) {
 
-	c, err := GetClient()
-	if err != nil {
-		return
-	}
-	defer PutClient(c)
-
 	// Use table.String() + key as Redis key
-	encodedValue, err := c.Cmd("get", table.String()+key).Bytes()
+	encodedValue, err := do(table.String()+key, "get", table.String()+key).Bytes()
 	if err != nil {
 		return
 	}
@@ -123,35 +280,99 @@ func Get(table RedisDatabase, key string,
 // `start` and `end` are inclusive
 // FIXME: this API causes an extra memory copy, need to patch radix to fix it
 func GetBytes(key string, start int64, end int64) ([]byte, error) {
-	c, err := GetClient()
-	if err != nil {
-		return nil, err
-	}
-	defer PutClient(c)
-
 	// Note Redis returns "" for nonexist key for GETRANGE
-	return c.Cmd("getrange", FileTable.String()+key, start, end).Bytes()
+	return do(FileTable.String()+key, "getrange", FileTable.String()+key, start, end).Bytes()
 }
 
 // Set file bytes
 func SetBytes(key string, value []byte) (err error) {
-	c, err := GetClient()
-	if err != nil {
-		return err
-	}
-	defer PutClient(c)
-
 	// Use table.String() + key as Redis key
-	return c.Cmd("set", FileTable.String()+key, value).Err
+	return do(FileTable.String()+key, "set", FileTable.String()+key, value).Err
 }
 
 // Publish the invalid message to other YIG instances through Redis
 func Invalid(table RedisDatabase, key string) (err error) {
-	c, err := GetClient()
+	return do("", "publish", table.InvalidQueue(), key).Err
+}
+
+// Same as Invalid for each of `entries`, published in a single round trip
+func InvalidBatch(entries []InvalidEntry) error {
+	cmds := make([]command, len(entries))
+	for i, e := range entries {
+		cmds[i] = command{cmd: "publish", args: []interface{}{e.Table.InvalidQueue(), e.Key}}
+	}
+	return firstError(pipeline(cmds))
+}
+
+// Pattern subscription which survives Redis restarts and failovers
+type Subscription struct {
+	pattern     string
+	onReconnect func()
+	client      *pubsub.SubClient
+	connected   bool // ever connected
+}
+
+// Subscribe to channels matching `pattern`, `onReconnect` is called after
+// subscribing again, as messages published meanwhile are lost
+func Subscribe(pattern string, onReconnect func()) *Subscription {
+	return &Subscription{
+		pattern:     pattern,
+		onReconnect: onReconnect,
+	}
+}
+
+func (s *Subscription) subscribe() error {
+	c, err := conn.dialSubscriber()
 	if err != nil {
 		return err
 	}
-	defer PutClient(c)
+	client := pubsub.NewSubClient(c)
+	if resp := client.PSubscribe(s.pattern); resp.Err != nil {
+		c.Close()
+		return resp.Err
+	}
+	s.client = client
+	return nil
+}
+
+func (s *Subscription) disconnect() {
+	s.client.Client.Close()
+	s.client = nil
+}
 
-	return c.Cmd("publish", table.InvalidQueue(), key).Err
+// Receive blocks until a message arrives or reading times out, reconnects
+// with backoff if connection is lost
+func (s *Subscription) Receive() *pubsub.SubResp {
+	backoff := 100 * time.Millisecond
+	for s.client == nil {
+		err := s.subscribe()
+		if err == nil {
+			if s.connected && s.onReconnect != nil {
+				s.onReconnect()
+			}
+			s.connected = true
+			break
+		}
+		helper.Logger.Println(5, "Failed to subscribe to Redis channel", s.pattern, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > helper.MAX_RETRY_INTERVAL {
+			backoff = helper.MAX_RETRY_INTERVAL
+		}
+	}
+
+	response := s.client.Receive()
+	if response.Err == nil {
+		return response
+	}
+	if response.Timeout() {
+		// connection is idle, make sure it's still alive
+		if ping := s.client.Ping(); ping.Err != nil {
+			s.disconnect()
+			return ping
+		}
+		return response
+	}
+	s.disconnect()
+	return response
 }
@@ -0,0 +1,450 @@
+package redis
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+func init() {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	helper.CONFIG.RedisTimeout = time.Second
+	helper.CONFIG.RedisConnectionNumber = 2
+}
+
+// Speaks enough RESP to play a Redis server, sentinel or cluster node,
+// commands are answered by `handle`
+type fakeServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+	handle   func(args []string) interface{}
+}
+
+func newFakeServer(t *testing.T, handle func(args []string) interface{}) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{listener: listener, handle: handle}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.conns = append(s.conns, conn)
+			s.lock.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	reader := redis.NewRespReader(conn)
+	for {
+		args, err := reader.Read().List()
+		if err != nil {
+			conn.Close()
+			return
+		}
+		redis.NewResp(s.handle(args)).WriteTo(conn)
+	}
+}
+
+func (s *fakeServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+// Stop accepting and drop all connections, like a crashed server
+func (s *fakeServer) kill() {
+	s.listener.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Handles GET and SET in memory, `name` is returned for unknown keys
+func kvHandler(name string) func(args []string) interface{} {
+	var lock sync.Mutex
+	values := make(map[string]string)
+	return func(args []string) interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			values[args[1]] = args[2]
+			return redis.NewRespSimple("OK")
+		case "GET":
+			if v, ok := values[args[1]]; ok {
+				return v
+			}
+			return name
+		}
+		return redis.NewRespSimple("PONG")
+	}
+}
+
+func TestSentinelFailover(t *testing.T) {
+	first := newFakeServer(t, kvHandler("first"))
+	second := newFakeServer(t, kvHandler("second"))
+	defer second.kill()
+	var lock sync.Mutex
+	master := first.addr()
+	sentinel := newFakeServer(t, func(args []string) interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(args) != 3 || args[2] != "mymaster" {
+			return errors.New("ERR unknown master")
+		}
+		host, port, _ := net.SplitHostPort(master)
+		return []string{host, port}
+	})
+	defer sentinel.kill()
+
+	b := newSentinelBackend("mymaster", []string{"127.0.0.1:1", sentinel.addr()})
+	defer b.close()
+	if v, err := b.do("k", "GET", "k").Str(); err != nil || v != "first" {
+		t.Fatalf("should be served by first master, got %s %v", v, err)
+	}
+
+	first.kill()
+	lock.Lock()
+	master = second.addr()
+	lock.Unlock()
+	// in-flight command fails, following ones go to the new master
+	b.do("k", "GET", "k")
+	if v, err := b.do("k", "GET", "k").Str(); err != nil || v != "second" {
+		t.Fatalf("should fail over to second master, got %s %v", v, err)
+	}
+}
+
+func TestSentinelReadonlyMaster(t *testing.T) {
+	replica := newFakeServer(t, func(args []string) interface{} {
+		return errors.New("READONLY You can't write against a read only replica.")
+	})
+	defer replica.kill()
+	newMaster := newFakeServer(t, kvHandler("new"))
+	defer newMaster.kill()
+	masters := []string{replica.addr(), newMaster.addr()}
+	var lock sync.Mutex
+	sentinel := newFakeServer(t, func(args []string) interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		host, port, _ := net.SplitHostPort(masters[0])
+		if len(masters) > 1 {
+			masters = masters[1:]
+		}
+		return []string{host, port}
+	})
+	defer sentinel.kill()
+
+	b := newSentinelBackend("mymaster", []string{sentinel.addr()})
+	defer b.close()
+	if resp := b.do("k", "SET", "k", "v"); !isFailure(resp) {
+		t.Fatalf("READONLY should be a failure, got %v", resp)
+	}
+	if v, err := b.do("k", "GET", "k").Str(); err != nil || v != "new" {
+		t.Fatalf("master should be asked again, got %s %v", v, err)
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	// examples from Redis Cluster specification
+	if crc16("123456789") != 0x31C3 {
+		t.Errorf("bad crc16 %x", crc16("123456789"))
+	}
+	if keySlot("{user1000}.following") != keySlot("{user1000}.followers") {
+		t.Error("keys with same hash tag should be in same slot")
+	}
+	if keySlot("foo{}{bar}") != int(crc16("foo{}{bar}")%CLUSTER_SLOT_COUNT) {
+		t.Error("empty hash tag should be ignored")
+	}
+}
+
+func TestClusterRedirection(t *testing.T) {
+	owner := newFakeServer(t, kvHandler("owner"))
+	defer owner.kill()
+	var slotsRequests int
+	var staleAddr string
+	var lock sync.Mutex
+	stale := newFakeServer(t, func(args []string) interface{} {
+		if strings.ToUpper(args[0]) == "CLUSTER" {
+			lock.Lock()
+			defer lock.Unlock()
+			slotsRequests += 1
+			// all slots are served by this node at first, then moved
+			addr := staleAddr
+			if slotsRequests > 1 {
+				addr = owner.addr()
+			}
+			host, portString, _ := net.SplitHostPort(addr)
+			port, _ := strconv.Atoi(portString)
+			return []interface{}{
+				[]interface{}{0, CLUSTER_SLOT_COUNT - 1, []interface{}{host, port}},
+			}
+		}
+		return errors.New("MOVED 1234 " + owner.addr())
+	})
+	defer stale.kill()
+	lock.Lock()
+	staleAddr = stale.addr()
+	lock.Unlock()
+
+	c := newClusterBackend([]string{stale.addr()})
+	defer c.close()
+	if c.nodeOf("k") != stale.addr() {
+		t.Fatalf("slots should be loaded, got %s", c.nodeOf("k"))
+	}
+	if v, err := c.do("k", "GET", "k").Str(); err != nil || v != "owner" {
+		t.Fatalf("MOVED should be followed, got %s %v", v, err)
+	}
+	if c.nodeOf("k") != owner.addr() {
+		t.Errorf("slots should be reloaded after MOVED, got %s", c.nodeOf("k"))
+	}
+}
+
+func TestBypassWhenUnavailable(t *testing.T) {
+	server := newFakeServer(t, kvHandler("v"))
+	addr := server.addr()
+	server.kill()
+	helper.CONFIG.RedisAddress = addr
+	helper.CONFIG.RedisFailureThreshold = 2
+	helper.CONFIG.RedisRetryInterval = time.Hour
+	Initialize()
+	defer Close()
+
+	for i := 0; i < 2; i++ {
+		if err := Ping(); err == nil || err == ErrUnavailable {
+			t.Fatalf("should try Redis before threshold reached, got %v", err)
+		}
+	}
+	if Available() {
+		t.Error("Redis should be unavailable")
+	}
+	start := time.Now()
+	if err := Ping(); err != ErrUnavailable {
+		t.Errorf("should bypass Redis, got %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("bypassing should not wait for Redis")
+	}
+	stats := Stats()
+	if !stats.Enabled || stats.Available || stats.Failed != 2 || stats.Bypassed != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestInvalidBatch(t *testing.T) {
+	var lock sync.Mutex
+	var received [][]string
+	server := newFakeServer(t, func(args []string) interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, args)
+		return 1
+	})
+	defer server.kill()
+	helper.CONFIG.RedisAddress = server.addr()
+	helper.CONFIG.RedisFailureThreshold = 2
+	helper.CONFIG.RedisRetryInterval = time.Second
+	Initialize()
+	defer Close()
+
+	entries := []InvalidEntry{{ObjectTable, "b:o1:"}, {ObjectTable, "b:o2:"}, {BucketTable, "b"}}
+	if err := RemoveBatch(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := InvalidBatch(entries); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"del", ObjectTable.String() + "b:o1:"},
+		{"del", ObjectTable.String() + "b:o2:"},
+		{"del", BucketTable.String() + "b"},
+		{"publish", ObjectTable.InvalidQueue(), "b:o1:"},
+		{"publish", ObjectTable.InvalidQueue(), "b:o2:"},
+		{"publish", BucketTable.InvalidQueue(), "b"},
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, received)
+	}
+	for i := range expected {
+		if strings.Join(received[i], " ") != strings.Join(expected[i], " ") {
+			t.Errorf("command %d: expected %v, got %v", i, expected[i], received[i])
+		}
+	}
+}
+
+func TestClusterPipelineRedirection(t *testing.T) {
+	owner := newFakeServer(t, kvHandler("owner"))
+	defer owner.kill()
+	var lock sync.Mutex
+	var staleAddr string
+	stale := newFakeServer(t, func(args []string) interface{} {
+		if strings.ToUpper(args[0]) == "CLUSTER" {
+			lock.Lock()
+			defer lock.Unlock()
+			host, portString, _ := net.SplitHostPort(staleAddr)
+			port, _ := strconv.Atoi(portString)
+			return []interface{}{
+				[]interface{}{0, CLUSTER_SLOT_COUNT - 1, []interface{}{host, port}},
+			}
+		}
+		if args[1] == "k2" {
+			return "stale"
+		}
+		return errors.New("ASK 1234 " + owner.addr())
+	})
+	defer stale.kill()
+	lock.Lock()
+	staleAddr = stale.addr()
+	lock.Unlock()
+
+	c := newClusterBackend([]string{stale.addr()})
+	defer c.close()
+	replies := c.pipeline([]command{
+		{key: "k1", cmd: "GET", args: []interface{}{"k1"}},
+		{key: "k2", cmd: "GET", args: []interface{}{"k2"}},
+		{key: "k3", cmd: "GET", args: []interface{}{"k3"}},
+	})
+	for i, expected := range []string{"owner", "stale", "owner"} {
+		if v, err := replies[i].Str(); err != nil || v != expected {
+			t.Errorf("reply %d: expected %s, got %s %v", i, expected, v, err)
+		}
+	}
+}
+
+// Keeps `keys` in memory, SCAN returns at most one of them at a time.
+// Cursor is the index in `keys`, so keys removed meanwhile don't make SCAN
+// skip others
+func scanHandler(keys ...string) func(args []string) interface{} {
+	var lock sync.Mutex
+	values := make(map[string]bool)
+	for _, key := range keys {
+		values[key] = true
+	}
+	return func(args []string) interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		switch strings.ToUpper(args[0]) {
+		case "SCAN":
+			cursor, _ := strconv.Atoi(args[1])
+			matched := []string{}
+			for cursor < len(keys) {
+				key := keys[cursor]
+				cursor += 1
+				if values[key] && strings.HasPrefix(key, strings.TrimSuffix(args[3], "*")) {
+					matched = append(matched, key)
+					break
+				}
+			}
+			if cursor >= len(keys) {
+				cursor = 0
+			}
+			return []interface{}{strconv.Itoa(cursor), matched}
+		case "DEL":
+			delete(values, args[1])
+			return 1
+		case "EXISTS":
+			if values[args[1]] {
+				return 1
+			}
+			return 0
+		}
+		return redis.NewRespSimple("PONG")
+	}
+}
+
+func exists(t *testing.T, b backend, key string) bool {
+	n, err := b.do(key, "EXISTS", key).Int()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestRemoveTable(t *testing.T) {
+	bucketKeys := []string{BucketTable.String() + "a", BucketTable.String() + "b"}
+	objectKey := ObjectTable.String() + "a:o:"
+	server := newFakeServer(t, scanHandler(bucketKeys[0], bucketKeys[1], objectKey))
+	defer server.kill()
+	helper.CONFIG.RedisAddress = server.addr()
+	helper.CONFIG.RedisFailureThreshold = 2
+	helper.CONFIG.RedisRetryInterval = time.Second
+	Initialize()
+	defer Close()
+
+	if err := RemoveTable(BucketTable); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range bucketKeys {
+		if exists(t, conn, key) {
+			t.Errorf("%s should be removed", key)
+		}
+	}
+	if !exists(t, conn, objectKey) {
+		t.Error("keys of other tables should be kept")
+	}
+}
+
+func TestClusterRemoveTable(t *testing.T) {
+	// each node is a master of half of the slots
+	keys := []string{BucketTable.String() + "a", BucketTable.String() + "b"}
+	nodes := []*fakeServer{newFakeServer(t, scanHandler(keys[0])), nil}
+	defer nodes[0].kill()
+	var lock sync.Mutex
+	slots := func(args []string) interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		var result []interface{}
+		for i, node := range nodes {
+			host, portString, _ := net.SplitHostPort(node.addr())
+			port, _ := strconv.Atoi(portString)
+			result = append(result, []interface{}{i * CLUSTER_SLOT_COUNT / 2,
+				(i+1)*CLUSTER_SLOT_COUNT/2 - 1, []interface{}{host, port}})
+		}
+		return result
+	}
+	handler := scanHandler(keys[1])
+	lock.Lock()
+	nodes[1] = newFakeServer(t, func(args []string) interface{} {
+		if strings.ToUpper(args[0]) == "CLUSTER" {
+			return slots(args)
+		}
+		return handler(args)
+	})
+	lock.Unlock()
+	defer nodes[1].kill()
+
+	c := newClusterBackend([]string{nodes[1].addr()})
+	defer c.close()
+	var found []string
+	err := c.scan(BucketTable.String()+"*", func(keys []string) error {
+		found = append(found, keys...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(found)
+	if strings.Join(found, " ") != strings.Join(keys, " ") {
+		t.Errorf("keys of all masters should be found, expected %v, got %v", keys, found)
+	}
+}
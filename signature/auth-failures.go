@@ -0,0 +1,231 @@
+package signature
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+)
+
+// Signatures of unknown access keys are computed with this secret and then
+// rejected, so they fail the same way and take the same time as wrong
+// signatures of existing keys
+var unknownKeySecret = randomSecret()
+
+func randomSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic("Failed to generate random secret: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Credential to check signatures of accessKey with, `known` is false for
+// access keys unknown to IAM. Other errors of IAM are returned as is
+func lookupCredential(accessKey string) (credential iam.Credential, known bool, err error) {
+	credential, err = iam.GetCredential(accessKey)
+	if err == iam.ErrAccessKeyNotExist {
+		return iam.Credential{AccessKeyID: accessKey, SecretAccessKey: unknownKeySecret}, false, nil
+	}
+	if err != nil {
+		helper.Logger.Println(5, "Failed to get credential of", accessKey, "with error", err)
+		return credential, false, ErrInternalError
+	}
+	return credential, true, nil
+}
+
+// Error of a signature of accessKey that doesn't match. Cached credentials of
+// known keys are queried again, in case their secrets were changed in IAM,
+// at most once within iam.CACHE_MIN_INVALIDATE_AGE for each key
+func signatureMismatch(accessKey string, known bool, stringToSign, provided string) error {
+	if known {
+		iam.InvalidateCredential(accessKey)
+	}
+	return SignatureMismatch{StringToSign: stringToSign, SignatureProvided: provided}
+}
+
+// Repeated signature failures of an access key from the same client IP are
+// delayed, longer for each failure, and once there are too many of them
+// within a window, requests are denied until the window passes, whether
+// signed correctly or not. Only the most recently failed keys are tracked.
+
+type AuthFailureStats struct {
+	Failures int64 // signature failures
+	Delayed  int64 // failures responded with delay
+	Denied   int64 // requests denied for too many failures
+	Tracked  int   // access key and client IP pairs with recent failures
+}
+
+type failureEntry struct {
+	key      string
+	failures int
+	first    time.Time // when the current window started
+}
+
+type failureTracker struct {
+	lock      sync.Mutex
+	threshold int // failures within window before requests are denied
+	window    time.Duration
+	delay     time.Duration // for the first failure, doubled for each one afterwards
+	maxDelay  time.Duration
+	capacity  int // max number of entries kept, least recently failed ones are dropped
+	entries   map[string]*list.Element
+	lru       *list.List
+	now       func() time.Time
+	sleep     func(time.Duration)
+
+	failures int64
+	delayed  int64
+	denied   int64
+}
+
+// nil if tracking is disabled
+var authFailures *failureTracker
+
+func newFailureTracker(threshold int, window, delay, maxDelay time.Duration,
+	capacity int) *failureTracker {
+
+	return &failureTracker{
+		threshold: threshold,
+		window:    window,
+		delay:     delay,
+		maxDelay:  maxDelay,
+		capacity:  capacity,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+		now:       time.Now,
+		sleep:     time.Sleep,
+	}
+}
+
+// SetupAuthFailures enables tracking of signature failures based on
+// CONFIG.AuthFailureThreshold
+func SetupAuthFailures() {
+	if helper.CONFIG.AuthFailureThreshold <= 0 {
+		authFailures = nil
+		return
+	}
+	authFailures = newFailureTracker(helper.CONFIG.AuthFailureThreshold,
+		helper.CONFIG.AuthFailureWindow, helper.CONFIG.AuthFailureDelay,
+		helper.CONFIG.AuthFailureMaxDelay, helper.CONFIG.AuthFailureCacheSize)
+}
+
+func GetAuthFailureStats() AuthFailureStats {
+	t := authFailures
+	if t == nil {
+		return AuthFailureStats{}
+	}
+	t.lock.Lock()
+	tracked := t.lru.Len()
+	t.lock.Unlock()
+	return AuthFailureStats{
+		Failures: atomic.LoadInt64(&t.failures),
+		Delayed:  atomic.LoadInt64(&t.delayed),
+		Denied:   atomic.LoadInt64(&t.denied),
+		Tracked:  tracked,
+	}
+}
+
+// Should be called with lock held, returns nil if key has no failures
+// within window
+func (t *failureTracker) getEntry(key string, now time.Time) *failureEntry {
+	element, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*failureEntry)
+	if now.Sub(entry.first) >= t.window {
+		t.lru.Remove(element)
+		delete(t.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// Whether requests of key should be denied without checking signatures
+func (t *failureTracker) blocked(key string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entry := t.getEntry(key, t.now())
+	if entry != nil && entry.failures >= t.threshold {
+		atomic.AddInt64(&t.denied, 1)
+		return true
+	}
+	return false
+}
+
+// Record a failure of key, returns how long to delay its response
+func (t *failureTracker) fail(key string) time.Duration {
+	atomic.AddInt64(&t.failures, 1)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := t.now()
+	entry := t.getEntry(key, now)
+	if entry == nil {
+		entry = &failureEntry{key: key, first: now}
+		t.entries[key] = t.lru.PushFront(entry)
+		for t.lru.Len() > t.capacity {
+			oldest := t.lru.Back()
+			t.lru.Remove(oldest)
+			delete(t.entries, oldest.Value.(*failureEntry).key)
+		}
+	} else {
+		t.lru.MoveToFront(t.entries[key])
+	}
+	entry.failures += 1
+
+	delay := t.delay
+	for i := 1; i < entry.failures && delay < t.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+	if delay > 0 {
+		atomic.AddInt64(&t.delayed, 1)
+	}
+	return delay
+}
+
+// Run verify for accessKey claimed by r, with failures tracked by client IP
+// and access key. Requests without access key are not tracked. Verified
+// access keys are then checked by helper.CheckVerifiedKey
+func guardSignature(r *http.Request, accessKey string,
+	verify func() (iam.Credential, error)) (iam.Credential, error) {
+
+	credential, err := trackFailures(r, accessKey, verify)
+	if err != nil {
+		return credential, err
+	}
+	err = helper.CheckVerifiedKey(r.Context(), credential.AccessKeyID)
+	return credential, err
+}
+
+func trackFailures(r *http.Request, accessKey string,
+	verify func() (iam.Credential, error)) (iam.Credential, error) {
+
+	t := authFailures
+	if t == nil || accessKey == "" {
+		return verify()
+	}
+	key := accessKey + "|" + helper.ClientIP(r)
+	if t.blocked(key) {
+		return iam.Credential{}, ErrAccessDenied
+	}
+	// failures are not forgotten on success, as some signatures are only
+	// checked after the body is read
+	credential, err := verify()
+	if IsSignatureMismatch(err) {
+		if delay := t.fail(key); delay > 0 {
+			t.sleep(delay)
+		}
+	}
+	return credential, err
+}
@@ -0,0 +1,136 @@
+package signature
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+)
+
+// IAM that only knows access key "hehe" with secret "hehehehe"
+func setupLocalIam(t *testing.T) (restore func()) {
+	config := helper.CONFIG
+	helper.CONFIG.IamBackend = iam.BACKEND_LOCAL
+	helper.CONFIG.IamCredentials = []helper.IamCredential{
+		{AccessKey: "hehe", SecretKey: "hehehehe", UserId: "hehehehe"}}
+	if err := iam.SetupBackend(); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		helper.CONFIG = config
+		helper.CONFIG.IamBackend = iam.BACKEND_REMOTE
+		iam.SetupBackend()
+	}
+}
+
+func signedV2Request(t *testing.T, accessKey, signature string) *http.Request {
+	r, err := http.NewRequest("GET", "http://s3.test.com/bucket/hehe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RemoteAddr = "10.0.0.1:12345"
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if signature == "" {
+		signature = sign("GET\n\n\n" + r.Header.Get("Date") + "\n/bucket/hehe")
+	}
+	r.Header.Set("Authorization", "AWS "+accessKey+":"+signature)
+	return r
+}
+
+func TestUnknownAccessKey(t *testing.T) {
+	defer setupLocalIam(t)()
+
+	if _, err := IsReqAuthenticated(signedV2Request(t, "hehe", "")); err != nil {
+		t.Fatalf("signature of known key should match, got %v", err)
+	}
+	wrongSignature := sign("hehe")
+	var mismatches []error
+	for _, accessKey := range []string{"hehe", "haha"} {
+		_, err := IsReqAuthenticated(signedV2Request(t, accessKey, wrongSignature))
+		mismatches = append(mismatches, err)
+	}
+	// even the correct signature of an unknown key, as if its secret were
+	// the same as that of "hehe"
+	_, err := IsReqAuthenticated(signedV2Request(t, "haha", ""))
+	mismatches = append(mismatches, err)
+	for i, err := range mismatches {
+		mismatch, ok := err.(SignatureMismatch)
+		if !ok {
+			t.Fatalf("%d: expected SignatureMismatch, got %v", i, err)
+		}
+		if mismatch.StringToSign != mismatches[0].(SignatureMismatch).StringToSign {
+			t.Errorf("%d: unexpected string to sign %q", i, mismatch.StringToSign)
+		}
+	}
+	if mismatches[1].(SignatureMismatch).SignatureProvided != wrongSignature {
+		t.Errorf("provided signature should be returned, got %+v", mismatches[1])
+	}
+}
+
+// Guess signatures of one key in a loop, then sign correctly
+func TestBruteForceSignature(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+	now := time.Now()
+	var delays []time.Duration
+	tracker := newFailureTracker(5, time.Minute, 100*time.Millisecond, time.Second, 100)
+	tracker.now = func() time.Time { return now }
+	tracker.sleep = func(d time.Duration) { delays = append(delays, d) }
+	authFailures = tracker
+	defer func() { authFailures = nil }()
+
+	for i := 0; i < 8; i++ {
+		_, err := IsReqAuthenticated(signedV2Request(t, "hehe", sign(fmt.Sprint(i))))
+		if i < 5 && !IsSignatureMismatch(err) {
+			t.Fatalf("guess %d: expected signature mismatch, got %v", i, err)
+		}
+		if i >= 5 && err != ErrAccessDenied {
+			t.Fatalf("guess %d: expected access denied, got %v", i, err)
+		}
+	}
+	if fmt.Sprint(delays) != "[100ms 200ms 400ms 800ms 1s]" {
+		t.Errorf("failures should be delayed increasingly, got %v", delays)
+	}
+	if _, err := IsReqAuthenticated(signedV2Request(t, "hehe", "")); err != ErrAccessDenied {
+		t.Errorf("correct signature should be denied as well, got %v", err)
+	}
+	other := signedV2Request(t, "hehe", "")
+	other.RemoteAddr = "10.0.0.2:12345"
+	if _, err := IsReqAuthenticated(other); err != nil {
+		t.Errorf("other clients should not be affected, got %v", err)
+	}
+	stats := GetAuthFailureStats()
+	if stats.Failures != 5 || stats.Delayed != 5 || stats.Denied != 4 || stats.Tracked != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := IsReqAuthenticated(signedV2Request(t, "hehe", "")); err != nil {
+		t.Errorf("failures should be forgotten after window, got %v", err)
+	}
+	if GetAuthFailureStats().Tracked != 0 {
+		t.Error("expired failures should be dropped")
+	}
+}
+
+func TestFailureTrackerCapacity(t *testing.T) {
+	tracker := newFailureTracker(2, time.Minute, 0, 0, 3)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d|10.0.0.1", i)
+		tracker.fail(key)
+		tracker.fail(key)
+		if tracker.lru.Len() > 3 || len(tracker.entries) > 3 {
+			t.Fatalf("%d entries kept beyond capacity", len(tracker.entries))
+		}
+		if !tracker.blocked(key) {
+			t.Fatalf("%s should be blocked", key)
+		}
+	}
+	if tracker.blocked("key0|10.0.0.1") {
+		t.Error("least recently failed keys should be dropped")
+	}
+}
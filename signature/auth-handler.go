@@ -17,16 +17,12 @@
 package signature
 
 import (
-	"bytes"
-	"crypto/md5"
 	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
-	"io/ioutil"
 	"net/http"
 	"strings"
 
 	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/iam"
 )
 
@@ -106,37 +102,60 @@ func sum256(data []byte) []byte {
 	return hash.Sum(nil)
 }
 
-// sumMD5 calculate md5 sum for an input byte array
-func sumMD5(data []byte) []byte {
-	hash := md5.New()
-	hash.Write(data)
-	return hash.Sum(nil)
-}
-
-// A helper function to verify if request has valid AWS Signature
+// A helper function to verify if request has valid AWS Signature.
+// Payload is verified as `r.Body` is read, so handlers should check
+// errors of reading the body
 func IsReqAuthenticated(r *http.Request) (c iam.Credential, e error) {
-	payload, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return c, ErrInternalError
-	}
-	// Verify Content-Md5, if payload is set.
-	if r.Header.Get("Content-Md5") != "" {
-		if r.Header.Get("Content-Md5") != base64.StdEncoding.EncodeToString(sumMD5(payload)) {
-			return c, ErrBadDigest
+	validateRegion := true // TODO: Validate region.
+	authType := GetRequestAuthType(r)
+	c, e = guardSignature(r, GetAccessKeyUnverified(r), func() (iam.Credential, error) {
+		switch authType {
+		case AuthTypePresignedV4:
+			return DoesPresignedSignatureMatchV4(r, validateRegion)
+		case AuthTypeSignedV4:
+			// signature covers the claimed hash, which is then checked
+			// against the payload by sha256VerifyReader
+			return DoesSignatureMatchV4(r.Header.Get("X-Amz-Content-Sha256"), r, validateRegion)
+		case AuthTypePresignedV2:
+			return DoesPresignedSignatureMatchV2(r)
+		case AuthTypeSignedV2:
+			return DoesSignatureMatchV2(r)
 		}
+		return iam.Credential{}, ErrAccessDenied
+	})
+	if e != nil {
+		return
 	}
-	// Populate back the payload.
-	r.Body = ioutil.NopCloser(bytes.NewReader(payload))
-	validateRegion := true // TODO: Validate region.
+	helper.EnableDebugLogging(r.Context(), c.AccessKeyID)
+	r.Body, e = newSha256VerifyReader(r, authType == AuthTypeSignedV4)
+	return
+}
+
+// Extract access key from request without verifying signature, returns
+// empty string for anonymous or malformed requests. Used where requests
+// need to be identified before authentication, e.g. rate limiting
+func GetAccessKeyUnverified(r *http.Request) string {
 	switch GetRequestAuthType(r) {
-	case AuthTypePresignedV4:
-		return DoesPresignedSignatureMatchV4(r, validateRegion)
 	case AuthTypeSignedV4:
-		return DoesSignatureMatchV4(hex.EncodeToString(sum256(payload)), r, validateRegion)
-	case AuthTypePresignedV2:
-		return DoesPresignedSignatureMatchV2(r)
+		signV4Values, err := parseSignV4(r.Header.Get("Authorization"), r.Header)
+		if err != nil {
+			return ""
+		}
+		return signV4Values.Credential.accessKey
+	case AuthTypePresignedV4:
+		credential, err := parseCredential(r.URL.Query().Get("X-Amz-Credential"))
+		if err != nil {
+			return ""
+		}
+		return credential.accessKey
 	case AuthTypeSignedV2:
-		return DoesSignatureMatchV2(r)
+		accessKey, _, err := parseSignV2(r.Header.Get("Authorization"))
+		if err != nil {
+			return ""
+		}
+		return accessKey
+	case AuthTypePresignedV2:
+		return r.URL.Query().Get("AWSAccessKeyId")
 	}
-	return c, ErrAccessDenied
+	return ""
 }
@@ -1,7 +1,11 @@
 package signature
 
 import (
+	"net/http"
 	"regexp"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
 )
 
 type PostPolicyType int
@@ -37,3 +41,25 @@ func GetPostPolicyType(formValues map[string]string) PostPolicyType {
 	}
 	return PostPolicyUnknown
 }
+
+// Verify signature of POST policy of V2 or V4, failures are tracked the same
+// way as signatures of other requests
+func DoesPolicySignatureMatch(r *http.Request, formValues map[string]string,
+	postPolicyType PostPolicyType) (credential iam.Credential, err error) {
+
+	switch postPolicyType {
+	case PostPolicyV2:
+		return guardSignature(r, formValues["Awsaccesskeyid"], func() (iam.Credential, error) {
+			return DoesPolicySignatureMatchV2(formValues)
+		})
+	case PostPolicyV4:
+		var accessKey string
+		if credHeader, err := parseCredential(formValues["X-Amz-Credential"]); err == nil {
+			accessKey = credHeader.accessKey
+		}
+		return guardSignature(r, accessKey, func() (iam.Credential, error) {
+			return DoesPolicySignatureMatchV4(formValues)
+		})
+	}
+	return credential, ErrMalformedPOSTRequest
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -50,12 +51,19 @@ func toString(val interface{}) string {
 }
 
 // toInteger _ Safely convert interface to integer without causing panic.
-func toInteger(val interface{}) int {
+// JSON numbers are decoded as float64, and numbers in strings are accepted
+// as AWS does
+func toInteger(val interface{}) (int64, error) {
 	switch v := val.(type) {
-	case int:
-		return v
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, fmt.Errorf("%v is not an integer", v)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
 	}
-	return 0
+	return 0, fmt.Errorf("%v is not an integer", val)
 }
 
 // isString - Safely check if val is of type string without causing panic.
@@ -76,8 +84,9 @@ type PostPolicyForm struct {
 			Value    string
 		}
 		ContentLengthRange struct {
-			Min int
-			Max int
+			Min   int64
+			Max   int64
+			Valid bool // false if not set in policy
 		}
 	}
 }
@@ -167,17 +176,26 @@ func parsePostPolicyForm(policy string,
 					Operator string
 					Value    string
 				}{
-					Operator: operator,
+					Operator: strings.ToLower(operator),
 					Value:    value,
 				}
 			case "content-length-range":
-				parsedPolicy.Conditions.ContentLengthRange = struct {
-					Min int
-					Max int
-				}{
-					Min: toInteger(condt[1]),
-					Max: toInteger(condt[2]),
+				min, err := toInteger(condt[1])
+				if err != nil {
+					return parsedPolicy, err
 				}
+				max, err := toInteger(condt[2])
+				if err != nil {
+					return parsedPolicy, err
+				}
+				if min < 0 || min > max {
+					return parsedPolicy,
+						fmt.Errorf("Invalid content-length-range [%d, %d] found in POST policy form.",
+							min, max)
+				}
+				parsedPolicy.Conditions.ContentLengthRange.Min = min
+				parsedPolicy.Conditions.ContentLengthRange.Max = max
+				parsedPolicy.Conditions.ContentLengthRange.Valid = true
 			default:
 				// Condition should be valid.
 				return parsedPolicy,
@@ -194,8 +212,11 @@ func parsePostPolicyForm(policy string,
 }
 
 // checkPostPolicy - apply policy conditions and validate input values.
+// Conditions of fields not in the form are checked against empty values.
+// Returns the policy parsed, for ContentLengthRange to be enforced while
+// reading the file
 func CheckPostPolicy(formValues map[string]string,
-	postPolicyVersion PostPolicyType) error {
+	postPolicyVersion PostPolicyType) (PostPolicyForm, error) {
 
 	var eqPolicyRegExp, startswithPolicyRegExp, ignoredFormRegExp *regexp.Regexp
 	switch postPolicyVersion {
@@ -208,44 +229,46 @@ func CheckPostPolicy(formValues map[string]string,
 	case PostPolicyAnonymous:
 		// "Requests without a security policy are considered anonymous"
 		// so no need to check it
-		return nil
+		return PostPolicyForm{}, nil
 	default:
-		return ErrNotImplemented
+		return PostPolicyForm{}, ErrNotImplemented
 	}
 	/// Decoding policy
 	policyBytes, err := base64.StdEncoding.DecodeString(formValues["Policy"])
 	if err != nil {
-		return ErrMalformedPOSTRequest
+		return PostPolicyForm{}, ErrMalformedPOSTRequest
 	}
 	postPolicyForm, err := parsePostPolicyForm(string(policyBytes),
 		eqPolicyRegExp, startswithPolicyRegExp)
 	if err != nil {
 		helper.Logger.Println(5, "Parse post-policy form error:", err)
-		return ErrMalformedPOSTRequest
+		return PostPolicyForm{}, ErrMalformedPOSTRequest
 	}
 	if !postPolicyForm.Expiration.After(time.Now()) {
-		return ErrPolicyAlreadyExpired
+		return PostPolicyForm{}, ErrPolicyAlreadyExpired
 	}
-	for name, value := range formValues {
+	for name := range formValues {
 		if ignoredFormRegExp.MatchString(name) {
 			continue
 		}
-		if condition, ok := postPolicyForm.Conditions.Policies[name]; ok {
-			switch condition.Operator {
-			case "eq":
-				if condition.Value != value {
-					return ErrPolicyViolation
-				}
-			case "starts-with":
-				if !strings.HasPrefix(value, condition.Value) {
-					return ErrPolicyViolation
-				}
-			}
-		} else { // field exists in form but not in policy
+		if _, ok := postPolicyForm.Conditions.Policies[name]; !ok {
+			// field exists in form but not in policy
 			// TODO make this error more specific to users
-			return ErrPolicyMissingFields
+			return PostPolicyForm{}, ErrPolicyMissingFields
+		}
+	}
+	for name, condition := range postPolicyForm.Conditions.Policies {
+		value := formValues[name]
+		switch condition.Operator {
+		case "eq":
+			if condition.Value != value {
+				return PostPolicyForm{}, ErrPolicyViolation
+			}
+		case "starts-with":
+			if !strings.HasPrefix(value, condition.Value) {
+				return PostPolicyForm{}, ErrPolicyViolation
+			}
 		}
 	}
-	// TODO: verify ContentLengthRange
-	return nil
+	return postPolicyForm, nil
 }
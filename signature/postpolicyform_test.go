@@ -0,0 +1,72 @@
+package signature
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+)
+
+func postPolicy(conditions string) string {
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	return base64.StdEncoding.EncodeToString([]byte(`{"expiration": "` + expiration +
+		`", "conditions": [` + conditions + `]}`))
+}
+
+func TestCheckPostPolicy(t *testing.T) {
+	conditions := `{"bucket": "hehe"}, ["starts-with", "$key", "user/"],
+		["starts-with", "$Content-Type", "image/"], ["content-length-range", 1, 1048576]`
+	cases := []struct {
+		form     map[string]string
+		expected error
+	}{
+		{map[string]string{"Bucket": "hehe", "Key": "user/a.png", "Content-Type": "image/png"}, nil},
+		{map[string]string{"Bucket": "haha", "Key": "user/a.png", "Content-Type": "image/png"},
+			ErrPolicyViolation},
+		{map[string]string{"Bucket": "hehe", "Key": "admin/a.png", "Content-Type": "image/png"},
+			ErrPolicyViolation},
+		// conditions of fields missing in form are not met
+		{map[string]string{"Bucket": "hehe", "Key": "user/a.png"}, ErrPolicyViolation},
+		{map[string]string{"Bucket": "hehe", "Key": "user/a.png", "Content-Type": "image/png",
+			"Acl": "public-read"}, ErrPolicyMissingFields},
+	}
+	for i, c := range cases {
+		c.form["Policy"] = postPolicy(conditions)
+		c.form["Awsaccesskeyid"] = "hehe"
+		c.form["Signature"] = "hehe"
+		form, err := CheckPostPolicy(c.form, PostPolicyV2)
+		if err != c.expected {
+			t.Errorf("case %d: expected %v, got %v", i, c.expected, err)
+		}
+		if err == nil {
+			lengthRange := form.Conditions.ContentLengthRange
+			if !lengthRange.Valid || lengthRange.Min != 1 || lengthRange.Max != 1048576 {
+				t.Errorf("case %d: content-length-range parsed wrong: %+v", i, lengthRange)
+			}
+		}
+	}
+
+	// empty prefix allows any value, or no value at all
+	form := map[string]string{"Bucket": "hehe", "Key": "hehe",
+		"Policy": postPolicy(`{"bucket": "hehe"}, ["starts-with", "$key", ""],
+			["starts-with", "$Content-Type", ""]`)}
+	policy, err := CheckPostPolicy(form, PostPolicyV2)
+	if err != nil || policy.Conditions.ContentLengthRange.Valid {
+		t.Errorf("expected no content-length-range, got %+v, %v", policy, err)
+	}
+
+	for _, conditions := range []string{
+		`["content-length-range", "1", "10"]`,
+		`["content-length-range", 1.5, 10]`,
+		`["content-length-range", 10, 1]`,
+		`["content-length-range", -1, 1]`,
+	} {
+		form := map[string]string{"Bucket": "hehe", "Policy": postPolicy(`{"bucket": "hehe"}, ` + conditions)}
+		policy, err := CheckPostPolicy(form, PostPolicyV2)
+		valid := conditions == `["content-length-range", "1", "10"]`
+		if (err == nil) != valid {
+			t.Errorf("%s: got %+v, %v", conditions, policy.Conditions.ContentLengthRange, err)
+		}
+	}
+}
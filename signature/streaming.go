@@ -0,0 +1,141 @@
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+)
+
+// For STREAMING-AWS4-HMAC-SHA256-PAYLOAD, see
+// http://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html
+const (
+	StreamingContentSHA256 = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	signV4ChunkAlgorithm   = "AWS4-HMAC-SHA256-PAYLOAD"
+	// hex encoded SHA256 of empty string
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// a chunk is held in memory until its signature is verified
+	maxChunkSize = 16 << 20
+)
+
+func IsStreamingUpload(r *http.Request) bool {
+	return r.Header.Get("X-Amz-Content-Sha256") == StreamingContentSHA256
+}
+
+// Length of payload carried by a streaming upload, Content-Length includes
+// chunk headers and signatures
+func DecodedContentLength(r *http.Request) (int64, error) {
+	size, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return 0, ErrMissingContentLength
+	}
+	return size, nil
+}
+
+// chunkedReader decodes a streaming upload body of chunks, each as
+// hex(size) + ";chunk-signature=" + signature + "\r\n" + data + "\r\n",
+// ended by a chunk of size 0. Each chunk is signed upon the signature of
+// the chunk before it, starting from the seed signature in Authorization
+// header. Data of a chunk is returned only after its signature is verified.
+type chunkedReader struct {
+	reader            *bufio.Reader
+	signingKey        []byte
+	date              string
+	scope             string
+	previousSignature string
+	chunk             []byte // data of current chunk not read yet
+	err               error
+}
+
+// Verify seed signature of a streaming upload and wrap its body for decoding
+func newChunkedReader(r *http.Request) (credential iam.Credential, reader io.Reader, err error) {
+	credential, err = DoesSignatureMatchV4(StreamingContentSHA256, r, true)
+	if err != nil {
+		return
+	}
+	// already validated above
+	signV4Values, _ := parseSignV4(r.Header.Get("Authorization"), r.Header)
+	date := r.Header.Get("x-amz-date")
+	if date == "" {
+		date = r.Header.Get("Date")
+	}
+	t, _ := ParseAmzDate(date)
+	region := signV4Values.Credential.scope.region
+	return credential, &chunkedReader{
+		reader:            bufio.NewReader(r.Body),
+		signingKey:        getSigningKey(credential.SecretAccessKey, t, region),
+		date:              t.Format(Iso8601Format),
+		scope:             getScope(t, region),
+		previousSignature: signV4Values.Signature,
+	}, nil
+}
+
+func (c *chunkedReader) chunkSignature(chunk []byte) string {
+	chunkSha256 := sha256.Sum256(chunk)
+	stringToSign := strings.Join([]string{
+		signV4ChunkAlgorithm,
+		c.date,
+		c.scope,
+		c.previousSignature,
+		emptySHA256,
+		hex.EncodeToString(chunkSha256[:]),
+	}, "\n")
+	return getSignature(c.signingKey, stringToSign)
+}
+
+// Read and verify next chunk, returns io.EOF after the final chunk
+func (c *chunkedReader) nextChunk() error {
+	// ReadSlice fails for headers longer than the buffer
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil {
+		return ErrIncompleteBody
+	}
+	header := strings.TrimSuffix(string(line), "\r\n")
+	parts := strings.SplitN(header, ";", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], "chunk-signature=") {
+		return ErrIncompleteBody
+	}
+	size, err := strconv.ParseInt(parts[0], 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return ErrIncompleteBody
+	}
+	signature := strings.TrimPrefix(parts[1], "chunk-signature=")
+
+	chunk := make([]byte, size+2)
+	if _, err = io.ReadFull(c.reader, chunk); err != nil {
+		return ErrIncompleteBody
+	}
+	if !bytes.HasSuffix(chunk, []byte("\r\n")) {
+		return ErrIncompleteBody
+	}
+	chunk = chunk[:size]
+	if c.chunkSignature(chunk) != signature {
+		return ErrSignatureDoesNotMatch
+	}
+	c.previousSignature = signature
+	if size == 0 {
+		return io.EOF
+	}
+	c.chunk = chunk
+	return nil
+}
+
+func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	for len(c.chunk) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.nextChunk()
+	}
+	n = copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
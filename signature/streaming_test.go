@@ -0,0 +1,142 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+var streamingSignedHeaders = []string{"host", "x-amz-content-sha256", "x-amz-date",
+	"x-amz-decoded-content-length"}
+
+// Build a part upload in the streaming format the way aws-sdk does,
+// `tamper` is called with the encoded body before it's sent
+func newStreamingRequest(t *testing.T, chunks [][]byte, tamper func([]byte)) *http.Request {
+	now := time.Now().UTC()
+	region := "cn-bj-1"
+	signingKey := getSigningKey("hehehehe", now, region)
+	decodedLength := 0
+	for _, chunk := range chunks {
+		decodedLength += len(chunk)
+	}
+
+	r, err := http.NewRequest("PUT",
+		"http://s3.test.com/bucket/object?partNumber=1&uploadId=hehe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Amz-Content-Sha256", StreamingContentSHA256)
+	r.Header.Set("X-Amz-Date", now.Format(Iso8601Format))
+	r.Header.Set("X-Amz-Decoded-Content-Length", strconv.Itoa(decodedLength))
+	canonicalHeaders, err := getCanonicalHeaders(streamingSignedHeaders, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalRequest := getCanonicalRequest(canonicalHeaders, StreamingContentSHA256,
+		r.URL.Query().Encode(), r.URL.Path, r.Method, streamingSignedHeaders)
+	seedSignature := getSignature(signingKey, getStringToSign(canonicalRequest, now, region))
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=hehehehe/%s/%s/s3/aws4_request,SignedHeaders=%s,Signature=%s",
+		now.Format(YYYYMMDD), region, strings.Join(streamingSignedHeaders, ";"),
+		seedSignature))
+
+	var body bytes.Buffer
+	previousSignature := seedSignature
+	for _, chunk := range append(chunks, nil) {
+		chunkSha256 := sha256.Sum256(chunk)
+		stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + now.Format(Iso8601Format) + "\n" +
+			getScope(now, region) + "\n" + previousSignature + "\n" + emptySHA256 + "\n" +
+			hex.EncodeToString(chunkSha256[:])
+		signature := getSignature(signingKey, stringToSign)
+		fmt.Fprintf(&body, "%x;chunk-signature=%s\r\n", len(chunk), signature)
+		body.Write(chunk)
+		body.WriteString("\r\n")
+		previousSignature = signature
+	}
+	if tamper != nil {
+		tamper(body.Bytes())
+	}
+	r.Body = ioutil.NopCloser(&body)
+	r.ContentLength = int64(body.Len())
+	return r
+}
+
+func TestStreamingUpload(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	chunks := [][]byte{bytes.Repeat([]byte("a"), 64*1024), bytes.Repeat([]byte("b"), 1000)}
+	r := newStreamingRequest(t, chunks, nil)
+	size, err := DecodedContentLength(r)
+	if err != nil || size != 64*1024+1000 {
+		t.Fatalf("wrong decoded length %d, %v", size, err)
+	}
+	credential, reader, err := VerifyUpload(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.AccessKeyID != "hehehehe" {
+		t.Errorf("wrong credential %+v", credential)
+	}
+	if _, ok := reader.(*SignVerifyReader); ok {
+		t.Error("streaming uploads are verified chunk by chunk")
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytes.Join(chunks, nil)) {
+		t.Error("payload decoded wrong")
+	}
+}
+
+func TestStreamingUploadTampered(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	chunks := [][]byte{[]byte("hehe"), []byte("haha")}
+	cases := []struct {
+		tamper func([]byte)
+		data   string // verified chunks before the error
+		err    error
+	}{
+		// data of the second chunk
+		{func(body []byte) { body[len(body)-92] = 'x' }, "hehe", ErrSignatureDoesNotMatch},
+		// signature of the final chunk
+		{func(body []byte) { body[len(body)-5] ^= 1 }, "hehehaha", ErrSignatureDoesNotMatch},
+		// size of the first chunk
+		{func(body []byte) { body[0] = '3' }, "", ErrIncompleteBody},
+	}
+	for i, c := range cases {
+		r := newStreamingRequest(t, chunks, c.tamper)
+		_, reader, err := VerifyUpload(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+		}
+		if string(data) != c.data {
+			t.Errorf("case %d: expected data %q, got %q", i, c.data, data)
+		}
+	}
+
+	// seed signature covers the decoded length
+	r := newStreamingRequest(t, chunks, nil)
+	r.Header.Set("X-Amz-Decoded-Content-Length", "4")
+	if _, _, err := VerifyUpload(r); !IsSignatureMismatch(err) {
+		t.Errorf("expected seed signature mismatch, got %v", err)
+	}
+}
@@ -21,10 +21,6 @@ import (
 const (
 	SignV2Algorithm = "AWS"
 	SignV4Algorithm = "AWS4-HMAC-SHA256"
-	/*HOST_URL        = "s3.test.com"  should be something like
-	s3.lecloud.com
-	for production servers
-	*/
 )
 
 func verifyDate(dateString string) (bool, error) {
@@ -73,14 +69,11 @@ func buildCanonicalizedAmzHeaders(headers *http.Header) string {
 
 func buildCanonicalizedResource(req *http.Request) string {
 	ans := ""
-	v := strings.Split(req.Host, ":")
-	hostWithOutPort := v[0]
-	if strings.HasSuffix(hostWithOutPort, "."+helper.CONFIG.S3Domain) {
-		bucket := strings.TrimSuffix(hostWithOutPort, "."+helper.CONFIG.S3Domain)
+	if bucket, ok := helper.BucketFromHost(req.Host); ok {
 		ans += "/" + bucket
 	}
 	ans += req.URL.EscapedPath()
-	helper.Debugln("HOST:", req.Host, hostWithOutPort, ans)
+	helper.Debugln("HOST:", req.Host, ans)
 	requiredQuery := []string{
 		// NOTE: this array is sorted alphabetically
 		"acl", "cors", "delete", "lifecycle", "location",
@@ -92,6 +85,7 @@ func buildCanonicalizedResource(req *http.Request) string {
 		"response-content-language",
 		"response-content-type",
 		"response-expires",
+		"select", "select-type",
 		"torrent", "uploadId", "uploads", "versionId",
 		"versioning", "versions", "website",
 	}
@@ -130,24 +124,37 @@ func dictate(secretKey string, stringToSign string, signature []byte) error {
 	return nil
 }
 
-func DoesSignatureMatchV2(r *http.Request) (credential iam.Credential, err error) {
-	authorizationHeader := r.Header.Get("Authorization")
-	splitHeader := strings.Split(authorizationHeader, " ")
-	// Authorization = "AWS" + " " + AWSAccessKeyId + ":" + Signature;
-	splitSignature := strings.Split(splitHeader[1], ":")
+// Split Authorization header of V2 into access key and signature, without
+// verifying either of them
+// Authorization = "AWS" + " " + AWSAccessKeyId + ":" + Signature;
+func parseSignV2(authorizationHeader string) (accessKey, signature string, err error) {
+	if !strings.HasPrefix(authorizationHeader, SignV2Algorithm+" ") {
+		return "", "", ErrAuthorizationHeaderMalformed
+	}
+	splitSignature := strings.Split(strings.TrimPrefix(authorizationHeader, SignV2Algorithm+" "), ":")
 	if len(splitSignature) != 2 {
-		return credential, ErrMissingSignTag
+		return "", "", ErrMissingFields
 	}
-	accessKey := splitSignature[0]
-	credential, e := iam.GetCredential(accessKey)
-	helper.Debug("cre1:%s,%s,%s,%s", credential.UserId, credential.DisplayName, credential.AccessKeyID, credential.SecretAccessKey)
-	if e != nil {
-		return credential, ErrInvalidAccessKeyID
+	accessKey, signature = splitSignature[0], splitSignature[1]
+	if accessKey == "" || signature == "" {
+		return "", "", ErrMissingFields
+	}
+	return accessKey, signature, nil
+}
+
+func DoesSignatureMatchV2(r *http.Request) (credential iam.Credential, err error) {
+	accessKey, signatureString, err := parseSignV2(r.Header.Get("Authorization"))
+	if err != nil {
+		return credential, err
 	}
-	signature, e := base64.StdEncoding.DecodeString(splitSignature[1])
+	signature, e := base64.StdEncoding.DecodeString(signatureString)
 	if e != nil {
 		return credential, ErrAuthorizationHeaderMalformed
 	}
+	credential, known, err := lookupCredential(accessKey)
+	if err != nil {
+		return credential, err
+	}
 	// StringToSign = HTTP-Verb + "\n" +
 	// 	Content-MD5 + "\n" +
 	// 	Content-Type + "\n" +
@@ -186,7 +193,10 @@ func DoesSignatureMatchV2(r *http.Request) (credential iam.Credential, err error
 	stringToSign += buildCanonicalizedResource(r)
 	helper.Debugln("stringtosign", stringToSign, credential.SecretAccessKey)
 	helper.Debugln("credential", credential.UserId, credential.AccessKeyID, credential.SecretAccessKey)
-	return credential, dictate(credential.SecretAccessKey, stringToSign, signature)
+	if err = dictate(credential.SecretAccessKey, stringToSign, signature); err != nil || !known {
+		return iam.Credential{}, signatureMismatch(accessKey, known, stringToSign, signatureString)
+	}
+	return credential, nil
 }
 
 func DoesPresignedSignatureMatchV2(r *http.Request) (credential iam.Credential, err error) {
@@ -194,15 +204,18 @@ func DoesPresignedSignatureMatchV2(r *http.Request) (credential iam.Credential,
 	accessKey := query.Get("AWSAccessKeyId")
 	expires := query.Get("Expires")
 	signatureString := query.Get("Signature")
-
-	credential, e := iam.GetCredential(accessKey)
-	if e != nil {
-		return credential, ErrInvalidAccessKeyID
+	if accessKey == "" || expires == "" || signatureString == "" {
+		return credential, ErrMissingFields
 	}
+
 	signature, e := base64.StdEncoding.DecodeString(signatureString)
 	if e != nil {
 		return credential, ErrAuthorizationHeaderMalformed
 	}
+	credential, known, err := lookupCredential(accessKey)
+	if err != nil {
+		return credential, err
+	}
 	if verified, e := verifyNotExpires(expires); e != nil {
 		return credential, ErrMalformedDate
 	} else if !verified {
@@ -222,34 +235,38 @@ func DoesPresignedSignatureMatchV2(r *http.Request) (credential iam.Credential,
 	stringToSign += buildCanonicalizedAmzHeaders(&r.Header)
 	stringToSign += buildCanonicalizedResource(r)
 
-	return credential, dictate(credential.SecretAccessKey, stringToSign, signature)
+	if err = dictate(credential.SecretAccessKey, stringToSign, signature); err != nil || !known {
+		return iam.Credential{}, signatureMismatch(accessKey, known, stringToSign, signatureString)
+	}
+	return credential, nil
 }
 
 func DoesPolicySignatureMatchV2(formValues map[string]string) (credential iam.Credential,
 	err error) {
 
-	if accessKey, ok := formValues["Awsaccesskeyid"]; ok {
-		credential, err = iam.GetCredential(accessKey)
-		if err != nil {
-			return credential, ErrInvalidAccessKeyID
-		}
-	} else {
+	accessKey, ok := formValues["Awsaccesskeyid"]
+	if !ok {
 		return credential, ErrMissingFields
 	}
+	credential, known, err := lookupCredential(accessKey)
+	if err != nil {
+		return credential, err
+	}
 
 	var signatureString string
-	var ok bool
 	if signatureString, ok = formValues["Signature"]; !ok {
 		return credential, ErrMissingFields
 	}
-	signature, err := base64.StdEncoding.DecodeString(signatureString)
-	if err != nil {
-		return credential, ErrSignatureDoesNotMatch
-	}
 	var policy string
 	if policy, ok = formValues["Policy"]; !ok {
 		return credential, ErrMissingFields
 	}
-
-	return credential, dictate(credential.SecretAccessKey, policy, signature)
+	signature, err := base64.StdEncoding.DecodeString(signatureString)
+	if err != nil {
+		return iam.Credential{}, SignatureMismatch{StringToSign: policy, SignatureProvided: signatureString}
+	}
+	if err = dictate(credential.SecretAccessKey, policy, signature); err != nil || !known {
+		return iam.Credential{}, signatureMismatch(accessKey, known, policy, signatureString)
+	}
+	return credential, nil
 }
@@ -0,0 +1,195 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/log"
+)
+
+func init() {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+}
+
+// Escape key the way botocore does, i.e. everything but unreserved
+// characters and "/"
+func clientEscape(key string) string {
+	escaped := ""
+	for _, b := range []byte(key) {
+		if 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' ||
+			b == '-' || b == '_' || b == '.' || b == '~' || b == '/' {
+			escaped += string(b)
+		} else {
+			escaped += fmt.Sprintf("%%%02X", b)
+		}
+	}
+	return escaped
+}
+
+func sign(stringToSign string) string {
+	mac := hmac.New(sha1.New, []byte("hehehehe"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var v2Keys = []string{"hehe", "he he", "中文/对象", "a+b", "a+b c=d&e!", "a/b%2Fc", "dir/", "a//b"}
+
+func TestSignatureV2EscapedPath(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	for _, key := range v2Keys {
+		path := "/bucket/" + clientEscape(key)
+		date := time.Now().UTC().Format(http.TimeFormat)
+		r, err := http.NewRequest("GET", "http://s3.test.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.URL.Path != "/bucket/"+key {
+			t.Fatalf("bad request path %s", r.URL.Path)
+		}
+		r.Header.Set("Date", date)
+		r.Header.Set("Authorization", "AWS hehe:"+sign("GET\n\n\n"+date+"\n"+path))
+		if _, err := DoesSignatureMatchV2(r); err != nil {
+			t.Errorf("key %q: signature should match, got %v", key, err)
+		}
+	}
+}
+
+func TestPresignedSignatureV2EscapedPath(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	for _, key := range v2Keys {
+		path := "/bucket/" + clientEscape(key)
+		expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		query := url.Values{}
+		query.Set("AWSAccessKeyId", "hehe")
+		query.Set("Expires", expires)
+		query.Set("Signature", sign("GET\n\n\n"+expires+"\n"+path))
+		r, err := http.NewRequest("GET", "http://s3.test.com"+path+"?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DoesPresignedSignatureMatchV2(r); err != nil {
+			t.Errorf("key %q: presigned signature should match, got %v", key, err)
+		}
+	}
+}
+
+// Clients not escaping reserved characters sign the path as sent
+func TestSignatureV2UnescapedPath(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	path := "/bucket/a+b=c"
+	date := time.Now().UTC().Format(http.TimeFormat)
+	r, _ := http.NewRequest("GET", "http://s3.test.com"+path, nil)
+	r.Header.Set("Date", date)
+	r.Header.Set("Authorization", "AWS hehe:"+sign("GET\n\n\n"+date+"\n"+path))
+	if _, err := DoesSignatureMatchV2(r); err != nil {
+		t.Errorf("signature should match, got %v", err)
+	}
+}
+
+// Virtual-hosted-style requests sign the bucket from host as part of path
+func TestSignatureV2VirtualHost(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	helper.CONFIG.S3Domain = "s3.test.com"
+	helper.CONFIG.Region = "cn-bj-1"
+	helper.CONFIG.RegionalS3Domain = true
+	helper.CONFIG.S3DomainAliases = []string{"cdn.s3.test.com"}
+	defer func() {
+		helper.CONFIG.DebugMode = false
+		helper.CONFIG.RegionalS3Domain = false
+		helper.CONFIG.S3DomainAliases = nil
+	}()
+
+	for _, url := range []string{
+		"http://s3.test.com/bucket/object",
+		"http://bucket.s3.test.com/object",
+		"http://bucket.s3.test.com:8080/object",
+		"http://bucket.s3.cn-bj-1.test.com/object",
+		"http://s3.cn-bj-1.test.com/bucket/object",
+		"http://bucket.cdn.s3.test.com/object",
+		"http://cdn.s3.test.com/bucket/object",
+	} {
+		date := time.Now().UTC().Format(http.TimeFormat)
+		r, _ := http.NewRequest("GET", url, nil)
+		r.Header.Set("Date", date)
+		r.Header.Set("Authorization", "AWS hehe:"+sign("GET\n\n\n"+date+"\n/bucket/object"))
+		if _, err := DoesSignatureMatchV2(r); err != nil {
+			t.Errorf("%s: signature should match, got %v", url, err)
+		}
+	}
+}
+
+func TestSignatureV2Malformed(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+	good := sign("GET\n\n\n" + date + "\n/bucket/object")
+	for _, c := range []struct {
+		authorization string
+		err           error
+	}{
+		{"", ErrAuthorizationHeaderMalformed},
+		{"AWS", ErrAuthorizationHeaderMalformed},
+		{"AWS4-HMAC-SHA256 hehe:" + good, ErrAuthorizationHeaderMalformed},
+		{"AWS ", ErrMissingFields},
+		{"AWS hehe", ErrMissingFields},
+		{"AWS hehe" + good, ErrMissingFields},
+		{"AWS :" + good, ErrMissingFields},
+		{"AWS hehe:", ErrMissingFields},
+		{"AWS hehe:" + good + ":", ErrMissingFields},
+		{"AWS hehe:not base64!", ErrAuthorizationHeaderMalformed},
+		{"AWS hehe:" + good[:len(good)-2], ErrAuthorizationHeaderMalformed},
+		{"AWS hehe:" + good, nil},
+	} {
+		r, _ := http.NewRequest("GET", "http://s3.test.com/bucket/object", nil)
+		r.Header.Set("Date", date)
+		r.Header.Set("Authorization", c.authorization)
+		if _, err := DoesSignatureMatchV2(r); err != c.err {
+			t.Errorf("%q: expected %v, got %v", c.authorization, c.err, err)
+		}
+	}
+}
+
+func TestPresignedSignatureV2Malformed(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	good := sign("GET\n\n\n" + expires + "\n/bucket/object")
+	for _, c := range []struct {
+		accessKey, expires, signature string
+		err                           error
+	}{
+		{"", expires, good, ErrMissingFields},
+		{"hehe", "", good, ErrMissingFields},
+		{"hehe", expires, "", ErrMissingFields},
+		{"hehe", expires, "not base64!", ErrAuthorizationHeaderMalformed},
+		{"hehe", "tomorrow", good, ErrMalformedDate},
+		{"hehe", expires, good, nil},
+	} {
+		query := url.Values{}
+		query.Set("AWSAccessKeyId", c.accessKey)
+		query.Set("Expires", c.expires)
+		query.Set("Signature", c.signature)
+		r, _ := http.NewRequest("GET", "http://s3.test.com/bucket/object?"+query.Encode(), nil)
+		if _, err := DoesPresignedSignatureMatchV2(r); err != c.err {
+			t.Errorf("%v: expected %v, got %v", query, c.err, err)
+		}
+	}
+}
@@ -18,6 +18,7 @@ package signature
 
 import (
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -199,11 +200,13 @@ func parsePreSignV4(query url.Values, headers http.Header) (preSignValues, error
 		return preSignValues{}, ErrMalformedDate
 	}
 
-	// Save expires in native time.Duration.
-	preSignV4Values.Expires, err = time.ParseDuration(query.Get("X-Amz-Expires") + "s")
-	if err != nil {
+	// Save expires in native time.Duration, it's number of seconds and
+	// between 1 and 604800(seven days)
+	expires, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires < 1 || expires > int64(PresignedUrlExpireLimit/time.Second) {
 		return preSignValues{}, ErrMalformedExpires
 	}
+	preSignV4Values.Expires = time.Duration(expires) * time.Second
 
 	// Save signed headers.
 	preSignV4Values.SignedHeaders, err =
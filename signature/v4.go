@@ -25,6 +25,7 @@
 package signature
 
 import (
+	"crypto/hmac"
 	"encoding/hex"
 	"net/http"
 	"sort"
@@ -33,6 +34,7 @@ import (
 
 	. "github.com/journeymidnight/yig/api/datatype"
 	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/iam"
 )
 
@@ -134,9 +136,9 @@ func DoesPolicySignatureMatchV4(formValues map[string]string) (credential iam.Cr
 		return credential, ErrMalformedDate
 	}
 
-	credential, e = iam.GetCredential(credHeader.accessKey)
-	if e != nil {
-		return credential, ErrInvalidAccessKeyID
+	credential, known, err := lookupCredential(credHeader.accessKey)
+	if err != nil {
+		return credential, err
 	}
 	// Get signing key.
 	signingKey := getSigningKey(credential.SecretAccessKey, t, region)
@@ -145,10 +147,8 @@ func DoesPolicySignatureMatchV4(formValues map[string]string) (credential iam.Cr
 	newSignature := getSignature(signingKey, formValues["Policy"])
 
 	// Verify signature.
-	if newSignature != formValues["X-Amz-Signature"] {
-		return credential, ErrSignatureDoesNotMatch
-	}
-	return credential, nil
+	return signatureMatches(credential, known, newSignature, formValues["X-Amz-Signature"],
+		formValues["Policy"])
 }
 
 // doesPresignedSignatureMatch - Verify query headers with presigned signature
@@ -162,10 +162,19 @@ func DoesPresignedSignatureMatchV4(r *http.Request,
 		return credential, err
 	}
 
-	if preSignValues.Expires > PresignedUrlExpireLimit {
-		return credential, ErrMalformedExpires
+	maxExpires := helper.CONFIG.MaxPresignedExpiry
+	if maxExpires == 0 {
+		maxExpires = PresignedUrlExpireLimit
 	}
-	if time.Now().Sub(preSignValues.Date) > time.Duration(preSignValues.Expires) {
+	if preSignValues.Expires > maxExpires {
+		return credential, ErrPresignedExpiresTooLong
+	}
+	// or URLs could be valid longer than allowed by signing in future
+	now := time.Now()
+	if preSignValues.Date.Sub(now) > 15*time.Minute {
+		return credential, ErrRequestNotReadyYet
+	}
+	if now.Sub(preSignValues.Date) > preSignValues.Expires {
 		return credential, ErrExpiredPresignRequest
 	}
 
@@ -194,9 +203,9 @@ func DoesPresignedSignatureMatchV4(r *http.Request,
 	// Get string to sign from canonical request.
 	presignedStringToSign := getStringToSign(presignedCanonicalReq, preSignValues.Date, region)
 
-	credential, e := iam.GetCredential(preSignValues.Credential.accessKey)
-	if e != nil {
-		return credential, ErrInvalidAccessKeyID
+	credential, known, err := lookupCredential(preSignValues.Credential.accessKey)
+	if err != nil {
+		return credential, err
 	}
 	// Get hmac presigned signing key.
 	presignedSigningKey := getSigningKey(credential.SecretAccessKey, preSignValues.Date, region)
@@ -205,10 +214,8 @@ func DoesPresignedSignatureMatchV4(r *http.Request,
 	newSignature := getSignature(presignedSigningKey, presignedStringToSign)
 
 	// Verify signature.
-	if preSignValues.Signature != newSignature {
-		return credential, ErrSignatureDoesNotMatch
-	}
-	return credential, nil
+	return signatureMatches(credential, known, newSignature, preSignValues.Signature,
+		presignedStringToSign)
 }
 
 // get credential but not verify it, used only for signed v4 auth
@@ -220,9 +227,11 @@ func getCredentialUnverified(r *http.Request) (credential iam.Credential, err er
 		return credential, err
 	}
 
-	credential, e := iam.GetCredential(signV4Values.Credential.accessKey)
-	if e != nil {
-		return credential, ErrInvalidAccessKeyID
+	credential, known, err := lookupCredential(signV4Values.Credential.accessKey)
+	if err != nil || !known {
+		// unknown access keys are treated as anonymous until the signature
+		// is checked, and rejected then as wrong signatures are
+		return iam.Credential{}, err
 	}
 
 	return credential, nil
@@ -292,9 +301,9 @@ func DoesSignatureMatchV4(hashedPayload string, r *http.Request,
 	// Get string to sign from canonical request.
 	stringToSign := getStringToSign(canonicalRequest, t, region)
 
-	credential, e := iam.GetCredential(signV4Values.Credential.accessKey)
-	if e != nil {
-		return credential, ErrInvalidAccessKeyID
+	credential, known, err := lookupCredential(signV4Values.Credential.accessKey)
+	if err != nil {
+		return credential, err
 	}
 	// Get hmac signing key.
 	signingKey := getSigningKey(credential.SecretAccessKey, t, region)
@@ -303,8 +312,17 @@ func DoesSignatureMatchV4(hashedPayload string, r *http.Request,
 	newSignature := getSignature(signingKey, stringToSign)
 
 	// Verify if signature match.
-	if newSignature != signV4Values.Signature {
-		return credential, ErrSignatureDoesNotMatch
+	return signatureMatches(credential, known, newSignature, signV4Values.Signature, stringToSign)
+}
+
+// Compare signatures in constant time, signatures of unknown access keys
+// never match
+func signatureMatches(credential iam.Credential, known bool,
+	expected, provided, stringToSign string) (iam.Credential, error) {
+
+	if !hmac.Equal([]byte(expected), []byte(provided)) || !known {
+		return iam.Credential{}, signatureMismatch(credential.AccessKeyID, known,
+			stringToSign, provided)
 	}
 	return credential, nil
 }
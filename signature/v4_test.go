@@ -0,0 +1,93 @@
+package signature
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+func newPresignedRequestV4(t *testing.T, date time.Time, expires string) *http.Request {
+	region := "cn-bj-1"
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", signV4Algorithm)
+	query.Set("X-Amz-Credential", "hehehehe/"+getScope(date, region))
+	query.Set("X-Amz-Date", date.Format(Iso8601Format))
+	query.Set("X-Amz-Expires", expires)
+	query.Set("X-Amz-SignedHeaders", "host")
+	r, err := http.NewRequest("GET", "http://s3.test.com/bucket/object?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalHeaders, err := getCanonicalHeaders([]string{"host"}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalRequest := getCanonicalRequest(canonicalHeaders, UnsignedPayload,
+		query.Encode(), r.URL.Path, r.Method, []string{"host"})
+	signature := getSignature(getSigningKey("hehehehe", date, region),
+		getStringToSign(canonicalRequest, date, region))
+	r.URL.RawQuery += "&X-Amz-Signature=" + signature
+	return r
+}
+
+func TestPresignedSignatureV4Expires(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	now := time.Now().UTC()
+	cases := []struct {
+		date    time.Time
+		expires string
+		err     error
+	}{
+		{now, "3600", nil},
+		{now.Add(-time.Hour), "600", ErrExpiredPresignRequest},
+		{now.Add(-6 * 24 * time.Hour), "604800", nil},
+		{now, "0", ErrMalformedExpires},
+		{now, "-1", ErrMalformedExpires},
+		{now, "604801", ErrMalformedExpires},
+		{now, "99999999999999999", ErrMalformedExpires},
+		{now, "1h", ErrMalformedExpires},
+		{now, "", ErrMalformedExpires},
+		{now.Add(time.Hour), "60", ErrRequestNotReadyYet},
+	}
+	for i, c := range cases {
+		r := newPresignedRequestV4(t, c.date, c.expires)
+		if _, err := DoesPresignedSignatureMatchV4(r, true); err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+		}
+	}
+
+	// signature covers the query string
+	r := newPresignedRequestV4(t, now, "3600")
+	r.URL.RawQuery = strings.Replace(r.URL.RawQuery, "X-Amz-Expires=3600",
+		"X-Amz-Expires=7200", 1)
+	if _, err := DoesPresignedSignatureMatchV4(r, true); !IsSignatureMismatch(err) {
+		t.Errorf("expected signature mismatch, got %v", err)
+	}
+}
+
+func TestMaxPresignedExpiry(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	helper.CONFIG.MaxPresignedExpiry = time.Hour
+	defer func() {
+		helper.CONFIG.DebugMode = false
+		helper.CONFIG.MaxPresignedExpiry = 0
+	}()
+
+	now := time.Now().UTC()
+	if _, err := DoesPresignedSignatureMatchV4(newPresignedRequestV4(t, now, "3600"),
+		true); err != nil {
+		t.Errorf("expected signature match, got %v", err)
+	}
+	if _, err := DoesPresignedSignatureMatchV4(newPresignedRequestV4(t, now, "3601"),
+		true); err != ErrPresignedExpiresTooLong {
+		t.Errorf("expected ErrPresignedExpiresTooLong, got %v", err)
+	}
+}
@@ -17,13 +17,16 @@
 package signature
 
 import (
+	"crypto/md5"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"hash"
 	"io"
 	"net/http"
 
 	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/iam"
 )
 
@@ -34,6 +37,9 @@ type SignVerifyReader struct {
 	Request      *http.Request
 	Reader       io.Reader
 	Sha256Writer hash.Hash
+	// set if signature is verified before the body is read, only the
+	// payload is then checked against x-amz-content-sha256
+	credential *iam.Credential
 }
 
 // Initializes a new signature verify reader.
@@ -64,31 +70,145 @@ func (v *SignVerifyReader) Verify() (iam.Credential, error) {
 	} else {
 		payloadSha256Hex = UnsignedPayload
 	}
-	return DoesSignatureMatchV4(payloadSha256Hex, v.Request, true)
+	if v.credential != nil {
+		if payloadSha256Hex != v.Request.Header.Get("X-Amz-Content-Sha256") {
+			return iam.Credential{}, ErrContentSHA256Mismatch
+		}
+		return *v.credential, nil
+	}
+	credential, err := DoesSignatureMatchV4(payloadSha256Hex, v.Request, true)
+	if err != nil {
+		return credential, err
+	}
+	return credential, helper.CheckVerifiedKey(v.Request.Context(), credential.AccessKeyID)
 }
 
 func (v *SignVerifyReader) Read(b []byte) (int, error) {
 	return v.Reader.Read(b)
 }
 
+// sha256VerifyReader calculates SHA256 of request body as it flows through,
+// and verifies against "x-amz-content-sha256" header once the whole body is
+// read, so the body needn't be buffered for signature checking.
+// MD5 is verified the same way if "Content-Md5" is set.
+type sha256VerifyReader struct {
+	body           io.ReadCloser
+	sha256Writer   hash.Hash // nil if payload is unsigned
+	expectedSha256 string
+	md5Writer      hash.Hash // nil if "Content-Md5" is not set
+	expectedMd5    string
+	length         int64 // -1 if unknown
+	read           int64
+	verified       bool
+	err            error
+}
+
+// Wrap request body for payload verification, body is left untouched if
+// there's nothing to verify. Requests with empty body are verified at once.
+func newSha256VerifyReader(r *http.Request, verifySha256 bool) (body io.ReadCloser, err error) {
+	reader := &sha256VerifyReader{
+		body:   r.Body,
+		length: r.ContentLength,
+	}
+	if verifySha256 && r.Header.Get("X-Amz-Content-Sha256") != UnsignedPayload {
+		reader.sha256Writer = sha256.New()
+		reader.expectedSha256 = r.Header.Get("X-Amz-Content-Sha256")
+	}
+	if r.Header.Get("Content-Md5") != "" {
+		reader.md5Writer = md5.New()
+		reader.expectedMd5 = r.Header.Get("Content-Md5")
+	}
+	if reader.sha256Writer == nil && reader.md5Writer == nil {
+		return r.Body, nil
+	}
+	if reader.length == 0 {
+		return r.Body, reader.verify()
+	}
+	return reader, nil
+}
+
+func (v *sha256VerifyReader) verify() error {
+	v.verified = true
+	if v.md5Writer != nil &&
+		base64.StdEncoding.EncodeToString(v.md5Writer.Sum(nil)) != v.expectedMd5 {
+		return ErrBadDigest
+	}
+	if v.sha256Writer != nil &&
+		hex.EncodeToString(v.sha256Writer.Sum(nil)) != v.expectedSha256 {
+		return ErrContentSHA256Mismatch
+	}
+	return nil
+}
+
+func (v *sha256VerifyReader) Read(p []byte) (n int, err error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err = v.body.Read(p)
+	if v.sha256Writer != nil {
+		v.sha256Writer.Write(p[:n])
+	}
+	if v.md5Writer != nil {
+		v.md5Writer.Write(p[:n])
+	}
+	v.read += int64(n)
+	// readers like io.LimitReader may stop right at Content-Length
+	// without ever seeing EOF
+	if !v.verified && (err == io.EOF || (v.length >= 0 && v.read >= v.length)) {
+		if verifyErr := v.verify(); verifyErr != nil {
+			v.err = verifyErr
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+func (v *sha256VerifyReader) Close() error {
+	return v.body.Close()
+}
+
 func VerifyUpload(r *http.Request) (credential iam.Credential, dataReader io.Reader, err error) {
 	dataReader = r.Body
-	switch GetRequestAuthType(r) {
-	default:
-		// For all unknown auth types return error.
-		err = ErrAccessDenied
+	authType := GetRequestAuthType(r)
+	checkLater := false
+	credential, err = trackFailures(r, GetAccessKeyUnverified(r), func() (c iam.Credential, e error) {
+		switch authType {
+		default:
+			// For all unknown auth types return error.
+			e = ErrAccessDenied
+		case AuthTypeAnonymous:
+			break
+		case AuthTypeSignedV2:
+			c, e = DoesSignatureMatchV2(r)
+		case AuthTypeSignedV4:
+			if IsStreamingUpload(r) {
+				c, dataReader, e = newChunkedReader(r)
+				break
+			}
+			signVerifyReader := newSignVerify(r)
+			dataReader = signVerifyReader
+			claimedSha256 := r.Header.Get("X-Amz-Content-Sha256")
+			if claimedSha256 == "" {
+				// signature could only be checked once the payload is read
+				c, e = getCredentialUnverified(r)
+				checkLater = true
+				break
+			}
+			// signature covers the claimed payload hash, so it's checked before
+			// the body is read, i.e. before "100 Continue" is sent to clients
+			// that wait for it
+			c, e = DoesSignatureMatchV4(claimedSha256, r, true)
+			signVerifyReader.credential = &c
+		case AuthTypePresignedV2:
+			c, e = DoesPresignedSignatureMatchV2(r)
+		case AuthTypePresignedV4:
+			c, e = DoesPresignedSignatureMatchV4(r, true)
+		}
 		return
-	case AuthTypeAnonymous:
-		break
-	case AuthTypeSignedV2:
-		credential, err = DoesSignatureMatchV2(r)
-	case AuthTypeSignedV4:
-		credential, err = getCredentialUnverified(r)
-		dataReader = newSignVerify(r)
-	case AuthTypePresignedV2:
-		credential, err = DoesPresignedSignatureMatchV2(r)
-	case AuthTypePresignedV4:
-		credential, err = DoesPresignedSignatureMatchV4(r, true)
+	})
+	if err == nil && !checkLater {
+		// otherwise checked by SignVerifyReader.Verify
+		err = helper.CheckVerifiedKey(r.Context(), credential.AccessKeyID)
 	}
 	return
 }
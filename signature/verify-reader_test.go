@@ -0,0 +1,169 @@
+package signature
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+func newPayloadRequest(payload, sha256Header string) *http.Request {
+	r, _ := http.NewRequest("PUT", "http://s3.test.com/bucket?cors", strings.NewReader(payload))
+	r.Header.Set("X-Amz-Content-Sha256", sha256Header)
+	return r
+}
+
+func TestSha256VerifyReader(t *testing.T) {
+	payload := strings.Repeat("hehe", 1024)
+	sum := sha256.Sum256([]byte(payload))
+	cases := []struct {
+		header string
+		err    error
+	}{
+		{hex.EncodeToString(sum[:]), nil},
+		{UnsignedPayload, nil},
+		{hex.EncodeToString(sum256([]byte("hehe"))), ErrContentSHA256Mismatch},
+	}
+	for i, c := range cases {
+		r := newPayloadRequest(payload, c.header)
+		body, err := newSha256VerifyReader(r, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+		}
+		if err == nil && string(data) != payload {
+			t.Errorf("case %d: payload altered", i)
+		}
+	}
+}
+
+func TestSha256VerifyReaderAtContentLength(t *testing.T) {
+	payload := "hehe"
+	r := newPayloadRequest(payload, hex.EncodeToString(sum256([]byte("haha"))))
+	body, err := newSha256VerifyReader(r, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stops at limit without reading EOF from body
+	_, err = ioutil.ReadAll(io.LimitReader(body, int64(len(payload))))
+	if err != ErrContentSHA256Mismatch {
+		t.Errorf("payload should be verified once Content-Length is read, got %v", err)
+	}
+}
+
+func TestSha256VerifyReaderEmptyBody(t *testing.T) {
+	r := newPayloadRequest("", hex.EncodeToString(sum256([]byte("hehe"))))
+	if _, err := newSha256VerifyReader(r, true); err != ErrContentSHA256Mismatch {
+		t.Errorf("empty payload should be verified at once, got %v", err)
+	}
+	r = newPayloadRequest("", hex.EncodeToString(sum256(nil)))
+	if _, err := newSha256VerifyReader(r, true); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContentMd5VerifyReader(t *testing.T) {
+	r := newPayloadRequest("hehe", UnsignedPayload)
+	r.Header.Set("Content-Md5", "1B2M2Y8AsgTpgAmY7PhCfg==") // MD5 of empty string
+	body, err := newSha256VerifyReader(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(body); err != ErrBadDigest {
+		t.Errorf("expected BadDigest, got %v", err)
+	}
+}
+
+// countingReader counts bytes read from it
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+// PUT signed with Authorization header, claiming payload hash `claimedSha256`
+func newSignedPutRequest(t *testing.T, payload, claimedSha256 string) (*http.Request,
+	*countingReader) {
+
+	now := time.Now().UTC()
+	region := "cn-bj-1"
+	body := &countingReader{Reader: strings.NewReader(payload)}
+	r, err := http.NewRequest("PUT", "http://s3.test.com/bucket/object", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Amz-Content-Sha256", claimedSha256)
+	r.Header.Set("X-Amz-Date", now.Format(Iso8601Format))
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders, err := getCanonicalHeaders(signedHeaders, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalRequest := getCanonicalRequest(canonicalHeaders, claimedSha256,
+		r.URL.Query().Encode(), r.URL.Path, r.Method, signedHeaders)
+	signature := getSignature(getSigningKey("hehehehe", now, region),
+		getStringToSign(canonicalRequest, now, region))
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=hehehehe/%s/%s/s3/aws4_request,SignedHeaders=%s,Signature=%s",
+		now.Format(YYYYMMDD), region, strings.Join(signedHeaders, ";"), signature))
+	return r, body
+}
+
+func TestVerifyUploadBeforeBody(t *testing.T) {
+	helper.CONFIG.DebugMode = true
+	defer func() { helper.CONFIG.DebugMode = false }()
+
+	payload := strings.Repeat("hehe", 1024)
+	sum := sha256.Sum256([]byte(payload))
+	for _, claimed := range []string{hex.EncodeToString(sum[:]), UnsignedPayload} {
+		r, body := newSignedPutRequest(t, payload, claimed)
+		credential, reader, err := VerifyUpload(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credential.AccessKeyID != "hehehehe" || body.read != 0 {
+			t.Errorf("%s: signature should be verified before reading body, got %+v, %d bytes read",
+				claimed, credential, body.read)
+		}
+		if _, err := ioutil.ReadAll(reader); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.(*SignVerifyReader).Verify(); err != nil {
+			t.Errorf("%s: %v", claimed, err)
+		}
+	}
+
+	r, body := newSignedPutRequest(t, payload, hex.EncodeToString(sum256([]byte("hehe"))))
+	r.Header.Set("Authorization", r.Header.Get("Authorization")+"0")
+	if _, _, err := VerifyUpload(r); !IsSignatureMismatch(err) || body.read != 0 {
+		t.Errorf("bad signature should be rejected before reading body, got %v, %d bytes read",
+			err, body.read)
+	}
+
+	r, _ = newSignedPutRequest(t, payload, hex.EncodeToString(sum256([]byte("hehe"))))
+	_, reader, err := VerifyUpload(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(reader)
+	if _, err := reader.(*SignVerifyReader).Verify(); err != ErrContentSHA256Mismatch {
+		t.Errorf("payload should match the claimed hash, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+// Canned ACL of a bucket applies to objects in it as well: public-read makes
+// all objects readable by everyone, public-read-write makes them writable
+// too, no matter what ACLs of the objects say. Explicit grants of the bucket
+// work the same way as their canned equivalents.
+
+// Whether `credential` could put or remove objects in `bucket`
+func (yig *YigStorage) canWriteObjectsOf(ctx context.Context, bucket meta.Bucket,
+	credential iam.Credential) bool {
+
+	if bucket.ACL.CannedAcl == "public-read-write" {
+		return true
+	}
+	return yig.bucketAllows(ctx, bucket, credential, datatype.ACL_PERM_WRITE)
+}
+
+// Parts of an upload could be put, copied or listed, and the upload itself
+// aborted or completed by its initiator, besides those who could write to
+// `bucket`
+func (yig *YigStorage) canWriteToUpload(ctx context.Context, bucket meta.Bucket, multipart meta.Multipart,
+	credential iam.Credential) bool {
+
+	if credential.UserId != "" && multipart.Metadata.InitiatorId == credential.UserId {
+		return true
+	}
+	return yig.canWriteObjectsOf(ctx, bucket, credential)
+}
+
+// Whether `credential` could list objects and uploads of `bucket`
+func (yig *YigStorage) canListObjectsOf(ctx context.Context, bucket meta.Bucket,
+	credential iam.Credential) bool {
+
+	switch bucket.ACL.CannedAcl {
+	case "public-read", "public-read-write":
+		return true
+	case "authenticated-read":
+		if credential.UserId != "" {
+			return true
+		}
+	}
+	return yig.bucketAllows(ctx, bucket, credential, datatype.ACL_PERM_READ)
+}
+
+// Whether `credential` could read `object` in `bucket`, anonymous requests
+// have empty UserId
+func (yig *YigStorage) canReadObject(ctx context.Context, bucket meta.Bucket, object *meta.Object,
+	credential iam.Credential) bool {
+
+	switch bucket.ACL.CannedAcl {
+	case "public-read", "public-read-write":
+		return true
+	case "":
+		if yig.grantsAllow(ctx, bucket.ACL.Grants, credential, datatype.ACL_PERM_READ) {
+			return true
+		}
+	}
+	if credential.UserId != "" && object.OwnerId == credential.UserId {
+		return true
+	}
+	switch object.ACL.CannedAcl {
+	case "public-read", "public-read-write":
+		return true
+	case "authenticated-read":
+		return credential.UserId != ""
+	case "bucket-owner-read", "bucket-owner-full-control":
+		return credential.UserId != "" && bucket.OwnerId == credential.UserId
+	case "":
+		return yig.grantsAllow(ctx, object.ACL.Grants, credential, datatype.ACL_PERM_READ)
+	}
+	return false
+}
+
+// The owner of `bucket` has all permissions of it, others only have those
+// granted explicitly
+func (yig *YigStorage) bucketAllows(ctx context.Context, bucket meta.Bucket, credential iam.Credential,
+	permission string) bool {
+
+	if credential.UserId != "" && bucket.OwnerId == credential.UserId {
+		return true
+	}
+	return bucket.ACL.CannedAcl == "" &&
+		yig.grantsAllow(ctx, bucket.ACL.Grants, credential, permission)
+}
+
+// Evaluate explicit grants of a bucket or an object, used when no canned ACL
+// is set
+func (yig *YigStorage) grantsAllow(ctx context.Context, grants []datatype.Grant, credential iam.Credential,
+	permission string) bool {
+
+	if len(grants) == 0 {
+		return false
+	}
+	var canonicalUserId string
+	if credential.UserId != "" {
+		var err error
+		canonicalUserId, err = iam.GetCanonicalUserId(credential.UserId)
+		if err != nil {
+			helper.LogWithContext(ctx, "Failed to get canonical user id for %s with error %v",
+				credential.UserId, err)
+			return false
+		}
+	}
+	return datatype.GrantsAllow(grants, canonicalUserId, permission)
+}
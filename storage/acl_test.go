@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/meta/types"
+)
+
+func TestObjectAccess(t *testing.T) {
+	// objects of bob in bucket of alice, requested by each of them
+	requesters := []iam.Credential{
+		{},                // anonymous
+		{UserId: "carol"}, // authenticated
+		{UserId: "alice"}, // bucket owner
+		{UserId: "bob"},   // object owner
+	}
+	grant := func(id, uri, permission string) []datatype.Grant {
+		g := datatype.Grant{Permission: permission}
+		g.Grantee.ID, g.Grantee.URI = id, uri
+		g.Grantee.XsiType = datatype.ACL_TYPE_CANON_USER
+		if uri != "" {
+			g.Grantee.XsiType = datatype.ACL_TYPE_GROUP
+		}
+		return []datatype.Grant{g}
+	}
+	cases := []struct {
+		bucketAcl, objectAcl string
+		read, write          [4]bool
+		bucketGrants         []datatype.Grant
+	}{
+		{"private", "private", [4]bool{false, false, false, true}, [4]bool{false, false, true, false}, nil},
+		{"private", "public-read", [4]bool{true, true, true, true}, [4]bool{false, false, true, false}, nil},
+		{"private", "authenticated-read", [4]bool{false, true, true, true}, [4]bool{false, false, true, false}, nil},
+		{"private", "bucket-owner-read", [4]bool{false, false, true, true}, [4]bool{false, false, true, false}, nil},
+		{"private", "", [4]bool{false, false, false, true}, [4]bool{false, false, true, false}, nil},
+		{"public-read", "private", [4]bool{true, true, true, true}, [4]bool{false, false, true, false}, nil},
+		{"public-read", "bucket-owner-read", [4]bool{true, true, true, true}, [4]bool{false, false, true, false}, nil},
+		{"public-read", "", [4]bool{true, true, true, true}, [4]bool{false, false, true, false}, nil},
+		{"public-read-write", "private", [4]bool{true, true, true, true}, [4]bool{true, true, true, true}, nil},
+		{"public-read-write", "", [4]bool{true, true, true, true}, [4]bool{true, true, true, true}, nil},
+		{"authenticated-read", "private", [4]bool{false, false, false, true}, [4]bool{false, false, true, false}, nil},
+		// explicit grants of buckets work as their canned equivalents
+		{"", "private", [4]bool{false, true, false, true}, [4]bool{false, false, true, false},
+			grant("carol", "", datatype.ACL_PERM_READ)},
+		{"", "private", [4]bool{true, true, true, true}, [4]bool{false, false, true, false},
+			grant("", datatype.ACL_GROUP_TYPE_ALL_USERS, datatype.ACL_PERM_READ)},
+		{"", "private", [4]bool{false, false, false, true}, [4]bool{true, true, true, true},
+			grant("", datatype.ACL_GROUP_TYPE_ALL_USERS, datatype.ACL_PERM_WRITE)},
+		{"", "", [4]bool{false, true, false, true}, [4]bool{false, true, true, false},
+			grant("carol", "", datatype.ACL_PERM_FULL_CONTROL)},
+	}
+	yig := &YigStorage{}
+	for _, c := range cases {
+		bucket := types.Bucket{Name: "bucket", OwnerId: "alice",
+			ACL: datatype.Acl{CannedAcl: c.bucketAcl, Grants: c.bucketGrants}}
+		object := &types.Object{Name: "object", BucketName: "bucket", OwnerId: "bob",
+			ACL: datatype.Acl{CannedAcl: c.objectAcl}}
+		for i, credential := range requesters {
+			if read := yig.canReadObject(context.Background(), bucket, object,
+				credential); read != c.read[i] {
+				t.Errorf("bucket %s, object %s, %q: expected read %v, got %v",
+					c.bucketAcl, c.objectAcl, credential.UserId, c.read[i], read)
+			}
+			if write := yig.canWriteObjectsOf(context.Background(), bucket, credential); write != c.write[i] {
+				t.Errorf("bucket %s, object %s, %q: expected write %v, got %v",
+					c.bucketAcl, c.objectAcl, credential.UserId, c.write[i], write)
+			}
+		}
+	}
+}
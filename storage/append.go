@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	meta "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/signature"
+)
+
+// ETag of an appendable object after data of MD5 `partEtag` is appended to
+// the object of `etag`. MD5 of the whole object could not be carried on
+// without reading back former data, so ETags of appends are chained instead
+func appendedEtag(etag, partEtag string) string {
+	if etag == "" {
+		return partEtag
+	}
+	sum := md5.Sum([]byte(etag + partEtag))
+	return hex.EncodeToString(sum[:])
+}
+
+// Append `data` to object `objectName` at `position`, which should be the
+// current size of the object, the object is created when appended at
+// position 0. Each append is saved in Ceph as a part of the object, so it's
+// read like a multipart uploaded one.
+// Appends at the same position are raced by compare-and-set of the object
+// size, while concurrent creation of an object is last-writer-wins as PUT
+func (yig *YigStorage) AppendObject(ctx context.Context, bucketName string, objectName string,
+	credential iam.Credential, position int64, size int64, data io.Reader,
+	metadata map[string]string, acl datatype.Acl,
+	sseRequest datatype.SseRequest) (result datatype.AppendObjectResult, err error) {
+
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	if err != nil {
+		return
+	}
+
+	if !yig.canWriteObjectsOf(ctx, bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+	// versions and encrypted parts of appendable objects are not supported yet
+	if bucket.Versioning != "Disabled" {
+		return result, ErrNotImplemented
+	}
+	if sseRequest.Type != "" || bucket.Encryption.SseRequest().Type != "" {
+		return result, ErrNotImplemented
+	}
+	if bucket.MaxObjectSize > 0 && position+size > bucket.MaxObjectSize {
+		return result, ErrEntityTooLarge
+	}
+
+	object, err := yig.MetaStorage.GetObject(bucketName, objectName, false)
+	if err == ErrNoSuchKey {
+		object, err = nil, nil
+	}
+	if err != nil {
+		return
+	}
+	var currentSize int64
+	if object != nil {
+		if !object.Appendable {
+			return result, ErrObjectNotAppendable
+		}
+		currentSize = object.Size
+	}
+	if position != currentSize {
+		return result, ErrPositionNotEqualToLength
+	}
+
+	limiter := yig.getBucketLimiter(bucket)
+	err = limiter.allowWrite()
+	if err != nil {
+		return
+	}
+
+	var cephCluster *CephStorage
+	var poolName string
+	if object == nil {
+		// size of the whole object is unknown, appends go to the pool for
+		// big files
+		cephCluster, poolName = yig.PickOneClusterAndPool(bucketName, objectName, -1,
+			datatype.STORAGE_CLASS_STANDARD)
+	} else {
+		cephCluster, err = yig.GetClusterByFsName(object.Location)
+		if err != nil {
+			return
+		}
+		poolName = object.Pool
+	}
+
+	md5Writer := md5.New()
+	limitedDataReader := limiter.limitWrite(ctx, io.LimitReader(data, size))
+	dataReader := io.TeeReader(limitedDataReader, md5Writer)
+	oid := cephCluster.GetUniqUploadName()
+	bytesWritten, err := cephCluster.Put(poolName, oid, dataReader)
+	maybeObjectToRecycle := objectToRecycle{
+		location: cephCluster.Name,
+		pool:     poolName,
+		objectId: oid,
+	}
+	if err != nil {
+		return
+	}
+	if bytesWritten < size {
+		RecycleQueue <- maybeObjectToRecycle
+		return result, ErrIncompleteBody
+	}
+
+	calculatedMd5 := hex.EncodeToString(md5Writer.Sum(nil))
+	if userMd5, ok := metadata["md5Sum"]; ok {
+		if userMd5 != "" && userMd5 != calculatedMd5 {
+			RecycleQueue <- maybeObjectToRecycle
+			return result, ErrBadDigest
+		}
+	}
+
+	if signVerifyReader, ok := data.(*signature.SignVerifyReader); ok {
+		credential, err = signVerifyReader.Verify()
+		if err != nil {
+			RecycleQueue <- maybeObjectToRecycle
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	part := &meta.Part{
+		Size:                 bytesWritten,
+		ObjectId:             oid,
+		Offset:               position,
+		Etag:                 calculatedMd5,
+		LastModified:         now.Format(meta.CREATE_TIME_LAYOUT),
+		InitializationVector: []byte{},
+	}
+	if object == nil {
+		var attrs map[string]string
+		attrs, err = getCustomedAttrs(metadata)
+		if err != nil {
+			RecycleQueue <- maybeObjectToRecycle
+			return
+		}
+		part.PartNumber = 1
+		object = &meta.Object{
+			Name:             objectName,
+			BucketName:       bucketName,
+			Location:         cephCluster.Name,
+			Pool:             poolName,
+			OwnerId:          credential.UserId,
+			Size:             bytesWritten,
+			LastModifiedTime: now,
+			Etag:             appendedEtag("", calculatedMd5),
+			ContentType:      metadata["Content-Type"],
+			ACL:              acl,
+			NullVersion:      true,
+			Parts:            map[int]*meta.Part{1: part},
+			CustomAttributes: attrs,
+			Appendable:       true,
+			StorageClass:     datatype.STORAGE_CLASS_STANDARD,
+		}
+		err = yig.MetaStorage.PutObjectEntries(ctx, object, nil)
+	} else {
+		part.PartNumber = len(object.Parts) + 1
+		object.Size += bytesWritten
+		object.Etag = appendedEtag(object.Etag, calculatedMd5)
+		err = yig.MetaStorage.AppendObject(object, part, now)
+	}
+	if err != nil {
+		RecycleQueue <- maybeObjectToRecycle
+		return
+	}
+	yig.updateUsage(bucketName, bytesWritten)
+
+	result.Md5 = object.Etag
+	result.NextPosition = position + bytesWritten
+	result.LastModified = now
+	return result, nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	. "github.com/journeymidnight/yig/error"
+	meta "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/redis"
+	"golang.org/x/time/rate"
+)
+
+// Per bucket limits of request rate and bandwidth, so tenants sharing
+// a cluster could not starve each other. Limits are stored with bucket and
+// token buckets are kept in memory of every yig instance, so a bucket gets
+// its limits on each instance.
+
+// bytes are taken from bandwidth limiters in chunks of this size,
+// so throughput is smoothed
+const BANDWIDTH_CHUNK_SIZE = 64 << 10 // 64 KB
+
+// Token bucket, up to one second of tokens could be accumulated. Bytes
+// are taken in chunks, so the burst of a bandwidth limiter is at least
+// a chunk
+func newRateLimiter(limit int64, burst int) *rate.Limiter {
+	if int64(burst) < limit {
+		burst = int(limit)
+	}
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+type bucketLimiter struct {
+	limits        meta.BucketRateLimit
+	readRequests  *rate.Limiter // nil if not limited
+	writeRequests *rate.Limiter
+	readBytes     *rate.Limiter
+	writeBytes    *rate.Limiter
+}
+
+func newBucketLimiter(limits meta.BucketRateLimit) *bucketLimiter {
+	maybeLimiter := func(limit int64, burst int) *rate.Limiter {
+		if limit <= 0 {
+			return nil
+		}
+		return newRateLimiter(limit, burst)
+	}
+	return &bucketLimiter{
+		limits:        limits,
+		readRequests:  maybeLimiter(limits.ReadRPSLimit, 1),
+		writeRequests: maybeLimiter(limits.WriteRPSLimit, 1),
+		readBytes:     maybeLimiter(limits.ReadBandwidthBps, BANDWIDTH_CHUNK_SIZE),
+		writeBytes:    maybeLimiter(limits.WriteBandwidthBps, BANDWIDTH_CHUNK_SIZE),
+	}
+}
+
+// Get limiter of bucket, limiter is rebuilt if limits of bucket have
+// changed. Returns nil if bucket is not limited.
+func (yig *YigStorage) getBucketLimiter(bucket meta.Bucket) *bucketLimiter {
+	if !bucket.IsLimited() {
+		yig.bucketLimiters.Delete(bucket.Name)
+		return nil
+	}
+	if value, ok := yig.bucketLimiters.Load(bucket.Name); ok {
+		limiter := value.(*bucketLimiter)
+		if limiter.limits == bucket.BucketRateLimit {
+			return limiter
+		}
+	}
+	limiter := newBucketLimiter(bucket.BucketRateLimit)
+	yig.bucketLimiters.Store(bucket.Name, limiter)
+	return limiter
+}
+
+func allowRequest(limiter *rate.Limiter) error {
+	if limiter != nil && !limiter.Allow() {
+		return ErrSlowDown
+	}
+	return nil
+}
+
+func (l *bucketLimiter) allowRead() error {
+	if l == nil {
+		return nil
+	}
+	return allowRequest(l.readRequests)
+}
+
+func (l *bucketLimiter) allowWrite() error {
+	if l == nil {
+		return nil
+	}
+	return allowRequest(l.writeRequests)
+}
+
+// Waiting for bandwidth stops when `ctx` is done, e.g. the client has gone
+type throttledReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *throttledReader) Read(p []byte) (n int, err error) {
+	if len(p) > BANDWIDTH_CHUNK_SIZE {
+		p = p[:BANDWIDTH_CHUNK_SIZE]
+	}
+	n, err = r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return
+}
+
+type throttledWriter struct {
+	ctx     context.Context
+	writer  io.Writer
+	limiter *rate.Limiter
+}
+
+func (w *throttledWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > BANDWIDTH_CHUNK_SIZE {
+			chunk = chunk[:BANDWIDTH_CHUNK_SIZE]
+		}
+		if err := w.limiter.WaitN(w.ctx, len(chunk)); err != nil {
+			return n, err
+		}
+		written, err := w.writer.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Limit bandwidth of data written into Ceph
+func (l *bucketLimiter) limitWrite(ctx context.Context, reader io.Reader) io.Reader {
+	if l == nil || l.writeBytes == nil {
+		return reader
+	}
+	return &throttledReader{ctx: ctx, reader: reader, limiter: l.writeBytes}
+}
+
+// Limit bandwidth of data read from Ceph
+func (l *bucketLimiter) limitRead(ctx context.Context, writer io.Writer) io.Writer {
+	if l == nil || l.readBytes == nil {
+		return writer
+	}
+	return &throttledWriter{ctx: ctx, writer: writer, limiter: l.readBytes}
+}
+
+// Limits take effect immediately on this instance, and on other instances
+// once their cached bucket is invalidated
+func (yig *YigStorage) SetBucketRateLimit(bucketName string, limits meta.BucketRateLimit) error {
+	if limits.ReadRPSLimit < 0 || limits.WriteRPSLimit < 0 ||
+		limits.ReadBandwidthBps < 0 || limits.WriteBandwidthBps < 0 {
+		return ErrInvalidRateLimitRequest
+	}
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
+	if err != nil {
+		return err
+	}
+	bucket.BucketRateLimit = limits
+	err = yig.MetaStorage.Client.PutBucket(bucket)
+	if err != nil {
+		return err
+	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	yig.bucketLimiters.Delete(bucketName)
+	return nil
+}
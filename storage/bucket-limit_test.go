@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/meta/types"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(2, 1)
+	if !l.AllowN(now, 1) || !l.AllowN(now, 1) {
+		t.Error("burst should be allowed")
+	}
+	if l.AllowN(now, 1) {
+		t.Error("should not be allowed before refill")
+	}
+	if !l.AllowN(now.Add(500*time.Millisecond), 1) {
+		t.Error("should be allowed after refill")
+	}
+
+	if l = newRateLimiter(100, BANDWIDTH_CHUNK_SIZE); l.Burst() != BANDWIDTH_CHUNK_SIZE {
+		t.Errorf("a chunk should be taken at once, got burst %d", l.Burst())
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 2*BANDWIDTH_CHUNK_SIZE)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	limiter := newBucketLimiter(types.BucketRateLimit{WriteBandwidthBps: 1024})
+	reader := limiter.limitWrite(ctx, bytes.NewReader(data))
+
+	start := time.Now()
+	_, err := ioutil.ReadAll(reader)
+	if err == nil {
+		t.Error("reading should stop when context is done")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("reading should not wait beyond deadline of context")
+	}
+}
+
+func TestBucketRateLimit(t *testing.T) {
+	c := newFakeClient()
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "alice"})
+	yig := newTestStorage(c)
+	yig.MetaStorage.Cache = newFakeCache()
+
+	bucket, _ := yig.MetaStorage.GetBucket("bucket", true)
+	if yig.getBucketLimiter(bucket) != nil {
+		t.Error("bucket without limits should not get a limiter")
+	}
+
+	err := yig.SetBucketRateLimit("bucket", types.BucketRateLimit{WriteRPSLimit: -1})
+	if err != ErrInvalidRateLimitRequest {
+		t.Errorf("negative limits should be rejected, got %v", err)
+	}
+	err = yig.SetBucketRateLimit("bucket", types.BucketRateLimit{WriteRPSLimit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, _ = yig.MetaStorage.GetBucket("bucket", true)
+	limiter := yig.getBucketLimiter(bucket)
+	if limiter.allowRead() != nil {
+		t.Error("reads are not limited")
+	}
+	if limiter.allowWrite() != nil {
+		t.Error("first write should be allowed")
+	}
+	if yig.getBucketLimiter(bucket).allowWrite() != ErrSlowDown {
+		t.Error("limiter should be shared by requests of the bucket")
+	}
+
+	// updated without restart
+	err = yig.SetBucketRateLimit("bucket", types.BucketRateLimit{WriteRPSLimit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, _ = yig.MetaStorage.GetBucket("bucket", true)
+	if yig.getBucketLimiter(bucket).allowWrite() != nil {
+		t.Error("limiter should be rebuilt after limits change")
+	}
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/journeymidnight/yig/api/datatype"
@@ -13,7 +15,7 @@ import (
 	"github.com/journeymidnight/yig/redis"
 )
 
-func (yig *YigStorage) MakeBucket(bucketName string, acl datatype.Acl,
+func (yig *YigStorage) MakeBucket(ctx context.Context, bucketName string, acl datatype.Acl,
 	credential iam.Credential) error {
 
 	now := time.Now().UTC()
@@ -26,13 +28,13 @@ func (yig *YigStorage) MakeBucket(bucketName string, acl datatype.Acl,
 	}
 	processed, err := yig.MetaStorage.Client.CheckAndPutBucket(bucket)
 	if err != nil {
-		yig.Logger.Println(5, "Error making hbase checkandput: ", err)
+		helper.LogWithContext(ctx, "Error making hbase checkandput: %v", err)
 		return err
 	}
 	if !processed { // bucket already exists, return accurate message
 		bucket, err := yig.MetaStorage.GetBucket(bucketName, false)
 		if err != nil {
-			yig.Logger.Println(5, "Error get bucket: ", bucketName, ", with error", err)
+			helper.LogWithContext(ctx, "Error get bucket: %s, with error %v", bucketName, err)
 			return ErrBucketAlreadyExists
 		}
 		if bucket.OwnerId == credential.UserId {
@@ -43,36 +45,60 @@ func (yig *YigStorage) MakeBucket(bucketName string, acl datatype.Acl,
 	}
 	err = yig.MetaStorage.AddBucketForUser(bucketName, credential.UserId)
 	if err != nil { // roll back bucket table, i.e. remove inserted bucket
-		yig.Logger.Println(5, "Error AddBucketForUser: ", err)
-		err = yig.MetaStorage.Client.DeleteBucket(bucket)
-		if err != nil {
-			yig.Logger.Println(5, "Error deleting: ", err)
-			yig.Logger.Println(5, "Leaving junk bucket unremoved: ", bucketName)
-			return err
-		}
+		helper.LogWithContext(ctx, "Error AddBucketForUser: %v", err)
+		yig.rollbackMakeBucket(ctx, bucket)
+		return err
 	}
-	if err == nil {
-		yig.MetaStorage.Cache.Remove(redis.UserTable, credential.UserId)
+	// clear what might be cached for a deleted bucket of the same name
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	yig.MetaStorage.Cache.Remove(redis.UserTable, credential.UserId)
+	return nil
+}
+
+// Remove the bucket inserted by MakeBucket, but only if it is still ours,
+// it might have been deleted and created again by others in between
+func (yig *YigStorage) rollbackMakeBucket(ctx context.Context, bucket meta.Bucket) {
+	current, err := yig.MetaStorage.Client.GetBucket(bucket.Name)
+	if err != nil {
+		helper.LogWithContext(ctx, "Error get bucket for rollback: %s %v", bucket.Name, err)
+		return
+	}
+	if current.OwnerId != bucket.OwnerId ||
+		current.CreateTime.Unix() != bucket.CreateTime.Unix() {
+		helper.LogWithContext(ctx, "Bucket recreated by others, skip rollback: %s", bucket.Name)
+		return
+	}
+	err = yig.MetaStorage.Client.DeleteBucket(bucket)
+	if err != nil {
+		helper.LogWithContext(ctx, "Error deleting: %v, leaving junk bucket unremoved: %s",
+			err, bucket.Name)
 	}
-	return err
 }
 
-func (yig *YigStorage) SetBucketAcl(bucketName string, policy datatype.AccessControlPolicy, acl datatype.Acl,
+func (yig *YigStorage) SetBucketAcl(ctx context.Context, bucketName string, policy datatype.AccessControlPolicy, acl datatype.Acl,
 	credential iam.Credential) error {
 
-	if acl.CannedAcl == "" {
+	// either canned or explicit grants from headers, otherwise from policy
+	if acl.CannedAcl == "" && len(acl.Grants) == 0 {
 		newCannedAcl, err := datatype.GetCannedAclFromPolicy(policy)
-		if err != nil {
+		if err == ErrUnsupportedAcl {
+			// not expressible as a canned ACL, store the grants as they are
+			err = datatype.IsValidGrants(policy.AccessControlList)
+			if err != nil {
+				return err
+			}
+			newCannedAcl = datatype.Acl{Grants: policy.AccessControlList}
+		} else if err != nil {
 			return err
 		}
 		acl = newCannedAcl
 	}
 
-	bucket, err := yig.MetaStorage.GetBucket(bucketName, false)
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
 	if err != nil {
 		return err
 	}
-	if bucket.OwnerId != credential.UserId {
+	if !yig.bucketAllows(ctx, bucket, credential, datatype.ACL_PERM_WRITE_ACP) {
 		return ErrBucketAccessForbidden
 	}
 	bucket.ACL = acl
@@ -86,10 +112,10 @@ func (yig *YigStorage) SetBucketAcl(bucketName string, policy datatype.AccessCon
 	return nil
 }
 
-func (yig *YigStorage) SetBucketLc(bucketName string, lc datatype.Lc,
+func (yig *YigStorage) SetBucketLc(ctx context.Context, bucketName string, lc datatype.Lc,
 	credential iam.Credential) error {
 	helper.Logger.Println(10, "enter SetBucketLc")
-	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
 	if err != nil {
 		return err
 	}
@@ -107,13 +133,13 @@ func (yig *YigStorage) SetBucketLc(bucketName string, lc datatype.Lc,
 
 	err = yig.MetaStorage.PutBucketToLifeCycle(bucket)
 	if err != nil {
-		yig.Logger.Println(5, "Error Put bucket to LC table hbase: ", err)
+		helper.LogWithContext(ctx, "Error Put bucket to LC table hbase: %v", err)
 		return err
 	}
 	return nil
 }
 
-func (yig *YigStorage) GetBucketLc(bucketName string, credential iam.Credential) (lc datatype.Lc,
+func (yig *YigStorage) GetBucketLc(ctx context.Context, bucketName string, credential iam.Credential) (lc datatype.Lc,
 	err error) {
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
 	if err != nil {
@@ -130,8 +156,8 @@ func (yig *YigStorage) GetBucketLc(bucketName string, credential iam.Credential)
 	return bucket.LC, nil
 }
 
-func (yig *YigStorage) DelBucketLc(bucketName string, credential iam.Credential) error {
-	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+func (yig *YigStorage) DelBucketLc(ctx context.Context, bucketName string, credential iam.Credential) error {
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
 	if err != nil {
 		return err
 	}
@@ -148,16 +174,16 @@ func (yig *YigStorage) DelBucketLc(bucketName string, credential iam.Credential)
 	}
 	err = yig.MetaStorage.RemoveBucketFromLifeCycle(bucket)
 	if err != nil {
-		yig.Logger.Println(5, "Error Remove bucket From LC table hbase: ", err)
+		helper.LogWithContext(ctx, "Error Remove bucket From LC table hbase: %v", err)
 		return err
 	}
 	return nil
 }
 
-func (yig *YigStorage) SetBucketCors(bucketName string, cors datatype.Cors,
+func (yig *YigStorage) SetBucketCors(ctx context.Context, bucketName string, cors datatype.Cors,
 	credential iam.Credential) error {
 
-	bucket, err := yig.MetaStorage.GetBucket(bucketName, false)
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
 	if err != nil {
 		return err
 	}
@@ -175,8 +201,8 @@ func (yig *YigStorage) SetBucketCors(bucketName string, cors datatype.Cors,
 	return nil
 }
 
-func (yig *YigStorage) DeleteBucketCors(bucketName string, credential iam.Credential) error {
-	bucket, err := yig.MetaStorage.GetBucket(bucketName, false)
+func (yig *YigStorage) DeleteBucketCors(ctx context.Context, bucketName string, credential iam.Credential) error {
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
 	if err != nil {
 		return err
 	}
@@ -194,7 +220,7 @@ func (yig *YigStorage) DeleteBucketCors(bucketName string, credential iam.Creden
 	return nil
 }
 
-func (yig *YigStorage) GetBucketCors(bucketName string,
+func (yig *YigStorage) GetBucketCors(ctx context.Context, bucketName string,
 	credential iam.Credential) (cors datatype.Cors, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
@@ -212,16 +238,145 @@ func (yig *YigStorage) GetBucketCors(bucketName string,
 	return bucket.CORS, nil
 }
 
-func (yig *YigStorage) SetBucketVersioning(bucketName string, versioning datatype.Versioning,
+func (yig *YigStorage) SetBucketEncryption(ctx context.Context, bucketName string, encryption datatype.Encryption,
 	credential iam.Credential) error {
 
-	bucket, err := yig.MetaStorage.GetBucket(bucketName, false)
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
+	if err != nil {
+		return err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return ErrBucketAccessForbidden
+	}
+	bucket.Encryption = encryption
+	err = yig.MetaStorage.Client.PutBucket(bucket)
+	if err != nil {
+		return err
+	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	return nil
+}
+
+func (yig *YigStorage) DeleteBucketEncryption(ctx context.Context, bucketName string, credential iam.Credential) error {
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
+	if err != nil {
+		return err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return ErrBucketAccessForbidden
+	}
+	bucket.Encryption = datatype.Encryption{}
+	err = yig.MetaStorage.Client.PutBucket(bucket)
+	if err != nil {
+		return err
+	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	return nil
+}
+
+func (yig *YigStorage) GetBucketEncryption(ctx context.Context, bucketName string,
+	credential iam.Credential) (encryption datatype.Encryption, err error) {
+
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	if err != nil {
+		return encryption, err
+	}
+	if bucket.OwnerId != credential.UserId {
+		err = ErrBucketAccessForbidden
+		return
+	}
+	if len(bucket.Encryption.Rules) == 0 {
+		err = ErrNoSuchBucketEncryption
+		return
+	}
+	encryption = bucket.Encryption
+	encryption.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	return encryption, nil
+}
+
+func (yig *YigStorage) SetBucketRequestPayment(ctx context.Context, bucketName string,
+	payment datatype.RequestPaymentConfiguration, credential iam.Credential) error {
+
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
+	if err != nil {
+		return err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return ErrBucketAccessForbidden
+	}
+	bucket.RequestPayer = payment.Payer
+	err = yig.MetaStorage.Client.PutBucket(bucket)
+	if err != nil {
+		return err
+	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	return nil
+}
+
+func (yig *YigStorage) GetBucketRequestPayment(ctx context.Context, bucketName string,
+	credential iam.Credential) (payment datatype.RequestPaymentConfiguration, err error) {
+
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	if err != nil {
+		return
+	}
+	if bucket.OwnerId != credential.UserId {
+		err = ErrBucketAccessForbidden
+		return
+	}
+	payment.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	payment.Payer = helper.Ternary(bucket.RequestPayer == "",
+		datatype.PAYER_BUCKET_OWNER, bucket.RequestPayer).(string)
+	return payment, nil
+}
+
+// Called by admin, objects larger than `maxObjectSize` are rejected,
+// 0 means no limit
+func (yig *YigStorage) SetBucketMaxObjectSize(bucketName string, maxObjectSize int64) error {
+	if maxObjectSize < 0 {
+		return ErrInvalidMaxObjectSize
+	}
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
+	if err != nil {
+		return err
+	}
+	bucket.MaxObjectSize = maxObjectSize
+	err = yig.MetaStorage.Client.PutBucket(bucket)
+	if err != nil {
+		return err
+	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	return nil
+}
+
+// Called by admin, see GzipVariants of meta.Bucket
+func (yig *YigStorage) SetBucketGzipVariants(bucketName string, enabled bool) error {
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
+	if err != nil {
+		return err
+	}
+	bucket.GzipVariants = enabled
+	err = yig.MetaStorage.Client.PutBucket(bucket)
+	if err != nil {
+		return err
+	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	return nil
+}
+
+func (yig *YigStorage) SetBucketVersioning(ctx context.Context, bucketName string, versioning datatype.Versioning,
+	credential iam.Credential) error {
+
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
 	if err != nil {
 		return err
 	}
 	if bucket.OwnerId != credential.UserId {
 		return ErrBucketAccessForbidden
 	}
+	if bucket.ObjectLock.IsEnabled() && versioning.Status != "Enabled" {
+		return ErrInvalidBucketState
+	}
 	bucket.Versioning = versioning.Status
 	err = yig.MetaStorage.Client.PutBucket(bucket)
 	if err != nil {
@@ -233,30 +388,44 @@ func (yig *YigStorage) SetBucketVersioning(bucketName string, versioning datatyp
 	return nil
 }
 
-func (yig *YigStorage) GetBucketVersioning(bucketName string, credential iam.Credential) (
+func (yig *YigStorage) GetBucketVersioning(ctx context.Context, bucketName string, credential iam.Credential) (
 	versioning datatype.Versioning, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, false)
 	if err != nil {
 		return versioning, err
 	}
+	if bucket.OwnerId != credential.UserId {
+		return versioning, ErrBucketAccessForbidden
+	}
+	versioning.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
 	versioning.Status = helper.Ternary(bucket.Versioning == "Disabled",
 		"", bucket.Versioning).(string)
 	return
 }
 
-func (yig *YigStorage) GetBucketAcl(bucketName string, credential iam.Credential) (
+func (yig *YigStorage) GetBucketAcl(ctx context.Context, bucketName string, credential iam.Credential) (
 	policy datatype.AccessControlPolicy, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, false)
 	if err != nil {
 		return policy, err
 	}
-	if bucket.OwnerId != credential.UserId {
+	if !yig.bucketAllows(ctx, bucket, credential, datatype.ACL_PERM_READ_ACP) {
 		err = ErrBucketAccessForbidden
 		return
 	}
 	owner := datatype.Owner{ID: credential.UserId, DisplayName: credential.DisplayName}
+	if bucket.OwnerId != credential.UserId {
+		ownerCred, err := iam.GetCredentialByUserId(bucket.OwnerId)
+		if err != nil {
+			return policy, err
+		}
+		owner = datatype.Owner{ID: ownerCred.UserId, DisplayName: ownerCred.DisplayName}
+	}
+	if bucket.ACL.CannedAcl == "" {
+		return datatype.CreatePolicyFromGrants(owner, bucket.ACL), nil
+	}
 	bucketOwner := datatype.Owner{}
 	policy, err = datatype.CreatePolicyFromCanned(owner, bucketOwner, bucket.ACL)
 	if err != nil {
@@ -267,45 +436,118 @@ func (yig *YigStorage) GetBucketAcl(bucketName string, credential iam.Credential
 }
 
 // For INTERNAL USE ONLY
-func (yig *YigStorage) GetBucket(bucketName string) (meta.Bucket, error) {
+func (yig *YigStorage) GetBucket(ctx context.Context, bucketName string) (meta.Bucket, error) {
 	return yig.MetaStorage.GetBucket(bucketName, true)
 }
 
-func (yig *YigStorage) GetBucketInfo(bucketName string,
-	credential iam.Credential) (bucket meta.Bucket, err error) {
+// Seconds bucket statistics are cached in Redis, scanning a bucket is costly
+const BUCKET_STATS_CACHE_TTL = 60
 
-	bucket, err = yig.MetaStorage.GetBucket(bucketName, true)
+// Redis key of cached statistics of a bucket, ":" is not allowed in bucket
+// names so it never collides with cached buckets
+func bucketStatsCacheKey(bucketName string) string {
+	return "stats:" + bucketName
+}
+
+// Counts of objects, versions and uploads of a bucket, scanned in parallel
+func (yig *YigStorage) GetBucketStats(bucketName string) (stats meta.BucketStats, err error) {
+	_, err = yig.MetaStorage.GetBucket(bucketName, true)
 	if err != nil {
 		return
 	}
-	if bucket.OwnerId != credential.UserId {
-		switch bucket.ACL.CannedAcl {
-		case "public-read", "public-read-write", "authenticated-read":
-			break
-		default:
-			err = ErrBucketAccessForbidden
-			return
+	useRedis := RedisEnabled() && redis.Available()
+	if useRedis {
+		cached, err := redis.Get(redis.BucketTable, bucketStatsCacheKey(bucketName),
+			func(b []byte) (interface{}, error) {
+				var stats meta.BucketStats
+				err := helper.MsgPackUnMarshal(b, &stats)
+				return stats, err
+			})
+		if err == nil {
+			return cached.(meta.BucketStats), nil
 		}
 	}
-	return
+
+	var uploads int64
+	var uploadsErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		uploads, uploadsErr = yig.MetaStorage.Client.CountMultipartUploads(bucketName)
+	}()
+	stats, err = yig.MetaStorage.Client.CountObjects(bucketName)
+	wg.Wait()
+	if err != nil {
+		return
+	}
+	if uploadsErr != nil {
+		return stats, uploadsErr
+	}
+	stats.MultipartUploadCount = uploads
+
+	if useRedis {
+		err := redis.SetWithExpire(redis.BucketTable, bucketStatsCacheKey(bucketName),
+			stats, BUCKET_STATS_CACHE_TTL)
+		if err != nil {
+			helper.Logger.Println(5, "Failed to cache stats of bucket", bucketName, err)
+		}
+	}
+	return stats, nil
 }
 
-func (yig *YigStorage) ListBuckets(credential iam.Credential) (buckets []meta.Bucket, err error) {
-	bucketNames, err := yig.MetaStorage.GetUserBuckets(credential.UserId, true)
+func (yig *YigStorage) GetBucketInfo(ctx context.Context, bucketName string,
+	credential iam.Credential) (bucket meta.Bucket, err error) {
+
+	bucket, err = yig.MetaStorage.GetBucket(bucketName, true)
 	if err != nil {
 		return
 	}
+	if !yig.canListObjectsOf(ctx, bucket, credential) {
+		err = ErrBucketAccessForbidden
+		return
+	}
+	return
+}
+
+func (yig *YigStorage) ListBuckets(ctx context.Context, credential iam.Credential,
+	request datatype.ListBucketsRequest) (buckets []meta.Bucket, continuationToken string, err error) {
+
+	var bucketNames []string
+	if request.MaxBuckets == 0 {
+		bucketNames, err = yig.MetaStorage.GetUserBuckets(credential.UserId, true)
+		if err != nil {
+			return
+		}
+	} else {
+		var marker string
+		if request.ContinuationToken != "" {
+			marker, err = util.Decrypt(request.ContinuationToken)
+			if err != nil {
+				return nil, "", ErrInvalidContinuationToken
+			}
+		}
+		var truncated bool
+		bucketNames, truncated, err = yig.MetaStorage.ListUserBuckets(credential.UserId,
+			marker, request.MaxBuckets)
+		if err != nil {
+			return
+		}
+		if truncated && len(bucketNames) != 0 {
+			continuationToken = util.Encrypt(bucketNames[len(bucketNames)-1])
+		}
+	}
 	for _, bucketName := range bucketNames {
 		bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
 		if err != nil {
-			return buckets, err
+			return buckets, "", err
 		}
 		buckets = append(buckets, bucket)
 	}
 	return
 }
 
-func (yig *YigStorage) DeleteBucket(bucketName string, credential iam.Credential) (err error) {
+func (yig *YigStorage) DeleteBucket(ctx context.Context, bucketName string, credential iam.Credential) (err error) {
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, false)
 	if err != nil {
 		return err
@@ -315,42 +557,67 @@ func (yig *YigStorage) DeleteBucket(bucketName string, credential iam.Credential
 		// TODO validate bucket policy
 	}
 
-	// Check if bucket is empty
-	objs, _, _, _, _, err := yig.MetaStorage.Client.ListObjects(bucketName, "", "", "", "", false, 1)
+	// Check if bucket is empty, noncurrent versions and delete markers count,
+	// so do pending multipart uploads whose parts would be leaked otherwise
+	objs, _, _, _, _, err := yig.MetaStorage.Client.ListObjects(bucketName, "", "", "", "", true, 1)
 	if err != nil {
 		return err
 	}
 	if len(objs) != 0 {
 		return ErrBucketNotEmpty
 	}
-	err = yig.MetaStorage.Client.DeleteBucket(bucket)
+	uploads, _, _, _, _, err := yig.MetaStorage.Client.ListMultipartUploads(bucketName,
+		"", "", "", "", "", 1)
 	if err != nil {
 		return err
 	}
+	if len(uploads) != 0 {
+		return ErrBucketNotEmpty
+	}
 
-	err = yig.MetaStorage.RemoveBucketForUser(bucketName, credential.UserId)
-	if err != nil { // roll back bucket table, i.e. re-add removed bucket entry
-		err = yig.MetaStorage.Client.AddBucketForUser(bucketName, credential.UserId)
-		if err != nil {
-			return err
-		}
+	// CORS, policy and other configurations of the bucket are removed along
+	// with its row
+	err = yig.MetaStorage.Client.DeleteBucket(bucket)
+	if err != nil {
+		return err
 	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
 
-	if err == nil {
-		yig.MetaStorage.Cache.Remove(redis.UserTable, credential.UserId)
-		yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	err = yig.MetaStorage.RemoveBucketForUser(bucketName, credential.UserId)
+	if err != nil { // roll back bucket table, i.e. re-add removed bucket
+		helper.LogWithContext(ctx, "Error RemoveBucketForUser: %v", err)
+		yig.rollbackDeleteBucket(ctx, bucket)
+		return err
 	}
+	yig.MetaStorage.Cache.Remove(redis.UserTable, credential.UserId)
 
 	if bucket.LC.Rule != nil {
 		err = yig.MetaStorage.RemoveBucketFromLifeCycle(bucket)
 		if err != nil {
-			yig.Logger.Println(5, "Error remove bucket from lifeCycle: ", err)
+			helper.LogWithContext(ctx, "Inconsistent data: bucket should be removed from lifecycle table: %s %v",
+				bucketName, err)
 		}
 	}
-
 	return nil
 }
 
+// Put back the bucket removed by DeleteBucket, unless a bucket of the same
+// name has been created by others in between
+func (yig *YigStorage) rollbackDeleteBucket(ctx context.Context, bucket meta.Bucket) {
+	processed, err := yig.MetaStorage.Client.CheckAndPutBucket(bucket)
+	if err != nil {
+		helper.LogWithContext(ctx, "Inconsistent data: bucket should be restored: %s %s %v",
+			bucket.Name, bucket.OwnerId, err)
+		return
+	}
+	if !processed {
+		helper.LogWithContext(ctx, "Inconsistent data: bucket recreated by others, "+
+			"should be removed from buckets of user: %s %s", bucket.Name, bucket.OwnerId)
+		return
+	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucket.Name)
+}
+
 func (yig *YigStorage) ListObjectsInternal(bucketName string,
 	request datatype.ListObjectsRequest) (retObjects []*meta.Object, prefixes []string, truncated bool,
 	nextMarker, nextVerIdMarker string, err error) {
@@ -379,28 +646,35 @@ func (yig *YigStorage) ListObjectsInternal(bucketName string,
 	return yig.MetaStorage.Client.ListObjects(bucketName, marker, verIdMarker, request.Prefix, request.Delimiter, request.Versioned, request.MaxKeys)
 }
 
-func (yig *YigStorage) ListObjects(credential iam.Credential, bucketName string,
+// Owners of listed objects, so each user is looked up only once in a list
+// call, objects are usually owned by a few users
+type ownerCache map[string]datatype.Owner
+
+func (c ownerCache) get(userId string) (datatype.Owner, error) {
+	if owner, ok := c[userId]; ok {
+		return owner, nil
+	}
+	credential, err := iam.GetCredentialByUserId(userId)
+	if err != nil {
+		return datatype.Owner{}, err
+	}
+	owner := datatype.Owner{ID: credential.UserId, DisplayName: credential.DisplayName}
+	c[userId] = owner
+	return owner, nil
+}
+
+func (yig *YigStorage) ListObjects(ctx context.Context, credential iam.Credential, bucketName string,
 	request datatype.ListObjectsRequest) (result meta.ListObjectsInfo, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
-	helper.Debugln("GetBucket", bucket)
+	helper.DebuglnContext(ctx, "GetBucket", bucket)
 	if err != nil {
 		return
 	}
 
-	switch bucket.ACL.CannedAcl {
-	case "public-read", "public-read-write":
-		break
-	case "authenticated-read":
-		if credential.UserId == "" {
-			err = ErrBucketAccessForbidden
-			return
-		}
-	default:
-		if bucket.OwnerId != credential.UserId {
-			err = ErrBucketAccessForbidden
-			return
-		}
+	if !yig.canListObjectsOf(ctx, bucket, credential) {
+		err = ErrBucketAccessForbidden
+		return
 	}
 	// TODO validate user policy and ACL
 
@@ -412,13 +686,20 @@ func (yig *YigStorage) ListObjects(credential iam.Credential, bucketName string,
 		result.NextMarker = util.Encrypt(result.NextMarker)
 	}
 	objects := make([]datatype.Object, 0, len(retObjects))
+	owners := make(ownerCache)
+	now := time.Now()
 	for _, obj := range retObjects {
-		helper.Debugln("result:", obj.Name)
+		helper.DebuglnContext(ctx, "result:", obj.Name)
+		// expired objects are left to lifecycle tool, which lists them
+		// with ListObjectsInternal
+		if obj.IsExpired(now) {
+			continue
+		}
 		object := datatype.Object{
 			LastModified: obj.LastModifiedTime.UTC().Format(meta.CREATE_TIME_LAYOUT),
 			ETag:         "\"" + obj.Etag + "\"",
 			Size:         obj.Size,
-			StorageClass: "STANDARD",
+			StorageClass: obj.StorageClass,
 		}
 		if request.EncodingType != "" { // only support "url" encoding for now
 			object.Key = url.QueryEscape(obj.Name)
@@ -427,15 +708,12 @@ func (yig *YigStorage) ListObjects(credential iam.Credential, bucketName string,
 		}
 
 		if request.FetchOwner {
-			var owner iam.Credential
-			owner, err = iam.GetCredentialByUserId(obj.OwnerId)
+			var owner datatype.Owner
+			owner, err = owners.get(obj.OwnerId)
 			if err != nil {
 				return
 			}
-			object.Owner = datatype.Owner{
-				ID:          owner.UserId,
-				DisplayName: owner.DisplayName,
-			}
+			object.Owner = &owner
 		}
 		objects = append(objects, object)
 	}
@@ -454,7 +732,7 @@ func (yig *YigStorage) ListObjects(credential iam.Credential, bucketName string,
 
 // TODO: refactor, similar to ListObjects
 // or not?
-func (yig *YigStorage) ListVersionedObjects(credential iam.Credential, bucketName string,
+func (yig *YigStorage) ListVersionedObjects(ctx context.Context, credential iam.Credential, bucketName string,
 	request datatype.ListObjectsRequest) (result meta.VersionedListObjectsInfo, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
@@ -462,19 +740,9 @@ func (yig *YigStorage) ListVersionedObjects(credential iam.Credential, bucketNam
 		return
 	}
 
-	switch bucket.ACL.CannedAcl {
-	case "public-read", "public-read-write":
-		break
-	case "authenticated-read":
-		if credential.UserId == "" {
-			err = ErrBucketAccessForbidden
-			return
-		}
-	default:
-		if bucket.OwnerId != credential.UserId {
-			err = ErrBucketAccessForbidden
-			return
-		}
+	if !yig.canListObjectsOf(ctx, bucket, credential) {
+		err = ErrBucketAccessForbidden
+		return
 	}
 
 	retObjects, prefixes, truncated, nextMarker, nextVerIdMarker, err := yig.ListObjectsInternal(bucketName, request)
@@ -484,13 +752,14 @@ func (yig *YigStorage) ListVersionedObjects(credential iam.Credential, bucketNam
 	}
 
 	objects := make([]datatype.VersionedObject, 0, len(retObjects))
+	owners := make(ownerCache)
 	for _, o := range retObjects {
 		// TODO: IsLatest
 		object := datatype.VersionedObject{
 			LastModified: o.LastModifiedTime.UTC().Format(meta.CREATE_TIME_LAYOUT),
 			ETag:         "\"" + o.Etag + "\"",
 			Size:         o.Size,
-			StorageClass: "STANDARD",
+			StorageClass: o.StorageClass,
 			Key:          o.Name,
 		}
 		if request.EncodingType != "" { // only support "url" encoding for now
@@ -503,15 +772,10 @@ func (yig *YigStorage) ListVersionedObjects(credential iam.Credential, bucketNam
 			object.XMLName.Local = "Version"
 		}
 		if request.FetchOwner {
-			var owner iam.Credential
-			owner, err = iam.GetCredentialByUserId(o.OwnerId)
+			object.Owner, err = owners.get(o.OwnerId)
 			if err != nil {
 				return
 			}
-			object.Owner = datatype.Owner{
-				ID:          owner.UserId,
-				DisplayName: owner.DisplayName,
-			}
 		}
 		objects = append(objects, object)
 	}
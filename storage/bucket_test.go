@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/meta/types"
+)
+
+// userFailureClient fails updating buckets of users, after `addBucketHook`
+// is called
+type userFailureClient struct {
+	*fakeClient
+	addBucketHook func(bucketName string) error
+	removeErr     error
+}
+
+func (c *userFailureClient) AddBucketForUser(bucketName, userId string) error {
+	if c.addBucketHook != nil {
+		if err := c.addBucketHook(bucketName); err != nil {
+			return err
+		}
+	}
+	return c.fakeClient.AddBucketForUser(bucketName, userId)
+}
+
+func (c *userFailureClient) RemoveBucketForUser(bucketName string, userId string) error {
+	if c.removeErr != nil {
+		return c.removeErr
+	}
+	return c.fakeClient.RemoveBucketForUser(bucketName, userId)
+}
+
+func TestMakeBucketConcurrently(t *testing.T) {
+	for _, owners := range [][2]string{{"alice", "alice"}, {"alice", "bob"}} {
+		c := newFakeClient()
+		yig := newTestStorage(c)
+
+		start := make(chan struct{})
+		results := make(chan error, 2)
+		for _, owner := range owners {
+			go func(owner string) {
+				<-start
+				results <- yig.MakeBucket(context.Background(), "bucket", datatype.Acl{CannedAcl: "private"},
+					iam.Credential{UserId: owner})
+			}(owner)
+		}
+		close(start)
+
+		var succeeded, failed int
+		for i := 0; i < 2; i++ {
+			switch err := <-results; err {
+			case nil:
+				succeeded += 1
+			case ErrBucketAlreadyExists, ErrBucketAlreadyOwnedByYou:
+				failed += 1
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if succeeded != 1 || failed != 1 {
+			t.Errorf("%v: expected exactly one winner, got %d succeeded %d failed",
+				owners, succeeded, failed)
+		}
+		winner := c.buckets["bucket"].OwnerId
+		if len(c.userBuckets) != 1 || !c.userBuckets[winner]["bucket"] {
+			t.Errorf("%v: bucket should only be listed for %s, got %v",
+				owners, winner, c.userBuckets)
+		}
+	}
+}
+
+func TestMakeBucketRollback(t *testing.T) {
+	c := &userFailureClient{fakeClient: newFakeClient()}
+	yig := newTestStorage(c)
+	failure := errors.New("hbase down")
+	c.addBucketHook = func(bucketName string) error { return failure }
+
+	err := yig.MakeBucket(context.Background(), "bucket", datatype.Acl{CannedAcl: "private"},
+		iam.Credential{UserId: "alice"})
+	if err != failure {
+		t.Errorf("error of AddBucketForUser should be returned, got %v", err)
+	}
+	if _, ok := c.buckets["bucket"]; ok {
+		t.Error("bucket should be removed after rollback")
+	}
+
+	// bucket deleted and recreated by others before rollback
+	c.addBucketHook = func(bucketName string) error {
+		c.DeleteBucket(types.Bucket{Name: bucketName})
+		c.CheckAndPutBucket(types.Bucket{Name: bucketName, OwnerId: "bob"})
+		return failure
+	}
+	err = yig.MakeBucket(context.Background(), "bucket", datatype.Acl{CannedAcl: "private"},
+		iam.Credential{UserId: "alice"})
+	if err != failure {
+		t.Errorf("error of AddBucketForUser should be returned, got %v", err)
+	}
+	if c.buckets["bucket"].OwnerId != "bob" {
+		t.Error("bucket of others should not be removed by rollback")
+	}
+}
+
+func TestListBucketsPaged(t *testing.T) {
+	yig := newTestStorage(newFakeClient())
+	alice := iam.Credential{UserId: "alice"}
+	for _, name := range []string{"e", "a", "d", "b", "c"} {
+		err := yig.MakeBucket(context.Background(), name, datatype.Acl{CannedAcl: "private"}, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	yig.MakeBucket(context.Background(), "other", datatype.Acl{CannedAcl: "private"},
+		iam.Credential{UserId: "bob"})
+
+	var pages [][]string
+	request := datatype.ListBucketsRequest{MaxBuckets: 2}
+	for {
+		buckets, token, err := yig.ListBuckets(context.Background(), alice, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page []string
+		for _, bucket := range buckets {
+			page = append(page, bucket.Name)
+		}
+		pages = append(pages, page)
+		if token == "" {
+			break
+		}
+		request.ContinuationToken = token
+	}
+	expected := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected pages %v, got %v", expected, pages)
+	}
+
+	buckets, token, err := yig.ListBuckets(context.Background(), alice, datatype.ListBucketsRequest{})
+	if err != nil || len(buckets) != 5 || token != "" {
+		t.Errorf("all buckets should be listed without max-buckets, got %d %q %v",
+			len(buckets), token, err)
+	}
+	request = datatype.ListBucketsRequest{MaxBuckets: 2, ContinuationToken: "hehe"}
+	if _, _, err := yig.ListBuckets(context.Background(), alice, request); err != ErrInvalidContinuationToken {
+		t.Errorf("expected ErrInvalidContinuationToken, got %v", err)
+	}
+}
+
+func TestDeleteBucket(t *testing.T) {
+	c := &userFailureClient{fakeClient: newFakeClient()}
+	yig := newTestStorage(c)
+	alice := iam.Credential{UserId: "alice"}
+	err := yig.MakeBucket(context.Background(), "bucket", datatype.Acl{CannedAcl: "private"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marker := c.putObject(&types.Object{Name: "hehe", BucketName: "bucket", DeleteMarker: true})
+	if err := yig.DeleteBucket(context.Background(), "bucket", alice); err != ErrBucketNotEmpty {
+		t.Errorf("bucket with delete markers should not be deleted, got %v", err)
+	}
+	c.DeleteObject(marker)
+	upload := types.Multipart{BucketName: "bucket", ObjectName: "hehe", InitialTime: time.Now()}
+	c.CreateMultipart(upload)
+	if err := yig.DeleteBucket(context.Background(), "bucket", alice); err != ErrBucketNotEmpty {
+		t.Errorf("bucket with multipart uploads should not be deleted, got %v", err)
+	}
+	c.DeleteMultipart(upload)
+
+	// bucket is put back if it could not be removed from buckets of the user
+	failure := errors.New("hbase down")
+	c.removeErr = failure
+	if err := yig.DeleteBucket(context.Background(), "bucket", alice); err != failure {
+		t.Errorf("error of RemoveBucketForUser should be returned, got %v", err)
+	}
+	if c.buckets["bucket"].OwnerId != "alice" || !c.userBuckets["alice"]["bucket"] {
+		t.Error("bucket should be restored after rollback")
+	}
+
+	c.removeErr = nil
+	if err := yig.DeleteBucket(context.Background(), "bucket", alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.buckets["bucket"]; ok || c.userBuckets["alice"]["bucket"] {
+		t.Error("bucket should be deleted")
+	}
+}
+
+// countingClient counts reads of buckets from database
+type countingClient struct {
+	*fakeClient
+	bucketGets int64
+}
+
+func (c *countingClient) GetBucket(bucketName string) (types.Bucket, error) {
+	atomic.AddInt64(&c.bucketGets, 1)
+	return c.fakeClient.GetBucket(bucketName)
+}
+
+func TestBucketCacheInvalidation(t *testing.T) {
+	c := newFakeClient()
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "alice", Usage: 1})
+	yig := newTestStorage(c)
+	yig.MetaStorage.Cache = newFakeCache()
+
+	yig.GetBucket(context.Background(), "bucket")
+	// usage updated in database, cache not invalidated
+	c.UpdateUsage("bucket", 99)
+
+	err := yig.SetBucketVersioning(context.Background(), "bucket", datatype.Versioning{Status: "Enabled"},
+		iam.Credential{UserId: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.buckets["bucket"].Usage != 100 {
+		t.Error("stale usage in cache should not be written back")
+	}
+	bucket, err := yig.GetBucket(context.Background(), "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket.Versioning != "Enabled" {
+		t.Error("cached bucket should be invalidated after update")
+	}
+}
+
+func BenchmarkGetObjectInfoBucketCached(b *testing.B) {
+	c := &countingClient{fakeClient: newFakeClient()}
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "user"})
+	c.putObject(&types.Object{Name: "a", BucketName: "bucket", OwnerId: "user"})
+	yig := newTestStorage(c)
+	yig.MetaStorage.Cache = newFakeCache()
+	credential := iam.Credential{UserId: "user"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := yig.GetObjectInfo(context.Background(), "bucket", "a", "", credential)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(c.bucketGets)/float64(b.N), "bucket-reads/op")
+	if c.bucketGets > 1 {
+		b.Errorf("bucket should be read from database once, read %d times", c.bucketGets)
+	}
+}
+
+func TestBucketMaxObjectSize(t *testing.T) {
+	c := newFakeClient()
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "alice"})
+	yig := newTestStorage(c)
+	yig.MetaStorage.Cache = newFakeCache()
+	credential := iam.Credential{UserId: "alice"}
+
+	if err := yig.SetBucketMaxObjectSize("bucket", -1); err != ErrInvalidMaxObjectSize {
+		t.Errorf("negative size should be rejected, got %v", err)
+	}
+	if err := yig.SetBucketMaxObjectSize("bucket", 10); err != nil {
+		t.Fatal(err)
+	}
+	_, err := yig.PutObject(context.Background(), "bucket", "a", credential, 11, strings.NewReader(strings.Repeat("x", 11)),
+		nil, datatype.Acl{CannedAcl: "private"}, datatype.SseRequest{})
+	if err != ErrEntityTooLarge {
+		t.Errorf("object larger than limit should be rejected early, got %v", err)
+	}
+
+	reader := &sizeLimitedReader{reader: strings.NewReader(strings.Repeat("x", 11)), max: 10}
+	n, err := reader.Read(make([]byte, 5))
+	if n != 5 || err != nil || reader.exceeded {
+		t.Errorf("reads within limit should pass, got %d %v", n, err)
+	}
+	n, err = reader.Read(make([]byte, 10))
+	if n != 0 || err != ErrEntityTooLarge || !reader.exceeded {
+		t.Errorf("read beyond limit should fail, got %d %v", n, err)
+	}
+}
+
+// uploadsFailureClient fails counting multipart uploads with `err`
+type uploadsFailureClient struct {
+	*fakeClient
+	err error
+}
+
+func (c *uploadsFailureClient) CountMultipartUploads(bucketName string) (int64, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.fakeClient.CountMultipartUploads(bucketName)
+}
+
+func TestGetBucketStats(t *testing.T) {
+	c := &uploadsFailureClient{fakeClient: newFakeClient()}
+	c.putBucket(types.Bucket{Name: "bucket"})
+	// put in order, newer versions later
+	for i, o := range []*types.Object{
+		{Name: "a", Size: 20},
+		{Name: "a", Size: 10},
+		{Name: "b", Size: 5},
+		{Name: "b", DeleteMarker: true},
+		{Name: "c", Size: 1, StorageClass: "GLACIER"},
+	} {
+		o.BucketName = "bucket"
+		o.LastModifiedTime = time.Now().Add(time.Duration(i) * time.Second)
+		c.putObject(o)
+	}
+	for _, name := range []string{"big", "huge"} {
+		c.CreateMultipart(types.Multipart{BucketName: "bucket", ObjectName: name,
+			InitialTime: time.Now()})
+	}
+	yig := newTestStorage(c)
+
+	stats, err := yig.GetBucketStats("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.BucketStats{
+		TotalBytes:           36,
+		ObjectCount:          2,
+		MultipartUploadCount: 2,
+		VersionsCount:        4,
+		DeleteMarkerCount:    1,
+		StorageClasses: map[string]types.StorageClassStats{
+			"STANDARD": {TotalBytes: 35, VersionsCount: 3},
+			"GLACIER":  {TotalBytes: 1, VersionsCount: 1},
+		},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	c.err = ErrServiceUnavailable
+	if _, err := yig.GetBucketStats("bucket"); err != ErrServiceUnavailable {
+		t.Errorf("error of scanning uploads should be returned, got %v", err)
+	}
+	if _, err := yig.GetBucketStats("nobucket"); err != ErrNoSuchBucket {
+		t.Errorf("expected NoSuchBucket, got %v", err)
+	}
+}
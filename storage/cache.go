@@ -1,18 +1,21 @@
 package storage
 
 import (
+	"bytes"
 	"io"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/journeymidnight/yig/helper"
 	meta "github.com/journeymidnight/yig/meta/types"
 	"github.com/journeymidnight/yig/redis"
-	"bytes"
 )
 
 const (
-	// only objects smaller than threshold are cached
-	FILE_CACHE_THRESHOLD_SIZE = 4 << 20 // 4M
+	// cached objects are split into chunks of this size, so ranged GETs
+	// only fetch relevant chunks from Redis
+	DATA_CACHE_CHUNK_SIZE = 512 << 10 // 512K
 )
 
 type DataCache interface {
@@ -23,10 +26,18 @@ type DataCache interface {
 		readThrough func() (io.ReadCloser, error),
 		onCacheMiss func(io.Writer) error) (io.ReadCloser, error)
 	Remove(key string)
+	GetStats() DataCacheStats
+}
+
+type DataCacheStats struct {
+	Hit    int64
+	Miss   int64
+	Bypass int64 // objects too large to be cached, or Redis is unavailable
 }
 
 type enabledDataCache struct {
 	failedCacheInvalidOperation chan string
+	stats                       DataCacheStats // accessed atomically
 }
 
 type disabledDataCache struct{}
@@ -47,39 +58,154 @@ func newDataCache(cacheEnabled bool) (d DataCache) {
 func invalidRedisCache(d *enabledDataCache) {
 	for {
 		key := <-d.failedCacheInvalidOperation
-		err := redis.Remove(redis.FileTable, key)
+		err := removeChunks(key)
 		if err != nil {
-			d.failedCacheInvalidOperation <- key
+			d.retryInvalid(key)
 			time.Sleep(1 * time.Second)
 		}
 	}
 }
 
-// `writeThrough` performs normal workflow without cache
-// `onCacheMiss` should be able to read the WHOLE object
-func (d *enabledDataCache) WriteFromCache(object *meta.Object, startOffset int64, length int64,
-	out io.Writer, writeThrough func(io.Writer) error, onCacheMiss func(io.Writer) error) error {
+// Queue invalid operation to redo, without blocking the request when
+// Redis is down for long and the queue is full
+func (d *enabledDataCache) retryInvalid(key string) {
+	select {
+	case d.failedCacheInvalidOperation <- key:
+	default:
+		helper.Logger.Println(5, "Too many failed data cache invalid operations, dropped", key)
+	}
+}
 
-	if object.Size > FILE_CACHE_THRESHOLD_SIZE {
-		return writeThrough(out)
+// Key of object in data cache, `version` is "null" for null version
+func dataCacheKey(bucketName, objectName, version string) string {
+	return bucketName + ":" + objectName + ":" + version
+}
+
+func chunkKey(cacheKey string, index int64) string {
+	return cacheKey + ":" + strconv.FormatInt(index, 10)
+}
+
+func maxCachedObjectSize() int64 {
+	return int64(helper.CONFIG.MaxObjectSizeForCache) << 10
+}
+
+// all keys an object could occupy, including the unchunked one written
+// by earlier versions
+func allChunkKeys(cacheKey string) []string {
+	keys := []string{cacheKey}
+	for i := int64(0); i*DATA_CACHE_CHUNK_SIZE < maxCachedObjectSize(); i++ {
+		keys = append(keys, chunkKey(cacheKey, i))
+	}
+	return keys
+}
+
+func removeChunks(cacheKey string) error {
+	return redis.RemoveKeys(redis.FileTable, allChunkKeys(cacheKey))
+}
+
+// range [Start, End) inside chunk `Index`
+type chunkRange struct {
+	Index      int64
+	Start, End int64
+}
+
+// chunks covering [start, end) of an object of `size` bytes
+func chunkRanges(size, start, end int64) (ranges []chunkRange) {
+	if end > size {
+		end = size
+	}
+	for i := start / DATA_CACHE_CHUNK_SIZE; i*DATA_CACHE_CHUNK_SIZE < end; i++ {
+		chunkStart := i * DATA_CACHE_CHUNK_SIZE
+		r := chunkRange{Index: i, Start: 0, End: DATA_CACHE_CHUNK_SIZE}
+		if start > chunkStart {
+			r.Start = start - chunkStart
+		}
+		if end < chunkStart+DATA_CACHE_CHUNK_SIZE {
+			r.End = end - chunkStart
+		}
+		ranges = append(ranges, r)
+	}
+	return
+}
+
+// Read [start, start+length) of a cached object, returns false if any
+// chunk is missing
+func readChunks(cacheKey string, size, start, length int64) ([]byte, bool) {
+	data := make([]byte, 0, length)
+	for _, r := range chunkRanges(size, start, start+length) {
+		// Redis returns "" for nonexist key for GETRANGE
+		chunk, err := redis.GetBytes(chunkKey(cacheKey, r.Index), r.Start, r.End-1)
+		if err != nil || int64(len(chunk)) != r.End-r.Start {
+			return nil, false
+		}
+		data = append(data, chunk...)
+	}
+	return data, int64(len(data)) == length
+}
+
+func writeChunks(cacheKey string, data []byte) {
+	for _, r := range chunkRanges(int64(len(data)), 0, int64(len(data))) {
+		offset := r.Index * DATA_CACHE_CHUNK_SIZE
+		err := redis.SetBytes(chunkKey(cacheKey, r.Index), data[offset+r.Start:offset+r.End])
+		if err != nil {
+			helper.ErrorIf(err, "Failed to cache object %s", cacheKey)
+			return
+		}
 	}
+}
+
+func (d *enabledDataCache) cacheable(object *meta.Object) bool {
+	if object.Size == 0 || object.Size > maxCachedObjectSize() || !redis.Available() {
+		atomic.AddInt64(&d.stats.Bypass, 1)
+		return false
+	}
+	return true
+}
 
-	cacheKey := object.BucketName + ":" + object.Name + ":" + object.GetVersionId()
+// Read [startOffset, startOffset+length) of object from cache, or read the
+// whole object with `onCacheMiss` and cache it
+func (d *enabledDataCache) read(object *meta.Object, startOffset int64, length int64,
+	onCacheMiss func(io.Writer) error) ([]byte, error) {
 
-	file, err := redis.GetBytes(cacheKey, startOffset, startOffset+length-1)
-	if err == nil && file != nil && int64(len(file)) == length {
+	cacheKey := dataCacheKey(object.BucketName, object.Name, object.GetVersionId())
+	data, hit := readChunks(cacheKey, object.Size, startOffset, length)
+	if hit {
 		helper.Debugln("File cache HIT")
-		_, err := out.Write(file)
-		return err
+		atomic.AddInt64(&d.stats.Hit, 1)
+		return data, nil
 	}
 
 	helper.Debugln("File cache MISS")
-
+	atomic.AddInt64(&d.stats.Miss, 1)
 	var buffer bytes.Buffer
-	onCacheMiss(&buffer)
+	err := onCacheMiss(&buffer)
+	if err != nil {
+		return nil, err
+	}
+	if int64(buffer.Len()) < startOffset+length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	// chunks are read with object size
+	if int64(buffer.Len()) == object.Size {
+		writeChunks(cacheKey, buffer.Bytes())
+	}
+	return buffer.Bytes()[startOffset : startOffset+length], nil
+}
+
+// `writeThrough` performs normal workflow without cache
+// `onCacheMiss` should be able to read the WHOLE object
+func (d *enabledDataCache) WriteFromCache(object *meta.Object, startOffset int64, length int64,
+	out io.Writer, writeThrough func(io.Writer) error, onCacheMiss func(io.Writer) error) error {
+
+	if !d.cacheable(object) {
+		return writeThrough(out)
+	}
 
-	redis.SetBytes(cacheKey, buffer.Bytes())
-	_, err = out.Write(buffer.Bytes()[startOffset : startOffset+length])
+	data, err := d.read(object, startOffset, length, onCacheMiss)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
 	return err
 }
 
@@ -97,7 +223,7 @@ func (d *enabledDataCache) GetAlignedReader(object *meta.Object, startOffset int
 	readThrough func() (io.ReadCloser, error),
 	onCacheMiss func(io.Writer) error) (io.ReadCloser, error) {
 
-	if object.Size > FILE_CACHE_THRESHOLD_SIZE {
+	if !d.cacheable(object) {
 		return readThrough()
 	}
 
@@ -105,23 +231,11 @@ func (d *enabledDataCache) GetAlignedReader(object *meta.Object, startOffset int
 	length += startOffset - alignedOffset
 	startOffset = alignedOffset
 
-	cacheKey := object.BucketName + ":" + object.Name + ":" + object.GetVersionId()
-
-	file, err := redis.GetBytes(cacheKey, startOffset, startOffset+length-1)
-	if err == nil && file != nil && int64(len(file)) == length {
-		helper.Debugln("File cache HIT")
-		r := newReadCloser(file)
-		return r, nil
+	data, err := d.read(object, startOffset, length, onCacheMiss)
+	if err != nil {
+		return nil, err
 	}
-
-	helper.Debugln("File cache MISS")
-
-	var buffer bytes.Buffer
-	onCacheMiss(&buffer)
-
-	redis.SetBytes(cacheKey, buffer.Bytes())
-	r := newReadCloser(buffer.Bytes()[startOffset : startOffset+length])
-	return r, nil
+	return newReadCloser(data), nil
 }
 
 func (d *disabledDataCache) GetAlignedReader(object *meta.Object, startOffset int64, length int64,
@@ -132,9 +246,9 @@ func (d *disabledDataCache) GetAlignedReader(object *meta.Object, startOffset in
 }
 
 func (d *enabledDataCache) Remove(key string) {
-	err := redis.Remove(redis.FileTable, key)
+	err := removeChunks(key)
 	if err != nil {
-		d.failedCacheInvalidOperation <- key
+		d.retryInvalid(key)
 	}
 }
 
@@ -142,6 +256,18 @@ func (d *disabledDataCache) Remove(key string) {
 	return
 }
 
+func (d *enabledDataCache) GetStats() DataCacheStats {
+	return DataCacheStats{
+		Hit:    atomic.LoadInt64(&d.stats.Hit),
+		Miss:   atomic.LoadInt64(&d.stats.Miss),
+		Bypass: atomic.LoadInt64(&d.stats.Bypass),
+	}
+}
+
+func (d *disabledDataCache) GetStats() DataCacheStats {
+	return DataCacheStats{}
+}
+
 type ReadCloser struct {
 	s []byte
 	i int64 // current reading index
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/journeymidnight/yig/helper"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+func TestDisabledDataCache(t *testing.T) {
+	d := newDataCache(false)
+	if _, ok := d.(*disabledDataCache); !ok {
+		t.Fatal("disabledDataCache expected if data cache is not enabled")
+	}
+	var out bytes.Buffer
+	err := d.WriteFromCache(&meta.Object{Size: 4}, 0, 4, &out,
+		func(w io.Writer) error {
+			_, err := w.Write([]byte("hehe"))
+			return err
+		},
+		func(w io.Writer) error {
+			t.Error("onCacheMiss should not be called")
+			return nil
+		})
+	if err != nil || out.String() != "hehe" {
+		t.Errorf("data should be written through, got %s %v", out.String(), err)
+	}
+}
+
+func TestChunkRanges(t *testing.T) {
+	const c = DATA_CACHE_CHUNK_SIZE
+	cases := []struct {
+		size, start, end int64
+		expected         []chunkRange
+	}{
+		{100, 0, 100, []chunkRange{{0, 0, 100}}},
+		{3 * c, 0, 3 * c, []chunkRange{{0, 0, c}, {1, 0, c}, {2, 0, c}}},
+		{3 * c, c + 1, c + 2, []chunkRange{{1, 1, 2}}},
+		{3 * c, c - 1, 2*c + 1, []chunkRange{{0, c - 1, c}, {1, 0, c}, {2, 0, 1}}},
+		{c + 10, 0, 2 * c, []chunkRange{{0, 0, c}, {1, 0, 10}}},
+	}
+	for i, tc := range cases {
+		ranges := chunkRanges(tc.size, tc.start, tc.end)
+		if len(ranges) != len(tc.expected) {
+			t.Errorf("case %d: expected %v, got %v", i, tc.expected, ranges)
+			continue
+		}
+		for j := range ranges {
+			if ranges[j] != tc.expected[j] {
+				t.Errorf("case %d: expected %v, got %v", i, tc.expected, ranges)
+				break
+			}
+		}
+	}
+}
+
+func TestDataCacheBypassLargeObject(t *testing.T) {
+	helper.CONFIG.MaxObjectSizeForCache = 4
+	d := &enabledDataCache{}
+	var out bytes.Buffer
+	err := d.WriteFromCache(&meta.Object{Size: 5 << 10}, 0, 4, &out,
+		func(w io.Writer) error {
+			_, err := w.Write([]byte("hehe"))
+			return err
+		},
+		func(w io.Writer) error {
+			t.Error("large objects should not be read into cache")
+			return nil
+		})
+	if err != nil || out.String() != "hehe" {
+		t.Errorf("data should be written through, got %s %v", out.String(), err)
+	}
+	if stats := d.GetStats(); stats.Bypass != 1 || stats.Hit != 0 || stats.Miss != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if keys := allChunkKeys("b:o:v"); len(keys) != 1+(4<<10+DATA_CACHE_CHUNK_SIZE-1)/DATA_CACHE_CHUNK_SIZE {
+		t.Errorf("unexpected keys to invalidate %v", keys)
+	}
+}
@@ -1,12 +1,12 @@
 package storage
 
 import (
-	"container/list"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"sync"
+	"syscall"
 
 	"github.com/journeymidnight/radoshttpd/rados"
 	"github.com/journeymidnight/yig/helper"
@@ -26,6 +26,7 @@ const (
 	BIG_FILE_POOLNAME   = "tiger"
 	BIG_FILE_THRESHOLD  = 128 << 10 /* 128K */
 	AIO_CONCURRENT      = 4
+	HEALTH_CHECK_OID    = "yig-health-check"
 )
 
 type CephStorage struct {
@@ -35,6 +36,20 @@ type CephStorage struct {
 	Logger     *log.Logger
 	CountMutex *sync.Mutex
 	Counter    uint64
+	pools      objectPools
+}
+
+// Reads and writes of objects in pools of a cluster
+type objectPools interface {
+	put(poolName string, oid string, data io.Reader) (size int64, err error)
+	getReader(poolName string, oid string, startOffset int64, length int64) (io.ReadCloser, error)
+	remove(poolName string, oid string) error
+}
+
+// objectPools of librados, objects in SMALL_FILE_POOLNAME are written
+// as a whole and others by libradosstriper
+type radosPools struct {
+	conn *rados.Conn
 }
 
 func NewCephStorage(configFile string, logger *log.Logger) *CephStorage {
@@ -72,6 +87,7 @@ func NewCephStorage(configFile string, logger *log.Logger) *CephStorage {
 		InstanceId: id,
 		Logger:     logger,
 		CountMutex: new(sync.Mutex),
+		pools:      radosPools{conn: Rados},
 	}
 
 	logger.Printf(5, "Ceph Cluster %s is ready, InstanceId is %d\n", name, id)
@@ -92,39 +108,6 @@ func setStripeLayout(p *rados.StriperPool) int {
 	return ret
 }
 
-func pending_has_completed(p *list.List) bool {
-	if p.Len() == 0 {
-		return false
-	}
-	e := p.Front()
-	c := e.Value.(*rados.AioCompletion)
-	ret := c.IsComplete()
-	if ret == 0 {
-		return false
-	} else {
-		return true
-	}
-}
-
-func wait_pending_front(p *list.List) int {
-	/* remove AioCompletion from list */
-	e := p.Front()
-	p.Remove(e)
-	c := e.Value.(*rados.AioCompletion)
-	c.WaitForComplete()
-	ret := c.GetReturnValue()
-	c.Release()
-	return ret
-}
-
-func drain_pending(p *list.List) int {
-	var ret int
-	for p.Len() > 0 {
-		ret = wait_pending_front(p)
-	}
-	return ret
-}
-
 func (cluster *CephStorage) GetUniqUploadName() string {
 	cluster.CountMutex.Lock()
 	defer cluster.CountMutex.Unlock()
@@ -137,8 +120,8 @@ func (c *CephStorage) Shutdown() {
 	c.Conn.Shutdown()
 }
 
-func (cluster *CephStorage) doSmallPut(poolname string, oid string, data io.Reader) (size int64, err error) {
-	pool, err := cluster.Conn.OpenPool(poolname)
+func (p radosPools) doSmallPut(poolname string, oid string, data io.Reader) (size int64, err error) {
+	pool, err := p.conn.OpenPool(poolname)
 	if err != nil {
 		return 0, errors.New("Bad poolname")
 	}
@@ -197,106 +180,146 @@ func (rd *RadosSmallDownloader) Close() error {
 	return nil
 }
 
-func (cluster *CephStorage) Put(poolname string, oid string, data io.Reader) (size int64, err error) {
+// A pending async write to Ceph
+type asyncWrite interface {
+	// Blocks until the write completes, returns negative errno on failure
+	wait() int
+}
 
-	if poolname == SMALL_FILE_POOLNAME {
-		return cluster.doSmallPut(poolname, oid, data)
-	}
+type asyncWriter interface {
+	// Starts writing `data` at `offset`, `data` must be kept untouched until
+	// the write completes
+	writeAsync(data []byte, offset uint64) (asyncWrite, error)
+}
 
-	pool, err := cluster.Conn.OpenPool(poolname)
-	if err != nil {
-		return 0, errors.New("Bad poolname")
-	}
-	defer pool.Destroy()
+type striperWriter struct {
+	striper *rados.StriperPool
+	oid     string
+}
 
-	striper, err := pool.CreateStriper()
+type striperCompletion struct {
+	c *rados.AioCompletion
+}
+
+func (w striperWriter) writeAsync(data []byte, offset uint64) (asyncWrite, error) {
+	c := new(rados.AioCompletion)
+	c.Create()
+	_, err := w.striper.WriteAIO(c, w.oid, data, offset)
 	if err != nil {
-		return 0, errors.New("Bad ioctx")
+		c.Release()
+		return nil, err
 	}
-	defer striper.Destroy()
-
-	setStripeLayout(&striper)
-
-	/* if the data len in pending_data is bigger than current_upload_window, I will flush the data to ceph */
-	/* current_upload_window could not dynamically increase or shrink */
-
-	var c *rados.AioCompletion
-	pending := list.New()
-	var current_upload_window = MIN_CHUNK_SIZE /* initial window size as MIN_CHUNK_SIZE, max size is MAX_CHUNK_SIZE */
-	var pending_data = make([]byte, current_upload_window)
-
-	var slice_offset = 0
-	var slice = pending_data[0:current_upload_window]
+	return striperCompletion{c: c}, nil
+}
 
-	var offset uint64 = 0
+func (c striperCompletion) wait() int {
+	c.c.WaitForComplete()
+	ret := c.c.GetReturnValue()
+	c.c.Release()
+	return ret
+}
 
-	for {
+type inflightWrite struct {
+	write  asyncWrite
+	buffer []byte
+}
 
-		count, err := data.Read(slice)
-		if count == 0 {
-			break
+// Stream `data` to `writer` in chunks of `chunkSize` bytes, with at most
+// `depth` writes in flight. Buffers are reused once their writes complete,
+// so at most depth+1 chunks are held in memory whatever the object size.
+func streamPut(writer asyncWriter, data io.Reader, chunkSize, depth int) (size int64, err error) {
+	var inflight []inflightWrite // oldest first
+	var free [][]byte
+	waitOldest := func() error {
+		oldest := inflight[0]
+		inflight = inflight[1:]
+		if ret := oldest.write.wait(); ret < 0 {
+			return rados.RadosError(ret)
 		}
-
-		slice_offset += count
-		slice = pending_data[slice_offset:current_upload_window]
-		if err != nil && err != io.EOF {
-			drain_pending(pending)
-			return 0, errors.New("Read from client failed")
+		free = append(free, oldest.buffer)
+		return nil
+	}
+	drain := func() (err error) {
+		for len(inflight) > 0 {
+			if e := waitOldest(); e != nil && err == nil {
+				err = e
+			}
 		}
+		return err
+	}
 
-		//is pending_data full?
-		if slice_offset < len(pending_data) {
-			continue
+	for {
+		var buffer []byte
+		if len(free) > 0 {
+			buffer = free[len(free)-1]
+			free = free[:len(free)-1]
+		} else {
+			buffer = make([]byte, chunkSize)
 		}
-
-		/* pending data is full now */
-		var bl []byte = pending_data[:]
-
-		/* allocate a new pending data */
-		pending_data = make([]byte, current_upload_window)
-		slice_offset = 0
-		slice = pending_data[0:current_upload_window]
-
-		c = new(rados.AioCompletion)
-		c.Create()
-		_, err = striper.WriteAIO(c, oid, bl, offset)
-		if err != nil {
-			c.Release()
-			drain_pending(pending)
-			return 0, errors.New("Bad io")
+		n, readErr := io.ReadFull(data, buffer)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			drain()
+			return 0, readErr
 		}
-		pending.PushBack(c)
-
-		for pending_has_completed(pending) {
-			if ret := wait_pending_front(pending); ret < 0 {
-				drain_pending(pending)
-				return 0, errors.New("Error drain_pending in pending_has_completed")
+		if n > 0 {
+			write, err := writer.writeAsync(buffer[:n], uint64(size))
+			if err != nil {
+				drain()
+				return 0, err
 			}
-		}
-
-		if pending.Len() > AIO_CONCURRENT {
-			if ret := wait_pending_front(pending); ret < 0 {
-				drain_pending(pending)
-				return 0, errors.New("Error wait_pending_front")
+			inflight = append(inflight, inflightWrite{write: write, buffer: buffer})
+			size += int64(n)
+			if len(inflight) >= depth {
+				if err = waitOldest(); err != nil {
+					drain()
+					return 0, err
+				}
 			}
 		}
-		offset += uint64(len(bl))
+		if readErr != nil { // end of data
+			break
+		}
 	}
+	if err = drain(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (cluster *CephStorage) Put(poolname string, oid string, data io.Reader) (size int64, err error) {
+	return cluster.pools.put(poolname, oid, data)
+}
+
+func (p radosPools) put(poolname string, oid string, data io.Reader) (size int64, err error) {
 
-	size = int64(uint64(slice_offset) + offset)
-	//write all remaining data
-	if slice_offset > 0 {
-		c = new(rados.AioCompletion)
-		c.Create()
-		striper.WriteAIO(c, oid, pending_data[:slice_offset], offset)
-		pending.PushBack(c)
+	if poolname == SMALL_FILE_POOLNAME {
+		return p.doSmallPut(poolname, oid, data)
 	}
 
-	//drain_pending
-	if ret := drain_pending(pending); ret < 0 {
-		return 0, errors.New("Error drain_pending")
+	pool, err := p.conn.OpenPool(poolname)
+	if err != nil {
+		return 0, errors.New("Bad poolname")
 	}
-	return size, nil
+	defer pool.Destroy()
+
+	striper, err := pool.CreateStriper()
+	if err != nil {
+		return 0, errors.New("Bad ioctx")
+	}
+	defer striper.Destroy()
+
+	// layout is saved along with each object by libradosstriper, so objects
+	// are read correctly even if layout here changes
+	setStripeLayout(&striper)
+
+	chunkSize, depth := MAX_CHUNK_SIZE, AIO_CONCURRENT
+	if helper.CONFIG.CephWriteChunkSize > 0 {
+		chunkSize = helper.CONFIG.CephWriteChunkSize << 10
+	}
+	if helper.CONFIG.CephWriteQueueDepth > 0 {
+		depth = helper.CONFIG.CephWriteQueueDepth
+	}
+	return streamPut(striperWriter{striper: &striper, oid: oid}, data, chunkSize, depth)
 }
 
 type RadosDownloader struct {
@@ -344,8 +367,14 @@ func (rd *RadosDownloader) Close() error {
 func (cluster *CephStorage) getReader(poolName string, oid string, startOffset int64,
 	length int64) (reader io.ReadCloser, err error) {
 
+	return cluster.pools.getReader(poolName, oid, startOffset, length)
+}
+
+func (p radosPools) getReader(poolName string, oid string, startOffset int64,
+	length int64) (reader io.ReadCloser, err error) {
+
 	if poolName == SMALL_FILE_POOLNAME {
-		pool, e := cluster.Conn.OpenPool(poolName)
+		pool, e := p.conn.OpenPool(poolName)
 		if e != nil {
 			err = errors.New("bad poolname")
 			return
@@ -360,7 +389,7 @@ func (cluster *CephStorage) getReader(poolName string, oid string, startOffset i
 		return radosSmallReader, nil
 	}
 
-	pool, err := cluster.Conn.OpenPool(poolName)
+	pool, err := p.conn.OpenPool(poolName)
 	if err != nil {
 		err = errors.New("bad poolname")
 		return
@@ -408,8 +437,8 @@ func (cluster *CephStorage) get(poolName string, oid string, startOffset int64,
 }
 */
 
-func (cluster *CephStorage) doSmallRemove(poolname string, oid string) error {
-	pool, err := cluster.Conn.OpenPool(poolname)
+func (p radosPools) doSmallRemove(poolname string, oid string) error {
+	pool, err := p.conn.OpenPool(poolname)
 	if err != nil {
 		return errors.New("Bad poolname")
 	}
@@ -418,12 +447,16 @@ func (cluster *CephStorage) doSmallRemove(poolname string, oid string) error {
 }
 
 func (cluster *CephStorage) Remove(poolname string, oid string) error {
+	return cluster.pools.remove(poolname, oid)
+}
+
+func (p radosPools) remove(poolname string, oid string) error {
 
 	if poolname == SMALL_FILE_POOLNAME {
-		return cluster.doSmallRemove(poolname, oid)
+		return p.doSmallRemove(poolname, oid)
 	}
 
-	pool, err := cluster.Conn.OpenPool(poolname)
+	pool, err := p.conn.OpenPool(poolname)
 	if err != nil {
 		return errors.New("Bad poolname")
 	}
@@ -438,6 +471,27 @@ func (cluster *CephStorage) Remove(poolname string, oid string) error {
 	return striper.Delete(oid)
 }
 
+// Check the cluster serves requests by stat-ing HEALTH_CHECK_OID, which
+// doesn't have to exist
+func (cluster *CephStorage) Ping() error {
+	pool, err := cluster.Conn.OpenPool(BIG_FILE_POOLNAME)
+	if err != nil {
+		return err
+	}
+	defer pool.Destroy()
+	striper, err := pool.CreateStriper()
+	if err != nil {
+		return err
+	}
+	defer striper.Destroy()
+
+	_, _, err = striper.State(HEALTH_CHECK_OID)
+	if err == rados.RadosError(-int(syscall.ENOENT)) {
+		return nil
+	}
+	return err
+}
+
 func (cluster *CephStorage) GetUsedSpacePercent() (pct int, err error) {
 	stat, err := cluster.Conn.GetClusterStats()
 	if err != nil {
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/radoshttpd/rados"
+)
+
+// mockRados writes into memory after `latency`, and tracks writes in flight
+type mockRados struct {
+	latency     time.Duration
+	failAt      int64 // offset of write that fails, -1 if none
+	discard     bool  // don't keep data written
+	lock        sync.Mutex
+	data        []byte
+	inflight    int
+	maxInflight int
+}
+
+type mockWrite struct {
+	done chan int
+}
+
+func (w mockWrite) wait() int {
+	return <-w.done
+}
+
+func (m *mockRados) writeAsync(data []byte, offset uint64) (asyncWrite, error) {
+	m.lock.Lock()
+	m.inflight += 1
+	if m.inflight > m.maxInflight {
+		m.maxInflight = m.inflight
+	}
+	m.lock.Unlock()
+	w := mockWrite{done: make(chan int, 1)}
+	go func() {
+		time.Sleep(m.latency)
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		m.inflight -= 1
+		if int64(offset) == m.failAt {
+			w.done <- -5 // EIO
+			return
+		}
+		if m.discard {
+			w.done <- 0
+			return
+		}
+		if end := int(offset) + len(data); end > len(m.data) {
+			m.data = append(m.data, make([]byte, end-len(m.data))...)
+		}
+		copy(m.data[offset:], data)
+		w.done <- 0
+	}()
+	return w, nil
+}
+
+// memoryPools keeps objects in memory, written by streamPut like objects
+// of big file pools are
+type memoryPools struct {
+	lock    sync.Mutex
+	objects map[string][]byte // by pool/oid
+}
+
+// Cluster `name` storing objects in memory
+func newTestCluster(name string) *CephStorage {
+	return &CephStorage{
+		Name:       name,
+		CountMutex: new(sync.Mutex),
+		pools:      &memoryPools{objects: make(map[string][]byte)},
+	}
+}
+
+func (p *memoryPools) put(poolName string, oid string, data io.Reader) (int64, error) {
+	mock := &mockRados{failAt: -1}
+	size, err := streamPut(mock, data, MIN_CHUNK_SIZE, AIO_CONCURRENT)
+	if err != nil {
+		return 0, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.objects[poolName+"/"+oid] = append([]byte{}, mock.data...)
+	return size, nil
+}
+
+func (p *memoryPools) getReader(poolName string, oid string, startOffset int64,
+	length int64) (io.ReadCloser, error) {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	data, ok := p.objects[poolName+"/"+oid]
+	if !ok {
+		return nil, rados.RadosError(-int(syscall.ENOENT))
+	}
+	if startOffset > int64(len(data)) {
+		startOffset = int64(len(data))
+	}
+	if end := startOffset + length; end < int64(len(data)) {
+		data = data[:end]
+	}
+	return ioutil.NopCloser(bytes.NewReader(data[startOffset:])), nil
+}
+
+func (p *memoryPools) remove(poolName string, oid string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.objects[poolName+"/"+oid]; !ok {
+		return rados.RadosError(-int(syscall.ENOENT))
+	}
+	delete(p.objects, poolName+"/"+oid)
+	return nil
+}
+
+// Data of an object in pools of `cluster`, nil if it's absent
+func storedData(cluster *CephStorage, poolName, oid string) []byte {
+	p := cluster.pools.(*memoryPools)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.objects[poolName+"/"+oid]
+}
+
+// Reads at most 1000 bytes at a time, like a network connection
+type slowReader struct {
+	reader io.Reader
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	if len(p) > 1000 {
+		p = p[:1000]
+	}
+	return r.reader.Read(p)
+}
+
+func TestStreamPut(t *testing.T) {
+	data := make([]byte, 1<<20+123)
+	rand.Read(data)
+	for _, depth := range []int{1, 4} {
+		mock := &mockRados{latency: time.Millisecond, failAt: -1}
+		size, err := streamPut(mock, slowReader{bytes.NewReader(data)}, 64<<10, depth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(data)) || !bytes.Equal(mock.data, data) {
+			t.Errorf("depth %d: data written wrong, size %d", depth, size)
+		}
+		if mock.maxInflight > depth {
+			t.Errorf("depth %d: %d writes in flight", depth, mock.maxInflight)
+		}
+	}
+
+	mock := &mockRados{failAt: -1}
+	size, err := streamPut(mock, bytes.NewReader(nil), 64<<10, 4)
+	if err != nil || size != 0 {
+		t.Errorf("empty object: size %d, %v", size, err)
+	}
+}
+
+func TestStreamPutError(t *testing.T) {
+	data := make([]byte, 1<<20)
+	mock := &mockRados{failAt: 256 << 10}
+	if _, err := streamPut(mock, bytes.NewReader(data), 64<<10, 4); err == nil {
+		t.Error("write error should be returned")
+	}
+
+	readErr := errors.New("hehe")
+	reader := io.MultiReader(bytes.NewReader(data), &errReader{readErr})
+	mock = &mockRados{failAt: -1}
+	if _, err := streamPut(mock, reader, 64<<10, 4); err != readErr {
+		t.Errorf("expected read error, got %v", err)
+	}
+	if mock.inflight != 0 {
+		t.Errorf("%d writes left in flight", mock.inflight)
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func benchmarkStreamPut(b *testing.B, depth int) {
+	data := make([]byte, 32<<20)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		mock := &mockRados{latency: 2 * time.Millisecond, failAt: -1, discard: true}
+		_, err := streamPut(mock, bytes.NewReader(data), 4<<20, depth)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamPutDepth1(b *testing.B) {
+	benchmarkStreamPut(b, 1)
+}
+
+func BenchmarkStreamPutDepth4(b *testing.B) {
+	benchmarkStreamPut(b, 4)
+}
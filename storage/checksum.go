@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+)
+
+// Hashes payload as it's written to Ceph, along with MD5(ETag), so the
+// payload is read only once
+type payloadVerifier struct {
+	checksums    datatype.Checksums
+	sha256Writer hash.Hash // nil if ContentSha256 is not set
+	checksumHash hash.Hash // nil if Algorithm is not set
+}
+
+func newPayloadVerifier(checksums datatype.Checksums) *payloadVerifier {
+	v := &payloadVerifier{checksums: checksums}
+	if checksums.ContentSha256 != "" {
+		v.sha256Writer = sha256.New()
+	}
+	if checksums.Algorithm != "" {
+		v.checksumHash = datatype.NewChecksumHash(checksums.Algorithm)
+	}
+	return v
+}
+
+func (v *payloadVerifier) writer() io.Writer {
+	var writers []io.Writer
+	if v.sha256Writer != nil {
+		writers = append(writers, v.sha256Writer)
+	}
+	if v.checksumHash != nil {
+		writers = append(writers, v.checksumHash)
+	}
+	if len(writers) == 0 {
+		return ioutil.Discard
+	}
+	return io.MultiWriter(writers...)
+}
+
+// Should be called after the whole payload is written
+func (v *payloadVerifier) verify() error {
+	if v.sha256Writer != nil &&
+		hex.EncodeToString(v.sha256Writer.Sum(nil)) != v.checksums.ContentSha256 {
+		return ErrContentSHA256Mismatch
+	}
+	if v.checksumHash != nil &&
+		base64.StdEncoding.EncodeToString(v.checksumHash.Sum(nil)) != v.checksums.Checksum {
+		return ErrBadChecksum
+	}
+	return nil
+}
+
+// Checksums of PutObject are passed in metadata, like "md5Sum"
+func checksumsFromMetadata(metadata map[string]string) datatype.Checksums {
+	return datatype.Checksums{
+		ContentSha256: metadata["contentSha256"],
+		Algorithm:     metadata["checksumAlgorithm"],
+		Checksum:      metadata["checksum"],
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+)
+
+func TestPayloadVerifier(t *testing.T) {
+	sha256Hex := "0ebe2eca800cf7bd9d9d9f9f4aafbc0c77ae155f43bbbeca69cb256a24c7f9bb"
+	cases := []struct {
+		checksums datatype.Checksums
+		payload   string
+		expected  error
+	}{
+		{datatype.Checksums{}, "hehe", nil},
+		{datatype.Checksums{ContentSha256: sha256Hex}, "hehe", nil},
+		{datatype.Checksums{ContentSha256: sha256Hex}, "haha", ErrContentSHA256Mismatch},
+		{datatype.Checksums{Algorithm: datatype.CHECKSUM_CRC32, Checksum: "ATZ9Qw=="}, "hehe", nil},
+		{datatype.Checksums{Algorithm: datatype.CHECKSUM_CRC32C, Checksum: "lqFPJA=="}, "hehe", nil},
+		{datatype.Checksums{Algorithm: datatype.CHECKSUM_SHA1,
+			Checksum: "QlJbttOw3Aa7eK5UhzPo+7VURrM="}, "hehe", nil},
+		{datatype.Checksums{Algorithm: datatype.CHECKSUM_CRC32, Checksum: "ATZ9Qw=="}, "haha",
+			ErrBadChecksum},
+		// both are checked
+		{datatype.Checksums{ContentSha256: sha256Hex, Algorithm: datatype.CHECKSUM_CRC32C,
+			Checksum: "ATZ9Qw=="}, "hehe", ErrBadChecksum},
+	}
+	for _, c := range cases {
+		verifier := newPayloadVerifier(c.checksums)
+		io.Copy(verifier.writer(), strings.NewReader(c.payload))
+		if err := verifier.verify(); err != c.expected {
+			t.Errorf("%+v %s: expected %v, got %v", c.checksums, c.payload, c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+// Opens reader of a part, with its range and decryption applied
+type partOpener func() (io.ReadCloser, error)
+
+type decryptedPartReader struct {
+	io.Reader
+	io.Closer
+}
+
+// Range of a part to read, offset is relative to the start of the part
+type partRange struct {
+	part   *meta.Part
+	offset int64
+	length int64
+}
+
+// Intersections of parts of multipart `object` with
+// [startOffset, startOffset+length), empty ones are left out
+func partRangesOf(object *meta.Object, startOffset, length int64) (ranges []partRange) {
+	end := startOffset + length
+	low := object.PartsIndex.SearchLowerBound(startOffset)
+	if low == -1 {
+		low = 0
+	}
+	// parts number starts from 1
+	for i := low + 1; i <= len(object.Parts); i++ {
+		p := object.Parts[i]
+		if p.Offset >= end {
+			break
+		}
+		from, to := p.Offset, p.Offset+p.Size
+		if from < startOffset {
+			from = startOffset
+		}
+		if to > end {
+			to = end
+		}
+		if to <= from { // part ends before startOffset, or is empty
+			continue
+		}
+		ranges = append(ranges, partRange{
+			part:   p,
+			offset: from - p.Offset,
+			length: to - from,
+		})
+	}
+	return ranges
+}
+
+// Reads `length` bytes of rados object `oid` from `offset`
+type rangeReaderGetter func(oid string, offset, length int64) (io.ReadCloser, error)
+
+// Encrypted parts are read from the AES block `r.offset` falls in and then
+// decrypted, see wrapAlignedEncryptionReader
+func partOpenerOf(getReader rangeReaderGetter, r partRange,
+	encryptionKey []byte) partOpener {
+
+	if len(encryptionKey) == 0 {
+		return func() (io.ReadCloser, error) {
+			return getReader(r.part.ObjectId, r.offset, r.length)
+		}
+	}
+	return func() (io.ReadCloser, error) {
+		alignedOffset := r.offset / AES_BLOCK_SIZE * AES_BLOCK_SIZE
+		reader, err := getReader(r.part.ObjectId, alignedOffset,
+			r.length+r.offset-alignedOffset)
+		if err != nil {
+			return nil, err
+		}
+		decryptedReader, err := wrapAlignedEncryptionReader(reader, r.offset,
+			encryptionKey, r.part.InitializationVector)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		return decryptedPartReader{Reader: decryptedReader, Closer: reader}, nil
+	}
+}
+
+type prefetchedPart struct {
+	chunks chan []byte // closed when the part is read through or fails
+	err    error       // valid once `chunks` is closed
+}
+
+// Chunks of downloadBufPool each part read ahead could hold before reading
+// of it blocks, for buffers of `bufferSize` KB
+func prefetchBufferChunks(bufferSize int) int {
+	chunks := (bufferSize<<10 + MIN_CHUNK_SIZE - 1) / MIN_CHUNK_SIZE
+	if chunks < 1 {
+		return 1
+	}
+	return chunks
+}
+
+// Write parts opened by `openers` to `writer` in order, while at most
+// `window` parts after the one being written are read ahead, each buffering
+// up to `bufferChunks` chunks. Reading stops once `ctx` is done(e.g. client
+// disconnects) or `writer` fails, and no goroutine is left behind when it
+// returns
+func prefetchParts(ctx context.Context, openers []partOpener, window int,
+	bufferChunks int, writer io.Writer) error {
+
+	if window < 0 {
+		window = 0
+	}
+	if bufferChunks < 1 {
+		bufferChunks = 1
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// parts being read, including the one being written
+	slots := make(chan struct{}, window+1)
+	parts := make(chan *prefetchedPart, window+1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(parts)
+		for _, open := range openers {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			part := &prefetchedPart{
+				chunks: make(chan []byte, bufferChunks),
+			}
+			parts <- part
+			wg.Add(1)
+			go func(open partOpener) {
+				defer wg.Done()
+				part.err = readPart(ctx, open, part.chunks)
+				close(part.chunks)
+			}(open)
+		}
+	}()
+
+	for part := range parts {
+		for chunk := range part.chunks {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			_, err := writer.Write(chunk)
+			downloadBufPool.Put(chunk[:cap(chunk)])
+			if err != nil {
+				return err
+			}
+		}
+		if part.err != nil {
+			return part.err
+		}
+		<-slots
+	}
+	return ctx.Err()
+}
+
+func readPart(ctx context.Context, open partOpener, chunks chan<- []byte) error {
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		buf := downloadBufPool.Get().([]byte)
+		n, err := io.ReadFull(reader, buf)
+		if n == 0 {
+			downloadBufPool.Put(buf)
+		} else {
+			select {
+			case chunks <- buf[:n]:
+			case <-ctx.Done():
+				downloadBufPool.Put(buf)
+				return ctx.Err()
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+// partSource hands out readers of parts filled with their part number, and
+// counts parts opened and closed
+type partSource struct {
+	lock   sync.Mutex
+	opened int
+	closed int
+}
+
+type fakePartReader struct {
+	io.Reader
+	source *partSource
+}
+
+func (r fakePartReader) Close() error {
+	r.source.lock.Lock()
+	r.source.closed += 1
+	r.source.lock.Unlock()
+	return nil
+}
+
+func (s *partSource) openers(parts int, size int) []partOpener {
+	var openers []partOpener
+	for i := 0; i < parts; i++ {
+		i := i
+		openers = append(openers, func() (io.ReadCloser, error) {
+			s.lock.Lock()
+			s.opened += 1
+			s.lock.Unlock()
+			// filled once opened, parts never read take no memory
+			data := bytes.Repeat([]byte{byte(i)}, size)
+			return fakePartReader{Reader: bytes.NewReader(data), source: s}, nil
+		})
+	}
+	return openers
+}
+
+func (s *partSource) openedCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.opened
+}
+
+// slowWriter takes `latency` for each write, and records how many parts
+// have been opened ahead of the one being written
+type slowWriter struct {
+	latency  time.Duration
+	source   *partSource
+	buf      bytes.Buffer
+	maxAhead int
+	onWrite  func()
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.latency)
+	if ahead := w.source.openedCount() - 1 - int(p[0]); ahead > w.maxAhead {
+		w.maxAhead = ahead
+	}
+	if w.onWrite != nil {
+		w.onWrite()
+	}
+	return w.buf.Write(p)
+}
+
+func TestPrefetchParts(t *testing.T) {
+	const parts, size = 6, MIN_CHUNK_SIZE*2 + 123
+	for _, window := range []int{0, 2} {
+		source := &partSource{}
+		writer := &slowWriter{latency: 5 * time.Millisecond, source: source}
+		err := prefetchParts(context.Background(), source.openers(parts, size),
+			window, 8, writer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []byte
+		for i := 0; i < parts; i++ {
+			expected = append(expected, bytes.Repeat([]byte{byte(i)}, size)...)
+		}
+		if !bytes.Equal(writer.buf.Bytes(), expected) {
+			t.Errorf("window %d: parts written wrong", window)
+		}
+		// parts are read while previous ones being written, but no more
+		// than `window` ahead
+		if writer.maxAhead != window {
+			t.Errorf("window %d: %d parts read ahead", window, writer.maxAhead)
+		}
+		if source.opened != parts || source.closed != parts {
+			t.Errorf("window %d: %d parts opened, %d closed", window,
+				source.opened, source.closed)
+		}
+	}
+}
+
+func TestPrefetchPartsCancel(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	source := &partSource{}
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := &slowWriter{latency: time.Millisecond, source: source, onWrite: cancel}
+	err := prefetchParts(ctx, source.openers(100, MIN_CHUNK_SIZE*20), 4, 8, writer)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if source.opened == 100 || source.opened != source.closed {
+		t.Errorf("%d parts opened, %d closed", source.opened, source.closed)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines leaked", n-goroutines)
+	}
+
+	// writer fails
+	source = &partSource{}
+	err = prefetchParts(context.Background(), source.openers(100, MIN_CHUNK_SIZE*20), 4,
+		8, failWriter{})
+	if err != io.ErrShortWrite {
+		t.Errorf("expected write error, got %v", err)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines leaked", n-goroutines)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+// Multipart object of parts sized `sizes`, along with its plaintext and
+// rados objects of parts, which are encrypted if `key` is set
+func multipartObject(t *testing.T, sizes []int, key []byte) (*meta.Object,
+	[]byte, map[string][]byte) {
+
+	object := &meta.Object{Parts: make(map[int]*meta.Part)}
+	index := &meta.SimpleIndex{}
+	stored := make(map[string][]byte)
+	var plain []byte
+	for i, size := range sizes {
+		data := make([]byte, size)
+		rand.Read(data)
+		part := &meta.Part{
+			PartNumber: i + 1,
+			Size:       int64(size),
+			ObjectId:   "part" + strconv.Itoa(i+1),
+			Offset:     int64(len(plain)),
+		}
+		stored[part.ObjectId] = data
+		if key != nil {
+			initializationVector, err := newInitializationVector()
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.InitializationVector = initializationVector
+			stored[part.ObjectId] = encrypt(t, data, key, initializationVector)
+		}
+		object.Parts[i+1] = part
+		index.Index = append(index.Index, part.Offset)
+		plain = append(plain, data...)
+	}
+	object.PartsIndex = index
+	object.Size = int64(len(plain))
+	return object, plain, stored
+}
+
+func TestMultipartRangeRead(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	// parts on, across and within AES block boundaries
+	sizes := []int{20, 33, 16, 1, 47}
+	for _, encryptionKey := range [][]byte{nil, key} {
+		object, plain, stored := multipartObject(t, sizes, encryptionKey)
+		getReader := func(oid string, offset, length int64) (io.ReadCloser, error) {
+			data := stored[oid]
+			if offset+length > int64(len(data)) {
+				t.Fatalf("%s: read [%d, %d) beyond %d bytes", oid, offset,
+					offset+length, len(data))
+			}
+			return ioutil.NopCloser(bytes.NewReader(data[offset : offset+length])), nil
+		}
+		// every range of the object, which covers reads starting or ending
+		// on part boundaries, within parts and spanning all parts
+		for start := int64(0); start < object.Size; start++ {
+			for length := int64(1); start+length <= object.Size; length++ {
+				var openers []partOpener
+				for _, r := range partRangesOf(object, start, length) {
+					openers = append(openers, partOpenerOf(getReader, r, encryptionKey))
+				}
+				var buf bytes.Buffer
+				err := prefetchParts(context.Background(), openers, 1, 1, &buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf.Bytes(), plain[start:start+length]) {
+					t.Fatalf("encrypted %v: range [%d, %d) read wrong",
+						encryptionKey != nil, start, start+length)
+				}
+			}
+		}
+	}
+}
+
+func TestPartRangesOf(t *testing.T) {
+	object, _, _ := multipartObject(t, []int{10, 10, 10}, nil)
+	cases := []struct {
+		start, length int64
+		expected      []partRange
+	}{
+		{0, 30, []partRange{{object.Parts[1], 0, 10}, {object.Parts[2], 0, 10},
+			{object.Parts[3], 0, 10}}},
+		{10, 10, []partRange{{object.Parts[2], 0, 10}}},
+		{9, 2, []partRange{{object.Parts[1], 9, 1}, {object.Parts[2], 0, 1}}},
+		{15, 1, []partRange{{object.Parts[2], 5, 1}}},
+		{19, 11, []partRange{{object.Parts[2], 9, 1}, {object.Parts[3], 0, 10}}},
+	}
+	for _, c := range cases {
+		ranges := partRangesOf(object, c.start, c.length)
+		if len(ranges) != len(c.expected) {
+			t.Errorf("[%d, %d): expected %v, got %v", c.start, c.start+c.length,
+				c.expected, ranges)
+			continue
+		}
+		for i := range ranges {
+			if ranges[i] != c.expected[i] {
+				t.Errorf("[%d, %d): expected %v, got %v", c.start,
+					c.start+c.length, c.expected, ranges)
+			}
+		}
+	}
+}
+
+func TestPrefetchBufferChunks(t *testing.T) {
+	for size, chunks := range map[int]int{
+		-1: 1, 0: 1, 1: 1, 512: 1, 513: 2, 4096: 8,
+	} {
+		if got := prefetchBufferChunks(size); got != chunks {
+			t.Errorf("buffer of %d KB: expected %d chunks, got %d", size, chunks, got)
+		}
+	}
+}
+
+// Reader of a Ceph object, every read takes `latency`
+type slowPartReader struct {
+	reader  io.Reader
+	latency time.Duration
+}
+
+func (r slowPartReader) Read(p []byte) (int, error) {
+	time.Sleep(r.latency)
+	return r.reader.Read(p)
+}
+
+func (r slowPartReader) Close() error {
+	return nil
+}
+
+// Read a 50MB object of 10 parts, with `window` parts read ahead
+func benchmarkPrefetchParts(b *testing.B, window int) {
+	const parts, size = 10, 5 << 20
+	data := make([]byte, size)
+	var openers []partOpener
+	for i := 0; i < parts; i++ {
+		openers = append(openers, func() (io.ReadCloser, error) {
+			return slowPartReader{reader: bytes.NewReader(data),
+				latency: 2 * time.Millisecond}, nil
+		})
+	}
+	b.SetBytes(parts * size)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := prefetchParts(context.Background(), openers, window,
+			prefetchBufferChunks(4096), ioutil.Discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPrefetchPartsSequential(b *testing.B) {
+	benchmarkPrefetchParts(b, 0)
+}
+
+func BenchmarkPrefetchParts2(b *testing.B) {
+	benchmarkPrefetchParts(b, 2)
+}
+
+func BenchmarkPrefetchParts4(b *testing.B) {
+	benchmarkPrefetchParts(b, 4)
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+
+	"github.com/journeymidnight/yig/helper"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+// With CONFIG.EnableDualWrite set, objects no smaller than BIG_FILE_THRESHOLD
+// are written to two Ceph clusters at the same time, and the second copy is
+// saved as a replica of the object. Should one of the writes fail, the object
+// is kept in the cluster written or the upload fails, depending on
+// CONFIG.DualWriteFailurePolicy.
+
+const (
+	DUAL_WRITE_POLICY_SINGLE = "single"
+	DUAL_WRITE_POLICY_FAIL   = "fail"
+)
+
+var errAllWritersFailed = errors.New("all writers failed")
+
+// writerMultiplexer writes the same data to all of its writers. Writers that
+// fail are dropped and the rest keep being written to, it fails only if
+// none is left
+type writerMultiplexer struct {
+	writers []io.Writer
+	errs    []error
+}
+
+func newWriterMultiplexer(writers ...io.Writer) *writerMultiplexer {
+	return &writerMultiplexer{
+		writers: writers,
+		errs:    make([]error, len(writers)),
+	}
+}
+
+func (m *writerMultiplexer) Write(p []byte) (n int, err error) {
+	alive := 0
+	for i, w := range m.writers {
+		if m.errs[i] != nil {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			m.errs[i] = err
+			continue
+		}
+		alive += 1
+	}
+	if alive == 0 {
+		return 0, errAllWritersFailed
+	}
+	return len(p), nil
+}
+
+// Run puts concurrently, each reads the whole of data. Returns bytes written
+// and error of each put
+func teePut(data io.Reader, puts ...func(io.Reader) (int64, error)) (sizes []int64,
+	errs []error) {
+
+	sizes, errs = make([]int64, len(puts)), make([]error, len(puts))
+	writers := make([]io.Writer, len(puts))
+	pipes := make([]*io.PipeWriter, len(puts))
+	var wg sync.WaitGroup
+	for i, put := range puts {
+		reader, writer := io.Pipe()
+		writers[i], pipes[i] = writer, writer
+		wg.Add(1)
+		go func(i int, put func(io.Reader) (int64, error)) {
+			defer wg.Done()
+			sizes[i], errs[i] = put(reader)
+			// writes to a put returned early fail instead of blocking
+			reader.CloseWithError(errs[i])
+		}(i, put)
+	}
+	m := newWriterMultiplexer(writers...)
+	_, err := io.Copy(m, data)
+	for _, pipe := range pipes {
+		pipe.CloseWithError(err) // puts get EOF if err is nil
+	}
+	wg.Wait()
+	return
+}
+
+// Another healthy cluster than primary to write replicas to, nil if there
+// is none
+func (yig *YigStorage) pickReplicaCluster(primary *CephStorage) *CephStorage {
+	var candidates []*CephStorage
+	for fsid, cluster := range yig.DataStorage {
+		if cluster == primary || !yig.clusterHealthy(fsid) {
+			continue
+		}
+		candidates = append(candidates, cluster)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// Write data as oid to cluster, and to another cluster as well if dual write
+// applies to objects of size. Returns the cluster that holds the object and
+// the replicas written besides it
+func (yig *YigStorage) putObjectData(cluster *CephStorage, poolName, oid string,
+	size int64, data io.Reader) (written int64, primary *CephStorage,
+	replicas []meta.Replica, err error) {
+
+	var replica *CephStorage
+	if helper.CONFIG.EnableDualWrite && size >= BIG_FILE_THRESHOLD {
+		replica = yig.pickReplicaCluster(cluster)
+	}
+	if replica == nil {
+		written, err = cluster.Put(poolName, oid, data)
+		return written, cluster, nil, err
+	}
+
+	clusters := []*CephStorage{cluster, replica}
+	sizes, errs := teePut(data,
+		func(r io.Reader) (int64, error) { return cluster.Put(poolName, oid, r) },
+		func(r io.Reader) (int64, error) { return replica.Put(poolName, oid, r) })
+	if errs[0] == nil && errs[1] == nil {
+		return sizes[0], cluster, []meta.Replica{{Location: replica.Name, Pool: poolName,
+			ObjectId: oid}}, nil
+	}
+	if errs[0] != nil && errs[1] != nil {
+		// the reader failed, or both clusters did. Data written to cluster
+		// is left to the caller as if it were written alone
+		RecycleQueue <- objectToRecycle{location: replica.Name, pool: poolName, objectId: oid}
+		return sizes[0], cluster, nil, errs[0]
+	}
+	succeeded, failed := 0, 1
+	if errs[0] != nil {
+		succeeded, failed = 1, 0
+	}
+	helper.Logger.Println(5, "Failed to write", poolName, oid, "to cluster",
+		clusters[failed].Name, "with error", errs[failed], ", written to cluster",
+		clusters[succeeded].Name, "only")
+	RecycleQueue <- objectToRecycle{location: clusters[failed].Name, pool: poolName, objectId: oid}
+	if helper.CONFIG.DualWriteFailurePolicy == DUAL_WRITE_POLICY_FAIL {
+		RecycleQueue <- objectToRecycle{location: clusters[succeeded].Name, pool: poolName,
+			objectId: oid}
+		return sizes[succeeded], clusters[succeeded], nil, errs[failed]
+	}
+	return sizes[succeeded], clusters[succeeded], nil, nil
+}
+
+// Recycle data of object and its replicas, e.g. when its metadata failed
+// to update
+func recycleObjectData(object objectToRecycle, replicas []meta.Replica) {
+	RecycleQueue <- object
+	for _, replica := range replicas {
+		RecycleQueue <- objectToRecycle{location: replica.Location, pool: replica.Pool,
+			objectId: replica.ObjectId}
+	}
+}
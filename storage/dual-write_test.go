@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// put that reads all data into buf
+func bufferPut(buf *bytes.Buffer) func(io.Reader) (int64, error) {
+	return func(r io.Reader) (int64, error) {
+		return io.Copy(buf, r)
+	}
+}
+
+// put that fails after reading n bytes
+func brokenPut(n int64) func(io.Reader) (int64, error) {
+	return func(r io.Reader) (int64, error) {
+		written, _ := io.CopyN(ioutil.Discard, r, n)
+		return written, errors.New("broken cluster")
+	}
+}
+
+type failingReader struct {
+	io.Reader
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestTeePut(t *testing.T) {
+	data := strings.Repeat("hehe", 100000)
+
+	var a, b bytes.Buffer
+	sizes, errs := teePut(strings.NewReader(data), bufferPut(&a), bufferPut(&b))
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	}
+	if sizes[0] != int64(len(data)) || sizes[1] != int64(len(data)) ||
+		a.String() != data || b.String() != data {
+		t.Errorf("data should be written to both, got %v", sizes)
+	}
+
+	// the other keeps being written to if one fails, at either side
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		puts := []func(io.Reader) (int64, error){bufferPut(&buf), brokenPut(1000)}
+		if i == 1 {
+			puts[0], puts[1] = puts[1], puts[0]
+		}
+		sizes, errs = teePut(strings.NewReader(data), puts...)
+		if errs[i] != nil || sizes[i] != int64(len(data)) || buf.String() != data {
+			t.Errorf("%d: data should be written despite the failed put, got %v %v", i, sizes, errs)
+		}
+		if errs[1-i] == nil {
+			t.Errorf("%d: failed put should be reported", i)
+		}
+	}
+
+	a.Reset()
+	b.Reset()
+	sizes, errs = teePut(failingReader{strings.NewReader(data)}, bufferPut(&a), bufferPut(&b))
+	if errs[0] == nil || errs[1] == nil {
+		t.Errorf("failed read should fail both puts, got %v %v", sizes, errs)
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/redis"
+)
+
+const (
+	// used if CONFIG.HealthCheckTimeout is not set
+	HEALTH_CHECK_TIMEOUT = 2 * time.Second
+	// reuse last result within this duration, so frequent probes from
+	// load balancers won't hammer dependencies
+	HEALTH_CACHE_DURATION = 2 * time.Second
+	// how often Ceph clusters are pinged for PickOneClusterAndPool
+	CLUSTER_HEALTH_CHECK_INTERVAL = 10 * time.Second
+)
+
+type ComponentHealth struct {
+	Name    string
+	Healthy bool
+	Error   string `json:",omitempty"`
+}
+
+type HealthStatus struct {
+	Healthy    bool
+	CheckedAt  time.Time
+	Components []ComponentHealth
+}
+
+var (
+	healthLock sync.Mutex
+	lastHealth HealthStatus
+)
+
+func checkWithTimeout(timeout time.Duration, check func() error) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- check()
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return errors.New("timeout after " + timeout.String())
+	}
+}
+
+func healthCheckTimeout() time.Duration {
+	if helper.CONFIG.HealthCheckTimeout > 0 {
+		return helper.CONFIG.HealthCheckTimeout
+	}
+	return HEALTH_CHECK_TIMEOUT
+}
+
+// Check connectivity of metadata store, Redis(if enabled) and all Ceph
+// clusters concurrently, results are cached for HEALTH_CACHE_DURATION
+func (yig *YigStorage) CheckHealth() HealthStatus {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	if time.Since(lastHealth.CheckedAt) < HEALTH_CACHE_DURATION {
+		return lastHealth
+	}
+
+	timeout := healthCheckTimeout()
+	checks := map[string]func() error{
+		helper.CONFIG.MetaStore: func() error {
+			return yig.MetaStorage.Client.Ping(timeout)
+		},
+	}
+	if RedisEnabled() {
+		checks["redis"] = redis.Ping
+	}
+	for name, cluster := range yig.DataStorage {
+		checks["ceph:"+name] = cluster.Ping
+	}
+
+	status := HealthStatus{Healthy: true}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			component := ComponentHealth{Name: name, Healthy: true}
+			err := checkWithTimeout(timeout, check)
+			if err != nil {
+				component.Healthy = false
+				component.Error = err.Error()
+				helper.Logger.Println(5, "Health check failed for", name, "with error", err)
+			}
+			lock.Lock()
+			status.Components = append(status.Components, component)
+			status.Healthy = status.Healthy && component.Healthy
+			lock.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	sort.Slice(status.Components, func(i, j int) bool {
+		return status.Components[i].Name < status.Components[j].Name
+	})
+	status.CheckedAt = time.Now()
+	lastHealth = status
+	return status
+}
+
+// Clusters are healthy until a check says otherwise
+func (yig *YigStorage) clusterHealthy(fsid string) bool {
+	healthy, ok := yig.clusterHealth.Load(fsid)
+	return !ok || healthy.(bool)
+}
+
+// Ping all clusters concurrently and record which are reachable
+func (yig *YigStorage) checkClusters(ping func(cluster *CephStorage) error) {
+	timeout := healthCheckTimeout()
+	var wg sync.WaitGroup
+	for fsid, cluster := range yig.DataStorage {
+		wg.Add(1)
+		go func(fsid string, cluster *CephStorage) {
+			defer wg.Done()
+			err := checkWithTimeout(timeout, func() error { return ping(cluster) })
+			if err != nil && yig.clusterHealthy(fsid) {
+				helper.Logger.Println(5, "Ceph cluster", fsid, "is unavailable:", err)
+			} else if err == nil && !yig.clusterHealthy(fsid) {
+				helper.Logger.Println(5, "Ceph cluster", fsid, "is available again")
+			}
+			yig.clusterHealth.Store(fsid, err == nil)
+		}(fsid, cluster)
+	}
+	wg.Wait()
+}
+
+func initializeClusterHealthChecker(yig *YigStorage) {
+	go func() {
+		for !yig.Stopping {
+			yig.checkClusters((*CephStorage).Ping)
+			time.Sleep(CLUSTER_HEALTH_CHECK_INTERVAL)
+		}
+	}()
+}
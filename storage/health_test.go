@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/helper"
+)
+
+// pingClient pings database with `ping`, and signals `pinged` once it
+// returns, so checks timed out could be waited for
+type pingClient struct {
+	*fakeClient
+	ping   func() error
+	pinged chan struct{}
+}
+
+// Storage with a fresh pingClient, and no cached health status
+func newPingTestStorage(ping func() error) (*YigStorage, *pingClient) {
+	c := &pingClient{fakeClient: newFakeClient(), ping: ping, pinged: make(chan struct{}, 10)}
+	lastHealth.CheckedAt = time.Time{}
+	return newTestStorage(c), c
+}
+
+func (c *pingClient) Ping(timeout time.Duration) error {
+	defer func() { c.pinged <- struct{}{} }()
+	return c.ping()
+}
+
+func TestCheckHealth(t *testing.T) {
+	helper.CONFIG.MetaStore = "hbase"
+	yig, _ := newPingTestStorage(func() error { return nil })
+	status := yig.CheckHealth()
+	if !status.Healthy || len(status.Components) != 1 || status.Components[0].Name != "hbase" {
+		t.Fatalf("expected healthy hbase, got %+v", status)
+	}
+
+	// cached result is returned within HEALTH_CACHE_DURATION
+	refused := func() error { return errors.New("connection refused") }
+	yig = newTestStorage(&pingClient{fakeClient: newFakeClient(), ping: refused,
+		pinged: make(chan struct{}, 10)})
+	if status = yig.CheckHealth(); !status.Healthy {
+		t.Errorf("expected cached healthy status, got %+v", status)
+	}
+
+	yig, _ = newPingTestStorage(refused)
+	status = yig.CheckHealth()
+	if status.Healthy || status.Components[0].Error != "connection refused" {
+		t.Errorf("expected unhealthy hbase, got %+v", status)
+	}
+
+	// slow pings are left running, and waited for before the next case
+	slow := func(release chan struct{}) func() error {
+		return func() error {
+			<-release
+			return nil
+		}
+	}
+	release := make(chan struct{})
+	yig, c := newPingTestStorage(slow(release))
+	status = yig.CheckHealth()
+	if status.Healthy {
+		t.Errorf("expected slow hbase to be unhealthy, got %+v", status)
+	}
+	close(release)
+	<-c.pinged
+
+	// timeout is configurable
+	helper.CONFIG.HealthCheckTimeout = 100 * time.Millisecond
+	defer func() { helper.CONFIG.HealthCheckTimeout = 0 }()
+	release = make(chan struct{})
+	yig, c = newPingTestStorage(slow(release))
+	start := time.Now()
+	status = yig.CheckHealth()
+	if status.Healthy || time.Since(start) > HEALTH_CHECK_TIMEOUT {
+		t.Errorf("expected hbase to time out in 100ms, got %+v in %v",
+			status, time.Since(start))
+	}
+	close(release)
+	<-c.pinged
+}
+
+func TestPickClusterSkipsUnhealthy(t *testing.T) {
+	yig := newTestStorage(newFakeClient())
+	yig.DataStorage = map[string]*CephStorage{
+		"up":   {Name: "up"},
+		"down": {Name: "down"},
+	}
+	// used space of clusters is not checked
+	latestQueryTime[1] = time.Now()
+
+	yig.checkClusters(func(cluster *CephStorage) error {
+		if cluster.Name == "down" {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	for i := 0; i < 100; i++ {
+		cluster, _ := yig.PickOneClusterAndPool("bucket", "object", -1, "")
+		if cluster.Name != "up" {
+			t.Fatal("unhealthy cluster should not be picked")
+		}
+	}
+
+	// all clusters are down, any of them is picked
+	yig.checkClusters(func(cluster *CephStorage) error {
+		return errors.New("connection refused")
+	})
+	if cluster, _ := yig.PickOneClusterAndPool("bucket", "object", -1, ""); cluster == nil {
+		t.Error("a cluster should be picked even if all are down")
+	}
+
+	yig.checkClusters(func(cluster *CephStorage) error { return nil })
+	picked := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		cluster, _ := yig.PickOneClusterAndPool("bucket", "object", -1, "")
+		picked[cluster.Name] = true
+	}
+	if !picked["down"] {
+		t.Error("recovered cluster should be picked again")
+	}
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/hex"
@@ -9,7 +10,6 @@ import (
 	"github.com/journeymidnight/yig/helper"
 	"github.com/journeymidnight/yig/iam"
 	meta "github.com/journeymidnight/yig/meta/types"
-	"github.com/journeymidnight/yig/redis"
 	"github.com/journeymidnight/yig/signature"
 	"io"
 	"net/url"
@@ -18,32 +18,16 @@ import (
 	"time"
 )
 
-const (
-	MAX_PART_SIZE   = 5 << 30   // 5GB
-	MIN_PART_SIZE   = 128 << 10 // 128KB
-	MAX_PART_NUMBER = 10000
-)
-
-func (yig *YigStorage) ListMultipartUploads(credential iam.Credential, bucketName string,
+func (yig *YigStorage) ListMultipartUploads(ctx context.Context, credential iam.Credential, bucketName string,
 	request datatype.ListUploadsRequest) (result datatype.ListMultipartUploadsResponse, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
 	if err != nil {
 		return
 	}
-	switch bucket.ACL.CannedAcl {
-	case "public-read", "public-read-write":
-		break
-	case "authenticated-read":
-		if credential.UserId == "" {
-			err = ErrBucketAccessForbidden
-			return
-		}
-	default:
-		if bucket.OwnerId != credential.UserId {
-			err = ErrBucketAccessForbidden
-			return
-		}
+	if !yig.canListObjectsOf(ctx, bucket, credential) {
+		err = ErrBucketAccessForbidden
+		return
 	}
 	// TODO policy and fancy ACL
 
@@ -53,8 +37,10 @@ func (yig *YigStorage) ListMultipartUploads(credential iam.Credential, bucketNam
 	}
 	result.IsTruncated = isTruncated
 	result.Uploads = uploads
-	result.NextKeyMarker = nextKeyMarker
-	result.NextUploadIdMarker = nextUploadIdMarker
+	if isTruncated {
+		result.NextKeyMarker = nextKeyMarker
+		result.NextUploadIdMarker = nextUploadIdMarker
+	}
 
 	sort.Strings(prefixes)
 	for _, prefix := range prefixes {
@@ -79,7 +65,7 @@ func (yig *YigStorage) ListMultipartUploads(credential iam.Credential, bucketNam
 	return
 }
 
-func (yig *YigStorage) NewMultipartUpload(credential iam.Credential, bucketName, objectName string,
+func (yig *YigStorage) NewMultipartUpload(ctx context.Context, credential iam.Credential, bucketName, objectName string,
 	metadata map[string]string, acl datatype.Acl,
 	sseRequest datatype.SseRequest) (uploadId string, err error) {
 
@@ -87,15 +73,14 @@ func (yig *YigStorage) NewMultipartUpload(credential iam.Credential, bucketName,
 	if err != nil {
 		return
 	}
-	switch bucket.ACL.CannedAcl {
-	case "public-read-write":
-		break
-	default:
-		if bucket.OwnerId != credential.UserId {
-			return "", ErrBucketAccessForbidden
-		}
+	if !yig.canWriteObjectsOf(ctx, bucket, credential) {
+		return "", ErrBucketAccessForbidden
 	}
 	// TODO policy and fancy ACL
+	// parts are encrypted as decided here, so is the completed object
+	if sseRequest.Type == "" {
+		sseRequest = bucket.Encryption.SseRequest()
+	}
 
 	contentType, ok := metadata["Content-Type"]
 	if !ok {
@@ -105,16 +90,20 @@ func (yig *YigStorage) NewMultipartUpload(credential iam.Credential, bucketName,
 	if err != nil {
 		return
 	}
-	cephCluster, pool := yig.PickOneClusterAndPool(bucketName, objectName, -1)
+	storageClass := helper.Ternary(metadata["storageClass"] == "",
+		datatype.STORAGE_CLASS_STANDARD, metadata["storageClass"]).(string)
+	cephCluster, pool := yig.PickOneClusterAndPool(bucketName, objectName, -1, storageClass)
 	multipartMetadata := meta.MultipartMetadata{
-		InitiatorId: credential.UserId,
-		OwnerId:     bucket.OwnerId,
-		ContentType: contentType,
-		Location:    cephCluster.Name,
-		Pool:        pool,
-		Acl:         acl,
-		SseRequest:  sseRequest,
-		Attrs:       attrs,
+		InitiatorId:  credential.UserId,
+		OwnerId:      bucket.OwnerId,
+		ContentType:  contentType,
+		Location:     cephCluster.Name,
+		Pool:         pool,
+		Acl:          acl,
+		SseRequest:   sseRequest,
+		Attrs:        attrs,
+		StorageClass: storageClass,
+		Ttl:          ttlOf(metadata),
 	}
 	if sseRequest.Type == "S3" {
 		multipartMetadata.EncryptionKey, err = encryptionKeyFromSseRequest(sseRequest)
@@ -140,8 +129,8 @@ func (yig *YigStorage) NewMultipartUpload(credential iam.Credential, bucketName,
 	return
 }
 
-func (yig *YigStorage) PutObjectPart(bucketName, objectName string, credential iam.Credential,
-	uploadId string, partId int, size int64, data io.Reader, md5Hex string,
+func (yig *YigStorage) PutObjectPart(ctx context.Context, bucketName, objectName string, credential iam.Credential,
+	uploadId string, partId int, size int64, data io.Reader, md5Hex string, checksums datatype.Checksums,
 	sseRequest datatype.SseRequest) (result datatype.PutObjectPartResult, err error) {
 
 	multipart, err := yig.MetaStorage.GetMultipart(bucketName, objectName, uploadId)
@@ -149,11 +138,21 @@ func (yig *YigStorage) PutObjectPart(bucketName, objectName string, credential i
 		return
 	}
 
-	if size > MAX_PART_SIZE {
+	if size > meta.MAX_PART_SIZE {
 		err = ErrEntityTooLarge
 		return
 	}
 
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	if err != nil {
+		return
+	}
+	limiter := yig.getBucketLimiter(bucket)
+	err = limiter.allowWrite()
+	if err != nil {
+		return
+	}
+
 	var encryptionKey []byte
 	switch multipart.Metadata.SseRequest.Type {
 	case "":
@@ -172,14 +171,15 @@ func (yig *YigStorage) PutObjectPart(bucketName, objectName string, credential i
 	}
 
 	md5Writer := md5.New()
-	limitedDataReader := io.LimitReader(data, size)
+	limitedDataReader := limiter.limitWrite(ctx, io.LimitReader(data, size))
 	poolName := multipart.Metadata.Pool
 	cephCluster, err := yig.GetClusterByFsName(multipart.Metadata.Location)
 	if err != nil {
 		return
 	}
 	oid := cephCluster.GetUniqUploadName()
-	dataReader := io.TeeReader(limitedDataReader, md5Writer)
+	verifier := newPayloadVerifier(checksums)
+	dataReader := io.TeeReader(limitedDataReader, io.MultiWriter(md5Writer, verifier.writer()))
 
 	var initializationVector []byte
 	if len(encryptionKey) != 0 {
@@ -216,6 +216,10 @@ func (yig *YigStorage) PutObjectPart(bucketName, objectName string, credential i
 		err = ErrBadDigest
 		return
 	}
+	if err = verifier.verify(); err != nil {
+		RecycleQueue <- maybeObjectToRecycle
+		return
+	}
 
 	if signVerifyReader, ok := data.(*signature.SignVerifyReader); ok {
 		credential, err = signVerifyReader.Verify()
@@ -225,19 +229,9 @@ func (yig *YigStorage) PutObjectPart(bucketName, objectName string, credential i
 		}
 	}
 
-	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
-	if err != nil {
+	if !yig.canWriteToUpload(ctx, bucket, multipart, credential) {
 		RecycleQueue <- maybeObjectToRecycle
-		return
-	}
-	switch bucket.ACL.CannedAcl {
-	case "public-read-write":
-		break
-	default:
-		if bucket.OwnerId != credential.UserId {
-			RecycleQueue <- maybeObjectToRecycle
-			return result, ErrBucketAccessForbidden
-		}
+		return result, ErrBucketAccessForbidden
 	} // TODO policy and fancy ACL
 
 	part := meta.Part{
@@ -275,7 +269,7 @@ func (yig *YigStorage) PutObjectPart(bucketName, objectName string, credential i
 	return result, nil
 }
 
-func (yig *YigStorage) CopyObjectPart(bucketName, objectName, uploadId string, partId int,
+func (yig *YigStorage) CopyObjectPart(ctx context.Context, bucketName, objectName, uploadId string, partId int,
 	size int64, data io.Reader, credential iam.Credential,
 	sseRequest datatype.SseRequest) (result datatype.PutObjectResult, err error) {
 
@@ -284,7 +278,7 @@ func (yig *YigStorage) CopyObjectPart(bucketName, objectName, uploadId string, p
 		return
 	}
 
-	if size > MAX_PART_SIZE {
+	if size > meta.MAX_PART_SIZE {
 		err = ErrEntityTooLarge
 		return
 	}
@@ -353,15 +347,10 @@ func (yig *YigStorage) CopyObjectPart(bucketName, objectName, uploadId string, p
 		RecycleQueue <- maybeObjectToRecycle
 		return
 	}
-	switch bucket.ACL.CannedAcl {
-	case "public-read-write":
-		break
-	default:
-		if bucket.OwnerId != credential.UserId {
-			RecycleQueue <- maybeObjectToRecycle
-			err = ErrBucketAccessForbidden
-			return
-		}
+	if !yig.canWriteToUpload(ctx, bucket, multipart, credential) {
+		RecycleQueue <- maybeObjectToRecycle
+		err = ErrBucketAccessForbidden
+		return
 	} // TODO policy and fancy ACL
 
 	if initializationVector == nil {
@@ -400,7 +389,7 @@ func (yig *YigStorage) CopyObjectPart(bucketName, objectName, uploadId string, p
 	return result, nil
 }
 
-func (yig *YigStorage) ListObjectParts(credential iam.Credential, bucketName, objectName string,
+func (yig *YigStorage) ListObjectParts(ctx context.Context, credential iam.Credential, bucketName, objectName string,
 	request datatype.ListPartsRequest) (result datatype.ListPartsResponse, err error) {
 
 	multipart, err := yig.MetaStorage.GetMultipart(bucketName, objectName, request.UploadId)
@@ -411,15 +400,20 @@ func (yig *YigStorage) ListObjectParts(credential iam.Credential, bucketName, ob
 	initiatorId := multipart.Metadata.InitiatorId
 	ownerId := multipart.Metadata.OwnerId
 
-	switch multipart.Metadata.Acl.CannedAcl {
-	case "public-read", "public-read-write":
+	// the initiator could always list parts of their own upload
+	switch {
+	case credential.UserId != "" && credential.UserId == initiatorId:
+		break
+	case multipart.Metadata.Acl.CannedAcl == "public-read",
+		multipart.Metadata.Acl.CannedAcl == "public-read-write":
 		break
-	case "authenticated-read":
+	case multipart.Metadata.Acl.CannedAcl == "authenticated-read":
 		if credential.UserId == "" {
 			err = ErrAccessDenied
 			return
 		}
-	case "bucket-owner-read", "bucket-owner-full-controll":
+	case multipart.Metadata.Acl.CannedAcl == "bucket-owner-read",
+		multipart.Metadata.Acl.CannedAcl == "bucket-owner-full-controll":
 		var bucket meta.Bucket
 		bucket, err = yig.MetaStorage.GetBucket(bucketName, true)
 		if err != nil {
@@ -435,25 +429,25 @@ func (yig *YigStorage) ListObjectParts(credential iam.Credential, bucketName, ob
 			return
 		}
 	}
-	for i := request.PartNumberMarker + 1; i <= MAX_PART_NUMBER; i++ {
-		if p, ok := multipart.Parts[i]; ok {
-			part := datatype.Part{
-				PartNumber:   i,
-				ETag:         "\"" + p.Etag + "\"",
-				LastModified: p.LastModified,
-				Size:         p.Size,
-			}
-			result.Parts = append(result.Parts, part)
-
-			if len(result.Parts) > request.MaxParts {
-				break
-			}
+	// parts after the marker, the marker itself is excluded. One more part
+	// than requested means there are more to list, starting after the last
+	// one returned
+	for i := request.PartNumberMarker + 1; i <= meta.MAX_PART_NUMBER; i++ {
+		p, ok := multipart.Parts[i]
+		if !ok {
+			continue
 		}
-	}
-	if len(result.Parts) == request.MaxParts+1 {
-		result.IsTruncated = true
-		result.NextPartNumberMarker = result.Parts[request.MaxParts].PartNumber
-		result.Parts = result.Parts[:request.MaxParts]
+		if len(result.Parts) == request.MaxParts {
+			result.IsTruncated = true
+			result.NextPartNumberMarker = result.Parts[len(result.Parts)-1].PartNumber
+			break
+		}
+		result.Parts = append(result.Parts, datatype.Part{
+			PartNumber:   i,
+			ETag:         "\"" + p.Etag + "\"",
+			LastModified: p.LastModified,
+			Size:         p.Size,
+		})
 	}
 
 	var user iam.Credential
@@ -473,7 +467,7 @@ func (yig *YigStorage) ListObjectParts(credential iam.Credential, bucketName, ob
 	result.Bucket = bucketName
 	result.Key = objectName
 	result.UploadId = request.UploadId
-	result.StorageClass = "STANDARD"
+	result.StorageClass = multipartStorageClass(multipart)
 	result.PartNumberMarker = request.PartNumberMarker
 	result.MaxParts = request.MaxParts
 	result.EncodingType = request.EncodingType
@@ -484,26 +478,20 @@ func (yig *YigStorage) ListObjectParts(credential iam.Credential, bucketName, ob
 	return
 }
 
-func (yig *YigStorage) AbortMultipartUpload(credential iam.Credential,
+func (yig *YigStorage) AbortMultipartUpload(ctx context.Context, credential iam.Credential,
 	bucketName, objectName, uploadId string) error {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
 	if err != nil {
 		return err
 	}
-	switch bucket.ACL.CannedAcl {
-	case "public-read-write":
-		break
-	default:
-		if bucket.OwnerId != credential.UserId {
-			return ErrBucketAccessForbidden
-		}
-	} // TODO policy and fancy ACL
-
 	multipart, err := yig.MetaStorage.GetMultipart(bucketName, objectName, uploadId)
 	if err != nil {
 		return err
 	}
+	if !yig.canWriteToUpload(ctx, bucket, multipart, credential) {
+		return ErrBucketAccessForbidden
+	} // TODO policy and fancy ACL
 
 	err = yig.MetaStorage.Client.DeleteMultipart(multipart)
 	if err != nil {
@@ -523,7 +511,23 @@ func (yig *YigStorage) AbortMultipartUpload(credential iam.Credential,
 	return nil
 }
 
-func (yig *YigStorage) CompleteMultipartUpload(credential iam.Credential, bucketName,
+// ETag of a multipart object, which is MD5 of the concatenated binary MD5
+// of `etags` followed by "-" and the number of parts. `etags` are of parts
+// submitted, in the order they're submitted, whatever their part numbers are.
+// See http://stackoverflow.com/questions/12186993
+func compositeEtag(etags []string) (string, error) {
+	md5Writer := md5.New()
+	for _, etag := range etags {
+		etagBytes, err := hex.DecodeString(etag)
+		if err != nil {
+			return "", ErrInvalidPart
+		}
+		md5Writer.Write(etagBytes)
+	}
+	return hex.EncodeToString(md5Writer.Sum(nil)) + "-" + strconv.Itoa(len(etags)), nil
+}
+
+func (yig *YigStorage) CompleteMultipartUpload(ctx context.Context, credential iam.Credential, bucketName,
 	objectName, uploadId string, uploadedParts []meta.CompletePart) (result datatype.CompleteMultipartResult,
 	err error) {
 
@@ -531,24 +535,18 @@ func (yig *YigStorage) CompleteMultipartUpload(credential iam.Credential, bucket
 	if err != nil {
 		return
 	}
-	switch bucket.ACL.CannedAcl {
-	case "public-read-write":
-		break
-	default:
-		if bucket.OwnerId != credential.UserId {
-			err = ErrBucketAccessForbidden
-			return
-		}
-	}
-	// TODO policy and fancy ACL
-
 	multipart, err := yig.MetaStorage.GetMultipart(bucketName, objectName, uploadId)
 	if err != nil {
 		return
 	}
+	if !yig.canWriteToUpload(ctx, bucket, multipart, credential) {
+		err = ErrBucketAccessForbidden
+		return
+	}
+	// TODO policy and fancy ACL
 
-	md5Writer := md5.New()
 	var totalSize int64 = 0
+	etags := make([]string, 0, len(uploadedParts))
 	for i := 0; i < len(uploadedParts); i++ {
 		if uploadedParts[i].PartNumber != i+1 {
 			err = ErrInvalidPart
@@ -559,7 +557,7 @@ func (yig *YigStorage) CompleteMultipartUpload(credential iam.Credential, bucket
 			err = ErrInvalidPart
 			return
 		}
-		if part.Size < MIN_PART_SIZE && part.PartNumber != len(uploadedParts) {
+		if part.Size < meta.MIN_PART_SIZE && part.PartNumber != len(uploadedParts) {
 			err = meta.PartTooSmall{
 				PartSize:   part.Size,
 				PartNumber: part.PartNumber,
@@ -571,27 +569,26 @@ func (yig *YigStorage) CompleteMultipartUpload(credential iam.Credential, bucket
 			err = ErrInvalidPart
 			return
 		}
-		var etagBytes []byte
-		etagBytes, err = hex.DecodeString(part.Etag)
-		if err != nil {
-			err = ErrInvalidPart
-			return
-		}
 		part.Offset = totalSize
 		totalSize += part.Size
-		md5Writer.Write(etagBytes)
+		etags = append(etags, part.Etag)
+	}
+	if bucket.MaxObjectSize > 0 && totalSize > bucket.MaxObjectSize {
+		err = ErrEntityTooLarge
+		return
+	}
+	result.ETag, err = compositeEtag(etags)
+	if err != nil {
+		return
 	}
-	result.ETag = hex.EncodeToString(md5Writer.Sum(nil))
-	result.ETag += "-" + strconv.Itoa(len(uploadedParts))
-	// See http://stackoverflow.com/questions/12186993
-	// for how to calculate multipart Etag
 
-	// Add to objects table
+	// Add to objects table. The object belongs to the bucket owner no matter
+	// who initiated the upload, the initiator is logged once it's completed
 	contentType := multipart.Metadata.ContentType
 	object := &meta.Object{
 		Name:             objectName,
 		BucketName:       bucketName,
-		OwnerId:          multipart.Metadata.OwnerId,
+		OwnerId:          bucket.OwnerId,
 		Pool:             multipart.Metadata.Pool,
 		Location:         multipart.Metadata.Location,
 		Size:             totalSize,
@@ -605,10 +602,17 @@ func (yig *YigStorage) CompleteMultipartUpload(credential iam.Credential, bucket
 		SseType:          multipart.Metadata.SseRequest.Type,
 		EncryptionKey:    multipart.Metadata.EncryptionKey,
 		CustomAttributes: multipart.Metadata.Attrs,
+		StorageClass:     multipartStorageClass(multipart),
 	}
+	object.SetTtl(multipart.Metadata.Ttl)
+	object.RetentionMode, object.RetainUntilDate =
+		bucket.ObjectLock.DefaultRetention(object.LastModifiedTime)
 
 	var nullVerNum uint64
-	nullVerNum, err = yig.checkOldObject(bucketName, objectName, bucket.Versioning)
+	var removed int64
+	nullVerNum, removed, err = yig.checkOldObject(ctx, bucketName, objectName, bucket.Versioning)
+	// usage of parts is already counted when they're uploaded
+	yig.updateUsage(bucketName, -removed)
 	if err != nil {
 		return
 	}
@@ -620,24 +624,12 @@ func (yig *YigStorage) CompleteMultipartUpload(credential iam.Credential, bucket
 		nullVerNum = uint64(object.LastModifiedTime.UnixNano())
 	}
 
-	err = yig.MetaStorage.PutObjectEntry(object)
+	objMap := newObjMap(object, nullVerNum)
+	err = yig.MetaStorage.PutObjectEntries(ctx, object, objMap)
 	if err != nil {
 		return
 	}
 
-	objMap := &meta.ObjMap{
-		Name:       objectName,
-		BucketName: bucketName,
-	}
-	if nullVerNum != 0 {
-		objMap.NullVerNum = nullVerNum
-		err = yig.MetaStorage.PutObjMapEntry(objMap)
-		if err != nil {
-			yig.delTableEntryForRollback(object, nil)
-			return
-		}
-	}
-
 	// Remove from multiparts table
 	err = yig.MetaStorage.Client.DeleteMultipart(multipart)
 	if err != nil { // rollback objects table
@@ -652,9 +644,19 @@ func (yig *YigStorage) CompleteMultipartUpload(credential iam.Credential, bucket
 	result.SseCustomerKeyMd5Base64 = base64.StdEncoding.EncodeToString(sseRequest.SseCustomerKey)
 
 	if err == nil {
-		yig.MetaStorage.Cache.Remove(redis.ObjectTable, bucketName+":"+objectName+":")
-		yig.DataCache.Remove(bucketName + ":" + objectName + ":" + object.GetVersionId())
+		yig.DataCache.Remove(dataCacheKey(bucketName, objectName, object.GetVersionId()))
+		yig.scheduleExpiration(ctx, bucket, object)
+		helper.LogWithContext(ctx, "Multipart upload %s of %s/%s initiated by %s completed by %s",
+			uploadId, bucketName, objectName, multipart.Metadata.InitiatorId, credential.UserId)
 	}
 
 	return
 }
+
+// Uploads initiated before storage classes are supported have none recorded
+func multipartStorageClass(multipart meta.Multipart) string {
+	if multipart.Metadata.StorageClass == "" {
+		return datatype.STORAGE_CLASS_STANDARD
+	}
+	return multipart.Metadata.StorageClass
+}
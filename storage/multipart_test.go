@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/meta/types"
+)
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCompositeEtag(t *testing.T) {
+	hehe, haha, empty := md5Hex("hehe"), md5Hex("hahahahahaha"), md5Hex("")
+	cases := []struct {
+		etags    []string
+		expected string
+	}{
+		// same as ETag of S3, e.g. `aws s3api complete-multipart-upload`
+		{[]string{hehe, haha, empty}, "53e1c12a838dbb6984accec7b706b3fc-3"},
+		// parts in submitted order, not sorted by anything
+		{[]string{haha, hehe}, "4c0d7883da779d47e2bd6623915ecfc3-2"},
+		{[]string{hehe}, md5Hex(string(mustDecodeHex(t, hehe))) + "-1"},
+	}
+	for _, c := range cases {
+		etag, err := compositeEtag(c.etags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != c.expected {
+			t.Errorf("%v: expected %s, got %s", c.etags, c.expected, etag)
+		}
+	}
+
+	if _, err := compositeEtag([]string{hehe, "hehe"}); err != ErrInvalidPart {
+		t.Errorf("malformed ETag should be rejected, got %v", err)
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Upload of bob to the bucket of alice, who granted bob write permission
+// before bob initiated the upload and revoked it afterwards
+func newMultipartTestStorage() (*YigStorage, *fakeClient, string) {
+	yig, c := newRenameTestStorage()
+	upload := types.Multipart{BucketName: "bucket", ObjectName: "big", InitialTime: time.Now(),
+		Metadata: types.MultipartMetadata{InitiatorId: "bob", OwnerId: "alice",
+			Acl: datatype.Acl{CannedAcl: "private"}},
+		Parts: map[int]*types.Part{1: {PartNumber: 1, Size: 10, ObjectId: "oid-1",
+			Etag: md5Hex("hehe")}},
+	}
+	c.CreateMultipart(upload)
+	uploadId, _ := upload.GetUploadId()
+	return yig, c.fakeClient, uploadId
+}
+
+func TestMultipartInitiator(t *testing.T) {
+	ctx := context.Background()
+	alice, bob, carol := iam.Credential{UserId: "alice"}, iam.Credential{UserId: "bob"},
+		iam.Credential{UserId: "carol"}
+	parts := []types.CompletePart{{PartNumber: 1, ETag: md5Hex("hehe")}}
+
+	for _, credential := range []iam.Credential{alice, bob} {
+		yig, _, uploadId := newMultipartTestStorage()
+		request := datatype.ListPartsRequest{UploadId: uploadId, MaxParts: 1000}
+		result, err := yig.ListObjectParts(ctx, credential, "bucket", "big", request)
+		if err != nil {
+			t.Fatalf("%s: %v", credential.UserId, err)
+		}
+		if result.Initiator.ID != "bob" || result.Owner.ID != "alice" || len(result.Parts) != 1 {
+			t.Errorf("%s: unexpected parts listed %+v", credential.UserId, result)
+		}
+	}
+	yig, _, uploadId := newMultipartTestStorage()
+	request := datatype.ListPartsRequest{UploadId: uploadId, MaxParts: 1000}
+	if _, err := yig.ListObjectParts(ctx, carol, "bucket", "big", request); err != ErrAccessDenied {
+		t.Errorf("parts should not be listed by others, got %v", err)
+	}
+
+	for _, credential := range []iam.Credential{alice, bob} {
+		yig, c, uploadId := newMultipartTestStorage()
+		if _, err := yig.CompleteMultipartUpload(ctx, credential, "bucket", "big", uploadId,
+			parts); err != nil {
+			t.Fatalf("%s: %v", credential.UserId, err)
+		}
+		object := c.latest("bucket", "big")
+		if object == nil || object.OwnerId != "alice" {
+			t.Errorf("%s: completed object should belong to the bucket owner, got %+v",
+				credential.UserId, object)
+		}
+		if _, err := c.GetMultipart("bucket", "big", uploadId); err != ErrNoSuchUpload {
+			t.Errorf("%s: completed upload should be removed", credential.UserId)
+		}
+	}
+	yig, c, uploadId := newMultipartTestStorage()
+	if _, err := yig.CompleteMultipartUpload(ctx, carol, "bucket", "big", uploadId,
+		parts); err != ErrBucketAccessForbidden {
+		t.Errorf("upload should not be completed by others, got %v", err)
+	}
+	if err := yig.AbortMultipartUpload(ctx, carol, "bucket", "big", uploadId); err != ErrBucketAccessForbidden {
+		t.Errorf("upload should not be aborted by others, got %v", err)
+	}
+	if _, err := c.GetMultipart("bucket", "big", uploadId); err != nil {
+		t.Error("upload should be kept")
+	}
+	queue := RecycleQueue
+	defer func() { RecycleQueue = queue }()
+	RecycleQueue = make(chan objectToRecycle, 1)
+	if err := yig.AbortMultipartUpload(ctx, bob, "bucket", "big", uploadId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetMultipart("bucket", "big", uploadId); err != ErrNoSuchUpload {
+		t.Error("upload should be aborted by its initiator")
+	}
+	if r := <-RecycleQueue; r.objectId != "oid-1" {
+		t.Errorf("parts of the aborted upload should be removed, got %v", r)
+	}
+}
+
+func TestListObjectPartsMarker(t *testing.T) {
+	ctx := context.Background()
+	alice := iam.Credential{UserId: "alice"}
+	yig, c, uploadId := newMultipartTestStorage()
+	upload, _ := c.GetMultipart("bucket", "big", uploadId)
+	for _, n := range []int{2, 3, 5, 6} {
+		c.PutObjectPart(upload, types.Part{PartNumber: n, Etag: md5Hex("hehe")})
+	}
+
+	cases := []struct {
+		marker, maxParts int
+		parts            []int
+		truncated        bool
+		next             int
+	}{
+		{0, 1, []int{1}, true, 1},
+		{1, 1, []int{2}, true, 2},
+		{0, 2, []int{1, 2}, true, 2},
+		{2, 2, []int{3, 5}, true, 5},
+		{5, 2, []int{6}, false, 0},
+		{3, 2, []int{5, 6}, false, 0},
+		{0, 5, []int{1, 2, 3, 5, 6}, false, 0},
+		{0, 6, []int{1, 2, 3, 5, 6}, false, 0},
+		{6, 2, nil, false, 0},
+	}
+	for _, tc := range cases {
+		result, err := yig.ListObjectParts(ctx, alice, "bucket", "big", datatype.ListPartsRequest{
+			UploadId: uploadId, PartNumberMarker: tc.marker, MaxParts: tc.maxParts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parts []int
+		for _, p := range result.Parts {
+			parts = append(parts, p.PartNumber)
+		}
+		if fmt.Sprint(parts) != fmt.Sprint(tc.parts) || result.IsTruncated != tc.truncated ||
+			result.NextPartNumberMarker != tc.next {
+			t.Errorf("marker %d, max %d: expected %v truncated %v next %d, got %v %v %d",
+				tc.marker, tc.maxParts, tc.parts, tc.truncated, tc.next,
+				parts, result.IsTruncated, result.NextPartNumberMarker)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	meta "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/redis"
+)
+
+// Object Lock(WORM), see https://docs.aws.amazon.com/AmazonS3/latest/dev/object-lock.html
+// Only bucket owner could configure Object Lock, retention and legal hold.
+
+func (yig *YigStorage) SetBucketObjectLock(ctx context.Context, bucketName string, config datatype.ObjectLockConfiguration,
+	credential iam.Credential) error {
+
+	bucket, err := yig.MetaStorage.GetBucketForUpdate(bucketName)
+	if err != nil {
+		return err
+	}
+	if bucket.OwnerId != credential.UserId {
+		return ErrBucketAccessForbidden
+	}
+	// versions are what Object Lock protects
+	if bucket.Versioning != "Enabled" {
+		return ErrInvalidBucketState
+	}
+	bucket.ObjectLock = config
+	err = yig.MetaStorage.Client.PutBucket(bucket)
+	if err != nil {
+		return err
+	}
+	yig.MetaStorage.Cache.Remove(redis.BucketTable, bucketName)
+	return nil
+}
+
+func (yig *YigStorage) GetBucketObjectLock(ctx context.Context, bucketName string,
+	credential iam.Credential) (config datatype.ObjectLockConfiguration, err error) {
+
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	if err != nil {
+		return
+	}
+	if bucket.OwnerId != credential.UserId {
+		err = ErrBucketAccessForbidden
+		return
+	}
+	if !bucket.ObjectLock.IsEnabled() {
+		err = ErrNoSuchObjectLockConfiguration
+		return
+	}
+	config = bucket.ObjectLock
+	config.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	return config, nil
+}
+
+// Get object version for changing its lock, bucket should have Object Lock enabled
+func (yig *YigStorage) getObjectToLock(bucketName, objectName, version string,
+	credential iam.Credential) (object *meta.Object, err error) {
+
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	if err != nil {
+		return
+	}
+	if bucket.OwnerId != credential.UserId {
+		return nil, ErrBucketAccessForbidden
+	}
+	if !bucket.ObjectLock.IsEnabled() {
+		return nil, ErrObjectLockNotEnabled
+	}
+	if version == "" {
+		object, err = yig.MetaStorage.GetObject(bucketName, objectName, false)
+	} else {
+		object, err = yig.getObjWithVersion(bucketName, objectName, version)
+	}
+	if err != nil {
+		return
+	}
+	if object.DeleteMarker {
+		return nil, ErrNoSuchKey
+	}
+	return object, nil
+}
+
+// Retention could always be extended, or changed from GOVERNANCE to
+// COMPLIANCE mode. In COMPLIANCE mode, it could not be shortened or changed
+// to GOVERNANCE; in GOVERNANCE mode, it could be only with
+// `bypassGovernance`.
+func (yig *YigStorage) PutObjectRetention(ctx context.Context, bucketName, objectName, version string,
+	retention datatype.ObjectRetention, bypassGovernance bool, credential iam.Credential) error {
+
+	object, err := yig.getObjectToLock(bucketName, objectName, version, credential)
+	if err != nil {
+		return err
+	}
+	if object.IsRetained(time.Now()) {
+		weakened := object.IsRetentionWeakenedBy(retention.Mode, retention.RetainUntilDate)
+		if weakened && (object.RetentionMode == datatype.RETENTION_MODE_COMPLIANCE ||
+			!bypassGovernance) {
+			return ErrObjectLocked
+		}
+	}
+	object.RetentionMode = retention.Mode
+	object.RetainUntilDate = retention.RetainUntilDate
+	return yig.MetaStorage.PutObjectEntry(object)
+}
+
+func (yig *YigStorage) GetObjectRetention(ctx context.Context, bucketName, objectName, version string,
+	credential iam.Credential) (retention datatype.ObjectRetention, err error) {
+
+	object, err := yig.getObjectToLock(bucketName, objectName, version, credential)
+	if err != nil {
+		return
+	}
+	if object.RetentionMode == "" {
+		err = ErrNoSuchObjectLockConfiguration
+		return
+	}
+	retention.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	retention.Mode = object.RetentionMode
+	retention.RetainUntilDate = object.RetainUntilDate.UTC()
+	return retention, nil
+}
+
+func (yig *YigStorage) PutObjectLegalHold(ctx context.Context, bucketName, objectName, version string,
+	legalHold datatype.ObjectLegalHold, credential iam.Credential) error {
+
+	object, err := yig.getObjectToLock(bucketName, objectName, version, credential)
+	if err != nil {
+		return err
+	}
+	object.LegalHold = legalHold.Status == datatype.LEGAL_HOLD_ON
+	return yig.MetaStorage.PutObjectEntry(object)
+}
+
+func (yig *YigStorage) GetObjectLegalHold(ctx context.Context, bucketName, objectName, version string,
+	credential iam.Credential) (legalHold datatype.ObjectLegalHold, err error) {
+
+	object, err := yig.getObjectToLock(bucketName, objectName, version, credential)
+	if err != nil {
+		return
+	}
+	legalHold.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	if object.LegalHold {
+		legalHold.Status = datatype.LEGAL_HOLD_ON
+	} else {
+		legalHold.Status = datatype.LEGAL_HOLD_OFF
+	}
+	return legalHold, nil
+}
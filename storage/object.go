@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"io"
 	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/journeymidnight/yig/api/datatype"
@@ -42,11 +44,16 @@ func getCustomedAttrs(metaData map[string]string) (map[string]string, error) {
 	return attrs, nil
 }
 
-func (yig *YigStorage) PickOneClusterAndPool(bucket string, object string, size int64) (cluster *CephStorage,
-	poolName string) {
+// Objects of storage classes other than STANDARD go to pools configured in
+// StorageClassPools regardless of their sizes
+func (yig *YigStorage) PickOneClusterAndPool(bucket string, object string, size int64,
+	storageClass string) (cluster *CephStorage, poolName string) {
 
 	var idx int
-	if size < 0 { // request.ContentLength is -1 if length is unknown
+	if pool, ok := helper.CONFIG.StorageClassPools[storageClass]; ok {
+		poolName = pool
+		idx = 1
+	} else if size < 0 { // request.ContentLength is -1 if length is unknown
 		poolName = BIG_FILE_POOLNAME
 		idx = 1
 	} else if size < BIG_FILE_THRESHOLD {
@@ -62,8 +69,9 @@ func (yig *YigStorage) PickOneClusterAndPool(bucket string, object string, size
 		latestQueryTime[idx] = time.Now()
 		needCheck = true
 	}
-	var totalWeight int
+	var totalWeight, unhealthyWeight int
 	clusterWeights := make(map[string]int, len(yig.DataStorage))
+	unhealthyWeights := make(map[string]int)
 	for fsid, _ := range yig.DataStorage {
 		cluster, err := yig.MetaStorage.GetCluster(fsid, poolName)
 		if err != nil {
@@ -73,6 +81,11 @@ func (yig *YigStorage) PickOneClusterAndPool(bucket string, object string, size
 		if cluster.Weight == 0 {
 			continue
 		}
+		if !yig.clusterHealthy(fsid) {
+			unhealthyWeight += cluster.Weight
+			unhealthyWeights[fsid] = cluster.Weight
+			continue
+		}
 		if needCheck {
 			pct, err := yig.DataStorage[fsid].GetUsedSpacePercent()
 			if err != nil {
@@ -87,6 +100,11 @@ func (yig *YigStorage) PickOneClusterAndPool(bucket string, object string, size
 		totalWeight += cluster.Weight
 		clusterWeights[fsid] = cluster.Weight
 	}
+	// all clusters are down, pick one of them anyway and let the request
+	// fail with its own error
+	if len(clusterWeights) == 0 {
+		clusterWeights, totalWeight = unhealthyWeights, unhealthyWeight
+	}
 	if len(clusterWeights) == 0 || totalWeight == 0 {
 		helper.Logger.Println(5, "Error picking cluster from table cluster in Hbase! Use first cluster in config to write.")
 		for _, c := range yig.DataStorage {
@@ -165,8 +183,19 @@ func generateTransPartObjectFunc(cephCluster *CephStorage, object *meta.Object,
 	return getNormalObject
 }
 
-func (yig *YigStorage) GetObject(object *meta.Object, startOffset int64,
+func (yig *YigStorage) GetObject(ctx context.Context, object *meta.Object, startOffset int64,
 	length int64, writer io.Writer, sseRequest datatype.SseRequest) (err error) {
+	bucket, err := yig.MetaStorage.GetBucket(object.BucketName, true)
+	if err != nil {
+		return err
+	}
+	limiter := yig.getBucketLimiter(bucket)
+	err = limiter.allowRead()
+	if err != nil {
+		return err
+	}
+	writer = limiter.limitRead(ctx, writer)
+
 	var encryptionKey []byte
 	if object.SseType == "S3" {
 		encryptionKey = object.EncryptionKey
@@ -216,83 +245,33 @@ func (yig *YigStorage) GetObject(object *meta.Object, startOffset int64,
 	}
 
 	// multipart uploaded object
-	var low int = object.PartsIndex.SearchLowerBound(startOffset)
-	if low == -1 {
-		low = 1
-	} else {
-		//parts number starts from 1, so plus 1 here
-		low += 1
+	cephCluster, ok := yig.DataStorage[object.Location]
+	if !ok {
+		return errors.New("Cannot find specified ceph cluster: " +
+			object.Location)
 	}
-
-	for i := low; i <= len(object.Parts); i++ {
-		p := object.Parts[i]
-		//for high
-		if p.Offset > startOffset+length {
-			return
-		}
-		//for low
-		{
-			var readOffset, readLength int64
-			if startOffset <= p.Offset {
-				readOffset = 0
-			} else {
-				readOffset = startOffset - p.Offset
-			}
-			if p.Offset+p.Size <= startOffset+length {
-				readLength = p.Offset + p.Size - readOffset
-			} else {
-				readLength = startOffset + length - (p.Offset + readOffset)
-			}
-			cephCluster, ok := yig.DataStorage[object.Location]
-			if !ok {
-				return errors.New("Cannot find specified ceph cluster: " +
-					object.Location)
-			}
-			if object.SseType == "" { // unencrypted object
-
-				transPartFunc := generateTransPartObjectFunc(cephCluster, object, p, readOffset, readLength)
-				err := transPartFunc(writer)
-				if err != nil {
-					return nil
-				}
-				continue
-			}
-
-			// encrypted object
-			err = copyEncryptedPart(object.Pool, p, cephCluster, readOffset, readLength, encryptionKey, writer)
-			if err != nil {
-				helper.Debugln("Multipart uploaded object write error:", err)
-			}
-		}
+	if object.SseType == "" { // unencrypted object, ignore SSE-C keys sent
+		encryptionKey = nil
 	}
-	return
-}
-
-func copyEncryptedPart(pool string, part *meta.Part, cephCluster *CephStorage, readOffset int64, length int64,
-	encryptionKey []byte, targetWriter io.Writer) (err error) {
-
-	reader, err := cephCluster.getAlignedReader(pool, part.ObjectId,
-		readOffset, length)
-	if err != nil {
-		return err
+	getReader := func(oid string, offset, length int64) (io.ReadCloser, error) {
+		return cephCluster.getReader(object.Pool, oid, offset, length)
 	}
-	defer reader.Close()
-
-	decryptedReader, err := wrapAlignedEncryptionReader(reader, readOffset,
-		encryptionKey, part.InitializationVector)
+	var openers []partOpener
+	for _, r := range partRangesOf(object, startOffset, length) {
+		openers = append(openers, partOpenerOf(getReader, r, encryptionKey))
+	}
+	err = prefetchParts(ctx, openers, helper.CONFIG.DownloadPrefetchParts,
+		prefetchBufferChunks(helper.CONFIG.DownloadPrefetchBufferSize), writer)
 	if err != nil {
-		return err
+		helper.LogWithContext(ctx, "Multipart uploaded object write error: %v", err)
 	}
-	buffer := downloadBufPool.Get().([]byte)
-	_, err = io.CopyBuffer(targetWriter, decryptedReader, buffer)
-	downloadBufPool.Put(buffer)
 	return err
 }
 
-func (yig *YigStorage) GetObjectInfo(bucketName string, objectName string,
+func (yig *YigStorage) GetObjectInfo(ctx context.Context, bucketName string, objectName string,
 	version string, credential iam.Credential) (object *meta.Object, err error) {
 
-	_, err = yig.MetaStorage.GetBucket(bucketName, true)
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
 	if err != nil {
 		return
 	}
@@ -305,34 +284,19 @@ func (yig *YigStorage) GetObjectInfo(bucketName string, objectName string,
 	if err != nil {
 		return
 	}
-
-	switch object.ACL.CannedAcl {
-	case "public-read", "public-read-write":
-		break
-	case "authenticated-read":
-		if credential.UserId == "" {
-			err = ErrAccessDenied
-			return
-		}
-	case "bucket-owner-read", "bucket-owner-full-control":
-		bucket, err := yig.GetBucket(bucketName)
-		if err != nil {
-			return object, ErrAccessDenied
-		}
-		if bucket.OwnerId != credential.UserId {
-			return object, ErrAccessDenied
-		}
-	default:
-		if object.OwnerId != credential.UserId {
-			err = ErrAccessDenied
-			return
-		}
+	if object.IsExpired(time.Now()) {
+		err = ErrNoSuchKey
+		return
 	}
 
+	if !yig.canReadObject(ctx, bucket, object, credential) {
+		err = ErrAccessDenied
+		return
+	}
 	return
 }
 
-func (yig *YigStorage) GetObjectAcl(bucketName string, objectName string,
+func (yig *YigStorage) GetObjectAcl(ctx context.Context, bucketName string, objectName string,
 	version string, credential iam.Credential) (policy datatype.AccessControlPolicy, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
@@ -356,6 +320,12 @@ func (yig *YigStorage) GetObjectAcl(bucketName string, objectName string,
 			err = ErrAccessDenied
 			return
 		}
+	case "":
+		if object.OwnerId != credential.UserId &&
+			!yig.grantsAllow(ctx, object.ACL.Grants, credential, datatype.ACL_PERM_READ_ACP) {
+			err = ErrAccessDenied
+			return
+		}
 	default:
 		if object.OwnerId != credential.UserId {
 			err = ErrAccessDenied
@@ -369,6 +339,14 @@ func (yig *YigStorage) GetObjectAcl(bucketName string, objectName string,
 		return
 	}
 	bucketOwner := datatype.Owner{ID: bucketCred.UserId, DisplayName: bucketCred.DisplayName}
+	if object.ACL.CannedAcl == "" {
+		objectCred, err := iam.GetCredentialByUserId(object.OwnerId)
+		if err != nil {
+			return policy, err
+		}
+		objectOwner := datatype.Owner{ID: objectCred.UserId, DisplayName: objectCred.DisplayName}
+		return datatype.CreatePolicyFromGrants(objectOwner, object.ACL), nil
+	}
 	policy, err = datatype.CreatePolicyFromCanned(owner, bucketOwner, object.ACL)
 	if err != nil {
 		return
@@ -377,12 +355,20 @@ func (yig *YigStorage) GetObjectAcl(bucketName string, objectName string,
 	return
 }
 
-func (yig *YigStorage) SetObjectAcl(bucketName string, objectName string, version string,
+func (yig *YigStorage) SetObjectAcl(ctx context.Context, bucketName string, objectName string, version string,
 	policy datatype.AccessControlPolicy, acl datatype.Acl, credential iam.Credential) error {
 
-	if acl.CannedAcl == "" {
+	// either canned or explicit grants from headers, otherwise from policy
+	if acl.CannedAcl == "" && len(acl.Grants) == 0 {
 		newCannedAcl, err := datatype.GetCannedAclFromPolicy(policy)
-		if err != nil {
+		if err == ErrUnsupportedAcl {
+			// not expressible as a canned ACL, store the grants as they are
+			err = datatype.IsValidGrants(policy.AccessControlList)
+			if err != nil {
+				return err
+			}
+			newCannedAcl = datatype.Acl{Grants: policy.AccessControlList}
+		} else if err != nil {
 			return err
 		}
 		acl = newCannedAcl
@@ -412,15 +398,8 @@ func (yig *YigStorage) SetObjectAcl(bucketName string, objectName string, versio
 		return err
 	}
 	object.ACL = acl
-	err = yig.MetaStorage.PutObjectEntry(object)
-	if err != nil {
-		return err
-	}
-	if err == nil {
-		yig.MetaStorage.Cache.Remove(redis.ObjectTable,
-			bucketName+":"+objectName+":"+version)
-	}
-	return nil
+	// cached object is cleared by PutObjectEntry
+	return yig.MetaStorage.PutObjectEntry(object)
 }
 
 func (yig *YigStorage) delTableEntryForRollback(object *meta.Object, objMap *meta.ObjMap) error {
@@ -436,6 +415,26 @@ func (yig *YigStorage) delTableEntryForRollback(object *meta.Object, objMap *met
 	return nil
 }
 
+// ObjMap entry to put along with a new object, nil if not needed
+func newObjMap(object *meta.Object, nullVerNum uint64) *meta.ObjMap {
+	if nullVerNum == 0 {
+		return nil
+	}
+	return &meta.ObjMap{
+		Name:       object.Name,
+		BucketName: object.BucketName,
+		NullVerNum: nullVerNum,
+	}
+}
+
+// Skip the request if nothing changes, e.g. an object is overwritten by
+// another one of the same size
+func (yig *YigStorage) updateUsage(bucketName string, delta int64) {
+	if delta != 0 {
+		yig.MetaStorage.UpdateUsage(bucketName, delta)
+	}
+}
+
 // Write path:
 //                                           +-----------+
 // PUT object/part                           |           |   Ceph
@@ -445,9 +444,27 @@ func (yig *YigStorage) delTableEntryForRollback(object *meta.Object, objMap *met
 //                   v            v
 //                  SHA256      MD5(ETag)
 //
+// Fails once more than `max` bytes are read
+type sizeLimitedReader struct {
+	reader   io.Reader
+	max      int64
+	read     int64
+	exceeded bool
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.max {
+		r.exceeded = true
+		return 0, ErrEntityTooLarge
+	}
+	return
+}
+
 // SHA256 is calculated only for v4 signed authentication
 // Encryptor is enabled when user set SSE headers
-func (yig *YigStorage) PutObject(bucketName string, objectName string, credential iam.Credential,
+func (yig *YigStorage) PutObject(ctx context.Context, bucketName string, objectName string, credential iam.Credential,
 	size int64, data io.Reader, metadata map[string]string, acl datatype.Acl,
 	sseRequest datatype.SseRequest) (result datatype.PutObjectResult, err error) {
 
@@ -456,13 +473,19 @@ func (yig *YigStorage) PutObject(bucketName string, objectName string, credentia
 		return
 	}
 
-	switch bucket.ACL.CannedAcl {
-	case "public-read-write":
-		break
-	default:
-		if bucket.OwnerId != credential.UserId {
-			return result, ErrBucketAccessForbidden
-		}
+	if !yig.canWriteObjectsOf(ctx, bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+	if sseRequest.Type == "" {
+		sseRequest = bucket.Encryption.SseRequest()
+	}
+	if bucket.MaxObjectSize > 0 && size > bucket.MaxObjectSize {
+		return result, ErrEntityTooLarge
+	}
+	limiter := yig.getBucketLimiter(bucket)
+	err = limiter.allowWrite()
+	if err != nil {
+		return
 	}
 
 	md5Writer := md5.New()
@@ -474,12 +497,23 @@ func (yig *YigStorage) PutObject(bucketName string, objectName string, credentia
 	} else {
 		limitedDataReader = data
 	}
+	// size is unknown, count bytes as they are read
+	var sizeLimiter *sizeLimitedReader
+	if size < 0 && bucket.MaxObjectSize > 0 {
+		sizeLimiter = &sizeLimitedReader{reader: limitedDataReader, max: bucket.MaxObjectSize}
+		limitedDataReader = sizeLimiter
+	}
+	limitedDataReader = limiter.limitWrite(ctx, limitedDataReader)
 
-	cephCluster, poolName := yig.PickOneClusterAndPool(bucketName, objectName, size)
+	storageClass := helper.Ternary(metadata["storageClass"] == "",
+		datatype.STORAGE_CLASS_STANDARD, metadata["storageClass"]).(string)
+	cephCluster, poolName := yig.PickOneClusterAndPool(bucketName, objectName, size, storageClass)
 
 	// Mapping a shorter name for the object
 	oid := cephCluster.GetUniqUploadName()
-	dataReader := io.TeeReader(limitedDataReader, md5Writer)
+	checksums := checksumsFromMetadata(metadata)
+	verifier := newPayloadVerifier(checksums)
+	dataReader := io.TeeReader(limitedDataReader, io.MultiWriter(md5Writer, verifier.writer()))
 
 	encryptionKey, err := encryptionKeyFromSseRequest(sseRequest)
 	if err != nil {
@@ -496,10 +530,8 @@ func (yig *YigStorage) PutObject(bucketName string, objectName string, credentia
 	if err != nil {
 		return
 	}
-	bytesWritten, err := cephCluster.Put(poolName, oid, storageReader)
-	if err != nil {
-		return
-	}
+	bytesWritten, cephCluster, replicas, err := yig.putObjectData(cephCluster, poolName, oid,
+		size, storageReader)
 	// Should metadata update failed, add `maybeObjectToRecycle` to `RecycleQueue`,
 	// so the object in Ceph could be removed asynchronously
 	maybeObjectToRecycle := objectToRecycle{
@@ -507,31 +539,48 @@ func (yig *YigStorage) PutObject(bucketName string, objectName string, credentia
 		pool:     poolName,
 		objectId: oid,
 	}
-	if bytesWritten < size {
+	if sizeLimiter != nil && sizeLimiter.exceeded {
+		err = ErrEntityTooLarge
+	}
+	// Put stops at the failed read, data written so far should be removed.
+	// Readers passed in could also fail on sizes, e.g. for content-length-range
+	// of POST policy
+	if err == ErrEntityTooLarge || err == ErrEntityTooSmall {
 		RecycleQueue <- maybeObjectToRecycle
+		return result, err
+	}
+	if err != nil {
+		return
+	}
+	if bytesWritten < size {
+		recycleObjectData(maybeObjectToRecycle, replicas)
 		return result, ErrIncompleteBody
 	}
 
 	calculatedMd5 := hex.EncodeToString(md5Writer.Sum(nil))
 	if userMd5, ok := metadata["md5Sum"]; ok {
 		if userMd5 != "" && userMd5 != calculatedMd5 {
-			RecycleQueue <- maybeObjectToRecycle
+			recycleObjectData(maybeObjectToRecycle, replicas)
 			return result, ErrBadDigest
 		}
 	}
+	if err = verifier.verify(); err != nil {
+		recycleObjectData(maybeObjectToRecycle, replicas)
+		return
+	}
 
 	result.Md5 = calculatedMd5
 
 	if signVerifyReader, ok := data.(*signature.SignVerifyReader); ok {
 		credential, err = signVerifyReader.Verify()
 		if err != nil {
-			RecycleQueue <- maybeObjectToRecycle
+			recycleObjectData(maybeObjectToRecycle, replicas)
 			return
 		}
 	}
 	attrs, err := getCustomedAttrs(metadata)
 	if err != nil {
-		RecycleQueue <- maybeObjectToRecycle
+		recycleObjectData(maybeObjectToRecycle, replicas)
 		return
 	}
 
@@ -556,13 +605,23 @@ func (yig *YigStorage) PutObject(bucketName string, objectName string, credentia
 			encryptionKey, []byte("")).([]byte),
 		InitializationVector: initializationVector,
 		CustomAttributes:     attrs,
+		StorageClass:         storageClass,
+		ChecksumAlgorithm:    checksums.Algorithm,
+		Checksum:             checksums.Checksum,
+		Replicas:             replicas,
 	}
+	object.SetTtl(ttlOf(metadata))
+	object.RetentionMode, object.RetainUntilDate =
+		bucket.ObjectLock.DefaultRetention(object.LastModifiedTime)
 
 	result.LastModified = object.LastModifiedTime
+	result.SseType = object.SseType
 	var nullVerNum uint64
-	nullVerNum, err = yig.checkOldObject(bucketName, objectName, bucket.Versioning)
+	var removed int64
+	nullVerNum, removed, err = yig.checkOldObject(ctx, bucketName, objectName, bucket.Versioning)
 	if err != nil {
-		RecycleQueue <- maybeObjectToRecycle
+		yig.updateUsage(bucketName, -removed)
+		recycleObjectData(maybeObjectToRecycle, replicas)
 		return
 	}
 	if bucket.Versioning == "Enabled" {
@@ -573,57 +632,86 @@ func (yig *YigStorage) PutObject(bucketName string, objectName string, credentia
 		nullVerNum = uint64(object.LastModifiedTime.UnixNano())
 	}
 
-	err = yig.MetaStorage.PutObjectEntry(object)
+	err = yig.MetaStorage.PutObjectEntries(ctx, object, newObjMap(object, nullVerNum))
 	if err != nil {
-		RecycleQueue <- maybeObjectToRecycle
+		yig.updateUsage(bucketName, -removed)
+		recycleObjectData(maybeObjectToRecycle, replicas)
 		return
 	}
-	objMap := &meta.ObjMap{
-		Name:       objectName,
-		BucketName: bucketName,
-	}
-	if nullVerNum != 0 {
-		objMap.NullVerNum = nullVerNum
-		err = yig.MetaStorage.PutObjMapEntry(objMap)
-		if err != nil {
-			yig.delTableEntryForRollback(object, nil)
-			RecycleQueue <- maybeObjectToRecycle
-			return
-		}
-	}
+	yig.updateUsage(bucketName, object.Size-removed)
+	yig.DataCache.Remove(dataCacheKey(bucketName, objectName, object.GetVersionId()))
+	yig.scheduleExpiration(ctx, bucket, object)
+	return result, nil
+}
 
-	if err == nil {
-		yig.MetaStorage.UpdateUsage(object.BucketName, object.Size)
+// Seconds to live of an object uploaded, passed as "ttl" in metadata by
+// handlers, zero if not set
+func ttlOf(metadata map[string]string) int64 {
+	ttl, _ := strconv.ParseInt(metadata["ttl"], 10, 64)
+	return ttl
+}
 
-		yig.MetaStorage.Cache.Remove(redis.ObjectTable, bucketName+":"+objectName+":")
-		yig.DataCache.Remove(bucketName + ":" + objectName + ":" + object.GetVersionId())
+// Expired objects are removed by lifecycle tool, make sure it scans the
+// bucket of `object` if the object expires. Failures are only logged, the
+// object is hidden from clients once expired anyway
+func (yig *YigStorage) scheduleExpiration(ctx context.Context, bucket meta.Bucket,
+	object *meta.Object) {
+
+	if object.ExpireTime.IsZero() {
+		return
+	}
+	err := yig.MetaStorage.PutBucketToLifeCycle(bucket)
+	if err != nil {
+		helper.LogWithContext(ctx, "Error put bucket to LC table for object TTL: %s %v",
+			bucket.Name, err)
 	}
-	return result, nil
 }
 
-func (yig *YigStorage) CopyObject(targetObject *meta.Object, source io.Reader, credential iam.Credential,
-	sseRequest datatype.SseRequest) (result datatype.PutObjectResult, err error) {
+func (yig *YigStorage) CopyObject(ctx context.Context, targetObject, sourceObject *meta.Object, source io.Reader,
+	credential iam.Credential, sseRequest datatype.SseRequest) (result datatype.PutObjectResult, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(targetObject.BucketName, true)
 	if err != nil {
 		return
 	}
 
-	switch bucket.ACL.CannedAcl {
-	case "public-read-write":
-		break
-	default:
-		if bucket.OwnerId != credential.UserId {
-			return result, ErrBucketAccessForbidden
+	if !yig.canWriteObjectsOf(ctx, bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+	if source == nil {
+		// versions are immutable, so metadata is only replaced in place if
+		// versioning is disabled and the object is not locked, otherwise a
+		// new version is created with data of the object
+		if bucket.Versioning == "Disabled" && !sourceObject.IsLocked(time.Now(), false) {
+			result, err = yig.replaceObjectMetadata(ctx, targetObject)
+			if err == nil {
+				yig.scheduleExpiration(ctx, bucket, targetObject)
+			}
+			return
 		}
+		reader := yig.newObjectReader(ctx, sourceObject)
+		defer reader.Close()
+		targetObject, source = newVersionOfObject(targetObject), reader
+		if sseRequest.Type == "" {
+			sseRequest.Type = sourceObject.SseType
+		}
+	}
+	if sseRequest.Type == "" {
+		sseRequest = bucket.Encryption.SseRequest()
+	}
+	if bucket.MaxObjectSize > 0 && targetObject.Size > bucket.MaxObjectSize {
+		return result, ErrEntityTooLarge
 	}
 
 	// Limit the reader to its provided size if specified.
 	var limitedDataReader io.Reader
 	limitedDataReader = io.LimitReader(source, targetObject.Size)
 
+	if targetObject.StorageClass == "" {
+		targetObject.StorageClass = datatype.STORAGE_CLASS_STANDARD
+	}
 	cephCluster, poolName := yig.PickOneClusterAndPool(targetObject.BucketName,
-		targetObject.Name, targetObject.Size)
+		targetObject.Name, targetObject.Size, targetObject.StorageClass)
 
 	var oid string
 	var maybeObjectToRecycle objectToRecycle
@@ -632,7 +720,15 @@ func (yig *YigStorage) CopyObject(targetObject *meta.Object, source io.Reader, c
 	if err != nil {
 		return
 	}
-	if len(targetObject.Parts) != 0 {
+	if sameEncryption(sourceObject, sseRequest) {
+		encryptionKey = sourceObject.EncryptionKey
+		maybeObjectToRecycle, err = yig.copyEncryptedData(sourceObject, targetObject,
+			cephCluster, poolName)
+		if err != nil {
+			return
+		}
+		result.Md5 = targetObject.Etag
+	} else if len(targetObject.Parts) != 0 {
 		var targetParts map[int]*meta.Part = make(map[int]*meta.Part, len(targetObject.Parts))
 		//		etaglist := make([]string, len(sourceObject.Parts))
 		for partNum, part := range targetObject.Parts {
@@ -747,10 +843,14 @@ func (yig *YigStorage) CopyObject(targetObject *meta.Object, source io.Reader, c
 		encryptionKey, []byte("")).([]byte)
 
 	result.LastModified = targetObject.LastModifiedTime
+	result.SseType = targetObject.SseType
 
 	var nullVerNum uint64
-	nullVerNum, err = yig.checkOldObject(targetObject.BucketName, targetObject.Name, bucket.Versioning)
+	var removed int64
+	nullVerNum, removed, err = yig.checkOldObject(ctx, targetObject.BucketName, targetObject.Name,
+		bucket.Versioning)
 	if err != nil {
+		yig.updateUsage(targetObject.BucketName, -removed)
 		RecycleQueue <- maybeObjectToRecycle
 		return
 	}
@@ -762,37 +862,159 @@ func (yig *YigStorage) CopyObject(targetObject *meta.Object, source io.Reader, c
 		nullVerNum = uint64(targetObject.LastModifiedTime.UnixNano())
 	}
 
-	err = yig.MetaStorage.PutObjectEntry(targetObject)
+	err = yig.MetaStorage.PutObjectEntries(ctx, targetObject, newObjMap(targetObject, nullVerNum))
 	if err != nil {
+		yig.updateUsage(targetObject.BucketName, -removed)
 		RecycleQueue <- maybeObjectToRecycle
 		return
 	}
-	objMap := &meta.ObjMap{
-		Name:       targetObject.Name,
-		BucketName: targetObject.BucketName,
-	}
-	if nullVerNum != 0 {
-		objMap.NullVerNum = nullVerNum
-		err = yig.MetaStorage.PutObjMapEntry(objMap)
+	yig.updateUsage(targetObject.BucketName, targetObject.Size-removed)
+	yig.DataCache.Remove(dataCacheKey(targetObject.BucketName, targetObject.Name,
+		targetObject.GetVersionId()))
+	yig.scheduleExpiration(ctx, bucket, targetObject)
+	return result, nil
+}
+
+// Both source and target of a copy are SSE-S3, whose data keys are all
+// encrypted with the same SSE_S3_MASTER_KEY, so data encrypted with the key
+// of source could be used for target as is
+func sameEncryption(sourceObject *meta.Object, sseRequest datatype.SseRequest) bool {
+	return sourceObject != nil && sourceObject.SseType == "S3" && sseRequest.Type == "S3" &&
+		len(sourceObject.EncryptionKey) != 0
+}
+
+// Copy encrypted data of `source` to `cluster` and `pool` without decrypting
+// it, `target` takes the IVs of source along with the data. Returns the last
+// Ceph object written, to be recycled should metadata update fail
+func (yig *YigStorage) copyEncryptedData(source, target *meta.Object, cluster *CephStorage,
+	pool string) (copied objectToRecycle, err error) {
+
+	sourceCluster, ok := yig.DataStorage[source.Location]
+	if !ok {
+		return copied, errors.New("Cannot find specified ceph cluster: " + source.Location)
+	}
+	unlimited := newBandwidthLimiter(0)
+	copied = objectToRecycle{location: cluster.Name, pool: pool}
+	if len(source.Parts) == 0 {
+		copied.objectId, err = copyCephObject(sourceCluster, cluster, source.Pool, pool,
+			source.ObjectId, source.Size, unlimited)
 		if err != nil {
-			yig.delTableEntryForRollback(targetObject, nil)
-			RecycleQueue <- maybeObjectToRecycle
 			return
 		}
+		target.ObjectId = copied.objectId
+		target.InitializationVector = source.InitializationVector
+		return copied, nil
+	}
+	targetParts := make(map[int]*meta.Part, len(source.Parts))
+	for partNum, part := range source.Parts {
+		targetPart := *part
+		targetPart.ObjectId, err = copyCephObject(sourceCluster, cluster, source.Pool, pool,
+			part.ObjectId, part.Size, unlimited)
+		if err != nil {
+			for _, p := range targetParts {
+				RecycleQueue <- objectToRecycle{location: cluster.Name, pool: pool, objectId: p.ObjectId}
+			}
+			return
+		}
+		targetPart.LastModified = time.Now().UTC().Format(meta.CREATE_TIME_LAYOUT)
+		targetParts[partNum] = &targetPart
+		copied.objectId = targetPart.ObjectId
 	}
+	target.ObjectId = ""
+	target.Parts = targetParts
+	return copied, nil
+}
 
-	if err == nil {
-		yig.MetaStorage.UpdateUsage(targetObject.BucketName, targetObject.Size)
+// Read whole data of `object`, reader should be closed once done
+func (yig *YigStorage) newObjectReader(ctx context.Context, object *meta.Object) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		err := yig.GetObject(ctx, object, 0, object.Size, writer, datatype.SseRequest{})
+		writer.CloseWithError(err)
+	}()
+	return reader
+}
 
-		yig.MetaStorage.Cache.Remove(redis.ObjectTable,
-			targetObject.BucketName+":"+targetObject.Name+":")
-		yig.DataCache.Remove(targetObject.BucketName + ":" + targetObject.Name + ":" + targetObject.GetVersionId())
+// Target of copying `replaced` to itself as a new version, with data of
+// `replaced` and metadata replaced. Parts are copied as they're updated
+// while data is being read
+func newVersionOfObject(replaced *meta.Object) *meta.Object {
+	object := &meta.Object{
+		BucketName:       replaced.BucketName,
+		Name:             replaced.Name,
+		Size:             replaced.Size,
+		Etag:             replaced.Etag,
+		ContentType:      replaced.ContentType,
+		CustomAttributes: replaced.CustomAttributes,
+		ACL:              replaced.ACL,
+		StorageClass:     replaced.StorageClass,
+		ExpireTime:       replaced.ExpireTime,
+	}
+	if len(replaced.Parts) != 0 {
+		object.Parts = make(map[int]*meta.Part, len(replaced.Parts))
+		for n, p := range replaced.Parts {
+			part := *p
+			object.Parts[n] = &part
+		}
+	}
+	return object
+}
+
+// Replace content type, custom attributes and ACL of an object copied to
+// itself, and update its last modified time. Only its metadata entry is
+// updated, data in Ceph is left as is
+func (yig *YigStorage) replaceObjectMetadata(ctx context.Context,
+	object *meta.Object) (result datatype.PutObjectResult, err error) {
+
+	object.CustomAttributes, err = getCustomedAttrs(object.CustomAttributes)
+	if err != nil {
+		return
+	}
+	// checked against etag of the object, so a removed one is not brought
+	// back by the entry put below
+	err = yig.MetaStorage.UpdateObjectAttrs(object)
+	if err != nil {
+		return
+	}
+	// last modified time is part of the rowkey, so the entry is moved to a
+	// new row, both rows share the same data until the old one is removed
+	replaced := *object
+	replaced.Rowkey = nil
+	replaced.VersionId = ""
+	replaced.LastModifiedTime = time.Now().UTC()
+	err = yig.MetaStorage.PutObjectEntry(&replaced)
+	if err != nil {
+		return
+	}
+	err = yig.MetaStorage.DeleteObjectEntry(object)
+	if err != nil {
+		if rollbackErr := yig.MetaStorage.DeleteObjectEntry(&replaced); rollbackErr != nil {
+			helper.LogWithContext(ctx, "Inconsistent data: object should be removed: %s %s %s %v",
+				replaced.BucketName, replaced.Name, replaced.GetVersionId(), rollbackErr)
+		}
+		return
 	}
+	yig.DataCache.Remove(dataCacheKey(object.BucketName, object.Name, object.GetVersionId()))
+	result.Md5 = replaced.Etag
+	result.LastModified = replaced.LastModifiedTime
+	result.SseType = replaced.SseType
 	return result, nil
 }
 
-func (yig *YigStorage) removeByObject(object *meta.Object) (err error) {
+func (yig *YigStorage) removeByObject(ctx context.Context, object *meta.Object) (err error) {
+	err = yig.removeObjectEntry(ctx, object)
+	if err != nil {
+		return
+	}
+	if !object.DeleteMarker {
+		yig.MetaStorage.UpdateUsage(object.BucketName, -object.Size)
+	}
+	return nil
+}
 
+// Same as removeByObject, but leaves bucket usage to the caller, so it
+// could be updated along with other changes in one request
+func (yig *YigStorage) removeObjectEntry(ctx context.Context, object *meta.Object) (err error) {
 	err = yig.MetaStorage.DeleteObjectEntry(object)
 	if err != nil {
 		return
@@ -801,58 +1023,103 @@ func (yig *YigStorage) removeByObject(object *meta.Object) (err error) {
 	if object.DeleteMarker {
 		return
 	}
+	yig.DataCache.Remove(dataCacheKey(object.BucketName, object.Name, object.GetVersionId()))
+	if yig.dataSharedWithPeer(ctx, object) {
+		return nil
+	}
 
 	err = yig.MetaStorage.PutObjectToGarbageCollection(object)
 	if err != nil { // try to rollback `objects` table
-		yig.Logger.Println(5, "Error PutObjectToGarbageCollection: ", err)
+		helper.LogWithContext(ctx, "Error PutObjectToGarbageCollection: %v", err)
 		err = yig.MetaStorage.PutObjectEntry(object)
 		if err != nil {
-			yig.Logger.Println(5, "Error insertObjectEntry: ", err)
-			yig.Logger.Println(5, "Inconsistent data: object should be removed:",
-				object)
+			helper.LogWithContext(ctx, "Error insertObjectEntry: %v, inconsistent data: "+
+				"object should be removed: %s %s %s", err,
+				object.BucketName, object.Name, object.GetVersionId())
 			return
 		}
 		return ErrInternalError
 	}
-
-	yig.MetaStorage.UpdateUsage(object.BucketName, -object.Size)
+	for _, replica := range object.Replicas {
+		err = yig.MetaStorage.PutObjectToGarbageCollection(&meta.Object{
+			BucketName: object.BucketName,
+			Name:       object.Name,
+			Location:   replica.Location,
+			Pool:       replica.Pool,
+			ObjectId:   replica.ObjectId,
+		})
+		if err != nil {
+			helper.LogWithContext(ctx, "Error PutObjectToGarbageCollection: %v, inconsistent data: "+
+				"replica should be removed: %s %s %s", err,
+				replica.Location, replica.Pool, replica.ObjectId)
+		}
+	}
 	return nil
 }
 
 func (yig *YigStorage) getObjWithVersion(bucketName, objectName, version string) (object *meta.Object, err error) {
 	if version == "null" {
 		objMap, err := yig.MetaStorage.GetObjectMap(bucketName, objectName)
+		if err == ErrNoSuchKey {
+			// objMap entry is only created once a newer version is written on top of
+			// the `null` version, so if it's absent, `null` version could only be
+			// the latest object
+			object, err := yig.MetaStorage.GetObject(bucketName, objectName, true)
+			if err != nil {
+				return nil, err
+			}
+			if !object.NullVersion {
+				return nil, ErrNoSuchVersion
+			}
+			return object, nil
+		}
 		if err != nil {
 			return nil, err
 		}
-		version = objMap.NullVerId
+		version = objMap.GetVersionId()
 	}
 	return yig.MetaStorage.GetObjectVersion(bucketName, objectName, version, true)
 
 }
 
-func (yig *YigStorage) removeAllObjectsEntryByName(bucketName, objectName string) (err error) {
+// `removed` is the size of objects removed, even if err is not nil, bucket
+// usage should be updated by caller
+func (yig *YigStorage) removeAllObjectsEntryByName(ctx context.Context, bucketName, objectName string,
+	bypassGovernance bool) (removed int64, err error) {
 
 	objs, err := yig.MetaStorage.GetAllObject(bucketName, objectName)
 	if err == ErrNoSuchKey {
-		return nil
+		return 0, nil
 	}
 	if err != nil {
-		return err
+		return 0, err
 	}
+	// remove nothing if any version is locked
+	now := time.Now()
 	for _, obj := range objs {
-		err = yig.removeByObject(obj)
+		if obj.IsLocked(now, bypassGovernance) {
+			return 0, ErrObjectLocked
+		}
+	}
+	for _, obj := range objs {
+		err = yig.removeObjectEntry(ctx, obj)
 		if err != nil {
-			return err
+			return
+		}
+		if !obj.DeleteMarker {
+			removed += obj.Size
 		}
 	}
 	return
 }
 
-func (yig *YigStorage) checkOldObject(bucketName, objectName, versioning string) (version uint64, err error) {
+// Remove objects to be overwritten by a new object, `removed` is their
+// total size which is left for caller to subtract from bucket usage
+func (yig *YigStorage) checkOldObject(ctx context.Context, bucketName, objectName, versioning string) (version uint64,
+	removed int64, err error) {
 
 	if versioning == "Disabled" {
-		err = yig.removeAllObjectsEntryByName(bucketName, objectName)
+		removed, err = yig.removeAllObjectsEntryByName(ctx, bucketName, objectName, false)
 		return
 	}
 
@@ -866,16 +1133,16 @@ func (yig *YigStorage) checkOldObject(bucketName, objectName, versioning string)
 			err = nil
 			objMapExist = false
 		} else if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 		var object *meta.Object
 		if objMapExist {
-			object, err = yig.MetaStorage.GetObjectVersion(bucketName, objectName, objMap.NullVerId, false)
+			object, err = yig.MetaStorage.GetObjectVersion(bucketName, objectName, objMap.GetVersionId(), false)
 			if err == ErrNoSuchKey {
 				err = nil
 				objectExist = false
 			} else if err != nil {
-				return 0, err
+				return 0, 0, err
 			}
 		} else {
 			object, err = yig.MetaStorage.GetObject(bucketName, objectName, false)
@@ -883,7 +1150,7 @@ func (yig *YigStorage) checkOldObject(bucketName, objectName, versioning string)
 				err = nil
 				objectExist = false
 			} else if err != nil {
-				return 0, err
+				return 0, 0, err
 			}
 		}
 
@@ -899,44 +1166,58 @@ func (yig *YigStorage) checkOldObject(bucketName, objectName, versioning string)
 				}*/
 				version, err = object.GetVersionNumber()
 				if err != nil {
-					helper.Debugln("-----------old object version:", err)
-					return 0, err
+					helper.DebuglnContext(ctx, "-----------old object version:", err)
+					return 0, 0, err
 				}
-				helper.Debugln("-----------old object version:", version)
+				helper.DebuglnContext(ctx, "-----------old object version:", version)
 				return
 			}
 		} else {
 			if objectExist && object.NullVersion {
-				err = yig.removeByObject(object)
+				if object.IsLocked(time.Now(), false) {
+					return 0, 0, ErrObjectLocked
+				}
+				err = yig.removeObjectEntry(ctx, object)
+				if err == nil {
+					removed = object.Size
+				}
 			}
 		}
 		return
 	}
 
-	return 0, errors.New("No Such versioning status!")
+	return 0, 0, errors.New("No Such versioning status!")
 }
 
-func (yig *YigStorage) removeObjectVersion(bucketName, objectName, version string) error {
+// Returns whether the removed version is a delete marker
+func (yig *YigStorage) removeObjectVersion(ctx context.Context, bucketName, objectName, version string,
+	bypassGovernance bool) (deleteMarker bool, err error) {
+
 	object, err := yig.getObjWithVersion(bucketName, objectName, version)
 	if err == ErrNoSuchKey {
-		return nil
+		return false, nil
 	}
 	if err != nil {
-		return err
+		return false, err
 	}
-	err = yig.removeByObject(object)
+	if object.IsLocked(time.Now(), bypassGovernance) {
+		return false, ErrObjectLocked
+	}
+	err = yig.removeByObject(ctx, object)
 	if err != nil {
-		return err
+		return false, err
 	}
 	if version == "null" {
 		objMap := &meta.ObjMap{
 			Name:       objectName,
 			BucketName: bucketName,
 		}
-		err := yig.MetaStorage.DeleteObjMapEntry(objMap)
-		return err
+		err = yig.MetaStorage.DeleteObjMapEntry(objMap)
+		if err != nil {
+			return false, err
+		}
 	}
-	return nil
+	return object.DeleteMarker, nil
 }
 
 func (yig *YigStorage) addDeleteMarker(bucket meta.Bucket, objectName string,
@@ -969,6 +1250,40 @@ func (yig *YigStorage) addDeleteMarker(bucket meta.Bucket, objectName string,
 	return
 }
 
+// Objects without canned ACL could grant WRITE to users other than the
+// bucket owner
+func (yig *YigStorage) deleteAllowedByObjectGrants(ctx context.Context, bucketName,
+	objectName, version string, credential iam.Credential) bool {
+
+	var object *meta.Object
+	var err error
+	if version == "" {
+		object, err = yig.MetaStorage.GetObject(bucketName, objectName, true)
+	} else {
+		object, err = yig.getObjWithVersion(bucketName, objectName, version)
+	}
+	if err != nil || object.ACL.CannedAcl != "" {
+		return false
+	}
+	return yig.grantsAllow(ctx, object.ACL.Grants, credential, datatype.ACL_PERM_WRITE)
+}
+
+// Delete objects one by one, see DeleteObject. Cache invalidations are
+// batched and committed once all objects are deleted
+func (yig *YigStorage) DeleteObjects(ctx context.Context, bucketName string, objects []datatype.ObjectIdentifier,
+	credential iam.Credential, bypassGovernance bool) ([]datatype.DeleteObjectResult, []error) {
+
+	batch, cache := yig.withBatchCache()
+	results := make([]datatype.DeleteObjectResult, len(objects))
+	errs := make([]error, len(objects))
+	for i, object := range objects {
+		results[i], errs[i] = batch.DeleteObject(ctx, bucketName, object.ObjectName,
+			object.VersionId, credential, bypassGovernance)
+	}
+	cache.Commit()
+	return results, errs
+}
+
 // When bucket versioning is Disabled/Enabled/Suspended, and request versionId is set/unset:
 //
 // |           |        with versionId        |                   without versionId                    |
@@ -979,28 +1294,30 @@ func (yig *YigStorage) addDeleteMarker(bucket meta.Bucket, objectName string,
 // |           |                              | null version delete marker                             |
 //
 // See http://docs.aws.amazon.com/AmazonS3/latest/dev/Versioning.html
-func (yig *YigStorage) DeleteObject(bucketName string, objectName string, version string,
-	credential iam.Credential) (result datatype.DeleteObjectResult, err error) {
+//
+// Versions locked by Object Lock are not removed, `bypassGovernance` takes
+// effect only for bucket owner.
+func (yig *YigStorage) DeleteObject(ctx context.Context, bucketName string, objectName string, version string,
+	credential iam.Credential, bypassGovernance bool) (result datatype.DeleteObjectResult, err error) {
 
 	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
 	if err != nil {
 		return
 	}
-	switch bucket.ACL.CannedAcl {
-	case "public-read-write":
-		break
-	default:
-		if bucket.OwnerId != credential.UserId && credential.UserId != "" {
-			return result, ErrBucketAccessForbidden
-		}
-	} // TODO policy and fancy ACL
+	bypassGovernance = bypassGovernance && bucket.OwnerId == credential.UserId
+	if !yig.canWriteObjectsOf(ctx, bucket, credential) &&
+		!yig.deleteAllowedByObjectGrants(ctx, bucketName, objectName, version, credential) {
+		return result, ErrBucketAccessForbidden
+	} // TODO policy
 
 	switch bucket.Versioning {
 	case "Disabled":
 		if version != "" && version != "null" {
 			return result, ErrNoSuchVersion
 		}
-		err = yig.removeAllObjectsEntryByName(bucketName, objectName)
+		var removed int64
+		removed, err = yig.removeAllObjectsEntryByName(ctx, bucketName, objectName, bypassGovernance)
+		yig.updateUsage(bucketName, -removed)
 		if err != nil {
 			return
 		}
@@ -1012,7 +1329,8 @@ func (yig *YigStorage) DeleteObject(bucketName string, objectName string, versio
 			}
 			result.DeleteMarker = true
 		} else {
-			err = yig.removeObjectVersion(bucketName, objectName, version)
+			result.DeleteMarker, err = yig.removeObjectVersion(ctx, bucketName, objectName,
+				version, bypassGovernance)
 			if err != nil {
 				return
 			}
@@ -1020,7 +1338,7 @@ func (yig *YigStorage) DeleteObject(bucketName string, objectName string, versio
 		}
 	case "Suspended":
 		if version == "" {
-			err = yig.removeObjectVersion(bucketName, objectName, "null")
+			_, err = yig.removeObjectVersion(ctx, bucketName, objectName, "null", bypassGovernance)
 			if err != nil {
 				return
 			}
@@ -1030,25 +1348,24 @@ func (yig *YigStorage) DeleteObject(bucketName string, objectName string, versio
 			}
 			result.DeleteMarker = true
 		} else {
-			err = yig.removeObjectVersion(bucketName, objectName, version)
+			result.DeleteMarker, err = yig.removeObjectVersion(ctx, bucketName, objectName,
+				version, bypassGovernance)
 			if err != nil {
 				return
 			}
 			result.VersionId = version
 		}
 	default:
-		yig.Logger.Println(5, "Invalid bucket versioning: ", bucketName)
+		helper.LogWithContext(ctx, "Invalid bucket versioning: %s", bucketName)
 		return result, ErrInternalError
 	}
 
 	if err == nil {
+		// data cache is invalidated when versions are removed
 		yig.MetaStorage.Cache.Remove(redis.ObjectTable, bucketName+":"+objectName+":")
-		yig.DataCache.Remove(bucketName + ":" + objectName + ":")
-		yig.DataCache.Remove(bucketName + ":" + objectName + ":" + "null")
 		if version != "" {
 			yig.MetaStorage.Cache.Remove(redis.ObjectTable,
 				bucketName+":"+objectName+":"+version)
-			yig.DataCache.Remove(bucketName + ":" + objectName + ":" + version)
 		}
 	}
 	return result, nil
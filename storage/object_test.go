@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/redis"
+)
+
+func TestDeleteObjectVersion(t *testing.T) {
+	alice := iam.Credential{UserId: "alice"}
+	ctx := context.Background()
+	for _, deleteMarker := range []bool{false, true} {
+		yig, c := newRenameTestStorage()
+		c.PutBucket(types.Bucket{Name: "bucket", OwnerId: "alice", Versioning: "Enabled"})
+		version := c.putObject(&types.Object{Name: "a", BucketName: "bucket", OwnerId: "alice",
+			Location: "ceph", Pool: "rabbit", ObjectId: "oid-v", DeleteMarker: deleteMarker})
+
+		result, err := yig.DeleteObject(ctx, "bucket", "a", version.VersionId, alice, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.VersionId != version.VersionId || result.DeleteMarker != deleteMarker {
+			t.Errorf("delete marker %v: got %+v", deleteMarker, result)
+		}
+		if versions := c.versions("bucket", "a"); len(versions) != 1 || !versions[0].NullVersion {
+			t.Errorf("delete marker %v: only the version should be removed, got %v",
+				deleteMarker, versions)
+		}
+		if gc := len(c.gc) != 0; gc == deleteMarker {
+			t.Errorf("delete marker %v: gc %v", deleteMarker, c.gc)
+		}
+	}
+}
+
+func TestSameEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	cases := []struct {
+		sourceType string
+		sourceKey  []byte
+		targetType string
+		expected   bool
+	}{
+		{"S3", key, "S3", true},
+		{"S3", key, "", false},
+		{"S3", key, "C", false},
+		{"C", nil, "S3", false},
+		{"", nil, "S3", false},
+		{"S3", nil, "S3", false},
+	}
+	for _, c := range cases {
+		source := &types.Object{SseType: c.sourceType, EncryptionKey: c.sourceKey}
+		if same := sameEncryption(source, datatype.SseRequest{Type: c.targetType}); same != c.expected {
+			t.Errorf("%q to %q: expected %v, got %v", c.sourceType, c.targetType, c.expected, same)
+		}
+	}
+	if sameEncryption(nil, datatype.SseRequest{Type: "S3"}) {
+		t.Error("copy without source object should be re-encrypted")
+	}
+}
+
+func TestDeleteObjects(t *testing.T) {
+	alice := iam.Credential{UserId: "alice"}
+	yig, c := newRenameTestStorage()
+	cache := newFakeCache()
+	yig.MetaStorage.Cache = cache
+	object := c.latest("bucket", "a")
+	cache.values[redis.ObjectTable] = map[string]interface{}{"bucket:a:": object}
+
+	results, errs := yig.DeleteObjects(context.Background(), "bucket", []datatype.ObjectIdentifier{
+		{ObjectName: "a"}, {ObjectName: "a", VersionId: object.VersionId}, {ObjectName: "missing"},
+	}, alice, false)
+	if len(results) != 3 || errs[0] != nil || errs[1] != ErrNoSuchVersion || errs[2] != nil {
+		t.Fatalf("unexpected results %v %v", results, errs)
+	}
+	if c.latest("bucket", "a") != nil {
+		t.Error("object should be removed")
+	}
+	if _, ok := cache.values[redis.ObjectTable]["bucket:a:"]; ok {
+		t.Error("cache of removed object should be invalidated")
+	}
+}
+
+// Object "a" of `versioning` bucket, stored in cluster "ceph"
+func newCopyTestStorage(versioning string, legalHold bool) (*YigStorage, *fakeClient,
+	*types.Object, []byte) {
+
+	c := newFakeClient()
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "alice", Versioning: versioning})
+	yig := newTestStorage(c)
+	yig.DataStorage = map[string]*CephStorage{"ceph": newTestCluster("ceph")}
+	// used space of clusters is not checked
+	latestQueryTime[0], latestQueryTime[1] = time.Now(), time.Now()
+
+	data := []byte(strings.Repeat("hehe", 1000))
+	yig.DataStorage["ceph"].Put(SMALL_FILE_POOLNAME, "oid", bytes.NewReader(data))
+	sum := md5.Sum(data)
+	object := c.putObject(&types.Object{Name: "a", BucketName: "bucket", OwnerId: "alice",
+		Location: "ceph", Pool: SMALL_FILE_POOLNAME, ObjectId: "oid", Size: int64(len(data)),
+		Etag: hex.EncodeToString(sum[:]), ContentType: "text/plain",
+		NullVersion: versioning != "Enabled", LegalHold: legalHold,
+		LastModifiedTime: time.Now().Add(-time.Hour).UTC()})
+	return yig, c, object, data
+}
+
+// Copy `object` to itself with content type replaced, like the handler does
+func copyToItself(yig *YigStorage, object *types.Object) (datatype.PutObjectResult, error) {
+	replaced := *object
+	replaced.ContentType = "text/hehe"
+	return yig.CopyObject(context.Background(), &replaced, object, nil,
+		iam.Credential{UserId: "alice"}, datatype.SseRequest{})
+}
+
+func TestCopyObjectToItself(t *testing.T) {
+	yig, c, object, _ := newCopyTestStorage("Disabled", false)
+	result, err := copyToItself(yig, object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := c.versions("bucket", "a")
+	if len(versions) != 1 || versions[0].ContentType != "text/hehe" ||
+		versions[0].ObjectId != "oid" {
+
+		t.Fatalf("metadata should be replaced in place, got %+v", versions)
+	}
+	if !result.LastModified.After(object.LastModifiedTime) {
+		t.Errorf("last modified time should advance, got %v", result.LastModified)
+	}
+	head, err := yig.GetObjectInfo(context.Background(), "bucket", "a", "",
+		iam.Credential{UserId: "alice"})
+	if err != nil || !head.LastModifiedTime.Equal(result.LastModified) ||
+		head.ContentType != "text/hehe" {
+
+		t.Errorf("HEAD should return the new last modified time, got %+v %v", head, err)
+	}
+}
+
+func TestCopyVersionedObjectToItself(t *testing.T) {
+	yig, c, object, data := newCopyTestStorage("Enabled", false)
+	result, err := copyToItself(yig, object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := c.versions("bucket", "a")
+	if len(versions) != 2 {
+		t.Fatalf("a new version should be created, got %+v", versions)
+	}
+	latest := c.latest("bucket", "a")
+	if latest.ContentType != "text/hehe" || latest.GetVersionId() != result.VersionId ||
+		latest.ObjectId == "oid" {
+
+		t.Errorf("unexpected new version %+v", latest)
+	}
+	stored := storedData(yig.DataStorage["ceph"], latest.Pool, latest.ObjectId)
+	if !bytes.Equal(stored, data) {
+		t.Error("data should be copied to the new version")
+	}
+	old, err := c.GetObject("bucket", "a", object.VersionId)
+	if err != nil || old.ContentType != "text/plain" {
+		t.Errorf("old version should be kept as is, got %+v %v", old, err)
+	}
+}
+
+func TestCopyLockedObjectToItself(t *testing.T) {
+	queue := RecycleQueue
+	defer func() { RecycleQueue = queue }()
+	RecycleQueue = make(chan objectToRecycle, 10)
+
+	yig, c, object, _ := newCopyTestStorage("Disabled", true)
+	if _, err := copyToItself(yig, object); err != ErrObjectLocked {
+		t.Errorf("expected ErrObjectLocked, got %v", err)
+	}
+	if versions := c.versions("bucket", "a"); len(versions) != 1 ||
+		versions[0].ContentType != "text/plain" {
+
+		t.Errorf("locked object should be kept as is, got %+v", versions)
+	}
+	if r := <-RecycleQueue; r.objectId == "oid" {
+		t.Error("only data copied should be removed")
+	}
+}
+
+// Expire time is set by the handler, the copy should keep it whether or not
+// it's a new version, and be removed by lifecycle tool
+func TestCopyObjectToItselfWithTtl(t *testing.T) {
+	for _, versioning := range []string{"Disabled", "Enabled"} {
+		yig, c, object, _ := newCopyTestStorage(versioning, false)
+		replaced := *object
+		replaced.ExpireTime = time.Now().Add(time.Hour).UTC()
+		_, err := yig.CopyObject(context.Background(), &replaced, object, nil,
+			iam.Credential{UserId: "alice"}, datatype.SseRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest := c.latest("bucket", "a"); !latest.ExpireTime.Equal(replaced.ExpireTime) {
+			t.Errorf("%s: copy should expire at %v, got %v", versioning, replaced.ExpireTime,
+				latest.ExpireTime)
+		}
+		if _, ok := c.lifeCycles["bucket"]; !ok {
+			t.Errorf("%s: bucket should be scanned by lifecycle tool", versioning)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/api"
+	"github.com/journeymidnight/yig/api/objectlayertest"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+)
+
+// YigStorage with metadata kept by fakeClient, and data by a cluster in
+// memory
+func TestObjectLayer(t *testing.T) {
+	config := helper.CONFIG
+	defer func() {
+		helper.CONFIG = config
+		iam.SetupBackend()
+	}()
+	helper.CONFIG.IamBackend = iam.BACKEND_LOCAL
+	helper.CONFIG.IamCredentials = []helper.IamCredential{
+		{AccessKey: "alice", SecretKey: "alicealice", UserId: objectlayertest.Alice.UserId},
+		{AccessKey: "bob", SecretKey: "bobbobbob", UserId: objectlayertest.Bob.UserId},
+	}
+	if err := iam.SetupBackend(); err != nil {
+		t.Fatal(err)
+	}
+	// used space of clusters is not checked
+	latestQueryTime[0], latestQueryTime[1] = time.Now(), time.Now()
+	// data removed is left in memory
+	queue := RecycleQueue
+	RecycleQueue = make(chan objectToRecycle)
+	done := make(chan struct{})
+	go func() {
+		for range RecycleQueue {
+		}
+		close(done)
+	}()
+	defer func() {
+		close(RecycleQueue)
+		<-done
+		RecycleQueue = queue
+	}()
+
+	objectlayertest.Run(t, func(t *testing.T) api.ObjectLayer {
+		yig := newTestStorage(newFakeClient())
+		yig.DataStorage = map[string]*CephStorage{"ceph": newTestCluster("ceph")}
+		return yig
+	})
+}
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	meta "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/redis"
+)
+
+// Move objects of a bucket to another Ceph cluster or pool, triggered
+// by admin API. Tasks are processed one by one in background, for each
+// object version:
+// 1. copy data from current location to target cluster/pool
+// 2. update location/pool/object ids in metadata, only if they are not
+// changed since we read them
+// 3. remove data from the old location
+
+const (
+	REBALANCE_QUEUE_SIZE = 100
+	REBALANCE_LIST_KEYS  = 1000
+)
+
+type RebalanceTask struct {
+	Bucket        string `json:"bucket"`
+	Prefix        string `json:"prefix"`
+	TargetCluster string `json:"targetCluster"`
+	TargetPool    string `json:"targetPool"`
+}
+
+var RebalanceQueue chan RebalanceTask
+
+func initializeRebalancer(yig *YigStorage) {
+	if RebalanceQueue == nil {
+		RebalanceQueue = make(chan RebalanceTask, REBALANCE_QUEUE_SIZE)
+	}
+	go rebalance(yig)
+}
+
+// Accept "small"/"big" as aliases of the pool names
+func rebalancePoolName(pool string) (string, bool) {
+	switch pool {
+	case "small", SMALL_FILE_POOLNAME:
+		return SMALL_FILE_POOLNAME, true
+	case "big", BIG_FILE_POOLNAME:
+		return BIG_FILE_POOLNAME, true
+	}
+	return "", false
+}
+
+// Validate and enqueue a rebalance task, returns immediately
+func (yig *YigStorage) Rebalance(task RebalanceTask) error {
+	if task.Bucket == "" {
+		return ErrInvalidRebalanceRequest
+	}
+	if _, ok := yig.DataStorage[task.TargetCluster]; !ok {
+		return ErrInvalidRebalanceRequest
+	}
+	pool, ok := rebalancePoolName(task.TargetPool)
+	if !ok {
+		return ErrInvalidRebalanceRequest
+	}
+	task.TargetPool = pool
+	_, err := yig.MetaStorage.GetBucket(task.Bucket, false)
+	if err != nil {
+		return err
+	}
+	select {
+	case RebalanceQueue <- task:
+		return nil
+	default:
+		return ErrRebalanceQueueFull
+	}
+}
+
+func rebalance(yig *YigStorage) {
+	yig.WaitGroup.Add(1)
+	defer yig.WaitGroup.Done()
+	for {
+		select {
+		case task := <-RebalanceQueue:
+			yig.rebalanceBucket(task)
+		default:
+			if yig.Stopping {
+				helper.Logger.Print(5, ".")
+				return
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
+
+func (yig *YigStorage) rebalanceBucket(task RebalanceTask) {
+	helper.Logger.Printf(5, "Start rebalance task %+v\n", task)
+	limiter := newBandwidthLimiter(int64(helper.CONFIG.RebalanceBandwidth) << 20)
+	var moved, failed int
+	marker := ""
+	for {
+		objects, _, truncated, nextMarker, _, err := yig.MetaStorage.Client.ListObjects(
+			task.Bucket, marker, "", task.Prefix, "", false, REBALANCE_LIST_KEYS)
+		if err != nil {
+			helper.Logger.Println(5, "Rebalance: failed to list bucket",
+				task.Bucket, "with error", err)
+			return
+		}
+		for _, o := range objects {
+			if yig.Stopping {
+				helper.Logger.Println(5, "Rebalance: interrupted by stopping, task",
+					task, "last object", o.Name)
+				return
+			}
+			versions, err := yig.MetaStorage.GetAllObject(task.Bucket, o.Name)
+			if err != nil {
+				helper.Logger.Println(5, "Rebalance: failed to get versions of",
+					task.Bucket, o.Name, "with error", err)
+				failed += 1
+				continue
+			}
+			for _, object := range versions {
+				done, err := yig.rebalanceObject(object, task.TargetCluster,
+					task.TargetPool, limiter)
+				if err != nil {
+					helper.Logger.Println(5, "Rebalance: failed to move",
+						object.BucketName, object.Name, object.GetVersionId(),
+						"with error", err)
+					failed += 1
+					continue
+				}
+				if done {
+					moved += 1
+				}
+			}
+		}
+		if !truncated || nextMarker == "" {
+			break
+		}
+		marker = nextMarker
+	}
+	helper.Logger.Printf(5, "Finish rebalance task %+v, %d moved, %d failed\n",
+		task, moved, failed)
+}
+
+type cephObjectToMove struct {
+	oldObjectId string
+	newObjectId string
+}
+
+// Returns false if the object needs no moving
+func (yig *YigStorage) rebalanceObject(object *meta.Object, cluster, pool string,
+	limiter *bandwidthLimiter) (bool, error) {
+
+	if object.DeleteMarker {
+		return false, nil
+	}
+	if object.Location == cluster && object.Pool == pool {
+		return false, nil
+	}
+	err := yig.moveObject(object, cluster, pool, limiter)
+	return err == nil, err
+}
+
+// Copy data of `object` to `pool` of `cluster`, then update its metadata,
+// along with other changes made to `object` such as its storage class. The
+// update fails if location of the object has changed since it's read. Data
+// is copied only if location changes, and the old copy is removed after the
+// update
+func (yig *YigStorage) moveObject(object *meta.Object, cluster, pool string,
+	limiter *bandwidthLimiter) error {
+
+	source, ok := yig.DataStorage[object.Location]
+	if !ok {
+		return errors.New("Cannot find specified ceph cluster: " + object.Location)
+	}
+	target, ok := yig.DataStorage[cluster]
+	if !ok {
+		return errors.New("Cannot find specified ceph cluster: " + cluster)
+	}
+	oldLocation, oldPool := object.Location, object.Pool
+
+	var copied []cephObjectToMove
+	recycle := func(location, pool string, useNewId bool) {
+		for _, c := range copied {
+			oid := helper.Ternary(useNewId, c.newObjectId, c.oldObjectId).(string)
+			RecycleQueue <- objectToRecycle{
+				location: location,
+				pool:     pool,
+				objectId: oid,
+			}
+		}
+	}
+
+	switch {
+	case oldLocation == cluster && oldPool == pool:
+		// only metadata changes
+	case len(object.Parts) == 0:
+		oid, err := copyCephObject(source, target, oldPool, pool,
+			object.ObjectId, object.Size, limiter)
+		if err != nil {
+			return err
+		}
+		copied = append(copied, cephObjectToMove{object.ObjectId, oid})
+		object.ObjectId = oid
+	default:
+		for _, p := range object.Parts {
+			oid, err := copyCephObject(source, target, oldPool, pool,
+				p.ObjectId, p.Size, limiter)
+			if err != nil {
+				recycle(cluster, pool, true)
+				return err
+			}
+			copied = append(copied, cephObjectToMove{p.ObjectId, oid})
+			p.ObjectId = oid
+		}
+	}
+
+	object.Location, object.Pool = cluster, pool
+	processed, err := yig.MetaStorage.UpdateObjectLocation(object, oldLocation, oldPool)
+	if err != nil || !processed {
+		recycle(cluster, pool, true)
+		if err == nil {
+			err = errors.New("object changed while being moved")
+		}
+		return err
+	}
+	recycle(oldLocation, oldPool, false)
+
+	yig.MetaStorage.Cache.Remove(redis.ObjectTable,
+		object.BucketName+":"+object.Name+":")
+	yig.MetaStorage.Cache.Remove(redis.ObjectTable,
+		object.BucketName+":"+object.Name+":"+object.GetVersionId())
+	return nil
+}
+
+// Copy raw data of a Ceph object, data is not decrypted since
+// encryption key and IV stay unchanged
+func copyCephObject(source, target *CephStorage, sourcePool, targetPool,
+	oid string, size int64, limiter *bandwidthLimiter) (newOid string, err error) {
+
+	reader, err := source.getReader(sourcePool, oid, 0, size)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	newOid = target.GetUniqUploadName()
+	written, err := target.Put(targetPool, newOid, limiter.wrap(reader))
+	if err == nil && written != size {
+		err = errors.New("incomplete copy of " + oid)
+	}
+	if err != nil {
+		RecycleQueue <- objectToRecycle{
+			location: target.Name,
+			pool:     targetPool,
+			objectId: newOid,
+		}
+	}
+	return
+}
+
+// Limit average bandwidth of all readers wrapped by the same limiter
+type bandwidthLimiter struct {
+	bytesPerSecond int64
+	start          time.Time
+	transferred    int64
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		bytesPerSecond: bytesPerSecond,
+		start:          time.Now(),
+	}
+}
+
+func (l *bandwidthLimiter) wrap(reader io.Reader) io.Reader {
+	return &limitedReader{reader: reader, limiter: l}
+}
+
+func (l *bandwidthLimiter) consume(n int) {
+	if l.bytesPerSecond <= 0 {
+		return
+	}
+	l.transferred += int64(n)
+	expected := time.Duration(float64(l.transferred) / float64(l.bytesPerSecond) *
+		float64(time.Second))
+	if elapsed := time.Since(l.start); elapsed < expected {
+		time.Sleep(expected - elapsed)
+	}
+}
+
+type limitedReader struct {
+	reader  io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.limiter.consume(n)
+	return
+}
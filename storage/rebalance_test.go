@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/journeymidnight/yig/meta/types"
+)
+
+// moveRaceClient runs `before` ahead of updating location of an object,
+// e.g. to move it elsewhere meanwhile
+type moveRaceClient struct {
+	*fakeClient
+	before func()
+}
+
+func (c *moveRaceClient) UpdateObjectLocation(object *types.Object, oldLocation,
+	oldPool string) (bool, error) {
+
+	c.before()
+	return c.fakeClient.UpdateObjectLocation(object, oldLocation, oldPool)
+}
+
+// Object "a" in cluster "old", with an empty cluster "new". Data removed
+// is queued in RecycleQueue, which is restored by the returned function
+func newRebalanceTestStorage(c *fakeClient) (*YigStorage, []byte, func()) {
+	queue := RecycleQueue
+	RecycleQueue = make(chan objectToRecycle, 10)
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "alice"})
+	yig := newTestStorage(c)
+	yig.DataStorage = map[string]*CephStorage{
+		"old": newTestCluster("old"),
+		"new": newTestCluster("new"),
+	}
+	data := []byte(strings.Repeat("hehe", 100000))
+	yig.DataStorage["old"].Put(BIG_FILE_POOLNAME, "oid", bytes.NewReader(data))
+	c.putObject(&types.Object{Name: "a", BucketName: "bucket", OwnerId: "alice",
+		Location: "old", Pool: BIG_FILE_POOLNAME, ObjectId: "oid", Size: int64(len(data)),
+		ContentType: "text/plain"})
+	return yig, data, func() { RecycleQueue = queue }
+}
+
+func TestRebalanceBucket(t *testing.T) {
+	c := newFakeClient()
+	yig, data, restore := newRebalanceTestStorage(c)
+	defer restore()
+
+	yig.rebalanceBucket(RebalanceTask{Bucket: "bucket", TargetCluster: "new",
+		TargetPool: BIG_FILE_POOLNAME})
+	object := c.latest("bucket", "a")
+	if object.Location != "new" || object.ObjectId == "oid" || object.ContentType != "text/plain" {
+		t.Fatalf("object should be moved to the new cluster, got %+v", object)
+	}
+	if !bytes.Equal(storedData(yig.DataStorage["new"], BIG_FILE_POOLNAME, object.ObjectId), data) {
+		t.Error("data should be copied to the new cluster")
+	}
+	if r := <-RecycleQueue; r.location != "old" || r.objectId != "oid" {
+		t.Errorf("data in the old cluster should be removed, got %+v", r)
+	}
+}
+
+func TestMoveObjectRace(t *testing.T) {
+	c := &moveRaceClient{fakeClient: newFakeClient()}
+	yig, _, restore := newRebalanceTestStorage(c.fakeClient)
+	defer restore()
+	yig.MetaStorage.Client = c
+	object := c.latest("bucket", "a")
+
+	// transitioned to another pool while being moved
+	c.before = func() {
+		o := c.latest("bucket", "a")
+		o.Pool, o.StorageClass = SMALL_FILE_POOLNAME, "GLACIER"
+		c.fakeClient.UpdateObjectLocation(o, "old", BIG_FILE_POOLNAME)
+	}
+	limiter := newBandwidthLimiter(0)
+	if err := yig.moveObject(object, "new", BIG_FILE_POOLNAME, limiter); err == nil {
+		t.Fatal("move should fail if the object is moved meanwhile")
+	}
+	if o := c.latest("bucket", "a"); o.Location != "old" || o.Pool != SMALL_FILE_POOLNAME ||
+		o.ObjectId != "oid" {
+
+		t.Errorf("location of the winner should be kept, got %+v", o)
+	}
+	if r := <-RecycleQueue; r.location != "new" || r.objectId != object.ObjectId {
+		t.Errorf("data copied should be removed, got %+v", r)
+	}
+	if len(RecycleQueue) != 0 {
+		t.Error("data at the location of the winner should be kept")
+	}
+}
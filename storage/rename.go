@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+	meta "github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/redis"
+)
+
+// Whether `a` and `b` point to the same data in Ceph
+func sameData(a, b *meta.Object) bool {
+	if a.Location != b.Location || a.Pool != b.Pool || a.ObjectId != b.ObjectId ||
+		len(a.Parts) != len(b.Parts) {
+		return false
+	}
+	for n, part := range a.Parts {
+		if other, ok := b.Parts[n]; !ok || other.ObjectId != part.ObjectId {
+			return false
+		}
+	}
+	return true
+}
+
+// Data of a removed object should be left alone if the object it's shared
+// with(see RenameObject) still references it. Lookup errors keep the data,
+// leaking it is better than losing it
+func (yig *YigStorage) dataSharedWithPeer(ctx context.Context, object *meta.Object) bool {
+	if object.SharedWith == "" {
+		return false
+	}
+	peer, err := yig.nullVersionOf(object.BucketName, object.SharedWith)
+	if err == ErrNoSuchKey || (err == nil && peer.Name != object.SharedWith) {
+		return false
+	}
+	if err != nil {
+		helper.LogWithContext(ctx, "Inconsistent data: data of object might be leaked: %s %s %s %v",
+			object.BucketName, object.Name, object.GetVersionId(), err)
+		return true
+	}
+	return sameData(object, peer)
+}
+
+// Objects are only renamed in buckets without versioning, so both of them are
+// `null` versions. Versioning could be enabled afterwards, and newer versions
+// put on top of the peer, so the `null` version is looked up instead of the
+// latest one. Cache is bypassed, the peer might be put or removed just now
+func (yig *YigStorage) nullVersionOf(bucketName, objectName string) (*meta.Object, error) {
+	objMap, err := yig.MetaStorage.Client.GetObjectMap(bucketName, objectName)
+	if err == nil {
+		return yig.MetaStorage.Client.GetObject(bucketName, objectName, objMap.GetVersionId())
+	}
+	if err != ErrNoSuchKey {
+		return nil, err
+	}
+	object, err := yig.MetaStorage.Client.GetObject(bucketName, objectName, "")
+	if err != nil {
+		return nil, err
+	}
+	if !object.NullVersion {
+		return nil, ErrNoSuchKey
+	}
+	return object, nil
+}
+
+// Rename an object within a bucket by moving its metadata entry, data in
+// Ceph is not copied. Both entries are marked as sharing data before the
+// old one is removed, so data is kept if the rename is interrupted half way
+// and either of them is deleted later.
+//
+// Only supported for buckets without versioning
+func (yig *YigStorage) RenameObject(ctx context.Context, bucketName, sourceName, targetName string,
+	credential iam.Credential) (result datatype.PutObjectResult, err error) {
+
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	if err != nil {
+		return
+	}
+	if !yig.canWriteObjectsOf(ctx, bucket, credential) {
+		return result, ErrBucketAccessForbidden
+	}
+	if bucket.Versioning != "Disabled" {
+		return result, ErrNotImplemented
+	}
+	if sourceName == targetName {
+		return result, ErrInvalidCopyDest
+	}
+
+	source, err := yig.MetaStorage.GetObject(bucketName, sourceName, false)
+	if err != nil {
+		return
+	}
+	if source.IsLocked(time.Now(), false) {
+		return result, ErrObjectLocked
+	}
+	source.SharedWith = targetName
+	err = yig.MetaStorage.UpdateObjectSharedWith(source)
+	if err != nil {
+		return
+	}
+
+	var removed int64
+	_, removed, err = yig.checkOldObject(ctx, bucketName, targetName, bucket.Versioning)
+	if err != nil {
+		yig.updateUsage(bucketName, -removed)
+		yig.unshareObject(ctx, source)
+		return
+	}
+
+	target := *source
+	target.Name = targetName
+	target.SharedWith = sourceName
+	target.Rowkey = nil
+	target.VersionId = ""
+	target.LastModifiedTime = time.Now().UTC()
+	err = yig.MetaStorage.PutObjectEntry(&target)
+	if err != nil {
+		yig.updateUsage(bucketName, -removed)
+		yig.unshareObject(ctx, source)
+		return
+	}
+
+	err = yig.MetaStorage.DeleteObjectEntry(source)
+	if err != nil {
+		yig.updateUsage(bucketName, -removed)
+		if rollbackErr := yig.MetaStorage.DeleteObjectEntry(&target); rollbackErr != nil {
+			helper.LogWithContext(ctx, "Inconsistent data: object should be removed: %s %s %s %v",
+				bucketName, targetName, target.GetVersionId(), rollbackErr)
+			return
+		}
+		yig.unshareObject(ctx, source)
+		return
+	}
+	yig.MetaStorage.Cache.Remove(redis.ObjectTable, bucketName+":"+sourceName+":")
+	yig.DataCache.Remove(dataCacheKey(bucketName, sourceName, source.GetVersionId()))
+	yig.updateUsage(bucketName, -removed)
+	yig.unshareObject(ctx, &target)
+
+	result.Md5 = target.Etag
+	result.LastModified = target.LastModifiedTime
+	result.SseType = target.SseType
+	return result, nil
+}
+
+// Best effort, a stale mark only costs a lookup when the object is removed
+func (yig *YigStorage) unshareObject(ctx context.Context, object *meta.Object) {
+	object.SharedWith = ""
+	if err := yig.MetaStorage.UpdateObjectSharedWith(object); err != nil {
+		helper.LogWithContext(ctx, "Error clearing shared mark of object: %s %s %v",
+			object.BucketName, object.Name, err)
+	}
+}
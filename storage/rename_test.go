@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/meta/types"
+)
+
+// deleteFailureClient fails DeleteObject with `err`
+type deleteFailureClient struct {
+	*fakeClient
+	err error
+}
+
+func (c *deleteFailureClient) DeleteObject(object *types.Object) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.fakeClient.DeleteObject(object)
+}
+
+func newRenameTestStorage() (*YigStorage, *deleteFailureClient) {
+	c := &deleteFailureClient{fakeClient: newFakeClient()}
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "alice", Versioning: "Disabled"})
+	c.putObject(&types.Object{Name: "a", BucketName: "bucket", OwnerId: "alice", Size: 10,
+		Location: "ceph", Pool: "rabbit", ObjectId: "oid-a", Etag: "etag-a",
+		NullVersion: true, LastModifiedTime: time.Now().Add(-time.Hour)})
+	return newTestStorage(c), c
+}
+
+func TestRenameObject(t *testing.T) {
+	yig, c := newRenameTestStorage()
+	alice := iam.Credential{UserId: "alice"}
+	ctx := context.Background()
+
+	result, err := yig.RenameObject(ctx, "bucket", "a", "b", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Md5 != "etag-a" {
+		t.Errorf("etag of the source should be returned, got %s", result.Md5)
+	}
+	if c.latest("bucket", "a") != nil {
+		t.Error("source should be removed")
+	}
+	b := c.latest("bucket", "b")
+	if b == nil || b.ObjectId != "oid-a" || b.SharedWith != "" {
+		t.Errorf("target should point to data of the source, got %+v", b)
+	}
+	if usage := c.buckets["bucket"].Usage; len(c.gc) != 0 || usage != 0 {
+		t.Errorf("data should not be removed or counted again, gc %v usage %d", c.gc, usage)
+	}
+
+	if _, err := yig.DeleteObject(ctx, "bucket", "b", "", alice, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.gc) != 1 || c.gc[0].ObjectId != "oid-a" {
+		t.Errorf("data should be removed along with the last name, gc %v", c.gc)
+	}
+
+	for _, c := range []struct {
+		source, target string
+		err            error
+	}{
+		{"a", "a", ErrInvalidCopyDest},
+		{"missing", "c", ErrNoSuchKey},
+	} {
+		yig, _ := newRenameTestStorage()
+		if _, err := yig.RenameObject(ctx, "bucket", c.source, c.target, alice); err != c.err {
+			t.Errorf("%s to %s: expected %v, got %v", c.source, c.target, c.err, err)
+		}
+	}
+
+	yig, c = newRenameTestStorage()
+	c.PutBucket(types.Bucket{Name: "bucket", OwnerId: "alice", Versioning: "Enabled"})
+	if _, err := yig.RenameObject(ctx, "bucket", "a", "b", alice); err != ErrNotImplemented {
+		t.Errorf("rename in versioned bucket should be rejected, got %v", err)
+	}
+	yig, _ = newRenameTestStorage()
+	if _, err := yig.RenameObject(ctx, "bucket", "a", "b", iam.Credential{UserId: "bob"}); err != ErrBucketAccessForbidden {
+		t.Errorf("rename by others should be forbidden, got %v", err)
+	}
+}
+
+// Rename fails to remove the source, and its rollback fails too, leaving
+// both names referencing the same data. Data should outlive either of them
+func TestRenameObjectInterrupted(t *testing.T) {
+	alice := iam.Credential{UserId: "alice"}
+	ctx := context.Background()
+	for _, order := range [][]string{{"a", "b"}, {"b", "a"}} {
+		yig, c := newRenameTestStorage()
+		c.err = errors.New("hbase down")
+		if _, err := yig.RenameObject(ctx, "bucket", "a", "b", alice); err != c.err {
+			t.Fatalf("expected error of DeleteObject, got %v", err)
+		}
+		if c.latest("bucket", "a").SharedWith != "b" || c.latest("bucket", "b").SharedWith != "a" {
+			t.Fatal("both names should be marked as sharing data")
+		}
+		c.err = nil
+
+		if _, err := yig.DeleteObject(ctx, "bucket", order[0], "", alice, false); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.gc) != 0 {
+			t.Errorf("%v: data should be kept while %s exists", order, order[1])
+		}
+		if _, err := yig.DeleteObject(ctx, "bucket", order[1], "", alice, false); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.gc) != 1 || c.gc[0].ObjectId != "oid-a" {
+			t.Errorf("%v: data should be removed along with the last name, gc %v", order, c.gc)
+		}
+	}
+}
+
+// Versioning is enabled after an interrupted rename, and a newer version is
+// put on top of one name. Data should be kept for its `null` version
+func TestRenameObjectVersionedLater(t *testing.T) {
+	alice := iam.Credential{UserId: "alice"}
+	ctx := context.Background()
+	yig, c := newRenameTestStorage()
+	c.err = errors.New("hbase down")
+	if _, err := yig.RenameObject(ctx, "bucket", "a", "b", alice); err != c.err {
+		t.Fatalf("expected error of DeleteObject, got %v", err)
+	}
+	c.err = nil
+
+	c.PutBucket(types.Bucket{Name: "bucket", OwnerId: "alice", Versioning: "Enabled"})
+	nullB := c.latest("bucket", "b")
+	c.PutObjectMap(&types.ObjMap{Name: "b", BucketName: "bucket",
+		NullVerNum: uint64(nullB.LastModifiedTime.UnixNano())})
+	c.putObject(&types.Object{Name: "b", BucketName: "bucket", OwnerId: "alice",
+		Location: "ceph", Pool: "rabbit", ObjectId: "oid-b"})
+
+	if _, err := yig.DeleteObject(ctx, "bucket", "a", "null", alice, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.gc) != 0 {
+		t.Errorf("data should be kept for the null version of b, gc %v", c.gc)
+	}
+
+	// once the null version of the peer is gone, data goes with the last name,
+	// newer versions of the peer never share it
+	yig, c = newRenameTestStorage()
+	a := c.latest("bucket", "a")
+	a.SharedWith = "b"
+	c.UpdateObjectSharedWith(a)
+	c.putObject(&types.Object{Name: "b", BucketName: "bucket", OwnerId: "alice",
+		Location: "ceph", Pool: "rabbit", ObjectId: "oid-a"})
+	if _, err := yig.DeleteObject(ctx, "bucket", "a", "", alice, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.gc) != 1 || c.gc[0].ObjectId != "oid-a" {
+		t.Errorf("data should be removed without the null version of b, gc %v", c.gc)
+	}
+}
+
+func TestSameData(t *testing.T) {
+	a := &types.Object{Location: "ceph", Pool: "rabbit", ObjectId: "oid",
+		Parts: map[int]*types.Part{1: {ObjectId: "p1"}}}
+	b := *a
+	if !sameData(a, &b) {
+		t.Error("copy of the object should share its data")
+	}
+	b.Parts = map[int]*types.Part{1: {ObjectId: "p2"}}
+	if sameData(a, &b) {
+		t.Error("objects with different parts should not share data")
+	}
+	b = *a
+	b.ObjectId = "other"
+	if sameData(a, &b) {
+		t.Error("objects with different ids should not share data")
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	meta "github.com/journeymidnight/yig/meta/types"
+)
+
+// Archived objects are kept in Ceph pools as any others, so a restore
+// completes at once and only its expiry is recorded. Returns whether the
+// object was restored already, in which case the expiry is updated
+func (yig *YigStorage) RestoreObject(ctx context.Context, bucketName, objectName, version string,
+	request datatype.RestoreRequest, credential iam.Credential) (restored bool, err error) {
+
+	bucket, err := yig.MetaStorage.GetBucket(bucketName, true)
+	if err != nil {
+		return
+	}
+	if !yig.canWriteObjectsOf(ctx, bucket, credential) {
+		return false, ErrBucketAccessForbidden
+	}
+	var object *meta.Object
+	if version == "" {
+		object, err = yig.MetaStorage.GetObject(bucketName, objectName, false)
+	} else {
+		object, err = yig.getObjWithVersion(bucketName, objectName, version)
+	}
+	if err != nil {
+		return
+	}
+	if object.DeleteMarker {
+		return false, ErrNoSuchKey
+	}
+	if !datatype.IsRestorableStorageClass(object.StorageClass) {
+		return false, ErrInvalidObjectState
+	}
+	now := time.Now()
+	restored = object.RestoreExpiryDate.After(now)
+	object.RestoreExpiryDate = datatype.RestoreExpiryDate(now, request.Days)
+	// only the expiry is saved, so changes made meanwhile aren't reverted
+	// and a removed object isn't brought back
+	return restored, yig.MetaStorage.UpdateObjectRestoreExpiry(object)
+}
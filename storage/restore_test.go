@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/meta/types"
+)
+
+// restoreRaceClient runs `before` ahead of updating restore expiry of an
+// object, e.g. to remove it meanwhile
+type restoreRaceClient struct {
+	*fakeClient
+	before func()
+}
+
+func (c *restoreRaceClient) UpdateObjectRestoreExpiry(object *types.Object) error {
+	c.before()
+	return c.fakeClient.UpdateObjectRestoreExpiry(object)
+}
+
+func TestRestoreObject(t *testing.T) {
+	c := &restoreRaceClient{fakeClient: newFakeClient(), before: func() {}}
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "alice"})
+	c.putObject(&types.Object{Name: "a", BucketName: "bucket", OwnerId: "alice",
+		Location: "ceph", Pool: "rabbit", ObjectId: "oid", Etag: "etag",
+		StorageClass: datatype.STORAGE_CLASS_GLACIER})
+	yig := newTestStorage(c)
+	alice := iam.Credential{UserId: "alice"}
+	ctx := context.Background()
+	request := datatype.RestoreRequest{Days: 1}
+
+	for _, expected := range []bool{false, true} {
+		restored, err := yig.RestoreObject(ctx, "bucket", "a", "", request, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored != expected {
+			t.Errorf("expected restored %v, got %v", expected, restored)
+		}
+		if o := c.latest("bucket", "a"); o.RestoreExpiryDate.IsZero() || o.ObjectId != "oid" {
+			t.Errorf("restore expiry should be saved, got %+v", o)
+		}
+	}
+
+	// removed while being restored
+	c.before = func() {
+		c.DeleteObject(c.latest("bucket", "a"))
+	}
+	_, err := yig.RestoreObject(ctx, "bucket", "a", "", request, alice)
+	if err != ErrNoSuchKey {
+		t.Errorf("expected ErrNoSuchKey, got %v", err)
+	}
+	if versions := c.versions("bucket", "a"); len(versions) != 0 {
+		t.Errorf("removed object should not be brought back, got %v", versions)
+	}
+}
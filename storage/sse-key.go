@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"sync/atomic"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+// Rotate SSE-S3 master key, triggered by admin API after SseS3MasterKey is
+// changed and the old one is added to SseS3PreviousKeys. Data keys of all
+// SSE-S3 objects are encrypted again with the current master key in
+// background, objects themselves are not re-encrypted. The old master key
+// could be removed from config once rotation finishes without failures.
+
+const SSE_KEY_ROTATION_LIST_SIZE = 1000
+
+var sseKeyRotating int32
+
+// Start rotation in background, returns immediately
+func (yig *YigStorage) RotateSseKey() error {
+	if !atomic.CompareAndSwapInt32(&sseKeyRotating, 0, 1) {
+		return ErrSseKeyRotationInProgress
+	}
+	yig.WaitGroup.Add(1)
+	go func() {
+		defer yig.WaitGroup.Done()
+		defer atomic.StoreInt32(&sseKeyRotating, 0)
+		yig.rotateSseKey()
+	}()
+	return nil
+}
+
+func (yig *YigStorage) rotateSseKey() {
+	helper.Logger.Println(5, "Start SSE-S3 master key rotation")
+	var rotated, failed int
+	marker := ""
+	for {
+		buckets, truncated, err := yig.MetaStorage.Client.ListBuckets(marker,
+			SSE_KEY_ROTATION_LIST_SIZE)
+		if err != nil {
+			helper.Logger.Println(5, "SSE-S3 key rotation: failed to list buckets after",
+				marker, "with error", err)
+			return
+		}
+		for _, bucketName := range buckets {
+			r, f, done := yig.rotateBucketSseKeys(bucketName)
+			rotated += r
+			failed += f
+			helper.Logger.Println(5, "SSE-S3 key rotation: bucket", bucketName,
+				r, "rotated,", f, "failed")
+			if !done {
+				helper.Logger.Println(5, "SSE-S3 key rotation: interrupted at bucket",
+					bucketName, rotated, "rotated,", failed, "failed")
+				return
+			}
+		}
+		if !truncated || len(buckets) == 0 {
+			break
+		}
+		marker = buckets[len(buckets)-1]
+	}
+	helper.Logger.Printf(5, "Finish SSE-S3 master key rotation, %d rotated, %d failed\n",
+		rotated, failed)
+}
+
+// Versions are listed so those hidden by delete markers are rotated too,
+// returns false if the bucket is not fully scanned
+func (yig *YigStorage) rotateBucketSseKeys(bucketName string) (rotated, failed int, done bool) {
+	marker, verIdMarker := "", ""
+	for {
+		objects, _, truncated, nextMarker, nextVerIdMarker, err := yig.MetaStorage.Client.ListObjects(
+			bucketName, marker, verIdMarker, "", "", true, SSE_KEY_ROTATION_LIST_SIZE)
+		if err != nil {
+			helper.Logger.Println(5, "SSE-S3 key rotation: failed to list bucket",
+				bucketName, "with error", err)
+			return rotated, failed, false
+		}
+		for _, object := range objects {
+			if yig.Stopping {
+				return rotated, failed, false
+			}
+			if object.SseType != "S3" || len(object.EncryptionKey) == 0 {
+				continue
+			}
+			err = yig.MetaStorage.Client.UpdateObjectSseKey(object)
+			if err != nil {
+				helper.Logger.Println(5, "SSE-S3 key rotation: failed to update",
+					object.BucketName, object.Name, object.GetVersionId(),
+					"with error", err)
+				failed += 1
+				continue
+			}
+			rotated += 1
+		}
+		if !truncated || nextMarker == "" {
+			return rotated, failed, true
+		}
+		marker, verIdMarker = nextMarker, nextVerIdMarker
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/iam"
+)
+
+const (
+	MAX_STAT_OBJECTS = 1000
+	STAT_CONCURRENCY = 16
+)
+
+type ObjectKey struct {
+	Bucket string
+	Key    string
+}
+
+// Result of stat for one object, `Error` is set instead of failing the
+// whole batch if the object is missing or inaccessible
+type ObjectStat struct {
+	Bucket       string
+	Key          string
+	Size         int64     `json:",omitempty"`
+	Etag         string    `json:",omitempty"`
+	ContentType  string    `json:",omitempty"`
+	LastModified time.Time `json:",omitempty"`
+	Error        string    `json:",omitempty"`
+}
+
+// Get metadata of many objects at once, via `GetObjectInfo` with at most
+// STAT_CONCURRENCY requests in flight. Results are in the same order as keys.
+func (yig *YigStorage) StatObjects(ctx context.Context, keys []ObjectKey,
+	credential iam.Credential) []ObjectStat {
+
+	stats := make([]ObjectStat, len(keys))
+	tokens := make(chan struct{}, STAT_CONCURRENCY)
+	var wg sync.WaitGroup
+	for i, k := range keys {
+		wg.Add(1)
+		tokens <- struct{}{}
+		go func(i int, k ObjectKey) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			stat := ObjectStat{Bucket: k.Bucket, Key: k.Key}
+			object, err := yig.GetObjectInfo(ctx, k.Bucket, k.Key, "", credential)
+			if err == nil && object.DeleteMarker {
+				err = ErrNoSuchKey
+			}
+			if apiErr, ok := err.(ApiError); ok {
+				stat.Error = apiErr.AwsErrorCode()
+			} else if err != nil {
+				stat.Error = err.Error()
+			} else {
+				stat.Size = object.Size
+				stat.Etag = object.Etag
+				stat.ContentType = object.ContentType
+				stat.LastModified = object.LastModifiedTime
+			}
+			stats[i] = stat
+		}(i, k)
+	}
+	wg.Wait()
+	return stats
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/meta/types"
+)
+
+func TestStatObjects(t *testing.T) {
+	now := time.Now().UTC()
+	c := newFakeClient()
+	c.putBucket(types.Bucket{Name: "bucket", OwnerId: "user"})
+	for _, o := range []*types.Object{
+		{Name: "a", BucketName: "bucket", OwnerId: "user", Size: 1, Etag: "etag-a",
+			ContentType: "text/plain"},
+		{Name: "b", BucketName: "bucket", OwnerId: "user", Size: 2, Etag: "etag-b"},
+		{Name: "marker", BucketName: "bucket", OwnerId: "user", DeleteMarker: true},
+		{Name: "private", BucketName: "bucket", OwnerId: "other"},
+	} {
+		o.LastModifiedTime = now
+		c.putObject(o)
+	}
+	yig := newTestStorage(c)
+
+	keys := []ObjectKey{
+		{"bucket", "a"},
+		{"bucket", "missing"},
+		{"bucket", "b"},
+		{"bucket", "marker"},
+		{"bucket", "private"},
+		{"nobucket", "a"},
+	}
+	var expected = []ObjectStat{
+		{Bucket: "bucket", Key: "a", Size: 1, Etag: "etag-a", ContentType: "text/plain",
+			LastModified: now},
+		{Bucket: "bucket", Key: "missing", Error: "NoSuchKey"},
+		{Bucket: "bucket", Key: "b", Size: 2, Etag: "etag-b", LastModified: now},
+		{Bucket: "bucket", Key: "marker", Error: "NoSuchKey"},
+		{Bucket: "bucket", Key: "private", Error: "AccessDenied"},
+		{Bucket: "nobucket", Key: "a", Error: "NoSuchBucket"},
+	}
+
+	stats := yig.StatObjects(context.Background(), keys, iam.Credential{UserId: "user"})
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(stats))
+	}
+	for i := range expected {
+		if stats[i] != expected[i] {
+			t.Errorf("%s/%s: expected %+v, got %+v", keys[i].Bucket, keys[i].Key,
+				expected[i], stats[i])
+		}
+	}
+}
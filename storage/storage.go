@@ -6,6 +6,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"io"
+	"io/ioutil"
 	"path/filepath"
 	"sync"
 
@@ -35,6 +36,35 @@ type YigStorage struct {
 	Logger      *log.Logger
 	Stopping    bool
 	WaitGroup   *sync.WaitGroup
+	// bucket name -> *bucketLimiter
+	bucketLimiters sync.Map
+	// fsid -> bool, updated by initializeClusterHealthChecker
+	clusterHealth sync.Map
+}
+
+// A copy of yig whose removals of metadata cache entries are held by the
+// returned cache until committed. Bucket limiters and cluster health are
+// not shared with the copy, it's only meant for object operations
+func (yig *YigStorage) withBatchCache() (*YigStorage, *meta.BatchCache) {
+	cache := meta.NewBatchCache(yig.MetaStorage.Cache)
+	return &YigStorage{
+		DataStorage: yig.DataStorage,
+		DataCache:   yig.DataCache,
+		MetaStorage: &meta.Meta{
+			Client: yig.MetaStorage.Client,
+			Logger: yig.MetaStorage.Logger,
+			Cache:  cache,
+		},
+		Logger:    yig.Logger,
+		Stopping:  yig.Stopping,
+		WaitGroup: yig.WaitGroup,
+	}, cache
+}
+
+// Whether Redis is needed by configured caches, YIG runs without Redis
+// if not
+func RedisEnabled() bool {
+	return meta.CacheType(helper.CONFIG.MetaCacheType).UsesRedis() || helper.CONFIG.EnableDataCache
 }
 
 func New(logger *log.Logger, metaCacheType int, enableDataCache bool, CephConfigPattern string) *YigStorage {
@@ -65,6 +95,8 @@ func New(logger *log.Logger, metaCacheType int, enableDataCache bool, CephConfig
 	}
 
 	initializeRecycler(&yig)
+	initializeRebalancer(&yig)
+	initializeClusterHealthChecker(&yig)
 	return &yig
 }
 
@@ -133,19 +165,30 @@ type alignedReader struct {
 }
 
 func (r *alignedReader) Read(p []byte) (n int, err error) {
-	if r.aligned {
-		return r.reader.Read(p)
+	if !r.aligned {
+		r.aligned = true
+		// the first read may return less than `offset` bytes
+		_, err = io.CopyN(ioutil.Discard, r.reader, r.offset)
+		if err != nil {
+			return 0, err
+		}
 	}
+	return r.reader.Read(p)
+}
 
-	r.aligned = true
-	buffer := make([]byte, len(p))
-	n, err = r.reader.Read(buffer)
-	if err != nil {
-		return
+// CTR counter of the block at `blockIndex`, i.e. `initializationVector` as
+// a big endian integer plus `blockIndex`, the same as what cipher.NewCTR
+// reaches after that many blocks
+func counterAt(initializationVector []byte, blockIndex int64) []byte {
+	counter := make([]byte, len(initializationVector))
+	copy(counter, initializationVector)
+	carry := uint64(blockIndex)
+	for i := len(counter) - 1; i >= 0 && carry != 0; i-- {
+		sum := uint64(counter[i]) + carry&0xff
+		counter[i] = byte(sum)
+		carry = carry>>8 + sum>>8
 	}
-
-	n = copy(p, buffer[r.offset:n])
-	return
+	return counter
 }
 
 // AES is a block cipher with block size of 16 bytes, i.e. the basic unit of encryption/decryption
@@ -169,7 +212,10 @@ func wrapAlignedEncryptionReader(reader io.Reader, startOffset int64, encryption
 	}
 
 	alignedOffset := startOffset / AES_BLOCK_SIZE * AES_BLOCK_SIZE
-	newReader, err := wrapEncryptionReader(reader, encryptionKey, initializationVector)
+	// data is encrypted from the beginning, so counter of the first block
+	// read should be advanced
+	newReader, err := wrapEncryptionReader(reader, encryptionKey,
+		counterAt(initializationVector, alignedOffset/AES_BLOCK_SIZE))
 	if err != nil {
 		return
 	}
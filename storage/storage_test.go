@@ -0,0 +1,750 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/journeymidnight/yig/api/datatype"
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+	"github.com/journeymidnight/yig/iam"
+	"github.com/journeymidnight/yig/log"
+	"github.com/journeymidnight/yig/meta"
+	"github.com/journeymidnight/yig/meta/client"
+	"github.com/journeymidnight/yig/meta/types"
+	"github.com/journeymidnight/yig/meta/util"
+	"github.com/journeymidnight/yig/redis"
+)
+
+// fakeClient keeps metadata in memory the way HBase does, versions of an
+// object are rows keyed by their timestamps, newest first. Safe for
+// concurrent use, methods not used by tests panic
+type fakeClient struct {
+	client.Client
+	lock        sync.Mutex
+	buckets     map[string]types.Bucket
+	userBuckets map[string]map[string]bool
+	objects     map[string]*types.Object   // by rowkey
+	objMaps     map[string]*types.ObjMap   // by bucket/object
+	multiparts  map[string]types.Multipart // by rowkey
+	lifeCycles  map[string]types.LifeCycle
+	gc          []*types.Object
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		buckets:     make(map[string]types.Bucket),
+		userBuckets: make(map[string]map[string]bool),
+		objects:     make(map[string]*types.Object),
+		objMaps:     make(map[string]*types.ObjMap),
+		multiparts:  make(map[string]types.Multipart),
+		lifeCycles:  make(map[string]types.LifeCycle),
+	}
+}
+
+// YigStorage on top of `c` without cache and Ceph
+func newTestStorage(c client.Client) *YigStorage {
+	helper.Logger = log.New(ioutil.Discard, "[yig]", log.LstdFlags, 5)
+	return &YigStorage{
+		MetaStorage: &meta.Meta{Client: c, Cache: &fakeCache{}},
+		DataCache:   &disabledDataCache{},
+		Logger:      helper.Logger,
+	}
+}
+
+// Put a bucket of `owner` and list it for the owner, like MakeBucket does
+func (c *fakeClient) putBucket(bucket types.Bucket) {
+	c.PutBucket(bucket)
+	c.AddBucketForUser(bucket.Name, bucket.OwnerId)
+}
+
+// Put `object` as a new version, its LastModifiedTime is set to now if
+// unset. Returns the version as read back
+func (c *fakeClient) putObject(object *types.Object) *types.Object {
+	if object.LastModifiedTime.IsZero() {
+		object.LastModifiedTime = time.Now().UTC()
+	}
+	c.PutObject(object)
+	rowkey, _ := object.GetRowkey()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return objectFromRow(c.objects[rowkey])
+}
+
+// The latest version of an object, nil if there's none
+func (c *fakeClient) latest(bucketName, objectName string) *types.Object {
+	object, err := c.GetObject(bucketName, objectName, "")
+	if err != nil {
+		return nil
+	}
+	return object
+}
+
+// All versions of an object, newest first
+func (c *fakeClient) versions(bucketName, objectName string) []*types.Object {
+	objects, _ := c.GetAllObject(bucketName, objectName, "")
+	return objects
+}
+
+func (c *fakeClient) GetBucket(bucketName string) (types.Bucket, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bucket, ok := c.buckets[bucketName]
+	if !ok {
+		return bucket, ErrNoSuchBucket
+	}
+	return bucket, nil
+}
+
+func (c *fakeClient) PutBucket(bucket types.Bucket) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.buckets[bucket.Name] = bucket
+	return nil
+}
+
+func (c *fakeClient) CheckAndPutBucket(bucket types.Bucket) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.buckets[bucket.Name]; ok {
+		return false, nil
+	}
+	c.buckets[bucket.Name] = bucket
+	return true, nil
+}
+
+func (c *fakeClient) DeleteBucket(bucket types.Bucket) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.buckets, bucket.Name)
+	return nil
+}
+
+func (c *fakeClient) ListBuckets(marker string, maxBuckets int) ([]string, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var buckets []string
+	for bucketName := range c.buckets {
+		if bucketName > marker {
+			buckets = append(buckets, bucketName)
+		}
+	}
+	return page(buckets, maxBuckets)
+}
+
+func (c *fakeClient) UpdateUsage(bucketName string, size int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if bucket, ok := c.buckets[bucketName]; ok {
+		bucket.Usage += size
+		c.buckets[bucketName] = bucket
+	}
+}
+
+func (c *fakeClient) GetUserBuckets(userId string) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var buckets []string
+	for bucketName := range c.userBuckets[userId] {
+		buckets = append(buckets, bucketName)
+	}
+	sort.Strings(buckets)
+	return buckets, nil
+}
+
+func (c *fakeClient) ListUserBuckets(userId, marker string, maxBuckets int) ([]string, bool,
+	error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var buckets []string
+	for bucketName := range c.userBuckets[userId] {
+		if bucketName > marker {
+			buckets = append(buckets, bucketName)
+		}
+	}
+	return page(buckets, maxBuckets)
+}
+
+func page(names []string, max int) ([]string, bool, error) {
+	sort.Strings(names)
+	if len(names) > max {
+		return names[:max], true, nil
+	}
+	return names, false, nil
+}
+
+func (c *fakeClient) AddBucketForUser(bucketName, userId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.userBuckets[userId] == nil {
+		c.userBuckets[userId] = make(map[string]bool)
+	}
+	c.userBuckets[userId][bucketName] = true
+	return nil
+}
+
+func (c *fakeClient) RemoveBucketForUser(bucketName string, userId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.userBuckets[userId], bucketName)
+	return nil
+}
+
+func (c *fakeClient) PutBucketToLifeCycle(lifeCycle types.LifeCycle) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lifeCycles[lifeCycle.BucketName] = lifeCycle
+	return nil
+}
+
+func (c *fakeClient) RemoveBucketFromLifeCycle(bucket types.Bucket) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.lifeCycles, bucket.Name)
+	return nil
+}
+
+func (c *fakeClient) GetCluster(fsid, pool string) (types.Cluster, error) {
+	return types.Cluster{Fsid: fsid, Pool: pool, Weight: 1}, nil
+}
+
+func (c *fakeClient) Ping(timeout time.Duration) error {
+	return nil
+}
+
+// Objects are copied in and out, so callers never share them with the
+// client, like they are decoded from rows every time
+func copyObject(o *types.Object) *types.Object {
+	object := *o
+	object.Parts = make(map[int]*types.Part, len(o.Parts))
+	for n, p := range o.Parts {
+		part := *p
+		object.Parts[n] = &part
+	}
+	return &object
+}
+
+// Version id and timestamp are decoded from rowkey, and index of parts is
+// built, as ObjectFromResponse does
+func objectFromRow(o *types.Object) *types.Object {
+	object := copyObject(o)
+	object.VersionId = util.Encrypt(strconv.FormatUint(rowkeyTimestamp(o.Rowkey), 10))
+	if len(object.Parts) != 0 {
+		offsets := make([]int64, len(object.Parts))
+		for n, p := range object.Parts {
+			offsets[n-1] = p.Offset
+		}
+		object.PartsIndex = &types.SimpleIndex{Index: offsets}
+	}
+	if object.StorageClass == "" {
+		object.StorageClass = datatype.STORAGE_CLASS_STANDARD
+	}
+	return object
+}
+
+func rowkeyTimestamp(rowkey []byte) uint64 {
+	return math.MaxUint64 - binary.BigEndian.Uint64(rowkey[len(rowkey)-8:])
+}
+
+// Rows of `bucketName` sorted by rowkey, so versions of an object are
+// newest first
+func (c *fakeClient) rows(bucketName string) []*types.Object {
+	var rows []*types.Object
+	for _, o := range c.objects {
+		if o.BucketName == bucketName {
+			rows = append(rows, o)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return bytes.Compare(rows[i].Rowkey, rows[j].Rowkey) < 0
+	})
+	return rows
+}
+
+func (c *fakeClient) objectRows(bucketName, objectName string) []*types.Object {
+	var rows []*types.Object
+	for _, o := range c.rows(bucketName) {
+		if o.Name == objectName {
+			rows = append(rows, o)
+		}
+	}
+	return rows
+}
+
+func versionTimestamp(version string) (uint64, error) {
+	decrypted, err := util.Decrypt(version)
+	if err != nil {
+		return 0, err
+	}
+	timestamp, err := strconv.ParseUint(decrypted, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidVersioning
+	}
+	return timestamp, nil
+}
+
+func (c *fakeClient) GetObject(bucketName, objectName, version string) (*types.Object, error) {
+	objects, err := c.GetAllObject(bucketName, objectName, version)
+	if err != nil {
+		return nil, err
+	}
+	return objects[0], nil
+}
+
+func (c *fakeClient) GetAllObject(bucketName, objectName, version string) ([]*types.Object, error) {
+	var timestamp uint64
+	if version != "" {
+		var err error
+		timestamp, err = versionTimestamp(version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var objects []*types.Object
+	for _, o := range c.objectRows(bucketName, objectName) {
+		if version == "" || rowkeyTimestamp(o.Rowkey) == timestamp {
+			objects = append(objects, objectFromRow(o))
+		}
+	}
+	if len(objects) == 0 {
+		return nil, ErrNoSuchKey
+	}
+	return objects, nil
+}
+
+func (c *fakeClient) PutObject(object *types.Object) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.objects[rowkey] = copyObject(object)
+	return nil
+}
+
+func (c *fakeClient) DeleteObject(object *types.Object) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.objects, rowkey)
+	return nil
+}
+
+// Update the row of `object` with `update`, if its etag is still the same
+func (c *fakeClient) updateObject(object *types.Object, update func(row *types.Object)) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	row, ok := c.objects[rowkey]
+	if !ok || row.Etag != object.Etag {
+		return ErrNoSuchKey
+	}
+	update(row)
+	return nil
+}
+
+func (c *fakeClient) UpdateObjectAttrs(object *types.Object) error {
+	return c.updateObject(object, func(row *types.Object) {
+		row.ContentType = object.ContentType
+		row.CustomAttributes = object.CustomAttributes
+		row.ACL = object.ACL
+	})
+}
+
+func (c *fakeClient) UpdateObjectSharedWith(object *types.Object) error {
+	return c.updateObject(object, func(row *types.Object) {
+		row.SharedWith = object.SharedWith
+	})
+}
+
+func (c *fakeClient) UpdateObjectRestoreExpiry(object *types.Object) error {
+	return c.updateObject(object, func(row *types.Object) {
+		row.RestoreExpiryDate = object.RestoreExpiryDate
+	})
+}
+
+func (c *fakeClient) UpdateObjectSseKey(object *types.Object) error {
+	return c.updateObject(object, func(row *types.Object) {
+		row.EncryptionKey = object.EncryptionKey
+	})
+}
+
+func (c *fakeClient) UpdateObjectLocation(object *types.Object, oldLocation, oldPool string) (bool,
+	error) {
+
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return false, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	row, ok := c.objects[rowkey]
+	if !ok || row.Location != oldLocation || row.Pool != oldPool {
+		return false, nil
+	}
+	row.Location, row.Pool, row.ObjectId = object.Location, object.Pool, object.ObjectId
+	row.StorageClass = object.StorageClass
+	for n, p := range object.Parts {
+		part := *p
+		row.Parts[n] = &part
+	}
+	return true, nil
+}
+
+func (c *fakeClient) AppendObject(object *types.Object, part *types.Part, lastModified time.Time) error {
+	rowkey, err := object.GetRowkey()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	row, ok := c.objects[rowkey]
+	if !ok || row.Size != part.Offset {
+		return ErrPositionNotEqualToLength
+	}
+	row.Size, row.Etag, row.LastModifiedTime = object.Size, object.Etag, lastModified
+	p := *part
+	row.Parts[part.PartNumber] = &p
+	return nil
+}
+
+func (c *fakeClient) GetObjectMap(bucketName, objectName string) (*types.ObjMap, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	objMap, ok := c.objMaps[bucketName+"/"+objectName]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	m := *objMap
+	return &m, nil
+}
+
+func (c *fakeClient) PutObjectMap(objMap *types.ObjMap) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	m := *objMap
+	c.objMaps[objMap.BucketName+"/"+objMap.Name] = &m
+	return nil
+}
+
+func (c *fakeClient) DeleteObjectMap(objMap *types.ObjMap) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.objMaps, objMap.BucketName+"/"+objMap.Name)
+	return nil
+}
+
+// Listed the way HBase scans rows, see HbaseClient.ListObjects
+func (c *fakeClient) ListObjects(bucketName, marker, verIdMarker, prefix, delimiter string,
+	versioned bool, maxKeys int) (objects []*types.Object, prefixes []string, truncated bool,
+	nextMarker, nextVerIdMarker string, err error) {
+
+	if verIdMarker == "null" {
+		var objMap *types.ObjMap
+		objMap, err = c.GetObjectMap(bucketName, marker)
+		if err != nil {
+			return
+		}
+		verIdMarker = objMap.GetVersionId()
+	}
+	// versions of `marker` older than the marker version are listed
+	var markerTimestamp uint64
+	if versioned && verIdMarker != "" {
+		markerTimestamp, err = versionTimestamp(verIdMarker)
+		if err != nil {
+			return
+		}
+	}
+	// marker under a common prefix skips the whole prefix
+	markerPrefix := util.CommonPrefix(marker, prefix, delimiter)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	collector := util.NewListCollector(maxKeys)
+	var lastName string
+	for i, o := range c.rows(bucketName) {
+		latest := i == 0 || o.Name != lastName
+		lastName = o.Name
+		if !strings.HasPrefix(o.Name, prefix) || o.Name < marker {
+			continue
+		}
+		if !versioned && (!latest || o.DeleteMarker) {
+			continue
+		}
+		if o.Name == marker && (markerTimestamp == 0 ||
+			rowkeyTimestamp(o.Rowkey) >= markerTimestamp) {
+			continue
+		}
+		commonPrefix := util.CommonPrefix(o.Name, prefix, delimiter)
+		if commonPrefix != "" {
+			if commonPrefix == markerPrefix || collector.Seen(commonPrefix) {
+				continue
+			}
+			if !collector.TakePrefix(commonPrefix) {
+				truncated = true
+				break
+			}
+			nextMarker, nextVerIdMarker = commonPrefix, ""
+			continue
+		}
+		if !collector.TakeKey() {
+			truncated = true
+			break
+		}
+		object := objectFromRow(o)
+		objects = append(objects, object)
+		nextMarker = object.Name
+		if versioned {
+			nextVerIdMarker = object.VersionId
+		}
+	}
+	prefixes = collector.Prefixes()
+	return
+}
+
+func (c *fakeClient) CountObjects(bucketName string) (stats types.BucketStats, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var lastName string
+	for i, o := range c.rows(bucketName) {
+		stats.AddVersion(o.Size, o.StorageClass, o.DeleteMarker, i == 0 || o.Name != lastName)
+		lastName = o.Name
+	}
+	return stats, nil
+}
+
+func (c *fakeClient) PutObjectToGarbageCollection(object *types.Object) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.gc = append(c.gc, copyObject(object))
+	return nil
+}
+
+func multipartRowkey(bucketName, objectName, uploadId string) (string, error) {
+	timestamp, err := versionTimestamp(uploadId)
+	if err != nil {
+		return "", err
+	}
+	m := types.Multipart{BucketName: bucketName, ObjectName: objectName,
+		InitialTime: time.Unix(0, int64(timestamp))}
+	return m.GetRowkey()
+}
+
+func copyMultipart(m types.Multipart) types.Multipart {
+	parts := make(map[int]*types.Part, len(m.Parts))
+	for n, p := range m.Parts {
+		part := *p
+		parts[n] = &part
+	}
+	m.Parts = parts
+	return m
+}
+
+func (c *fakeClient) GetMultipart(bucketName, objectName, uploadId string) (types.Multipart, error) {
+	rowkey, err := multipartRowkey(bucketName, objectName, uploadId)
+	if err != nil {
+		return types.Multipart{}, ErrNoSuchUpload
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	multipart, ok := c.multiparts[rowkey]
+	if !ok {
+		return types.Multipart{}, ErrNoSuchUpload
+	}
+	return copyMultipart(multipart), nil
+}
+
+func (c *fakeClient) CreateMultipart(multipart types.Multipart) error {
+	rowkey, err := multipart.GetRowkey()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.multiparts[rowkey] = copyMultipart(multipart)
+	return nil
+}
+
+func (c *fakeClient) PutObjectPart(multipart types.Multipart, part types.Part) error {
+	rowkey, err := multipart.GetRowkey()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	m, ok := c.multiparts[rowkey]
+	if !ok { // a row of the part only, like HBase
+		m = types.Multipart{BucketName: multipart.BucketName, ObjectName: multipart.ObjectName,
+			InitialTime: multipart.InitialTime, Parts: make(map[int]*types.Part)}
+		c.multiparts[rowkey] = m
+	}
+	m.Parts[part.PartNumber] = &part
+	return nil
+}
+
+func (c *fakeClient) DeleteMultipart(multipart types.Multipart) error {
+	rowkey, err := multipart.GetRowkey()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.multiparts, rowkey)
+	return nil
+}
+
+// Listed from (keyMarker, uploadIdMarker) inclusively in the order of
+// rowkeys, see HbaseClient.ListMultipartUploads
+func (c *fakeClient) ListMultipartUploads(bucketName, keyMarker, uploadIdMarker, prefix,
+	delimiter, encodingType string, maxUploads int) (uploads []datatype.Upload,
+	prefixes []string, truncated bool, nextKeyMarker, nextUploadIdMarker string, err error) {
+
+	var startRow string
+	if keyMarker != "" {
+		m := types.Multipart{BucketName: bucketName, ObjectName: keyMarker}
+		startRow, _ = m.GetRowkey()
+		startRow = startRow[:len(startRow)-8] // without upload time
+		if uploadIdMarker != "" {
+			startRow, err = multipartRowkey(bucketName, keyMarker, uploadIdMarker)
+			if err != nil {
+				return
+			}
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var rowkeys []string
+	for rowkey, m := range c.multiparts {
+		if m.BucketName == bucketName && rowkey >= startRow &&
+			strings.HasPrefix(m.ObjectName, prefix) {
+
+			rowkeys = append(rowkeys, rowkey)
+		}
+	}
+	sort.Strings(rowkeys)
+	uploads = make([]datatype.Upload, 0)
+	collector := util.NewListCollector(maxUploads)
+	for _, rowkey := range rowkeys {
+		m := c.multiparts[rowkey]
+		if commonPrefix := util.CommonPrefix(m.ObjectName, prefix, delimiter); commonPrefix != "" {
+			if collector.Seen(commonPrefix) || collector.TakePrefix(commonPrefix) {
+				continue
+			}
+		} else if collector.TakeKey() {
+			uploads = append(uploads, uploadOf(m))
+			continue
+		}
+		truncated = true
+		nextKeyMarker = m.ObjectName
+		nextUploadIdMarker, err = m.GetUploadId()
+		break
+	}
+	prefixes = collector.Prefixes()
+	return
+}
+
+func uploadOf(m types.Multipart) datatype.Upload {
+	upload := datatype.Upload{
+		Key:          m.ObjectName,
+		StorageClass: m.Metadata.StorageClass,
+		Initiated:    m.InitialTime.UTC().Format(types.CREATE_TIME_LAYOUT),
+	}
+	if upload.StorageClass == "" {
+		upload.StorageClass = datatype.STORAGE_CLASS_STANDARD
+	}
+	upload.UploadId, _ = m.GetUploadId()
+	owner, _ := iam.GetCredentialByUserId(m.Metadata.OwnerId)
+	initiator, _ := iam.GetCredentialByUserId(m.Metadata.InitiatorId)
+	upload.Owner.ID, upload.Owner.DisplayName = owner.UserId, owner.DisplayName
+	upload.Initiator.ID, upload.Initiator.DisplayName = initiator.UserId, initiator.DisplayName
+	return upload
+}
+
+func (c *fakeClient) CountMultipartUploads(bucketName string) (count int64, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, m := range c.multiparts {
+		if m.BucketName == bucketName {
+			count += 1
+		}
+	}
+	return count, nil
+}
+
+// MetaCache in memory, values are kept only if willNeed like
+// enabledMetaCache. A zero fakeCache caches nothing
+type fakeCache struct {
+	lock   sync.Mutex
+	values map[redis.RedisDatabase]map[string]interface{}
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{values: make(map[redis.RedisDatabase]map[string]interface{})}
+}
+
+func (m *fakeCache) Get(table redis.RedisDatabase, key string,
+	onCacheMiss func() (interface{}, error),
+	unmarshaller func([]byte) (interface{}, error), willNeed bool) (interface{}, error) {
+
+	m.lock.Lock()
+	value, ok := m.values[table][key]
+	m.lock.Unlock()
+	if ok {
+		return value, nil
+	}
+	value, err := onCacheMiss()
+	if err != nil || !willNeed {
+		return value, err
+	}
+	m.lock.Lock()
+	if m.values != nil {
+		if m.values[table] == nil {
+			m.values[table] = make(map[string]interface{})
+		}
+		m.values[table][key] = value
+	}
+	m.lock.Unlock()
+	return value, nil
+}
+
+func (m *fakeCache) Remove(table redis.RedisDatabase, key string) {
+	m.lock.Lock()
+	delete(m.values[table], key)
+	m.lock.Unlock()
+}
+
+func (m *fakeCache) RemoveBatch(entries []redis.InvalidEntry) {
+	for _, e := range entries {
+		m.Remove(e.Table, e.Key)
+	}
+}
+
+func (m *fakeCache) GetCacheHitRatio() float64 { return 0 }
+
+func (m *fakeCache) GetNegativeCacheHits() int64 { return 0 }
+
+func (m *fakeCache) GetStats() meta.MetaCacheStats { return meta.MetaCacheStats{} }
+
+func (m *fakeCache) Flush(table redis.RedisDatabase) error {
+	m.lock.Lock()
+	delete(m.values, table)
+	m.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	. "github.com/journeymidnight/yig/error"
+	"github.com/journeymidnight/yig/helper"
+)
+
+// Move a version of an object to the pool of `storageClass` in its cluster,
+// for transitions of lifecycle rules. Returns false if the object needs no
+// moving, i.e. it's a delete marker, appendable or already of `storageClass`.
+// Data copied is limited by RebalanceBandwidth
+func (yig *YigStorage) TransitionObject(bucketName, objectName, version string,
+	storageClass string) (bool, error) {
+
+	pool, ok := helper.CONFIG.StorageClassPools[storageClass]
+	if !ok {
+		return false, ErrInvalidStorageClass
+	}
+	object, err := yig.getObjWithVersion(bucketName, objectName, version)
+	if err != nil {
+		return false, err
+	}
+	// parts appended while copying would be lost, so appendable objects
+	// are left alone
+	if object.DeleteMarker || object.Appendable || object.StorageClass == storageClass {
+		return false, nil
+	}
+	object.StorageClass = storageClass
+	limiter := newBandwidthLimiter(int64(helper.CONFIG.RebalanceBandwidth) << 20)
+	err = yig.moveObject(object, object.Location, pool, limiter)
+	return err == nil, err
+}
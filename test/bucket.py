@@ -57,6 +57,28 @@ def get_bucket_acl(name, client):
     print 'Get bucket ACL:', ans
 
 
+def put_bucket_acl_grants(name, client):
+    client.put_bucket_acl(
+        Bucket=name+'hehe',
+        GrantRead='id="someone-else", uri="http://acs.amazonaws.com/groups/global/AuthenticatedUsers"',
+        GrantWriteACP='id="someone-else"',
+    )
+    ans = client.get_bucket_acl(Bucket=name+'hehe')
+    print 'Get bucket ACL grants:', ans
+    permissions = [(g['Grantee'].get('ID') or g['Grantee'].get('URI'), g['Permission'])
+                   for g in ans['Grants']]
+    assert ('someone-else', 'READ') in permissions
+    assert ('someone-else', 'WRITE_ACP') in permissions
+    assert len(permissions) == 3
+
+
+def put_bucket_acl_with_email_grantee_should_fail(name, client):
+    client.put_bucket_acl(
+        Bucket=name+'hehe',
+        GrantRead='emailAddress="someone@example.com"',
+    )
+
+
 # boto cannot even send presigned acl requests correctly,
 # so comment out
 
@@ -114,6 +136,29 @@ def bucket_limit_per_user(name, client):
     for i in range(1, 101):
         client.delete_bucket(Bucket=name+str(i))
 
+
+def put_bucket_request_payment(name, client):
+    client.put_bucket_request_payment(
+        Bucket=name+'hehe',
+        RequestPaymentConfiguration={
+            'Payer': 'Requester'
+        }
+    )
+    ans = client.get_bucket_request_payment(
+        Bucket=name+'hehe',
+    )
+    print 'Get bucket request payment:', ans
+    assert ans['Payer'] == 'Requester'
+
+
+def list_objects_requester_pays_owner(name, client):
+    ans = client.list_objects(
+        Bucket=name+'hehe',
+        RequestPayer='requester'
+    )
+    # owners are not charged
+    assert 'RequestCharged' not in ans
+
 # =====================================================
 
 TESTS = [
@@ -122,7 +167,11 @@ TESTS = [
     create_bucket_presigned,
     list_buckets_presigned,
     list_buckets_presigned_expired,
+    put_bucket_acl_grants,
+    put_bucket_acl_with_email_grantee_should_fail,
     put_bucket_acl, get_bucket_acl,
+    put_bucket_request_payment,
+    list_objects_requester_pays_owner,
     delete_bucket_presigned,
     sanity.delete_bucket,
     bucket_limit_per_user,
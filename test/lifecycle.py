@@ -24,6 +24,14 @@ def put_bucket_lifecycle(name, client):
 def del_bucket_lifecycle(name, client):
     client.delete_bucket_lifecycle(Bucket=name+'hehe')
 
+def head_object_expiration(name, client):
+    response = client.head_object(
+        Bucket=name+'hehe',
+        Key=name+'hehe',
+    )
+    print 'expiration: ', response['Expiration']
+    assert 'rule-id="test"' in response['Expiration']
+
 def do_lc(name, client):
     os.system('../lc')
     time.sleep(3)
@@ -50,6 +58,7 @@ TESTS = [
     put_bucket_lifecycle,
     get_bucket_lifecycle,
     sanity.get_object,
+    head_object_expiration,
     do_lc,
     get_object,
     del_bucket_lifecycle,
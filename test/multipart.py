@@ -1,5 +1,6 @@
 import base
 import sanity
+import botocore
 
 # =====================================================
 
@@ -117,6 +118,82 @@ def sse_custom_multipart(name, client):
     print 'Get SSE-C multipart upload object:', ans
 
 
+def copy_part_from_sse_custom(name, client):
+    ans = client.create_multipart_upload(
+        Bucket=name+'hehe',
+        Key=name+'custom-copy',
+        SSECustomerAlgorithm='AES256',
+        SSECustomerKey='fedcba9876543210' * 2
+    )
+    upload_id = ans['UploadId']
+
+    # decrypted with the source key, and encrypted again with the new one
+    ans = client.upload_part_copy(
+        Bucket=name+'hehe',
+        Key=name+'custom-copy',
+        PartNumber=1,
+        UploadId=upload_id,
+        SSECustomerAlgorithm='AES256',
+        SSECustomerKey='fedcba9876543210' * 2,
+        CopySource={
+            'Bucket': name+'hehe',
+            'Key': name+'custom'
+        },
+        CopySourceSSECustomerAlgorithm='AES256',
+        CopySourceSSECustomerKey='0123456789abcdef' * 2,
+        CopySourceRange='bytes=1048576-2097151'
+    )
+    print 'Copy part from SSE-C object:', ans
+    etag = ans['CopyPartResult']['ETag']
+
+    client.complete_multipart_upload(
+        Bucket=name+'hehe',
+        Key=name+'custom-copy',
+        MultipartUpload={
+            'Parts': [
+                {'ETag': etag, 'PartNumber': 1},
+            ]
+        },
+        UploadId=upload_id
+    )
+    ans = client.get_object(
+        Bucket=name+'hehe',
+        Key=name+'custom-copy',
+        SSECustomerAlgorithm='AES256',
+        SSECustomerKey='fedcba9876543210' * 2,
+    )
+    body = ans['Body'].read()
+    assert body == sanity.RANGE_2
+    print 'Get object copied from SSE-C object:', ans
+
+
+def copy_part_from_sse_custom_without_key_should_fail(name, client):
+    ans = client.create_multipart_upload(
+        Bucket=name+'hehe',
+        Key=name+'custom-copy'
+    )
+    upload_id = ans['UploadId']
+    try:
+        client.upload_part_copy(
+            Bucket=name+'hehe',
+            Key=name+'custom-copy',
+            PartNumber=1,
+            UploadId=upload_id,
+            CopySource={
+                'Bucket': name+'hehe',
+                'Key': name+'custom'
+            }
+        )
+    except botocore.exceptions.ClientError as e:
+        assert e.response['ResponseMetadata']['HTTPStatusCode'] == 400, e.response
+        print 'Copy part from SSE-C object without key:', e.response['Error']['Code']
+        return
+    finally:
+        client.abort_multipart_upload(Bucket=name+'hehe', Key=name+'custom-copy',
+                                      UploadId=upload_id)
+    assert False, 'Copy part from SSE-C object without key should fail'
+
+
 def delete_multipart_uploaded_objects(name, client):
     ans = client.delete_objects(
         Bucket=name+'hehe',
@@ -127,19 +204,88 @@ def delete_multipart_uploaded_objects(name, client):
                 },
                 {
                     'Key': name+'custom'
+                },
+                {
+                    'Key': name+'custom-copy'
                 }
             ]
         }
     )
     print 'Delete multiple objects:', ans
 
+def list_multipart_uploads_many_prefixes(name, client):
+    upload_ids = {}
+    for i in range(5):
+        key = 'dir%d/obj' % i
+        ans = client.create_multipart_upload(Bucket=name+'hehe', Key=key)
+        upload_ids[key] = ans['UploadId']
+    listed = []
+    key_marker = ''
+    upload_id_marker = ''
+    while True:
+        ans = client.list_multipart_uploads(
+            Bucket=name+'hehe',
+            Delimiter='/',
+            MaxUploads=2,
+            KeyMarker=key_marker,
+            UploadIdMarker=upload_id_marker,
+        )
+        prefixes = [p['Prefix'] for p in ans.get('CommonPrefixes', [])]
+        assert len(prefixes) + len(ans.get('Uploads', [])) <= 2
+        listed += prefixes
+        if not ans['IsTruncated']:
+            break
+        key_marker = ans['NextKeyMarker']
+        upload_id_marker = ans.get('NextUploadIdMarker', '')
+    assert sorted(listed) == ['dir%d/' % i for i in range(5)]
+    for key, upload_id in upload_ids.items():
+        client.abort_multipart_upload(Bucket=name+'hehe', Key=key, UploadId=upload_id)
+    print 'List multipart uploads with many prefixes:', listed
+
+def assert_no_such_upload(description, operation):
+    try:
+        operation()
+    except botocore.exceptions.ClientError as e:
+        assert e.response['Error']['Code'] == 'NoSuchUpload', e.response
+        assert e.response['ResponseMetadata']['HTTPStatusCode'] == 404
+        print description, 'with bogus upload ID:', e.response['Error']['Code']
+        return
+    assert False, description + ' with bogus upload ID should fail'
+
+def bogus_upload_id(name, client):
+    for upload_id in ['bogus', 'deadbeef']:
+        assert_no_such_upload('List parts', lambda: client.list_parts(
+            Bucket=name+'hehe', Key=name+'bogus', UploadId=upload_id))
+        assert_no_such_upload('Upload part', lambda: client.upload_part(
+            Body=sanity.RANGE_1, Bucket=name+'hehe', Key=name+'bogus',
+            PartNumber=1, UploadId=upload_id))
+        assert_no_such_upload('Complete multipart upload',
+            lambda: client.complete_multipart_upload(
+                Bucket=name+'hehe', Key=name+'bogus',
+                MultipartUpload={'Parts': [{'ETag': '"hehe"', 'PartNumber': 1}]},
+                UploadId=upload_id))
+        assert_no_such_upload('Abort multipart upload',
+            lambda: client.abort_multipart_upload(
+                Bucket=name+'hehe', Key=name+'bogus', UploadId=upload_id))
+    # a missing key is a different error
+    try:
+        client.get_object(Bucket=name+'hehe', Key=name+'bogus')
+    except botocore.exceptions.ClientError as e:
+        assert e.response['Error']['Code'] == 'NoSuchKey', e.response
+        return
+    assert False, 'Get bogus object should fail'
+
 # =====================================================
 
 TESTS = [
     sanity.create_bucket,
     sse_s3_multipart,
     sse_custom_multipart,
+    copy_part_from_sse_custom,
+    copy_part_from_sse_custom_without_key_should_fail,
     delete_multipart_uploaded_objects,
+    list_multipart_uploads_many_prefixes,
+    bogus_upload_id,
     sanity.delete_bucket,
 ]
 
@@ -1,6 +1,11 @@
 import base
+import base64
 import sanity
 import requests
+import struct
+import zlib
+import botocore.exceptions
+from datetime import datetime
 import config
 
 # =====================================================
@@ -38,6 +43,136 @@ def get_object_acl(name, client):
     print 'Get object ACL:', ans
 
 
+def put_object_acl_grants(name, client):
+    owner = client.get_object_acl(
+        Bucket=name+'hehe',
+        Key=name+'hehe',
+    )['Owner']
+    client.put_object_acl(
+        Bucket=name+'hehe',
+        Key=name+'hehe',
+        AccessControlPolicy={
+            'Owner': owner,
+            'Grants': [
+                {
+                    'Grantee': {
+                        'Type': 'CanonicalUser',
+                        'ID': owner['ID'],
+                    },
+                    'Permission': 'FULL_CONTROL',
+                },
+                {
+                    'Grantee': {
+                        'Type': 'CanonicalUser',
+                        'ID': 'someone-else',
+                    },
+                    'Permission': 'READ',
+                },
+            ],
+        },
+    )
+    ans = client.get_object_acl(
+        Bucket=name+'hehe',
+        Key=name+'hehe',
+    )
+    print 'Get object ACL grants:', ans
+    ids = [g['Grantee'].get('ID') for g in ans['Grants']]
+    assert 'someone-else' in ids
+
+
+def get_object_with_grants_anonymous_should_fail(name, client):
+    url = config.CONFIG['endpoint'] + '/' + name+'hehe' + '/' + name+'hehe'
+    response = requests.get(url)
+    print 'Get object with grants anonymously:', response.status_code
+    assert response.status_code == 200
+
+
+def put_object_with_grant_headers(name, client):
+    client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'granted',
+        GrantRead='uri="http://acs.amazonaws.com/groups/global/AllUsers"',
+        GrantReadACP='id="someone-else"',
+    )
+    ans = client.get_object_acl(Bucket=name+'hehe', Key=name+'granted')
+    print 'Get object ACL from grant headers:', ans
+    permissions = [(g['Grantee'].get('ID') or g['Grantee'].get('URI'), g['Permission'])
+                   for g in ans['Grants']]
+    assert ('someone-else', 'READ_ACP') in permissions
+    url = config.CONFIG['endpoint'] + '/' + name+'hehe' + '/' + name+'granted'
+    response = requests.get(url)
+    print 'Get object granted to all users anonymously:', response.status_code
+    assert response.status_code == 200
+    assert response.text == sanity.SMALL_TEST_FILE
+
+
+def put_object_with_canned_acl_and_grant_headers_should_fail(name, client):
+    client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'granted',
+        ACL='public-read',
+        GrantRead='id="someone-else"',
+    )
+
+
+def put_object_with_storage_class(name, client):
+    client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'standard',
+        StorageClass='STANDARD',
+    )
+    ans = client.list_objects(Bucket=name+'hehe', Prefix=name+'standard')
+    print 'List objects with storage class:', ans
+    assert ans['Contents'][0]['StorageClass'] == 'STANDARD'
+
+
+def put_object_with_invalid_storage_class_should_fail(name, client):
+    client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'standard',
+        StorageClass='HEHE',
+    )
+
+
+def with_headers(client, event, headers):
+    # add headers boto3 doesn't know about to requests of `event`
+    def add_headers(request, **kwargs):
+        for k, v in headers.items():
+            request.headers[k] = v
+    client.meta.events.register(event, add_headers)
+    return lambda: client.meta.events.unregister(event, add_headers)
+
+
+def put_object_with_checksum(name, client):
+    body = 'hehe'
+    crc32 = base64.b64encode(struct.pack('>I', zlib.crc32(body) & 0xffffffff))
+    done = with_headers(client, 'before-sign.s3.PutObject', {'x-amz-checksum-crc32': crc32})
+    try:
+        client.put_object(Body=body, Bucket=name+'hehe', Key=name+'checksum')
+    finally:
+        done()
+    done = with_headers(client, 'before-sign.s3.HeadObject', {'x-amz-checksum-mode': 'ENABLED'})
+    try:
+        ans = client.head_object(Bucket=name+'hehe', Key=name+'checksum')
+    finally:
+        done()
+    print 'Head object with checksum:', ans
+    assert ans['ResponseMetadata']['HTTPHeaders']['x-amz-checksum-crc32'] == crc32
+
+
+def put_object_with_wrong_checksum_should_fail(name, client):
+    crc32 = base64.b64encode(struct.pack('>I', zlib.crc32('haha') & 0xffffffff))
+    done = with_headers(client, 'before-sign.s3.PutObject', {'x-amz-checksum-crc32': crc32})
+    try:
+        client.put_object(Body='hehe', Bucket=name+'hehe', Key=name+'checksum')
+    finally:
+        done()
+
+
 def get_public_object(name, client):
     url = config.CONFIG['endpoint'] + '/' + name+'hehe' + '/' + name+'hehe'
     print url
@@ -47,6 +182,28 @@ def get_public_object(name, client):
     assert response.text == sanity.SMALL_TEST_FILE
 
 
+def get_object_in_public_read_bucket_anonymous(name, client):
+    client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'private',
+        ACL='private',
+    )
+    client.put_bucket_acl(Bucket=name+'hehe', ACL='public-read')
+    try:
+        url = config.CONFIG['endpoint'] + '/' + name+'hehe' + '/' + name+'private'
+        response = requests.get(url)
+        print 'Get object in public-read bucket anonymously:', response.status_code
+        assert response.status_code == 200
+        assert response.text == sanity.SMALL_TEST_FILE
+        response = requests.delete(url)
+        print 'Delete object in public-read bucket anonymously:', response.status_code
+        assert response.status_code == 403
+    finally:
+        client.put_bucket_acl(Bucket=name+'hehe', ACL='private')
+        client.delete_object(Bucket=name+'hehe', Key=name+'private')
+
+
 def object_encryption_s3(name, client):
     client.put_object(
         Body=sanity.SMALL_TEST_FILE,
@@ -210,6 +367,88 @@ def sse_copy_custom_to_custom(name, client):
     print 'SSE copy: plain to s3:', ans
 
 
+def put_bucket_encryption(name, client):
+    client.put_bucket_encryption(
+        Bucket=name+'hehe',
+        ServerSideEncryptionConfiguration={
+            'Rules': [{
+                'ApplyServerSideEncryptionByDefault': {
+                    'SSEAlgorithm': 'AES256',
+                },
+            }],
+        },
+    )
+    ans = client.get_bucket_encryption(
+        Bucket=name+'hehe',
+    )
+    print 'Get bucket encryption:', ans
+
+
+def object_encryption_bucket_default(name, client):
+    ans = client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'encrypted_default',
+    )
+    assert ans['ServerSideEncryption'] == 'AES256'
+    ans = client.get_object(
+        Bucket=name+'hehe',
+        Key=name+'encrypted_default',
+    )
+    assert ans['ServerSideEncryption'] == 'AES256'
+    assert ans['Body'].read() == sanity.SMALL_TEST_FILE
+    # explicit headers override bucket default
+    client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'encrypted_default_custom',
+        SSECustomerAlgorithm='AES256',
+        SSECustomerKey='0123456789abcdef' * 2
+    )
+    ans = client.get_object(
+        Bucket=name+'hehe',
+        Key=name+'encrypted_default_custom',
+        SSECustomerAlgorithm='AES256',
+        SSECustomerKey='0123456789abcdef' * 2
+    )
+    assert 'ServerSideEncryption' not in ans
+    assert ans['Body'].read() == sanity.SMALL_TEST_FILE
+
+
+def delete_bucket_encryption(name, client):
+    client.delete_bucket_encryption(
+        Bucket=name+'hehe',
+    )
+
+
+def get_bucket_encryption_nonexist(name, client):
+    client.get_bucket_encryption(
+        Bucket=name+'hehe',
+    )
+
+
+def select_object_content(name, client):
+    client.put_object(
+        Body='name,age\nalice,30\nbob,17\n',
+        Bucket=name+'hehe',
+        Key=name+'csv',
+    )
+    ans = client.select_object_content(
+        Bucket=name+'hehe',
+        Key=name+'csv',
+        Expression="SELECT name FROM S3Object WHERE age >= 18",
+        ExpressionType='SQL',
+        InputSerialization={'CSV': {'FileHeaderInfo': 'USE'}},
+        OutputSerialization={'CSV': {}},
+    )
+    records = ''
+    for event in ans['Payload']:
+        if 'Records' in event:
+            records += event['Records']['Payload']
+    print 'Select object content:', records
+    assert records == 'alice\n'
+
+
 def delete_multiple_objects(name, client):
     ans = client.delete_objects(
         Bucket=name+'hehe',
@@ -241,20 +480,193 @@ def delete_multiple_objects(name, client):
                 },
                 {
                     'Key': name+'_custom'
+                },
+                {
+                    'Key': name+'csv'
+                },
+                {
+                    'Key': name+'append'
+                },
+                {
+                    'Key': name+'granted'
+                },
+                {
+                    'Key': name+'standard'
+                },
+                {
+                    'Key': name+'checksum'
                 }
             ]
         }
     )
     print 'Delete multiple objects:', ans
 
+
+def copy_object_to_name_with_newline_should_fail(name, client):
+    # would share its rowkey prefix with name+'plain'
+    client.copy_object(
+        Bucket=name+'hehe',
+        Key=name+'plain\n\x00\x00\x00\x00\x00\x00\x00\x00',
+        CopySource={
+            'Bucket': name+'hehe',
+            'Key': name+'plain'
+        }
+    )
+
+
+def copy_object_if_match(name, client):
+    etag = client.head_object(Bucket=name+'hehe', Key=name+'hehe')['ETag']
+    ans = client.copy_object(
+        Bucket=name+'hehe',
+        Key=name+'hehe-conditional',
+        CopySource={
+            'Bucket': name+'hehe',
+            'Key': name+'hehe'
+        },
+        CopySourceIfMatch=etag,
+        # ignored since if-match holds
+        CopySourceIfUnmodifiedSince=datetime(2000, 1, 1)
+    )
+    print 'Copy object if match:', ans
+    client.delete_object(Bucket=name+'hehe', Key=name+'hehe-conditional')
+
+
+def copy_object_if_none_match_should_fail(name, client):
+    etag = client.head_object(Bucket=name+'hehe', Key=name+'hehe')['ETag']
+    client.copy_object(
+        Bucket=name+'hehe',
+        Key=name+'hehe-conditional',
+        CopySource={
+            'Bucket': name+'hehe',
+            'Key': name+'hehe'
+        },
+        CopySourceIfNoneMatch=etag
+    )
+
+def copy_object_to_itself_should_fail(name, client):
+    client.copy_object(
+        Bucket=name+'hehe',
+        Key=name+'hehe',
+        CopySource={
+            'Bucket': name+'hehe',
+            'Key': name+'hehe'
+        }
+    )
+
+
+def copy_object_to_itself_replace_metadata(name, client):
+    etag = client.head_object(Bucket=name+'hehe', Key=name+'hehe')['ETag']
+    ans = client.copy_object(
+        Bucket=name+'hehe',
+        Key=name+'hehe',
+        CopySource={
+            'Bucket': name+'hehe',
+            'Key': name+'hehe'
+        },
+        MetadataDirective='REPLACE',
+        ContentType='text/hehe',
+        CacheControl='no-cache'
+    )
+    print 'Copy object to itself:', ans
+    ans = client.head_object(Bucket=name+'hehe', Key=name+'hehe')
+    assert ans['ETag'] == etag
+    assert ans['ContentType'] == 'text/hehe'
+    assert ans['CacheControl'] == 'no-cache'
+
+
+def append_object(client, bucket, key, position, body):
+    # boto3 knows nothing about AppendObject, turn a PutObject request into it
+    def to_append(request, **kwargs):
+        request.method = 'POST'
+        request.url += '?append&position=' + str(position)
+    client.meta.events.register('before-sign.s3.PutObject', to_append)
+    try:
+        ans = client.put_object(Bucket=bucket, Key=key, Body=body)
+    finally:
+        client.meta.events.unregister('before-sign.s3.PutObject', to_append)
+    return int(ans['ResponseMetadata']['HTTPHeaders']['x-yig-next-append-position'])
+
+
+def append_object_interleaved(name, client):
+    other = [c for c in base.clients.values() if c is not client][0]
+    position = append_object(client, name+'hehe', name+'append', 0, 'hello')
+    assert position == 5
+    position = append_object(other, name+'hehe', name+'append', position, ' world')
+    assert position == 11
+    # the first client appends at a stale position
+    try:
+        append_object(client, name+'hehe', name+'append', 5, ' hehe')
+        assert False, 'append at stale position should fail'
+    except botocore.exceptions.ClientError as e:
+        assert e.response['Error']['Code'] == 'PositionNotEqualToLength'
+    position = append_object(client, name+'hehe', name+'append', position, '!')
+    assert position == 12
+    ans = client.get_object(Bucket=name+'hehe', Key=name+'append')
+    body = ans['Body'].read()
+    print 'Appended object:', body
+    assert body == 'hello world!'
+    ans = client.get_object(Bucket=name+'hehe', Key=name+'append', Range='bytes=3-6')
+    assert ans['Body'].read() == 'lo w'
+
+
+def append_object_position_mismatch_should_fail(name, client):
+    append_object(client, name+'hehe', name+'append', 100, 'hehe')
+
+
+def append_object_not_appendable_should_fail(name, client):
+    size = client.head_object(Bucket=name+'hehe', Key=name+'hehe')['ContentLength']
+    append_object(client, name+'hehe', name+'hehe', size, 'hehe')
+
+
+def rename_object(client, bucket, key, rename_source):
+    # boto3 knows nothing about RenameObject, add the header to a PutObject
+    def add_rename_source(request, **kwargs):
+        request.headers['X-Amz-Rename-Source'] = rename_source
+    client.meta.events.register('before-sign.s3.PutObject', add_rename_source)
+    try:
+        return client.put_object(Bucket=bucket, Key=key)
+    finally:
+        client.meta.events.unregister('before-sign.s3.PutObject', add_rename_source)
+
+
+def rename_object_then_delete(name, client):
+    bucket = name+'hehe'
+    for first, second in [(name+'old', name+'new'), (name+'new', name+'old')]:
+        client.put_object(Bucket=bucket, Key=name+'old', Body='hehe')
+        ans = rename_object(client, bucket, name+'new', '/'+bucket+'/'+name+'old')
+        print 'Rename object:', ans
+        try:
+            client.head_object(Bucket=bucket, Key=name+'old')
+            assert False, 'source should be removed after rename'
+        except botocore.exceptions.ClientError as e:
+            assert e.response['Error']['Code'] == '404'
+        ans = client.get_object(Bucket=bucket, Key=name+'new')
+        assert ans['Body'].read() == 'hehe'
+        client.delete_object(Bucket=bucket, Key=first)
+        client.delete_object(Bucket=bucket, Key=second)
+
+
+def rename_object_across_buckets_should_fail(name, client):
+    rename_object(client, name+'hehe', name+'new', '/'+name+'other/'+name+'hehe')
+
+
 # =====================================================
 
 TESTS = [
     sanity.create_bucket,
     sanity.put_object,
     get_object_presigned,
+    put_object_acl_grants,
+    get_object_with_grants_anonymous_should_fail,
+    put_object_with_grant_headers,
+    put_object_with_canned_acl_and_grant_headers_should_fail,
+    put_object_with_storage_class,
+    put_object_with_invalid_storage_class_should_fail,
+    put_object_with_checksum,
+    put_object_with_wrong_checksum_should_fail,
     put_object_acl, get_object_acl,
     get_public_object,
+    get_object_in_public_read_bucket_anonymous,
     object_encryption_s3,
     object_encryption_customer_key,
     object_encryption_wrong_customer_key_should_fail,
@@ -262,6 +674,21 @@ TESTS = [
     sse_copy_plain_to_custom,
     sse_copy_s3_to_custom,
     sse_copy_custom_to_custom,
+    copy_object_to_name_with_newline_should_fail,
+    copy_object_if_match,
+    copy_object_if_none_match_should_fail,
+    copy_object_to_itself_should_fail,
+    copy_object_to_itself_replace_metadata,
+    put_bucket_encryption,
+    object_encryption_bucket_default,
+    delete_bucket_encryption,
+    get_bucket_encryption_nonexist,
+    select_object_content,
+    append_object_interleaved,
+    append_object_position_mismatch_should_fail,
+    append_object_not_appendable_should_fail,
+    rename_object_then_delete,
+    rename_object_across_buckets_should_fail,
     delete_multiple_objects,
     sanity.delete_bucket,
 ]
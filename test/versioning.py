@@ -141,6 +141,89 @@ def count_files_and_versions(name, client):
 
     return len(files), len(file_versions), len(delete_markers)
 
+def object_lock_senarios(name, client):
+    client.put_bucket_versioning(
+        Bucket=name+'hehe',
+        VersioningConfiguration={
+            'Status': 'Enabled'
+        }
+    )
+    client.put_object_lock_configuration(
+        Bucket=name+'hehe',
+        ObjectLockConfiguration={
+            'ObjectLockEnabled': 'Enabled',
+            'Rule': {
+                'DefaultRetention': {
+                    'Mode': 'GOVERNANCE',
+                    'Days': 1
+                }
+            }
+        }
+    )
+    ans = client.get_object_lock_configuration(
+        Bucket=name+'hehe',
+    )
+    print 'Get object lock configuration:', ans
+    ans = client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'_locked',
+    )
+    version = ans['VersionId']
+    ans = client.get_object_retention(
+        Bucket=name+'hehe',
+        Key=name+'_locked',
+        VersionId=version
+    )
+    assert ans['Retention']['Mode'] == 'GOVERNANCE'
+    try:
+        client.delete_object(
+            Bucket=name+'hehe',
+            Key=name+'_locked',
+            VersionId=version
+        )
+        assert False, 'locked version should not be deleted'
+    except client.exceptions.ClientError as e:
+        assert e.response['Error']['Code'] == 'AccessDenied'
+
+    client.put_object_legal_hold(
+        Bucket=name+'hehe',
+        Key=name+'_locked',
+        VersionId=version,
+        LegalHold={'Status': 'ON'}
+    )
+    try:
+        client.delete_object(
+            Bucket=name+'hehe',
+            Key=name+'_locked',
+            VersionId=version,
+            BypassGovernanceRetention=True
+        )
+        assert False, 'version under legal hold should not be deleted'
+    except client.exceptions.ClientError as e:
+        assert e.response['Error']['Code'] == 'AccessDenied'
+    client.put_object_legal_hold(
+        Bucket=name+'hehe',
+        Key=name+'_locked',
+        VersionId=version,
+        LegalHold={'Status': 'OFF'}
+    )
+    client.delete_object(
+        Bucket=name+'hehe',
+        Key=name+'_locked',
+        VersionId=version,
+        BypassGovernanceRetention=True
+    )
+
+
+def suspend_versioning_object_lock_should_fail(name, client):
+    client.put_bucket_versioning(
+        Bucket=name+'hehe',
+        VersioningConfiguration={
+            'Status': 'Suspended'
+        }
+    )
+
 # =====================================================
 
 # bucket_name -> file_name -> dummy bool
@@ -168,6 +251,23 @@ def upload_objects_versioning_disabled(name, client):
     CURRENT_VERSIONS[name+'hehe'] = current_versions
 
 
+def get_bucket_versioning_disabled(name, client):
+    ans = client.get_bucket_versioning(
+        Bucket=name+'hehe'
+    )
+    print 'Get bucket versioning:', ans
+    assert ans.get('Status') is None
+
+
+def put_bucket_versioning_invalid_status_should_fail(name, client):
+    client.put_bucket_versioning(
+        Bucket=name+'hehe',
+        VersioningConfiguration={
+            'Status': 'Disabled'
+        }
+    )
+
+
 def upload_objects_versioning_enabled(name, client):
     client.put_bucket_versioning(
         Bucket=name+'hehe',
@@ -289,16 +389,58 @@ def versioning_suspended_senarios(name, client):
     assert v == 0
     assert d == 0
 
+
+def get_null_version_versioning_suspended(name, client):
+    client.put_bucket_versioning(
+        Bucket=name+'hehe',
+        VersioningConfiguration={
+            'Status': 'Suspended'
+        }
+    )
+    ans = client.put_object(
+        Body=sanity.SMALL_TEST_FILE,
+        Bucket=name+'hehe',
+        Key=name+'_null_version'
+    )
+    print 'Put object to version suspended bucket:', ans
+
+    ans = client.get_object(
+        Bucket=name+'hehe',
+        Key=name+'_null_version',
+        VersionId='null'
+    )
+    body = ans['Body'].read()
+    assert body == sanity.SMALL_TEST_FILE
+    assert ans.get('VersionId') == 'null'
+
+    client.delete_object(
+        Bucket=name+'hehe',
+        Key=name+'_null_version',
+        VersionId='null'
+    )
+    f, v, d = count_files_and_versions(name, client)
+    print 'After deleting null version object:', f, v, d
+    assert f == 0
+    assert v == 0
+    assert d == 0
+
 # =====================================================
 
 TESTS = [
     sanity.create_bucket,
+    get_bucket_versioning_disabled,
+    put_bucket_versioning_invalid_status_should_fail,
     upload_objects_versioning_disabled,
     upload_objects_versioning_enabled,
     delete_object_versioning_enabled,
     sanity.delete_bucket,
     sanity.create_bucket,
     versioning_suspended_senarios,
+    get_null_version_versioning_suspended,
+    sanity.delete_bucket,
+    sanity.create_bucket,
+    object_lock_senarios,
+    suspend_versioning_object_lock_should_fail,
     sanity.delete_bucket,
 ]
 
@@ -8,6 +8,7 @@ import (
     "os"
     "flag"
     "encoding/json"
+    "bytes"
 )
 
 var client = &http.Client{}
@@ -20,11 +21,14 @@ type Config struct {
 var config Config
 func printHelp() {
     fmt.Println("Usage: admin <commands> [options...] ")
-    fmt.Println("Commands: usage|bucket|object|user|cachehit")
+    fmt.Println("Commands: usage|bucket|object|user|cachehit|rebalance")
     fmt.Println("Options:")
     fmt.Println(" -b, --bucket   Specify bucket to operate")
     fmt.Println(" -u, --uid      Specify user name to operate")
     fmt.Println(" -o, --object   Specify object to operate")
+    fmt.Println(" -p, --prefix   Specify object prefix to rebalance")
+    fmt.Println(" -c, --cluster  Specify target ceph cluster(fsid) to rebalance")
+    fmt.Println(" -l, --pool     Specify target pool(small|big) to rebalance")
 }
 
 func isParaEmpty(p string) bool {
@@ -208,6 +212,46 @@ func getCacheHit() {
 
 }
 
+func rebalance(bucket, prefix, cluster, pool string) {
+    if isParaEmpty(bucket) || isParaEmpty(cluster) || isParaEmpty(pool) {
+        return
+    }
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+    })
+
+    tokenString, err := token.SignedString([]byte(config.AdminKey))
+
+    if(err==nil) {
+        //go use token
+        fmt.Printf("\nHS256 = %v\n",tokenString)
+    } else {
+        fmt.Println("internal error", err)
+        return
+    }
+
+    task, _ := json.Marshal(map[string]string{
+        "bucket": bucket,
+        "prefix": prefix,
+        "targetCluster": cluster,
+        "targetPool": pool,
+    })
+    url := config.RequestUrl + "/admin/rebalance"
+    request, _ := http.NewRequest("POST", url, bytes.NewReader(task))
+    request.Header.Set("Authorization", "Bearer " + tokenString)
+    response, err := client.Do(request)
+    if err != nil {
+        fmt.Println("send request failed",err)
+        return
+    }
+    defer response.Body.Close()
+    body, _ := ioutil.ReadAll(response.Body)
+    if response.StatusCode != http.StatusAccepted {
+        fmt.Println("rebalance failed as status != 202", response.StatusCode, string(body))
+        return
+    }
+    fmt.Println(string(body))
+}
+
 func main() {
     f, err := os.Open("./admin.json")
     if err != nil {
@@ -227,6 +271,9 @@ func main() {
     bucket := mySet.String("b", "", "bucket name")
     uid := mySet.String("u", "", "user name")
     object := mySet.String("o", "", "object name")
+    prefix := mySet.String("p", "", "object prefix")
+    cluster := mySet.String("c", "", "target ceph cluster")
+    pool := mySet.String("l", "", "target pool")
     mySet.Parse(os.Args[2:])
     fmt.Println("command:", os.Args[1], "bucket:", *bucket,"user:", *uid, "object:", *object)
     switch os.Args[1] {
@@ -240,6 +287,8 @@ func main() {
         getObjectInfo(*bucket, *object)
     case "cachehit":
         getCacheHit()
+    case "rebalance":
+        rebalance(*bucket, *prefix, *cluster, *pool)
     default:
         printHelp()
         return
@@ -7,6 +7,7 @@ import (
 	"github.com/journeymidnight/yig/meta"
 	"github.com/journeymidnight/yig/meta/types"
 	"github.com/journeymidnight/yig/storage"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"strings"
@@ -75,9 +76,34 @@ func deleteFromCeph(index int) {
 	}
 }
 
+// Rowkey to resume scanning garbage collection table from, garbages before
+// it that were queued but not removed when the tool stopped are scanned
+// again once the scan wraps around
+func loadCheckpoint() string {
+	checkpoint, err := ioutil.ReadFile(helper.CONFIG.GcCheckpointPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			helper.Logger.Println(5, "Failed to read checkpoint, scan from beginning:", err)
+		}
+		return ""
+	}
+	return string(checkpoint)
+}
+
+func saveCheckpoint(startRowKey string) {
+	path := helper.CONFIG.GcCheckpointPath
+	err := ioutil.WriteFile(path+".tmp", []byte(startRowKey), 0644)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		helper.Logger.Println(5, "Failed to save checkpoint", startRowKey, ":", err)
+	}
+}
+
 func removeDeleted() {
 	time.Sleep(time.Duration(1000) * time.Millisecond)
-	var startRowKey string
+	startRowKey := loadCheckpoint()
 	var garbages []types.GarbageCollection
 	var err error
 	for {
@@ -102,12 +128,14 @@ func removeDeleted() {
 		if len(garbages) == 0 {
 			time.Sleep(time.Duration(10000) * time.Millisecond)
 			startRowKey = ""
+			saveCheckpoint(startRowKey)
 			continue
 		} else if len(garbages) == 1 {
-			for _, garbage := range garbages {
-				taskQ <- garbage
-			}
-			startRowKey = ""
+			taskQ <- garbages[0]
+			// scan from the smallest rowkey after it, so it won't be
+			// queued twice
+			startRowKey = garbages[0].Rowkey + "\x00"
+			saveCheckpoint(startRowKey)
 			time.Sleep(time.Duration(5000) * time.Millisecond)
 			continue
 		} else {
@@ -116,6 +144,7 @@ func removeDeleted() {
 			for _, garbage := range garbages {
 				taskQ <- garbage
 			}
+			saveCheckpoint(startRowKey)
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/journeymidnight/yig/api/datatype"
 	"github.com/journeymidnight/yig/helper"
@@ -11,8 +12,6 @@ import (
 	"github.com/journeymidnight/yig/storage"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -71,6 +70,33 @@ func checkIfExpiration(updateTime time.Time, days int) bool {
 	}
 }
 
+// Age of an object in days, or in seconds if LcDebug is set
+func ageOf(modified time.Time) int {
+	if helper.CONFIG.LcDebug {
+		return int(time.Since(modified).Seconds())
+	}
+	return int(time.Since(modified).Hours() / 24)
+}
+
+// Move object to the storage class of the latest transition of `rule` due,
+// if it's not there yet
+func transitionIfDue(object *types.Object, rule datatype.LcRule) {
+	storageClass, ok := rule.TransitionOf(object.StorageClass, ageOf(object.LastModifiedTime))
+	if !ok {
+		return
+	}
+	moved, err := yig.TransitionObject(object.BucketName, object.Name, object.VersionId, storageClass)
+	if err != nil {
+		helper.Logger.Println(5, "[FAILED]", object.BucketName, object.Name, object.VersionId, storageClass, err)
+		fmt.Println("[FAILED]", object.BucketName, object.Name, object.VersionId, storageClass, err)
+		return
+	}
+	if moved {
+		helper.Logger.Println(5, "[TRANSITIONED]", object.BucketName, object.Name, object.VersionId, storageClass)
+		fmt.Println("[TRANSITIONED]", object.BucketName, object.Name, object.VersionId, storageClass)
+	}
+}
+
 // If a rule has an empty prifex ,the days in it will be consider as a default days for all objects that not specified in
 // other rules. For this reason, we have two conditions to check if a object has expired and should be deleted
 //  if defaultConfig == true
@@ -84,23 +110,31 @@ func checkIfExpiration(updateTime time.Time, days int) bool {
 //  if defaultConfig == false
 //                 for each rule get objects by prefix
 //  iterator rules ----------------------------------> loop objects-------->delete object if expired
+//
+// Objects not expired are moved to the storage class of the latest transition
+// due in the same rule
 func retrieveBucket(lc types.LifeCycle) error {
 	defaultConfig := false
-	defaultDays := 0
 	bucket, err := yig.MetaStorage.GetBucket(lc.BucketName, false)
 	if err != nil {
 		return err
 	}
-	rules := bucket.LC.Rule
+	// expired objects are removed on behalf of the bucket owner
+	owner := iam.Credential{UserId: bucket.OwnerId}
+	var rules []datatype.LcRule
+	for _, rule := range bucket.LC.Rule {
+		// objects are not tagged yet, rules with tag conditions apply to none
+		// of them
+		if rule.MatchesTags(nil) {
+			rules = append(rules, rule)
+		}
+	}
 	for _, rule := range rules {
 		if rule.Prefix == "" {
 			defaultConfig = true
-			defaultDays, err = strconv.Atoi(rule.Expiration)
-			if err != nil {
-				return err
-			}
 		}
 	}
+	bucketLc := datatype.Lc{Rule: rules}
 	var request datatype.ListObjectsRequest
 	request.Versioned = true
 	request.MaxKeys = 1000
@@ -112,31 +146,12 @@ func retrieveBucket(lc types.LifeCycle) error {
 			}
 
 			for _, object := range retObjects {
-				prefixMatch := false
-				matchDays := 0
-				for _, rule := range rules {
-					if rule.Prefix == "" {
-						continue
-					}
-					if strings.HasPrefix(object.Name, rule.Prefix) == false {
-						continue
-					}
-					prefixMatch = true
-					matchDays, err = strconv.Atoi(rule.Expiration)
-					if err != nil {
-						return err
-					}
-				}
-				days := 0
-				if prefixMatch == true {
-					days = matchDays
-				} else {
-					days = defaultDays
-				}
+				// the rule with the prefix matched, or the default one
+				rule, _ := bucketLc.RuleOf(object.Name, nil)
+				days, expires := rule.ExpirationDays()
 				helper.Debugln("inteval:", time.Since(object.LastModifiedTime).Seconds())
-				if checkIfExpiration(object.LastModifiedTime, days) {
-					helper.Debugln("come here")
-					_, err = yig.DeleteObject(object.BucketName, object.Name, object.VersionId, iam.Credential{})
+				if (expires && checkIfExpiration(object.LastModifiedTime, days)) || object.IsExpired(time.Now()) {
+					_, err = yig.DeleteObject(context.Background(), object.BucketName, object.Name, object.VersionId, owner, false)
 					if err != nil {
 						helper.Logger.Println(5, "[FAILED]", object.BucketName, object.Name, object.VersionId, err)
 						fmt.Println("[FAILED]", object.BucketName, object.Name, object.VersionId, err)
@@ -144,7 +159,9 @@ func retrieveBucket(lc types.LifeCycle) error {
 					}
 					helper.Logger.Println(5, "[DELETED]", object.BucketName, object.Name, object.VersionId)
 					fmt.Println("[DELETED]", object.BucketName, object.Name, object.VersionId)
+					continue
 				}
+				transitionIfDue(object, rule)
 			}
 			if truncated == true {
 				request.KeyMarker = nextMarker
@@ -158,10 +175,7 @@ func retrieveBucket(lc types.LifeCycle) error {
 			if rule.Prefix == "" {
 				continue
 			}
-			days, _ := strconv.Atoi(rule.Expiration)
-			if err != nil {
-				return err
-			}
+			days, expires := rule.ExpirationDays()
 			request.Prefix = rule.Prefix
 			for {
 
@@ -170,8 +184,8 @@ func retrieveBucket(lc types.LifeCycle) error {
 					return err
 				}
 				for _, object := range retObjects {
-					if checkIfExpiration(object.LastModifiedTime, days) {
-						_, err = yig.DeleteObject(object.BucketName, object.Name, object.VersionId, iam.Credential{})
+					if expires && checkIfExpiration(object.LastModifiedTime, days) {
+						_, err = yig.DeleteObject(context.Background(), object.BucketName, object.Name, object.VersionId, owner, false)
 						if err != nil {
 							logger.Println(5, "failed to delete object:", object.Name, object.BucketName)
 							helper.Logger.Println(5, "[FAILED]", object.BucketName, object.Name, object.VersionId, err)
@@ -180,7 +194,9 @@ func retrieveBucket(lc types.LifeCycle) error {
 						}
 						helper.Logger.Println(5, "[DELETED]", object.BucketName, object.Name, object.VersionId)
 						fmt.Println("[DELETED]", object.BucketName, object.Name, object.VersionId)
+						continue
 					}
+					transitionIfDue(object, rule)
 				}
 				if truncated == true {
 					request.KeyMarker = nextMarker
@@ -191,7 +207,41 @@ func retrieveBucket(lc types.LifeCycle) error {
 
 			}
 		}
+		// objects out of rules' prefixes could also expire due to TTL
+		return removeTtlExpiredObjects(bucket.Name, owner)
+	}
+	return nil
+}
 
+// Remove objects whose TTL set by "x-yig-ttl" header expired
+func removeTtlExpiredObjects(bucketName string, owner iam.Credential) error {
+	var request datatype.ListObjectsRequest
+	request.Versioned = true
+	request.MaxKeys = 1000
+	for {
+		retObjects, _, truncated, nextMarker, nextVerIdMarker, err := yig.ListObjectsInternal(bucketName, request)
+		if err != nil {
+			return err
+		}
+		for _, object := range retObjects {
+			if !object.IsExpired(time.Now()) {
+				continue
+			}
+			_, err = yig.DeleteObject(context.Background(), object.BucketName, object.Name, object.VersionId, owner, false)
+			if err != nil {
+				helper.Logger.Println(5, "[FAILED]", object.BucketName, object.Name, object.VersionId, err)
+				fmt.Println("[FAILED]", object.BucketName, object.Name, object.VersionId, err)
+				continue
+			}
+			helper.Logger.Println(5, "[DELETED]", object.BucketName, object.Name, object.VersionId)
+			fmt.Println("[DELETED]", object.BucketName, object.Name, object.VersionId)
+		}
+		if truncated == true {
+			request.KeyMarker = nextMarker
+			request.VersionIdMarker = nextVerIdMarker
+		} else {
+			break
+		}
 	}
 	return nil
 }